@@ -0,0 +1,196 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cloudspannerecosystem/harbourbridge/conversion"
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+)
+
+// assess runs a schema-only assessment of each source listed in
+// listFile (one per line; blank lines and lines starting with '#' are
+// ignored). A source is either a PostgreSQL connection string (URL or
+// key=value form) or the name of a pg_dump file. We write a report
+// for each source, plus an aggregate report with a combined roll-up.
+// Failure to assess one source does not stop assessment of the rest.
+// No Spanner resources are used.
+func assess(listFile, outputFilePrefix string, now time.Time, out *os.File) error {
+	sources, err := readSourceList(listFile)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no sources found in %s", listFile)
+	}
+	var l []internal.SchemaAssessment
+	for i, src := range sources {
+		name := redactSource(src)
+		fmt.Fprintf(out, "Assessing %s\n", name)
+		conv, source, err := assessSchema(src)
+		if err != nil {
+			fmt.Fprintf(out, "  Failed: %v\n", err)
+			l = append(l, internal.SchemaAssessment{Source: name, Err: err})
+			continue
+		}
+		a := internal.AssessSchema(name, conv)
+		reportFileName := fmt.Sprintf("%s%d-%s.%s", outputFilePrefix, i+1, sourceFileName(src), conversion.ReportFile)
+		f, err := os.Create(reportFileName)
+		if err != nil {
+			fmt.Fprintf(out, "  Can't write report file %s: %v\n", reportFileName, err)
+		} else {
+			w := bufio.NewWriter(f)
+			w.WriteString(getAssessmentBanner(now, name))
+			internal.GenerateReport(source, conv, w, nil)
+			w.Flush()
+			f.Close()
+			fmt.Fprintf(out, "  Schema rating: %s. See file '%s' for details.\n", a.Rating, reportFileName)
+		}
+		l = append(l, a)
+	}
+	aggregateFileName := outputFilePrefix + "aggregate." + conversion.ReportFile
+	f, err := os.Create(aggregateFileName)
+	if err != nil {
+		return fmt.Errorf("can't create aggregate report file %s: %w", aggregateFileName, err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	internal.GenerateAggregateReport(l, w)
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("can't write aggregate report file %s: %w", aggregateFileName, err)
+	}
+	fmt.Fprintf(out, "Assessed %d sources. See file '%s' for the aggregate assessment.\n", len(sources), aggregateFileName)
+	return nil
+}
+
+func readSourceList(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't open source list: %w", err)
+	}
+	defer f.Close()
+	var l []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l = append(l, line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("can't read source list: %w", err)
+	}
+	return l, nil
+}
+
+// isConnectionString returns true if src looks like a PostgreSQL
+// connection string rather than a file name.
+func isConnectionString(src string) bool {
+	return strings.HasPrefix(src, "postgres://") || strings.HasPrefix(src, "postgresql://") ||
+		strings.Contains(src, "dbname=") || strings.Contains(src, "host=")
+}
+
+// assessSchema runs schema conversion for a single source. Returns
+// the Conv and a description of the source. Panics during
+// conversion are reported as errors, so that one bad source doesn't
+// abort the whole assessment.
+func assessSchema(src string) (conv *internal.Conv, source internal.Source, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error during schema conversion: %v", r)
+		}
+	}()
+	conv = internal.MakeConv()
+	if isConnectionString(src) {
+		db, err := sql.Open(POSTGRES, src)
+		if err != nil {
+			return nil, internal.PostgresSource, err
+		}
+		defer db.Close()
+		if err := internal.ProcessInfoSchema(conv, db); err != nil {
+			return nil, internal.PostgresSource, err
+		}
+		return conv, internal.PostgresSource, nil
+	}
+	f, err := conversion.OpenDump(context.Background(), src)
+	if err != nil {
+		return nil, internal.PgDumpSource, err
+	}
+	defer f.Close()
+	conv.SetSchemaMode()
+	conv.SetDataSink(nil)
+	if err := internal.ProcessPgDump(conv, internal.NewReader(bufio.NewReader(f), nil)); err != nil {
+		return nil, internal.PgDumpSource, err
+	}
+	return conv, internal.PgDumpSource, nil
+}
+
+var passwordRE = regexp.MustCompile(`password=\S+`)
+
+// redactSource removes passwords from connection strings, so that
+// sources can be safely printed in reports.
+func redactSource(src string) string {
+	if !isConnectionString(src) {
+		return src
+	}
+	if u, err := url.Parse(src); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return passwordRE.ReplaceAllString(src, "password=xxxxx")
+}
+
+var (
+	unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+	dbnameRE        = regexp.MustCompile(`dbname=(\S+)`)
+)
+
+// sourceFileName returns a short name for src, suitable for use in
+// file names: the database name for connection strings, and the base
+// name (without extension) for files.
+func sourceFileName(src string) string {
+	name := src
+	if isConnectionString(src) {
+		if u, err := url.Parse(src); err == nil && u.Scheme != "" {
+			name = strings.TrimPrefix(u.Path, "/")
+		} else if m := dbnameRE.FindStringSubmatch(src); m != nil {
+			name = m[1]
+		}
+	} else {
+		name = strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	}
+	name = unsafeFileChars.ReplaceAllString(name, "_")
+	if name == "" {
+		name = "db"
+	}
+	return name
+}
+
+func getAssessmentBanner(now time.Time, source string) string {
+	return fmt.Sprintf("Generated at %s for assessment of %s\n\n", now.Format("2006-01-02 15:04:05"), source)
+}
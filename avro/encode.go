@@ -0,0 +1,221 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/civil"
+	sp "cloud.google.com/go/spanner"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// encodeRow appends the Avro binary encoding of a row of table ct to
+// buf. Columns missing from cols are NULL. Returns an error if a value
+// doesn't match its column's type, or a NOT NULL column is missing.
+func encodeRow(buf *bytes.Buffer, ct ddl.CreateTable, cols []string, vals []interface{}) error {
+	if len(cols) != len(vals) {
+		return fmt.Errorf("got %d columns but %d values", len(cols), len(vals))
+	}
+	for _, cn := range ct.ColNames {
+		cd := ct.ColDefs[cn]
+		i := indexOf(cols, cn)
+		if i < 0 {
+			if cd.NotNull {
+				return fmt.Errorf("no value for NOT NULL column %s", cn)
+			}
+			writeLong(buf, 0) // Union branch null.
+			continue
+		}
+		if !cd.NotNull {
+			writeLong(buf, 1)
+		}
+		var err error
+		if cd.IsArray {
+			err = encodeArray(buf, cd.T, vals[i])
+		} else {
+			err = encodeScalar(buf, cd.T, vals[i])
+		}
+		if err != nil {
+			return fmt.Errorf("column %s: %w", cn, err)
+		}
+	}
+	return nil
+}
+
+func indexOf(l []string, s string) int {
+	for i, x := range l {
+		if x == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// encodeArray encodes array v (a slice of Spanner client library values
+// e.g. []spanner.NullInt64) as a single block of nullable items.
+func encodeArray(buf *bytes.Buffer, t ddl.ScalarType, v interface{}) error {
+	a := reflect.ValueOf(v)
+	if a.Kind() != reflect.Slice {
+		return fmt.Errorf("can't write %T as an Avro array", v)
+	}
+	if n := a.Len(); n > 0 {
+		writeLong(buf, int64(n))
+		for i := 0; i < n; i++ {
+			x, ok := nullableValue(a.Index(i).Interface())
+			if !ok {
+				writeLong(buf, 0)
+				continue
+			}
+			writeLong(buf, 1)
+			if err := encodeScalar(buf, t, x); err != nil {
+				return err
+			}
+		}
+	}
+	writeLong(buf, 0) // End of array.
+	return nil
+}
+
+// nullableValue returns the value of array element x, and false if it
+// is NULL.
+func nullableValue(x interface{}) (interface{}, bool) {
+	switch x := x.(type) {
+	case sp.NullBool:
+		return x.Bool, x.Valid
+	case sp.NullInt64:
+		return x.Int64, x.Valid
+	case sp.NullFloat64:
+		return x.Float64, x.Valid
+	case sp.NullString:
+		return x.StringVal, x.Valid
+	case sp.NullDate:
+		return x.Date, x.Valid
+	case sp.NullTime:
+		return x.Time, x.Valid
+	case []byte: // Elements of BYTES arrays.
+		return x, x != nil
+	}
+	return x, x != nil
+}
+
+func encodeScalar(buf *bytes.Buffer, t ddl.ScalarType, v interface{}) error {
+	var ok bool
+	switch t.(type) {
+	case ddl.Bool:
+		var b bool
+		if b, ok = v.(bool); ok {
+			if b {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+		}
+	case ddl.Int64:
+		var i int64
+		if i, ok = v.(int64); ok {
+			writeLong(buf, i)
+		}
+	case ddl.Float64:
+		var f float64
+		if f, ok = v.(float64); ok {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+			buf.Write(b[:])
+		}
+	case ddl.String:
+		var s string
+		if s, ok = v.(string); ok {
+			writeBytes(buf, []byte(s))
+		}
+	case ddl.Bytes:
+		var b []byte
+		if b, ok = v.([]byte); ok {
+			writeBytes(buf, b)
+		}
+	case ddl.Date:
+		var d civil.Date
+		if d, ok = v.(civil.Date); ok {
+			writeLong(buf, int64(d.DaysSince(civil.Date{Year: 1970, Month: time.January, Day: 1})))
+		}
+	case ddl.Timestamp:
+		var ts time.Time
+		if ts, ok = v.(time.Time); ok {
+			writeLong(buf, ts.Unix()*1000000+int64(ts.Nanosecond()/1000))
+		}
+	case ddl.Numeric:
+		// Numeric values are strings (see convNumeric in
+		// internal/data.go).
+		var s string
+		if s, ok = v.(string); ok {
+			b, err := decimalBytes(s)
+			if err != nil {
+				return err
+			}
+			writeBytes(buf, b)
+		}
+	default:
+		return fmt.Errorf("no Avro type for Spanner type %s", t.PrintScalarType())
+	}
+	if !ok {
+		return fmt.Errorf("can't write %T as Avro for Spanner type %s", v, t.PrintScalarType())
+	}
+	return nil
+}
+
+// decimalBytes returns the Avro decimal encoding of numeric value s:
+// the two's-complement big-endian bytes of s*10^numericScale.
+func decimalBytes(s string) ([]byte, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid numeric %q", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(numericScale), nil)))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("numeric %s has more than %d digits after the decimal point", s, numericScale)
+	}
+	x := r.Num()
+	if x.Sign() >= 0 {
+		b := x.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b, nil
+	}
+	// Negative: add 2^(8n), where n is the fewest bytes that hold x
+	// with a sign bit i.e. -x-1 fits in 8n-1 bits.
+	n := uint(new(big.Int).Not(x).BitLen()/8 + 1)
+	return new(big.Int).Add(x, new(big.Int).Lsh(big.NewInt(1), 8*n)).Bytes(), nil
+}
+
+// writeLong writes x as a zig-zag encoded variable-length integer.
+func writeLong(buf *bytes.Buffer, x int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], x)])
+}
+
+// writeBytes writes b prefixed by its length (the encoding of both
+// bytes and strings).
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeLong(buf, int64(len(b)))
+	buf.Write(b)
+}
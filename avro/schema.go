@@ -0,0 +1,130 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package avro writes converted data to Avro object container files
+// (see https://avro.apache.org/docs/1.8.2/spec.html), one per Spanner
+// table, as an alternative to writing it to Spanner. The files can be
+// bulk-imported into Spanner e.g. with Dataflow, which is faster than
+// writing rows with mutations for very large migrations.
+//
+// Avro types are derived from the Spanner schema (see Schema). Values
+// are the ones HarbourBridge's data conversion gives to the Spanner
+// client library, so the same rows can be written to either.
+package avro
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// Schema returns the Avro schema (as JSON) for the rows of table ct.
+// It is a record with a field for each column, in column order:
+//
+//	BOOL      -> boolean
+//	INT64     -> long
+//	FLOAT64   -> double
+//	STRING    -> string
+//	BYTES     -> bytes
+//	DATE      -> int (logical type date)
+//	TIMESTAMP -> long (logical type timestamp-micros)
+//	NUMERIC   -> bytes (logical type decimal, precision 38, scale 9)
+//	ARRAY<T>  -> array of union of null and T
+//
+// Columns that aren't NOT NULL are unions of null and their type.
+// Each field also has a sqlType property giving its Spanner type.
+func Schema(ct ddl.CreateTable) ([]byte, error) {
+	r := recordSchema{Type: "record", Name: ct.Name}
+	for _, cn := range ct.ColNames {
+		cd := ct.ColDefs[cn]
+		t, err := avroType(cd.T)
+		if err != nil {
+			return nil, fmt.Errorf("column %s of table %s: %w", cn, ct.Name, err)
+		}
+		if cd.IsArray {
+			t = arraySchema{Type: "array", Items: []interface{}{"null", t}}
+		}
+		f := fieldSchema{Name: cn, Type: t, SQLType: sqlType(cd)}
+		if !cd.NotNull {
+			f.Type = []interface{}{"null", t}
+			f.Default = &jsonNull
+		}
+		r.Fields = append(r.Fields, f)
+	}
+	return json.Marshal(r)
+}
+
+type recordSchema struct {
+	Type   string        `json:"type"`
+	Name   string        `json:"name"`
+	Fields []fieldSchema `json:"fields"`
+}
+
+type fieldSchema struct {
+	Name    string           `json:"name"`
+	Type    interface{}      `json:"type"`
+	Default *json.RawMessage `json:"default,omitempty"` // Null for nullable columns.
+	SQLType string           `json:"sqlType"`
+}
+
+type arraySchema struct {
+	Type  string        `json:"type"`
+	Items []interface{} `json:"items"`
+}
+
+type logicalSchema struct {
+	Type        string `json:"type"`
+	LogicalType string `json:"logicalType"`
+	Precision   int    `json:"precision,omitempty"`
+	Scale       int    `json:"scale,omitempty"`
+}
+
+var jsonNull = json.RawMessage("null")
+
+// Precision and scale of Spanner NUMERIC.
+const (
+	numericPrecision = 38
+	numericScale     = 9
+)
+
+// avroType returns the Avro type for Spanner type t.
+func avroType(t ddl.ScalarType) (interface{}, error) {
+	switch t.(type) {
+	case ddl.Bool:
+		return "boolean", nil
+	case ddl.Int64:
+		return "long", nil
+	case ddl.Float64:
+		return "double", nil
+	case ddl.String:
+		return "string", nil
+	case ddl.Bytes:
+		return "bytes", nil
+	case ddl.Date:
+		return logicalSchema{Type: "int", LogicalType: "date"}, nil
+	case ddl.Timestamp:
+		return logicalSchema{Type: "long", LogicalType: "timestamp-micros"}, nil
+	case ddl.Numeric:
+		return logicalSchema{Type: "bytes", LogicalType: "decimal", Precision: numericPrecision, Scale: numericScale}, nil
+	}
+	return nil, fmt.Errorf("no Avro type for Spanner type %s", t.PrintScalarType())
+}
+
+func sqlType(cd ddl.ColumnDef) string {
+	if cd.IsArray {
+		return "ARRAY<" + cd.T.PrintScalarType() + ">"
+	}
+	return cd.T.PrintScalarType()
+}
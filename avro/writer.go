@@ -0,0 +1,239 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avro
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// blockBytes is the (uncompressed) size at which a block of rows is
+// written out. Bigger blocks compress better, but use more memory.
+const blockBytes = 1 << 20
+
+// maxBadRows is the number of bad rows kept for SampleBadRows.
+const maxBadRows = 100
+
+// WriterConfig specifies parameters for configuring Writer.
+type WriterConfig struct {
+	Dir    string            // Directory for the Avro files. It must exist.
+	Tables []ddl.CreateTable // Tables to write. Each gets a file, even if it has no rows.
+
+	// If non-nil, OnDrop is called for each row that can't be written
+	// (e.g. because a value doesn't match its column's type).
+	OnDrop func(table string, cols []string, vals []interface{}, err error)
+	// If non-nil, OnWrite is called with the number of rows in each
+	// block of rows written.
+	OnWrite func(rows int64)
+}
+
+// Writer writes rows of data (added via AddRow) to Avro object
+// container files, one per table, named after the table e.g.
+// "Singers.avro". Rows are written in deflate-compressed blocks. Rows
+// that can't be encoded for their table's schema are dropped (see
+// DroppedRowsByTable). Writer is not threadsafe.
+type Writer struct {
+	files   map[string]*tableFile
+	onDrop  func(table string, cols []string, vals []interface{}, err error)
+	onWrite func(rows int64)
+	row     bytes.Buffer     // Scratch space for encoding a row.
+	dropped map[string]int64 // Count of dropped rows, broken down by table.
+	badRows []string         // A sample of dropped rows.
+	err     error            // First error writing a file.
+}
+
+// tableFile is the Avro file for a table.
+type tableFile struct {
+	ct    ddl.CreateTable
+	path  string
+	f     *os.File
+	w     *bufio.Writer
+	sync  [16]byte     // Sync marker written after each block.
+	block bytes.Buffer // Encoded rows not yet written.
+	rows  int64        // Rows in block.
+}
+
+// NewWriter creates an Avro file for each of config.Tables, and returns
+// a Writer for adding rows to them. Existing files are overwritten.
+func NewWriter(config WriterConfig) (*Writer, error) {
+	w := &Writer{
+		files:   make(map[string]*tableFile),
+		onDrop:  config.OnDrop,
+		onWrite: config.OnWrite,
+		dropped: make(map[string]int64),
+	}
+	for _, ct := range config.Tables {
+		tf, err := createFile(filepath.Join(config.Dir, ct.Name+".avro"), ct)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.files[ct.Name] = tf
+	}
+	return w, nil
+}
+
+func createFile(path string, ct ddl.CreateTable) (*tableFile, error) {
+	schema, err := Schema(ct)
+	if err != nil {
+		return nil, err
+	}
+	tf := &tableFile{ct: ct, path: path}
+	if _, err := rand.Read(tf.sync[:]); err != nil {
+		return nil, err
+	}
+	tf.f, err = os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	tf.w = bufio.NewWriter(tf.f)
+	// The header: magic, then file metadata (a map of bytes values),
+	// then the sync marker.
+	var h bytes.Buffer
+	h.WriteString("Obj\x01")
+	writeLong(&h, 2)
+	writeBytes(&h, []byte("avro.schema"))
+	writeBytes(&h, schema)
+	writeBytes(&h, []byte("avro.codec"))
+	writeBytes(&h, []byte("deflate"))
+	writeLong(&h, 0)
+	h.Write(tf.sync[:])
+	if _, err := tf.w.Write(h.Bytes()); err != nil {
+		tf.f.Close()
+		return nil, err
+	}
+	return tf, nil
+}
+
+// AddRow adds a row of data for table, with values vals for columns
+// cols (columns not in cols are NULL). Values are those given to the
+// Spanner client library e.g. int64 for INT64 and civil.Date for DATE.
+// Rows are buffered: they are written once a block is full, or on
+// Close.
+func (w *Writer) AddRow(table string, cols []string, vals []interface{}) {
+	tf, ok := w.files[table]
+	if !ok {
+		w.drop(table, cols, vals, fmt.Errorf("no Avro file for table %s", table))
+		return
+	}
+	if w.err != nil {
+		w.drop(table, cols, vals, w.err)
+		return
+	}
+	w.row.Reset()
+	if err := encodeRow(&w.row, tf.ct, cols, vals); err != nil {
+		w.drop(table, cols, vals, err)
+		return
+	}
+	tf.block.Write(w.row.Bytes())
+	tf.rows++
+	if tf.block.Len() >= blockBytes {
+		w.writeBlock(tf)
+	}
+}
+
+// Close writes any buffered rows and closes the files. It returns the
+// first error writing a file (rows added after such an error are
+// dropped).
+func (w *Writer) Close() error {
+	for _, tf := range w.files {
+		if tf.rows > 0 {
+			w.writeBlock(tf)
+		}
+		if err := tf.w.Flush(); err != nil && w.err == nil {
+			w.err = fmt.Errorf("can't write %s: %w", tf.path, err)
+		}
+		if err := tf.f.Close(); err != nil && w.err == nil {
+			w.err = fmt.Errorf("can't write %s: %w", tf.path, err)
+		}
+	}
+	return w.err
+}
+
+// Paths returns the paths of the Avro files, keyed by table.
+func (w *Writer) Paths() map[string]string {
+	m := make(map[string]string)
+	for t, tf := range w.files {
+		m[t] = tf.path
+	}
+	return m
+}
+
+// DroppedRowsByTable returns a map of tables to counts of dropped rows.
+// Dropped rows are rows that were not written to the Avro files.
+func (w *Writer) DroppedRowsByTable() map[string]int64 {
+	m := make(map[string]int64)
+	for t, n := range w.dropped {
+		m[t] = n
+	}
+	return m
+}
+
+// SampleBadRows returns a string-formatted list of sample rows that
+// were dropped. Returns at most n rows.
+func (w *Writer) SampleBadRows(n int) []string {
+	if n > len(w.badRows) {
+		n = len(w.badRows)
+	}
+	return append([]string{}, w.badRows[:n]...)
+}
+
+func (w *Writer) drop(table string, cols []string, vals []interface{}, err error) {
+	w.dropped[table]++
+	if len(w.badRows) < maxBadRows {
+		w.badRows = append(w.badRows, fmt.Sprintf("table=%s cols=%v data=%v error=%v", table, cols, vals, err))
+	}
+	if w.onDrop != nil {
+		w.onDrop(table, cols, vals, err)
+	}
+}
+
+// writeBlock writes the buffered rows of tf as a block: the number of
+// rows, the size of the compressed rows, the compressed rows and the
+// sync marker.
+func (w *Writer) writeBlock(tf *tableFile) {
+	if w.err != nil {
+		w.dropped[tf.ct.Name] += tf.rows
+		tf.block.Reset()
+		tf.rows = 0
+		return
+	}
+	var data bytes.Buffer
+	fw, _ := flate.NewWriter(&data, flate.DefaultCompression) // Only fails for bad levels.
+	fw.Write(tf.block.Bytes())
+	fw.Close()
+	var h bytes.Buffer
+	writeLong(&h, tf.rows)
+	writeLong(&h, int64(data.Len()))
+	tf.w.Write(h.Bytes())
+	tf.w.Write(data.Bytes())
+	if _, err := tf.w.Write(tf.sync[:]); err != nil {
+		// bufio.Writer errors are sticky, so this catches errors in
+		// the writes above too.
+		w.err = fmt.Errorf("can't write %s: %w", tf.path, err)
+		w.dropped[tf.ct.Name] += tf.rows
+	} else if w.onWrite != nil {
+		w.onWrite(tf.rows)
+	}
+	tf.block.Reset()
+	tf.rows = 0
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avro
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	sp "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+var testTable = ddl.CreateTable{
+	Name:     "t",
+	ColNames: []string{"id", "name", "score", "born", "ts", "price", "tags", "flag"},
+	ColDefs: map[string]ddl.ColumnDef{
+		"id":    {Name: "id", T: ddl.Int64{}, NotNull: true},
+		"name":  {Name: "name", T: ddl.String{Len: ddl.MaxLength{}}},
+		"score": {Name: "score", T: ddl.Float64{}},
+		"born":  {Name: "born", T: ddl.Date{}},
+		"ts":    {Name: "ts", T: ddl.Timestamp{}},
+		"price": {Name: "price", T: ddl.Numeric{}},
+		"tags":  {Name: "tags", T: ddl.String{Len: ddl.MaxLength{}}, IsArray: true},
+		"flag":  {Name: "flag", T: ddl.Bool{}, NotNull: true},
+	},
+	Pks: []ddl.IndexKey{{Col: "id"}},
+}
+
+func TestSchema(t *testing.T) {
+	ct := ddl.CreateTable{
+		Name:     "t",
+		ColNames: []string{"a", "b", "c"},
+		ColDefs: map[string]ddl.ColumnDef{
+			"a": {Name: "a", T: ddl.Int64{}, NotNull: true},
+			"b": {Name: "b", T: ddl.Timestamp{}},
+			"c": {Name: "c", T: ddl.Numeric{}, IsArray: true, NotNull: true},
+		},
+	}
+	s, err := Schema(ct)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"type": "record", "name": "t", "fields": [
+		{"name": "a", "type": "long", "sqlType": "INT64"},
+		{"name": "b", "type": ["null", {"type": "long", "logicalType": "timestamp-micros"}], "default": null, "sqlType": "TIMESTAMP"},
+		{"name": "c", "type": {"type": "array", "items": ["null", {"type": "bytes", "logicalType": "decimal", "precision": 38, "scale": 9}]}, "sqlType": "ARRAY<NUMERIC>"}
+	]}`, string(s))
+}
+
+func TestDecimalBytes(t *testing.T) {
+	tests := []struct {
+		numeric string
+		want    []byte
+	}{
+		{"0", []byte{0}},
+		{"0.000000001", []byte{1}},
+		{"-0.000000001", []byte{0xff}},
+		{"0.000000128", []byte{0, 0x80}},
+		{"-0.000000128", []byte{0x80}},
+		{"-0.000000129", []byte{0xff, 0x7f}},
+		{"1", []byte{0x3b, 0x9a, 0xca, 0x00}},
+		{"-1", []byte{0xc4, 0x65, 0x36, 0x00}},
+	}
+	for _, tc := range tests {
+		b, err := decimalBytes(tc.numeric)
+		assert.Nil(t, err, tc.numeric)
+		assert.Equal(t, tc.want, b, tc.numeric)
+	}
+	for _, s := range []string{"abc", "0.0000000001"} {
+		_, err := decimalBytes(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avro")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	var written int64
+	var dropped []string
+	w, err := NewWriter(WriterConfig{
+		Dir:     dir,
+		Tables:  []ddl.CreateTable{testTable},
+		OnWrite: func(rows int64) { written += rows },
+		OnDrop: func(table string, cols []string, vals []interface{}, err error) {
+			dropped = append(dropped, err.Error())
+		},
+	})
+	assert.Nil(t, err)
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	w.AddRow("t",
+		[]string{"id", "name", "score", "born", "ts", "price", "tags", "flag"},
+		[]interface{}{int64(-7), "abc", 1.5, civil.Date{Year: 1970, Month: 1, Day: 3}, ts, "-1.5",
+			[]sp.NullString{{StringVal: "x", Valid: true}, {}}, true})
+	w.AddRow("t", []string{"id", "flag"}, []interface{}{int64(8), false})
+	w.AddRow("t", []string{"id", "flag"}, []interface{}{"8", false}) // Bad type.
+	w.AddRow("t", []string{"name"}, []interface{}{"x"})              // Missing NOT NULL column.
+	w.AddRow("u", []string{"id"}, []interface{}{int64(1)})           // Unknown table.
+	assert.Nil(t, w.Close())
+	assert.Equal(t, int64(2), written)
+	assert.Equal(t, 3, len(dropped))
+	assert.Equal(t, map[string]int64{"t": 2, "u": 1}, w.DroppedRowsByTable())
+	assert.Equal(t, 2, len(w.SampleBadRows(2)))
+	assert.Equal(t, 3, len(w.SampleBadRows(10)))
+	path := filepath.Join(dir, "t.avro")
+	assert.Equal(t, map[string]string{"t": path}, w.Paths())
+
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	schema, err := Schema(testTable)
+	assert.Nil(t, err)
+	meta, rows := readFile(t, b)
+	assert.Equal(t, map[string]string{"avro.schema": string(schema), "avro.codec": "deflate"}, meta)
+	assert.Equal(t, 1, len(rows))
+
+	r := bytes.NewReader(rows[0])
+	// First row.
+	assert.Equal(t, int64(-7), readLong(t, r))
+	assert.Equal(t, int64(1), readLong(t, r)) // Non-null.
+	assert.Equal(t, "abc", string(readBytes(t, r)))
+	assert.Equal(t, int64(1), readLong(t, r))
+	var f [8]byte
+	io.ReadFull(r, f[:])
+	assert.Equal(t, 1.5, math.Float64frombits(binary.LittleEndian.Uint64(f[:])))
+	assert.Equal(t, int64(1), readLong(t, r))
+	assert.Equal(t, int64(2), readLong(t, r)) // Days since epoch.
+	assert.Equal(t, int64(1), readLong(t, r))
+	assert.Equal(t, ts.UnixNano()/1000, readLong(t, r))
+	assert.Equal(t, int64(1), readLong(t, r))
+	assert.Equal(t, []byte{0xa6, 0x97, 0xd1, 0x00}, readBytes(t, r)) // -1500000000.
+	assert.Equal(t, int64(1), readLong(t, r))
+	assert.Equal(t, int64(2), readLong(t, r)) // Array block of 2 items.
+	assert.Equal(t, int64(1), readLong(t, r))
+	assert.Equal(t, "x", string(readBytes(t, r)))
+	assert.Equal(t, int64(0), readLong(t, r)) // Null item.
+	assert.Equal(t, int64(0), readLong(t, r)) // End of array.
+	flag, _ := r.ReadByte()
+	assert.Equal(t, byte(1), flag)
+	// Second row: all nullable columns are null.
+	assert.Equal(t, int64(8), readLong(t, r))
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, int64(0), readLong(t, r))
+	}
+	flag, _ = r.ReadByte()
+	assert.Equal(t, byte(0), flag)
+	assert.Equal(t, 0, r.Len())
+}
+
+func TestWriter_Blocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avro")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ct := ddl.CreateTable{
+		Name:     "t",
+		ColNames: []string{"s"},
+		ColDefs:  map[string]ddl.ColumnDef{"s": {Name: "s", T: ddl.String{Len: ddl.MaxLength{}}, NotNull: true}},
+	}
+	var blocks []int64
+	w, err := NewWriter(WriterConfig{Dir: dir, Tables: []ddl.CreateTable{ct}, OnWrite: func(rows int64) { blocks = append(blocks, rows) }})
+	assert.Nil(t, err)
+	s := string(make([]byte, 1000))
+	for i := 0; i < 2000; i++ {
+		w.AddRow("t", []string{"s"}, []interface{}{s})
+	}
+	assert.Nil(t, w.Close())
+	// Blocks are written once they reach blockBytes.
+	n := int64(blockBytes/1002 + 1)
+	assert.Equal(t, []int64{n, 2000 - n}, blocks)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "t.avro"))
+	assert.Nil(t, err)
+	_, rows := readFile(t, b)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, int(n)*1002, len(rows[0]))
+}
+
+// readFile decodes Avro object container file b, and returns its
+// metadata and the (decompressed) data of each block.
+func readFile(t *testing.T, b []byte) (map[string]string, [][]byte) {
+	r := bytes.NewReader(b)
+	magic := make([]byte, 4)
+	io.ReadFull(r, magic)
+	assert.Equal(t, "Obj\x01", string(magic))
+	meta := make(map[string]string)
+	for n := readLong(t, r); n != 0; n = readLong(t, r) {
+		for i := int64(0); i < n; i++ {
+			k := readBytes(t, r)
+			meta[string(k)] = string(readBytes(t, r))
+		}
+	}
+	sync := make([]byte, 16)
+	io.ReadFull(r, sync)
+	var blocks [][]byte
+	for r.Len() > 0 {
+		readLong(t, r) // Row count.
+		data, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(readBytes(t, r))))
+		assert.Nil(t, err)
+		blocks = append(blocks, data)
+		marker := make([]byte, 16)
+		io.ReadFull(r, marker)
+		assert.Equal(t, sync, marker)
+	}
+	return meta, blocks
+}
+
+func readLong(t *testing.T, r *bytes.Reader) int64 {
+	x, err := binary.ReadVarint(r)
+	assert.Nil(t, err)
+	return x
+}
+
+func readBytes(t *testing.T, r *bytes.Reader) []byte {
+	b := make([]byte, readLong(t, r))
+	_, err := io.ReadFull(r, b)
+	assert.Nil(t, err)
+	return b
+}
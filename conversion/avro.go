@@ -0,0 +1,100 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+
+	"github.com/cloudspannerecosystem/harbourbridge/avro"
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// dataWriter is where data conversion writes rows: Spanner (via
+// spanner.BatchWriter) or Avro files (via avroWriter).
+type dataWriter interface {
+	AddRow(table string, cols []string, vals []interface{})
+	DroppedRowsByTable() map[string]int64
+	TooLargeRowsByTable() map[string]int64
+	DroppedRowsByCode() map[string]map[string]int64
+	SampleBadRows(n int) []string
+}
+
+// abandoner is implemented by dataWriters that abandon rows when the
+// conversion is interrupted (see spanner.BatchWriter.Abandon).
+type abandoner interface {
+	AbandonedRowsByTable() map[string]int64
+}
+
+// avroWriter adapts avro.Writer to dataWriter. Avro files have no
+// commit size limit.
+type avroWriter struct {
+	*avro.Writer
+}
+
+func (avroWriter) TooLargeRowsByTable() map[string]int64 { return map[string]int64{} }
+
+// DroppedRowsByCode returns no rows: Avro writes don't fail with gRPC
+// codes, so rows the writer drops are reported as write errors.
+func (avroWriter) DroppedRowsByCode() map[string]map[string]int64 {
+	return map[string]map[string]int64{}
+}
+
+// avroWriter creates the Avro files for the tables of conv's Spanner
+// schema in Options.AvroDir (creating the directory if needed).
+func (r *runner) avroWriter(conv *internal.Conv) (avroWriter, error) {
+	dir := r.opts.AvroDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return avroWriter{}, fmt.Errorf("can't create Avro directory: %w", err)
+	}
+	var tables []ddl.CreateTable
+	for _, t := range conv.SpannerTables() {
+		ct, _ := conv.SpannerTable(t)
+		tables = append(tables, ct)
+	}
+	config := avro.WriterConfig{
+		Dir:    dir,
+		Tables: tables,
+		OnWrite: func(rows int64) {
+			atomic.AddInt64(&r.res.RowsWritten, rows)
+		},
+	}
+	if w := r.badRows; w != nil {
+		config.OnDrop = func(table string, cols []string, vals []interface{}, err error) {
+			w.AddWriteError(table, cols, vals, internal.BadRowWrite, err)
+		}
+	}
+	w, err := avro.NewWriter(config)
+	if err != nil {
+		return avroWriter{}, fmt.Errorf("can't create Avro files: %w", err)
+	}
+	return avroWriter{w}, nil
+}
+
+// addAvroArtifacts records the Avro files written by w, in table order.
+func (r *runner) addAvroArtifacts(w avroWriter) {
+	paths := w.Paths()
+	var tables []string
+	for t := range paths {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for _, t := range tables {
+		r.addArtifact(AvroArtifact, paths[t])
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// compression is the compression format of dump input.
+type compression int
+
+const (
+	uncompressed compression = iota
+	gzipped
+	zstdCompressed
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// detectCompression returns the compression format of the data that
+// starts with header (which should be at least 4 bytes, unless the data
+// is shorter).
+func detectCompression(header []byte) compression {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return gzipped
+	case bytes.HasPrefix(header, zstdMagic):
+		return zstdCompressed
+	}
+	return uncompressed
+}
+
+// errZstd is returned for zstd-compressed input: the Go standard
+// library has no zstd decoder, and we don't vendor one.
+var errZstd = fmt.Errorf("zstd-compressed input is not supported; decompress it first e.g.\n" +
+	"  zstd -dc dump.zst | harbourbridge")
+
+// peekCompression returns the compression format of s, leaving its
+// offset at the start.
+func peekCompression(s io.ReadSeeker) (compression, error) {
+	header := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(s, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return uncompressed, fmt.Errorf("can't read input: %w", err)
+	}
+	if _, err := s.Seek(0, io.SeekStart); err != nil {
+		return uncompressed, fmt.Errorf("can't reset file offset: %w", err)
+	}
+	return detectCompression(header[:n]), nil
+}
+
+// gzipSeeker decompresses a seekable gzip file on the fly. It only
+// supports seeking to the start (which restarts decompression), which
+// is all that the second (data) pass over a dump needs.
+type gzipSeeker struct {
+	f    io.ReadSeeker // Compressed file.
+	z    *gzip.Reader
+	n    int64 // Decompressed bytes read since the last seek.
+	size int64 // Decompressed size (-1 until the end of the data is reached).
+}
+
+func newGzipSeeker(f io.ReadSeeker) (*gzipSeeker, error) {
+	z, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("can't read gzip input: %w", err)
+	}
+	return &gzipSeeker{f: f, z: z, size: -1}, nil
+}
+
+func (g *gzipSeeker) Read(p []byte) (int, error) {
+	n, err := g.z.Read(p)
+	g.n += int64(n)
+	if err == io.EOF {
+		g.size = g.n
+	}
+	return n, err
+}
+
+func (g *gzipSeeker) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, fmt.Errorf("gzip input only supports seeking to the start")
+	}
+	if _, err := g.f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := g.z.Reset(bufio.NewReader(g.f)); err != nil {
+		return 0, fmt.Errorf("can't read gzip input: %w", err)
+	}
+	g.n = 0
+	return 0, nil
+}
+
+func (g *gzipSeeker) Close() error {
+	return g.z.Close()
+}
+
+// gzipSizeEstimate estimates the decompressed size of gzip file f (of
+// compressed size n), for progress reporting. The gzip trailer records
+// the size modulo 2^32 (of the last member only), so for files large
+// enough that gzip's overhead is negligible, we assume that data doesn't
+// get smaller when compressed. Leaves f's offset at the start.
+func gzipSizeEstimate(f io.ReadSeeker, n int64) (int64, error) {
+	if n < 18 { // Smallest possible gzip file.
+		return n, nil
+	}
+	if _, err := f.Seek(-4, io.SeekEnd); err != nil {
+		return 0, fmt.Errorf("can't read gzip trailer: %w", err)
+	}
+	var isize uint32
+	if err := binary.Read(f, binary.LittleEndian, &isize); err != nil {
+		return 0, fmt.Errorf("can't read gzip trailer: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("can't reset file offset: %w", err)
+	}
+	size := int64(isize)
+	for n >= 1<<20 && size < n {
+		size += 1 << 32
+	}
+	return size, nil
+}
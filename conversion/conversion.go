@@ -0,0 +1,1351 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conversion provides a library API for running HarbourBridge
+// conversions from Go programs. The harbourbridge command is a thin
+// wrapper over this API.
+//
+// Run has no global state, doesn't write to stdout (status messages
+// go to Options.Logger, progress to Options.Progress) and never exits
+// the process: all failures are returned as errors.
+package conversion
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sp "cloud.google.com/go/spanner"
+	_ "github.com/lib/pq" // PostgreSQL driver for database/sql.
+	"google.golang.org/api/option"
+	instancepb "google.golang.org/genproto/googleapis/spanner/admin/instance/v1"
+	sppb "google.golang.org/genproto/googleapis/spanner/v1"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner"
+)
+
+const (
+	// PGDUMP is the driver name for pg_dump.
+	PGDUMP string = "pgdump"
+	// MYSQLDUMP is the driver name for mysqldump.
+	MYSQLDUMP string = "mysqldump"
+	// POSTGRES is the driver name for PostgreSQL.
+	POSTGRES string = "postgres"
+	// DYNAMODB is the driver name for DynamoDB exports.
+	DYNAMODB string = "dynamodb"
+	// CSV is the driver name for CSV files.
+	CSV string = "csv"
+	// SQLSERVER is the driver name for SQL Server.
+	SQLSERVER string = "sqlserver"
+)
+
+// Names of generated files. These are appended to Options.FilePrefix.
+const (
+	SchemaFile     = "schema.txt"
+	ReportFile     = "report.txt"
+	HTMLReportFile = "report.html"
+	JSONReportFile = "report.json"
+	BadDataFile    = "dropped.txt"
+)
+
+// Directories of per-table files in Options.OutDir. Artifact names of
+// per-table files are their paths relative to Options.OutDir.
+const (
+	TableSchemaDir = "schema" // <table>.ddl for each Spanner table.
+	TableReportDir = "report" // <table>.txt for each source table.
+)
+
+// Artifact names of files that aren't generated files.
+const (
+	BadRowsArtifact    = "bad rows"   // Options.BadRowsFile.
+	CheckpointArtifact = "checkpoint" // Options.CheckpointFile.
+	AvroArtifact       = "avro"       // Options.AvroDir (one per table).
+)
+
+// Default performance settings.
+const (
+	DefaultBatchBytesLimit = 100 * 1000 * 1000
+	DefaultBatchBytes      = 20 * 1000 * 1000
+	DefaultWriteLimit      = 40
+	DefaultRetryLimit      = 1000
+	DefaultCommitAttempts  = 5
+	DefaultCommitBudget    = time.Minute
+	DefaultBadRowsLimit    = 100 * 1000 * 1000
+	DefaultIndexBatch      = 10
+	DefaultDDLBatch        = 100
+	DefaultDDLBatchBytes   = 1000 * 1000
+	DefaultInterruptWait   = 30 * time.Second
+	DefaultBadValueSamples = 5
+	DefaultReadWorkers     = 1
+	DefaultFetchSize       = 10000
+)
+
+// maxBatchBytes is Spanner's commit size limit.
+const maxBatchBytes = 100 * 1000 * 1000
+
+// Logger is used for status messages. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Options configures a conversion.
+type Options struct {
+	// Source.
+	Driver string    // PGDUMP, MYSQLDUMP, POSTGRES, SQLSERVER, DYNAMODB or CSV. If empty, PGDUMP or MYSQLDUMP, whichever the input is recognized as (PGDUMP if not recognized).
+	Input  io.Reader // Dump data (PGDUMP and MYSQLDUMP), or the data files of an export (DYNAMODB), possibly gzipped. If not seekable, it is copied (decompressed) to a temporary file.
+	DSN    string    // Connection string for the source database (POSTGRES and SQLSERVER only).
+
+	// Inputs are dump files (PGDUMP and MYSQLDUMP only) to convert
+	// together into one Spanner database, instead of Input: the schema
+	// passes over all the files come before the data passes. Names must
+	// be unique. It is an error for two files to define a table
+	// differently.
+	Inputs []NamedInput
+
+	// DynamoDB configures the conversion of a DynamoDB export in
+	// DynamoDB JSON format (DYNAMODB only): the table's name is needed,
+	// since exports don't record it.
+	DynamoDB internal.DynamoDBExport
+
+	// CSV defines the tables of a CSV conversion, with their Spanner
+	// schema and the files holding their rows (CSV only; see
+	// internal.ReadCSVSchema).
+	CSV *internal.CSVSchema
+
+	// Target.
+	Project       string
+	Instance      string
+	DBName        string // Name of the Spanner database to create (see DBCollision if it exists), or of the existing database for DataOnly and ExistingDB.
+	ClientOptions []option.ClientOption
+	DryRun        bool // Convert schema and data, but don't create a Spanner database or write any data.
+	SchemaOnly    bool // Convert schema and write the schema file and report, but don't access Spanner or convert data.
+	DataOnly      bool // Convert data into the existing Spanner database DBName, using its schema (or Session, if set) rather than creating one.
+	DeferIndexes  bool // Create the database without secondary indexes, and create them after data conversion (see Result.DDLFailures).
+	DDLResumeFrom int  // If positive, resume creating the schema of the existing database DBName from this batch (numbered from 1) of a previous run that failed.
+	ExistingDB    bool // Convert data into the existing Spanner database DBName, skipping tables that don't match the generated schema (see Conv.CheckExistingSchema).
+
+	// If CreateInstance is non-nil and Instance doesn't exist, Instance
+	// is created with these settings before anything else. If the
+	// conversion then fails (other than by being interrupted), the
+	// instance is deleted.
+	CreateInstance *InstanceSpec
+
+	// DBCollision is what to do if the database DBName already exists
+	// when it is to be created (empty for DBNameCollisionError). The
+	// check is made before the source is read.
+	DBCollision DBNameCollision
+
+	// Dialect is the dialect of the Spanner database that the schema
+	// is converted for (empty for GoogleSQL). PostgreSQL is only
+	// supported for schema-only conversions: the schema file and report
+	// use PostgreSQL-dialect DDL and type names.
+	Dialect Dialect
+
+	// If ValidateDDL is non-empty, the schema is validated before the
+	// Spanner database is created, by applying it to a throwaway
+	// database: ValidateDDLInstance for a temporary database in
+	// Instance, or ValidateDDLEmulator followed by the address
+	// (host:port) of a Spanner emulator e.g. "emulator:localhost:9010".
+	// If Spanner rejects any statements, Run writes the report (listing
+	// them) and returns ErrSchemaInvalid without creating the database.
+	ValidateDDL string
+
+	// If AvroDir is non-empty, data is written to Avro files in AvroDir
+	// (one per table, see package avro) instead of Spanner, for bulk
+	// import e.g. with Dataflow. No Spanner database is created, so
+	// Project, Instance and DBName are optional.
+	AvroDir string
+
+	// Endpoint is the address (host:port) of a Spanner emulator to use
+	// instead of Cloud Spanner, e.g. for end-to-end tests in CI. If
+	// empty, $SPANNER_EMULATOR_HOST is used (if set). Instance is
+	// created in the emulator if it doesn't exist.
+	Endpoint string
+
+	// Overrides.
+	TableOptions map[string]internal.TableOptions // Keyed by source table name (see internal.ReadTableOptions).
+	TypeMap      internal.TypeMap                 // User overrides of the default type mappings (see internal.ReadTypeMap).
+	Transforms   internal.ColumnTransforms        // Transforms (e.g. hashing) of column values during data conversion (see internal.ReadColumnTransforms).
+	Session      *internal.Session                // If non-nil, used instead of the Spanner schema and mapping from schema conversion (see internal.ReadSession).
+	WriteSession io.Writer                        // If non-nil, the session (see internal.Conv.WriteSession) is written here after schema conversion.
+	ColumnStats  bool                             // Collect per-column statistics (see internal.Conv.EnableColumnStats).
+	ShowMappings bool                             // List each table's column mappings in the report (see internal.Conv.EnableColumnMappings).
+	PIIKeyCheck  bool                             // Check for primary keys containing personal data.
+	SyntheticPK  internal.SyntheticPKStrategy     // How to fill primary keys added to tables without one (empty for the default).
+	Inheritance  internal.InheritanceStrategy     // How to convert tables that inherit from other tables (empty for the default).
+	Namespace    string                           // Source schema whose tables keep their names; tables of other schemas get a schema prefix (empty for public).
+	Schemas      []string                         // Source schemas whose tables are converted (empty for all but PostgreSQL's own; POSTGRES only).
+	StringLength int64                            // Length of STRING columns for source types that would map to STRING(MAX), for policies that forbid STRING(MAX) (zero for STRING(MAX)).
+	Overflow     internal.StringOverflow          // What to do with values longer than their STRING(N) column (empty for the default).
+	TimeZone     *time.Location                   // Zone that source timestamps without time zone are interpreted in (nil for UTC).
+	Floats       internal.FloatPolicy             // What to do with NaN, infinite and out-of-range FLOAT64 values (empty for the default).
+	InvalidUTF8  internal.UTF8Strategy            // What to do with STRING values that aren't valid UTF-8 (zero for the default).
+	Duplicates   internal.DuplicateCopy           // What to do with COPY-FROM blocks for tables whose data an earlier block gave (empty for the default; PGDUMP only).
+	CommitTS     internal.CommitTimestampOption   // Commit timestamp column to add to all tables (zero for none); TableOptions can override it.
+	Sampling     internal.RowSampling             // Convert only a sample of the rows of each table e.g. for trial conversions (zero for all rows).
+	Issues       internal.IssueOverrides          // Schema issues to leave out of the report, or report with a different severity.
+	Verify       bool                             // After data conversion, compare row counts of the source and Spanner tables (see Result.Mismatches).
+
+	// Output. If FilePrefix and OutDir are empty, no files are written.
+	FilePrefix string
+	TextReport bool
+	HTMLReport bool
+	JSONReport bool
+
+	// Tables are listed in the reports in ReportOrder (empty for
+	// alphabetical order). If ReportLevel is terse, the text report
+	// lists tables that converted cleanly on one line each, rather than
+	// in sections (empty for full). Schema and data conversion are
+	// rated using Thresholds (zero for the defaults).
+	ReportOrder internal.ReportOrder
+	ReportLevel internal.ReportLevel
+	Thresholds  internal.RatingThresholds
+
+	// If OutDir is non-empty, files are written to directory OutDir
+	// (with names prefixed by FilePrefix), which is created if needed.
+	// For large schemas, there are also per-table files:
+	// schema/<table>.ddl in OutDir has the DDL of each Spanner table,
+	// and report/<table>.txt has the schema and data conversion details
+	// of each source table, which the text report then leaves out.
+	OutDir string
+
+	// If BadRowsFile is non-empty, every bad row is written to it as
+	// JSON lines (see internal.BadRowWriter), up to BadRowsLimit bytes.
+	// Zero BadRowsLimit means use DefaultBadRowsLimit.
+	BadRowsFile  string
+	BadRowsLimit int64
+
+	// The report gives up to BadValueSamples example values of the
+	// column responsible for each cause of bad rows (zero means use
+	// DefaultBadValueSamples), unless NoDataSamples is set e.g. for
+	// sensitive data.
+	BadValueSamples int64
+	NoDataSamples   bool
+
+	// Unexpected conditions in the report give the approximate offset
+	// in the dump where each was first seen, and a snippet of the dump
+	// text there, unless NoSnippets is set e.g. for confidential dumps.
+	NoSnippets bool
+
+	// If CheckpointFile is non-empty, the progress of data conversion is
+	// recorded there (see internal.Checkpoint), and updated after each
+	// write to Spanner. If Resume is set, a data-only conversion resumes
+	// from the checkpoint, skipping rows processed by previous runs.
+	// Resumed conversions overwrite any existing rows, since rows
+	// written just before the previous run stopped may not have made it
+	// into the checkpoint. For POSTGRES, tables with a primary key
+	// resume after the key of the last row recorded by the checkpoint
+	// (see FetchSize); other tables skip rows by position, as for dumps.
+	CheckpointFile string
+	Resume         bool
+
+	// Performance. Zero values mean use the defaults.
+	BatchBytesLimit int64 // Limit on bytes buffered for writes to Spanner.
+	BatchBytes      int64 // Limit on (estimated) bytes in each write to Spanner. Must be well under Spanner's 100MB commit limit.
+	WriteLimit      int64 // Limit on number of in-progress writes.
+	RetryLimit      int64 // Limit on retries of failed writes.
+	IndexBatch      int64 // Limit on CREATE INDEX statements in each schema update (see DeferIndexes).
+	DDLBatch        int64 // Limit on statements in each schema update when creating the database.
+	DDLBatchBytes   int64 // Limit on bytes of statements in each schema update when creating the database.
+	ReadWorkers     int64 // Source tables read concurrently during data conversion, each in a connection of its own (POSTGRES only).
+	FetchSize       int64 // Rows read by each query of a source table with a primary key, in key order (POSTGRES only).
+
+	// Writes that fail with transient Spanner errors are retried with
+	// exponential backoff, up to CommitAttempts attempts and
+	// CommitRetryBudget of backoff for each write.
+	CommitAttempts    int64
+	CommitRetryBudget time.Duration
+
+	// Writes to Spanner are sent with request priority WritePriority
+	// (empty for Spanner's default, HIGH) and transaction tag WriteTag
+	// (empty for none), e.g. so that a bulk load doesn't starve other
+	// traffic on the instance, and can be picked out in its metrics.
+	WritePriority internal.WritePriority
+	WriteTag      string
+
+	// If ctx is canceled during data conversion (e.g. on SIGINT), Run
+	// stops reading the source, doesn't start any more writes, and
+	// waits up to InterruptWait (if zero, DefaultInterruptWait) for
+	// writes in progress before writing the report (see ErrInterrupted).
+	InterruptWait time.Duration
+
+	Logger   Logger    // If nil, status messages are discarded.
+	Progress io.Writer // If nil, progress is not reported.
+	Metrics  *Metrics  // If non-nil, updated as the conversion runs e.g. to serve to Prometheus (see NewMetrics).
+	Now      time.Time // Time used in banners and file contents. If zero, time.Now() is used.
+}
+
+// Result describes a completed conversion.
+type Result struct {
+	Database    string           // Full name of the Spanner database. Empty for dry runs.
+	Summary     string           // Brief summary of the conversion, as given at the top of the report.
+	Ratings     internal.Ratings // Overall schema and data conversion ratings.
+	Report      *Report          // Structured version of the report, with per-table ratings, issues and row stats.
+	RowsWritten int64            // Rows written to Spanner or Avro files (for dry runs, rows that would have been written).
+	BadWrites   map[string]int64 // Rows that converted but couldn't be written, keyed by source table.
+	Mismatches  int              // Tables whose row counts don't match (see Options.Verify).
+	DDLFailures int              // Indexes that couldn't be created after data conversion (see Options.DeferIndexes).
+	Artifacts   []Artifact       // Files written, in the order written.
+	// Resource usage of the conversion. Empty for dry runs, which
+	// aren't sampled.
+	Usage internal.ResourceUsage
+}
+
+// ErrInterrupted is returned by Run (along with the conversion so far)
+// if its context is canceled before the conversion completes. The
+// report is still written, and describes the rows converted until then.
+var ErrInterrupted = errors.New("conversion interrupted")
+
+// ErrSchemaInvalid is returned by Run if Spanner rejects statements of
+// the schema when it is validated (see Options.ValidateDDL). The report
+// is still written, and lists the rejected statements.
+var ErrSchemaInvalid = errors.New("schema rejected by Spanner")
+
+// Schema validation targets (see Options.ValidateDDL).
+const (
+	ValidateDDLInstance = "instance"
+	ValidateDDLEmulator = "emulator:"
+)
+
+// Artifact is a file written by a conversion.
+type Artifact struct {
+	Name  string // e.g. SchemaFile.
+	Path  string
+	Bytes int64
+}
+
+// Run converts the source database described by opts to Spanner. It
+// runs schema conversion, creates the Spanner database, runs data
+// conversion, and writes the schema, bad data and report files. The
+// returned Conv can be used for further analysis of the conversion
+// e.g. Conv.GetDDL returns the Spanner schema as DDL statements.
+//
+// Schema-only conversions (Options.SchemaOnly) stop after writing the
+// schema file and report. Data-only conversions (Options.DataOnly)
+// don't create the Spanner database: data is written to an existing
+// database, whose schema is checked against the source schema.
+//
+// Canceling ctx interrupts the conversion: see ErrInterrupted. See
+// Converter for running the steps of a conversion separately.
+func Run(ctx context.Context, opts Options) (*Conv, *Result, error) {
+	r := newRunner(opts)
+	if err := r.validateInput(); err != nil {
+		return nil, nil, err
+	}
+	if err := r.validate(); err != nil {
+		return nil, nil, err
+	}
+	conv, res, err := r.run(ctx)
+	// An interrupted conversion can be resumed, so it keeps the
+	// instance.
+	if err != nil && r.instance != nil && !errors.Is(err, ErrInterrupted) {
+		deleteInstance(r.opts, r.log)
+	}
+	return conv, res, err
+}
+
+// newRunner returns a runner for opts, with defaults filled in.
+func newRunner(opts Options) *runner {
+	r := &runner{opts: opts, log: opts.Logger}
+	if r.log == nil {
+		r.log = nopLogger{}
+	}
+	if r.opts.Driver == "" {
+		r.opts.Driver = PGDUMP
+		r.detectDriver = true
+	}
+	if r.opts.Now.IsZero() {
+		r.opts.Now = time.Now()
+	}
+	return r
+}
+
+type runner struct {
+	opts          Options
+	log           Logger
+	inputs        []*dumpInput // Seekable dump inputs: Options.Input, or Options.Inputs.
+	bytesRead     int64
+	tempFileBytes int64
+	detectDriver  bool                        // Options.Driver wasn't set (see detectFormat).
+	format        internal.InputFormat        // Format of the dump inputs (see detectFormat).
+	badRows       *internal.BadRowWriter      // Nil unless Options.BadRowsFile is set.
+	checkpoint    *internal.CheckpointTracker // Nil unless Options.CheckpointFile is set.
+	dumpHash      string                      // Hash of dump input, or the source database (only computed if Options.CheckpointFile is set).
+	instance      *instancepb.Instance        // Nil unless the instance was created for the conversion (see Options.CreateInstance).
+	res           Result
+}
+
+// NamedInput is a dump file, with the name used for it in reports and
+// errors (see Options.Inputs).
+type NamedInput struct {
+	Name  string
+	Input io.Reader // Possibly gzipped. If not seekable, it is copied (decompressed) to a temporary file.
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
+// validateInput checks that an input is given for drivers that read
+// one. It is separate from validate since Converters are given their
+// input after validation (see ProcessPgDump).
+func (r *runner) validateInput() error {
+	o := r.opts
+	switch o.Driver {
+	case PGDUMP, MYSQLDUMP:
+		if o.Input == nil && len(o.Inputs) == 0 {
+			return fmt.Errorf("no input specified for driver %s", o.Driver)
+		}
+	case DYNAMODB:
+		if o.Input == nil {
+			return fmt.Errorf("no input specified for driver %s", o.Driver)
+		}
+	}
+	return nil
+}
+
+func (r *runner) validate() error {
+	o := r.opts
+	switch o.Driver {
+	case PGDUMP, MYSQLDUMP:
+		if o.Input != nil && len(o.Inputs) > 0 {
+			return fmt.Errorf("only one of Input and Inputs can be set")
+		}
+		names := make(map[string]bool)
+		for _, in := range o.Inputs {
+			if in.Name == "" || names[in.Name] {
+				return fmt.Errorf("inputs need unique names: got %q", in.Name)
+			}
+			names[in.Name] = true
+		}
+	case DYNAMODB:
+		if err := o.DynamoDB.Validate(); err != nil {
+			return err
+		}
+	case CSV:
+		if o.CSV == nil {
+			return fmt.Errorf("no CSV schema specified for driver %s", o.Driver)
+		}
+		if o.CheckpointFile != "" {
+			return fmt.Errorf("checkpoints aren't supported for driver %s", o.Driver)
+		}
+	case POSTGRES:
+		if o.DSN == "" {
+			return fmt.Errorf("no connection string specified for driver %s", o.Driver)
+		}
+	case SQLSERVER:
+		if o.DSN == "" {
+			return fmt.Errorf("no connection string specified for driver %s", o.Driver)
+		}
+		if o.CheckpointFile != "" {
+			return fmt.Errorf("checkpoints aren't supported for driver %s", o.Driver)
+		}
+	default:
+		return fmt.Errorf("driver %s not supported", o.Driver)
+	}
+	if len(o.Inputs) > 0 && o.Driver != PGDUMP && o.Driver != MYSQLDUMP {
+		return fmt.Errorf("multiple inputs are only supported for drivers %s and %s", PGDUMP, MYSQLDUMP)
+	}
+	if o.CheckpointFile != "" && (o.DryRun || o.SchemaOnly) {
+		return fmt.Errorf("checkpoints are only supported for data conversions that write to Spanner")
+	}
+	if err := o.Sampling.Validate(); err != nil {
+		return err
+	}
+	if err := o.Issues.Validate(); err != nil {
+		return err
+	}
+	if o.Sampling.Enabled() && o.CheckpointFile != "" {
+		return fmt.Errorf("row sampling can't be combined with checkpoints")
+	}
+	if o.Dialect == DialectPostgreSQL && !o.SchemaOnly {
+		return fmt.Errorf("the postgresql dialect is only supported for schema-only conversions")
+	}
+	if o.Verify && (o.DryRun || o.SchemaOnly || o.Resume) {
+		return fmt.Errorf("verification needs a data conversion that writes to Spanner, and can't be combined with resuming")
+	}
+	if o.AvroDir != "" && (o.DryRun || o.SchemaOnly || o.DataOnly || o.CheckpointFile != "" || o.Verify) {
+		return fmt.Errorf("writing Avro files can't be combined with dry runs, schema-only or data-only conversions, checkpoints or verification")
+	}
+	if o.DeferIndexes && (o.DryRun || o.SchemaOnly || o.DataOnly || o.AvroDir != "") {
+		return fmt.Errorf("deferring indexes needs a conversion that creates a Spanner database, and can't be combined with dry runs, schema-only or data-only conversions or writing Avro files")
+	}
+	if o.IndexBatch < 0 || o.DDLBatch < 0 || o.DDLBatchBytes < 0 {
+		return fmt.Errorf("index and DDL batch limits must not be negative")
+	}
+	if o.DDLResumeFrom < 0 {
+		return fmt.Errorf("DDL batch to resume from must not be negative")
+	}
+	if o.DDLResumeFrom > 0 && (o.DryRun || o.SchemaOnly || o.DataOnly || o.AvroDir != "") {
+		return fmt.Errorf("resuming schema creation needs a conversion that creates a Spanner database, and can't be combined with dry runs, schema-only or data-only conversions or writing Avro files")
+	}
+	switch v := o.ValidateDDL; {
+	case v == "":
+	case o.DataOnly || o.AvroDir != "" || o.DDLResumeFrom > 0:
+		return fmt.Errorf("schema validation needs a conversion that creates a Spanner schema, and can't be combined with data-only conversions, writing Avro files or resuming schema creation")
+	case o.Dialect == DialectPostgreSQL:
+		return fmt.Errorf("schema validation isn't supported for the postgresql dialect")
+	case v == ValidateDDLInstance:
+		if o.Project == "" || o.Instance == "" {
+			return fmt.Errorf("validating the schema in a temporary database needs a project and instance")
+		}
+	case !strings.HasPrefix(v, ValidateDDLEmulator) || v == ValidateDDLEmulator:
+		return fmt.Errorf("bad schema validation target %q: expected %s or %s<host:port>", v, ValidateDDLInstance, ValidateDDLEmulator)
+	}
+	if o.ExistingDB && (o.DryRun || o.SchemaOnly || o.DataOnly || o.AvroDir != "" || o.DeferIndexes || o.DDLResumeFrom > 0 || o.ValidateDDL != "") {
+		return fmt.Errorf("converting data into an existing database can't be combined with dry runs, schema-only or data-only conversions, writing Avro files, deferring indexes, resuming schema creation or schema validation")
+	}
+	if o.Resume && (o.CheckpointFile == "" || !o.DataOnly) {
+		return fmt.Errorf("resuming needs a checkpoint file and a data-only conversion (into the database of the previous run)")
+	}
+	if o.BadRowsLimit < 0 {
+		return fmt.Errorf("bad rows limit must not be negative")
+	}
+	if o.BadValueSamples < 0 {
+		return fmt.Errorf("bad value samples must not be negative")
+	}
+	if o.ReadWorkers < 0 {
+		return fmt.Errorf("read workers must not be negative")
+	}
+	if o.ReadWorkers > 1 && o.Driver != POSTGRES {
+		return fmt.Errorf("concurrent reads are only supported for driver %s", POSTGRES)
+	}
+	if len(o.Schemas) > 0 && o.Driver != POSTGRES {
+		return fmt.Errorf("source schemas can only be selected for driver %s", POSTGRES)
+	}
+	if (o.DynamoDB.Table != "" || len(o.DynamoDB.Key) > 0 || o.DynamoDB.SampleSize != 0) && o.Driver != DYNAMODB {
+		return fmt.Errorf("DynamoDB export options are only supported for driver %s", DYNAMODB)
+	}
+	if o.CSV != nil && o.Driver != CSV {
+		return fmt.Errorf("a CSV schema is only supported for driver %s", CSV)
+	}
+	if o.FetchSize < 0 {
+		return fmt.Errorf("fetch size must not be negative")
+	}
+	if o.BatchBytes < 0 || o.BatchBytes > maxBatchBytes {
+		return fmt.Errorf("batch bytes must be at most %d (Spanner's commit size limit)", maxBatchBytes)
+	}
+	if _, err := internal.ParseWritePriority(string(o.WritePriority)); err != nil {
+		return err
+	}
+	if err := internal.CheckWriteTag(o.WriteTag); err != nil {
+		return err
+	}
+	if o.SchemaOnly && o.DataOnly {
+		return fmt.Errorf("schema-only and data-only conversions can't be combined")
+	}
+	if o.DataOnly && o.DryRun && o.Session == nil {
+		return fmt.Errorf("data-only dry runs need a session, since the schema can't be read from Spanner")
+	}
+	if !o.DryRun && !o.SchemaOnly && o.AvroDir == "" && (o.Project == "" || o.Instance == "" || o.DBName == "") {
+		return fmt.Errorf("project, instance and database name must be specified (unless doing a dry run, schema-only conversion or writing Avro files)")
+	}
+	if _, err := ParseDBNameCollision(string(o.DBCollision)); err != nil {
+		return err
+	}
+	if r.createsDatabase() {
+		if err := CheckDBName(o.DBName); err != nil {
+			return err
+		}
+	}
+	if s := o.CreateInstance; s != nil {
+		if !r.createsDatabase() {
+			return fmt.Errorf("creating an instance needs a conversion that creates a Spanner database, and can't be combined with dry runs, schema-only or data-only conversions, existing databases, writing Avro files or resuming schema creation")
+		}
+		if o.emulatorHost() != "" {
+			return fmt.Errorf("creating an instance isn't supported for the Spanner emulator, which creates instances on demand")
+		}
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *runner) run(ctx context.Context) (*internal.Conv, *Result, error) {
+	c := &Converter{r: r}
+	defer c.Close()
+	// Passively sample resource usage (memory, goroutines) for the
+	// "Resource Usage" section of the report. Dry runs aren't sampled,
+	// since they assess a conversion rather than do it.
+	var monitor *internal.UsageMonitor
+	if !r.opts.DryRun {
+		monitor = internal.StartUsageMonitor(5 * time.Second)
+	}
+	defer func() {
+		if monitor != nil {
+			monitor.Stop()
+		}
+	}()
+	// recordUsage stops the monitor (if any), and records the usage
+	// sampled along with the bytes read and written to files.
+	recordUsage := func(conv *internal.Conv, tempFileBytes int64) {
+		if monitor == nil {
+			return
+		}
+		usage := monitor.Stop()
+		monitor = nil
+		usage.BytesRead = r.bytesRead
+		usage.TempFileBytes = tempFileBytes
+		conv.SetResourceUsage(usage)
+		r.res.Usage = usage
+	}
+	if r.opts.CreateInstance != nil {
+		i, err := createInstance(ctx, r.opts, r.log)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.instance = i
+	}
+	if r.createsDatabase() {
+		name, err := ResolveDBName(ctx, r.opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if name != r.opts.DBName {
+			r.log.Printf("Database %s already exists: using %s instead.\n", r.opts.DBName, name)
+			r.opts.DBName = name
+		}
+	}
+	if r.opts.OutDir != "" {
+		if err := os.MkdirAll(r.opts.OutDir, 0755); err != nil {
+			return nil, nil, fmt.Errorf("can't create output directory: %w", err)
+		}
+	}
+	if err := c.processSchema(ctx); err != nil {
+		if c.sessionMismatch {
+			// Write the report, which lists all the problems found.
+			c.report(getBanner(r.opts.Now, "(session mismatch)"))
+		}
+		return nil, nil, err
+	}
+	conv := c.conv
+	if i := r.instance; i != nil {
+		conv.SetCreatedInstance(i.Name, configID(i), i.NodeCount, i.ProcessingUnits)
+	}
+	if ctx.Err() != nil {
+		return c.reportInterrupted(dbLabel(r.opts.DBName, "(interrupted)"))
+	}
+	if r.opts.WriteSession != nil {
+		if err := conv.WriteSession(r.opts.WriteSession); err != nil {
+			return nil, nil, fmt.Errorf("can't write session: %w", err)
+		}
+	}
+	// Data-only conversions don't write the schema file, since it may
+	// be the (reviewed) file that the Spanner database was created from.
+	if !r.opts.DataOnly {
+		r.writeSchemaFile(conv)
+		r.writeTableSchemaFiles(conv)
+	}
+	if r.opts.ValidateDDL != "" {
+		if err := validateSchema(ctx, r.opts, conv, r.log); err != nil {
+			return nil, nil, fmt.Errorf("can't validate schema: %w", err)
+		}
+		if n := conv.DDLRejected(); n > 0 {
+			conv.SkipDataConversion()
+			c.report(getBanner(r.opts.Now, dbLabel(r.opts.DBName, "(schema invalid)")))
+			return nil, nil, fmt.Errorf("%w: %d statements were rejected (see the DDL Validation section of the report)", ErrSchemaInvalid, n)
+		}
+	}
+	if r.opts.SchemaOnly {
+		conv.SkipDataConversion()
+		recordUsage(conv, r.tempFileBytes)
+		c.report(getBanner(r.opts.Now, dbLabel(r.opts.DBName, "(schema only)")))
+		return conv, &r.res, nil
+	}
+
+	var client *sp.Client
+	var err error
+	db := dbLabel(r.opts.DBName, "(dry run)")
+	if r.opts.AvroDir != "" {
+		db = dbLabel(r.opts.DBName, "(Avro files in "+r.opts.AvroDir+")")
+	} else if !r.opts.DryRun {
+		if r.opts.DataOnly || r.opts.ExistingDB {
+			db = dbPath(r.opts)
+		} else {
+			db, err = createDatabase(ctx, r.opts, conv, r.log)
+			if err != nil {
+				if conv.DDLBatchFailed() > 0 {
+					// Write the report, which lists the statements applied.
+					c.report(getBanner(r.opts.Now, dbPath(r.opts)+" (schema incomplete)"))
+				}
+				return nil, nil, fmt.Errorf("can't create database: %w", err)
+			}
+		}
+		r.res.Database = db
+		conv.SetDatabase(db)
+		client, err = sp.NewClient(ctx, db, clientOptions(r.opts)...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't create client for db %s: %w", db, err)
+		}
+		defer client.Close()
+		if r.opts.DataOnly && r.opts.Session == nil {
+			cols, err := readSpannerSchema(ctx, client)
+			if err != nil {
+				return nil, nil, fmt.Errorf("can't read schema of db %s: %w", db, err)
+			}
+			if err := conv.ApplySpannerSchema(cols); err != nil {
+				// Write the report, which lists all the problems found.
+				c.report(getBanner(r.opts.Now, db+" (schema mismatch)"))
+				return nil, nil, err
+			}
+		}
+		if r.opts.ExistingDB {
+			cols, err := readSpannerSchema(ctx, client)
+			if err != nil {
+				return nil, nil, fmt.Errorf("can't read schema of db %s: %w", db, err)
+			}
+			if l := conv.CheckExistingSchema(cols); len(l) > 0 {
+				r.log.Printf("Skipping %d tables that don't match the existing database (see the report): %s\n", len(l), strings.Join(l, ", "))
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return c.reportInterrupted(db + " (interrupted)")
+	}
+	err = c.WriteData(ctx, client)
+	interrupted := errors.Is(err, ErrInterrupted)
+	if err != nil && !interrupted {
+		return nil, nil, fmt.Errorf("can't finish data conversion for db %s: %w", db, err)
+	}
+	if interrupted {
+		db += " (interrupted)"
+	}
+	banner := getBanner(r.opts.Now, db)
+	badDataBytes := r.writeBadData(c.bw, conv, banner)
+	recordUsage(conv, r.tempFileBytes+badDataBytes+c.badRowsBytes)
+	if interrupted {
+		// Indexes and verification are skipped.
+		c.report(banner)
+		return conv, &r.res, ErrInterrupted
+	}
+	if r.opts.DeferIndexes {
+		if err := createIndexes(ctx, r.opts, conv, db, r.log); err != nil {
+			return nil, nil, err
+		}
+		r.res.DDLFailures = conv.DDLFailures()
+	}
+	if r.opts.Verify {
+		r.verify(ctx, client, conv)
+	}
+	c.report(banner)
+	return conv, &r.res, nil
+}
+
+// reportInterrupted writes the report of a conversion interrupted
+// before data conversion started, and returns ErrInterrupted.
+func (c *Converter) reportInterrupted(db string) (*internal.Conv, *Result, error) {
+	c.conv.SkipDataConversion()
+	c.conv.SetInterrupted(nil)
+	c.report(getBanner(c.r.opts.Now, db))
+	return c.conv, &c.r.res, ErrInterrupted
+}
+
+// verify counts the rows of each Spanner table, for the verification
+// section of the report. Sources don't need counting again: the rows
+// read from dumps are the source count, and POSTGRES tables were
+// counted in the snapshot that data was read in (see dataConv), so
+// changes to the source database since then don't show up as
+// mismatches.
+func (r *runner) verify(ctx context.Context, client *sp.Client, conv *internal.Conv) {
+	r.log.Printf("Verifying row counts ...\n")
+	var source map[string]int64
+	if r.opts.Driver == POSTGRES {
+		// Tables missing from source are reported as unknown counts.
+		source = conv.SourceRowCounts()
+	}
+	conv.SetVerifyCounts(source, countSpannerRows(ctx, client, conv.SpannerTables(), r.log))
+	r.res.Mismatches = internal.VerifyMismatches(conv, r.res.BadWrites)
+}
+
+func (r *runner) schemaConv(ctx context.Context) (*internal.Conv, error) {
+	conv := internal.MakeConv()
+	conv.SetInputFormat(r.format)
+	conv.SetTypeMap(r.opts.TypeMap)
+	conv.SetSyntheticPKStrategy(r.opts.SyntheticPK)
+	conv.SetInheritanceStrategy(r.opts.Inheritance)
+	conv.SetDefaultSchema(r.opts.Namespace)
+	conv.SetSourceSchemas(r.opts.Schemas)
+	if r.opts.Driver == DYNAMODB {
+		conv.SetDynamoDBExport(r.opts.DynamoDB)
+	}
+	if r.opts.Driver == CSV {
+		conv.SetCSVSchema(r.opts.CSV)
+	}
+	if err := conv.SetStringLength(r.opts.StringLength); err != nil {
+		return nil, err
+	}
+	conv.SetStringOverflow(r.opts.Overflow)
+	conv.SetTimestampZone(r.opts.TimeZone)
+	conv.SetFloatPolicy(r.opts.Floats)
+	conv.SetDuplicateCopy(r.opts.Duplicates)
+	if err := conv.SetUTF8Strategy(r.opts.InvalidUTF8); err != nil {
+		return nil, err
+	}
+	conv.SetCommitTimestamp(r.opts.CommitTS)
+	conv.SetRowSampling(r.opts.Sampling)
+	if !r.opts.NoSnippets {
+		conv.EnableSnippets()
+	}
+	conv.SetMetrics(r.opts.Metrics)
+	conv.SetReportOrder(r.opts.ReportOrder)
+	conv.SetReportLevel(r.opts.ReportLevel)
+	conv.SetDialect(r.opts.Dialect)
+	if r.opts.DryRun && !r.opts.SchemaOnly {
+		conv.SetDryRun()
+	}
+	if r.opts.AvroDir != "" {
+		conv.SetDataTarget("Avro files")
+	}
+	if r.opts.Thresholds != (internal.RatingThresholds{}) {
+		if err := conv.SetRatingThresholds(r.opts.Thresholds); err != nil {
+			return nil, err
+		}
+	}
+	if err := conv.SetIssueOverrides(r.opts.Issues); err != nil {
+		return nil, err
+	}
+	switch r.opts.Driver {
+	case POSTGRES:
+		sourceDB, err := sql.Open(POSTGRES, r.opts.DSN)
+		if err != nil {
+			return nil, err
+		}
+		defer sourceDB.Close()
+		if err := internal.ProcessInfoSchema(conv, sourceDB); err != nil {
+			return nil, err
+		}
+		if r.opts.CheckpointFile != "" {
+			// Identifies the source database in checkpoints, to check
+			// that resumed conversions use the same database.
+			name, err := internal.SourceDatabase(ctx, sourceDB)
+			if err != nil {
+				return nil, err
+			}
+			r.dumpHash = "postgres:" + name
+		}
+		if r.opts.SchemaOnly {
+			// For the storage estimates in the report, since rows
+			// aren't counted during data conversion.
+			conv.SetRowEstimates(internal.EstimateSqlRows(conv, sourceDB))
+		}
+	case SQLSERVER:
+		sourceDB, err := openSQLServer(r.opts.DSN)
+		if err != nil {
+			return nil, err
+		}
+		defer sourceDB.Close()
+		if err := internal.ProcessSQLServerSchema(conv, sourceDB); err != nil {
+			return nil, err
+		}
+		if r.opts.SchemaOnly {
+			conv.SetRowEstimates(internal.EstimateSqlRows(conv, sourceDB))
+		}
+	case PGDUMP, MYSQLDUMP, DYNAMODB:
+		p := internal.NewProgressWriter(r.bytesRead, "Generating schema", internal.Verbose(), r.opts.Progress)
+		conv.SetSchemaMode() // Build schema and ignore data in the dump.
+		conv.SetDataSink(nil)
+		h := sha256.New()
+		var offset int64 // Of the input in the concatenation of all inputs, for progress.
+		for _, d := range r.inputs {
+			if ctx.Err() != nil {
+				break
+			}
+			var in io.Reader = d.in
+			if r.opts.CheckpointFile != "" {
+				// Hash the dump during this pass, to check that resumed
+				// conversions use the same dump.
+				in = io.TeeReader(in, h)
+			}
+			if err := r.processInput(conv, d, internal.NewReaderContext(ctx, bufio.NewReader(in), offsetProgress{p, offset})); err != nil && ctx.Err() == nil {
+				return nil, err
+			}
+			if r.opts.CheckpointFile != "" && ctx.Err() == nil {
+				// Make sure the hash covers the whole dump, even if parsing
+				// stopped early.
+				if _, err := io.Copy(h, d.in); err != nil {
+					return nil, fmt.Errorf("can't read the data file: %w", err)
+				}
+			}
+			if d.gzip != nil && d.gzip.size >= 0 {
+				// Replace the estimate by the actual decompressed size.
+				r.bytesRead += d.gzip.size - d.size
+				d.size = d.gzip.size
+			}
+			offset += d.size
+		}
+		if len(r.opts.Inputs) > 0 {
+			conv.FinishDumpFiles()
+		}
+		p.Done()
+		if r.opts.CheckpointFile != "" && ctx.Err() == nil {
+			r.dumpHash = "sha256:" + hex.EncodeToString(h.Sum(nil))
+		}
+		if l := conv.DumpFileConflicts(); len(l) > 0 {
+			return nil, fmt.Errorf("inputs define tables differently: %s", strings.Join(l, "; "))
+		}
+	case CSV:
+		conv.SetSchemaMode() // Count rows, as for dumps.
+		conv.SetDataSink(nil)
+		internal.ProcessCSVSchema(conv)
+		p := internal.NewProgressWriter(r.csvFileCount(), "Counting rows of CSV files", internal.Verbose(), r.opts.Progress)
+		if err := r.processCSV(ctx, conv, p); err != nil && ctx.Err() == nil {
+			return nil, fmt.Errorf("failed to read the CSV files: %w", err)
+		}
+		p.Done()
+	}
+	return conv, nil
+}
+
+// dataConv runs data conversion, writing data to Spanner via client
+// (or to Avro files, if Options.AvroDir is set). For dry runs, client
+// is nil and data is converted (and batched) as usual, but not written.
+func (r *runner) dataConv(ctx context.Context, client *sp.Client, conv *internal.Conv) (dataWriter, error) {
+	// Row counts and data are read in one snapshot of the source
+	// database, which concurrent readers join. The schema is read
+	// separately, during schema conversion.
+	var snapshot *sql.Tx
+	var readers []internal.SqlQueryer
+	var readOpts internal.SqlReadOptions
+	var rows map[string]int64 // Rows of each source table, for progress.
+	switch r.opts.Driver {
+	case POSTGRES:
+		sourceDB, err := sql.Open(POSTGRES, r.opts.DSN)
+		if err != nil {
+			return nil, err
+		}
+		defer sourceDB.Close()
+		snapshot, err = internal.BeginSnapshot(ctx, conv, sourceDB)
+		if err != nil {
+			return nil, err
+		}
+		defer snapshot.Rollback()
+		readers = []internal.SqlQueryer{snapshot}
+		readOpts.FetchSize = defaultInt64(r.opts.FetchSize, DefaultFetchSize)
+		// Further readers, and readers replacing ones whose
+		// connection was lost, join the snapshot.
+		var joined []*sql.Tx
+		var joinLock sync.Mutex
+		defer func() {
+			for _, tx := range joined {
+				tx.Rollback()
+			}
+		}()
+		id, err := internal.ExportSnapshot(ctx, snapshot)
+		join := func(ctx context.Context) (internal.SqlQueryer, error) {
+			tx, err := internal.JoinSnapshot(ctx, sourceDB, id)
+			if err != nil {
+				return nil, err
+			}
+			joinLock.Lock()
+			defer joinLock.Unlock()
+			joined = append(joined, tx)
+			return tx, nil
+		}
+		n := defaultInt64(r.opts.ReadWorkers, DefaultReadWorkers)
+		if err != nil {
+			r.log.Printf("Can't share the snapshot of the source database (%v): reading one table at a time, without reconnecting if the connection is lost.\n", err)
+		} else {
+			readOpts.Reconnect = join
+		}
+		for i := int64(1); err == nil && i < n; i++ {
+			var tx internal.SqlQueryer
+			if tx, err = join(ctx); err != nil {
+				r.log.Printf("Can't start read worker %d (%v): reading %d tables at a time.\n", i+1, err, i)
+				break
+			}
+			readers = append(readers, tx)
+		}
+		internal.SetRowStats(conv, snapshot)
+		rows = conv.SourceRowCounts()
+		if len(rows) < len(conv.SourceTables()) {
+			// Fall back to estimates from PostgreSQL's statistics for
+			// tables that couldn't be counted.
+			for t, n := range internal.EstimateSqlRows(conv, snapshot) {
+				if _, ok := rows[t]; !ok {
+					rows[t] = n
+				}
+			}
+		}
+	case SQLSERVER:
+		// Tables are read one at a time, in separate queries, so
+		// rows changed meanwhile can be inconsistent between tables.
+		sourceDB, err := openSQLServer(r.opts.DSN)
+		if err != nil {
+			return nil, err
+		}
+		defer sourceDB.Close()
+		readers = []internal.SqlQueryer{sourceDB}
+		internal.SetRowStats(conv, sourceDB)
+		rows = conv.SourceRowCounts()
+	case PGDUMP, MYSQLDUMP, DYNAMODB:
+		for _, d := range r.inputs {
+			if _, err := d.in.Seek(0, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("can't seek to start of file (preparation for second pass): %w", err)
+			}
+		}
+	}
+	msg := "Writing data to Spanner"
+	if r.opts.AvroDir != "" {
+		msg = "Writing data to Avro files"
+	} else if client == nil {
+		msg = "Converting data (dry run)"
+	}
+	if r.fromDump() || r.opts.Driver == CSV {
+		rows = conv.SourceRowCounts()
+	}
+	p := r.dataProgress(msg, rows)
+	conv.SetProgress(p)
+	var writer dataWriter
+	var finish func() error
+	if r.opts.AvroDir != "" {
+		aw, err := r.avroWriter(conv)
+		if err != nil {
+			return nil, err
+		}
+		writer = aw
+		finish = func() error {
+			err := aw.Close()
+			r.addAvroArtifacts(aw)
+			return err
+		}
+	} else {
+		// Writes use their own context, so that writes in progress can
+		// complete if ctx is canceled.
+		writeCtx, cancelWrites := context.WithCancel(context.Background())
+		defer cancelWrites()
+		bw := r.batchWriter(writeCtx, client, conv)
+		writer = bw
+		finish = func() error {
+			if ctx.Err() == nil {
+				bw.Flush()
+			} else if !bw.Abandon(defaultDuration(r.opts.InterruptWait, DefaultInterruptWait)) {
+				cancelWrites()
+				bw.Flush()
+			}
+			return nil
+		}
+	}
+	conv.SetDataMode() // For dumps, process data; schema is unchanged.
+	if t := r.checkpoint; t != nil {
+		// Checkpoints are only supported for Spanner (see validate).
+		bw := writer.(*spanner.BatchWriter)
+		conv.SetDataSink(
+			func(table string, cols []string, vals []interface{}) {
+				bw.AddRowWithID(table, cols, vals, t.CurrentRow())
+			})
+	} else {
+		conv.SetDataSink(
+			func(table string, cols []string, vals []interface{}) {
+				writer.AddRow(table, cols, vals)
+			})
+	}
+	switch r.opts.Driver {
+	case POSTGRES, SQLSERVER:
+		internal.ProcessSqlDataParallel(ctx, conv, readers, readOpts)
+	case PGDUMP, MYSQLDUMP, DYNAMODB:
+		var offset int64
+		for _, d := range r.inputs {
+			if ctx.Err() != nil {
+				break
+			}
+			r.processInput(conv, d, internal.NewReaderContext(ctx, bufio.NewReader(d.in), offsetProgress{p, offset}))
+			offset += d.size
+		}
+	case CSV:
+		// The schema pass has already read the files, so errors
+		// are unlikely, and are logged rather than failing the run.
+		if err := r.processCSV(ctx, conv, nil); err != nil {
+			internal.Log().Errorf("Can't read CSV files: %s", err)
+		}
+	}
+	err := finish()
+	p.Done()
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// dataProgress returns the ProgressSink for data conversion, which
+// reports to Options.Progress table by table, with totals from rows
+// (keyed by source table).
+func (r *runner) dataProgress(msg string, rows map[string]int64) internal.ProgressSink {
+	if r.opts.Progress == nil {
+		return internal.NopProgress{}
+	}
+	var dumpBytes int64
+	if r.fromDump() {
+		dumpBytes = r.bytesRead
+	}
+	return internal.NewTableProgress(msg, dumpBytes, rows, internal.Verbose(), r.opts.Progress)
+}
+
+// batchWriter returns a BatchWriter that writes to Spanner via client
+// (or, for dry runs, just counts rows).
+func (r *runner) batchWriter(ctx context.Context, client *sp.Client, conv *internal.Conv) *spanner.BatchWriter {
+	config := spanner.BatchWriterConfig{
+		BytesLimit:        defaultInt64(r.opts.BatchBytesLimit, DefaultBatchBytesLimit),
+		BatchBytes:        defaultInt64(r.opts.BatchBytes, DefaultBatchBytes),
+		WriteLimit:        defaultInt64(r.opts.WriteLimit, DefaultWriteLimit),
+		RetryLimit:        defaultInt64(r.opts.RetryLimit, DefaultRetryLimit),
+		CommitAttempts:    defaultInt64(r.opts.CommitAttempts, DefaultCommitAttempts),
+		CommitRetryBudget: defaultDuration(r.opts.CommitRetryBudget, DefaultCommitBudget),
+		Log:               internal.Log(),
+		Write: func(m []*sp.Mutation) error {
+			if client != nil {
+				start := time.Now()
+				_, err := client.Apply(ctx, m, r.applyOptions()...)
+				conv.RecordCommit(sp.ErrCode(err).String(), time.Since(start))
+				if err != nil {
+					return err
+				}
+			}
+			atomic.AddInt64(&r.res.RowsWritten, int64(len(m)))
+			return nil
+		},
+		OnRetry: func() { conv.AddCommitRetries(1) },
+	}
+	if w := r.badRows; w != nil {
+		config.OnDrop = func(table string, cols []string, vals []interface{}, err error) {
+			reason := internal.BadRowWrite
+			if err == spanner.ErrRowTooLarge {
+				reason = internal.BadRowTooLarge
+			}
+			w.AddWriteError(table, cols, vals, reason, err)
+		}
+	}
+	if t := r.checkpoint; t != nil {
+		config.OnDone = t.Settle
+		config.Upsert = r.opts.Resume
+	}
+	return spanner.NewBatchWriter(config)
+}
+
+// writePriority returns the priority of writes to Spanner, in
+// canonical form (see Options.WritePriority).
+func (r *runner) writePriority() internal.WritePriority {
+	p, _ := internal.ParseWritePriority(string(r.opts.WritePriority)) // Already validated.
+	return p
+}
+
+// applyOptions returns the options for writes to Spanner, which set
+// their priority and transaction tag (see Options.WritePriority).
+func (r *runner) applyOptions() []sp.ApplyOption {
+	var opts []sp.ApplyOption
+	switch r.writePriority() {
+	case internal.WritePriorityLow:
+		opts = append(opts, sp.Priority(sppb.RequestOptions_PRIORITY_LOW))
+	case internal.WritePriorityMedium:
+		opts = append(opts, sp.Priority(sppb.RequestOptions_PRIORITY_MEDIUM))
+	case internal.WritePriorityHigh:
+		opts = append(opts, sp.Priority(sppb.RequestOptions_PRIORITY_HIGH))
+	}
+	if r.opts.WriteTag != "" {
+		opts = append(opts, sp.TransactionTag(r.opts.WriteTag))
+	}
+	return opts
+}
+
+// fromDump returns true if the source is a dump file (rather than a
+// database connection).
+// createsDatabase returns whether the conversion creates a Spanner
+// database (rather than writing to an existing one, or not writing to
+// Spanner at all).
+func (r *runner) createsDatabase() bool {
+	o := r.opts
+	return !o.DryRun && !o.SchemaOnly && o.AvroDir == "" && !o.DataOnly && !o.ExistingDB && o.DDLResumeFrom == 0
+}
+
+func (r *runner) fromDump() bool {
+	return r.opts.Driver == PGDUMP || r.opts.Driver == MYSQLDUMP || r.opts.Driver == DYNAMODB
+}
+
+// processDump does schema or data conversion of the dump in rd (see
+// internal.ProcessPgDump), depending on conv's mode.
+func (r *runner) processDump(conv *internal.Conv, rd *internal.Reader) error {
+	switch r.opts.Driver {
+	case MYSQLDUMP:
+		return internal.ProcessMySQLDump(conv, rd)
+	case DYNAMODB:
+		return internal.ProcessDynamoDBExport(conv, rd)
+	}
+	return internal.ProcessPgDump(conv, rd)
+}
+
+// source returns a description of the source, for reports.
+func (r *runner) source() internal.Source {
+	switch r.opts.Driver {
+	case PGDUMP:
+		return internal.PgDumpSource
+	case MYSQLDUMP:
+		return internal.MySQLDumpSource
+	case DYNAMODB:
+		return internal.DynamoDBSource
+	case CSV:
+		return internal.CSVSource
+	case SQLSERVER:
+		return internal.SQLServerSource
+	}
+	return internal.PostgresSource
+}
+
+// getSeekable returns a seekable version of in (in itself if it is
+// seekable, otherwise a copy in a temporary file), with the size of the
+// input. The returned cleanup function removes any temporary file.
+func getSeekable(in io.Reader) (*dumpInput, func(), error) {
+	if s, ok := in.(io.ReadSeeker); ok {
+		// Stdin is seekable when you run 'cmd < file'.
+		if n, err := s.Seek(0, io.SeekEnd); err == nil {
+			if _, err := s.Seek(0, io.SeekStart); err != nil {
+				return nil, nil, fmt.Errorf("can't reset file offset: %w", err)
+			}
+			c, err := peekCompression(s)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch c {
+			case gzipped:
+				// Decompress on the fly in each pass. Progress is based
+				// on decompressed bytes, so we need to estimate the
+				// decompressed size (until the first pass gets it).
+				size, err := gzipSizeEstimate(s, n)
+				if err != nil {
+					return nil, nil, err
+				}
+				g, err := newGzipSeeker(s)
+				if err != nil {
+					return nil, nil, err
+				}
+				return &dumpInput{in: g, gzip: g, size: size}, func() { g.Close() }, nil
+			case zstdCompressed:
+				return nil, nil, errZstd
+			}
+			return &dumpInput{in: s, size: n}, func() {}, nil
+		}
+	}
+	// Compressed input is decompressed into the temporary file.
+	br := bufio.NewReader(in)
+	header, _ := br.Peek(len(zstdMagic)) // Errors are returned by the copy below.
+	in = br
+	switch detectCompression(header) {
+	case gzipped:
+		z, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't read gzip input: %w", err)
+		}
+		defer z.Close()
+		in = z
+	case zstdCompressed:
+		return nil, nil, errZstd
+	}
+	internal.VerbosePrintln("Creating a tmp file with a copy of the input because it is not seekable.")
+	// Create file in os.TempDir. Its not clear this is a good idea e.g. if the
+	// pg_dump output is large (tens of GBs) and os.TempDir points to a directory
+	// (such as /tmp) that's configured with a small amount of disk space.
+	// To workaround such limits on Unix, set $TMPDIR to a directory with lots
+	// of disk space.
+	f, err := ioutil.TempFile("", "harbourbridge.data")
+	if err != nil {
+		return nil, nil, seekError(err)
+	}
+	os.Remove(f.Name()) // On Unix, the file will be deleted when it is closed.
+	cleanup := func() { f.Close() }
+	n, err := io.Copy(f, in)
+	if err != nil {
+		cleanup()
+		return nil, nil, seekError(fmt.Errorf("can't write input to tmp file: %w", err))
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, seekError(fmt.Errorf("can't reset file offset: %w", err))
+	}
+	return &dumpInput{in: f, size: n, tempBytes: n}, cleanup, nil
+}
+
+func seekError(err error) error {
+	return fmt.Errorf("can't get seekable input file: %w.\n"+`
+Likely cause: not enough space in %s.
+Try writing pg_dump output to a file first i.e.
+  pg_dump > tmpfile
+  harbourbridge < tmpfile`, err, os.TempDir())
+}
+
+func (r *runner) path(name string) string {
+	if r.opts.OutDir != "" {
+		return filepath.Join(r.opts.OutDir, r.opts.FilePrefix+name)
+	}
+	if r.opts.FilePrefix == "" {
+		return ""
+	}
+	return r.opts.FilePrefix + name
+}
+
+func (r *runner) addArtifact(name, path string) {
+	var n int64
+	if info, err := os.Stat(path); err == nil {
+		n = info.Size()
+	}
+	r.res.Artifacts = append(r.res.Artifacts, Artifact{Name: name, Path: path, Bytes: n})
+}
+
+// dbLabel returns a description of the database for banners e.g.
+// "mydb (dry run)".
+func dbLabel(dbName, mode string) string {
+	if dbName == "" {
+		return mode
+	}
+	return dbName + " " + mode
+}
+
+func getBanner(now time.Time, db string) string {
+	return fmt.Sprintf("Generated at %s for db %s\n\n", now.Format("2006-01-02 15:04:05"), db)
+}
+
+func defaultInt64(v, d int64) int64 {
+	if v == 0 {
+		return d
+	}
+	return v
+}
+
+func defaultDuration(v, d time.Duration) time.Duration {
+	if v == 0 {
+		return d
+	}
+	return v
+}
+
+func sum(m map[string]int64) int64 {
+	n := int64(0)
+	for _, c := range m {
+		n += c
+	}
+	return n
+}
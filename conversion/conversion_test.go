@@ -0,0 +1,884 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sp "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+const testDump = "CREATE TABLE t (a bigint PRIMARY KEY, b text, c int4);\n" +
+	"COPY public.t (a, b, c) FROM stdin;\n" +
+	"1\tfoo\t10\n" +
+	"2\tbar\tnot-a-number\n" +
+	"\\.\n"
+
+type bufLogger struct{ bytes.Buffer }
+
+func (l *bufLogger) Printf(format string, v ...interface{}) { fmt.Fprintf(&l.Buffer, format, v...) }
+
+func TestRun_DryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, tc := range []struct {
+		name  string
+		input io.Reader
+	}{
+		{"seekable", strings.NewReader(testDump)},
+		// Non-seekable input is copied to a temporary file.
+		{"pipe", io.MultiReader(strings.NewReader(testDump))},
+		// Gzipped input is decompressed on the fly, or into the
+		// temporary file.
+		{"gzip", bytes.NewReader(gzipBytes(testDump))},
+		{"gzip pipe", io.MultiReader(bytes.NewReader(gzipBytes(testDump)))},
+	} {
+		prefix := filepath.Join(dir, tc.name+".")
+		l := &bufLogger{}
+		var progress bytes.Buffer
+		conv, res, err := Run(context.Background(), Options{
+			Input:      tc.input,
+			DryRun:     true,
+			FilePrefix: prefix,
+			TextReport: true,
+			JSONReport: true,
+			Logger:     l,
+			Progress:   &progress,
+			Now:        time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		})
+		assert.Nil(t, err, tc.name)
+		assert.Equal(t, int64(2), conv.Rows(), tc.name)
+		assert.Equal(t, int64(1), conv.BadRows(), tc.name)
+		assert.Equal(t, "", res.Database, tc.name)
+		assert.Equal(t, int64(1), res.RowsWritten, tc.name)
+		assert.Equal(t, internal.Ratings{
+			Schema:     internal.RatingExcellent,
+			Data:       internal.RatingPoor,
+			SchemaDesc: "EXCELLENT (all columns mapped cleanly)",
+			DataDesc:   "POOR (50% of 2 rows would be written to Spanner)",
+		}, res.Ratings, tc.name)
+		assert.True(t, strings.HasPrefix(res.Summary, "DRY RUN — no data was written to Spanner.\n"), tc.name)
+		assert.Contains(t, res.Summary, "Data conversion: POOR", tc.name)
+		// Dry runs don't sample resource usage.
+		assert.Equal(t, internal.ResourceUsage{}, res.Usage, tc.name)
+		var names []string
+		for _, a := range res.Artifacts {
+			names = append(names, a.Name)
+			info, err := os.Stat(a.Path)
+			assert.Nil(t, err, a.Path)
+			assert.Equal(t, info.Size(), a.Bytes, a.Path)
+			assert.True(t, strings.HasPrefix(a.Path, prefix), a.Path)
+		}
+		assert.Equal(t, []string{SchemaFile, BadDataFile, ReportFile, JSONReportFile}, names, tc.name)
+		report, err := ioutil.ReadFile(prefix + ReportFile)
+		assert.Nil(t, err)
+		assert.Contains(t, string(report), "Generated at 2020-01-02 03:04:05 for db (dry run)")
+		// Nothing in the report says rows were written.
+		assert.NotContains(t, string(report), "rows written to Spanner", tc.name)
+		jsonReport, err := ioutil.ReadFile(prefix + JSONReportFile)
+		assert.Nil(t, err)
+		assert.Contains(t, string(jsonReport), `"dryRun": true`)
+		assert.NotContains(t, string(report), "Resource Usage")
+		assert.NotContains(t, string(jsonReport), `"resourceUsage"`)
+		// pg_dump statement stats are included for pg_dump input.
+		assert.Contains(t, string(report), "Statements Processed")
+		assert.Contains(t, l.String(), "See file '"+prefix+ReportFile+"'")
+		assert.Contains(t, progress.String(), "Converting data (dry run): 100% | table t: 2 of 2 rows, 22 B", tc.name)
+	}
+}
+
+func TestConverter(t *testing.T) {
+	c, err := NewConv(Options{DryRun: true})
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, errNoSchema, c.WriteData(context.Background(), nil))
+	assert.Nil(t, c.Report())
+	assert.Nil(t, c.ProcessPgDump(io.MultiReader(strings.NewReader(testDump))))
+	assert.Equal(t, errSchemaDone, c.ProcessPgDump(strings.NewReader(testDump)))
+	assert.Equal(t, []string{"CREATE TABLE `t` (\n    `a` INT64 NOT NULL,\n    `b` STRING(MAX),\n    `c` INT64 \n) PRIMARY KEY (`a`)"}, c.SchemaToDDL())
+	assert.Nil(t, c.WriteData(context.Background(), nil))
+	assert.Equal(t, errDataDone, c.WriteData(context.Background(), nil))
+	assert.Equal(t, int64(2), c.Conv().Rows())
+	assert.Equal(t, int64(1), c.RowsWritten())
+	report := c.Report()
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, len(report.Tables))
+
+	// The same conversion, via Run.
+	_, res, err := Run(context.Background(), Options{Input: strings.NewReader(testDump), DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, res.Report.Summary, report.Summary)
+	assert.Equal(t, res.Report.Tables[0].Issues, report.Tables[0].Issues)
+	assert.Equal(t, res.Report.Tables[0].BadRowCauses, report.Tables[0].BadRowCauses)
+
+	for _, tc := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"driver", Options{Driver: MYSQLDUMP, DryRun: true}, "driver mysqldump not supported by Converter: use Run"},
+		{"input", Options{Input: strings.NewReader(testDump), DryRun: true}, "input is given to ProcessPgDump, not Options"},
+		{"options", Options{}, "project, instance and database name must be specified (unless doing a dry run, schema-only conversion or writing Avro files)"},
+	} {
+		_, err := NewConv(tc.opts)
+		assert.EqualError(t, err, tc.want, tc.name)
+	}
+	// mysqldump output is rejected, rather than converted.
+	c, err = NewConv(Options{SchemaOnly: true})
+	assert.Nil(t, err)
+	assert.EqualError(t, c.ProcessPgDump(strings.NewReader("-- MySQL dump 10.13\n")), "the input looks like mysqldump output, which driver pgdump can't read: use driver mysqldump")
+	c, err = NewConv(Options{DryRun: true})
+	assert.Nil(t, err)
+	assert.Nil(t, c.ProcessPgDump(strings.NewReader(testDump)))
+	assert.EqualError(t, c.WriteData(context.Background(), &sp.Client{}), "a Spanner client is needed unless doing a dry run or writing Avro files, and not allowed otherwise")
+}
+
+func TestRun_DynamoDB(t *testing.T) {
+	export := `{"Item":{"id":{"S":"a"},"n":{"N":"1"}}}` + "\n" + `{"Item":{"id":{"S":"b"},"n":{"S":"x"}}}` + "\n"
+	conv, res, err := Run(context.Background(), Options{
+		Driver:   DYNAMODB,
+		Input:    bytes.NewReader(gzipBytes(export)),
+		DynamoDB: DynamoDBExport{Table: "items", Key: []string{"id"}},
+		DryRun:   true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"items"}, conv.SourceTables())
+	assert.Equal(t, int64(2), conv.Rows())
+	assert.Equal(t, int64(0), conv.BadRows())
+	assert.Equal(t, int64(2), res.RowsWritten)
+}
+
+func TestRun_CSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	users1 := filepath.Join(dir, "users-1.csv")
+	users2 := filepath.Join(dir, "users-2.tsv.gz")
+	assert.Nil(t, ioutil.WriteFile(users1, []byte("id,bio\n1,\"multi\nline\"\n2,\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(users2, gzipBytes("id\tbio\nx\ty\n"), 0644))
+	conv, res, err := Run(context.Background(), Options{
+		Driver: CSV,
+		CSV: &CSVSchema{Tables: []CSVTable{{
+			Name:       "users",
+			Columns:    []CSVColumn{{Name: "id", Type: "INT64"}, {Name: "bio", Type: "STRING"}},
+			PrimaryKey: []string{"id"},
+			Files:      []string{users1, users2},
+		}}},
+		DryRun: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users"}, conv.SourceTables())
+	assert.Equal(t, int64(3), conv.Rows())
+	assert.Equal(t, int64(1), conv.BadRows())
+	assert.Equal(t, int64(2), res.RowsWritten)
+}
+
+func TestRun_Inputs(t *testing.T) {
+	a := "CREATE TABLE a.t (id bigint PRIMARY KEY);\n" +
+		"COPY a.t (id) FROM stdin;\n1\n2\n\\.\n"
+	b := "CREATE TABLE b.u (id bigint PRIMARY KEY, t_id bigint REFERENCES a.t (id));\n" +
+		"CREATE TABLE a.t (id bigint PRIMARY KEY);\n" +
+		"COPY b.u (id, t_id) FROM stdin;\n3\t1\n\\.\n"
+	conv, res, err := Run(context.Background(), Options{
+		Inputs: []NamedInput{
+			{Name: "a.sql.gz", Input: bytes.NewReader(gzipBytes(a))},
+			{Name: "b.sql", Input: io.MultiReader(strings.NewReader(b))}, // Not seekable.
+		},
+		DryRun: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.t", "b.u"}, conv.SourceTables())
+	assert.Equal(t, int64(3), res.RowsWritten)
+	assert.Equal(t, int64(5), conv.Statements())
+
+	_, _, err = Run(context.Background(), Options{
+		Inputs: []NamedInput{
+			{Name: "a.sql", Input: strings.NewReader(a)},
+			{Name: "b.sql", Input: strings.NewReader(strings.Replace(b, "a.t (id bigint", "a.t (id text", 1))},
+		},
+		DryRun: true,
+	})
+	assert.EqualError(t, err, "inputs define tables differently: table a.t is defined differently in a.sql and b.sql")
+}
+
+func TestRun_DetectFormat(t *testing.T) {
+	mysqlDump := "-- MySQL dump 10.13  Distrib 8.0.26\n" +
+		"CREATE TABLE `t` (`id` int NOT NULL, PRIMARY KEY (`id`));\n" +
+		"INSERT INTO `t` VALUES (1),(2);\n"
+	pgDump := "--\n-- PostgreSQL database dump\n--\n" + testDump
+	// With no driver, mysqldump output is converted with MYSQLDUMP.
+	conv, res, err := Run(context.Background(), Options{Input: strings.NewReader(mysqlDump), DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"t"}, conv.SourceTables())
+	assert.Equal(t, int64(2), res.RowsWritten)
+	assert.Equal(t, internal.InputFormatMySQLDump, internal.InputFormat(internal.BuildReport(internal.MySQLDumpSource, conv, nil).InputFormat))
+	conv, _, err = Run(context.Background(), Options{Input: bytes.NewReader(gzipBytes(pgDump)), DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, internal.InputFormatPgDump, internal.InputFormat(internal.BuildReport(internal.PgDumpSource, conv, nil).InputFormat))
+
+	for _, tc := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"archive", Options{Input: strings.NewReader("PGDMP\x01\x0e\x00")}, errPgArchive.Error()},
+		{"mysqldump with pgdump driver", Options{Driver: PGDUMP, Input: strings.NewReader(mysqlDump)}, "the input looks like mysqldump output, which driver pgdump can't read: use driver mysqldump"},
+		{"pgdump with mysqldump driver", Options{Driver: MYSQLDUMP, Input: strings.NewReader(pgDump)}, "the input looks like pg_dump output, which driver mysqldump can't read: use driver pgdump"},
+		{"mixed inputs", Options{Inputs: []NamedInput{{Name: "a.sql", Input: strings.NewReader(pgDump)}, {Name: "b.sql", Input: strings.NewReader(mysqlDump)}}}, "inputs are in different formats: pg_dump and mysqldump"},
+		{"archive input", Options{Inputs: []NamedInput{{Name: "a.sql", Input: strings.NewReader(pgDump)}, {Name: "b.dump", Input: strings.NewReader("PGDMP")}}}, "b.dump: " + errPgArchive.Error()},
+	} {
+		tc.opts.DryRun = true
+		_, _, err := Run(context.Background(), tc.opts)
+		assert.EqualError(t, err, tc.want, tc.name)
+	}
+}
+
+func TestOpenSQLServer(t *testing.T) {
+	db, err := openSQLServer("sqlserver://localhost?database=d")
+	assert.Nil(t, err)
+	db.Close()
+}
+
+func TestRun_Zstd(t *testing.T) {
+	zstd := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x0, 0x0}
+	for _, in := range []io.Reader{bytes.NewReader(zstd), io.MultiReader(bytes.NewReader(zstd))} {
+		_, _, err := Run(context.Background(), Options{Input: in, DryRun: true})
+		assert.Equal(t, errZstd, err)
+	}
+}
+
+func TestGzipSizeEstimate(t *testing.T) {
+	for _, tc := range []struct {
+		isize    uint32
+		n        int64
+		expected int64
+	}{
+		{10, 30, 10}, // Small files can get bigger when compressed.
+		{5 << 20, 1 << 20, 5 << 20},
+		{1 << 20, 2 << 20, 1<<32 + 1<<20}, // The size is modulo 2^32.
+	} {
+		b := make([]byte, 20)
+		binary.LittleEndian.PutUint32(b[16:], tc.isize)
+		size, err := gzipSizeEstimate(bytes.NewReader(b), tc.n)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, size)
+	}
+}
+
+func TestGCSReader(t *testing.T) {
+	// Each read of the object fails after 10 bytes, and the first two
+	// attempts to open it at offset 20 fail.
+	failures := 2
+	var offsets []int64
+	open := func(offset int64) (io.ReadCloser, error) {
+		offsets = append(offsets, offset)
+		if offset == 20 && failures > 0 {
+			failures--
+			return nil, fmt.Errorf("unavailable")
+		}
+		end := offset + 10
+		if end > int64(len(testDump)) {
+			end = int64(len(testDump))
+		}
+		return ioutil.NopCloser(strings.NewReader(testDump[offset:end])), nil
+	}
+	r := newGCSReader("gs://b/o", int64(len(testDump)), open)
+	var delays []time.Duration
+	r.sleep = func(d time.Duration) { delays = append(delays, d) }
+	// Run conversion twice, to check the second pass too.
+	for pass := 0; pass < 2; pass++ {
+		conv, _, err := Run(context.Background(), Options{Input: r, DryRun: true})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), conv.Rows())
+	}
+	assert.Equal(t, []time.Duration{gcsInitialBackoff, 2 * gcsInitialBackoff}, delays)
+	// The first read is for detecting compression.
+	assert.Equal(t, []int64{0, 0, 10, 20, 20, 20, 30}, offsets[:7])
+
+	// Reads give up after gcsReadAttempts attempts.
+	r = newGCSReader("gs://b/o", 100, func(int64) (io.ReadCloser, error) { return nil, fmt.Errorf("unavailable") })
+	r.sleep = func(time.Duration) {}
+	_, err := r.Read(make([]byte, 10))
+	assert.EqualError(t, err, "can't read gs://b/o at offset 0: unavailable")
+}
+
+func TestParseGCSURL(t *testing.T) {
+	bucket, object, err := parseGCSURL("gs://my-bucket/dumps/mydb.sql.gz")
+	assert.Nil(t, err)
+	assert.Equal(t, "my-bucket", bucket)
+	assert.Equal(t, "dumps/mydb.sql.gz", object)
+	for _, url := range []string{"gs://my-bucket", "gs://my-bucket/", "gs:///o"} {
+		_, _, err := parseGCSURL(url)
+		assert.NotNil(t, err, url)
+	}
+}
+
+func gzipBytes(s string) []byte {
+	var b bytes.Buffer
+	z := gzip.NewWriter(&b)
+	z.Write([]byte(s))
+	z.Close()
+	return b.Bytes()
+}
+
+func TestRun_NoFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	_, res, err := Run(context.Background(), Options{Input: strings.NewReader(testDump), DryRun: true, TextReport: true})
+	assert.Nil(t, err)
+	assert.Nil(t, res.Artifacts)
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+}
+
+func TestRun_BadOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"no input", Options{DryRun: true}},
+		{"no dsn", Options{Driver: POSTGRES, DryRun: true}},
+		{"bad driver", Options{Driver: "oracle", DryRun: true}},
+		{"no target", Options{Input: strings.NewReader(testDump)}},
+		{"schema and data only", Options{Input: strings.NewReader(testDump), DryRun: true, SchemaOnly: true, DataOnly: true}},
+		{"data only dry run", Options{Input: strings.NewReader(testDump), DryRun: true, DataOnly: true}},
+		{"batch too big", Options{Input: strings.NewReader(testDump), DryRun: true, BatchBytes: 200 * 1000 * 1000}},
+		{"checkpoint dry run", Options{Input: strings.NewReader(testDump), DryRun: true, CheckpointFile: "checkpoint.json"}},
+		{"resume without checkpoint", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "d", DataOnly: true, Resume: true}},
+		{"resume without data only", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "d", CheckpointFile: "checkpoint.json", Resume: true}},
+		{"negative bad rows limit", Options{Input: strings.NewReader(testDump), DryRun: true, BadRowsFile: "bad.jsonl", BadRowsLimit: -1}},
+		{"negative bad value samples", Options{Input: strings.NewReader(testDump), DryRun: true, BadValueSamples: -1}},
+		{"negative row limit", Options{Input: strings.NewReader(testDump), DryRun: true, Sampling: internal.RowSampling{Limit: -1}}},
+		{"bad sample percent", Options{Input: strings.NewReader(testDump), DryRun: true, Sampling: internal.RowSampling{Percent: 101}}},
+		{"sampling with checkpoint", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "d", CheckpointFile: "checkpoint.json", Sampling: internal.RowSampling{Limit: 1}}},
+		{"verify dry run", Options{Input: strings.NewReader(testDump), DryRun: true, Verify: true}},
+		{"verify schema only", Options{Input: strings.NewReader(testDump), SchemaOnly: true, Verify: true}},
+		{"avro dry run", Options{Input: strings.NewReader(testDump), DryRun: true, AvroDir: "avro"}},
+		{"validate ddl data only", Options{Input: strings.NewReader(testDump), DataOnly: true, ValidateDDL: ValidateDDLInstance, Project: "p", Instance: "i", DBName: "d"}},
+		{"validate ddl without instance", Options{Input: strings.NewReader(testDump), SchemaOnly: true, ValidateDDL: ValidateDDLInstance}},
+		{"validate ddl no emulator address", Options{Input: strings.NewReader(testDump), SchemaOnly: true, ValidateDDL: "emulator:"}},
+		{"validate ddl bad target", Options{Input: strings.NewReader(testDump), SchemaOnly: true, ValidateDDL: "cloud"}},
+		{"validate ddl postgresql dialect", Options{Input: strings.NewReader(testDump), SchemaOnly: true, ValidateDDL: "emulator:localhost:9010", Dialect: DialectPostgreSQL}},
+		{"existing db data only", Options{Input: strings.NewReader(testDump), ExistingDB: true, DataOnly: true, Project: "p", Instance: "i", DBName: "d"}},
+		{"existing db dry run", Options{Input: strings.NewReader(testDump), ExistingDB: true, DryRun: true, DBName: "d"}},
+		{"existing db defer indexes", Options{Input: strings.NewReader(testDump), ExistingDB: true, DeferIndexes: true, Project: "p", Instance: "i", DBName: "d"}},
+		{"bad database name", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "Orders"}},
+		{"bad database name collision policy", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "orders", DBCollision: "overwrite"}},
+		{"create instance schema only", Options{Input: strings.NewReader(testDump), SchemaOnly: true, CreateInstance: &InstanceSpec{Config: "regional-us-central1"}}},
+		{"create instance emulator", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "orders", Endpoint: "localhost:9010", CreateInstance: &InstanceSpec{Config: "regional-us-central1"}}},
+		{"create instance bad capacity", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "orders", CreateInstance: &InstanceSpec{Config: "regional-us-central1", ProcessingUnits: 150}}},
+		{"negative read workers", Options{Input: strings.NewReader(testDump), SchemaOnly: true, ReadWorkers: -1}},
+		{"read workers for dump", Options{Input: strings.NewReader(testDump), SchemaOnly: true, ReadWorkers: 4}},
+		{"schemas for dump", Options{Input: strings.NewReader(testDump), SchemaOnly: true, Schemas: []string{"audit"}}},
+		{"dynamodb without table", Options{Driver: DYNAMODB, Input: strings.NewReader(""), SchemaOnly: true}},
+		{"dynamodb options for dump", Options{Input: strings.NewReader(testDump), SchemaOnly: true, DynamoDB: DynamoDBExport{Table: "t"}}},
+		{"csv without schema", Options{Driver: CSV, SchemaOnly: true}},
+		{"csv schema for dump", Options{Input: strings.NewReader(testDump), SchemaOnly: true, CSV: &CSVSchema{}}},
+		{"csv checkpoint", Options{Driver: CSV, CSV: &CSVSchema{}, CheckpointFile: "c", Project: "p", Instance: "i", DBName: "d"}},
+		{"sqlserver without dsn", Options{Driver: SQLSERVER, SchemaOnly: true}},
+		{"sqlserver checkpoint", Options{Driver: SQLSERVER, DSN: "sqlserver://h", CheckpointFile: "c", Project: "p", Instance: "i", DBName: "d"}},
+		{"negative fetch size", Options{Driver: POSTGRES, DSN: "dbname=d", SchemaOnly: true, FetchSize: -1}},
+		{"input and inputs", Options{Input: strings.NewReader(testDump), Inputs: []NamedInput{{Name: "a", Input: strings.NewReader(testDump)}}, DryRun: true}},
+		{"inputs without names", Options{Inputs: []NamedInput{{Input: strings.NewReader(testDump)}}, DryRun: true}},
+		{"inputs with same name", Options{Inputs: []NamedInput{{Name: "a", Input: strings.NewReader(testDump)}, {Name: "a", Input: strings.NewReader(testDump)}}, DryRun: true}},
+		{"dynamodb inputs", Options{Driver: DYNAMODB, Input: strings.NewReader(""), Inputs: []NamedInput{{Name: "a", Input: strings.NewReader("")}}, DynamoDB: DynamoDBExport{Table: "t", Key: []string{"id"}}, DryRun: true}},
+		{"avro data only", Options{Input: strings.NewReader(testDump), DataOnly: true, AvroDir: "avro", Project: "p", Instance: "i", DBName: "d"}},
+		{"avro verify", Options{Input: strings.NewReader(testDump), Verify: true, AvroDir: "avro"}},
+		{"postgresql dialect dry run", Options{Input: strings.NewReader(testDump), DryRun: true, Dialect: DialectPostgreSQL}},
+		{"defer indexes dry run", Options{Input: strings.NewReader(testDump), DryRun: true, DeferIndexes: true}},
+		{"defer indexes data only", Options{Input: strings.NewReader(testDump), DataOnly: true, DeferIndexes: true, Project: "p", Instance: "i", DBName: "d"}},
+		{"negative index batch", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "d", IndexBatch: -1}},
+		{"negative ddl batch", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "d", DDLBatch: -1}},
+		{"negative ddl resume", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "d", DDLResumeFrom: -1}},
+		{"ddl resume dry run", Options{Input: strings.NewReader(testDump), DryRun: true, DDLResumeFrom: 2}},
+		{"ddl resume data only", Options{Input: strings.NewReader(testDump), Project: "p", Instance: "i", DBName: "d", DataOnly: true, DDLResumeFrom: 2}},
+		{"bad write priority", Options{Input: strings.NewReader(testDump), DryRun: true, WritePriority: "URGENT"}},
+		{"bad write tag", Options{Input: strings.NewReader(testDump), DryRun: true, WriteTag: "tag\n"}},
+	}
+	for _, tc := range tests {
+		_, _, err := Run(context.Background(), tc.opts)
+		assert.NotNil(t, err, tc.name)
+	}
+}
+
+func TestRun_RowSampling(t *testing.T) {
+	// The first row converts cleanly, and the second doesn't.
+	_, res, err := Run(context.Background(), Options{
+		Input:    strings.NewReader(testDump),
+		DryRun:   true,
+		Sampling: internal.RowSampling{Limit: 1},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.RowsWritten)
+	assert.Equal(t, internal.RatingExcellent, res.Ratings.Data)
+	assert.Contains(t, res.Summary, "Row sampling: 1 of 2 rows were converted (first 1 rows of each table).")
+}
+
+func TestRun_BadRowsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "bad.jsonl")
+	_, res, err := Run(context.Background(), Options{
+		Input:       strings.NewReader(testDump),
+		DryRun:      true,
+		BadRowsFile: name,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Artifacts))
+	info, err := os.Stat(name)
+	assert.Nil(t, err)
+	assert.Equal(t, Artifact{Name: BadRowsArtifact, Path: name, Bytes: info.Size()}, res.Artifacts[0])
+	b, err := ioutil.ReadFile(name)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(b), "\n"))
+	assert.Contains(t, string(b), `"table":"t","reason":"parse"`)
+	assert.Contains(t, string(b), `"values":["2","bar","not-a-number"]`)
+	assert.Contains(t, res.Summary, "Bad rows written to "+name+": 1.")
+}
+
+func TestRun_BadValueSamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	example := `1 int4 parse error (column 'c') e.g. "not-a-number" (see`
+	for _, noSamples := range []bool{false, true} {
+		prefix := filepath.Join(dir, fmt.Sprintf("%t.", noSamples))
+		_, _, err := Run(context.Background(), Options{
+			Input:         strings.NewReader(testDump),
+			DryRun:        true,
+			FilePrefix:    prefix,
+			TextReport:    true,
+			NoDataSamples: noSamples,
+		})
+		assert.Nil(t, err)
+		report, err := ioutil.ReadFile(prefix + ReportFile)
+		assert.Nil(t, err)
+		if noSamples {
+			assert.NotContains(t, string(report), "Examples of data conversion problems")
+		} else {
+			assert.Contains(t, string(report), "Examples of data conversion problems\n1) "+example)
+		}
+	}
+}
+
+func TestRun_Avro(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	avroDir := filepath.Join(dir, "avro")
+	prefix := filepath.Join(dir, "out.")
+	_, res, err := Run(context.Background(), Options{
+		Input:      strings.NewReader(testDump),
+		AvroDir:    avroDir,
+		FilePrefix: prefix,
+		TextReport: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "", res.Database)
+	assert.Equal(t, int64(1), res.RowsWritten)
+	assert.Equal(t, "POOR (50% of 2 rows written to Avro files)", res.Ratings.DataDesc)
+	var names []string
+	for _, a := range res.Artifacts {
+		names = append(names, a.Name)
+	}
+	assert.Equal(t, []string{SchemaFile, AvroArtifact, BadDataFile, ReportFile}, names)
+	assert.Equal(t, filepath.Join(avroDir, "t.avro"), res.Artifacts[1].Path)
+	b, err := ioutil.ReadFile(res.Artifacts[1].Path)
+	assert.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(b, []byte("Obj\x01")))
+	report, err := ioutil.ReadFile(prefix + ReportFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(report), "Generated at ")
+	assert.Contains(t, string(report), "(Avro files in "+avroDir+")")
+	assert.NotContains(t, string(report), "written to Spanner")
+}
+
+func TestClientOptions(t *testing.T) {
+	env, set := os.LookupEnv("SPANNER_EMULATOR_HOST")
+	defer func() {
+		if set {
+			os.Setenv("SPANNER_EMULATOR_HOST", env)
+		} else {
+			os.Unsetenv("SPANNER_EMULATOR_HOST")
+		}
+	}()
+	os.Unsetenv("SPANNER_EMULATOR_HOST")
+	o := Options{ClientOptions: []option.ClientOption{option.WithUserAgent("test")}}
+	assert.Equal(t, "", o.emulatorHost())
+	assert.Equal(t, 1, len(clientOptions(o)))
+	os.Setenv("SPANNER_EMULATOR_HOST", "localhost:9010")
+	assert.Equal(t, "localhost:9010", o.emulatorHost())
+	assert.Equal(t, 4, len(clientOptions(o)))
+	// Endpoint takes precedence over the environment.
+	o.Endpoint = "emulator:9010"
+	assert.Equal(t, "emulator:9010", o.emulatorHost())
+}
+
+func TestApplyOptions(t *testing.T) {
+	for _, tc := range []struct {
+		opts Options
+		want int
+	}{
+		{Options{}, 0},
+		{Options{WritePriority: "low"}, 1},
+		{Options{WritePriority: WritePriorityHigh, WriteTag: "harbourbridge-import"}, 2},
+		{Options{WriteTag: "harbourbridge-import"}, 1},
+	} {
+		r := &runner{opts: tc.opts}
+		assert.Equal(t, tc.want, len(r.applyOptions()), tc.opts)
+	}
+}
+
+func TestDumpHash(t *testing.T) {
+	r := &runner{opts: Options{Driver: PGDUMP, CheckpointFile: "checkpoint.json"}, inputs: []*dumpInput{{in: strings.NewReader(testDump)}}, log: nopLogger{}}
+	_, err := r.schemaConv(context.Background())
+	assert.Nil(t, err)
+	h := sha256.Sum256([]byte(testDump))
+	assert.Equal(t, "sha256:"+hex.EncodeToString(h[:]), r.dumpHash)
+}
+
+func TestRun_SchemaOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	prefix := filepath.Join(dir, "schema.")
+	// No project, instance or database is needed.
+	conv, res, err := Run(context.Background(), Options{
+		Input:      strings.NewReader(testDump),
+		SchemaOnly: true,
+		FilePrefix: prefix,
+		TextReport: true,
+		Now:        time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), res.RowsWritten)
+	assert.Equal(t, internal.RatingSkipped, res.Ratings.Data)
+	assert.Equal(t, "SKIPPED (data conversion not run)", res.Ratings.DataDesc)
+	assert.NotEmpty(t, conv.GetDDL(ddl.Config{}))
+	var names []string
+	for _, a := range res.Artifacts {
+		names = append(names, a.Name)
+	}
+	assert.Equal(t, []string{SchemaFile, ReportFile}, names)
+	report, err := ioutil.ReadFile(prefix + ReportFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(report), "Generated at 2020-01-02 03:04:05 for db (schema only)")
+	assert.Contains(t, string(report), "Data conversion: SKIPPED (data conversion not run).")
+}
+
+func TestRun_OutDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	_, res, err := Run(context.Background(), Options{
+		Input:      strings.NewReader(testDump),
+		SchemaOnly: true,
+		OutDir:     out,
+		TextReport: true,
+	})
+	assert.Nil(t, err)
+	var names []string
+	for _, a := range res.Artifacts {
+		names = append(names, a.Name)
+	}
+	assert.Equal(t, []string{SchemaFile, filepath.Join(TableSchemaDir, "t.ddl"), filepath.Join(TableReportDir, "t.txt"), ReportFile}, names)
+	schema, err := ioutil.ReadFile(filepath.Join(out, TableSchemaDir, "t.ddl"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(schema), "CREATE TABLE t (")
+	table, err := ioutil.ReadFile(filepath.Join(out, TableReportDir, "t.txt"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(table), "Table t\n")
+	report, err := ioutil.ReadFile(filepath.Join(out, ReportFile))
+	assert.Nil(t, err)
+	assert.Contains(t, string(report), "(see "+filepath.Join(TableReportDir, "t.txt")+")")
+	assert.NotContains(t, string(report), "Table t\n")
+}
+
+func TestSafeFileNames(t *testing.T) {
+	names := []string{"users", "a/b", "Users", "con", "Con.x", ".hidden", "", "naïve", "users_2"}
+	assert.Equal(t, map[string]string{
+		"users":   "users",
+		"a/b":     "a_b",
+		"Users":   "Users_2",
+		"con":     "_con",
+		"Con.x":   "_Con.x",
+		".hidden": "_.hidden",
+		"":        "_",
+		"naïve":   "na_ve",
+		"users_2": "users_2_2",
+	}, safeFileNames(names))
+}
+
+// interruptingReader cancels a context when it is rewound for the
+// third time after being read: the first rewind follows the check for
+// compression, the second the check of the input's format, and the
+// third is at the start of data conversion.
+type interruptingReader struct {
+	*strings.Reader
+	cancel  func()
+	read    bool
+	rewinds int
+}
+
+func (r *interruptingReader) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
+
+func (r *interruptingReader) Seek(offset int64, whence int) (int64, error) {
+	if r.read && offset == 0 && whence == io.SeekStart {
+		r.read = false
+		r.rewinds++
+		if r.rewinds == 3 {
+			r.cancel()
+		}
+	}
+	return r.Reader.Seek(offset, whence)
+}
+
+func TestRun_Interrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, tc := range []struct {
+		name    string
+		early   bool // Interrupted before Run.
+		db      string
+		summary string
+	}{
+		{"early", true, "db (interrupted)", "CONVERSION INTERRUPTED: the conversion stopped before any data rows were read.\n"},
+		{"data", false, "db (dry run) (interrupted)", "CONVERSION INTERRUPTED: the conversion stopped before any data rows were read. Rows not read, and left out of this report: 2.\n"},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var in io.Reader = &interruptingReader{Reader: strings.NewReader(testDump), cancel: cancel}
+		if tc.early {
+			cancel()
+			in = strings.NewReader(testDump)
+		}
+		prefix := filepath.Join(dir, tc.name+".")
+		conv, res, err := Run(ctx, Options{
+			Input:      in,
+			DryRun:     true,
+			FilePrefix: prefix,
+			TextReport: true,
+			Now:        time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		})
+		cancel()
+		assert.Equal(t, ErrInterrupted, err, tc.name)
+		assert.True(t, conv.Interrupted(), tc.name)
+		assert.True(t, strings.HasPrefix(res.Summary, tc.summary), tc.name+": "+res.Summary)
+		report, err := ioutil.ReadFile(prefix + ReportFile)
+		assert.Nil(t, err)
+		assert.Contains(t, string(report), "Generated at 2020-01-02 03:04:05 for "+tc.db+"\n", tc.name)
+	}
+}
+
+func TestRun_DataOnlySession(t *testing.T) {
+	var buf bytes.Buffer
+	_, _, err := Run(context.Background(), Options{Input: strings.NewReader(testDump), SchemaOnly: true, WriteSession: &buf})
+	assert.Nil(t, err)
+	s, err := internal.ReadSession(&buf)
+	assert.Nil(t, err)
+	// A data-only dry run uses the session for the schema, and doesn't
+	// write the schema file.
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	_, res, err := Run(context.Background(), Options{
+		Input:      strings.NewReader(testDump),
+		DataOnly:   true,
+		DryRun:     true,
+		Session:    s,
+		FilePrefix: filepath.Join(dir, "data."),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res.RowsWritten)
+	assert.Equal(t, internal.RatingPoor, res.Ratings.Data)
+	_, err = os.Stat(filepath.Join(dir, "data."+SchemaFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRun_Session(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	_, _, err = Run(context.Background(), Options{Input: strings.NewReader(testDump), DryRun: true, WriteSession: &buf})
+	assert.Nil(t, err)
+	s, err := internal.ReadSession(&buf)
+	assert.Nil(t, err)
+
+	// Hand-edit the session: rename column b, and map c to a string so
+	// that the row with "not-a-number" converts.
+	cols := s.Tables[0].Spanner.Columns
+	assert.Equal(t, "b", cols[1].Name)
+	cols[1].Name = "name"
+	cols[2].Type = "STRING(MAX)"
+	conv, res, err := Run(context.Background(), Options{Input: strings.NewReader(testDump), DryRun: true, Session: s})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), conv.BadRows())
+	assert.Equal(t, int64(2), res.RowsWritten)
+	stmts := strings.Join(conv.GetDDL(ddl.Config{}), "\n")
+	assert.Contains(t, stmts, "name STRING(MAX)")
+	assert.Contains(t, stmts, "c STRING(MAX)")
+
+	// The session doesn't match a dump with a different table.
+	prefix := filepath.Join(dir, "mismatch.")
+	_, _, err = Run(context.Background(), Options{
+		Input:      strings.NewReader(strings.Replace(testDump, "TABLE t ", "TABLE u ", 1)),
+		DryRun:     true,
+		Session:    s,
+		FilePrefix: prefix,
+		TextReport: true,
+	})
+	assert.NotNil(t, err)
+	report, err := ioutil.ReadFile(prefix + ReportFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(report), "Schema Mismatch")
+	assert.Contains(t, string(report), "Table t is in the session, but not in the source database.")
+}
+
+func TestApplyDDL(t *testing.T) {
+	conv, _, err := Run(context.Background(), Options{
+		Input:      strings.NewReader("CREATE TABLE t (a bigint PRIMARY KEY, b text, c int4);\nCREATE INDEX b_idx ON t (b);\n"),
+		SchemaOnly: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"CREATE INDEX b_idx ON t (b)"}, conv.GetIndexDDL(ddl.Config{}))
+	assert.Equal(t, 1, len(conv.GetTableDDL(ddl.Config{})))
+
+	// Statements containing "bad" fail, and the statements after them
+	// in their batch aren't applied.
+	var batches [][]string
+	update := func(ctx context.Context, stmts []string, progress func(n int)) (int, error) {
+		batches = append(batches, stmts)
+		for i, s := range stmts {
+			if strings.Contains(s, "bad") {
+				return i, fmt.Errorf("can't apply %s", s)
+			}
+			progress(i + 1)
+		}
+		return len(stmts), nil
+	}
+	stmts := []string{"s1", "bad2", "s3", "s4", "bad5", "bad6", "s7"}
+	var out bytes.Buffer
+	p := internal.NewProgressWriter(int64(len(stmts)), "Creating indexes", false, &out)
+	applyDDL(context.Background(), stmts, 3, p, update, conv.AddAppliedDDL)
+	assert.Equal(t, [][]string{{"s1", "bad2", "s3"}, {"s3", "s4", "bad5"}, {"bad6", "s7"}, {"s7"}}, batches)
+	assert.Equal(t, 3, conv.DDLFailures())
+	assert.True(t, strings.HasSuffix(out.String(), "100%\n"))
+}
+
+func TestBatchDDL(t *testing.T) {
+	stmts := []string{"aaaa", "bb", "cccccccc", "d", "e", "f"}
+	assert.Equal(t, [][]string{{"aaaa", "bb"}, {"cccccccc"}, {"d", "e"}, {"f"}}, batchDDL(stmts, 2, 6))
+	assert.Equal(t, [][]string{{"aaaa", "bb", "cccccccc", "d", "e", "f"}}, batchDDL(stmts, 100, 100))
+	assert.Nil(t, batchDDL(nil, 100, 100))
+}
+
+func TestDDLObjects(t *testing.T) {
+	assert.Equal(t, "TABLE singers", ddlObject("CREATE TABLE `Singers` (\n    `a` INT64\n) PRIMARY KEY (`a`)"))
+	assert.Equal(t, "INDEX b_idx", ddlObject("CREATE UNIQUE NULL_FILTERED INDEX `b_idx` ON `t` (`b`)"))
+	assert.Equal(t, "CONSTRAINT fk", ddlObject("ALTER TABLE `t` ADD CONSTRAINT `fk` FOREIGN KEY (`a`) REFERENCES `u` (`a`)"))
+	assert.Equal(t, "", ddlObject("ALTER TABLE `t` ADD FOREIGN KEY (`a`) REFERENCES `u` (`a`)"))
+	// Spanner returns foreign keys as part of CREATE TABLE statements.
+	assert.Equal(t, map[string]bool{"TABLE t": true, "CONSTRAINT fk": true, "INDEX b_idx": true}, ddlObjects([]string{
+		"CREATE TABLE t (\n  a INT64,\n  CONSTRAINT fk FOREIGN KEY(a) REFERENCES u(a),\n) PRIMARY KEY(a)",
+		"CREATE INDEX b_idx ON t(b)",
+	}))
+}
+
+func TestApplySchema(t *testing.T) {
+	conv := internal.MakeConv()
+	batches := [][]string{{"CREATE TABLE a", "CREATE TABLE b"}, {"CREATE TABLE c", "CREATE TABLE bad", "CREATE TABLE d"}, {"CREATE TABLE e"}}
+	var applied []string
+	update := func(ctx context.Context, stmts []string, progress func(n int)) (int, error) {
+		for i, s := range stmts {
+			if s == "CREATE TABLE bad" {
+				return i, fmt.Errorf("can't apply %s", s)
+			}
+			applied = append(applied, s)
+		}
+		return len(stmts), nil
+	}
+	err := applySchema(context.Background(), conv, batches, 1, nil, update, nopLogger{})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, conv.DDLBatchFailed())
+	assert.Equal(t, []string{"CREATE TABLE a", "CREATE TABLE b", "CREATE TABLE c"}, applied)
+
+	// Resuming from the failed batch skips statements the database
+	// already has.
+	conv = internal.MakeConv()
+	applied = nil
+	batches[1][1] = "CREATE TABLE fixed"
+	existing := ddlObjects([]string{"CREATE TABLE a", "CREATE TABLE b", "CREATE TABLE c"})
+	assert.Nil(t, applySchema(context.Background(), conv, batches, 2, existing, update, nopLogger{}))
+	assert.Equal(t, 0, conv.DDLBatchFailed())
+	assert.Equal(t, []string{"CREATE TABLE fixed", "CREATE TABLE d", "CREATE TABLE e"}, applied)
+}
+
+func TestRun_Transforms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversion")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	prefix := filepath.Join(dir, "ok.")
+	_, _, err = Run(context.Background(), Options{
+		Input:      strings.NewReader(testDump),
+		DryRun:     true,
+		FilePrefix: prefix,
+		TextReport: true,
+		Transforms: ColumnTransforms{"t.b": "hash-sha256"},
+	})
+	assert.Nil(t, err)
+	report, err := ioutil.ReadFile(prefix + ReportFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(report), "Column values are transformed during data conversion: 'b' (hash-sha256).")
+	// Transforms of columns that don't exist fail before any data is
+	// converted.
+	conv, _, err := Run(context.Background(), Options{
+		Input:      strings.NewReader(testDump),
+		DryRun:     true,
+		Transforms: ColumnTransforms{"t.x": "null"},
+	})
+	assert.EqualError(t, err, "bad column transform for t.x: no such column: x")
+	assert.Nil(t, conv)
+}
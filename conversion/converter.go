@@ -0,0 +1,255 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	sp "cloud.google.com/go/spanner"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+)
+
+// Converter runs a conversion step by step, for programs that need
+// more control than Run gives e.g. to check the Spanner schema before
+// writing data with their own Spanner client. Run uses the same steps.
+// A typical use is:
+//
+//	c, err := conversion.NewConv(opts)
+//	...
+//	defer c.Close()
+//	if err := c.ProcessPgDump(dump); err != nil {
+//		...
+//	}
+//	stmts := c.SchemaToDDL() // Create a Spanner database with stmts.
+//	if err := c.WriteData(ctx, client); err != nil {
+//		...
+//	}
+//	report := c.Report()
+//
+// Unlike Run, a Converter doesn't create Spanner databases or write
+// the schema and report files.
+type Converter struct {
+	r            *runner
+	conv         *internal.Conv // Nil until schema conversion is done.
+	bw           dataWriter     // Nil until data conversion is done.
+	badRowsBytes int64          // Size of the bad-rows file (see Options.BadRowsFile).
+	cleanup      func()         // Removes temporary copies of inputs.
+
+	sessionMismatch bool // Options.Session doesn't match the source schema.
+}
+
+var (
+	errNoSchema   = errors.New("schema hasn't been converted")
+	errSchemaDone = errors.New("schema has already been converted")
+	errDataDone   = errors.New("data has already been converted")
+)
+
+// NewConv returns a Converter for pg_dump output, to be given to
+// ProcessPgDump. Options.Driver must be empty or PGDUMP, and
+// Options.Input and Options.Inputs must not be set; other options are
+// validated as for Run.
+func NewConv(opts Options) (*Converter, error) {
+	if opts.Driver != "" && opts.Driver != PGDUMP {
+		return nil, fmt.Errorf("driver %s not supported by Converter: use Run", opts.Driver)
+	}
+	if opts.Input != nil || len(opts.Inputs) > 0 {
+		return nil, fmt.Errorf("input is given to ProcessPgDump, not Options")
+	}
+	r := newRunner(opts)
+	// ProcessPgDump rejects mysqldump output, rather than converting it.
+	r.detectDriver = false
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	return &Converter{r: r}, nil
+}
+
+// ProcessPgDump runs schema conversion of pg_dump output in, which may
+// be gzipped. If in isn't seekable, it is copied to a temporary file
+// (see Close), since data conversion reads it again.
+func (c *Converter) ProcessPgDump(in io.Reader) error {
+	if c.conv != nil {
+		return errSchemaDone
+	}
+	c.r.opts.Input = in
+	if err := c.r.validateInput(); err != nil {
+		return err
+	}
+	return c.processSchema(context.Background())
+}
+
+// processSchema runs schema conversion of the source described by the
+// options, and applies the options that modify the Spanner schema or
+// configure data conversion. If ctx is canceled, the options aren't
+// applied, since the schema may be incomplete.
+func (c *Converter) processSchema(ctx context.Context) error {
+	r := c.r
+	if r.fromDump() {
+		cleanup, err := r.openInputs()
+		if err != nil {
+			return err
+		}
+		c.cleanup = cleanup
+		if err := r.detectFormat(); err != nil {
+			return err
+		}
+	}
+	conv, err := r.schemaConv(ctx)
+	if err != nil {
+		return err
+	}
+	c.conv = conv
+	if ctx.Err() != nil {
+		return nil
+	}
+	o := r.opts
+	if o.Session != nil {
+		if err := conv.ApplySession(o.Session); err != nil {
+			c.sessionMismatch = true
+			return err
+		}
+	}
+	if err := conv.ApplyTableOptions(o.TableOptions, o.Now); err != nil {
+		return err
+	}
+	if err := conv.SetColumnTransforms(o.Transforms); err != nil {
+		return err
+	}
+	if o.ColumnStats {
+		conv.EnableColumnStats()
+	}
+	if o.ShowMappings {
+		conv.EnableColumnMappings()
+	}
+	if o.PIIKeyCheck {
+		conv.EnablePIIKeyCheck()
+	}
+	if !o.NoDataSamples {
+		conv.SetBadValueSamples(int(defaultInt64(o.BadValueSamples, DefaultBadValueSamples)))
+	}
+	return nil
+}
+
+// SchemaToDDL returns the Spanner schema as the DDL statements used to
+// create the Spanner database.
+func (c *Converter) SchemaToDDL() []string {
+	if c.conv == nil {
+		return nil
+	}
+	return schemaToDDL(c.conv)
+}
+
+// schemaToDDL returns the Spanner schema of conv, as sent to Spanner.
+func schemaToDDL(conv *internal.Conv) []string {
+	// The schema we send to Spanner excludes comments (since Cloud
+	// Spanner DDL doesn't accept them), and protects table and col names
+	// using backticks (to avoid any issues with Spanner reserved words).
+	return conv.GetDDL(spannerDDLConfig)
+}
+
+// WriteData runs data conversion, writing data to the Spanner database
+// of client, which must have the schema given by SchemaToDDL. For dry
+// runs (Options.DryRun) and Avro files (Options.AvroDir), client must
+// be nil. If ctx is canceled, WriteData stops and returns
+// ErrInterrupted; Report then describes the rows converted until then.
+func (c *Converter) WriteData(ctx context.Context, client *sp.Client) error {
+	r, conv := c.r, c.conv
+	if conv == nil {
+		return errNoSchema
+	}
+	if c.bw != nil {
+		return errDataDone
+	}
+	if (client == nil) != (r.opts.DryRun || r.opts.AvroDir != "") {
+		return fmt.Errorf("a Spanner client is needed unless doing a dry run or writing Avro files, and not allowed otherwise")
+	}
+	if client != nil {
+		conv.SetWriteOptions(r.writePriority(), r.opts.WriteTag)
+	}
+	closeBadRows, err := r.openBadRows(conv)
+	if err != nil {
+		return err
+	}
+	if err := r.startCheckpoint(conv); err != nil {
+		closeBadRows()
+		return err
+	}
+	bw, err := r.dataConv(ctx, client, conv)
+	c.badRowsBytes = closeBadRows()
+	r.closeCheckpoint()
+	if err != nil {
+		return err
+	}
+	c.bw = bw
+	r.res.BadWrites = internal.BySourceTable(conv, bw.DroppedRowsByTable())
+	if r.checkpoint != nil {
+		for t, tc := range r.checkpoint.Resumed() {
+			if tc.BadWrites > 0 {
+				r.res.BadWrites[t] += tc.BadWrites
+			}
+		}
+	}
+	conv.AddTooLargeRows(internal.BySourceTable(conv, bw.TooLargeRowsByTable()))
+	conv.AddWriteErrors(bw.DroppedRowsByCode())
+	if ctx.Err() != nil {
+		// Rows abandoned by the writer weren't written, so they count
+		// as bad writes.
+		abandoned := map[string]int64{}
+		if a, ok := bw.(abandoner); ok {
+			abandoned = internal.BySourceTable(conv, a.AbandonedRowsByTable())
+		}
+		for t, n := range abandoned {
+			r.res.BadWrites[t] += n
+		}
+		conv.SetInterrupted(abandoned)
+		return ErrInterrupted
+	}
+	return nil
+}
+
+// Report returns the structured version of the report of the
+// conversion so far: the same information as the text and JSON
+// reports, with per-table ratings, issues and row stats. It returns
+// nil until ProcessPgDump has succeeded.
+func (c *Converter) Report() *Report {
+	if c.conv == nil {
+		return nil
+	}
+	return internal.BuildReport(c.r.source(), c.conv, c.r.res.BadWrites)
+}
+
+// Conv returns the state of the conversion, or nil until ProcessPgDump
+// has succeeded.
+func (c *Converter) Conv() *Conv {
+	return c.conv
+}
+
+// RowsWritten returns the number of rows written to Spanner or Avro
+// files (for dry runs, rows that would have been written).
+func (c *Converter) RowsWritten() int64 {
+	return c.r.res.RowsWritten
+}
+
+// Close removes any temporary copy of the input made by ProcessPgDump.
+func (c *Converter) Close() {
+	if c.cleanup != nil {
+		c.cleanup()
+		c.cleanup = nil
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// credentialsScope is the OAuth scope of impersonated credentials. It
+// covers both the Spanner admin and data APIs.
+const credentialsScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// credentialsKey is the part of a credentials JSON file (e.g. a service
+// account key) that we look at.
+type credentialsKey struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+}
+
+// readCredentialsKey reads the credentials JSON file f.
+func readCredentialsKey(f string) (credentialsKey, error) {
+	var k credentialsKey
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return k, fmt.Errorf("can't read credentials file: %w", err)
+	}
+	if err := json.Unmarshal(b, &k); err != nil {
+		return k, fmt.Errorf("can't parse credentials file %s: %w", f, err)
+	}
+	if k.Type == "" {
+		return k, fmt.Errorf("credentials file %s has no type: expected e.g. a service account key file", f)
+	}
+	return k, nil
+}
+
+// CredentialOptions returns the client options (see
+// Options.ClientOptions) for Spanner clients to authenticate with the
+// credentials file credentialsFile (e.g. a service account key file)
+// instead of Application Default Credentials, and to impersonate the
+// service account serviceAccount (an email address), if these are
+// non-empty. Impersonation needs the Service Account Token Creator role
+// on serviceAccount.
+func CredentialOptions(ctx context.Context, credentialsFile, serviceAccount string) ([]option.ClientOption, error) {
+	var base []option.ClientOption
+	if credentialsFile != "" {
+		if _, err := readCredentialsKey(credentialsFile); err != nil {
+			return nil, err
+		}
+		base = append(base, option.WithCredentialsFile(credentialsFile))
+	}
+	if serviceAccount == "" {
+		return base, nil
+	}
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: serviceAccount,
+		Scopes:          []string{credentialsScope},
+	}, base...)
+	if err != nil {
+		return nil, fmt.Errorf("can't impersonate service account %s: %w", serviceAccount, err)
+	}
+	return []option.ClientOption{option.WithTokenSource(ts)}, nil
+}
+
+// Principal describes who Spanner clients authenticate as with the
+// options of CredentialOptions(credentialsFile, serviceAccount) e.g.
+// "service account loader@p.iam.gserviceaccount.com". For Application
+// Default Credentials, the credentials file is
+// $GOOGLE_APPLICATION_CREDENTIALS (if set).
+func Principal(credentialsFile, serviceAccount string) string {
+	p := "application default credentials"
+	f := credentialsFile
+	if f == "" {
+		f = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if f != "" {
+		k, err := readCredentialsKey(f)
+		switch {
+		case err != nil:
+			p = "credentials from " + f
+		case k.ClientEmail != "":
+			p = "service account " + k.ClientEmail
+		case k.Type == "authorized_user":
+			p = "user credentials from " + f
+		default:
+			p = k.Type + " credentials from " + f
+		}
+	}
+	if serviceAccount != "" {
+		return fmt.Sprintf("service account %s (impersonated by %s)", serviceAccount, p)
+	}
+	return p
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCredentials(t *testing.T, dir, name, content string) string {
+	f := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(f, []byte(content), 0600))
+	return f
+}
+
+func TestCredentialOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	key := writeCredentials(t, dir, "key.json", `{"type": "service_account", "client_email": "loader@p.iam.gserviceaccount.com"}`)
+	opts, err := CredentialOptions(context.Background(), "", "")
+	assert.Nil(t, err)
+	assert.Empty(t, opts)
+	opts, err = CredentialOptions(context.Background(), key, "")
+	assert.Nil(t, err)
+	assert.Len(t, opts, 1)
+	for _, f := range []string{
+		filepath.Join(dir, "missing.json"),
+		writeCredentials(t, dir, "bad.json", `not json`),
+		writeCredentials(t, dir, "untyped.json", `{"client_email": "loader@p.iam.gserviceaccount.com"}`),
+	} {
+		_, err = CredentialOptions(context.Background(), f, "")
+		assert.NotNil(t, err, f)
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	key := writeCredentials(t, dir, "key.json", `{"type": "service_account", "client_email": "loader@p.iam.gserviceaccount.com"}`)
+	user := writeCredentials(t, dir, "user.json", `{"type": "authorized_user", "client_id": "x"}`)
+	old, set := os.LookupEnv("GOOGLE_APPLICATION_CREDENTIALS")
+	os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+	defer func() {
+		if set {
+			os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", old)
+		}
+	}()
+	tests := []struct {
+		file, serviceAccount, expected string
+	}{
+		{"", "", "application default credentials"},
+		{key, "", "service account loader@p.iam.gserviceaccount.com"},
+		{user, "", "user credentials from " + user},
+		{filepath.Join(dir, "missing.json"), "", "credentials from " + filepath.Join(dir, "missing.json")},
+		{"", "admin@p.iam.gserviceaccount.com", "service account admin@p.iam.gserviceaccount.com (impersonated by application default credentials)"},
+		{key, "admin@p.iam.gserviceaccount.com", "service account admin@p.iam.gserviceaccount.com (impersonated by service account loader@p.iam.gserviceaccount.com)"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, Principal(tc.file, tc.serviceAccount))
+	}
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", key)
+	assert.Equal(t, "service account loader@p.iam.gserviceaccount.com", Principal("", ""))
+	if !set {
+		os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+)
+
+// processCSV reads the files of the CSV tables (see Options.CSV),
+// counting rows in schema mode and converting them in data mode. If
+// p is non-nil, it gets the number of files read so far.
+func (r *runner) processCSV(ctx context.Context, conv *internal.Conv, p *internal.Progress) error {
+	var files int64
+	for _, t := range r.opts.CSV.Tables {
+		open := func(file string) (io.ReadCloser, error) {
+			files++
+			if p != nil {
+				p.MaybeReport(files)
+			}
+			return openCSVFile(ctx, file)
+		}
+		if err := internal.ProcessCSVTable(ctx, conv, t, open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvFileCount returns the number of files of the CSV tables.
+func (r *runner) csvFileCount() int64 {
+	var n int64
+	for _, t := range r.opts.CSV.Tables {
+		n += int64(len(t.Files))
+	}
+	return n
+}
+
+// openCSVFile opens a CSV file, which is either a local file name or a
+// Google Cloud Storage URL (see OpenDump), decompressing it if it is
+// gzipped.
+func openCSVFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	f, err := OpenDump(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	header, _ := br.Peek(len(zstdMagic)) // Errors are returned by reads.
+	switch detectCompression(header) {
+	case gzipped:
+		z, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("can't read gzip input: %w", err)
+		}
+		return gzipFile{z, f}, nil
+	case zstdCompressed:
+		f.Close()
+		return nil, errZstd
+	}
+	return readCloser{br, f}, nil
+}
+
+// readCloser reads a file via a buffered or decompressing Reader.
+type readCloser struct {
+	io.Reader
+	f io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.f.Close()
+}
+
+// gzipFile decompresses a gzipped file.
+type gzipFile struct {
+	z *gzip.Reader
+	f io.Closer
+}
+
+func (g gzipFile) Read(p []byte) (int, error) {
+	return g.z.Read(p)
+}
+
+func (g gzipFile) Close() error {
+	g.z.Close()
+	return g.f.Close()
+}
@@ -0,0 +1,487 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	sp "cloud.google.com/go/spanner"
+	database "cloud.google.com/go/spanner/admin/database/apiv1"
+	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	"google.golang.org/api/option"
+	adminpb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
+	instancepb "google.golang.org/genproto/googleapis/spanner/admin/instance/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// createDatabase creates a new Spanner database with the schema in conv,
+// and returns its full name. The schema is applied in batches (see
+// batchDDL), since the admin API limits the size of schema updates. If
+// a batch fails, the database is left with the statements applied so
+// far, and schema creation can be resumed from the failed batch (see
+// Options.DDLResumeFrom) once the problem is fixed.
+func createDatabase(ctx context.Context, o Options, conv *internal.Conv, log Logger) (string, error) {
+	opts := clientOptions(o)
+	if o.emulatorHost() != "" {
+		if err := createEmulatorInstance(ctx, o, opts, log); err != nil {
+			return "", err
+		}
+	}
+	adminClient, err := database.NewDatabaseAdminClient(ctx, opts...)
+	if err != nil {
+		return "", fmt.Errorf("can't create admin client: %w", AnalyzeError(err, o.Project, o.Instance))
+	}
+	defer adminClient.Close()
+	schema := schemaToDDL(conv)
+	if o.DeferIndexes {
+		schema = conv.GetTableDDL(spannerDDLConfig)
+	}
+	batches := batchDDL(schema, int(defaultInt64(o.DDLBatch, DefaultDDLBatch)), defaultInt64(o.DDLBatchBytes, DefaultDDLBatchBytes))
+	db := dbPath(o)
+	from := 1
+	var existing map[string]bool
+	if o.DDLResumeFrom > 0 {
+		if o.DDLResumeFrom > len(batches) {
+			return "", fmt.Errorf("can't resume schema creation from batch %d: the schema has %d batches", o.DDLResumeFrom, len(batches))
+		}
+		from = o.DDLResumeFrom
+		log.Printf("Resuming schema creation of database %s from batch %d of %d ...\n", o.DBName, from, len(batches))
+		resp, err := adminClient.GetDatabaseDdl(ctx, &adminpb.GetDatabaseDdlRequest{Database: db})
+		if err != nil {
+			return "", fmt.Errorf("can't read schema of db %s: %w", db, AnalyzeError(err, o.Project, o.Instance))
+		}
+		existing = ddlObjects(resp.Statements)
+	} else {
+		log.Printf("Creating new database %s in instance %s with default permissions ...\n", o.DBName, o.Instance)
+		op, err := adminClient.CreateDatabase(ctx, &adminpb.CreateDatabaseRequest{
+			Parent:          fmt.Sprintf("projects/%s/instances/%s", o.Project, o.Instance),
+			CreateStatement: "CREATE DATABASE `" + o.DBName + "`",
+		})
+		if err != nil {
+			return "", fmt.Errorf("can't build CreateDatabaseRequest: %w", AnalyzeError(err, o.Project, o.Instance))
+		}
+		if _, err := op.Wait(ctx); err != nil {
+			return "", fmt.Errorf("createDatabase call failed: %w", AnalyzeError(err, o.Project, o.Instance))
+		}
+	}
+	if err := applySchema(ctx, conv, batches, from, existing, updateDDL(adminClient, db), log); err != nil {
+		return "", AnalyzeError(err, o.Project, o.Instance)
+	}
+	log.Printf("Created database %s.\n", o.DBName)
+	return db, nil
+}
+
+// batchDDL splits stmts into batches of at most maxStmts statements
+// and maxBytes bytes (statements bigger than maxBytes get a batch of
+// their own), keeping them in order.
+func batchDDL(stmts []string, maxStmts int, maxBytes int64) [][]string {
+	var batches [][]string
+	var b []string
+	bytes := int64(0)
+	for _, s := range stmts {
+		if len(b) > 0 && (len(b) == maxStmts || bytes+int64(len(s)) > maxBytes) {
+			batches = append(batches, b)
+			b, bytes = nil, 0
+		}
+		b = append(b, s)
+		bytes += int64(len(s))
+	}
+	if len(b) > 0 {
+		batches = append(batches, b)
+	}
+	return batches
+}
+
+// applySchema applies batches of DDL statements in order, starting
+// with batch from (numbered from 1), using update. It stops at the
+// first batch that fails. Leading statements of batch from that create
+// objects in existing are skipped, since they were applied by a
+// previous run. Results are recorded in conv (see
+// internal.Conv.SetDDLBatches).
+func applySchema(ctx context.Context, conv *internal.Conv, batches [][]string, from int, existing map[string]bool, update ddlUpdater, log Logger) error {
+	conv.SetDDLBatches(batches, from)
+	for k := from; k <= len(batches); k++ {
+		stmts := batches[k-1]
+		previous := 0
+		if k == from {
+			for previous < len(stmts) && existing[ddlObject(stmts[previous])] {
+				previous++
+			}
+		}
+		if len(batches) > 1 {
+			log.Printf("Applying schema batch %d of %d ...\n", k, len(batches))
+		}
+		applied := 0
+		var err error
+		if previous < len(stmts) {
+			applied, err = update(ctx, stmts[previous:], func(int) {})
+		}
+		conv.SetDDLBatchResult(k, previous, applied, err)
+		if err != nil {
+			return fmt.Errorf("batch %d of %d of the schema failed, so the database is incomplete (see the DDL Application section of the report): %w", k, len(batches), err)
+		}
+	}
+	return nil
+}
+
+// ddlObjectRE matches the start of DDL statements that create tables,
+// indexes and foreign keys, giving the kind of object and its name.
+var ddlObjectRE = regexp.MustCompile("(?i)^\\s*(?:CREATE\\s+(TABLE)|CREATE\\s+(?:UNIQUE\\s+)?(?:NULL_FILTERED\\s+)?(INDEX)|ALTER\\s+TABLE\\s+\\S+\\s+ADD\\s+(CONSTRAINT))\\s+`?(\\w+)`?")
+
+// constraintRE matches named constraints, e.g. foreign keys in CREATE
+// TABLE statements.
+var constraintRE = regexp.MustCompile("(?i)\\bCONSTRAINT\\s+`?(\\w+)`?")
+
+// ddlObject returns the object created by DDL statement stmt e.g.
+// "TABLE singers", or "" if it isn't a table, index or named foreign
+// key. Names are lower case, since Spanner names are case insensitive.
+func ddlObject(stmt string) string {
+	m := ddlObjectRE.FindStringSubmatch(stmt)
+	if m == nil {
+		return ""
+	}
+	return strings.ToUpper(m[1]+m[2]+m[3]) + " " + strings.ToLower(m[4])
+}
+
+// ddlObjects returns the objects created by DDL statements stmts (see
+// ddlObject), including constraints declared in CREATE TABLE
+// statements, which is how Spanner returns foreign keys.
+func ddlObjects(stmts []string) map[string]bool {
+	objects := make(map[string]bool)
+	for _, s := range stmts {
+		if o := ddlObject(s); o != "" {
+			objects[o] = true
+		}
+		for _, m := range constraintRE.FindAllStringSubmatch(s, -1) {
+			objects["CONSTRAINT "+strings.ToLower(m[1])] = true
+		}
+	}
+	return objects
+}
+
+// spannerDDLConfig is the configuration of DDL sent to Spanner.
+var spannerDDLConfig = ddl.Config{Comments: false, ProtectIds: true}
+
+// ddlPollInterval is how often the progress of DDL operations is checked.
+const ddlPollInterval = 10 * time.Second
+
+// createIndexes creates the secondary indexes of conv in database db,
+// for conversions that defer them until data is loaded (see
+// Options.DeferIndexes). Statements are applied in batches, and are
+// recorded in conv (see internal.Conv.AddAppliedDDL); statements that
+// fail don't stop the rest.
+func createIndexes(ctx context.Context, o Options, conv *internal.Conv, db string, log Logger) error {
+	stmts := conv.GetIndexDDL(spannerDDLConfig)
+	if len(stmts) == 0 {
+		return nil
+	}
+	adminClient, err := database.NewDatabaseAdminClient(ctx, clientOptions(o)...)
+	if err != nil {
+		return fmt.Errorf("can't create admin client: %w", AnalyzeError(err, o.Project, o.Instance))
+	}
+	defer adminClient.Close()
+	log.Printf("Creating %d indexes (backfilling them can take a while for big tables) ...\n", len(stmts))
+	p := internal.NewProgressWriter(int64(len(stmts)), "Creating indexes", internal.Verbose(), o.Progress)
+	applyDDL(ctx, stmts, int(defaultInt64(o.IndexBatch, DefaultIndexBatch)), p, updateDDL(adminClient, db), conv.AddAppliedDDL)
+	p.Done()
+	if n := conv.DDLFailures(); n > 0 {
+		log.Printf("Failed to create %d of %d indexes (see the report).\n", n, len(stmts))
+	}
+	return nil
+}
+
+// validateSchema validates the schema of conv by applying it to a
+// throwaway database (see Options.ValidateDDL), which is dropped
+// afterwards. Statements that Spanner rejects are recorded in conv
+// (see internal.Conv.AddValidatedDDL); the returned error is for
+// failures to create the throwaway database.
+func validateSchema(ctx context.Context, o Options, conv *internal.Conv, log Logger) error {
+	// The throwaway database is in the target instance, or in an
+	// instance of the emulator at ValidateDDL.
+	vo := o
+	where := fmt.Sprintf("a temporary database in instance %s", o.Instance)
+	if host := strings.TrimPrefix(o.ValidateDDL, ValidateDDLEmulator); host != o.ValidateDDL {
+		vo = Options{Project: validateProject, Instance: validateInstance, Endpoint: host}
+		where = fmt.Sprintf("a temporary database in the Spanner emulator at %s", host)
+	}
+	opts := clientOptions(vo)
+	if vo.emulatorHost() != "" {
+		if err := createEmulatorInstance(ctx, vo, opts, log); err != nil {
+			return err
+		}
+	}
+	adminClient, err := database.NewDatabaseAdminClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("can't create admin client: %w", AnalyzeError(err, vo.Project, vo.Instance))
+	}
+	defer adminClient.Close()
+	vo.DBName = fmt.Sprintf("hb-validate-%x", time.Now().UnixNano())
+	log.Printf("Validating the schema in %s ...\n", where)
+	op, err := adminClient.CreateDatabase(ctx, &adminpb.CreateDatabaseRequest{
+		Parent:          fmt.Sprintf("projects/%s/instances/%s", vo.Project, vo.Instance),
+		CreateStatement: "CREATE DATABASE `" + vo.DBName + "`",
+	})
+	if err == nil {
+		_, err = op.Wait(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("can't create database %s to validate the schema: %w", vo.DBName, AnalyzeError(err, vo.Project, vo.Instance))
+	}
+	db := dbPath(vo)
+	defer func() {
+		if err := adminClient.DropDatabase(ctx, &adminpb.DropDatabaseRequest{Database: db}); err != nil {
+			log.Printf("Can't drop database %s used to validate the schema: %v\n", db, err)
+		}
+	}()
+	conv.StartDDLValidation(where)
+	stmts := schemaToDDL(conv)
+	p := internal.NewProgressWriter(int64(len(stmts)), "Validating schema", internal.Verbose(), o.Progress)
+	applyDDL(ctx, stmts, int(defaultInt64(o.DDLBatch, DefaultDDLBatch)), p, updateDDL(adminClient, db), conv.AddValidatedDDL)
+	p.Done()
+	if n := conv.DDLRejected(); n > 0 {
+		log.Printf("Spanner rejected %d of %d statements of the schema (see the report).\n", n, len(stmts))
+	}
+	return nil
+}
+
+// Project and instance of the emulator used to validate the schema
+// (see Options.ValidateDDL).
+const (
+	validateProject  = "harbourbridge"
+	validateInstance = "ddl-validation"
+)
+
+// ddlUpdater applies stmts in a single DDL operation. It returns the
+// number of statements applied: when a statement fails, the statements
+// before it have been applied, and the rest (including the failed one)
+// haven't. progress is called
+// with the statements applied so far while the operation runs.
+type ddlUpdater func(ctx context.Context, stmts []string, progress func(n int)) (int, error)
+
+// applyDDL applies stmts in batches of (at most) batch statements,
+// using update. Each statement is recorded with its error (nil if it
+// was applied), and the rest of the batch of a statement that fails is
+// retried.
+func applyDDL(ctx context.Context, stmts []string, batch int, p *internal.Progress, update ddlUpdater, record func(stmt string, err error)) {
+	done := 0
+	for len(stmts) > 0 {
+		n := batch
+		if n > len(stmts) {
+			n = len(stmts)
+		}
+		applied, err := update(ctx, stmts[:n], func(k int) { p.MaybeReport(int64(done + k)) })
+		for _, s := range stmts[:applied] {
+			record(s, nil)
+		}
+		if err != nil {
+			record(stmts[applied], err)
+			applied++
+		}
+		stmts = stmts[applied:]
+		done += applied
+		p.MaybeReport(int64(done))
+	}
+}
+
+// updateDDL returns a ddlUpdater for database db.
+func updateDDL(adminClient *database.DatabaseAdminClient, db string) ddlUpdater {
+	return func(ctx context.Context, stmts []string, progress func(n int)) (int, error) {
+		op, err := adminClient.UpdateDatabaseDdl(ctx, &adminpb.UpdateDatabaseDdlRequest{Database: db, Statements: stmts})
+		if err != nil {
+			return 0, err
+		}
+		for {
+			err := op.Poll(ctx)
+			// The metadata has a commit timestamp for each statement
+			// applied so far.
+			applied := 0
+			if m, _ := op.Metadata(); m != nil {
+				applied = len(m.CommitTimestamps)
+			}
+			if err != nil {
+				if applied >= len(stmts) {
+					applied = len(stmts) - 1 // Blame the last statement.
+				}
+				return applied, err
+			}
+			if op.Done() {
+				return len(stmts), nil
+			}
+			progress(applied)
+			select {
+			case <-ctx.Done():
+				return applied, ctx.Err()
+			case <-time.After(ddlPollInterval):
+			}
+		}
+	}
+}
+
+// emulatorHost returns the address of the Spanner emulator to use (see
+// Options.Endpoint), or "" for Cloud Spanner.
+func (o Options) emulatorHost() string {
+	if o.Endpoint != "" {
+		return o.Endpoint
+	}
+	return os.Getenv("SPANNER_EMULATOR_HOST")
+}
+
+// clientOptions returns the options for Spanner clients: o.ClientOptions,
+// plus options for connecting to the emulator (if any). The emulator
+// doesn't use TLS or authentication.
+func clientOptions(o Options) []option.ClientOption {
+	opts := o.ClientOptions
+	if host := o.emulatorHost(); host != "" {
+		opts = append(opts,
+			option.WithEndpoint(host),
+			option.WithGRPCDialOption(grpc.WithInsecure()),
+			option.WithoutAuthentication(),
+		)
+	}
+	return opts
+}
+
+// emulatorInstanceConfig is the only instance config the emulator has.
+const emulatorInstanceConfig = "emulator-config"
+
+// createEmulatorInstance creates instance o.Instance in the emulator, if
+// it doesn't already exist. Emulator instances are created on demand,
+// since the emulator starts out empty (e.g. in CI).
+func createEmulatorInstance(ctx context.Context, o Options, opts []option.ClientOption, log Logger) error {
+	adminClient, err := instance.NewInstanceAdminClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("can't create instance admin client: %w", err)
+	}
+	defer adminClient.Close()
+	op, err := adminClient.CreateInstance(ctx, &instancepb.CreateInstanceRequest{
+		Parent:     "projects/" + o.Project,
+		InstanceId: o.Instance,
+		Instance: &instancepb.Instance{
+			Config:      fmt.Sprintf("projects/%s/instanceConfigs/%s", o.Project, emulatorInstanceConfig),
+			DisplayName: o.Instance,
+			NodeCount:   1,
+		},
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't create emulator instance %s: %w", o.Instance, err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		return fmt.Errorf("can't create emulator instance %s: %w", o.Instance, err)
+	}
+	log.Printf("Created emulator instance %s.\n", o.Instance)
+	return nil
+}
+
+// dbPath returns the full name of the Spanner database o.DBName.
+func dbPath(o Options) string {
+	return fmt.Sprintf("projects/%s/instances/%s/databases/%s", o.Project, o.Instance, o.DBName)
+}
+
+// readSpannerSchema returns the columns of the tables of an existing
+// Spanner database, with their positions in the primary key.
+func readSpannerSchema(ctx context.Context, client *sp.Client) ([]internal.SpannerColumn, error) {
+	stmt := sp.Statement{SQL: `SELECT c.TABLE_NAME, c.COLUMN_NAME, c.SPANNER_TYPE, c.IS_NULLABLE, k.ORDINAL_POSITION, k.COLUMN_ORDERING
+		FROM INFORMATION_SCHEMA.COLUMNS AS c
+		LEFT JOIN INFORMATION_SCHEMA.INDEX_COLUMNS AS k
+		ON k.TABLE_CATALOG = c.TABLE_CATALOG AND k.TABLE_SCHEMA = c.TABLE_SCHEMA AND k.TABLE_NAME = c.TABLE_NAME
+			AND k.COLUMN_NAME = c.COLUMN_NAME AND k.INDEX_TYPE = 'PRIMARY_KEY'
+		WHERE c.TABLE_CATALOG = '' AND c.TABLE_SCHEMA = ''
+		ORDER BY c.TABLE_NAME, c.ORDINAL_POSITION`}
+	iter := client.Single().Query(ctx, stmt)
+	defer iter.Stop()
+	var cols []internal.SpannerColumn
+	err := iter.Do(func(row *sp.Row) error {
+		var c internal.SpannerColumn
+		var nullable string
+		var keyPos sp.NullInt64
+		var ordering sp.NullString
+		if err := row.Columns(&c.Table, &c.Column, &c.Type, &nullable, &keyPos, &ordering); err != nil {
+			return err
+		}
+		c.NotNull = nullable == "NO"
+		c.KeyPos = keyPos.Int64
+		c.KeyDesc = ordering.StringVal == "DESC"
+		cols = append(cols, c)
+		return nil
+	})
+	return cols, err
+}
+
+// countSpannerRows returns the number of rows in each of tables, with
+// -1 for tables that couldn't be counted.
+func countSpannerRows(ctx context.Context, client *sp.Client, tables []string, log Logger) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, t := range tables {
+		n := int64(-1)
+		// Spanner table names are identifiers, so quoting is enough.
+		iter := client.Single().Query(ctx, sp.Statement{SQL: fmt.Sprintf("SELECT COUNT(*) FROM `%s`", t)})
+		err := iter.Do(func(row *sp.Row) error {
+			return row.Columns(&n)
+		})
+		if err != nil {
+			log.Printf("Can't count rows of Spanner table %s: %v\n", t, err)
+			n = -1
+		}
+		counts[t] = n
+	}
+	return counts
+}
+
+// AnalyzeError inspects an error returned from Cloud Spanner and adds information
+// about potential root causes e.g. authentication issues.
+func AnalyzeError(err error, project, instance string) error {
+	e := strings.ToLower(err.Error())
+	if containsAny(e, []string{"unauthenticated", "cannot fetch token", "default credentials"}) {
+		return fmt.Errorf("%w.\n"+`
+Possible cause: credentials are mis-configured. Do you need to run
+
+  gcloud auth application-default login
+
+or configure environment variable GOOGLE_APPLICATION_CREDENTIALS.
+See https://cloud.google.com/docs/authentication/getting-started.
+`, err)
+	}
+	if containsAny(e, []string{"instance not found"}) && instance != "" {
+		return fmt.Errorf("%w.\n"+`
+Possible cause: Spanner instance specified via instance option does not exist.
+Please check that '%s' is correct and that it is a valid Spanner
+instance for project %s. To have HarbourBridge create it, use
+-create-instance and -instance-config.
+`, err, instance, project)
+	}
+	return err
+}
+
+func containsAny(s string, l []string) bool {
+	for _, a := range l {
+		if strings.Contains(s, a) {
+			return true
+		}
+	}
+	return false
+}
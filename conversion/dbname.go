@@ -0,0 +1,193 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	database "cloud.google.com/go/spanner/admin/database/apiv1"
+	adminpb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DBNameCollision is what to do when the database to be created
+// already exists.
+type DBNameCollision string
+
+const (
+	DBNameCollisionError  DBNameCollision = "error"  // Fail (the default).
+	DBNameCollisionSuffix DBNameCollision = "suffix" // Add the first free suffix -1, -2, ... to the name.
+)
+
+// ParseDBNameCollision parses the name of a DBNameCollision policy. The
+// empty string means the default, DBNameCollisionError.
+func ParseDBNameCollision(s string) (DBNameCollision, error) {
+	switch p := DBNameCollision(strings.ToLower(s)); p {
+	case "":
+		return DBNameCollisionError, nil
+	case DBNameCollisionError, DBNameCollisionSuffix:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown database name collision policy %q: expected error or suffix", s)
+}
+
+// ErrDatabaseExists is returned by Run if the database to be created
+// already exists (and Options.DBCollision isn't DBNameCollisionSuffix).
+// It is returned before the source is read.
+var ErrDatabaseExists = errors.New("database already exists")
+
+const (
+	minDBNameLength = 2
+	maxDBNameLength = 30
+	maxDBNameSuffix = 99
+	// dbNameTimeFormat is the format of the timestamp of generated
+	// database names.
+	dbNameTimeFormat = "20060102-150405"
+)
+
+// CheckDBName returns an error if name isn't a valid Spanner database
+// ID: 2 to 30 lowercase letters, digits, underscores and hyphens,
+// starting with a letter and ending with a letter or digit.
+func CheckDBName(name string) error {
+	bad := func(why string) error {
+		return fmt.Errorf("bad database name %q: %s", name, why)
+	}
+	if len(name) < minDBNameLength || len(name) > maxDBNameLength {
+		return bad(fmt.Sprintf("must be %d to %d characters long", minDBNameLength, maxDBNameLength))
+	}
+	for _, c := range name {
+		if !dbNameChar(c) {
+			return bad("must contain only lowercase letters, digits, underscores and hyphens")
+		}
+	}
+	if c := name[0]; c < 'a' || c > 'z' {
+		return bad("must start with a lowercase letter")
+	}
+	if c := name[len(name)-1]; c == '_' || c == '-' {
+		return bad("must end with a letter or digit")
+	}
+	return nil
+}
+
+func dbNameChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-'
+}
+
+// GenerateDBName returns a name for a new Spanner database: prefix (or,
+// if prefix is empty, a name derived from source, e.g. a dump file name
+// or the name of the source database), an underscore, and a timestamp
+// from now e.g. "orders_20201231-235959". Names derived from source are
+// lowercased, with other characters that Spanner doesn't allow replaced
+// by underscores, and shortened to fit. It returns an error if the name
+// isn't a valid database name (see CheckDBName), which can only happen
+// for a bad prefix.
+func GenerateDBName(prefix, source string, now time.Time) (string, error) {
+	ts := now.Format(dbNameTimeFormat)
+	if prefix == "" {
+		prefix = dbNameFromSource(source, maxDBNameLength-len(ts)-1)
+	}
+	name := prefix + "_" + ts
+	return name, CheckDBName(name)
+}
+
+// dbNameFromSource derives a database name of at most n characters from
+// source, falling back to "harbourbridge" if source has no letters.
+func dbNameFromSource(source string, n int) string {
+	base := path.Base(strings.ReplaceAll(source, "\\", "/"))
+	if i := strings.Index(base, "."); i > 0 {
+		base = base[:i] // Strip extensions e.g. ".sql.gz".
+	}
+	name := []rune(strings.ToLower(base))
+	for i, c := range name {
+		if !dbNameChar(c) {
+			name[i] = '_'
+		}
+	}
+	s := strings.TrimLeftFunc(string(name), func(c rune) bool { return c < 'a' || c > 'z' })
+	if s == "" {
+		s = "harbourbridge"
+	}
+	if len(s) > n {
+		s = s[:n]
+	}
+	return strings.TrimRight(s, "_-")
+}
+
+// suffixDBName returns name with suffix -i, shortening name if needed
+// so that the result fits Spanner's limit.
+func suffixDBName(name string, i int) string {
+	suffix := fmt.Sprintf("-%d", i)
+	if len(name)+len(suffix) > maxDBNameLength {
+		name = strings.TrimRight(name[:maxDBNameLength-len(suffix)], "_-")
+	}
+	return name + suffix
+}
+
+// pickDBName returns name if exists reports that there's no such
+// database. Otherwise, for DBNameCollisionSuffix it returns the first
+// of name-1, name-2, ... that doesn't exist, and for other policies it
+// returns ErrDatabaseExists.
+func pickDBName(name string, policy DBNameCollision, exists func(name string) (bool, error)) (string, error) {
+	for i := 0; i <= maxDBNameSuffix; i++ {
+		n := name
+		if i > 0 {
+			n = suffixDBName(name, i)
+		}
+		found, err := exists(n)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return n, nil
+		}
+		if policy != DBNameCollisionSuffix {
+			return "", fmt.Errorf("%w: %s (use a different name, or add a suffix to it with the %s collision policy)", ErrDatabaseExists, n, DBNameCollisionSuffix)
+		}
+	}
+	return "", fmt.Errorf("%w: %s and %s to %s", ErrDatabaseExists, name, suffixDBName(name, 1), suffixDBName(name, maxDBNameSuffix))
+}
+
+// ResolveDBName checks whether the database o.DBName exists in
+// o.Instance, and returns the name to create the database with: o.DBName
+// if it doesn't exist, or (depending on o.DBCollision) a suffixed name
+// that doesn't. Run calls it before reading the source, so that a name
+// collision is found straight away rather than after schema conversion.
+// Callers that need the final name before calling Run (e.g. to name
+// output files) can also call it themselves.
+func ResolveDBName(ctx context.Context, o Options) (string, error) {
+	adminClient, err := database.NewDatabaseAdminClient(ctx, clientOptions(o)...)
+	if err != nil {
+		return "", fmt.Errorf("can't create admin client: %w", AnalyzeError(err, o.Project, o.Instance))
+	}
+	defer adminClient.Close()
+	return pickDBName(o.DBName, o.DBCollision, func(name string) (bool, error) {
+		vo := o
+		vo.DBName = name
+		_, err := adminClient.GetDatabase(ctx, &adminpb.GetDatabaseRequest{Name: dbPath(vo)})
+		switch status.Code(err) {
+		case codes.OK:
+			return true, nil
+		case codes.NotFound:
+			return false, nil
+		}
+		return false, fmt.Errorf("can't check whether database %s exists: %w", name, AnalyzeError(err, o.Project, o.Instance))
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDBName(t *testing.T) {
+	for _, name := range []string{"ab", "orders_20201231-235959", "a-1", strings.Repeat("a", 30)} {
+		assert.Nil(t, CheckDBName(name), name)
+	}
+	tests := []struct{ name, err string }{
+		{"a", "must be 2 to 30 characters long"},
+		{strings.Repeat("a", 31), "must be 2 to 30 characters long"},
+		{"Orders", "must contain only lowercase letters"},
+		{"or.ders", "must contain only lowercase letters"},
+		{"1orders", "must start with a lowercase letter"},
+		{"_orders", "must start with a lowercase letter"},
+		{"orders-", "must end with a letter or digit"},
+	}
+	for _, tc := range tests {
+		err := CheckDBName(tc.name)
+		if assert.NotNil(t, err, tc.name) {
+			assert.Contains(t, err.Error(), tc.err, tc.name)
+		}
+	}
+}
+
+func TestGenerateDBName(t *testing.T) {
+	now := time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC)
+	tests := []struct{ prefix, source, name string }{
+		{"", "pgdump", "pgdump_20201231-235959"},
+		{"", "/tmp/Orders.sql.gz", "orders_20201231-235959"},
+		{"", "gs://bucket/dumps/my db.sql", "my_db_20201231-235959"},
+		{"", "2020-sales.sql", "sales_20201231-235959"},
+		{"", "customer_accounts_backup.sql", "customer_accou_20201231-235959"},
+		{"", "123", "harbourbridge_20201231-235959"},
+		{"", "", "harbourbridge_20201231-235959"},
+		{"shop", "orders.sql", "shop_20201231-235959"},
+	}
+	for _, tc := range tests {
+		name, err := GenerateDBName(tc.prefix, tc.source, now)
+		assert.Nil(t, err, tc.source)
+		assert.Equal(t, tc.name, name, tc.source)
+	}
+	_, err := GenerateDBName("Shop", "", now)
+	assert.NotNil(t, err)
+	_, err = GenerateDBName("a_very_long_database_prefix", "", now)
+	assert.NotNil(t, err)
+}
+
+func TestPickDBName(t *testing.T) {
+	existing := map[string]bool{"orders": true, "orders-1": true}
+	exists := func(name string) (bool, error) { return existing[name], nil }
+	name, err := pickDBName("sales", DBNameCollisionError, exists)
+	assert.Nil(t, err)
+	assert.Equal(t, "sales", name)
+	_, err = pickDBName("orders", DBNameCollisionError, exists)
+	assert.True(t, errors.Is(err, ErrDatabaseExists))
+	name, err = pickDBName("orders", DBNameCollisionSuffix, exists)
+	assert.Nil(t, err)
+	assert.Equal(t, "orders-2", name)
+
+	// Suffixes shorten names that would be too long.
+	long := "customer_accou_20201231-235959"
+	existing[long] = true
+	name, err = pickDBName(long, DBNameCollisionSuffix, exists)
+	assert.Nil(t, err)
+	assert.Equal(t, "customer_accou_20201231-2359-1", name)
+	assert.Nil(t, CheckDBName(name))
+
+	_, err = pickDBName("x1", DBNameCollisionSuffix, func(string) (bool, error) { return true, nil })
+	assert.True(t, errors.Is(err, ErrDatabaseExists))
+	_, err = pickDBName("x1", DBNameCollisionSuffix, func(string) (bool, error) { return false, fmt.Errorf("permission denied") })
+	assert.NotNil(t, err)
+}
+
+func TestParseDBNameCollision(t *testing.T) {
+	p, err := ParseDBNameCollision("")
+	assert.Nil(t, err)
+	assert.Equal(t, DBNameCollisionError, p)
+	p, err = ParseDBNameCollision("Suffix")
+	assert.Nil(t, err)
+	assert.Equal(t, DBNameCollisionSuffix, p)
+	_, err = ParseDBNameCollision("overwrite")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+)
+
+// dumpInput is a dump file made seekable by getSeekable.
+type dumpInput struct {
+	name      string // Empty unless Options.Inputs is set.
+	in        io.ReadSeeker
+	gzip      *gzipSeeker // Non-nil for gzipped seekable input.
+	size      int64       // Decompressed size (estimated for gzipped input until the schema pass).
+	tempBytes int64       // Size of the temporary file copy, if any.
+}
+
+// openInputs makes the dump inputs seekable (see getSeekable), and
+// records their total size. The returned cleanup function removes any
+// temporary files.
+func (r *runner) openInputs() (func(), error) {
+	inputs := r.opts.Inputs
+	if len(inputs) == 0 {
+		inputs = []NamedInput{{Input: r.opts.Input}}
+	}
+	var cleanups []func()
+	cleanup := func() {
+		for _, c := range cleanups {
+			c()
+		}
+	}
+	for _, in := range inputs {
+		d, c, err := getSeekable(in.Input)
+		if err != nil {
+			cleanup()
+			if in.Name != "" {
+				err = fmt.Errorf("%s: %w", in.Name, err)
+			}
+			return nil, err
+		}
+		cleanups = append(cleanups, c)
+		d.name = in.Name
+		r.inputs = append(r.inputs, d)
+		r.bytesRead += d.size
+		r.tempFileBytes += d.tempBytes
+	}
+	return cleanup, nil
+}
+
+// processInput does schema or data conversion of the dump input d,
+// read by rd (see processDump). Inputs converted together are recorded
+// as such (see internal.Conv.StartDumpFile).
+func (r *runner) processInput(conv *internal.Conv, d *dumpInput, rd *internal.Reader) error {
+	if d.name != "" {
+		conv.StartDumpFile(d.name)
+	}
+	if err := r.processDump(conv, rd); err != nil {
+		if d.name != "" {
+			return fmt.Errorf("failed to parse %s: %w", d.name, err)
+		}
+		return fmt.Errorf("failed to parse the data file: %w", err)
+	}
+	return nil
+}
+
+// offsetProgress reports the progress of reading a dump input as
+// progress through all the inputs, which start offset bytes before it.
+type offsetProgress struct {
+	internal.ProgressSink
+	offset int64
+}
+
+func (p offsetProgress) MaybeReport(progress int64) {
+	p.ProgressSink.MaybeReport(p.offset + progress)
+}
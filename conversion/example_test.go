@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/conversion"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// This example does an end-to-end dry run of a pg_dump conversion:
+// the schema and data are fully converted, but no Spanner database is
+// created and no files are written.
+func ExampleRun() {
+	dump := "CREATE TABLE products (id bigint PRIMARY KEY, name text, price real);\n" +
+		"COPY public.products (id, name, price) FROM stdin;\n" +
+		"1\tbook\t9.99\n" +
+		"2\tpen\t1.50\n" +
+		"\\.\n"
+	conv, res, err := conversion.Run(context.Background(), conversion.Options{
+		Driver: conversion.PGDUMP,
+		Input:  strings.NewReader(dump),
+		DryRun: true,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Converted %d rows, %d would be written to Spanner.\n", conv.Rows(), res.RowsWritten)
+	fmt.Printf("Schema: %s.\n", res.Ratings.SchemaDesc)
+	fmt.Printf("Data: %s.\n", res.Ratings.DataDesc)
+	// Output:
+	// Converted 2 rows, 2 would be written to Spanner.
+	// Schema: EXCELLENT (all columns mapped cleanly).
+	// Data: EXCELLENT (all 2 rows would be written to Spanner).
+}
+
+// This example uses the structured report and the Spanner schema of a
+// schema-only conversion, without parsing report.txt.
+func ExampleRun_report() {
+	dump := "CREATE TABLE products (id serial PRIMARY KEY, name text);\n" +
+		"CREATE TABLE tags (name text);\n"
+	conv, res, err := conversion.Run(context.Background(), conversion.Options{
+		Driver:     conversion.PGDUMP,
+		Input:      strings.NewReader(dump),
+		SchemaOnly: true,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, stmt := range conv.GetDDL(ddl.Config{}) {
+		fmt.Println(strings.SplitN(stmt, "\n", 2)[0])
+	}
+	for _, t := range res.Report.Tables {
+		fmt.Printf("Table %s: %s.\n", t.SrcTable, t.Rating.Schema.Description)
+		for _, issue := range t.Issues {
+			fmt.Printf("  %s %s %v\n", issue.Severity, issue.Issue, issue.Columns)
+		}
+	}
+	// Output:
+	// CREATE TABLE products (
+	// CREATE TABLE tags (
+	// Table products: POOR (many columns did not map cleanly; 2 warnings across 2 columns).
+	//   warning serial [id]
+	//   warning hotspot [id]
+	// Table tags: GOOD (all columns mapped cleanly, but missing primary key).
+	//   warning missingPrimaryKey [synth_id]
+}
+
+// This example converts the schema and data of pg_dump output step by
+// step. Programs writing to Spanner create the database with the DDL
+// statements, and pass a client for it to WriteData.
+func ExampleConverter() {
+	dump := "CREATE TABLE products (id bigint PRIMARY KEY, name text);\n" +
+		"COPY public.products (id, name) FROM stdin;\n" +
+		"1\tbook\n" +
+		"\\.\n"
+	c, err := conversion.NewConv(conversion.Options{DryRun: true})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer c.Close()
+	if err := c.ProcessPgDump(strings.NewReader(dump)); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, stmt := range c.SchemaToDDL() {
+		fmt.Println(strings.SplitN(stmt, "\n", 2)[0])
+	}
+	if err := c.WriteData(context.Background(), nil); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, t := range c.Report().Tables {
+		fmt.Printf("Table %s: %d rows, %s.\n", t.SrcTable, t.Rows, t.Rating.Data.Description)
+	}
+	// Output:
+	// CREATE TABLE `products` (
+	// Table products: 1 rows, EXCELLENT (all 1 rows would be written to Spanner).
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+)
+
+// DumpFile is a dump file opened by OpenDump.
+type DumpFile interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// OpenDump opens the dump file at path, which is either a local file
+// name or a Google Cloud Storage URL (gs://bucket/object). GCS objects
+// are streamed rather than copied locally: each pass over the dump is
+// a separate sequential read.
+func OpenDump(ctx context.Context, path string) (DumpFile, error) {
+	if !strings.HasPrefix(path, "gs://") {
+		return os.Open(path)
+	}
+	bucket, object, err := parseGCSURL(path)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := storage.NewService(ctx, option.WithScopes(storage.DevstorageReadOnlyScope))
+	if err != nil {
+		return nil, fmt.Errorf("can't create GCS client: %w", err)
+	}
+	obj, err := svc.Objects.Get(bucket, object).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("can't get GCS object %s: %w", path, err)
+	}
+	open := func(offset int64) (io.ReadCloser, error) {
+		// Pin the generation, so that all reads see the same data even
+		// if the object is overwritten.
+		call := svc.Objects.Get(bucket, object).Generation(obj.Generation)
+		call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		resp, err := call.Context(ctx).Download()
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}
+	return newGCSReader(path, int64(obj.Size), open), nil
+}
+
+// parseGCSURL splits a gs://bucket/object URL.
+func parseGCSURL(url string) (string, string, error) {
+	l := strings.SplitN(strings.TrimPrefix(url, "gs://"), "/", 2)
+	if len(l) != 2 || l[0] == "" || l[1] == "" {
+		return "", "", fmt.Errorf("bad GCS URL %s: expected gs://bucket/object", url)
+	}
+	return l[0], l[1], nil
+}
+
+// Parameters used to control retries of GCS reads that fail part-way
+// through an object. Delays between attempts start at
+// gcsInitialBackoff and double on each attempt.
+const (
+	gcsReadAttempts   = 5
+	gcsInitialBackoff = 500 * time.Millisecond
+)
+
+// gcsReader reads a GCS object sequentially, from a single ranged read
+// that is only reopened when it fails or when the reader seeks. Failed
+// reads are retried from the last good offset.
+type gcsReader struct {
+	url    string
+	size   int64
+	open   func(offset int64) (io.ReadCloser, error) // Reads the object from offset.
+	sleep  func(time.Duration)
+	body   io.ReadCloser // Nil until the first Read after a seek.
+	got    int64         // Bytes read from body.
+	offset int64
+}
+
+func newGCSReader(url string, size int64, open func(int64) (io.ReadCloser, error)) *gcsReader {
+	return &gcsReader{url: url, size: size, open: open, sleep: time.Sleep}
+}
+
+func (r *gcsReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	delay := gcsInitialBackoff
+	for attempt := 1; ; attempt++ {
+		n, cut, err := r.read(p)
+		switch {
+		case err == nil || err == io.EOF:
+			return n, err
+		case n > 0:
+			return n, nil // The next Read reopens the object.
+		case cut:
+			// The read got some data before failing: reopen the
+			// object right away.
+			attempt, delay = 0, gcsInitialBackoff
+			continue
+		case attempt >= gcsReadAttempts:
+			return 0, fmt.Errorf("can't read %s at offset %d: %w", r.url, r.offset, err)
+		}
+		internal.VerbosePrintf("Retrying read of %s at offset %d in %v (attempt %d failed: %v)\n", r.url, r.offset, delay, attempt, err)
+		r.sleep(delay)
+		delay *= 2
+	}
+}
+
+// read does a single read, opening the object at the current offset if
+// needed. After an error, the next read reopens the object. It also
+// returns true if the error cut off a read that had returned data.
+func (r *gcsReader) read(p []byte) (int, bool, error) {
+	if r.body == nil {
+		body, err := r.open(r.offset)
+		if err != nil {
+			return 0, false, err
+		}
+		r.body = body
+		r.got = 0
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	r.got += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF // The connection was cut.
+	}
+	if err != nil && err != io.EOF {
+		r.closeBody()
+	}
+	return n, r.got > 0, err
+}
+
+// Seek sets the offset for the next Read, which starts a new read of
+// the object.
+func (r *gcsReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("can't seek to negative offset %d of %s", offset, r.url)
+	}
+	if offset != r.offset {
+		r.closeBody()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *gcsReader) Close() error {
+	r.closeBody()
+	return nil
+}
+
+func (r *gcsReader) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+)
+
+// errPgArchive is returned for pg_dump archives, which HarbourBridge
+// can't read.
+var errPgArchive = errors.New("the input is a pg_dump archive (pg_dump -Fc or -Ft), but HarbourBridge reads plain-format SQL: " +
+	"run pg_dump without -Fc or -Ft, or convert the archive with 'pg_restore -f dump.sql archive'")
+
+// detectFormat recognizes the format of the dump inputs from their
+// start (see internal.DetectInputFormat), so that input in the wrong
+// format fails fast rather than producing a report full of unexpected
+// conditions. pg_dump archives are rejected. If Options.Driver wasn't
+// set, mysqldump output is converted with MYSQLDUMP; otherwise input
+// recognized as the other kind of dump is rejected.
+func (r *runner) detectFormat() error {
+	if r.opts.Driver != PGDUMP && r.opts.Driver != MYSQLDUMP {
+		return nil
+	}
+	format := internal.InputFormatUnknown
+	for _, d := range r.inputs {
+		f, err := sniffFormat(d.in)
+		if err == nil && f == internal.InputFormatPgArchive {
+			err = errPgArchive
+		}
+		if err != nil {
+			if d.name != "" {
+				err = fmt.Errorf("%s: %w", d.name, err)
+			}
+			return err
+		}
+		switch {
+		case f == internal.InputFormatUnknown:
+		case format == internal.InputFormatUnknown:
+			format = f
+		case f != format:
+			return fmt.Errorf("inputs are in different formats: %s and %s", format, f)
+		}
+	}
+	r.format = format
+	switch {
+	case format == internal.InputFormatMySQLDump && r.opts.Driver == PGDUMP && r.detectDriver:
+		r.log.Printf("Input recognized as mysqldump output: using driver %s.\n", MYSQLDUMP)
+		r.opts.Driver = MYSQLDUMP
+	case format == internal.InputFormatMySQLDump && r.opts.Driver == PGDUMP:
+		return fmt.Errorf("the input looks like mysqldump output, which driver %s can't read: use driver %s", PGDUMP, MYSQLDUMP)
+	case format == internal.InputFormatPgDump && r.opts.Driver == MYSQLDUMP:
+		return fmt.Errorf("the input looks like pg_dump output, which driver %s can't read: use driver %s", MYSQLDUMP, PGDUMP)
+	}
+	return nil
+}
+
+// sniffFormat recognizes the format of dump in from its start, leaving
+// in's offset at the start.
+func sniffFormat(in io.ReadSeeker) (internal.InputFormat, error) {
+	b := make([]byte, internal.InputSniffBytes)
+	n, err := io.ReadFull(in, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("can't read input: %w", err)
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("can't reset file offset: %w", err)
+	}
+	return internal.DetectInputFormat(b[:n]), nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	instancepb "google.golang.org/genproto/googleapis/spanner/admin/instance/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// InstanceSpec gives the settings of the Spanner instance to create if
+// Options.Instance doesn't exist (see Options.CreateInstance). Compute
+// capacity is given in nodes or processing units (1000 per node), but
+// not both: if both are zero, the instance gets one node.
+type InstanceSpec struct {
+	Config          string // Instance config e.g. "regional-us-central1", or its full name.
+	Nodes           int32
+	ProcessingUnits int32
+}
+
+// instancePollInterval is how often progress of instance creation is
+// reported.
+const instancePollInterval = 10 * time.Second
+
+func (s InstanceSpec) validate() error {
+	switch pu := s.ProcessingUnits; {
+	case s.Config == "":
+		return fmt.Errorf("creating an instance needs an instance config")
+	case s.Nodes < 0 || pu < 0:
+		return fmt.Errorf("instance nodes and processing units must not be negative")
+	case s.Nodes > 0 && pu > 0:
+		return fmt.Errorf("instance compute capacity can be given in nodes or processing units, but not both")
+	case pu > 0 && pu < 1000 && pu%100 != 0, pu >= 1000 && pu%1000 != 0:
+		return fmt.Errorf("instance processing units must be a multiple of 100 below 1000, or a multiple of 1000, got %d", pu)
+	}
+	return nil
+}
+
+// configName returns the full name of the instance config of s.
+func (s InstanceSpec) configName(project string) string {
+	if strings.Contains(s.Config, "/") {
+		return s.Config
+	}
+	return fmt.Sprintf("projects/%s/instanceConfigs/%s", project, s.Config)
+}
+
+// instance returns the instance to create for s.
+func (s InstanceSpec) instance(project, name string) *instancepb.Instance {
+	i := &instancepb.Instance{
+		Config:          s.configName(project),
+		DisplayName:     name,
+		NodeCount:       s.Nodes,
+		ProcessingUnits: s.ProcessingUnits,
+	}
+	if i.NodeCount == 0 && i.ProcessingUnits == 0 {
+		i.NodeCount = 1
+	}
+	return i
+}
+
+// createInstance creates instance o.Instance with settings
+// o.CreateInstance, unless it already exists, waiting for the operation
+// to finish. It returns the instance if it created it, and nil if it
+// already existed.
+func createInstance(ctx context.Context, o Options, log Logger) (*instancepb.Instance, error) {
+	adminClient, err := instance.NewInstanceAdminClient(ctx, clientOptions(o)...)
+	if err != nil {
+		return nil, fmt.Errorf("can't create instance admin client: %w", AnalyzeError(err, o.Project, ""))
+	}
+	defer adminClient.Close()
+	name := fmt.Sprintf("projects/%s/instances/%s", o.Project, o.Instance)
+	_, err = adminClient.GetInstance(ctx, &instancepb.GetInstanceRequest{Name: name})
+	if err == nil {
+		return nil, nil
+	}
+	if status.Code(err) != codes.NotFound {
+		return nil, fmt.Errorf("can't check whether instance %s exists: %w", o.Instance, instancePermissionError(err, o.Project))
+	}
+	want := o.CreateInstance.instance(o.Project, o.Instance)
+	log.Printf("Creating instance %s (%s) ...\n", o.Instance, describeInstance(want))
+	op, err := adminClient.CreateInstance(ctx, &instancepb.CreateInstanceRequest{
+		Parent:     "projects/" + o.Project,
+		InstanceId: o.Instance,
+		Instance:   want,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't create instance %s: %w", o.Instance, instancePermissionError(err, o.Project))
+	}
+	start := time.Now()
+	for {
+		i, err := op.Poll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("can't create instance %s: %w", o.Instance, instancePermissionError(err, o.Project))
+		}
+		if op.Done() {
+			log.Printf("Created instance %s.\n", o.Instance)
+			return i, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("can't create instance %s: %w", o.Instance, ctx.Err())
+		case <-time.After(instancePollInterval):
+		}
+		log.Printf("Waiting for instance %s to be created (%s so far) ...\n", o.Instance, time.Since(start).Round(time.Second))
+	}
+}
+
+// deleteInstance deletes instance o.Instance, which createInstance
+// created for a conversion that failed.
+func deleteInstance(o Options, log Logger) {
+	// The conversion's context may have been canceled.
+	ctx := context.Background()
+	adminClient, err := instance.NewInstanceAdminClient(ctx, clientOptions(o)...)
+	if err == nil {
+		defer adminClient.Close()
+		err = adminClient.DeleteInstance(ctx, &instancepb.DeleteInstanceRequest{Name: fmt.Sprintf("projects/%s/instances/%s", o.Project, o.Instance)})
+	}
+	if err != nil {
+		log.Printf("Can't delete instance %s, which was created for the conversion: %v\n", o.Instance, err)
+		return
+	}
+	log.Printf("Deleted instance %s, which was created for the conversion.\n", o.Instance)
+}
+
+// describeInstance describes the config and compute capacity of i e.g.
+// "regional-us-central1, 1 node".
+func describeInstance(i *instancepb.Instance) string {
+	config := configID(i)
+	switch {
+	case i.ProcessingUnits > 0 && i.NodeCount == 0:
+		return fmt.Sprintf("%s, %d processing units", config, i.ProcessingUnits)
+	case i.NodeCount == 1:
+		return fmt.Sprintf("%s, 1 node", config)
+	}
+	return fmt.Sprintf("%s, %d nodes", config, i.NodeCount)
+}
+
+// configID returns the ID of the instance config of i e.g.
+// "regional-us-central1".
+func configID(i *instancepb.Instance) string {
+	return i.Config[strings.LastIndex(i.Config, "/")+1:]
+}
+
+// instancePermissionError adds the IAM roles needed to create an
+// instance to err, if it's a permission failure.
+func instancePermissionError(err error, project string) error {
+	if status.Code(err) != codes.PermissionDenied {
+		return AnalyzeError(err, project, "")
+	}
+	return fmt.Errorf("%w.\n"+`
+You don't have permission to create Spanner instances in project %s.
+Creating an instance needs the spanner.instances.get, spanner.instances.create
+and (to delete it if the conversion fails) spanner.instances.delete
+permissions, which these IAM roles have:
+
+  roles/spanner.admin (Cloud Spanner Admin)
+  roles/editor (Editor)
+  roles/owner (Owner)
+
+Ask a project owner to grant you one of them e.g.
+
+  gcloud projects add-iam-policy-binding %s \
+    --member=user:<your email> --role=roles/spanner.admin
+
+or create the instance yourself, and then convert into it.
+`, err, project, project)
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	instancepb "google.golang.org/genproto/googleapis/spanner/admin/instance/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInstanceSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		spec InstanceSpec
+		ok   bool
+	}{
+		{"default capacity", InstanceSpec{Config: "regional-us-central1"}, true},
+		{"nodes", InstanceSpec{Config: "regional-us-central1", Nodes: 3}, true},
+		{"small", InstanceSpec{Config: "regional-us-central1", ProcessingUnits: 100}, true},
+		{"large", InstanceSpec{Config: "regional-us-central1", ProcessingUnits: 2000}, true},
+		{"no config", InstanceSpec{Nodes: 1}, false},
+		{"both", InstanceSpec{Config: "regional-us-central1", Nodes: 1, ProcessingUnits: 1000}, false},
+		{"negative", InstanceSpec{Config: "regional-us-central1", Nodes: -1}, false},
+		{"bad small", InstanceSpec{Config: "regional-us-central1", ProcessingUnits: 150}, false},
+		{"bad large", InstanceSpec{Config: "regional-us-central1", ProcessingUnits: 1500}, false},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.ok, tc.spec.validate() == nil, tc.name)
+	}
+
+	i := InstanceSpec{Config: "regional-us-central1"}.instance("p", "i")
+	assert.Equal(t, "projects/p/instanceConfigs/regional-us-central1", i.Config)
+	assert.Equal(t, int32(1), i.NodeCount)
+	assert.Equal(t, "regional-us-central1, 1 node", describeInstance(i))
+	i = InstanceSpec{Config: "projects/p/instanceConfigs/nam3", ProcessingUnits: 500}.instance("p", "i")
+	assert.Equal(t, "projects/p/instanceConfigs/nam3", i.Config)
+	assert.Equal(t, "nam3, 500 processing units", describeInstance(i))
+	assert.Equal(t, "nam3, 2 nodes", describeInstance(&instancepb.Instance{Config: "nam3", NodeCount: 2, ProcessingUnits: 2000}))
+}
+
+func TestInstancePermissionError(t *testing.T) {
+	err := instancePermissionError(status.Error(codes.PermissionDenied, "caller does not have permission"), "my-project")
+	assert.Contains(t, err.Error(), "roles/spanner.admin")
+	assert.Contains(t, err.Error(), "gcloud projects add-iam-policy-binding my-project")
+	err = instancePermissionError(fmt.Errorf("quota exceeded"), "my-project")
+	assert.Equal(t, "quota exceeded", err.Error())
+}
@@ -0,0 +1,346 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func (r *runner) writeSchemaFile(conv *internal.Conv) {
+	name := r.path(SchemaFile)
+	if name == "" {
+		return
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		r.log.Printf("Can't create schema file %s: %v\n", name, err)
+		return
+	}
+	defer f.Close()
+	// The schema file we write out includes comments, and doesn't add backticks
+	// around table and column names. This file is intended for explanatory
+	// and documentation purposes, and is not strictly legal Cloud Spanner DDL
+	// (Cloud Spanner doesn't currently support comments). Change 'Comments'
+	// to false and 'ProtectIds' to true to write out a schema file that is
+	// legal Cloud Spanner DDL.
+	ddl := conv.GetDDL(ddl.Config{Comments: true, ProtectIds: false, Dialect: conv.Dialect()})
+	if len(ddl) == 0 {
+		ddl = []string{"\n-- Schema is empty -- no tables found\n"}
+	}
+	l := []string{
+		fmt.Sprintf("-- Schema generated %s\n", r.opts.Now.Format("2006-01-02 15:04:05")),
+		strings.Join(ddl, ";\n\n"),
+		"\n",
+	}
+	if _, err := f.WriteString(strings.Join(l, "")); err != nil {
+		r.log.Printf("Can't write out schema file: %v\n", err)
+		return
+	}
+	r.addArtifact(SchemaFile, name)
+	r.log.Printf("Wrote schema to file '%s'.\n", name)
+}
+
+// writeTableSchemaFiles writes the DDL of each Spanner table to its own
+// file (see Options.OutDir).
+func (r *runner) writeTableSchemaFiles(conv *internal.Conv) {
+	if r.opts.OutDir == "" {
+		return
+	}
+	// As for the schema file, include comments and don't add backticks.
+	c := ddl.Config{Comments: true, ProtectIds: false, Dialect: conv.Dialect()}
+	r.writeTableFiles(TableSchemaDir, ".ddl", conv.SpannerTables(), func(t string, w *bufio.Writer) {
+		w.WriteString(strings.Join(conv.GetSpannerTableDDL(t, c), ";\n\n") + ";\n")
+	})
+}
+
+// writeTableFiles writes a file for each of tables to directory dir of
+// Options.OutDir, with contents written by gen. File names are the
+// table names (see safeFileNames) plus ext. It returns the paths of the
+// files written, relative to Options.OutDir, keyed by table.
+func (r *runner) writeTableFiles(dir, ext string, tables []string, gen func(table string, w *bufio.Writer)) map[string]string {
+	files := make(map[string]string)
+	if err := os.MkdirAll(filepath.Join(r.opts.OutDir, dir), 0755); err != nil {
+		r.log.Printf("Can't create directory for per-table files: %v\n", err)
+		return files
+	}
+	names := safeFileNames(tables)
+	for _, t := range tables {
+		rel := filepath.Join(dir, names[t]+ext)
+		path := filepath.Join(r.opts.OutDir, rel)
+		err := writeFile(path, func(w *bufio.Writer) error {
+			gen(t, w)
+			return nil
+		})
+		if err != nil {
+			r.log.Printf("Can't write out file %s: %v\n", path, err)
+			continue
+		}
+		r.addArtifact(rel, path)
+		files[t] = rel
+	}
+	return files
+}
+
+// reservedFileNames are names that Windows doesn't allow for files
+// (with any extension).
+var reservedFileNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// safeFileNames returns file names (without extensions) for names e.g.
+// table names, keyed by name. Characters other than ASCII letters,
+// digits, '_', '-' and '.' are replaced by '_', and names that would be
+// hidden, reserved or empty get a '_' prefix. Names that would then
+// clash, ignoring case (for case-insensitive filesystems), get a
+// numeric suffix.
+func safeFileNames(names []string) map[string]string {
+	files := make(map[string]string)
+	used := make(map[string]bool)
+	for _, n := range names {
+		f := strings.Map(func(c rune) rune {
+			switch {
+			case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_', c == '-', c == '.':
+				return c
+			}
+			return '_'
+		}, n)
+		if f == "" || f[0] == '.' || reservedFileNames[strings.ToUpper(strings.SplitN(f, ".", 2)[0])] {
+			f = "_" + f
+		}
+		base := f
+		for i := 2; used[strings.ToLower(f)]; i++ {
+			f = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[strings.ToLower(f)] = true
+		files[n] = f
+	}
+	return files
+}
+
+// writeBadData writes detailed info about bad rows to the bad-data
+// file. Returns the number of bytes written to the file.
+func (r *runner) writeBadData(bw dataWriter, conv *internal.Conv, banner string) int64 {
+	name := r.path(BadDataFile)
+	if name == "" {
+		return 0
+	}
+	badConversions := conv.BadRows()
+	badWrites := sum(bw.DroppedRowsByTable())
+	if badConversions == 0 && badWrites == 0 {
+		os.Remove(name) // Cleanup bad-data file from previous run.
+		return 0
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		r.log.Printf("Can't write out bad data file: %v\n", err)
+		return 0
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	w.WriteString(banner)
+	maxRows := 100
+	if badConversions > 0 {
+		l := conv.SampleBadRows(maxRows)
+		if int64(len(l)) < badConversions {
+			w.WriteString("A sample of rows that generated conversion errors:\n")
+		} else {
+			w.WriteString("Rows that generated conversion errors:\n")
+		}
+		for _, row := range l {
+			w.WriteString("  " + row + "\n")
+		}
+	}
+	if tooLarge := sum(bw.TooLargeRowsByTable()); tooLarge > 0 {
+		// We don't keep samples of these rows since they are huge.
+		fmt.Fprintf(w, "%d rows exceeded Spanner's commit size limit, so weren't written to Spanner.\n", tooLarge)
+		badWrites -= tooLarge
+	}
+	if badWrites > 0 {
+		l := bw.SampleBadRows(maxRows)
+		if int64(len(l)) < badWrites {
+			fmt.Fprintf(w, "A sample of rows that successfully converted but couldn't be written to %s:\n", conv.DataTarget())
+		} else {
+			fmt.Fprintf(w, "Rows that successfully converted but couldn't be written to %s:\n", conv.DataTarget())
+		}
+		for _, row := range l {
+			w.WriteString("  " + row + "\n")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		r.log.Printf("Can't write out bad data file: %v\n", err)
+	}
+	r.addArtifact(BadDataFile, name)
+	r.log.Printf("See file '%s' for details of bad rows\n", name)
+	return r.res.Artifacts[len(r.res.Artifacts)-1].Bytes
+}
+
+// openBadRows creates the bad-rows file (if requested), and configures
+// conv to write all bad rows to it. The returned function closes the
+// file, and returns the number of bytes written to it.
+func (r *runner) openBadRows(conv *internal.Conv) (func() int64, error) {
+	name := r.opts.BadRowsFile
+	if name == "" {
+		return func() int64 { return 0 }, nil
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't create bad rows file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	r.badRows = internal.NewBadRowWriter(w, name, defaultInt64(r.opts.BadRowsLimit, DefaultBadRowsLimit))
+	conv.SetBadRowWriter(r.badRows)
+	return func() int64 {
+		err := r.badRows.Err()
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			r.log.Printf("Can't write out bad rows file: %v\n", err)
+		}
+		f.Close()
+		r.addArtifact(BadRowsArtifact, name)
+		return r.res.Artifacts[len(r.res.Artifacts)-1].Bytes
+	}, nil
+}
+
+// startCheckpoint sets up checkpointing of data conversion (if
+// requested). For resumed conversions, it reads the checkpoint and
+// accounts for rows written by previous runs.
+func (r *runner) startCheckpoint(conv *internal.Conv) error {
+	name := r.opts.CheckpointFile
+	if name == "" {
+		return nil
+	}
+	var resume *internal.Checkpoint
+	if r.opts.Resume {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("can't read checkpoint file: %w", err)
+		}
+		resume, err = internal.ReadCheckpoint(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("can't read checkpoint file %s: %w", name, err)
+		}
+	}
+	t, err := internal.NewCheckpointTracker(name, r.dumpHash, resume)
+	if err != nil {
+		return fmt.Errorf("can't resume from checkpoint file %s: %w", name, err)
+	}
+	conv.SetCheckpointTracker(t)
+	r.checkpoint = t
+	for _, tc := range t.Resumed() {
+		r.res.RowsWritten += tc.Rows - tc.BadRows - tc.BadWrites
+	}
+	return nil
+}
+
+// closeCheckpoint writes the final checkpoint (if any).
+func (r *runner) closeCheckpoint() {
+	if r.checkpoint == nil {
+		return
+	}
+	if err := r.checkpoint.Close(); err != nil {
+		r.log.Printf("Can't write out checkpoint file: %v\n", err)
+		return
+	}
+	r.addArtifact(CheckpointArtifact, r.opts.CheckpointFile)
+}
+
+// report writes the requested reports, fills in the summary fields of
+// the result, and logs a summary of the conversion.
+func (c *Converter) report(banner string) {
+	r, conv := c.r, c.conv
+	src := r.source()
+	badWrites := r.res.BadWrites
+	r.res.Summary = internal.GenerateSummary(conv, badWrites)
+	r.res.Ratings = internal.OverallRatings(conv, badWrites)
+	r.res.Report = c.Report()
+	var files []string
+	write := func(enabled bool, name string, gen func(w *bufio.Writer) error) {
+		path := r.path(name)
+		if !enabled || path == "" {
+			return
+		}
+		if err := writeFile(path, gen); err != nil {
+			r.log.Printf("Can't write out report file %s: %v\n", path, err)
+			return
+		}
+		r.addArtifact(name, path)
+		if name != JSONReportFile { // The JSON report is intended for tools, not people.
+			files = append(files, path)
+		}
+	}
+	write(r.opts.TextReport, ReportFile, func(w *bufio.Writer) error {
+		w.WriteString(banner)
+		if r.opts.OutDir != "" {
+			// Table details go in per-table files.
+			files := r.writeTableFiles(TableReportDir, ".txt", conv.SourceTables(), func(t string, w *bufio.Writer) {
+				w.WriteString(banner)
+				internal.WriteTableReport(conv, t, w, badWrites)
+			})
+			internal.GenerateSplitReport(src, conv, w, badWrites, files)
+			return nil
+		}
+		internal.GenerateReport(src, conv, w, badWrites)
+		return nil
+	})
+	write(r.opts.HTMLReport, HTMLReportFile, func(w *bufio.Writer) error {
+		return internal.GenerateHTMLReport(src, conv, w, badWrites, banner)
+	})
+	write(r.opts.JSONReport, JSONReportFile, func(w *bufio.Writer) error {
+		return internal.GenerateJSONReport(src, conv, w, badWrites)
+	})
+	if r.fromDump() {
+		files := ""
+		if n := len(r.opts.Inputs); n > 0 {
+			files = fmt.Sprintf(" in %d files", n)
+		}
+		r.log.Printf("Processed %d bytes of %s data%s (%d statements, %d rows of data, %d errors, %d unexpected conditions).\n",
+			r.bytesRead, src.Name, files, conv.Statements(), conv.Rows(), conv.StatementErrors(), conv.Unexpecteds())
+	} else if r.opts.Driver == CSV {
+		r.log.Printf("Processed %d CSV files (%d rows of data, %d unexpected conditions).\n",
+			r.csvFileCount(), conv.Rows(), conv.Unexpecteds())
+	} else {
+		r.log.Printf("Processed source database via %s driver (%d rows of data, %d unexpected conditions).\n",
+			r.opts.Driver, conv.Rows(), conv.Unexpecteds())
+	}
+	r.log.Printf("%s", r.res.Summary)
+	if len(files) > 0 {
+		r.log.Printf("See file '%s' for details of the schema and data conversions.\n", strings.Join(files, "' and '"))
+	}
+}
+
+func writeFile(name string, gen func(w *bufio.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if err := gen(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
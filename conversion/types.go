@@ -0,0 +1,354 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conversion
+
+import (
+	"io"
+	"time"
+
+	"github.com/cloudspannerecosystem/harbourbridge/internal"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// The conversion API uses types from the internal package, which Go
+// programs outside HarbourBridge can't import. The following aliases
+// make them nameable, so that programs can fill in Options and use
+// the Conv and Result returned by Run.
+
+// Conv is the state of a conversion (see Run).
+type Conv = internal.Conv
+
+// Types used in Options.
+type (
+	TableOptions        = internal.TableOptions
+	TypeMap             = internal.TypeMap
+	TypeOverride        = internal.TypeOverride
+	ColumnTransforms    = internal.ColumnTransforms
+	Session             = internal.Session
+	SyntheticPKStrategy = internal.SyntheticPKStrategy
+	InheritanceStrategy = internal.InheritanceStrategy
+	StringOverflow      = internal.StringOverflow
+	FloatPolicy         = internal.FloatPolicy
+	UTF8Strategy        = internal.UTF8Strategy
+	CommitTimestampFill = internal.CommitTimestampFill
+	RowSampling         = internal.RowSampling
+	DynamoDBExport      = internal.DynamoDBExport
+	CSVSchema           = internal.CSVSchema
+	WritePriority       = internal.WritePriority
+	DuplicateCopy       = internal.DuplicateCopy
+	IssueOverrides      = internal.IssueOverrides
+	ReportOrder         = internal.ReportOrder
+	ReportLevel         = internal.ReportLevel
+	RatingThresholds    = internal.RatingThresholds
+	Dialect             = ddl.Dialect
+)
+
+// CommitTimestampOption specifies a commit timestamp column, for
+// Options.CommitTS and TableOptions.
+type CommitTimestampOption = internal.CommitTimestampOption
+
+// ColumnOptions specifies Spanner column options, for
+// TableOptions.ColumnOptions.
+type ColumnOptions = internal.ColumnOptions
+
+// Parts of a CSVSchema, for Options.CSV.
+type (
+	CSVTable  = internal.CSVTable
+	CSVColumn = internal.CSVColumn
+	CSVFormat = internal.CSVFormat
+)
+
+// Synthetic primary key strategies (see Options.SyntheticPK).
+const (
+	SyntheticPKBitReversed = internal.SyntheticPKBitReversed
+	SyntheticPKSequential  = internal.SyntheticPKSequential
+	SyntheticPKUUID        = internal.SyntheticPKUUID
+)
+
+// Inheritance strategies (see Options.Inheritance).
+const (
+	InheritanceSeparate = internal.InheritanceSeparate
+	InheritanceMerge    = internal.InheritanceMerge
+)
+
+// String overflow policies (see Options.Overflow).
+const (
+	StringOverflowReject   = internal.StringOverflowReject
+	StringOverflowTruncate = internal.StringOverflowTruncate
+)
+
+// Float policies (see Options.Floats).
+const (
+	FloatReject = internal.FloatReject
+	FloatNull   = internal.FloatNull
+	FloatClamp  = internal.FloatClamp
+)
+
+// Duplicate COPY-FROM policies (see Options.Duplicates).
+const (
+	DuplicateCopySkip   = internal.DuplicateCopySkip
+	DuplicateCopyAppend = internal.DuplicateCopyAppend
+)
+
+// Spanner database dialects (see Options.Dialect).
+const (
+	DialectGoogleStandardSQL = ddl.GoogleStandardSQL
+	DialectPostgreSQL        = ddl.PostgreSQL
+)
+
+// Report orders (see Options.ReportOrder).
+const (
+	ReportOrderAlpha    = internal.ReportOrderAlpha
+	ReportOrderRows     = internal.ReportOrderRows
+	ReportOrderProblems = internal.ReportOrderProblems
+)
+
+// Report levels (see Options.ReportLevel).
+const (
+	ReportLevelFull  = internal.ReportLevelFull
+	ReportLevelTerse = internal.ReportLevelTerse
+)
+
+// Write priorities (see Options.WritePriority).
+const (
+	WritePriorityLow    = internal.WritePriorityLow
+	WritePriorityMedium = internal.WritePriorityMedium
+	WritePriorityHigh   = internal.WritePriorityHigh
+)
+
+// Commit timestamp fills (see Options.CommitTS).
+const (
+	CommitTimestampNull   = internal.CommitTimestampNull
+	CommitTimestampCommit = internal.CommitTimestampCommit
+)
+
+// MaxStringLength is the maximum length of Spanner STRING(N) columns
+// (see Options.StringLength).
+const MaxStringLength = internal.MaxStringLength
+
+// DefaultDynamoDBSampleSize is the default number of values of each
+// DynamoDB attribute used to infer its type (see
+// DynamoDBExport.SampleSize).
+const DefaultDynamoDBSampleSize = internal.DefaultDynamoDBSampleSize
+
+// Types used in Result. Report is the structured version of the
+// report: the same information as report.txt (and the JSON report),
+// for programs that need per-table ratings, issues and row stats.
+type (
+	Rating              = internal.Rating
+	Ratings             = internal.Ratings
+	ResourceUsage       = internal.ResourceUsage
+	Report              = internal.Report
+	ReportRatings       = internal.ReportRatings
+	ReportRating        = internal.ReportRating
+	ReportTable         = internal.ReportTable
+	ReportIssue         = internal.ReportIssue
+	ReportStatement     = internal.ReportStatement
+	ReportDroppedObject = internal.ReportDroppedObject
+	ReportUnexpected    = internal.ReportUnexpected
+)
+
+// Ratings, from worst to best (see Rating).
+const (
+	RatingNone         = internal.RatingNone
+	RatingSkipped      = internal.RatingSkipped
+	RatingInconsistent = internal.RatingInconsistent
+	RatingPoor         = internal.RatingPoor
+	RatingOK           = internal.RatingOK
+	RatingGood         = internal.RatingGood
+	RatingExcellent    = internal.RatingExcellent
+)
+
+// ReadTableOptions reads a table options file for Options.TableOptions.
+func ReadTableOptions(r io.Reader) (map[string]TableOptions, error) {
+	return internal.ReadTableOptions(r)
+}
+
+// ReadTypeMap reads a JSON or YAML type map file for Options.TypeMap.
+func ReadTypeMap(r io.Reader) (TypeMap, error) {
+	return internal.ReadTypeMap(r)
+}
+
+// ReadCSVSchema reads a JSON or YAML CSV schema file for Options.CSV.
+// File names in the schema are used as is: callers resolve relative
+// names.
+func ReadCSVSchema(r io.Reader) (*CSVSchema, error) {
+	return internal.ReadCSVSchema(r)
+}
+
+// ReadColumnTransforms reads a JSON or YAML column transforms file for
+// Options.Transforms.
+func ReadColumnTransforms(r io.Reader) (ColumnTransforms, error) {
+	return internal.ReadColumnTransforms(r)
+}
+
+// ReadSession reads a session file (see Options.WriteSession) for
+// Options.Session.
+func ReadSession(r io.Reader) (*Session, error) {
+	return internal.ReadSession(r)
+}
+
+// ParseSyntheticPKStrategy parses the name of a synthetic primary key
+// strategy e.g. "uuid". The empty string means the default.
+func ParseSyntheticPKStrategy(s string) (SyntheticPKStrategy, error) {
+	return internal.ParseSyntheticPKStrategy(s)
+}
+
+// ParseInheritanceStrategy parses the name of an inheritance strategy
+// e.g. "merge". The empty string means the default.
+func ParseInheritanceStrategy(s string) (InheritanceStrategy, error) {
+	return internal.ParseInheritanceStrategy(s)
+}
+
+// ParseStringOverflow parses the name of a string overflow policy e.g.
+// "truncate". The empty string means the default.
+func ParseStringOverflow(s string) (StringOverflow, error) {
+	return internal.ParseStringOverflow(s)
+}
+
+// ParseDuplicateCopy parses the name of a duplicate COPY-FROM policy
+// e.g. "append". The empty string means the default.
+func ParseDuplicateCopy(s string) (DuplicateCopy, error) {
+	return internal.ParseDuplicateCopy(s)
+}
+
+// ParseReportOrder parses the name of a report order e.g. "problems".
+// The empty string means the default.
+func ParseReportOrder(s string) (ReportOrder, error) {
+	return internal.ParseReportOrder(s)
+}
+
+// ParseReportLevel parses the name of a report level e.g. "terse". The
+// empty string means the default.
+func ParseReportLevel(s string) (ReportLevel, error) {
+	return internal.ParseReportLevel(s)
+}
+
+// ParseRatingThresholds parses rating thresholds for Options.Thresholds,
+// given as percentages e.g. "good=2,ok=10". Thresholds that aren't
+// given keep their default value.
+func ParseRatingThresholds(s string) (RatingThresholds, error) {
+	return internal.ParseRatingThresholds(s)
+}
+
+// ParseDialect parses the name of a Spanner database dialect e.g.
+// "postgresql". The empty string means GoogleSQL.
+func ParseDialect(s string) (Dialect, error) {
+	return internal.ParseDialect(s)
+}
+
+// ParseWritePriority parses a write priority e.g. "low" (case
+// insensitive). The empty string means Spanner's default.
+func ParseWritePriority(s string) (WritePriority, error) {
+	return internal.ParseWritePriority(s)
+}
+
+// ParseFloatPolicy parses the name of a float policy e.g. "clamp". The
+// empty string means the default.
+func ParseFloatPolicy(s string) (FloatPolicy, error) {
+	return internal.ParseFloatPolicy(s)
+}
+
+// ParseUTF8Strategy parses a strategy for STRING values that aren't
+// valid UTF-8: "reject", "replace" or "transcode-from=<charset>" (e.g.
+// "transcode-from=latin1"). The empty string means the default.
+func ParseUTF8Strategy(s string) (UTF8Strategy, error) {
+	return internal.ParseUTF8Strategy(s)
+}
+
+// ParseCommitTimestampFill parses the name of a commit timestamp fill
+// e.g. "commit". The empty string means the default.
+func ParseCommitTimestampFill(s string) (CommitTimestampFill, error) {
+	return internal.ParseCommitTimestampFill(s)
+}
+
+// ParseTimeZone parses a time zone for Options.TimeZone: an IANA name
+// (e.g. "America/New_York") or a fixed offset (e.g. "+05:30"). The
+// empty string means UTC.
+func ParseTimeZone(s string) (*time.Location, error) {
+	return internal.ParseTimeZone(s)
+}
+
+// ParseIssueNames parses a comma-separated list of schema issue names
+// for Options.Issues e.g. "timestamp,widened".
+func ParseIssueNames(s string) []string {
+	return internal.ParseIssueNames(s)
+}
+
+// ParseIssueSeverities parses a comma-separated list of issue=severity
+// pairs for Options.Issues e.g. "numeric=note,widened=warning".
+func ParseIssueSeverities(s string) (map[string]string, error) {
+	return internal.ParseIssueSeverities(s)
+}
+
+// ParseRating parses the name of a rating e.g. "good".
+func ParseRating(s string) (Rating, error) {
+	return internal.ParseRating(s)
+}
+
+// Diagnostics are logged by a leveled logger, which is shared by all
+// conversions (see SetLog). By default, warnings and errors are logged
+// to stderr as text.
+type (
+	LogLevel    = internal.LogLevel
+	LogFormat   = internal.LogFormat
+	LevelLogger = internal.Logger
+)
+
+// Log levels, from least to most verbose (see LogLevel).
+const (
+	LogError = internal.LogError
+	LogWarn  = internal.LogWarn
+	LogInfo  = internal.LogInfo
+	LogDebug = internal.LogDebug
+)
+
+// Log formats (see LogFormat).
+const (
+	LogText = internal.LogText
+	LogJSON = internal.LogJSON
+)
+
+// ParseLogLevel parses a log level: "error", "warn", "info" or "debug".
+func ParseLogLevel(s string) (LogLevel, error) {
+	return internal.ParseLogLevel(s)
+}
+
+// ParseLogFormat parses a log format: "text" or "json".
+func ParseLogFormat(s string) (LogFormat, error) {
+	return internal.ParseLogFormat(s)
+}
+
+// NewLogger returns a logger that writes messages at or below level to
+// w, in the given format. It can also be used as Options.Logger, which
+// logs status messages at info level.
+func NewLogger(w io.Writer, level LogLevel, format LogFormat) *LevelLogger {
+	return internal.NewLogger(w, level, format)
+}
+
+// SetLog sets the logger for diagnostics. Since it is shared by all
+// conversions, it should be called before any conversion is run.
+func SetLog(l *LevelLogger) {
+	internal.SetLog(l)
+}
+
+// Metrics holds metrics about a conversion (see Options.Metrics). It is
+// an http.Handler that serves them in the Prometheus text format.
+type Metrics = internal.Metrics
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return internal.NewMetrics()
+}
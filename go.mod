@@ -3,16 +3,19 @@ module github.com/cloudspannerecosystem/harbourbridge
 go 1.13
 
 require (
-	cloud.google.com/go v0.55.0
-	cloud.google.com/go/spanner v1.4.0
+	cloud.google.com/go v0.88.0
+	cloud.google.com/go/spanner v1.24.0
 	github.com/DATA-DOG/go-sqlmock v1.4.1
+	github.com/denisenkom/go-mssqldb v0.11.0
 	github.com/goware/modvendor v0.0.0-20190516042800-ce72b408a8fe // indirect
 	github.com/lfittl/pg_query_go v1.0.0
 	github.com/lib/pq v1.3.0
 	github.com/mattn/go-zglob v0.0.1 // indirect
-	github.com/stretchr/testify v1.4.0
-	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
-	google.golang.org/api v0.20.0
-	google.golang.org/genproto v0.0.0-20200318110522-7735f76e9fa5
-	google.golang.org/grpc v1.28.0
+	github.com/stretchr/testify v1.6.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/text v0.3.6
+	google.golang.org/api v0.51.0
+	google.golang.org/genproto v0.0.0-20210726143408-b02e89920bf0
+	google.golang.org/grpc v1.39.0
+	gopkg.in/yaml.v2 v2.2.3
 )
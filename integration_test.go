@@ -17,6 +17,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -25,6 +26,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,6 +35,9 @@ import (
 	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
 	"google.golang.org/api/iterator"
 	databasepb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
+
+	"github.com/cloudspannerecosystem/harbourbridge/conversion"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
 )
 
 var (
@@ -131,7 +136,7 @@ func TestIntegration_SimpleUse(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to open the test data file: %v", err)
 	}
-	err = toSpanner("pgdump", projectID, instanceID, dbName, &ioStreams{in: f, out: os.Stdout}, filePrefix, now)
+	_, err = toSpanner(context.Background(), "pgdump", projectID, instanceID, dbName, nil, &ioStreams{in: f, out: os.Stdout}, filePrefix, now)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -176,6 +181,61 @@ func TestIntegration_Command(t *testing.T) {
 	checkResults(t, dbPath)
 }
 
+// TestIntegration_Emulator runs an end-to-end conversion against the
+// Spanner emulator (e.g. the gcr.io/cloud-spanner-emulator/emulator
+// container), which needs no GCP project or credentials. It runs if
+// SPANNER_EMULATOR_HOST is set.
+func TestIntegration_Emulator(t *testing.T) {
+	if testing.Short() || os.Getenv("SPANNER_EMULATOR_HOST") == "" {
+		t.Skip("Emulator integration tests skipped: SPANNER_EMULATOR_HOST is missing")
+	}
+	tmpdir, err := ioutil.TempDir(".", "int-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	now := time.Now()
+	dbName, _ := getDatabaseName(now)
+	f, err := os.Open("test_data/pg_dump.test.out")
+	if err != nil {
+		t.Fatalf("failed to open the test data file: %v", err)
+	}
+	defer f.Close()
+	conv, res, err := conversion.Run(context.Background(), conversion.Options{
+		Input:      f,
+		Project:    emulatorProject,
+		Instance:   emulatorInstance,
+		DBName:     dbName,
+		FilePrefix: filepath.Join(tmpdir, dbName+"."),
+		TextReport: true,
+		Verify:     true,
+		Now:        now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tables []string
+	for _, s := range conv.GetDDL(ddl.Config{}) {
+		tables = append(tables, strings.SplitN(s, "\n", 2)[0])
+	}
+	wantTables := []string{"CREATE TABLE cart (", "CREATE TABLE test (", "CREATE TABLE test2 (", "CREATE TABLE test3 ("}
+	if !reflect.DeepEqual(tables, wantTables) {
+		t.Fatalf("DDL is not correct: got %q, want %q", tables, wantTables)
+	}
+	if got, want := res.RowsWritten, int64(12); got != want {
+		t.Fatalf("rows written is not correct: got %d, want %d", got, want)
+	}
+	if res.Ratings.Schema != conversion.RatingExcellent || res.Ratings.Data != conversion.RatingExcellent {
+		t.Fatalf("ratings are not correct: got %+v, want EXCELLENT schema and data", res.Ratings)
+	}
+	if res.Mismatches != 0 {
+		t.Fatalf("verification found %d tables with mismatched row counts", res.Mismatches)
+	}
+	checkResults(t, res.Database)
+}
+
 func checkResults(t *testing.T, dbPath string) {
 	// Make a query to check results.
 	ctx := context.Background()
@@ -289,3 +349,19 @@ func checkArrays(ctx context.Context, t *testing.T, client *spanner.Client) {
 		t.Fatalf("string array is not correct: got %v, want %v", got, want)
 	}
 }
+
+// getDatabaseName returns a random database name, so that tests that
+// run in parallel don't collide.
+func getDatabaseName(now time.Time) (string, error) {
+	return generateName(fmt.Sprintf("pg_dump_%s", now.Format("2006-01-02")))
+}
+
+func generateName(prefix string) (string, error) {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("error generating name: %w", err)
+
+	}
+	return fmt.Sprintf("%s_%x-%x", prefix, b[0:2], b[2:4]), nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+)
+
+// SchemaAssessment is a structured summary of the schema conversion
+// of a single source database. Aggregate assessments combine
+// SchemaAssessments for many databases into a single roll-up report
+// (see GenerateAggregateReport).
+type SchemaAssessment struct {
+	Source       string           // Name of the source (e.g. dump file or redacted connection string).
+	Err          error            // Non-nil if the source could not be assessed.
+	Tables       int64            // Number of tables.
+	Cols         int64            // Number of columns.
+	Warnings     int64            // Number of warnings (see analyzeCols for how these are counted).
+	MissingPKeys int64            // Number of tables without a primary key.
+	Category     Rating           // Schema rating category e.g. RatingGood.
+	Rating       string           // Schema rating e.g. "GOOD (most columns mapped cleanly)".
+	Issues       map[string]int64 // Count of columns affected by each type of schema issue.
+}
+
+// AssessSchema builds a SchemaAssessment for a source database whose
+// schema has been converted into conv.
+func AssessSchema(source string, conv *Conv) SchemaAssessment {
+	a := SchemaAssessment{Source: source, Issues: make(map[string]int64)}
+	var listed int64
+	for _, t := range analyzeTables(conv, nil) {
+		a.Tables++
+		a.Cols += t.cols
+		a.Warnings += t.warnings
+		listed += t.listed
+		if t.syntheticPKey != "" {
+			a.MissingPKeys++
+		}
+		for i, n := range t.issueCols {
+			if i != missingPrimaryKey { // Counted in MissingPKeys.
+				a.Issues[i.String()] += n
+			}
+		}
+	}
+	a.Category, a.Rating = rateSchema(schemaCounts{cols: a.Cols, warnings: a.Warnings, listed: listed, missingPKey: a.MissingPKeys > 0}, true, conv.ratingThresholds())
+	return a
+}
+
+// Order in which rating categories are listed (best first).
+var ratingCategories = []Rating{RatingExcellent, RatingGood, RatingOK, RatingPoor, RatingNone}
+
+// GenerateAggregateReport writes a roll-up report for a set of schema
+// assessments to w: overall totals, the distribution of schema ratings,
+// issue totals across databases, and a list of databases ranked from
+// worst to best.
+func GenerateAggregateReport(l []SchemaAssessment, w *bufio.Writer) {
+	var ok, failed []SchemaAssessment
+	for _, a := range l {
+		if a.Err != nil {
+			failed = append(failed, a)
+		} else {
+			ok = append(ok, a)
+		}
+	}
+	var tables, cols, missingPKeys int64
+	ratings := make(map[Rating]int64)
+	issues := make(map[string]int64)
+	issueDBs := make(map[string]int64) // Number of databases with each issue.
+	for _, a := range ok {
+		tables += a.Tables
+		cols += a.Cols
+		missingPKeys += a.MissingPKeys
+		ratings[a.Category]++
+		for i, n := range a.Issues {
+			issues[i] += n
+			issueDBs[i]++
+		}
+	}
+
+	writeHeading(w, "Aggregate Assessment")
+	justifyLines(w, fmt.Sprintf("Assessed %d source databases (%d succeeded, %d failed): "+
+		"%d tables, %d columns, %d tables without primary keys.",
+		len(l), len(ok), len(failed), tables, cols, missingPKeys), 80, 0)
+	w.WriteString("\n\n")
+
+	writeHeading(w, "Schema Ratings")
+	for _, r := range ratingCategories {
+		fmt.Fprintf(w, "  %-10s %d\n", r, ratings[r])
+	}
+	w.WriteString("\n")
+
+	if len(issues) > 0 {
+		writeHeading(w, "Schema Issues")
+		w.WriteString("Columns affected by each issue, summed across databases.\n")
+		var names []string
+		n := 0
+		for i := range issues {
+			names = append(names, i)
+			if len(i) > n {
+				n = len(i)
+			}
+		}
+		// Most frequent issues first; break ties alphabetically.
+		sort.Slice(names, func(i, j int) bool {
+			if issues[names[i]] != issues[names[j]] {
+				return issues[names[i]] > issues[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		for _, i := range names {
+			fmt.Fprintf(w, "  %-*s  %6d  (%d databases)\n", n, i, issues[i], issueDBs[i])
+		}
+		w.WriteString("\n")
+	}
+
+	if len(ok) > 0 {
+		writeHeading(w, "Databases Ranked by Schema Quality (Worst First)")
+		ranked := append([]SchemaAssessment{}, ok...)
+		sort.SliceStable(ranked, func(i, j int) bool { return worseSchema(ranked[i], ranked[j]) })
+		for i, a := range ranked {
+			justifyLines(w, fmt.Sprintf("%d) %s: %s. %s%% of columns mapped cleanly, %d tables without primary keys.\n",
+				i+1, a.Source, a.Rating, pct(a.Cols, a.Warnings), a.MissingPKeys), 80, 3)
+		}
+		w.WriteString("\n")
+	}
+
+	if len(failed) > 0 {
+		writeHeading(w, "Failed Sources")
+		for i, a := range failed {
+			justifyLines(w, fmt.Sprintf("%d) %s: %s.\n", i+1, a.Source, a.Err), 80, 3)
+		}
+		w.WriteString("\n")
+	}
+}
+
+// worseSchema returns true if a's schema conversion is worse than b's:
+// a higher fraction of warnings, then more missing primary keys, then
+// alphabetical order of source (for determinism).
+func worseSchema(a, b SchemaAssessment) bool {
+	fa, fb := warningFrac(a), warningFrac(b)
+	if fa != fb {
+		return fa > fb
+	}
+	if a.MissingPKeys != b.MissingPKeys {
+		return a.MissingPKeys > b.MissingPKeys
+	}
+	return a.Source < b.Source
+}
+
+func warningFrac(a SchemaAssessment) float64 {
+	if a.Cols == 0 {
+		return 0
+	}
+	return float64(a.Warnings) / float64(a.Cols)
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssessSchema(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t1 (a bigint PRIMARY KEY, b numeric, c numeric);\n" +
+		"CREATE TABLE t2 (a int, b timestamp);\n")
+	expected := SchemaAssessment{
+		Source:       "db1",
+		Tables:       2,
+		Cols:         5,
+		Warnings:     2,
+		MissingPKeys: 1,
+		Category:     RatingPoor,
+		Rating:       "POOR (many columns did not map cleanly + some missing primary keys; 2 warnings across 5 columns)",
+		Issues:       map[string]int64{"numeric": 2, "timestamp": 1, "widened": 1},
+	}
+	assert.Equal(t, expected, AssessSchema("db1", conv))
+}
+
+func TestGenerateAggregateReport(t *testing.T) {
+	l := []SchemaAssessment{
+		{Source: "good.sql", Tables: 3, Cols: 10, Category: RatingExcellent, Rating: "EXCELLENT (all columns mapped cleanly)", Issues: map[string]int64{"widened": 2}},
+		{Source: "bad.sql", Tables: 2, Cols: 4, Warnings: 3, MissingPKeys: 1, Category: RatingPoor, Rating: "POOR (many columns did not map cleanly + some missing primary keys)",
+			Issues: map[string]int64{"numeric": 3, "widened": 1}},
+		{Source: "missing.sql", Err: fmt.Errorf("open missing.sql: no such file or directory")},
+		{Source: "ok.sql", Tables: 1, Cols: 10, Warnings: 2, Category: RatingOK, Rating: "OK (some columns did not map cleanly)", Issues: map[string]int64{"serial": 2}},
+	}
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateAggregateReport(l, w)
+	w.Flush()
+	expected := `----------------------------
+Aggregate Assessment
+----------------------------
+Assessed 4 source databases (3 succeeded, 1 failed): 6 tables, 24 columns, 1
+tables without primary keys.
+
+----------------------------
+Schema Ratings
+----------------------------
+  EXCELLENT  1
+  GOOD       0
+  OK         1
+  POOR       1
+  NONE       0
+
+----------------------------
+Schema Issues
+----------------------------
+Columns affected by each issue, summed across databases.
+  numeric       3  (1 databases)
+  widened       3  (2 databases)
+  serial        2  (1 databases)
+
+----------------------------
+Databases Ranked by Schema Quality (Worst First)
+----------------------------
+1) bad.sql: POOR (many columns did not map cleanly + some missing primary keys).
+   25% of columns mapped cleanly, 1 tables without primary keys.
+2) ok.sql: OK (some columns did not map cleanly). 80% of columns mapped cleanly,
+   0 tables without primary keys.
+3) good.sql: EXCELLENT (all columns mapped cleanly). 100% of columns mapped
+   cleanly, 0 tables without primary keys.
+
+----------------------------
+Failed Sources
+----------------------------
+1) missing.sql: open missing.sql: no such file or directory.
+
+`
+	assert.Equal(t, expected, buf.String())
+}
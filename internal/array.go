@@ -0,0 +1,154 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Parsing of PostgreSQL array literals, as written by the array output
+// routine (see section 8.15.6 of
+// https://www.postgresql.org/docs/current/arrays.html). Elements are
+// separated by commas, and are double-quoted if they are empty, contain
+// braces, commas, double quotes, backslashes or white space, or match
+// the word NULL. Within elements, a backslash quotes the next
+// character. An unquoted NULL is a NULL element.
+
+// dimsRE matches the optional dimension decoration of arrays whose
+// lower bounds aren't 1, e.g. "[0:2]=" for one dimension.
+var dimsRE = regexp.MustCompile(`^((?:\[-?\d+:-?\d+\])+)\s*=\s*`)
+
+// arrayDimsError is the error for array values with more than one
+// dimension in columns mapped to (one-dimensional) Spanner arrays.
+// PostgreSQL doesn't enforce the declared dimensions of array columns,
+// so any array column can have such values. Rows with them are bad
+// rows with reason BadRowArrayDims.
+type arrayDimsError struct {
+	val string
+}
+
+func (e *arrayDimsError) Error() string {
+	return fmt.Sprintf("can't convert array %s: Spanner arrays have one dimension", e.val)
+}
+
+func (conv *Conv) addMultiDimArray(srcTable, srcCol string) {
+	if conv.multiDimRows[srcTable] == nil {
+		conv.multiDimRows[srcTable] = make(map[string]int64)
+	}
+	conv.multiDimRows[srcTable][srcCol]++
+}
+
+// describeMultiDimArray describes the rows whose values of srcCol of
+// srcTable had more than one dimension, for reports.
+func (conv *Conv) describeMultiDimArray(srcTable, srcCol string) string {
+	n := conv.multiDimRows[srcTable][srcCol]
+	what := "rows have multi-dimensional values, and are bad rows"
+	if n == 1 {
+		what = "row has a multi-dimensional value, and is a bad row"
+	}
+	return fmt.Sprintf("Column '%s': %d %s", srcCol, n, what)
+}
+
+// parseArray parses one-dimensional PostgreSQL array literal v,
+// returning its elements and whether each is NULL. Elements are
+// unquoted and unescaped. It returns an arrayDimsError if v has more
+// than one dimension.
+func parseArray(v string) (elems []string, nulls []bool, err error) {
+	v = strings.TrimSpace(v)
+	if m := dimsRE.FindStringSubmatch(v); m != nil {
+		if strings.Count(m[1], "[") > 1 {
+			return nil, nil, &arrayDimsError{v}
+		}
+		v = v[len(m[0]):]
+	}
+	if len(v) < 2 || v[0] != '{' || v[len(v)-1] != '}' {
+		return nil, nil, fmt.Errorf("unrecognized data format for array: expected {v1, v2, ...}")
+	}
+	body := v[1 : len(v)-1]
+	if strings.TrimSpace(body) == "" {
+		return []string{}, []bool{}, nil
+	}
+	for i := 0; ; i++ {
+		for i < len(body) && isArraySpace(body[i]) {
+			i++
+		}
+		var b strings.Builder
+		literal := false // Whether the element can't be NULL (it's quoted or escaped).
+		var elem string
+		switch {
+		case i < len(body) && body[i] == '{':
+			return nil, nil, &arrayDimsError{v}
+		case i < len(body) && body[i] == '"':
+			literal = true
+			for i++; i < len(body) && body[i] != '"'; i++ {
+				if body[i] == '\\' && i+1 < len(body) {
+					i++
+				}
+				b.WriteByte(body[i])
+			}
+			if i == len(body) {
+				return nil, nil, fmt.Errorf("unterminated quoted element in array %s", v)
+			}
+			i++ // Closing quote.
+			elem = b.String()
+		default:
+			// Unquoted elements end at the next comma, and lose
+			// trailing white space (but not escaped white space).
+			kept := 0 // Length of b up to its last escaped character.
+			for ; i < len(body) && body[i] != ','; i++ {
+				switch c := body[i]; {
+				case c == '{' || c == '}' || c == '"':
+					return nil, nil, fmt.Errorf("unexpected %q in array %s", c, v)
+				case c == '\\' && i+1 < len(body):
+					i++
+					b.WriteByte(body[i])
+					kept = b.Len()
+					literal = true
+				default:
+					b.WriteByte(c)
+				}
+			}
+			elem = b.String()
+			elem = elem[:kept] + strings.TrimRight(elem[kept:], arraySpace)
+			if elem == "" {
+				return nil, nil, fmt.Errorf("empty element in array %s", v)
+			}
+		}
+		null := !literal && strings.EqualFold(elem, "NULL")
+		if null {
+			elem = ""
+		}
+		elems = append(elems, elem)
+		nulls = append(nulls, null)
+		for i < len(body) && isArraySpace(body[i]) {
+			i++
+		}
+		if i == len(body) {
+			return elems, nulls, nil
+		}
+		if body[i] != ',' {
+			return nil, nil, fmt.Errorf("unexpected %q in array %s", body[i], v)
+		}
+	}
+}
+
+// arraySpace is the white space of array literals.
+const arraySpace = " \t\n\r\v\f"
+
+func isArraySpace(c byte) bool {
+	return strings.IndexByte(arraySpace, c) >= 0
+}
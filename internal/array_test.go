@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseArray(t *testing.T) {
+	tests := []struct {
+		in    string
+		elems []string
+		nulls []bool
+		err   bool
+		dims  bool // Whether the error is an arrayDimsError.
+	}{
+		{in: "{}", elems: []string{}, nulls: []bool{}},
+		{in: "{ }", elems: []string{}, nulls: []bool{}},
+		{in: "{a,b}", elems: []string{"a", "b"}, nulls: []bool{false, false}},
+		{in: `{"a,b",c}`, elems: []string{"a,b", "c"}, nulls: []bool{false, false}},
+		{in: `{"say \"hi\"","back\\slash"}`, elems: []string{`say "hi"`, `back\slash`}, nulls: []bool{false, false}},
+		{in: `{"",x}`, elems: []string{"", "x"}, nulls: []bool{false, false}},
+		{in: `{NULL,null,"NULL",\NULL}`, elems: []string{"", "", "NULL", "NULL"}, nulls: []bool{true, true, false, false}},
+		{in: "{ 1 , 2 }", elems: []string{"1", "2"}, nulls: []bool{false, false}},
+		{in: `{a\ ,b}`, elems: []string{"a ", "b"}, nulls: []bool{false, false}},
+		{in: `{"{}","a}b"}`, elems: []string{"{}", "a}b"}, nulls: []bool{false, false}},
+		{in: "[0:1]={1,2}", elems: []string{"1", "2"}, nulls: []bool{false, false}},
+		{in: "{{1,2},{3,4}}", err: true, dims: true},
+		{in: "[1:2][1:1]={{1},{2}}", err: true, dims: true},
+		{in: "{1,,2}", err: true},
+		{in: `{"a}`, err: true},
+		{in: `{a"b"}`, err: true},
+		{in: "1,2", err: true},
+		{in: "", err: true},
+	}
+	for _, tc := range tests {
+		elems, nulls, err := parseArray(tc.in)
+		if tc.err {
+			assert.NotNil(t, err, tc.in)
+			_, dims := err.(*arrayDimsError)
+			assert.Equal(t, tc.dims, dims, tc.in)
+			continue
+		}
+		assert.Nil(t, err, tc.in)
+		assert.Equal(t, tc.elems, elems, tc.in)
+		assert.Equal(t, tc.nulls, nulls, tc.in)
+	}
+}
+
+func TestProcessPgDump_Arrays(t *testing.T) {
+	conv := MakeConv()
+	var buf bytes.Buffer
+	conv.SetBadRowWriter(NewBadRowWriter(&buf, "bad.jsonl", 1000))
+	conv, rows := runProcessPgDumpConv(conv, "CREATE TABLE t (id bigint PRIMARY KEY, s text[], i int8[], f float8[], b bool[], ts timestamptz[], d date[], by bytea[]);\n"+
+		"COPY public.t (id, s, i, f, b, ts, d, by) FROM stdin;\n"+
+		"1\t{\"a,b\",\"say \\\\\"hi\\\\\"\",\"\",NULL,\"NULL\"}\t{1,NULL,-2}\t{1.5,NULL}\t{t,f,NULL}\t{\"2020-01-02 03:04:05+00\",NULL}\t{2020-01-02,NULL}\t{\"\\\\\\\\x00ff\",NULL}\n"+
+		"2\t{}\t{}\t{}\t{}\t{}\t{}\t{}\n"+
+		"3\t\\N\t{{1,2},{3,4}}\t\\N\t\\N\t\\N\t\\N\t\\N\n"+
+		"4\t\\N\t{{5}}\t\\N\t\\N\t\\N\t\\N\t\\N\n"+
+		"\\.\n")
+	assert.Equal(t, 2, len(rows))
+	// Times have different locations, so check them separately.
+	ts := rows[0].vals[5].([]spanner.NullTime)
+	assert.Equal(t, 2, len(ts))
+	assert.True(t, getTime(t, "2020-01-02T03:04:05Z").Equal(ts[0].Time))
+	assert.Equal(t, []interface{}{
+		int64(1),
+		[]spanner.NullString{{StringVal: "a,b", Valid: true}, {StringVal: `say "hi"`, Valid: true}, {StringVal: "", Valid: true}, {Valid: false}, {StringVal: "NULL", Valid: true}},
+		[]spanner.NullInt64{{Int64: 1, Valid: true}, {Valid: false}, {Int64: -2, Valid: true}},
+		[]spanner.NullFloat64{{Float64: 1.5, Valid: true}, {Valid: false}},
+		[]spanner.NullBool{{Bool: true, Valid: true}, {Bool: false, Valid: true}, {Valid: false}},
+		[]spanner.NullTime{{Time: ts[0].Time, Valid: true}, {Valid: false}},
+		[]spanner.NullDate{{Date: getDate("2020-01-02"), Valid: true}, {Valid: false}},
+		[][]byte{{0x00, 0xff}, nil},
+	}, rows[0].vals)
+	assert.Equal(t, []interface{}{int64(2), []spanner.NullString{}, []spanner.NullString{}, []spanner.NullString{}, []spanner.NullString{}, []spanner.NullString{}, []spanner.NullString{}, []spanner.NullString{}}, rows[1].vals)
+
+	assert.Equal(t, int64(2), conv.BadRows())
+	assert.Equal(t, map[string]map[string]int64{"t": {"i": 2}}, conv.multiDimRows)
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r badRowRecord
+		assert.Nil(t, json.Unmarshal([]byte(l), &r))
+		assert.Equal(t, BadRowArrayDims, r.Reason)
+	}
+	b := new(bytes.Buffer)
+	w := bufio.NewWriter(b)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, normalizeSpace(b.String()), "Column 'i': 2 rows have multi-dimensional values, and are bad rows. Spanner doesn't support multi-dimensional arrays")
+}
@@ -0,0 +1,285 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Bad rows are counted by cause for each table, so that the report can
+// say why rows are bad (and which columns are responsible), rather than
+// just how many there are. The report can also give the first few
+// values responsible for each cause (see SetBadValueSamples).
+
+// maxBadValueLen is the length (in characters) of the longest example
+// value of bad rows shown in the report: longer values are redacted.
+const maxBadValueLen = 100
+
+// badRowCause is why a row is bad: the reason, with the source column
+// (and, for parse errors, its source type) where known, or the gRPC
+// code of the error for rows that Spanner rejected.
+type badRowCause struct {
+	reason BadRowReason
+	col    string
+	typ    string // Only for BadRowParse.
+	code   string // Only for BadRowWrite.
+}
+
+// badRowCauses counts the bad rows of a table by cause.
+type badRowCauses map[badRowCause]int64
+
+// badRowValues has example values of the bad rows of a table, keyed by
+// cause, formatted for the report.
+type badRowValues map[badRowCause][]string
+
+// badRowCount is the number of bad rows of a table with a cause. The
+// zero cause stands for rows that couldn't be converted for reasons
+// that aren't known (e.g. statements that couldn't be parsed).
+type badRowCount struct {
+	cause badRowCause
+	rows  int64
+}
+
+// conversionCause returns the cause of a row that couldn't be converted
+// because of err.
+func conversionCause(err error) badRowCause {
+	var c badRowCause
+	var ce *columnError
+	col := errors.As(err, &ce)
+	if col {
+		c.col = ce.col
+	}
+	var ne *nullError
+	var be *byteaError
+	var re *timestampRangeError
+	var fe *floatError
+	var ue *utf8Error
+	var de *arrayDimsError
+	var le *lengthError
+	var me *tableMismatchError
+	switch {
+	case errors.As(err, &ne):
+		c.reason, c.col = BadRowNull, ne.col
+	case errors.As(err, &be):
+		c.reason = BadRowBytea
+	case errors.As(err, &re):
+		c.reason = BadRowTimestampRange
+	case errors.As(err, &fe):
+		c.reason = BadRowFloat
+	case errors.As(err, &ue):
+		c.reason = BadRowUTF8
+	case errors.As(err, &de):
+		c.reason = BadRowArrayDims
+	case errors.As(err, &le), errors.Is(err, strconv.ErrRange):
+		c.reason = BadRowRange
+	case errors.As(err, &me):
+		c.reason = BadRowTableMismatch
+	case col:
+		c.reason, c.typ = BadRowParse, ce.typ
+	default:
+		c.reason = BadRowConversion
+	}
+	return c
+}
+
+// addBadRowCause counts n bad rows of srcTable with cause c.
+func (conv *Conv) addBadRowCause(srcTable string, c badRowCause, n int64) {
+	m, ok := conv.stats.badCauses[srcTable]
+	if !ok {
+		m = make(badRowCauses)
+		conv.stats.badCauses[srcTable] = m
+	}
+	m[c] += n
+}
+
+// SetBadValueSamples configures conv to keep up to n distinct example
+// values of the column responsible for each cause of bad rows, which
+// the report gives. Zero disables them, which is the default (e.g. for
+// sensitive data, which shouldn't end up in reports).
+func (conv *Conv) SetBadValueSamples(n int) {
+	conv.badValueLimit = n
+}
+
+// sampleBadValue keeps the value responsible for cause c of a bad row
+// of srcTable, if it's one of the first few. Only a bounded number of
+// values (of bounded length) is kept, however many rows are bad.
+func (conv *Conv) sampleBadValue(srcTable string, srcCols, vals []string, c badRowCause) {
+	if conv.badValueLimit <= 0 || c.col == "" || conv.transformed(srcTable, c.col) {
+		return
+	}
+	v := "NULL"
+	if c.reason != BadRowNull {
+		i := 0
+		for i < len(srcCols) && srcCols[i] != c.col {
+			i++
+		}
+		if i >= len(vals) {
+			return
+		}
+		v = formatBadValue(vals[i])
+	}
+	m, ok := conv.badValues[srcTable]
+	if !ok {
+		if conv.badValues == nil {
+			conv.badValues = make(map[string]badRowValues)
+		}
+		m = make(badRowValues)
+		conv.badValues[srcTable] = m
+	}
+	l := m[c]
+	if len(l) >= conv.badValueLimit {
+		return
+	}
+	for _, x := range l {
+		if x == v {
+			return
+		}
+	}
+	m[c] = append(l, v)
+}
+
+// formatBadValue formats example value v of bad rows for the report:
+// quoted, or redacted if it is longer than maxBadValueLen characters.
+func formatBadValue(v string) string {
+	if n := utf8.RuneCountInString(v); n > maxBadValueLen {
+		return fmt.Sprintf("<%d characters, redacted>", n)
+	}
+	return strconv.Quote(v)
+}
+
+// badValueLines returns report lines with the example values of the bad
+// rows of srcTable (see SetBadValueSamples), most common cause first.
+func badValueLines(conv *Conv, srcTable string) []reportLine {
+	var l []reportLine
+	for _, x := range conv.badRowCounts(srcTable, 0, 0) {
+		vals := conv.badValues[srcTable][x.cause]
+		if len(vals) == 0 {
+			continue
+		}
+		l = append(l, reportLine{badValue, []string{x.cause.col}, fmt.Sprintf("%s e.g. %s", x.cause.describe(x.rows), strings.Join(vals, ", "))})
+	}
+	return l
+}
+
+// AddWriteErrors records counts of rows that Spanner rejected, keyed by
+// Spanner table and then by the gRPC code of the error (see
+// spanner.BatchWriter.DroppedRowsByCode). These rows are also bad
+// writes: this just records the reason, so that the report can give it.
+func (conv *Conv) AddWriteErrors(m map[string]map[string]int64) {
+	for spTable, codes := range m {
+		srcTable := spTable
+		if src, ok := conv.toSource[spTable]; ok {
+			srcTable = src.name
+		}
+		for code, n := range codes {
+			conv.addBadRowCause(srcTable, badRowCause{reason: BadRowWrite, code: code}, n)
+		}
+	}
+}
+
+// badRowCounts returns the bad rows of srcTable by cause, most common
+// first. badConv and badWrites are the table's totals of rows that
+// couldn't be converted and rows that couldn't be written: those not
+// accounted for by a cause are given as conversion rows with the zero
+// cause, and as write errors without a code, respectively.
+func (conv *Conv) badRowCounts(srcTable string, badConv, badWrites int64) []badRowCount {
+	var l []badRowCount
+	for c, n := range conv.stats.badCauses[srcTable] {
+		l = append(l, badRowCount{c, n})
+		if c.reason == BadRowWrite || c.reason == BadRowTooLarge {
+			badWrites -= n
+		} else {
+			badConv -= n
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].rows != l[j].rows {
+			return l[i].rows > l[j].rows
+		}
+		return l[i].cause.describe(1) < l[j].cause.describe(1)
+	})
+	if badWrites > 0 {
+		l = append(l, badRowCount{badRowCause{reason: BadRowWrite}, badWrites})
+	}
+	if badConv > 0 {
+		l = append(l, badRowCount{rows: badConv})
+	}
+	return l
+}
+
+// describe describes n bad rows with cause c e.g. "3 timestamp parse
+// errors (column 'created_at')".
+func (c badRowCause) describe(n int64) string {
+	plural := func(s string) string {
+		if n == 1 {
+			return s
+		}
+		return s + "s"
+	}
+	var col string
+	if c.col != "" {
+		col = fmt.Sprintf(" (column '%s')", c.col)
+	}
+	switch c.reason {
+	case BadRowParse:
+		return fmt.Sprintf("%d %s parse %s%s", n, c.typ, plural("error"), col)
+	case BadRowNull:
+		return fmt.Sprintf("%d NULL in NOT NULL column '%s'", n, c.col)
+	case BadRowRange:
+		return fmt.Sprintf("%d %s out of range%s", n, plural("value"), col)
+	case BadRowTimestampRange:
+		return fmt.Sprintf("%d %s outside Spanner's range%s", n, plural("timestamp"), col)
+	case BadRowFloat:
+		return fmt.Sprintf("%d NaN or infinite %s%s", n, plural("float"), col)
+	case BadRowUTF8:
+		return fmt.Sprintf("%d invalid UTF-8 %s%s", n, plural("string"), col)
+	case BadRowBytea:
+		return fmt.Sprintf("%d undecodable bytea %s%s", n, plural("value"), col)
+	case BadRowArrayDims:
+		return fmt.Sprintf("%d multi-dimensional %s%s", n, plural("array"), col)
+	case BadRowConversion:
+		return fmt.Sprintf("%d conversion %s%s", n, plural("error"), col)
+	case BadRowTooLarge:
+		return fmt.Sprintf("%d %s exceeding Spanner's commit size limit", n, plural("row"))
+	case BadRowTableMismatch:
+		return fmt.Sprintf("%d %s not loaded (table doesn't match the existing database)", n, plural("row"))
+	case BadRowRead:
+		return fmt.Sprintf("%d %s not read (error reading the source database)", n, plural("row"))
+	case BadRowWrite:
+		if c.code != "" {
+			return fmt.Sprintf("%d write %s (%s)", n, plural("error"), c.code)
+		}
+		return fmt.Sprintf("%d write %s", n, plural("error"))
+	}
+	return fmt.Sprintf("%d other", n)
+}
+
+// badRowsMsg describes the causes of a table's bad rows, or returns ""
+// if there are none.
+func badRowsMsg(l []badRowCount) string {
+	if len(l) == 0 {
+		return ""
+	}
+	var s []string
+	for _, x := range l {
+		s = append(s, x.cause.describe(x.rows))
+	}
+	return "Bad rows: " + strings.Join(s, ", ")
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBadRowCauses(t *testing.T) {
+	cols := []string{"id", "created_at", "email", "code"}
+	conv := buildConv(
+		ddl.CreateTable{
+			Name:     "t",
+			ColNames: cols,
+			ColDefs: map[string]ddl.ColumnDef{
+				"id":         ddl.ColumnDef{Name: "id", T: ddl.Int64{}},
+				"created_at": ddl.ColumnDef{Name: "created_at", T: ddl.Timestamp{}},
+				"email":      ddl.ColumnDef{Name: "email", T: ddl.String{Len: ddl.MaxLength{}}, NotNull: true},
+				"code":       ddl.ColumnDef{Name: "code", T: ddl.String{Len: ddl.Int64Length{Value: 2}}},
+			},
+			Pks: []ddl.IndexKey{ddl.IndexKey{Col: "id"}}},
+		schema.Table{
+			Name:     "t",
+			ColNames: cols,
+			ColDefs: map[string]schema.Column{
+				"id":         schema.Column{Name: "id", Type: schema.Type{Name: "int8"}},
+				"created_at": schema.Column{Name: "created_at", Type: schema.Type{Name: "timestamptz"}},
+				"email":      schema.Column{Name: "email", Type: schema.Type{Name: "text"}},
+				"code":       schema.Column{Name: "code", Type: schema.Type{Name: "varchar(2)"}},
+			}})
+	conv.SetLocation(time.UTC)
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+	for _, vals := range [][]string{
+		{"1", "2020-01-02 03:04:05+00", "a@b.c", "ok"},
+		{"2", "yesterday", "a@b.c", "ok"},
+		{"3", "last week", "a@b.c", "ok"},
+		{"4", "2020-01-02 03:04:05+00", nullMarker, "ok"},
+		{"99999999999999999999", "2020-01-02 03:04:05+00", "a@b.c", "ok"},
+		{"6", "2020-01-02 03:04:05+00", "a@b.c", "too long"},
+	} {
+		conv.statsAddRow("t", true)
+		ProcessDataRow(conv, "t", cols, vals)
+	}
+	conv.AddTooLargeRows(map[string]int64{"t": 1})
+	conv.AddWriteErrors(map[string]map[string]int64{"t": {"FailedPrecondition": 2}})
+	l := conv.badRowCounts("t", 5, 4)
+	assert.Equal(t, []badRowCount{
+		{badRowCause{reason: BadRowParse, col: "created_at", typ: "timestamptz"}, 2},
+		{badRowCause{reason: BadRowWrite, code: "FailedPrecondition"}, 2},
+		{badRowCause{reason: BadRowNull, col: "email"}, 1},
+		{badRowCause{reason: BadRowTooLarge}, 1},
+		{badRowCause{reason: BadRowRange, col: "code"}, 1},
+		{badRowCause{reason: BadRowRange, col: "id"}, 1},
+		{badRowCause{reason: BadRowWrite}, 1},
+	}, l)
+	assert.Equal(t, "Bad rows: 2 timestamptz parse errors (column 'created_at'), "+
+		"2 write errors (FailedPrecondition), 1 NULL in NOT NULL column 'email', "+
+		"1 row exceeding Spanner's commit size limit, 1 value out of range (column 'code'), "+
+		"1 value out of range (column 'id'), 1 write error", badRowsMsg(l))
+	// Bad rows without a known cause.
+	assert.Equal(t, []badRowCount{{rows: 3}}, conv.badRowCounts("u", 3, 0))
+	assert.Equal(t, "Bad rows: 3 other", badRowsMsg(conv.badRowCounts("u", 3, 0)))
+	assert.Equal(t, "", badRowsMsg(nil))
+}
+
+func TestBadValueSamples(t *testing.T) {
+	cols := []string{"id", "n", "s"}
+	conv := buildConv(
+		ddl.CreateTable{
+			Name:     "t",
+			ColNames: cols,
+			ColDefs: map[string]ddl.ColumnDef{
+				"id": ddl.ColumnDef{Name: "id", T: ddl.Int64{}, NotNull: true},
+				"n":  ddl.ColumnDef{Name: "n", T: ddl.Int64{}},
+				"s":  ddl.ColumnDef{Name: "s", T: ddl.String{Len: ddl.MaxLength{}}},
+			}},
+		schema.Table{
+			Name:     "t",
+			ColNames: cols,
+			ColDefs: map[string]schema.Column{
+				"id": schema.Column{Name: "id", Type: schema.Type{Name: "int8"}},
+				"n":  schema.Column{Name: "n", Type: schema.Type{Name: "int8"}},
+				"s":  schema.Column{Name: "s", Type: schema.Type{Name: "text"}},
+			}})
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+	process := func() {
+		long := strings.Repeat("x", 101)
+		for _, vals := range [][]string{
+			{"1", "a", "ok"},
+			{"2", "a", "ok"}, // Repeated values are only given once.
+			{"3", long, "ok"},
+			{"4", "b", "ok"}, // Over the limit.
+			{nullMarker, "1", "ok"},
+		} {
+			conv.statsAddRow("t", true)
+			ProcessDataRow(conv, "t", cols, vals)
+		}
+	}
+	// Disabled by default.
+	process()
+	assert.Nil(t, badValueLines(conv, "t"))
+
+	conv.stats.badCauses = make(map[string]badRowCauses)
+	conv.SetBadValueSamples(2)
+	process()
+	assert.Equal(t, []reportLine{
+		{badValue, []string{"n"}, `4 int8 parse errors (column 'n') e.g. "a", <101 characters, redacted>`},
+		{badValue, []string{"id"}, "1 NULL in NOT NULL column 'id' e.g. NULL"},
+	}, badValueLines(conv, "t"))
+	tr := buildTableReport(conv, "t", nil)
+	assert.Equal(t, "Examples of data conversion problems", tr.body[len(tr.body)-1].heading)
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// The bad-rows file is an opt-in feature (see SetBadRowWriter) that
+// records every bad row, both rows that fail conversion and rows that
+// can't be written to Spanner, as JSON lines. Unlike the bad-data file,
+// which only has a sample of bad rows, it is intended to be complete so
+// that users can go straight from the report to the offending data.
+
+// BadRowReason is the reason a row is bad.
+type BadRowReason string
+
+const (
+	// BadRowConversion means the row's values couldn't be converted to
+	// the Spanner schema.
+	BadRowConversion BadRowReason = "conversion"
+	// BadRowWrite means Spanner returned an error when writing the row.
+	BadRowWrite BadRowReason = "write"
+	// BadRowTooLarge means the row exceeds Spanner's commit size limit,
+	// so it was never sent to Spanner.
+	BadRowTooLarge BadRowReason = "too_large"
+	// BadRowBytea means a bytea value couldn't be decoded: it is in
+	// neither PostgreSQL's hex format nor its escape format.
+	BadRowBytea BadRowReason = "bytea"
+	// BadRowTimestampRange means a timestamp is outside Spanner's range
+	// (years 1 to 9999).
+	BadRowTimestampRange BadRowReason = "timestamp_range"
+	// BadRowFloat means a FLOAT64 value is NaN, infinite or out of
+	// range, and the float policy rejected it.
+	BadRowFloat BadRowReason = "float"
+	// BadRowUTF8 means a STRING value isn't valid UTF-8, and the UTF-8
+	// strategy couldn't fix it.
+	BadRowUTF8 BadRowReason = "invalid_utf8"
+	// BadRowArrayDims means an array value has more than one dimension,
+	// but its column is mapped to a (one-dimensional) Spanner array.
+	BadRowArrayDims BadRowReason = "array_dimensions"
+	// BadRowParse means a value couldn't be parsed as its column's type.
+	BadRowParse BadRowReason = "parse"
+	// BadRowNull means a value is NULL, but its Spanner column is NOT
+	// NULL.
+	BadRowNull BadRowReason = "null"
+	// BadRowRange means a value is out of range for its Spanner type:
+	// a number that overflows, or a string longer than its column
+	// allows.
+	BadRowRange BadRowReason = "out_of_range"
+	// BadRowTableMismatch means the row's Spanner table doesn't match
+	// the existing database that data is converted into, so the row
+	// was never sent to Spanner.
+	BadRowTableMismatch BadRowReason = "table_mismatch"
+	// BadRowRead means the row couldn't be read from the source
+	// database (e.g. the connection dropped while reading its table),
+	// so it was never converted.
+	BadRowRead BadRowReason = "read"
+)
+
+// badRowRecord is a line of the bad-rows file.
+type badRowRecord struct {
+	Table        string       `json:"table"`                  // Source table.
+	SpannerTable string       `json:"spannerTable,omitempty"` // Only for rows that couldn't be written.
+	Reason       BadRowReason `json:"reason"`
+	Error        string       `json:"error"`
+	Cols         []string     `json:"cols"`   // Source columns for conversion errors, Spanner columns otherwise.
+	Values       []string     `json:"values"` // Source values for conversion errors, converted values otherwise.
+}
+
+// BadRowWriter writes bad rows to a bad-rows file as JSON lines, up to
+// a limit on the size of the file. Unlike Conv, it is threadsafe, since
+// rows that can't be written to Spanner are reported by the goroutines
+// writing to Spanner.
+type BadRowWriter struct {
+	name      string // Name of the file (for the report).
+	limit     int64  // Limit on bytes written.
+	lock      sync.Mutex
+	w         io.Writer         // Protected by lock.
+	bytes     int64             // Bytes written; protected by lock.
+	rows      map[string]int64  // Rows written, keyed by source table; protected by lock.
+	truncated int64             // Rows not written because of limit; protected by lock.
+	err       error             // First error writing to w; protected by lock.
+	toSource  map[string]string // Maps Spanner table name to source table name. Read-only once set.
+}
+
+// NewBadRowWriter returns a BadRowWriter that writes to w, which is
+// described as name in reports. At most limit bytes are written.
+func NewBadRowWriter(w io.Writer, name string, limit int64) *BadRowWriter {
+	return &BadRowWriter{name: name, limit: limit, w: w, rows: make(map[string]int64)}
+}
+
+// SetBadRowWriter configures conv to write all bad rows to w. It should
+// be called after schema conversion (and any session has been applied),
+// since it snapshots the mapping from Spanner to source table names.
+func (conv *Conv) SetBadRowWriter(w *BadRowWriter) {
+	w.toSource = make(map[string]string)
+	for spTable, src := range conv.toSource {
+		w.toSource[spTable] = src.name
+	}
+	conv.badRowsOut = w
+}
+
+// AddWriteError records a row of Spanner table spTable that couldn't be
+// written to Spanner.
+func (w *BadRowWriter) AddWriteError(spTable string, cols []string, vals []interface{}, reason BadRowReason, err error) {
+	srcTable, ok := w.toSource[spTable]
+	if !ok {
+		srcTable = spTable
+	}
+	var l []string
+	for _, v := range vals {
+		l = append(l, fmt.Sprint(v))
+	}
+	w.add(badRowRecord{Table: srcTable, SpannerTable: spTable, Reason: reason, Error: err.Error(), Cols: cols, Values: l})
+}
+
+// Err returns the first error encountered writing the bad-rows file.
+func (w *BadRowWriter) Err() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.err
+}
+
+func (w *BadRowWriter) addConversionError(srcTable string, cols, vals []string, err error) {
+	w.add(badRowRecord{Table: srcTable, Reason: conversionCause(err).reason, Error: err.Error(), Cols: cols, Values: vals})
+}
+
+func (w *BadRowWriter) add(r badRowRecord) {
+	b, err := json.Marshal(r)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	// Once we hit an error, stop writing (but keep counting).
+	if err != nil || w.err != nil || w.bytes+int64(len(b))+1 > w.limit {
+		w.truncated++
+		return
+	}
+	b = append(b, '\n')
+	if _, err := w.w.Write(b); err != nil {
+		w.err = err
+		w.truncated++
+		return
+	}
+	w.bytes += int64(len(b))
+	w.rows[r.Table]++
+}
+
+// tableRows returns the number of rows of srcTable written to the
+// bad-rows file.
+func (w *BadRowWriter) tableRows(srcTable string) int64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.rows[srcTable]
+}
+
+// summary returns the total number of rows written to the bad-rows file,
+// and the number of bad rows that were not written because of the limit
+// on file size (or a write error).
+func (w *BadRowWriter) summary() (rows, truncated int64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, n := range w.rows {
+		rows += n
+	}
+	return rows, w.truncated
+}
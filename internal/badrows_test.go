@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBadRowWriter(t *testing.T) {
+	s := "CREATE TABLE \"a-b\" (id bigint PRIMARY KEY, n bigint);\n" +
+		"INSERT INTO \"a-b\" (id, n) VALUES (1, 2);\n" +
+		"INSERT INTO \"a-b\" (id, n) VALUES (2, 'x');\n"
+	conv := MakeConv()
+	conv.SetLocation(time.UTC)
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	var buf bytes.Buffer
+	w := NewBadRowWriter(&buf, "bad.jsonl", 1000)
+	conv.SetBadRowWriter(w)
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	// Rows that fail writes are reported by Spanner table.
+	w.AddWriteError("a_b", []string{"id", "n"}, []interface{}{int64(1), int64(2)}, BadRowWrite, fmt.Errorf("write failed"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	var r badRowRecord
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &r))
+	assert.Equal(t, "a-b", r.Table)
+	assert.Equal(t, BadRowParse, r.Reason)
+	assert.Equal(t, []string{"id", "n"}, r.Cols)
+	assert.Equal(t, []string{"2", "x"}, r.Values)
+	assert.NotEmpty(t, r.Error)
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &r))
+	assert.Equal(t, badRowRecord{Table: "a-b", SpannerTable: "a_b", Reason: BadRowWrite, Error: "write failed", Cols: []string{"id", "n"}, Values: []string{"1", "2"}}, r)
+
+	tr := buildTableReport(conv, "a-b", nil)
+	assert.Equal(t, int64(2), tr.badRowsLogged)
+	summary := GenerateSummary(conv, nil)
+	assert.Contains(t, summary, "Bad rows written to bad.jsonl: 2.\n")
+	assert.NotContains(t, summary, "is incomplete")
+	var out bytes.Buffer
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, &out, nil))
+	assert.Contains(t, out.String(), `"badRowsLogged": 2`)
+	assert.Contains(t, out.String(), `"path": "bad.jsonl"`)
+}
+
+func TestBadRowWriter_Limit(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewBadRowWriter(&buf, "bad.jsonl", 150)
+	for i := 0; i < 3; i++ {
+		w.addConversionError("t", []string{"a"}, []string{fmt.Sprint(i)}, fmt.Errorf("bad value"))
+	}
+	// Each line is 84 bytes, so only the first fits.
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+	rows, truncated := w.summary()
+	assert.Equal(t, int64(1), rows)
+	assert.Equal(t, int64(2), truncated)
+	assert.Nil(t, w.Err())
+
+	conv := MakeConv()
+	conv.SetBadRowWriter(w)
+	assert.Equal(t, []string{
+		"Bad rows written to bad.jsonl: 1",
+		"Note: bad.jsonl is incomplete: 2 bad rows were not written to it (its size limit is 150 bytes)",
+	}, badRowsFileSummary(conv))
+}
@@ -0,0 +1,329 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoints make data conversion from pg_dump resumable. Rows of each
+// source table are numbered in dump order, and a checkpoint records, for
+// each table, how many rows at the start of the table are settled: either
+// written to Spanner, or bad (and so never will be). Since writes to
+// Spanner complete out of order, rows after the first unsettled row may
+// also have been written; resumed conversions must tolerate rewriting them.
+//
+// Tables read from a source database in primary key order (see
+// ProcessSqlDataParallel) also record the key of the last settled row, so
+// that resumed conversions can read the table from after that key rather
+// than skipping rows by position.
+
+// checkpointVersion is the version of the checkpoint file format. It
+// should be incremented for any change that makes old checkpoints unusable.
+const checkpointVersion = 1
+
+// Checkpoint records the progress of data conversion from a dump.
+type Checkpoint struct {
+	Version  int                        `json:"version"`
+	DumpHash string                     `json:"dumpHash"` // Hash of the dump's contents (or the source database, see NewCheckpointTracker), to detect resumption with a different source.
+	Tables   map[string]TableCheckpoint `json:"tables"`   // Keyed by source table name.
+}
+
+// TableCheckpoint records the progress of data conversion for a table.
+type TableCheckpoint struct {
+	Rows      int64 `json:"rows"`      // Rows (in dump order) that have been written to Spanner, or are bad.
+	BadRows   int64 `json:"badRows"`   // Rows (of Rows) that couldn't be converted.
+	BadWrites int64 `json:"badWrites"` // Rows (of Rows) that converted, but couldn't be written to Spanner.
+
+	// Primary key of the last row of Rows (see keyText), for tables read
+	// from a source database in key order.
+	Key []string `json:"key,omitempty"`
+}
+
+// ReadCheckpoint reads a checkpoint written by a CheckpointTracker.
+func ReadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	var cp Checkpoint
+	if err := json.NewDecoder(r).Decode(&cp); err != nil {
+		return nil, fmt.Errorf("can't parse checkpoint: %w", err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d (expected %d)", cp.Version, checkpointVersion)
+	}
+	return &cp, nil
+}
+
+type rowState byte
+
+const (
+	rowPending rowState = iota
+	rowWritten
+	rowBadConversion
+	rowBadWrite
+)
+
+// tableProgress tracks the rows of a table that aren't yet settled.
+type tableProgress struct {
+	next    int64           // Position of the next row in the dump.
+	states  []rowState      // States of rows from position done.Rows up to next.
+	keys    [][]string      // Keys of the rows of states (nil for rows read without keys).
+	done    TableCheckpoint // Counts for the settled prefix of the table.
+	resumed TableCheckpoint // Counts for rows settled in previous runs.
+}
+
+type rowRef struct {
+	t   *tableProgress
+	pos int64
+}
+
+// CheckpointTracker tracks which rows have been settled during data
+// conversion, and writes a checkpoint to a file whenever this changes.
+// Rows that were settled in a previous run (see NewCheckpointTracker) are
+// skipped. CheckpointTracker is threadsafe, since rows are settled by the
+// goroutines writing to Spanner.
+type CheckpointTracker struct {
+	name     string // Checkpoint file.
+	dumpHash string
+	lock     sync.Mutex
+	tables   map[string]*tableProgress // Keyed by source table; protected by lock.
+	inFlight map[int64]rowRef          // Rows passed to the data sink but not yet settled, keyed by ID; protected by lock.
+	nextID   int64                     // Protected by lock.
+	current  int64                     // ID of the row being converted; protected by lock.
+	err      error                     // First error writing the checkpoint; protected by lock.
+}
+
+// NewCheckpointTracker returns a CheckpointTracker that writes
+// checkpoints for the dump with hash dumpHash to the file name. For
+// source databases, dumpHash identifies the database instead e.g.
+// "postgres:<database name>". If resume is non-nil, conversion resumes
+// from it: rows it records as settled are skipped. It is an error if
+// resume is for a different source.
+func NewCheckpointTracker(name, dumpHash string, resume *Checkpoint) (*CheckpointTracker, error) {
+	t := &CheckpointTracker{name: name, dumpHash: dumpHash, tables: make(map[string]*tableProgress), inFlight: make(map[int64]rowRef)}
+	if resume == nil {
+		return t, nil
+	}
+	if resume.DumpHash != dumpHash {
+		return nil, fmt.Errorf("checkpoint is for a different source (source is %s, but checkpoint has %s)", dumpHash, resume.DumpHash)
+	}
+	for name, tc := range resume.Tables {
+		t.tables[name] = &tableProgress{done: tc, resumed: tc}
+	}
+	return t, nil
+}
+
+// SetCheckpointTracker configures conv to track the progress of data
+// conversion with t. It must be called before data conversion starts.
+// Row counts for rows settled in a previous run are added to conv's
+// stats, so that the report covers the combined runs.
+func (conv *Conv) SetCheckpointTracker(t *CheckpointTracker) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for name, tp := range t.tables {
+		conv.statsAddGoodRows(name, tp.resumed.Rows-tp.resumed.BadRows)
+		conv.statsAddBadRows(name, tp.resumed.BadRows)
+		conv.stats.resumed += tp.resumed.Rows
+	}
+	conv.checkpoint = t
+}
+
+// CurrentRow returns the ID of the row currently being converted. It is
+// intended for use by data sinks, which pass it to the Spanner writer so
+// that the row can be settled once it has been written (see Settle).
+func (t *CheckpointTracker) CurrentRow() int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.current
+}
+
+// Settle records that the rows with the given IDs have been written to
+// Spanner (if err is nil) or dropped (if err is non-nil), and writes the
+// checkpoint if the settled prefix of any table has grown.
+func (t *CheckpointTracker) Settle(ids []int64, err error) {
+	state := rowWritten
+	if err != nil {
+		state = rowBadWrite
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	advanced := false
+	for _, id := range ids {
+		ref, ok := t.inFlight[id]
+		if !ok {
+			continue
+		}
+		delete(t.inFlight, id)
+		if ref.t.settle(ref.pos, state) {
+			advanced = true
+		}
+	}
+	if advanced {
+		t.write()
+	}
+}
+
+// Close writes the final checkpoint, and returns the first error
+// encountered writing the checkpoint.
+func (t *CheckpointTracker) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.write()
+	return t.err
+}
+
+// Checkpoint returns the current checkpoint.
+func (t *CheckpointTracker) Checkpoint() *Checkpoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.checkpoint()
+}
+
+// Resumed returns the progress made by previous runs, keyed by source
+// table (see NewCheckpointTracker).
+func (t *CheckpointTracker) Resumed() map[string]TableCheckpoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	m := make(map[string]TableCheckpoint)
+	for name, tp := range t.tables {
+		if tp.resumed.Rows > 0 {
+			m[name] = tp.resumed
+		}
+	}
+	return m
+}
+
+// start is called for each row of srcTable in data mode. It returns
+// false if the row was settled in a previous run (and so should be
+// skipped). Otherwise the row becomes the current row.
+func (t *CheckpointTracker) start(srcTable string) bool {
+	return t.startKeyed(srcTable, nil)
+}
+
+// startKeyed is like start, for rows read with their primary key key
+// (nil if not known).
+func (t *CheckpointTracker) startKeyed(srcTable string, key []string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tp, ok := t.tables[srcTable]
+	if !ok {
+		tp = &tableProgress{}
+		t.tables[srcTable] = tp
+	}
+	pos := tp.next
+	tp.next++
+	if pos < tp.resumed.Rows {
+		return false
+	}
+	tp.states = append(tp.states, rowPending)
+	tp.keys = append(tp.keys, key)
+	t.nextID++
+	t.current = t.nextID
+	t.inFlight[t.current] = rowRef{tp, pos}
+	return true
+}
+
+// resumeKey returns the key of the last row of srcTable settled by
+// previous runs, or nil if there is none. Rows of srcTable must then be
+// read in key order starting after that key, since they aren't skipped
+// by position.
+func (t *CheckpointTracker) resumeKey(srcTable string) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tp, ok := t.tables[srcTable]
+	if !ok || tp.resumed.Key == nil {
+		return nil
+	}
+	tp.next = tp.resumed.Rows
+	return tp.resumed.Key
+}
+
+// badConversion records that the current row couldn't be converted.
+func (t *CheckpointTracker) badConversion() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if ref, ok := t.inFlight[t.current]; ok {
+		delete(t.inFlight, t.current)
+		ref.t.settle(ref.pos, rowBadConversion)
+	}
+}
+
+// settle records the state of the row at pos, and advances the settled
+// prefix of the table. Returns true if the prefix grew.
+func (tp *tableProgress) settle(pos int64, state rowState) bool {
+	tp.states[pos-tp.done.Rows] = state
+	n := 0
+	for n < len(tp.states) && tp.states[n] != rowPending {
+		switch tp.states[n] {
+		case rowBadConversion:
+			tp.done.BadRows++
+		case rowBadWrite:
+			tp.done.BadWrites++
+		}
+		n++
+	}
+	if n > 0 && tp.keys[n-1] != nil {
+		tp.done.Key = tp.keys[n-1]
+	}
+	tp.done.Rows += int64(n)
+	tp.states = tp.states[n:]
+	tp.keys = tp.keys[n:]
+	return n > 0
+}
+
+func (t *CheckpointTracker) checkpoint() *Checkpoint {
+	cp := &Checkpoint{Version: checkpointVersion, DumpHash: t.dumpHash, Tables: make(map[string]TableCheckpoint)}
+	for name, tp := range t.tables {
+		cp.Tables[name] = tp.done
+	}
+	return cp
+}
+
+// write writes the checkpoint to a temporary file and then renames it,
+// so that the checkpoint file is always complete even if we crash while
+// writing it. Once an error occurs, we stop writing checkpoints.
+func (t *CheckpointTracker) write() {
+	if t.err != nil {
+		return
+	}
+	b, err := json.MarshalIndent(t.checkpoint(), "", "  ")
+	if err != nil {
+		t.err = err
+		return
+	}
+	f, err := ioutil.TempFile(filepath.Dir(t.name), filepath.Base(t.name)+".tmp")
+	if err != nil {
+		t.err = err
+		return
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), t.name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		t.err = fmt.Errorf("can't write checkpoint file %s: %w", t.name, err)
+	}
+}
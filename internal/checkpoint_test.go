@@ -0,0 +1,137 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const checkpointDump = "CREATE TABLE t (a bigint PRIMARY KEY, b bigint);\n" +
+	"COPY public.t (a, b) FROM stdin;\n" +
+	"1\t10\n" +
+	"2\tx\n" +
+	"3\t30\n" +
+	"4\t40\n" +
+	"5\t50\n" +
+	"\\.\n"
+
+// runCheckpointedDump converts checkpointDump with checkpoint tracker t,
+// and returns the IDs passed to the data sink, keyed by the value of
+// column a.
+func runCheckpointedDump(t *CheckpointTracker) (*Conv, map[int64]int64) {
+	conv := MakeConv()
+	conv.SetLocation(time.UTC)
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(checkpointDump)), nil))
+	conv.SetCheckpointTracker(t)
+	conv.SetDataMode()
+	ids := make(map[int64]int64)
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {
+		ids[vals[0].(int64)] = t.CurrentRow()
+	})
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(checkpointDump)), nil))
+	return conv, ids
+}
+
+func TestCheckpointTracker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "checkpoint.json")
+	tracker, err := NewCheckpointTracker(name, "sha256:abc", nil)
+	assert.Nil(t, err)
+	_, ids := runCheckpointedDump(tracker)
+	assert.Equal(t, 4, len(ids))
+	// Writes complete out of order: rows are only settled once all
+	// previous rows of the table are settled. Row 2 is a bad row.
+	tracker.Settle([]int64{ids[3], ids[4]}, nil)
+	assert.Equal(t, TableCheckpoint{}, tracker.Checkpoint().Tables["t"])
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+	tracker.Settle([]int64{ids[1]}, nil)
+	assert.Equal(t, TableCheckpoint{Rows: 4, BadRows: 1}, tracker.Checkpoint().Tables["t"])
+	tracker.Settle([]int64{ids[5]}, fmt.Errorf("write failed"))
+	assert.Nil(t, tracker.Close())
+
+	f, err := os.Open(name)
+	assert.Nil(t, err)
+	defer f.Close()
+	cp, err := ReadCheckpoint(f)
+	assert.Nil(t, err)
+	assert.Equal(t, &Checkpoint{
+		Version:  checkpointVersion,
+		DumpHash: "sha256:abc",
+		Tables:   map[string]TableCheckpoint{"t": {Rows: 5, BadRows: 1, BadWrites: 1}},
+	}, cp)
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+}
+
+func TestCheckpointTracker_Resume(t *testing.T) {
+	resume := &Checkpoint{
+		Version:  checkpointVersion,
+		DumpHash: "sha256:abc",
+		Tables:   map[string]TableCheckpoint{"t": {Rows: 3, BadRows: 1, BadWrites: 1}},
+	}
+	_, err := NewCheckpointTracker("checkpoint.json", "sha256:def", resume)
+	assert.NotNil(t, err)
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tracker, err := NewCheckpointTracker(filepath.Join(dir, "checkpoint.json"), "sha256:abc", resume)
+	assert.Nil(t, err)
+	conv, ids := runCheckpointedDump(tracker)
+	// Rows settled by the previous run are skipped.
+	assert.Equal(t, []int64{4, 5}, sortedKeys(ids))
+	assert.Equal(t, map[string]TableCheckpoint{"t": {Rows: 3, BadRows: 1, BadWrites: 1}}, tracker.Resumed())
+	// Stats cover both runs.
+	assert.Equal(t, int64(5), conv.stats.rows["t"])
+	assert.Equal(t, int64(4), conv.stats.goodRows["t"])
+	assert.Equal(t, int64(1), conv.stats.badRows["t"])
+	assert.Contains(t, GenerateSummary(conv, nil), "Resumed from checkpoint: 3 rows were processed by previous runs, and skipped by this one.\n")
+	tracker.Settle([]int64{ids[4], ids[5]}, nil)
+	assert.Equal(t, TableCheckpoint{Rows: 5, BadRows: 1, BadWrites: 1}, tracker.Checkpoint().Tables["t"])
+}
+
+func TestReadCheckpoint(t *testing.T) {
+	_, err := ReadCheckpoint(strings.NewReader(`{"version": 1, "dumpHash": "sha256:abc", "tables": {}}`))
+	assert.Nil(t, err)
+	_, err = ReadCheckpoint(strings.NewReader(`{"version": 2, "dumpHash": "sha256:abc", "tables": {}}`))
+	assert.NotNil(t, err)
+	_, err = ReadCheckpoint(strings.NewReader(`{"version": 1, "dumpHash": "sha256:abc", "tables": {`))
+	assert.NotNil(t, err)
+}
+
+func sortedKeys(m map[int64]int64) []int64 {
+	var l []int64
+	for k := range m {
+		l = append(l, k)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	return l
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Column statistics are an opt-in feature (see EnableColumnStats) that
+// tracks, for each source column, the fraction of NULL values and an
+// approximate count of distinct values. These help decide which
+// columns deserve indexes or key positions in Spanner.
+//
+// Distinct counts are estimated using HyperLogLog, which uses a fixed
+// amount of memory per column (hllRegisters bytes) regardless of the
+// number of rows. The estimates have a standard error of
+// 1.04/sqrt(hllRegisters) i.e. about 1.6%.
+
+const (
+	hllPrecision = 12 // Number of hash bits used to select a register.
+	hllRegisters = 1 << hllPrecision
+)
+
+// hllStdError is the standard error of distinct-value estimates.
+var hllStdError = 1.04 / math.Sqrt(hllRegisters)
+
+// hyperLogLog is a minimal HyperLogLog sketch for estimating the number
+// of distinct values in a stream.
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+func (h *hyperLogLog) add(s string) {
+	x := hash64(s)
+	i := x >> (64 - hllPrecision)
+	// Set a guard bit so that rank is bounded by 64-hllPrecision+1.
+	w := x<<hllPrecision | 1<<(hllPrecision-1)
+	rank := uint8(bits.LeadingZeros64(w)) + 1
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() int64 {
+	m := float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := alpha * m * m / sum
+	// Small range correction: use linear counting.
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(e + 0.5)
+}
+
+// hash64 computes a 64 bit hash of s. FNV-1a is cheap but its output
+// is not well mixed for short inputs, so we apply the murmur3
+// finalizer to get good avalanche behavior (HyperLogLog relies on the
+// high-order bits being uniformly distributed).
+func hash64(s string) uint64 {
+	x := uint64(14695981039346656037) // FNV-1a offset basis.
+	for i := 0; i < len(s); i++ {
+		x ^= uint64(s[i])
+		x *= 1099511628211 // FNV-1a prime.
+	}
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// columnStats contains statistics for a single source column.
+type columnStats struct {
+	values   int64 // Number of values observed (including NULLs).
+	nulls    int64 // Number of NULL values observed.
+	distinct hyperLogLog
+
+	// Used by the PII key check (see pii.go).
+	piiSampled int64              // Number of values checked against PII value patterns.
+	piiMatches [numPIIKinds]int64 // Number of sampled values matching each pattern.
+}
+
+// EnableColumnStats turns on collection of per-column statistics
+// (NULL fraction and approximate distinct count) during data conversion.
+// Column statistics are off by default since they add some overhead to
+// data conversion (see README).
+func (conv *Conv) EnableColumnStats() {
+	conv.colStats = make(map[string]map[string]*columnStats)
+}
+
+// observeValue records a source value for column statistics. It is a
+// no-op unless column statistics are enabled. 'null' indicates that the
+// value is NULL, in which case val is ignored.
+func (conv *Conv) observeValue(srcTable, srcCol string, val string, null bool) {
+	if conv.colStats == nil || !conv.dataMode() {
+		return
+	}
+	m, ok := conv.colStats[srcTable]
+	if !ok {
+		m = make(map[string]*columnStats)
+		conv.colStats[srcTable] = m
+	}
+	cs, ok := m[srcCol]
+	if !ok {
+		cs = &columnStats{}
+		m[srcCol] = cs
+	}
+	cs.values++
+	if null {
+		cs.nulls++
+		return
+	}
+	cs.distinct.add(val)
+	conv.observePII(cs, val)
+}
+
+// observeSqlValue is like observeValue, but for values returned by
+// database/sql (where nil represents NULL).
+func (conv *Conv) observeSqlValue(srcTable, srcCol string, val interface{}) {
+	if conv.colStats == nil {
+		return
+	}
+	switch v := val.(type) {
+	case nil:
+		conv.observeValue(srcTable, srcCol, "", true)
+	case []byte:
+		conv.observeValue(srcTable, srcCol, string(v), false)
+	case string:
+		conv.observeValue(srcTable, srcCol, v, false)
+	default:
+		conv.observeValue(srcTable, srcCol, fmt.Sprintf("%v", v), false)
+	}
+}
+
+// columnStatsSummary is a report-friendly summary of columnStats.
+type columnStatsSummary struct {
+	Col      string  // Source column name.
+	Values   int64   // Number of values observed.
+	NullFrac float64 // Fraction of values that are NULL.
+	Distinct int64   // Approximate number of distinct non-NULL values.
+}
+
+// getColumnStats returns column statistics for srcTable, in column
+// order. Returns nil if column statistics are not enabled or no data
+// was seen for the table.
+func (conv *Conv) getColumnStats(srcTable string) []columnStatsSummary {
+	m, ok := conv.colStats[srcTable]
+	if !ok {
+		return nil
+	}
+	var l []columnStatsSummary
+	for _, c := range conv.srcSchema[srcTable].ColNames {
+		cs, ok := m[c]
+		if !ok {
+			continue
+		}
+		s := columnStatsSummary{Col: c, Values: cs.values}
+		if cs.values > 0 {
+			s.NullFrac = float64(cs.nulls) / float64(cs.values)
+		}
+		if n := cs.values - cs.nulls; n > 0 {
+			// The estimate can slightly exceed the number of
+			// non-NULL values; clamp it to avoid confusion.
+			s.Distinct = cs.distinct.estimate()
+			if s.Distinct > n {
+				s.Distinct = n
+			}
+		}
+		l = append(l, s)
+	}
+	return l
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 100000, 1000000} {
+		var h hyperLogLog
+		for i := 0; i < n; i++ {
+			h.add(strconv.Itoa(i))
+			h.add(strconv.Itoa(i)) // Duplicates should not change the estimate.
+		}
+		e := h.estimate()
+		// Allow 4 standard errors (plus 1 for tiny counts).
+		tolerance := 4*hllStdError*float64(n) + 1
+		assert.True(t, math.Abs(float64(e-int64(n))) <= tolerance, "n=%d estimate=%d", n, e)
+	}
+}
+
+func TestColumnStats(t *testing.T) {
+	s := "CREATE TABLE test (a text, b bigint, c text);\n" +
+		"COPY public.test (a, b, c) FROM stdin;\n" +
+		"a1	1	\\N\n" +
+		"a2	1	\\N\n" +
+		"a3	2	\\N\n" +
+		"a4	\\N	x\n" +
+		"\\.\n"
+	conv := MakeConv()
+	conv.SetLocation(time.UTC)
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	// Stats are only collected in data mode.
+	conv.EnableColumnStats()
+	assert.Nil(t, conv.getColumnStats("test"))
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	expected := []columnStatsSummary{
+		{Col: "a", Values: 4, NullFrac: 0, Distinct: 4},
+		{Col: "b", Values: 4, NullFrac: 0.25, Distinct: 2},
+		{Col: "c", Values: 4, NullFrac: 0.75, Distinct: 1},
+	}
+	assert.Equal(t, expected, conv.getColumnStats("test"))
+}
+
+func TestColumnStatsDisabled(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE test (a text);\n" +
+		"INSERT INTO test (a) VALUES ('x');")
+	assert.Nil(t, conv.getColumnStats("test"))
+}
+
+// BenchmarkColumnStats measures the overhead of column statistics on
+// data conversion. The README gives the results of:
+//
+//	go test -run '^$' -bench ColumnStats -benchtime 1000000x -count 20 ./internal
+func BenchmarkColumnStats(b *testing.B) {
+	for _, enabled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("enabled=%t", enabled), func(b *testing.B) {
+			conv, _ := runProcessPgDump("CREATE TABLE test (id bigint PRIMARY KEY, a text, b float8, c text);")
+			if enabled {
+				conv.EnableColumnStats()
+			}
+			conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+			cols := []string{"id", "a", "b", "c"}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ProcessDataRow(conv, "test", cols, []string{strconv.Itoa(i), "some text value", "42.6", "\\N"})
+			}
+		})
+	}
+}
+
+func TestWriteColumnStats(t *testing.T) {
+	var buf strings.Builder
+	w := bufio.NewWriter(&buf)
+	writeColumnStats([]columnStatsSummary{
+		{Col: "id", Values: 1000, NullFrac: 0, Distinct: 1000},
+		{Col: "description", Values: 1000, NullFrac: 0.125, Distinct: 37},
+	}, w)
+	w.Flush()
+	expected := "Column statistics (approximate: distinct counts have a standard error of 1.6%)\n" +
+		"  column         null%    distinct\n" +
+		"  id              0.0%       ~1000\n" +
+		"  description    12.5%         ~37\n" +
+		"\n"
+	assert.Equal(t, expected, buf.String())
+}
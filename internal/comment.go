@@ -0,0 +1,158 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	nodes "github.com/lfittl/pg_query_go/nodes"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+)
+
+// Comments on source objects (COMMENT ON) often document what tables
+// and columns are for, but Spanner has nowhere to store them. Comments
+// on tables and columns are kept in the source schema, and printed as
+// comments above the table in the generated schema file. Comments on
+// other objects are given with them in the report's dropped objects.
+
+// commentKinds maps the objects that comments can be on to the kinds of
+// dropped objects (see dropped.go).
+var commentKinds = map[nodes.ObjectType]string{
+	nodes.OBJECT_INDEX:    "index",
+	nodes.OBJECT_MATVIEW:  "view",
+	nodes.OBJECT_SEQUENCE: "sequence",
+	nodes.OBJECT_TRIGGER:  "trigger",
+	nodes.OBJECT_VIEW:     "view",
+}
+
+// processCommentStmt handles COMMENT ON. Comments on objects we don't
+// track (e.g. functions) are skipped.
+func processCommentStmt(conv *Conv, n nodes.CommentStmt) {
+	l, ok := n.Object.(nodes.List)
+	if !ok || len(l.Items) == 0 {
+		conv.skipStatement([]nodes.Node{n})
+		return
+	}
+	var text string // COMMENT ON ... IS NULL removes the comment.
+	if n.Comment != nil {
+		text = *n.Comment
+	}
+	switch n.Objtype {
+	case nodes.OBJECT_TABLE:
+		table, err := getListName(conv, l)
+		if err != nil {
+			logStmtError(conv, n, fmt.Errorf("can't get table name: %w", err))
+			return
+		}
+		t, ok := conv.srcSchema[table]
+		if !ok {
+			conv.skipStatement([]nodes.Node{n})
+			return
+		}
+		t.Comment = text
+		conv.srcSchema[table] = t
+	case nodes.OBJECT_COLUMN:
+		col, err := getString(l.Items[len(l.Items)-1])
+		if err == nil {
+			var table string
+			table, err = getListName(conv, nodes.List{Items: l.Items[:len(l.Items)-1]})
+			if t, ok := conv.srcSchema[table]; ok && err == nil {
+				cd, ok := t.ColDefs[col]
+				if !ok {
+					conv.skipStatement([]nodes.Node{n})
+					return
+				}
+				cd.Comment = text
+				t.ColDefs[col] = cd
+				break
+			}
+		}
+		if err != nil {
+			logStmtError(conv, n, fmt.Errorf("can't get column name: %w", err))
+		} else {
+			conv.skipStatement([]nodes.Node{n}) // e.g. a column of a view.
+		}
+		return
+	default:
+		kind, ok := commentKinds[n.Objtype]
+		if !ok {
+			conv.skipStatement([]nodes.Node{n})
+			return
+		}
+		var name string
+		var err error
+		if kind == "view" || kind == "sequence" {
+			name, err = getListName(conv, l)
+		} else {
+			// Index and trigger names aren't qualified by their schema
+			// in dropped objects.
+			name, err = getString(l.Items[len(l.Items)-1])
+		}
+		if err != nil {
+			logStmtError(conv, n, fmt.Errorf("can't get name: %w", err))
+			return
+		}
+		if conv.objComments == nil {
+			conv.objComments = make(map[string]string)
+		}
+		conv.objComments[kind+" "+name] = text
+	}
+	conv.schemaStatement([]nodes.Node{n})
+}
+
+// objectComment returns the comment on source object name of the given
+// kind (as for dropped objects e.g. "view"), or "" if there is none.
+func (conv *Conv) objectComment(kind, name string) string {
+	return conv.objComments[kind+" "+name]
+}
+
+// sourceComments returns the comments on table t and its columns, as
+// lines for the table's comment in the generated schema file (or "" if
+// there are none).
+func sourceComments(t schema.Table) string {
+	var l []string
+	if t.Comment != "" {
+		l = append(l, "Table comment: "+t.Comment)
+	}
+	for _, c := range t.ColNames {
+		if s := t.ColDefs[c].Comment; s != "" {
+			l = append(l, fmt.Sprintf("Column %s: %s", quoteIfNeeded(c), s))
+		}
+	}
+	return strings.Join(l, "\n")
+}
+
+// describeComments returns the source columns of srcTable that have
+// comments (in column order), with a description of the comments
+// carried over for the report, or nil and "" if there are none.
+func (conv *Conv) describeComments(srcTable string) ([]string, string) {
+	t := conv.srcSchema[srcTable]
+	var cols, l []string
+	if t.Comment != "" {
+		l = append(l, "the table")
+	}
+	for _, c := range t.ColNames {
+		if t.ColDefs[c].Comment != "" {
+			cols = append(cols, c)
+			l = append(l, fmt.Sprintf("'%s'", c))
+		}
+	}
+	if len(l) == 0 {
+		return nil, ""
+	}
+	return cols, "Source comments were carried over to the schema file for " + strings.Join(l, ", ")
+}
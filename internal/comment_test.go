@@ -0,0 +1,69 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestProcessPgDump_Comments(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE public.users (id bigint PRIMARY KEY, email text, zip text);\n" +
+			"CREATE VIEW v AS SELECT id FROM users;\n" +
+			"CREATE INDEX users_lower ON users (lower(email));\n" +
+			"COMMENT ON TABLE public.users IS 'People who can log in.\nOne row per person.';\n" +
+			"COMMENT ON COLUMN public.users.email IS 'Primary contact address.';\n" +
+			"COMMENT ON COLUMN users.zip IS 'Old';\n" +
+			"COMMENT ON COLUMN users.zip IS NULL;\n" +
+			"COMMENT ON COLUMN users.missing IS 'x';\n" +
+			"COMMENT ON VIEW public.v IS 'Just the ids.';\n" +
+			"COMMENT ON INDEX public.users_lower IS 'For case-insensitive lookups.';\n" +
+			"COMMENT ON FUNCTION f() IS 'x';\n")
+	assert.Equal(t, "People who can log in.\nOne row per person.", conv.srcSchema["users"].Comment)
+	assert.Equal(t, "Primary contact address.", conv.srcSchema["users"].ColDefs["email"].Comment)
+	assert.Equal(t, "", conv.srcSchema["users"].ColDefs["zip"].Comment)
+	assert.Equal(t, statementStat{schema: 6, skip: 2}, *conv.stats.statement["CommentStmt"])
+
+	ddlText := strings.Join(conv.GetDDL(ddl.Config{Comments: true}), "\n")
+	assert.Contains(t, ddlText, "--\n"+
+		"-- Spanner schema for source table users\n"+
+		"-- Table comment: People who can log in.\n"+
+		"-- One row per person.\n"+
+		"-- Column email: Primary contact address.\n"+
+		"--\n"+
+		"CREATE TABLE users (")
+
+	b := new(bytes.Buffer)
+	w := bufio.NewWriter(b)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	report := normalizeSpace(b.String())
+	assert.Contains(t, report, "Source comments were carried over to the schema file for the table, 'email'. Spanner doesn't store comments, so they are only kept in the schema file")
+	assert.Contains(t, report, "1) v (table users). Comment: Just the ids.")
+	assert.Contains(t, report, "Comment: For case-insensitive lookups.")
+	r := BuildReport(PgDumpSource, conv, nil)
+	var comments []string
+	for _, d := range r.DroppedObjects {
+		comments = append(comments, d.Comment)
+	}
+	assert.Equal(t, []string{"Just the ids.", "For case-insensitive lookups."}, comments)
+}
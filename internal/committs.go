@@ -0,0 +1,166 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	sp "cloud.google.com/go/spanner"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// Commit timestamp columns are TIMESTAMP columns with the
+// allow_commit_timestamp option, added to Spanner tables (they have no
+// source column) so that applications can track changes to rows after
+// migration by writing the commit timestamp to them.
+
+// CommitTimestampFill determines what is written to commit timestamp
+// columns during data conversion.
+type CommitTimestampFill string
+
+// Commit timestamp fills.
+const (
+	CommitTimestampNull   CommitTimestampFill = "null"   // The column is left NULL (the default).
+	CommitTimestampCommit CommitTimestampFill = "commit" // The column is set to the commit timestamp of the write.
+)
+
+// ParseCommitTimestampFill returns the fill named s (empty for the
+// default).
+func ParseCommitTimestampFill(s string) (CommitTimestampFill, error) {
+	switch x := CommitTimestampFill(s); x {
+	case "":
+		return CommitTimestampNull, nil
+	case CommitTimestampNull, CommitTimestampCommit:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown commit timestamp fill %q: expected null or commit", s)
+}
+
+// CommitTimestampOption specifies a commit timestamp column to add to
+// Spanner tables.
+type CommitTimestampOption struct {
+	Column string              `json:"column"` // Name of the column (empty for none).
+	Fill   CommitTimestampFill `json:"fill"`   // Empty for the default.
+}
+
+// commitTSColumn is the commit timestamp column of a Spanner table.
+type commitTSColumn struct {
+	col  string
+	fill CommitTimestampFill
+}
+
+// SetCommitTimestamp configures the commit timestamp column added to
+// all tables (a zero option means none). Table options can override it
+// for individual tables (see ApplyTableOptions), which adds the
+// columns.
+func (conv *Conv) SetCommitTimestamp(o CommitTimestampOption) {
+	conv.commitTSOption = o
+}
+
+// addCommitTimestampColumns adds commit timestamp columns to the
+// Spanner tables, as configured by SetCommitTimestamp and opts.
+func (conv *Conv) addCommitTimestampColumns(opts map[string]TableOptions) error {
+	var tables []string
+	for t := range conv.srcSchema {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for _, srcTable := range tables {
+		o := conv.commitTSOption
+		if t, ok := opts[srcTable]; ok && t.CommitTimestamp != nil {
+			o = *t.CommitTimestamp
+		}
+		if o.Column == "" {
+			continue
+		}
+		if err := conv.addCommitTimestampColumn(srcTable, o); err != nil {
+			return fmt.Errorf("bad commit timestamp column for table %s: %w", srcTable, err)
+		}
+	}
+	return nil
+}
+
+func (conv *Conv) addCommitTimestampColumn(srcTable string, o CommitTimestampOption) error {
+	fill, err := ParseCommitTimestampFill(string(o.Fill))
+	if err != nil {
+		return err
+	}
+	if name, why := spannerName(o.Column); why != "" || name != o.Column {
+		return fmt.Errorf("%q isn't a legal Spanner column name", o.Column)
+	}
+	spTable, err := GetSpannerTable(conv, srcTable)
+	if err != nil {
+		return err
+	}
+	ct, ok := conv.spSchema[spTable]
+	if !ok {
+		return fmt.Errorf("no Spanner table %s", spTable)
+	}
+	// Reuse the column if it's already there (e.g. from a session),
+	// otherwise add it with a name that's not already used.
+	col := o.Column
+	for n := 0; ; n++ {
+		c, ok := findCol(ct, col)
+		if !ok {
+			ct.ColNames = append(ct.ColNames, col)
+			ct.ColDefs[col] = ddl.ColumnDef{Name: col, T: ddl.Timestamp{}, Options: map[string]string{ddl.AllowCommitTimestamp: "true"}}
+			conv.spSchema[spTable] = ct
+			break
+		}
+		if cd := ct.ColDefs[c]; cd.AllowsCommitTimestamp() && conv.toSource[spTable].cols[c] == "" {
+			col = c
+			break
+		}
+		col = fmt.Sprintf("%s%d", o.Column, n)
+	}
+	conv.commitTS[spTable] = commitTSColumn{col: col, fill: fill}
+	return nil
+}
+
+// findCol returns the column of ct named name, ignoring case (Spanner
+// names are case insensitive).
+func findCol(ct ddl.CreateTable, name string) (string, bool) {
+	for _, c := range ct.ColNames {
+		if strings.EqualFold(c, name) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// nextCommitTimestamp returns the commit timestamp column of spTable
+// and its value for the next row, if spTable has a commit timestamp
+// column that is filled during data conversion.
+func (conv *Conv) nextCommitTimestamp(spTable string) (string, interface{}, bool) {
+	c, ok := conv.commitTS[spTable]
+	if !ok || c.fill != CommitTimestampCommit {
+		return "", nil, false
+	}
+	return c.col, sp.CommitTimestamp, true
+}
+
+// describeCommitTimestamp describes the commit timestamp column of
+// spTable, for reports.
+func (conv *Conv) describeCommitTimestamp(spTable string) string {
+	c := conv.commitTS[spTable]
+	how := "left NULL"
+	if c.fill == CommitTimestampCommit {
+		how = "set to the commit timestamp of each write"
+	}
+	return fmt.Sprintf("Column '%s' was added: it is a TIMESTAMP column with allow_commit_timestamp = true, and has no source column. Migrated rows have it %s", c.col, how)
+}
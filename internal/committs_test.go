@@ -0,0 +1,112 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	sp "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestReadTableOptions_CommitTimestamp(t *testing.T) {
+	opts, err := ReadTableOptions(strings.NewReader(`{"users": {"commit_timestamp": {"column": "update_time", "fill": "commit"}}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]TableOptions{
+		"users": TableOptions{CommitTimestamp: &CommitTimestampOption{Column: "update_time", Fill: CommitTimestampCommit}},
+	}, opts)
+}
+
+func TestAddCommitTimestampColumns(t *testing.T) {
+	const schema = "CREATE TABLE a (id bigint PRIMARY KEY, update_time text);\n" +
+		"CREATE TABLE b (id bigint PRIMARY KEY);\n" +
+		"CREATE TABLE c (id bigint PRIMARY KEY);\n"
+	conv, _ := runProcessPgDump(schema)
+	conv.SetCommitTimestamp(CommitTimestampOption{Column: "update_time"})
+	assert.Nil(t, conv.ApplyTableOptions(map[string]TableOptions{
+		"b": TableOptions{CommitTimestamp: &CommitTimestampOption{Column: "modified", Fill: CommitTimestampCommit}},
+		"c": TableOptions{CommitTimestamp: &CommitTimestampOption{}},
+	}, time.Now()))
+	// Table a already has an update_time column, so gets a variation.
+	assert.Equal(t, []string{"id", "update_time", "update_time0"}, conv.spSchema["a"].ColNames)
+	assert.Equal(t, ddl.ColumnDef{Name: "update_time0", T: ddl.Timestamp{}, Options: map[string]string{ddl.AllowCommitTimestamp: "true"}}, conv.spSchema["a"].ColDefs["update_time0"])
+	assert.Equal(t, []string{"id", "modified"}, conv.spSchema["b"].ColNames)
+	assert.Equal(t, []string{"id"}, conv.spSchema["c"].ColNames)
+	assert.Equal(t, map[string]commitTSColumn{"a": {"update_time0", CommitTimestampNull}, "b": {"modified", CommitTimestampCommit}}, conv.commitTS)
+
+	// Sessions keep the column, and applying table options again reuses it.
+	var buf bytes.Buffer
+	assert.Nil(t, conv.WriteSession(&buf))
+	s, err := ReadSession(&buf)
+	assert.Nil(t, err)
+	conv2, _ := runProcessPgDump(schema)
+	assert.Nil(t, conv2.ApplySession(s))
+	conv2.SetCommitTimestamp(CommitTimestampOption{Column: "update_time"})
+	assert.Nil(t, conv2.ApplyTableOptions(nil, time.Now()))
+	assert.Equal(t, []string{"id", "update_time", "update_time0"}, conv2.spSchema["a"].ColNames)
+	assert.Equal(t, []string{"id", "modified", "update_time"}, conv2.spSchema["b"].ColNames)
+
+	for _, o := range []map[string]TableOptions{
+		{"x": TableOptions{CommitTimestamp: &CommitTimestampOption{Column: "update_time"}}},
+		{"b": TableOptions{CommitTimestamp: &CommitTimestampOption{Column: "update time"}}},
+		{"b": TableOptions{CommitTimestamp: &CommitTimestampOption{Column: "update_time", Fill: "now"}}},
+	} {
+		conv, _ := runProcessPgDump(schema)
+		assert.NotNil(t, conv.ApplyTableOptions(o, time.Now()), o)
+	}
+}
+
+func TestCommitTimestampData(t *testing.T) {
+	s := "CREATE TABLE a (id bigint PRIMARY KEY);\n" +
+		"CREATE TABLE b (id bigint PRIMARY KEY);\n" +
+		"COPY public.a (id) FROM stdin;\n" +
+		"1\n" +
+		"\\.\n" +
+		"COPY public.b (id) FROM stdin;\n" +
+		"2\n" +
+		"\\.\n"
+	conv := MakeConv()
+	conv.SetLocation(time.UTC)
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	conv.SetCommitTimestamp(CommitTimestampOption{Column: "update_time", Fill: CommitTimestampCommit})
+	opts := map[string]TableOptions{"b": TableOptions{CommitTimestamp: &CommitTimestampOption{Column: "update_time"}}}
+	assert.Nil(t, conv.ApplyTableOptions(opts, time.Now()))
+	conv.SetDataMode()
+	var rows []spannerData
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {
+		rows = append(rows, spannerData{table: table, cols: cols, vals: vals})
+	})
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	assert.Equal(t, []spannerData{
+		{table: "a", cols: []string{"id", "update_time"}, vals: []interface{}{int64(1), sp.CommitTimestamp}},
+		{table: "b", cols: []string{"id"}, vals: []interface{}{int64(2)}},
+	}, rows)
+
+	b := new(bytes.Buffer)
+	w := bufio.NewWriter(b)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	r := normalizeSpace(b.String())
+	assert.Contains(t, r, "Column 'update_time' was added: it is a TIMESTAMP column with allow_commit_timestamp = true, and has no source column. Migrated rows have it set to the commit timestamp of each write")
+	assert.Contains(t, r, "Migrated rows have it left NULL")
+	assert.Contains(t, strings.Join(conv.GetDDL(ddl.Config{}), "\n"), "update_time TIMESTAMP OPTIONS (allow_commit_timestamp = true)")
+}
@@ -17,11 +17,12 @@ package internal
 import (
 	"fmt"
 	"reflect"
-	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	nodes "github.com/lfittl/pg_query_go/nodes"
+	"golang.org/x/text/encoding"
 
 	"github.com/cloudspannerecosystem/harbourbridge/schema"
 	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
@@ -34,12 +35,89 @@ type Conv struct {
 	syntheticPKeys map[string]syntheticPKey            // Maps Spanner table name to synthetic primary key (if needed).
 	srcSchema      map[string]schema.Table             // Maps source-DB table name to schema information.
 	issues         map[string]map[string][]schemaIssue // Maps source-DB table/col to list of schema conversion issues.
+	groupIssues    map[string][]groupIssue             // Maps source-DB table to list of schema conversion issues that span multiple columns.
 	toSpanner      map[string]nameAndCols              // Maps from source-DB table name to Spanner name and column mapping.
 	toSource       map[string]nameAndCols              // Maps from Spanner table name to source-DB table name and column mapping.
 	dataSink       func(table string, cols []string, values []interface{})
-	location       *time.Location // Timezone (for timestamp conversion).
-	sampleBadRows  rowSamples     // Rows that generated errors during conversion.
+	location       *time.Location   // Timezone (for timestamp conversion).
+	now            func() time.Time // Clock used for timing data conversion (see timing.go).
+	sampleBadRows  rowSamples       // Rows that generated errors during conversion.
 	stats          stats
+	usage          *ResourceUsage                     // Resource usage high-water marks for the run (nil if not tracked).
+	colStats       map[string]map[string]*columnStats // Per-column data statistics, keyed by source table and column (nil if not enabled).
+	piiKeyCheck    bool                               // Whether to check primary keys for personal data (see pii.go).
+	showMappings   bool                               // Whether reports list the column mappings of each table (see mappings.go).
+	rowDeletion    map[string]*rowDeletionStats       // Row deletion policies, keyed by source table (see tableoptions.go).
+	typeMap        TypeMap                            // User overrides of default type mappings (see typemap.go).
+	typeOverrides  map[string]map[string]TypeOverride // Type overrides applied, keyed by source table and column.
+	mismatches     []string                           // Problems found matching the source schema to a session or existing Spanner schema (see session.go).
+	dataSkipped    bool                               // Whether data conversion was deliberately not run (see SkipDataConversion).
+	dryRun         bool                               // Whether data is converted, but not written (see SetDryRun).
+	writePriority  WritePriority                      // Priority of writes to Spanner (see SetWriteOptions).
+	writeTag       string                             // Transaction tag of writes to Spanner.
+	badRowsOut     *BadRowWriter                      // If non-nil, all bad rows are written here (see badrows.go).
+	badValues      map[string]badRowValues            // Example values of bad rows, keyed by source table and cause (see SetBadValueSamples).
+	badValueLimit  int                                // Number of example values kept for each cause (zero if disabled).
+	transforms     map[string]tableTransforms         // Column transforms, keyed by source table (see transform.go).
+	checkpoint     *CheckpointTracker                 // If non-nil, tracks progress of data conversion (see checkpoint.go).
+	dropped        []droppedObject                    // Source DB objects that were dropped (see dropped.go).
+	objComments    map[string]string                  // Comments on source objects other than tables and columns, keyed by kind and name (see comment.go).
+	indexSQL       map[string]map[string]string       // Definitions of source indexes, keyed by source table and index name (dumps only).
+	mysql          bool                               // Source DB is MySQL (see mysqldump.go).
+	dynamo         *dynamoExport                      // Non-nil if the source is a DynamoDB export (see dynamodb.go).
+	csv            *CSVSchema                         // Non-nil if the source is CSV files (see csv.go).
+	sqlserver      bool                               // Source DB is SQL Server (see sqlserver.go).
+	pkStrategy     SyntheticPKStrategy                // Strategy for synthetic primary keys (empty means the default; see synthpk.go).
+	sampler        *rowSampler                        // If non-nil, only a sample of data rows is converted (see sample.go).
+	verify         *verifyCounts                      // Row counts from the verification pass, if any (see verify.go).
+	dataTarget     string                             // Where data is written, for reports (empty means Spanner; see SetDataTarget).
+	database       string                             // Full name of the Spanner database that data is written to, for reports (see SetDatabase).
+	newInstance    *ReportCreatedInstance             // Spanner instance created for the conversion, if any (see instance.go).
+	snapshot       *ReportSnapshot                    // Snapshot of the source database that data was read in, if any (see BeginSnapshot).
+	rowEstimates   map[string]int64                   // Approximate rows per source table from source statistics (see SetRowEstimates).
+	enums          map[string][]string                // Labels of source enum types, keyed by type name (see enum.go).
+	domains        map[string]domainDef               // Source domains, keyed by domain name (see domain.go).
+	partitions     partitions                         // Partitioned source tables (see partition.go).
+	inheritance    inheritance                        // Source tables that inherit from other tables (see inherit.go).
+	defaultSchema  string                             // Source schema whose tables have no schema prefix (empty means public; see namespace.go).
+	searchPath     string                             // Schema of unqualified table names in a dump, from SET search_path (empty means the default schema).
+	schemas        map[string]bool                    // Source schemas whose tables are converted from a database (nil for all; see namespace.go).
+	dumpSettings   dumpSettings                       // Settings from a dump's SET statements (see dumpsettings.go).
+	badEncoding    string                             // client_encoding of the dump, if we can't decode it.
+	stringLength   int64                              // Length of STRING columns that would otherwise be STRING(MAX) (zero means MAX; see strlen.go).
+	overflowPolicy StringOverflow                     // What to do with values longer than their STRING(N) column (empty means the default).
+	overflows      map[string]map[string]int64        // Count of values longer than their STRING(N) column, keyed by source table and column.
+	timestampZone  *time.Location                     // Zone of timestamps without time zone (nil means UTC; see timezone.go).
+	tsOutOfRange   map[string]map[string]int64        // Count of timestamps outside Spanner's range, keyed by source table and column.
+	floatPolicy    FloatPolicy                        // What to do with NaN, infinite and out-of-range FLOAT64 values (empty means the default).
+	floatSpecials  map[string]map[string]int64        // Count of NaN, infinite and out-of-range FLOAT64 values, keyed by source table and column.
+	utf8Strategy   UTF8Strategy                       // What to do with STRING values that aren't valid UTF-8 (zero means the default).
+	utf8Charset    encoding.Encoding                  // Source charset, for UTF8Transcode.
+	invalidUTF8    map[string]map[string]int64        // Count of STRING values that weren't valid UTF-8, keyed by source table and column.
+	multiDimRows   map[string]map[string]int64        // Count of rows with multi-dimensional values in columns mapped to Spanner arrays, keyed by source table and column.
+	commitTSOption CommitTimestampOption              // Commit timestamp column added to all tables (see committs.go).
+	commitTS       map[string]commitTSColumn          // Maps Spanner table name to its commit timestamp column (if any).
+	ddlBatches     *ddlBatches                        // Batches of DDL statements that create the Spanner schema, if applied (see ddlbatch.go).
+	ddlApplied     *ddlApplication                    // DDL statements applied after data conversion, if any (see ddlapply.go).
+	ddlValidation  *ddlValidation                     // DDL statements applied to a throwaway database to validate the schema, if any (see ddlvalidate.go).
+	existingDB     *existingDB                        // Tables that don't match the existing database that data is converted into, if any (see existingdb.go).
+	progress       ProgressSink                       // If non-nil, receives the rows read during data conversion (see SetProgress).
+	lastRead       lastRow                            // Last data row read (see interrupt.go).
+	dupCopyPolicy  DuplicateCopy                      // What to do with duplicate COPY-FROM blocks (empty means the default; see dupcopy.go).
+	copiedTables   map[string]bool                    // Tables whose COPY-FROM blocks were completed in this pass, by name as given in the dump.
+	dupCopies      map[string]*dupCopyStats           // Duplicate COPY-FROM blocks, keyed by source table.
+	tableDefs      tableDefStats                      // DROP TABLE and duplicate CREATE TABLE statements (see droptable.go).
+	dumpFiles      dumpFiles                          // Dump files converted together, if several (see dumpfiles.go).
+	inputFormat    InputFormat                        // Format of the dump, if recognized before conversion (see inputformat.go).
+	dumpContext    dumpContext                        // Part of the dump being processed, for unexpected conditions (see unexpected.go).
+	snippets       bool                               // Give snippets of dump text with unexpected conditions.
+	issueOverrides issueOverrides                     // Schema issues suppressed, or reported with a different severity (see issueoverride.go).
+	metrics        *Metrics                           // If non-nil, updated as stats change (see metrics.go).
+	reportOrder    ReportOrder                        // Order that tables are listed in reports (empty means alphabetical).
+	reportLevel    ReportLevel                        // Detail the text report gives for clean tables (empty means full).
+	thresholds     RatingThresholds                   // Thresholds of schema and data ratings (zero means the defaults; see thresholds.go).
+	dialect        ddl.Dialect                        // Dialect of the Spanner database (empty for GoogleSQL; see dialect.go).
+	interrupted    *interruption                      // Non-nil if the conversion was interrupted (see SetInterrupted).
 }
 
 type mode int
@@ -63,18 +141,152 @@ type schemaIssue int
 // with type mappings, as well as features (such as source
 // DB constraints) that aren't supported in Spanner.
 const (
-	defaultValue schemaIssue = iota
+	badValue schemaIssue = iota
+	checkConstraint
+	comment
+	commitTimestamp
+	datetime
+	defaultValue
+	domain
+	dupCopy
+	dynamoDocument
+	dynamoFloat
+	dynamoMixedTypes
+	enum
+	floatSpecial
 	foreignKey
+	foreignKeyUnsupported
+	hotspot
+	indexUnsupported
+	inherited
+	inheritedMerged
+	invalidUTF8
 	missingPrimaryKey
 	multiDimensionalArray
 	noGoodType
 	numeric
+	numericOutOfRange
 	numericThatFits
+	orderingChanged
+	partitioned
+	piiKey
+	renamed
+	rowDeletionPolicy
+	rowDeletionPolicyNullable
+	rowVersion
 	serial
+	stringBounded
+	stringOverflow
 	timestamp
+	timestampRange
+	transformed
+	typeOverride
+	uniqueUnsupported
 	widened
 )
 
+// groupIssue is a schema issue for a construct that spans a group of
+// columns (e.g. a composite foreign key). Such issues are reported once
+// for the whole group rather than once per column.
+type groupIssue struct {
+	issue     schemaIssue
+	cols      []string // Source-DB columns, in constraint order.
+	construct string   // Construct affected e.g. "primary key" or "index idx_users_email" (if relevant).
+	detail    string   // Issue-specific detail e.g. why a foreign key or index couldn't be converted (if relevant).
+}
+
+// String returns a short, stable name for a schema issue, used when
+// issues are aggregated or reported in machine-readable form.
+func (i schemaIssue) String() string {
+	switch i {
+	case badValue:
+		return "badValue"
+	case checkConstraint:
+		return "checkConstraint"
+	case comment:
+		return "comment"
+	case commitTimestamp:
+		return "commitTimestamp"
+	case datetime:
+		return "datetime"
+	case defaultValue:
+		return "defaultValue"
+	case domain:
+		return "domain"
+	case dupCopy:
+		return "dupCopy"
+	case dynamoDocument:
+		return "dynamoDocument"
+	case dynamoFloat:
+		return "dynamoFloat"
+	case dynamoMixedTypes:
+		return "dynamoMixedTypes"
+	case enum:
+		return "enum"
+	case floatSpecial:
+		return "floatSpecial"
+	case foreignKey:
+		return "foreignKey"
+	case foreignKeyUnsupported:
+		return "foreignKeyUnsupported"
+	case hotspot:
+		return "hotspot"
+	case indexUnsupported:
+		return "indexUnsupported"
+	case inherited:
+		return "inherited"
+	case inheritedMerged:
+		return "inheritedMerged"
+	case invalidUTF8:
+		return "invalidUTF8"
+	case missingPrimaryKey:
+		return "missingPrimaryKey"
+	case multiDimensionalArray:
+		return "multiDimensionalArray"
+	case noGoodType:
+		return "noGoodType"
+	case numeric:
+		return "numeric"
+	case numericOutOfRange:
+		return "numericOutOfRange"
+	case numericThatFits:
+		return "numericThatFits"
+	case orderingChanged:
+		return "orderingChanged"
+	case partitioned:
+		return "partitioned"
+	case piiKey:
+		return "piiKey"
+	case renamed:
+		return "renamed"
+	case rowDeletionPolicy:
+		return "rowDeletionPolicy"
+	case rowDeletionPolicyNullable:
+		return "rowDeletionPolicyNullable"
+	case rowVersion:
+		return "rowVersion"
+	case serial:
+		return "serial"
+	case stringBounded:
+		return "stringBounded"
+	case stringOverflow:
+		return "stringOverflow"
+	case timestamp:
+		return "timestamp"
+	case timestampRange:
+		return "timestampRange"
+	case transformed:
+		return "transformed"
+	case typeOverride:
+		return "typeOverride"
+	case uniqueUnsupported:
+		return "uniqueUnsupported"
+	case widened:
+		return "widened"
+	}
+	return fmt.Sprintf("schemaIssue(%d)", int(i))
+}
+
 // nameAndCols contains the name of a table and its columns.
 // Used to map between source DB and Spanner table and column names.
 type nameAndCols struct {
@@ -104,9 +316,19 @@ type stats struct {
 	rows       map[string]int64          // Count of rows encountered during processing (a + b + c + d), broken down by source table.
 	goodRows   map[string]int64          // Count of rows successfully converted (b + c), broken down by source table.
 	badRows    map[string]int64          // Count of rows where conversion failed (d), broken down by source table.
+	badCauses  map[string]badRowCauses   // Count of bad rows (c + d) by cause, where known, broken down by source table (see badcause.go).
+	tooLarge   map[string]int64          // Count of rows not written because they exceed Spanner's commit size limit (part of c), broken down by source table.
 	statement  map[string]*statementStat // Count of processed statements, broken down by statement type.
-	unexpected map[string]int64          // Count of unexpected conditions, broken down by condition description.
+	queries    queryStats                // Count of queries of the source database, for direct connections (see querystats.go).
+	bySchema   map[string]*queryStats    // Count of queries about tables, broken down by source schema (see querystats.go).
+	unexpected map[string]*conditionStat // Count of unexpected conditions, broken down by condition description (see unexpected.go).
+	unexpBytes int64                     // Bytes stored for unexpected conditions (descriptions and snippets).
+	suppressed *suppressedConditions     // Unexpected conditions not stored because of the limits (nil if none).
 	reparsed   int64                     // Count of times we re-parse pg_dump data looking for end-of-statement.
+	retries    int64                     // Count of retries of writes to Spanner that failed with transient errors.
+	resumed    int64                     // Count of rows skipped because they were settled by a previous run (see checkpoint.go).
+	timing     map[string]*tableTiming   // Time spent and bytes processed during data conversion, broken down by source table.
+	storage    map[string]int64          // Estimated Spanner storage in bytes of rows converted, broken down by source table (see storage.go).
 }
 
 type statementStat struct {
@@ -123,16 +345,33 @@ func MakeConv() *Conv {
 		syntheticPKeys: make(map[string]syntheticPKey),
 		srcSchema:      make(map[string]schema.Table),
 		issues:         make(map[string]map[string][]schemaIssue),
+		groupIssues:    make(map[string][]groupIssue),
 		toSpanner:      make(map[string]nameAndCols),
 		toSource:       make(map[string]nameAndCols),
+		indexSQL:       make(map[string]map[string]string),
+		enums:          make(map[string][]string),
+		domains:        make(map[string]domainDef),
+		overflows:      make(map[string]map[string]int64),
+		tsOutOfRange:   make(map[string]map[string]int64),
+		floatSpecials:  make(map[string]map[string]int64),
+		invalidUTF8:    make(map[string]map[string]int64),
+		multiDimRows:   make(map[string]map[string]int64),
+		commitTS:       make(map[string]commitTSColumn),
+		partitions:     partitions{parent: make(map[string]string), keys: make(map[string][]string)},
+		inheritance:    inheritance{parents: make(map[string][]string), columns: make(map[string][]string), merged: make(map[string]string), column: make(map[string]string), order: make(map[string][]string)},
 		location:       time.Local, // By default, use go's local time, which uses $TZ (when set).
+		now:            time.Now,
 		sampleBadRows:  rowSamples{bytesLimit: 10 * 1000 * 1000},
 		stats: stats{
 			rows:       make(map[string]int64),
 			goodRows:   make(map[string]int64),
 			badRows:    make(map[string]int64),
+			badCauses:  make(map[string]badRowCauses),
+			tooLarge:   make(map[string]int64),
 			statement:  make(map[string]*statementStat),
-			unexpected: make(map[string]int64),
+			unexpected: make(map[string]*conditionStat),
+			timing:     make(map[string]*tableTiming),
+			storage:    make(map[string]int64),
 		},
 	}
 }
@@ -142,6 +381,22 @@ func (conv *Conv) SetDataSink(ds func(table string, cols []string, values []inte
 	conv.dataSink = ds
 }
 
+// SetProgress configures conv to report the rows read during data
+// conversion to p.
+func (conv *Conv) SetProgress(p ProgressSink) {
+	conv.progress = p
+}
+
+// SourceRowCounts returns the number of data rows of each source table
+// (see Rows), keyed by table name.
+func (conv *Conv) SourceRowCounts() map[string]int64 {
+	rows := make(map[string]int64)
+	for t, n := range conv.stats.rows {
+		rows[t] = n
+	}
+	return rows
+}
+
 // Note on modes.
 // We process the pg_dump output twice. In the first pass (schema mode) we
 // build the schema, and the second pass (data mode) we write data to
@@ -164,17 +419,106 @@ func (conv *Conv) SetDataMode() {
 	conv.mode = dataOnly
 }
 
+// SkipDataConversion records that data conversion was deliberately not
+// run (e.g. for a schema-only conversion), so that reports say the data
+// conversion was skipped rather than that no data rows were found.
+func (conv *Conv) SkipDataConversion() {
+	conv.dataSkipped = true
+}
+
+// SetDryRun records that data is converted, but not written anywhere,
+// so that reports say so.
+func (conv *Conv) SetDryRun() {
+	conv.dryRun = true
+}
+
+// SetDataTarget records where data is written, if not to Spanner, so
+// that reports say e.g. "rows written to Avro files" (see WrittenTo).
+func (conv *Conv) SetDataTarget(name string) {
+	conv.dataTarget = name
+}
+
+// SetDatabase records the full name of the Spanner database that data
+// is written to, so that reports give it (in the summary, and in the
+// JSON report for scripts).
+func (conv *Conv) SetDatabase(name string) {
+	conv.database = name
+}
+
+// DataTarget returns where data is written e.g. "Spanner", for reports.
+func (conv *Conv) DataTarget() string {
+	if conv.dataTarget == "" {
+		return "Spanner"
+	}
+	return conv.dataTarget
+}
+
+// WrittenTo describes how data is written, for reports: e.g. "written
+// to Spanner", or "would be written to Spanner" for dry runs.
+func (conv *Conv) WrittenTo() string {
+	if conv.dryRun {
+		return "would be written to " + conv.DataTarget()
+	}
+	return "written to " + conv.DataTarget()
+}
+
+// SpannerTable returns the Spanner schema of table spTable.
+func (conv *Conv) SpannerTable(spTable string) (ddl.CreateTable, bool) {
+	ct, ok := conv.spSchema[spTable]
+	return ct, ok
+}
+
 // GetDDL Schema returns the Spanner schema that has been constructed so far.
-// Return DDL in alphabetical table order.
+// Return DDL in alphabetical table order (each table followed by its
+// indexes), followed by ALTER TABLE statements that add foreign keys.
 func (conv *Conv) GetDDL(c ddl.Config) []string {
-	var tables []string
-	for t := range conv.spSchema {
-		tables = append(tables, t)
+	return conv.getDDL(c, true)
+}
+
+// GetTableDDL is like GetDDL, but leaves out secondary indexes (see
+// GetIndexDDL), so that they can be created after data is loaded.
+func (conv *Conv) GetTableDDL(c ddl.Config) []string {
+	return conv.getDDL(c, false)
+}
+
+// GetIndexDDL returns the CREATE INDEX statements of the Spanner
+// schema, in alphabetical table order.
+func (conv *Conv) GetIndexDDL(c ddl.Config) []string {
+	var ddl []string
+	for _, t := range conv.SpannerTables() {
+		for _, i := range conv.spSchema[t].Indexes {
+			ddl = append(ddl, i.PrintCreateIndex(c))
+		}
 	}
-	sort.Strings(tables)
+	return ddl
+}
+
+// GetSpannerTableDDL returns the DDL of Spanner table spTable: its
+// CREATE TABLE statement, followed by its indexes and foreign keys.
+func (conv *Conv) GetSpannerTableDDL(spTable string, c ddl.Config) []string {
+	ct := conv.spSchema[spTable]
+	ddl := []string{ct.PrintCreateTable(c)}
+	for _, i := range ct.Indexes {
+		ddl = append(ddl, i.PrintCreateIndex(c))
+	}
+	return append(ddl, ct.PrintForeignKeys(c)...)
+}
+
+func (conv *Conv) getDDL(c ddl.Config, indexes bool) []string {
+	tables := conv.SpannerTables()
 	var ddl []string
 	for _, t := range tables {
 		ddl = append(ddl, conv.spSchema[t].PrintCreateTable(c))
+		if !indexes {
+			continue
+		}
+		for _, i := range conv.spSchema[t].Indexes {
+			ddl = append(ddl, i.PrintCreateIndex(c))
+		}
+	}
+	// Foreign keys are added once all tables exist.
+	for _, t := range tables {
+		ddl = append(ddl, conv.spSchema[t].PrintForeignKeys(c)...)
 	}
 	return ddl
 }
@@ -187,8 +531,11 @@ func (conv *Conv) WriteRow(srcTable, spTable string, spCols []string, spVals []i
 		conv.unexpected(msg)
 		conv.statsAddBadRow(srcTable, conv.dataMode())
 	} else {
+		spCols, spVals = conv.transformRow(srcTable, spCols, spVals)
+		conv.checkRowDeletion(srcTable, spCols, spVals)
 		conv.dataSink(spTable, spCols, spVals)
 		conv.statsAddGoodRow(srcTable, conv.dataMode())
+		conv.statsAddStorage(srcTable, spTable, spCols, spVals)
 	}
 }
 
@@ -232,12 +579,24 @@ func (conv *Conv) StatementErrors() int64 {
 // Unexpecteds returns the total number of distinct unexpected conditions
 // encountered during processing.
 func (conv *Conv) Unexpecteds() int64 {
-	return int64(len(conv.stats.unexpected))
+	n := int64(len(conv.stats.unexpected))
+	if x := conv.stats.suppressed; x != nil {
+		n += x.distinct.estimate()
+	}
+	return n
 }
 
 // CollectBadRows updates the list of bad rows, while respecting
-// the byte limit for bad rows.
-func (conv *Conv) CollectBadRow(srcTable string, srcCols, vals []string) {
+// the byte limit for bad rows, records the row (and err, the
+// reason it is bad) in the bad-rows file, if there is one, and
+// counts it by cause for the report.
+func (conv *Conv) CollectBadRow(srcTable string, srcCols, vals []string, err error) {
+	if conv.badRowsOut != nil {
+		conv.badRowsOut.addConversionError(srcTable, srcCols, vals, err)
+	}
+	c := conversionCause(err)
+	conv.addBadRowCause(srcTable, c, 1)
+	conv.sampleBadValue(srcTable, srcCols, vals, c)
 	r := &row{table: srcTable, cols: srcCols, vals: vals}
 	bytes := byteSize(r)
 	// Cap storage used by badRows. Keep at least one bad row.
@@ -267,7 +626,7 @@ func (conv *Conv) AddPrimaryKeys() {
 		if len(ct.Pks) == 0 {
 			k := conv.buildPrimaryKey(t)
 			ct.ColNames = append(ct.ColNames, k)
-			ct.ColDefs[k] = ddl.ColumnDef{Name: k, T: ddl.Int64{}}
+			ct.ColDefs[k] = ddl.ColumnDef{Name: k, T: conv.syntheticPKType()}
 			ct.Pks = []ddl.IndexKey{ddl.IndexKey{Col: k}}
 			conv.spSchema[t] = ct
 			conv.syntheticPKeys[t] = syntheticPKey{k, 0}
@@ -275,6 +634,12 @@ func (conv *Conv) AddPrimaryKeys() {
 	}
 }
 
+// SetResourceUsage records resource usage high-water marks for the run,
+// for inclusion in the report.
+func (conv *Conv) SetResourceUsage(u ResourceUsage) {
+	conv.usage = &u
+}
+
 // SetLocation configures the timezone for data conversion.
 func (conv *Conv) SetLocation(loc *time.Location) {
 	conv.location = loc
@@ -298,19 +663,6 @@ func (conv *Conv) buildPrimaryKey(spTable string) string {
 	}
 }
 
-// unexpected records stats about corner-cases and conditions
-// that were not expected. Note that the counts maybe not
-// be completely reliable due to potential double-counting
-// because we process pg_dump data twice.
-func (conv *Conv) unexpected(u string) {
-	VerbosePrintf("Unexpected condition: %s\n", u)
-	// Limit size of unexpected map. If over limit, then only
-	// update existing entries.
-	if _, ok := conv.stats.unexpected[u]; ok || len(conv.stats.unexpected) < 1000 {
-		conv.stats.unexpected[u]++
-	}
-}
-
 // statsAddRow increments the count of rows for 'srcTable' if b is
 // true.  The boolean arg 'b' is used to avoid double counting of
 // stats. Specifically, some code paths that report row stats run in
@@ -321,36 +673,66 @@ func (conv *Conv) unexpected(u string) {
 // otherwise stats will be dropped.
 func (conv *Conv) statsAddRow(srcTable string, b bool) {
 	if b {
-		conv.stats.rows[srcTable]++
+		conv.statsAddRows(srcTable, 1)
 	}
 }
 
 func (conv *Conv) statsAddRows(srcTable string, count int64) {
 	conv.stats.rows[srcTable] += count
+	conv.metrics.add(metricRows, srcTable, count)
 }
 
 // statsAddGoodRow increments the good-row stats for 'srcTable' if b
 // is true.  See statsAddRow comments for context.
 func (conv *Conv) statsAddGoodRow(srcTable string, b bool) {
 	if b {
-		conv.stats.goodRows[srcTable]++
+		conv.statsAddGoodRows(srcTable, 1)
 	}
 }
 
 func (conv *Conv) statsAddGoodRows(srcTable string, count int64) {
 	conv.stats.goodRows[srcTable] += count
+	conv.metrics.add(metricGoodRows, srcTable, count)
 }
 
 // statsAddBadRow increments the bad-row stats for 'srcTable' if b is
 // true.  See statsAddRow comments for context.
 func (conv *Conv) statsAddBadRow(srcTable string, b bool) {
 	if b {
-		conv.stats.badRows[srcTable]++
+		conv.statsAddBadRows(srcTable, 1)
 	}
 }
 
 func (conv *Conv) statsAddBadRows(srcTable string, count int64) {
 	conv.stats.badRows[srcTable] += count
+	conv.metrics.add(metricBadRows, srcTable, count)
+}
+
+// statsMoveRows moves the count of rows of table from to table to.
+func (conv *Conv) statsMoveRows(from, to string) {
+	conv.stats.rows[to] += conv.stats.rows[from]
+	delete(conv.stats.rows, from)
+	conv.metrics.moveRows(from, to)
+}
+
+// AddCommitRetries records n retries of writes to Spanner that failed
+// with transient errors. Unlike most Conv methods, it is threadsafe, so
+// that retries can be recorded as they happen (see
+// spanner.BatchWriterConfig.OnRetry).
+func (conv *Conv) AddCommitRetries(n int64) {
+	atomic.AddInt64(&conv.stats.retries, n)
+	conv.metrics.add(metricRetries, "", n)
+}
+
+// AddTooLargeRows records counts of rows, keyed by source table, that
+// weren't written to Spanner because they exceed Spanner's commit size
+// limit. These rows are also bad writes: this just records the reason,
+// so that the report can give it.
+func (conv *Conv) AddTooLargeRows(m map[string]int64) {
+	for srcTable, n := range m {
+		conv.stats.tooLarge[srcTable] += n
+		conv.addBadRowCause(srcTable, badRowCause{reason: BadRowTooLarge}, n)
+	}
 }
 
 func prNodeType(n nodes.Node) string {
@@ -52,7 +52,9 @@ func TestGetDDL(t *testing.T) {
 			"a": ddl.ColumnDef{Name: "a", T: ddl.Int64{}},
 			"b": ddl.ColumnDef{Name: "b", T: ddl.Float64{}},
 		},
-		Pks: []ddl.IndexKey{ddl.IndexKey{Col: "a"}}}
+		Pks:         []ddl.IndexKey{ddl.IndexKey{Col: "a"}},
+		ForeignKeys: []ddl.ForeignKey{{Name: "fk", Columns: []string{"a"}, ReferTable: "table2", ReferColumns: []string{"a"}}},
+		Indexes:     []ddl.CreateIndex{{Name: "idx", Table: "table1", Keys: []ddl.IndexKey{{Col: "b"}}}}}
 	conv.spSchema["table2"] = ddl.CreateTable{
 		Name:     "table2",
 		ColNames: []string{"a"},
@@ -69,9 +71,12 @@ func TestGetDDL(t *testing.T) {
 	}
 	e := []string{
 		"CREATE TABLE table1 ( a INT64, b FLOAT64 ) PRIMARY KEY (a)",
+		"CREATE INDEX idx ON table1 (b)",
 		"CREATE TABLE table2 ( a INT64 ) PRIMARY KEY (a)",
+		// Foreign keys come after all tables, so that the referenced table exists.
+		"ALTER TABLE table1 ADD CONSTRAINT fk FOREIGN KEY (a) REFERENCES table2 (a)",
 	}
-	assert.ElementsMatch(t, normalize(e), normalize(ddl))
+	assert.Equal(t, normalize(e), normalize(ddl))
 }
 
 func TestRows(t *testing.T) {
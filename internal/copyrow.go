@@ -0,0 +1,143 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+)
+
+// Decoding of rows of COPY-FROM blocks, which use PostgreSQL's COPY
+// text format: values are separated by tabs, rows end with a newline,
+// \N is NULL, and backslash escapes represent special characters
+// within values (see
+// https://www.postgresql.org/docs/current/sql-copy.html).
+
+// continuesRow reports whether line b (including its newline) ends
+// with a backslash-newline, which represents a newline within a value
+// rather than the end of the row.
+func continuesRow(b []byte) bool {
+	n := len(b)
+	if n == 0 || b[n-1] != '\n' {
+		return false
+	}
+	return trailingBackslashes(string(b[:n-1]))%2 == 1
+}
+
+// trailingBackslashes returns the number of backslashes at the end of s.
+func trailingBackslashes(s string) int {
+	n := 0
+	for i := len(s) - 1; i >= 0 && s[i] == '\\'; i-- {
+		n++
+	}
+	return n
+}
+
+// decodeCopyRow decodes a row of a COPY-FROM block, returning its
+// values and whether each is NULL (see processDataRow).
+func decodeCopyRow(row string) (vals []string, nulls []bool) {
+	// Drop the row's end-of-line: an unescaped newline, optionally
+	// preceded by an unescaped carriage return.
+	for _, eol := range []string{"\n", "\r"} {
+		if strings.HasSuffix(row, eol) && trailingBackslashes(row[:len(row)-1])%2 == 0 {
+			row = row[:len(row)-1]
+		}
+	}
+	var b strings.Builder
+	raw := 0 // Start of the current value in row.
+	end := func(i int) {
+		if row[raw:i] == nullMarker {
+			vals = append(vals, nullMarker)
+			nulls = append(nulls, true)
+		} else {
+			vals = append(vals, b.String())
+			nulls = append(nulls, false)
+		}
+		b.Reset()
+		raw = i + 1
+	}
+	for i := 0; i < len(row); i++ {
+		c := row[i]
+		switch {
+		case c == '\t':
+			end(i)
+		case c == '\\' && i+1 < len(row):
+			i++
+			switch c := row[i]; c {
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'v':
+				b.WriteByte('\v')
+			case 'x':
+				// \x followed by one or two hex digits. Without them,
+				// it's just x.
+				v, n := parseDigits(row[i+1:], 16, 2)
+				if n == 0 {
+					b.WriteByte('x')
+				} else {
+					b.WriteByte(byte(v))
+					i += n
+				}
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				v, n := parseDigits(row[i:], 8, 3)
+				b.WriteByte(byte(v))
+				i += n - 1
+			default:
+				// Any other character (including backslash, and the
+				// newline of a backslash-newline) stands for itself.
+				b.WriteByte(c)
+			}
+		default:
+			// Note: a trailing backslash (which PostgreSQL rejects)
+			// is kept.
+			b.WriteByte(c)
+		}
+	}
+	end(len(row))
+	return vals, nulls
+}
+
+// parseDigits parses up to max leading digits of s in base (8 or 16),
+// returning their value and how many there were.
+func parseDigits(s string, base, max int) (v, n int) {
+	for n < max && n < len(s) {
+		d := digitValue(s[n])
+		if d < 0 || d >= base {
+			break
+		}
+		v = v*base + d
+		n++
+	}
+	return v, n
+}
+
+func digitValue(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	case 'A' <= c && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
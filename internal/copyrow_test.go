@@ -0,0 +1,83 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCopyRow(t *testing.T) {
+	tests := []struct {
+		name  string
+		row   string
+		vals  []string
+		nulls []bool
+	}{
+		{"simple", "a\tb c\n", []string{"a", "b c"}, []bool{false, false}},
+		{"CRLF", "a\tb\r\n", []string{"a", "b"}, []bool{false, false}},
+		{"no newline", "a", []string{"a"}, []bool{false}},
+		{"empty values", "\t\n", []string{"", ""}, []bool{false, false}},
+		{"null", "\\N\ta\n", []string{nullMarker, "a"}, []bool{true, false}},
+		{"literal \\N", "\\\\N\tN\n", []string{`\N`, "N"}, []bool{false, false}},
+		{"null prefix", "\\Nx\n", []string{"Nx"}, []bool{false}},
+		{"embedded tab", "a\\tb\tc\n", []string{"a\tb", "c"}, []bool{false, false}},
+		{"embedded newlines", "a\\nb\\rc\n", []string{"a\nb\rc"}, []bool{false}},
+		{"backslash-newline", "a\\\nb\tc\n", []string{"a\nb", "c"}, []bool{false, false}},
+		{"escaped CR", "a\\\r\n", []string{"a\r"}, []bool{false}},
+		{"other escapes", "\\b\\f\\v\n", []string{"\b\f\v"}, []bool{false}},
+		{"backslash", "a\\\\b\n", []string{`a\b`}, []bool{false}},
+		{"trailing backslash", "a\\\\\tb\\\n", []string{`a\`, "b\n"}, []bool{false, false}},
+		{"unterminated backslash", "a\\", []string{`a\`}, []bool{false}},
+		{"octal", "\\101\\0\\1234\n", []string{"A\x00S4"}, []bool{false}},
+		{"hex", "\\x41\\x4g\\xz\n", []string{"A\x04gxz"}, []bool{false}},
+		{"bytea", "\\\\x00ff\n", []string{`\x00ff`}, []bool{false}},
+		{"array", "{\"a\\\\\"b\",c}\n", []string{`{"a\"b",c}`}, []bool{false}},
+		{"emoji", "😀\t日本\n", []string{"😀", "日本"}, []bool{false, false}},
+		{"unknown escape", "\\q\n", []string{"q"}, []bool{false}},
+	}
+	for _, tc := range tests {
+		vals, nulls := decodeCopyRow(tc.row)
+		assert.Equal(t, tc.vals, vals, tc.name)
+		assert.Equal(t, tc.nulls, nulls, tc.name)
+	}
+}
+
+func TestContinuesRow(t *testing.T) {
+	assert.False(t, continuesRow([]byte("a\n")))
+	assert.True(t, continuesRow([]byte("a\\\n")))
+	assert.False(t, continuesRow([]byte("a\\\\\n")))
+	assert.True(t, continuesRow([]byte("a\\\\\\\n")))
+	assert.False(t, continuesRow([]byte("a\\")))
+}
+
+func TestProcessPgDump_CopyEscapes(t *testing.T) {
+	conv, rows := runProcessPgDump("CREATE TABLE t (id bigint PRIMARY KEY, s text, n bigint);\n" +
+		"COPY public.t (id, s, n) FROM stdin;\n" +
+		"1\ta\\tb\\\\\t\\N\n" +
+		"2\t\\\\N\t3\n" +
+		"3\tline 1\\\nline 2\t4\n" +
+		"4\t\\N\t\\N\n" +
+		"\\.\n")
+	noIssues(conv, t, "COPY escapes")
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"id", "s"}, vals: []interface{}{int64(1), "a\tb\\"}},
+		{table: "t", cols: []string{"id", "s", "n"}, vals: []interface{}{int64(2), `\N`, int64(3)}},
+		{table: "t", cols: []string{"id", "s", "n"}, vals: []interface{}{int64(3), "line 1\nline 2", int64(4)}},
+		{table: "t", cols: []string{"id"}, vals: []interface{}{int64(4)}},
+	}, rows)
+	assert.Equal(t, int64(4), conv.Rows())
+}
@@ -0,0 +1,426 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// CSV files have no schema, so the user supplies one: the Spanner types
+// of each table's columns, its primary key, and the files that hold its
+// data (see CSVSchema). Schema conversion maps these types to
+// themselves, so the Spanner schema is the one given, but tables get
+// the same naming, key and option handling as other sources. Data is
+// read twice, as for dumps: the schema pass counts rows (for reports
+// and progress), and the data pass converts them.
+
+// CSVSchema defines the tables of a CSV conversion, and the format of
+// their files. It is read from a JSON or YAML config file e.g.
+//
+//	tables:
+//	  - name: users
+//	    columns:
+//	      - {name: id, type: INT64, not_null: true}
+//	      - {name: email, type: STRING, length: 100}
+//	      - {name: joined, type: TIMESTAMP}
+//	    primary_key: [id]
+//	    files: [users-1.csv, users-2.csv.gz]
+//	format:
+//	  null: \N
+type CSVSchema struct {
+	Tables []CSVTable `json:"tables" yaml:"tables"`
+	Format CSVFormat  `json:"format" yaml:"format"`
+}
+
+// CSVTable defines a table of a CSV conversion.
+type CSVTable struct {
+	Name       string      `json:"name" yaml:"name"`
+	Columns    []CSVColumn `json:"columns" yaml:"columns"`
+	PrimaryKey []string    `json:"primary_key" yaml:"primary_key"` // Empty for a synthetic primary key.
+	Files      []string    `json:"files" yaml:"files"`             // Files holding the table's rows, possibly gzipped.
+}
+
+// CSVColumn defines a column of a CSV table.
+type CSVColumn struct {
+	Name    string `json:"name" yaml:"name"`
+	Type    string `json:"type" yaml:"type"`         // Spanner type e.g. "STRING" (case insensitive).
+	Length  int64  `json:"length" yaml:"length"`     // Length for STRING and BYTES. Zero means MAX.
+	NotNull bool   `json:"not_null" yaml:"not_null"` // Whether the Spanner column is NOT NULL.
+}
+
+// CSVFormat specifies the format of CSV files. Fields are separated by
+// the delimiter, and can be quoted, so that they can hold delimiters,
+// quotes and newlines. Within a quoted field, the escape character
+// followed by a quote or the escape character stands for that
+// character (so with the default escape, a doubled quote is a quote).
+// As in PostgreSQL's CSV format, an unquoted field that is the null
+// token is NULL, while a quoted one never is: by default, an empty
+// unquoted field is NULL, and "" is an empty string.
+type CSVFormat struct {
+	Delimiter string `json:"delimiter" yaml:"delimiter"` // Field delimiter (default "," and tab for .tsv files).
+	Quote     string `json:"quote" yaml:"quote"`         // Quote character (default '"').
+	Escape    string `json:"escape" yaml:"escape"`       // Escape character within quoted fields (default: the quote character).
+	Null      string `json:"null" yaml:"null"`           // Unquoted field value that stands for NULL (default empty).
+	NoHeader  bool   `json:"no_header" yaml:"no_header"` // Files don't start with a header line of column names, so they have all the columns, in order.
+}
+
+// ReadCSVSchema reads a CSV schema config file (see CSVSchema). The
+// file can be either JSON or YAML.
+func ReadCSVSchema(r io.Reader) (*CSVSchema, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("can't read CSV schema: %w", err)
+	}
+	var s CSVSchema
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		err = d.Decode(&s)
+	} else {
+		err = yaml.UnmarshalStrict(b, &s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't parse CSV schema: %w", err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// validate returns an error if s isn't a valid CSV schema.
+func (s *CSVSchema) validate() error {
+	if len(s.Tables) == 0 {
+		return fmt.Errorf("bad CSV schema: no tables")
+	}
+	tables := make(map[string]bool)
+	for i, t := range s.Tables {
+		if t.Name == "" {
+			return fmt.Errorf("bad CSV schema: table %d has no name", i+1)
+		}
+		if tables[t.Name] {
+			return fmt.Errorf("bad CSV schema: table %s is defined twice", t.Name)
+		}
+		tables[t.Name] = true
+		if len(t.Columns) == 0 {
+			return fmt.Errorf("bad CSV schema: table %s has no columns", t.Name)
+		}
+		cols := make(map[string]bool)
+		for j, c := range t.Columns {
+			if c.Name == "" {
+				return fmt.Errorf("bad CSV schema: column %d of table %s has no name", j+1, t.Name)
+			}
+			if cols[c.Name] {
+				return fmt.Errorf("bad CSV schema: column %s of table %s is defined twice", c.Name, t.Name)
+			}
+			cols[c.Name] = true
+			if _, err := c.spannerType(); err != nil {
+				return fmt.Errorf("bad CSV schema: column %s of table %s: %w", c.Name, t.Name, err)
+			}
+		}
+		key := make(map[string]bool)
+		for _, k := range t.PrimaryKey {
+			if !cols[k] {
+				return fmt.Errorf("bad CSV schema: primary key column %s isn't a column of table %s", k, t.Name)
+			}
+			if key[k] {
+				return fmt.Errorf("bad CSV schema: primary key column %s of table %s is listed twice", k, t.Name)
+			}
+			key[k] = true
+		}
+	}
+	f := s.Format
+	for _, c := range []struct {
+		name string
+		val  string
+	}{{"delimiter", f.Delimiter}, {"quote", f.Quote}, {"escape", f.Escape}} {
+		if c.val != "" && utf8.RuneCountInString(c.val) != 1 {
+			return fmt.Errorf("bad CSV format: %s must be a single character, got %q", c.name, c.val)
+		}
+	}
+	if f.Delimiter != "" && f.Delimiter == f.quote() {
+		return fmt.Errorf("bad CSV format: delimiter and quote must be different characters")
+	}
+	for _, c := range []string{"\n", "\r"} {
+		if f.Delimiter == c || f.Quote == c || f.Escape == c {
+			return fmt.Errorf("bad CSV format: delimiter, quote and escape can't be line breaks")
+		}
+	}
+	return nil
+}
+
+// spannerType returns the Spanner type of c.
+func (c CSVColumn) spannerType() (ddl.ScalarType, error) {
+	return TypeOverride{Type: c.Type, Length: c.Length}.spannerType()
+}
+
+func (f CSVFormat) quote() string {
+	if f.Quote == "" {
+		return `"`
+	}
+	return f.Quote
+}
+
+func (f CSVFormat) escape() string {
+	if f.Escape == "" {
+		return f.quote()
+	}
+	return f.Escape
+}
+
+// delimiter returns the delimiter of file, which defaults to tab for
+// TSV files.
+func (f CSVFormat) delimiter(file string) string {
+	switch {
+	case f.Delimiter != "":
+		return f.Delimiter
+	case strings.HasSuffix(strings.ToLower(strings.TrimSuffix(file, ".gz")), ".tsv"):
+		return "\t"
+	}
+	return ","
+}
+
+// SetCSVSchema configures conv to convert the CSV tables of s (see
+// ProcessCSVSchema and ProcessCSVTable). It must be called before
+// schema conversion.
+func (conv *Conv) SetCSVSchema(s *CSVSchema) {
+	conv.csv = s
+}
+
+// ProcessCSVSchema does schema conversion of the tables configured by
+// SetCSVSchema. Their rows are then counted by ProcessCSVTable.
+func ProcessCSVSchema(conv *Conv) {
+	for _, t := range conv.csv.Tables {
+		st := schema.Table{Name: t.Name, ColDefs: make(map[string]schema.Column)}
+		for _, c := range t.Columns {
+			ty := schema.Type{Name: strings.ToUpper(c.Type)}
+			if c.Length > 0 {
+				ty.Mods = []int64{c.Length}
+			}
+			st.ColNames = append(st.ColNames, c.Name)
+			st.ColDefs[c.Name] = schema.Column{Name: c.Name, Type: ty, NotNull: c.NotNull}
+		}
+		for _, k := range t.PrimaryKey {
+			st.PrimaryKeys = append(st.PrimaryKeys, schema.Key{Column: k})
+		}
+		conv.srcSchema[t.Name] = st
+	}
+	schemaToDDL(conv)
+	conv.AddPrimaryKeys()
+}
+
+// toSpannerTypeCSV maps the type of a CSV column (defined by id and
+// mods) into a Spanner type. CSV columns are defined with Spanner
+// types, so this is the identity mapping.
+func toSpannerTypeCSV(conv *Conv, id string, mods []int64) (ddl.ScalarType, []schemaIssue) {
+	c := CSVColumn{Type: id}
+	if len(mods) > 0 {
+		c.Length = mods[0]
+	}
+	ty, err := c.spannerType()
+	if err != nil {
+		// ReadCSVSchema has already validated the types.
+		conv.unexpected(fmt.Sprintf("Bad CSV column type %s: %s", id, err))
+		return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{noGoodType}
+	}
+	return ty, nil
+}
+
+// csvDataType returns the name of the PostgreSQL type whose values are
+// converted in the same way as values of CSV columns of type id (see
+// convScalar).
+func csvDataType(id string) string {
+	switch id {
+	case "TIMESTAMP":
+		// Timestamps without a time zone are interpreted in the
+		// configured location (see SetLocation).
+		return "timestamptz"
+	case "BYTES":
+		// As written by PostgreSQL's COPY e.g. \x0102.
+		return "bytea"
+	}
+	return id
+}
+
+// ProcessCSVTable reads the files of table t (see SetCSVSchema), using
+// open to open each file. In schema mode, it counts the rows, and in
+// data mode, it converts them. Reading stops once ctx is done.
+func ProcessCSVTable(ctx context.Context, conv *Conv, t CSVTable, open func(file string) (io.ReadCloser, error)) error {
+	st, ok := conv.srcSchema[t.Name]
+	if !ok {
+		return fmt.Errorf("table %s isn't in the schema", t.Name)
+	}
+	for _, file := range t.Files {
+		if ctx.Err() != nil {
+			return nil
+		}
+		f, err := open(file)
+		if err != nil {
+			return fmt.Errorf("can't open CSV file %s: %w", file, err)
+		}
+		err = processCSVFile(conv, st, file, NewReaderContext(ctx, bufio.NewReader(f), nil))
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if conv.dataMode() && ctx.Err() == nil {
+		conv.tableRead()
+	}
+	return nil
+}
+
+// processCSVFile reads the rows of table st from CSV file r.
+func processCSVFile(conv *Conv, st schema.Table, file string, r *Reader) error {
+	format := conv.csv.Format
+	s := csvScanner{r: r, delim: format.delimiter(file), quote: format.quote(), escape: format.escape()}
+	cols := st.ColNames
+	if !format.NoHeader {
+		header, _, err := s.next()
+		if err != nil {
+			return fmt.Errorf("can't read header of CSV file %s: %w", file, err)
+		}
+		if len(header) > 0 {
+			// Strip a UTF-8 byte order mark.
+			header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		}
+		for _, c := range header {
+			if _, ok := st.ColDefs[c]; !ok {
+				return fmt.Errorf("CSV file %s has column %s, which isn't a column of table %s", file, c, st.Name)
+			}
+		}
+		cols = header
+	}
+	for {
+		offset := r.Offset
+		vals, quoted, err := s.next()
+		if r.Stopped() || vals == nil {
+			break
+		}
+		if err != nil {
+			conv.setDumpContext(int64(offset), strings.Join(vals, format.delimiter(file)))
+			conv.unexpected(fmt.Sprintf("Processing CSV file %s: %s", file, err))
+			conv.clearDumpContext()
+		}
+		conv.statsAddRow(st.Name, conv.schemaMode())
+		if conv.dataMode() {
+			nulls := make([]bool, len(vals))
+			for i, v := range vals {
+				nulls[i] = !quoted[i] && v == format.Null
+				if i < len(cols) && st.ColDefs[cols[i]].Type.Name == "TIMESTAMP" && len(v) > 10 && v[10] == 'T' {
+					// Accept ISO 8601 timestamps e.g. 2020-01-02T03:04:05Z.
+					vals[i] = v[:10] + " " + v[11:]
+				}
+			}
+			processDataRow(conv, st.Name, cols, vals, nulls)
+		}
+	}
+	return nil
+}
+
+// csvScanner splits CSV files into records. Records can span lines,
+// since quoted fields can hold newlines.
+type csvScanner struct {
+	r      *Reader
+	delim  string
+	quote  string
+	escape string
+}
+
+// next returns the fields of the next record, and whether each was
+// quoted. It returns nil fields at the end of the input. A record that
+// ends with an unterminated quoted field is returned with an error.
+func (s *csvScanner) next() ([]string, []bool, error) {
+	var fields []string
+	var quoted []bool
+	var field strings.Builder
+	inQuotes, wasQuoted := false, false
+	for {
+		if s.r.EOF {
+			break
+		}
+		line := string(s.r.ReadLine())
+		if s.r.Stopped() {
+			return nil, nil, nil
+		}
+		if !inQuotes && fields == nil && field.Len() == 0 && strings.TrimRight(line, "\r\n") == "" {
+			if s.r.EOF {
+				return nil, nil, nil
+			}
+			continue // Skip blank lines.
+		}
+	scan:
+		for i := 0; i < len(line); {
+			switch {
+			case inQuotes && s.escape != s.quote && strings.HasPrefix(line[i:], s.escape) &&
+				(strings.HasPrefix(line[i+len(s.escape):], s.quote) || strings.HasPrefix(line[i+len(s.escape):], s.escape)):
+				_, n := utf8.DecodeRuneInString(line[i+len(s.escape):])
+				field.WriteString(line[i+len(s.escape) : i+len(s.escape)+n])
+				i += len(s.escape) + n
+			case inQuotes && strings.HasPrefix(line[i:], s.quote):
+				if s.escape == s.quote && strings.HasPrefix(line[i+len(s.quote):], s.quote) {
+					field.WriteString(s.quote)
+					i += 2 * len(s.quote)
+					continue
+				}
+				inQuotes = false
+				i += len(s.quote)
+			case inQuotes:
+				field.WriteByte(line[i])
+				i++
+			case line[i:] == "\n" || line[i:] == "\r\n":
+				break scan
+			case strings.HasPrefix(line[i:], s.delim):
+				fields = append(fields, field.String())
+				quoted = append(quoted, wasQuoted)
+				field.Reset()
+				wasQuoted = false
+				i += len(s.delim)
+			case strings.HasPrefix(line[i:], s.quote) && field.Len() == 0 && !wasQuoted:
+				inQuotes, wasQuoted = true, true
+				i += len(s.quote)
+			default:
+				// Quotes within unquoted fields are kept as is.
+				field.WriteByte(line[i])
+				i++
+			}
+		}
+		if !inQuotes {
+			break
+		}
+	}
+	if fields == nil && field.Len() == 0 && !wasQuoted {
+		return nil, nil, nil
+	}
+	fields = append(fields, field.String())
+	quoted = append(quoted, wasQuoted)
+	if inQuotes {
+		return fields, quoted, fmt.Errorf("unterminated quoted field at end of file")
+	}
+	return fields, quoted, nil
+}
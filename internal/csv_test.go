@@ -0,0 +1,201 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+const csvSchemaSample = `tables:
+- name: users
+  columns:
+  - {name: id, type: INT64}
+  - {name: name, type: STRING, length: 20, not_null: true}
+  - {name: bio, type: string}
+  - {name: joined, type: TIMESTAMP}
+  - {name: born, type: DATE}
+  - {name: pic, type: BYTES}
+  primary_key: [id]
+  files: [users-1.csv, users-2.csv]
+`
+
+// csvFiles maps file names to contents, for ProcessCSVTable.
+type csvFiles map[string]string
+
+func (f csvFiles) open(file string) (io.ReadCloser, error) {
+	s, ok := f[file]
+	if !ok {
+		return nil, fmt.Errorf("no such file")
+	}
+	return ioutil.NopCloser(strings.NewReader(s)), nil
+}
+
+func TestProcessCSVTable(t *testing.T) {
+	files := csvFiles{
+		"users-1.csv": "\ufeffid,name,bio,joined,born,pic\r\n" +
+			"1,ann,\"likes \"\"quotes\"\",\nand newlines\",2020-01-02T03:04:05Z,1990-05-06,\\x0102\r\n" +
+			"\r\n" +
+			"2,bob,,2020-01-02 03:04:05,,\n",
+		// Columns can be in any order, and a quoted empty field is an
+		// empty string rather than NULL.
+		"users-2.csv": "name,id,bio\n" +
+			"cat,3,\"\"\n" +
+			"dan,x,\n",
+	}
+	s, err := ReadCSVSchema(strings.NewReader(csvSchemaSample))
+	assert.Nil(t, err)
+	conv, rows := runProcessCSV(t, s, files)
+	assert.Equal(t, map[string]int64{
+		"Error while converting data: can't convert to int64: strconv.ParseInt: parsing \"...\": invalid syntax\n": 1,
+	}, unexpectedCounts(conv))
+	st := stripSchemaComments(conv.spSchema)["users"]
+	assert.Equal(t, []ddl.IndexKey{{Col: "id"}}, st.Pks)
+	expected := map[string]ddl.ColumnDef{
+		"id":     {Name: "id", T: ddl.Int64{}},
+		"name":   {Name: "name", T: ddl.String{Len: ddl.Int64Length{Value: 20}}, NotNull: true},
+		"bio":    {Name: "bio", T: ddl.String{Len: ddl.MaxLength{}}},
+		"joined": {Name: "joined", T: ddl.Timestamp{}},
+		"born":   {Name: "born", T: ddl.Date{}},
+		"pic":    {Name: "pic", T: ddl.Bytes{Len: ddl.MaxLength{}}},
+	}
+	for c, cd := range expected {
+		assert.Equal(t, cd, st.ColDefs[c], c)
+	}
+	assert.Empty(t, conv.issues["users"])
+
+	joined := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	expectedData := []spannerData{
+		{table: "users", cols: []string{"id", "name", "bio", "joined", "born", "pic"},
+			vals: []interface{}{int64(1), "ann", "likes \"quotes\",\nand newlines", joined, civil.Date{Year: 1990, Month: 5, Day: 6}, []byte{1, 2}}},
+		{table: "users", cols: []string{"id", "name", "joined"}, vals: []interface{}{int64(2), "bob", joined}},
+		{table: "users", cols: []string{"name", "id", "bio"}, vals: []interface{}{"cat", int64(3), ""}},
+	}
+	assert.Equal(t, expectedData, rows)
+	assert.Equal(t, int64(4), conv.stats.rows["users"])
+	assert.Equal(t, int64(1), conv.stats.badRows["users"])
+	assert.Equal(t, int64(3), conv.stats.goodRows["users"])
+}
+
+func TestProcessCSVTable_Format(t *testing.T) {
+	s := &CSVSchema{
+		Tables: []CSVTable{{
+			Name:    "t",
+			Columns: []CSVColumn{{Name: "a", Type: "STRING"}, {Name: "b", Type: "STRING"}},
+			Files:   []string{"t.tsv"},
+		}},
+		Format: CSVFormat{Quote: "'", Escape: `\`, Null: `\N`, NoHeader: true},
+	}
+	files := csvFiles{"t.tsv": "x\t'it\\'s \\\\ here'\n\\N\t'\\N'\n'tab\there'\t\n"}
+	conv, rows := runProcessCSV(t, s, files)
+	assert.Zero(t, len(conv.stats.unexpected), fmt.Sprintf("unexpected conditions: %v", unexpectedCounts(conv)))
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"a", "b", "synth_id"}, vals: []interface{}{"x", `it's \ here`, int64(0)}},
+		{table: "t", cols: []string{"b", "synth_id"}, vals: []interface{}{`\N`, int64(-1 << 63)}},
+		{table: "t", cols: []string{"a", "b", "synth_id"}, vals: []interface{}{"tab\there", "", int64(1 << 62)}},
+	}, rows)
+}
+
+func TestProcessCSVTable_Errors(t *testing.T) {
+	s, err := ReadCSVSchema(strings.NewReader(csvSchemaSample))
+	assert.Nil(t, err)
+	conv := MakeConv()
+	conv.SetCSVSchema(s)
+	conv.SetSchemaMode()
+	ProcessCSVSchema(conv)
+	err = ProcessCSVTable(context.Background(), conv, s.Tables[0], csvFiles{"users-1.csv": "id,email\n"}.open)
+	assert.EqualError(t, err, "CSV file users-1.csv has column email, which isn't a column of table users")
+	err = ProcessCSVTable(context.Background(), conv, s.Tables[0], csvFiles{"users-1.csv": "id\n"}.open)
+	assert.EqualError(t, err, "can't open CSV file users-2.csv: no such file")
+
+	files := csvFiles{"users-1.csv": "id,name\n1,ann\n", "users-2.csv": "id,name\n2,\"bob\n"}
+	conv, rows := runProcessCSV(t, s, files)
+	assert.Equal(t, map[string]int64{
+		"Processing CSV file users-2.csv: unterminated quoted field at end of file": 2,
+	}, unexpectedCounts(conv))
+	assert.Equal(t, 2, len(rows))
+}
+
+func TestReadCSVSchema(t *testing.T) {
+	for _, tc := range []struct {
+		schema string
+		err    string
+	}{
+		{csvSchemaSample, ""},
+		{`{"tables": [{"name": "t", "columns": [{"name": "a", "type": "BOOL"}], "files": ["t.csv"]}], "format": {"delimiter": "|"}}`, ""},
+		{`{"tables": [], "other": 1}`, `can't parse CSV schema: json: unknown field "other"`},
+		{"tables: []", "bad CSV schema: no tables"},
+		{"tables: [{columns: [{name: a, type: INT64}]}]", "bad CSV schema: table 1 has no name"},
+		{"tables: [{name: t, columns: [{name: a, type: INT64}]}, {name: t, columns: [{name: a, type: INT64}]}]", "bad CSV schema: table t is defined twice"},
+		{"tables: [{name: t}]", "bad CSV schema: table t has no columns"},
+		{"tables: [{name: t, columns: [{name: a, type: INT64}, {name: a, type: INT64}]}]", "bad CSV schema: column a of table t is defined twice"},
+		{"tables: [{name: t, columns: [{name: a, type: INT32}]}]", "bad CSV schema: column a of table t: unknown Spanner type \"INT32\""},
+		{"tables: [{name: t, columns: [{name: a, type: INT64}], primary_key: [b]}]", "bad CSV schema: primary key column b isn't a column of table t"},
+		{"tables: [{name: t, columns: [{name: a, type: INT64}]}]\nformat: {quote: \"''\"}", "bad CSV format: quote must be a single character, got \"''\""},
+		{"tables: [{name: t, columns: [{name: a, type: INT64}]}]\nformat: {delimiter: '\"'}", "bad CSV format: delimiter and quote must be different characters"},
+	} {
+		_, err := ReadCSVSchema(strings.NewReader(tc.schema))
+		if tc.err == "" {
+			assert.Nil(t, err, tc.schema)
+		} else {
+			assert.EqualError(t, err, tc.err, tc.schema)
+		}
+	}
+}
+
+func TestProcessCSVTable_Report(t *testing.T) {
+	s, err := ReadCSVSchema(strings.NewReader(csvSchemaSample))
+	assert.Nil(t, err)
+	conv, _ := runProcessCSV(t, s, csvFiles{"users-1.csv": "id,name\n1,ann\nx,bob\n", "users-2.csv": "id,name\n"})
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	GenerateReport(CSVSource, conv, w, nil)
+	w.Flush()
+	report := b.String()
+	assert.Contains(t, report, "Table users")
+	assert.NotContains(t, report, "Analysis of statements")
+}
+
+func runProcessCSV(t *testing.T, s *CSVSchema, files csvFiles) (*Conv, []spannerData) {
+	conv := MakeConv()
+	conv.SetLocation(time.UTC)
+	conv.SetCSVSchema(s)
+	conv.SetSchemaMode()
+	ProcessCSVSchema(conv)
+	for _, table := range s.Tables {
+		assert.Nil(t, ProcessCSVTable(context.Background(), conv, table, files.open))
+	}
+	conv.SetDataMode()
+	var rows []spannerData
+	conv.SetDataSink(
+		func(table string, cols []string, vals []interface{}) {
+			rows = append(rows, spannerData{table: table, cols: cols, vals: vals})
+		})
+	for _, table := range s.Tables {
+		assert.Nil(t, ProcessCSVTable(context.Background(), conv, table, files.open))
+	}
+	return conv, rows
+}
@@ -16,8 +16,9 @@ package internal
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"math/bits"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
@@ -29,16 +30,48 @@ import (
 	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
 )
 
+// nullMarker is the PostgreSQL representation of NULL in COPY-FROM
+// blocks. ProcessDataRow and ConvertData treat it as NULL.
+const nullMarker = "\\N"
+
 // ProcessDataRow converts a row of data and writes it out to Spanner.
 // srcTable and srcCols are the source table and columns respectively,
 // and vals contains string data to be converted to appropriate types
 // to send to Spanner.  ProcessDataRow is only called in dataMode.
 func ProcessDataRow(conv *Conv, srcTable string, srcCols, vals []string) {
-	spTable, spCols, spVals, err := ConvertData(conv, srcTable, srcCols, vals)
+	processDataRow(conv, srcTable, srcCols, vals, nullMarkers(vals))
+}
+
+// processDataRow is like ProcessDataRow, but nulls[i] reports whether
+// vals[i] is NULL (entries beyond the end of nulls are false). This
+// lets sources that decode values distinguish NULL from a value that
+// happens to be nullMarker.
+func processDataRow(conv *Conv, srcTable string, srcCols, vals []string, nulls []bool) {
+	var n int
+	for _, v := range vals {
+		n += len(v)
+	}
+	conv.rowRead(srcTable, int64(n))
+	if !conv.sampleRow(srcTable) {
+		return
+	}
+	if conv.checkpoint != nil && !conv.checkpoint.start(srcTable) {
+		return // Row was settled by a previous run.
+	}
+	if conv.mismatchedRow(srcTable, srcCols, vals) {
+		if conv.checkpoint != nil {
+			conv.checkpoint.badConversion()
+		}
+		return
+	}
+	spTable, spCols, spVals, err := convertData(conv, srcTable, srcCols, vals, nulls)
 	if err != nil {
+		if conv.checkpoint != nil {
+			conv.checkpoint.badConversion()
+		}
 		conv.unexpected(fmt.Sprintf("Error while converting data: %s\n", err))
 		conv.statsAddBadRow(srcTable, conv.dataMode())
-		conv.CollectBadRow(srcTable, srcCols, vals)
+		conv.CollectBadRow(srcTable, srcCols, vals, err)
 	} else {
 		conv.WriteRow(srcTable, spTable, spCols, spVals)
 	}
@@ -49,6 +82,20 @@ func ProcessDataRow(conv *Conv, srcTable string, srcCols, vals []string) {
 // in vals may be empty, we also return the list of columns (empty
 // cols are dropped).
 func ConvertData(conv *Conv, srcTable string, srcCols []string, vals []string) (string, []string, []interface{}, error) {
+	return convertData(conv, srcTable, srcCols, vals, nullMarkers(vals))
+}
+
+// nullMarkers returns the nulls of vals for processDataRow: the entries
+// that are nullMarker.
+func nullMarkers(vals []string) []bool {
+	nulls := make([]bool, len(vals))
+	for i, v := range vals {
+		nulls[i] = v == nullMarker
+	}
+	return nulls
+}
+
+func convertData(conv *Conv, srcTable string, srcCols []string, vals []string, nulls []bool) (string, []string, []interface{}, error) {
 	// Note: if there are many rows for the same srcTable/srcCols,
 	// then the following functionality will be (redundantly)
 	// repeated for every row converted. If this becomes a
@@ -74,32 +121,75 @@ func ConvertData(conv *Conv, srcTable string, srcCols []string, vals []string) (
 	}
 	for i, spCol := range spCols {
 		srcCol := srcCols[i]
-		if vals[i] == "\\N" { // PostgreSQL representation of empty column in COPY-FROM blocks.
+		if i < len(nulls) && nulls[i] {
+			conv.observeValue(srcTable, srcCol, "", true)
+			if spSchema.ColDefs[spCol].NotNull {
+				return "", []string{}, []interface{}{}, &nullError{srcCol}
+			}
 			continue
 		}
+		conv.observeValue(srcTable, srcCol, vals[i], false)
 		spColDef, ok1 := spSchema.ColDefs[spCol]
 		srcColDef, ok2 := srcSchema.ColDefs[srcCol]
 		if !ok1 || !ok2 {
 			return "", []string{}, []interface{}{}, fmt.Errorf("can't find Spanner and source-db schema for col %s", spCol)
 		}
+		srcType := srcColDef.Type.Name
+		if conv.mysql {
+			srcType = mysqlDataType(srcType)
+		}
+		if conv.csv != nil {
+			srcType = csvDataType(srcType)
+		}
+		if conv.sqlserver {
+			srcType = sqlServerDataType(srcType)
+		}
+		if conv.dynamo != nil {
+			if err := conv.dynamo.checkType(srcType, i); err != nil {
+				return "", []string{}, []interface{}{}, &columnError{col: srcCol, typ: srcType, err: err}
+			}
+		}
 		var x interface{}
 		var err error
 		if spColDef.IsArray {
-			x, err = convArray(spColDef.T, srcColDef.Type.Name, conv.location, vals[i])
+			x, err = convArray(spColDef.T, srcType, conv.location, conv.naiveZone(), vals[i])
 		} else {
-			x, err = convScalar(spColDef.T, srcColDef.Type.Name, conv.location, vals[i])
+			x, err = convScalar(spColDef.T, srcType, conv.location, conv.naiveZone(), vals[i])
+		}
+		if err == nil {
+			x, err = conv.fitUTF8(srcTable, srcCol, spColDef.T, x)
+		}
+		if err == nil {
+			x, err = conv.fitString(srcTable, srcCol, spColDef.T, x)
+		}
+		var null bool
+		if err == nil {
+			x, null, err = conv.fitFloat(srcTable, srcCol, spColDef.T, spColDef.NotNull, x, vals[i])
 		}
 		if err != nil {
-			return "", []string{}, []interface{}{}, err
+			var re *timestampRangeError
+			var de *arrayDimsError
+			switch {
+			case errors.As(err, &re):
+				conv.addTimestampRange(srcTable, srcCol)
+			case errors.As(err, &de):
+				conv.addMultiDimArray(srcTable, srcCol)
+			}
+			return "", []string{}, []interface{}{}, &columnError{col: srcCol, typ: srcType, err: err}
+		}
+		if null {
+			continue
 		}
 		v = append(v, x)
 		c = append(c, spCol)
 	}
-	if aux, ok := conv.syntheticPKeys[spTable]; ok {
-		c = append(c, aux.col)
-		v = append(v, int64(bits.Reverse64(uint64(aux.sequence))))
-		aux.sequence++
-		conv.syntheticPKeys[spTable] = aux
+	if col, x, ok := conv.nextSyntheticPK(spTable); ok {
+		c = append(c, col)
+		v = append(v, x)
+	}
+	if col, x, ok := conv.nextCommitTimestamp(spTable); ok {
+		c = append(c, col)
+		v = append(v, x)
 	}
 	return spTable, c, v, nil
 }
@@ -108,7 +198,7 @@ func ConvertData(conv *Conv, srcTable string, srcCols []string, vals []string) (
 // appropriate Spanner value. It is the caller's responsibility to
 // detect and handle NULL values: convScalar will return error if a
 // NULL value is passed.
-func convScalar(spannerType ddl.ScalarType, srcTypeName string, location *time.Location, val string) (interface{}, error) {
+func convScalar(spannerType ddl.ScalarType, srcTypeName string, location, naive *time.Location, val string) (interface{}, error) {
 	// Whitespace within the val string is considered part of the data value.
 	// Note that many of the underlying conversions functions we use (like
 	// strconv.ParseFloat and strconv.ParseInt) return "invalid syntax"
@@ -118,17 +208,19 @@ func convScalar(spannerType ddl.ScalarType, srcTypeName string, location *time.L
 	case ddl.Bool:
 		return convBool(val)
 	case ddl.Bytes:
-		return convBytes(val)
+		return convBytes(srcTypeName, val)
 	case ddl.Date:
 		return convDate(val)
 	case ddl.Float64:
 		return convFloat64(val)
 	case ddl.Int64:
 		return convInt64(val)
+	case ddl.Numeric:
+		return convNumeric(val)
 	case ddl.String:
 		return val, nil
 	case ddl.Timestamp:
-		return convTimestamp(srcTypeName, location, val)
+		return convTimestamp(srcTypeName, location, naive, val)
 	default:
 		return val, fmt.Errorf("data conversion not implemented for type %v", reflect.TypeOf(spannerType))
 	}
@@ -142,15 +234,92 @@ func convBool(val string) (bool, error) {
 	return b, err
 }
 
-func convBytes(val string) ([]byte, error) {
-	if val[0:2] != `\x` {
-		return []byte{}, fmt.Errorf("can't convert to bytes: doesn't start with \\x prefix")
+// convBytes maps a source DB value to bytes. Only bytea values are
+// encoded, using PostgreSQL's hex format (the default) or its older
+// escape format: values of other types (e.g. a varchar mapped to BYTES
+// via the type map) are converted as is.
+func convBytes(srcTypeName, val string) ([]byte, error) {
+	if srcTypeName != "bytea" {
+		return []byte(val), nil
 	}
-	b, err := hex.DecodeString(val[2:])
-	if err != nil {
-		return b, fmt.Errorf("can't convert to bytes: %w", err)
+	if strings.HasPrefix(val, `\x`) {
+		b, err := hex.DecodeString(val[2:])
+		if err != nil {
+			return []byte{}, &byteaError{fmt.Errorf("bad hex format: %w", err)}
+		}
+		return b, nil
 	}
-	return b, err
+	return decodeByteaEscape(val)
+}
+
+// decodeByteaEscape decodes a bytea value in PostgreSQL's escape
+// format, where bytes are either literal, \\ (a backslash), or \
+// followed by three octal digits.
+func decodeByteaEscape(val string) ([]byte, error) {
+	b := make([]byte, 0, len(val))
+	for i := 0; i < len(val); i++ {
+		if val[i] != '\\' {
+			b = append(b, val[i])
+			continue
+		}
+		if i+1 < len(val) && val[i+1] == '\\' {
+			b = append(b, '\\')
+			i++
+			continue
+		}
+		if i+3 < len(val) {
+			if v, err := strconv.ParseUint(val[i+1:i+4], 8, 8); err == nil {
+				b = append(b, byte(v))
+				i += 3
+				continue
+			}
+		}
+		return []byte{}, &byteaError{fmt.Errorf("bad escape format: invalid escape at offset %d", i)}
+	}
+	return b, nil
+}
+
+// byteaError is the error for bytea values that can't be decoded.
+// Rows with such values are bad rows with reason BadRowBytea.
+type byteaError struct {
+	err error
+}
+
+func (e *byteaError) Error() string {
+	return fmt.Sprintf("can't convert to bytes: %s", e.err)
+}
+
+func (e *byteaError) Unwrap() error {
+	return e.err
+}
+
+// columnError wraps the error for a value of column col, of source type
+// typ, that couldn't be converted, so that the report can say which
+// columns bad rows come from (see badRowCause). It doesn't change the
+// error message.
+type columnError struct {
+	col string
+	typ string
+	err error
+}
+
+func (e *columnError) Error() string {
+	return e.err.Error()
+}
+
+func (e *columnError) Unwrap() error {
+	return e.err
+}
+
+// nullError is the error for NULL values of columns that are NOT NULL
+// in Spanner, which Spanner would reject. Rows with such values are bad
+// rows with reason BadRowNull.
+type nullError struct {
+	col string
+}
+
+func (e *nullError) Error() string {
+	return fmt.Sprintf("value of column %s is NULL, but its Spanner column is NOT NULL", e.col)
 }
 
 func convDate(val string) (civil.Date, error) {
@@ -163,6 +332,11 @@ func convDate(val string) (civil.Date, error) {
 
 func convFloat64(val string) (float64, error) {
 	f, err := strconv.ParseFloat(val, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		// Out of range values parse as infinities, which are handled
+		// by the float policy (see fitFloat).
+		return f, nil
+	}
 	if err != nil {
 		return f, fmt.Errorf("can't convert to float64: %w", err)
 	}
@@ -177,15 +351,49 @@ func convInt64(val string) (int64, error) {
 	return i, err
 }
 
+// maxNumeric is the smallest value too large for a Spanner NUMERIC
+// i.e. NUMERIC values have at most 29 digits before the decimal point.
+var maxNumeric = new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(29), nil))
+
+// convNumeric maps a source DB numeric into the canonical string
+// representation of a Spanner NUMERIC. We send NUMERIC values to
+// Spanner as strings: this is their wire encoding, and mutations don't
+// carry type information (so this works even though our version of
+// the Spanner client library predates NUMERIC). Returns an error if
+// val doesn't fit in a NUMERIC, rather than silently losing precision.
+func convNumeric(val string) (string, error) {
+	r, ok := new(big.Rat).SetString(val)
+	if !ok {
+		return "", fmt.Errorf("can't convert to numeric: invalid syntax %q", val)
+	}
+	if new(big.Rat).Abs(r).Cmp(maxNumeric) >= 0 {
+		return "", fmt.Errorf("can't convert to numeric: %s has more than 29 digits before the decimal point", val)
+	}
+	s := r.FloatString(9)
+	if x, _ := new(big.Rat).SetString(s); x.Cmp(r) != 0 {
+		return "", fmt.Errorf("can't convert to numeric: %s has more than 9 digits after the decimal point", val)
+	}
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" {
+		s = "0"
+	}
+	return s, nil
+}
+
 // convTimestamp maps a source DB timestamp into a go Time (which
 // is translated to a Spanner timestamp by the go Spanner client library).
-// It handles both timestamptz and timestamp conversions.
+// It handles both timestamptz and timestamp conversions: timestamps
+// without time zone are interpreted in zone naive (see
+// SetTimestampZone).
 // Note that PostgreSQL supports a wide variety of different timestamp
 // formats (see https://www.postgresql.org/docs/9.1/datatype-datetime.html).
 // We don't attempt to support all of these timestamp formats. Our goal
 // is more modest: we just need to support the formats generated by
 // pg_dump.
-func convTimestamp(srcTypeName string, location *time.Location, val string) (t time.Time, err error) {
+func convTimestamp(srcTypeName string, location, naive *time.Location, val string) (t time.Time, err error) {
+	if outOfRangeTimestamp(val) {
+		return t, &timestampRangeError{val}
+	}
 	// pg_dump outputs timestamps as ISO 8601, except:
 	// a) it uses space instead of T
 	// b) timezones are abbreviated to just hour (minute is specified only if non-zero).
@@ -207,14 +415,12 @@ func convTimestamp(srcTypeName string, location *time.Location, val string) (t t
 		}
 	} else {
 		// timestamp without time zone: data should just consist of date and time.
-		// timestamp conversion should ignore timezone. We mimic this using Parse
-		// i.e. treat it as UTC, so it will be stored 'as-is' in Spanner.
-		t, err = time.Parse("2006-01-02 15:04:05", val)
+		t, err = time.ParseInLocation("2006-01-02 15:04:05", val, naive)
 	}
 	if err != nil {
 		return t, fmt.Errorf("can't convert to timestamp (posgres type: %s)", srcTypeName)
 	}
-	return t, err
+	return t, checkTimestampRange(t, val)
 }
 
 // convArray converts a source database string value (representing an
@@ -223,18 +429,17 @@ func convTimestamp(srcTypeName string, location *time.Location, val string) (t t
 // is NULL. However, convArray does handle the case where individual
 // array elements are NULL. In other words, convArray handles "{1,
 // NULL, 2}", but it does not handle "NULL" (it returns error).
-func convArray(spannerType ddl.ScalarType, srcTypeName string, location *time.Location, v string) (interface{}, error) {
-	v = strings.TrimSpace(v)
+func convArray(spannerType ddl.ScalarType, srcTypeName string, location, naive *time.Location, v string) (interface{}, error) {
+	a, nulls, err := parseArray(v)
+	if err != nil {
+		return []interface{}{}, err
+	}
 	// Handle empty array. Note that we use an empty NullString array
 	// for all Spanner array types since this will be converted to the
 	// appropriate type by the Spanner client.
-	if v == "{}" {
+	if len(a) == 0 {
 		return []spanner.NullString{}, nil
 	}
-	if v[0] != '{' || v[len(v)-1] != '}' {
-		return []interface{}{}, fmt.Errorf("unrecognized data format for array: expected {v1, v2, ...}")
-	}
-	a := strings.Split(v[1:len(v)-1], ",")
 
 	// The Spanner client for go does not accept []interface{} for arrays.
 	// Instead it only accepts slices of a specific type e.g. []int64, []string.
@@ -242,15 +447,11 @@ func convArray(spannerType ddl.ScalarType, srcTypeName string, location *time.Lo
 	switch spannerType.(type) {
 	case ddl.Bool:
 		var r []spanner.NullBool
-		for _, s := range a {
-			if s == "NULL" {
+		for i, s := range a {
+			if nulls[i] {
 				r = append(r, spanner.NullBool{Valid: false})
 				continue
 			}
-			s, err := processQuote(s)
-			if err != nil {
-				return []spanner.NullBool{}, err
-			}
 			b, err := convBool(s)
 			if err != nil {
 				return []spanner.NullBool{}, err
@@ -260,16 +461,12 @@ func convArray(spannerType ddl.ScalarType, srcTypeName string, location *time.Lo
 		return r, nil
 	case ddl.Bytes:
 		var r [][]byte
-		for _, s := range a {
-			if s == "NULL" {
+		for i, s := range a {
+			if nulls[i] {
 				r = append(r, nil)
 				continue
 			}
-			s, err := processQuote(s)
-			if err != nil {
-				return [][]byte{}, err
-			}
-			b, err := convBytes(s)
+			b, err := convBytes(srcTypeName, s)
 			if err != nil {
 				return [][]byte{}, err
 			}
@@ -278,15 +475,11 @@ func convArray(spannerType ddl.ScalarType, srcTypeName string, location *time.Lo
 		return r, nil
 	case ddl.Date:
 		var r []spanner.NullDate
-		for _, s := range a {
-			if s == "NULL" {
+		for i, s := range a {
+			if nulls[i] {
 				r = append(r, spanner.NullDate{Valid: false})
 				continue
 			}
-			s, err := processQuote(s)
-			if err != nil {
-				return []spanner.NullDate{}, err
-			}
 			d, err := convDate(s)
 			if err != nil {
 				return []spanner.NullDate{}, err
@@ -296,15 +489,11 @@ func convArray(spannerType ddl.ScalarType, srcTypeName string, location *time.Lo
 		return r, nil
 	case ddl.Float64:
 		var r []spanner.NullFloat64
-		for _, s := range a {
-			if s == "NULL" {
+		for i, s := range a {
+			if nulls[i] {
 				r = append(r, spanner.NullFloat64{Valid: false})
 				continue
 			}
-			s, err := processQuote(s)
-			if err != nil {
-				return []spanner.NullFloat64{}, err
-			}
 			f, err := convFloat64(s)
 			if err != nil {
 				return []spanner.NullFloat64{}, err
@@ -314,15 +503,11 @@ func convArray(spannerType ddl.ScalarType, srcTypeName string, location *time.Lo
 		return r, nil
 	case ddl.Int64:
 		var r []spanner.NullInt64
-		for _, s := range a {
-			if s == "NULL" {
+		for i, s := range a {
+			if nulls[i] {
 				r = append(r, spanner.NullInt64{Valid: false})
 				continue
 			}
-			s, err := processQuote(s)
-			if err != nil {
-				return []spanner.NullInt64{}, err
-			}
 			i, err := convInt64(s)
 			if err != nil {
 				return r, err
@@ -330,32 +515,39 @@ func convArray(spannerType ddl.ScalarType, srcTypeName string, location *time.Lo
 			r = append(r, spanner.NullInt64{Int64: i, Valid: true})
 		}
 		return r, nil
-	case ddl.String:
+	case ddl.Numeric:
+		// See convNumeric: NUMERIC values are sent as strings.
 		var r []spanner.NullString
-		for _, s := range a {
-			if s == "NULL" {
+		for i, s := range a {
+			if nulls[i] {
 				r = append(r, spanner.NullString{Valid: false})
 				continue
 			}
-			s, err := processQuote(s)
+			n, err := convNumeric(s)
 			if err != nil {
 				return []spanner.NullString{}, err
 			}
+			r = append(r, spanner.NullString{StringVal: n, Valid: true})
+		}
+		return r, nil
+	case ddl.String:
+		var r []spanner.NullString
+		for i, s := range a {
+			if nulls[i] {
+				r = append(r, spanner.NullString{Valid: false})
+				continue
+			}
 			r = append(r, spanner.NullString{StringVal: s, Valid: true})
 		}
 		return r, nil
 	case ddl.Timestamp:
 		var r []spanner.NullTime
-		for _, s := range a {
-			if s == "NULL" {
+		for i, s := range a {
+			if nulls[i] {
 				r = append(r, spanner.NullTime{Valid: false})
 				continue
 			}
-			s, err := processQuote(s)
-			if err != nil {
-				return []spanner.NullTime{}, err
-			}
-			t, err := convTimestamp(srcTypeName, location, s)
+			t, err := convTimestamp(srcTypeName, location, naive, s)
 			if err != nil {
 				return []spanner.NullTime{}, err
 			}
@@ -366,21 +558,6 @@ func convArray(spannerType ddl.ScalarType, srcTypeName string, location *time.Lo
 	return []interface{}{}, fmt.Errorf("array type conversion not implemented for type %v", reflect.TypeOf(spannerType))
 }
 
-// processQuote returns the unquoted version of s.
-// Note: The element values of PostgreSQL arrays may have double
-// quotes around them.  The array output routine will put double
-// quotes around element values if they are empty strings, contain
-// curly braces, delimiter characters, double quotes, backslashes, or
-// white space, or match the word NULL. Double quotes and backslashes
-// embedded in element values will be backslash-escaped.  See section
-// 8.14.6.of www.postgresql.org/docs/9.1/arrays.html.
-func processQuote(s string) (string, error) {
-	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
-		return strconv.Unquote(s)
-	}
-	return s, nil
-}
-
 func byteSize(r *row) int64 {
 	n := int64(len(r.table))
 	for _, c := range r.cols {
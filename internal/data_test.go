@@ -15,8 +15,11 @@
 package internal
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"math/bits"
+	"strings"
 	"testing"
 	"time"
 
@@ -70,10 +73,14 @@ func TestConvertData(t *testing.T) {
 		e       interface{} // Expected result.
 	}{
 		{"bool", ddl.Bool{}, false, "", "true", true},
-		{"bytes", ddl.Bytes{Len: ddl.MaxLength{}}, false, "", `\x0001beef`, []byte{0x0, 0x1, 0xbe, 0xef}},
+		{"bytes", ddl.Bytes{Len: ddl.MaxLength{}}, false, "bytea", `\x0001beef`, []byte{0x0, 0x1, 0xbe, 0xef}},
+		{"bytes escape format", ddl.Bytes{Len: ddl.MaxLength{}}, false, "bytea", `a\000\\\377`, []byte{'a', 0x0, '\\', 0xff}},
+		{"bytes empty", ddl.Bytes{Len: ddl.MaxLength{}}, false, "bytea", "", []byte{}},
+		{"bytes from text", ddl.Bytes{Len: ddl.MaxLength{}}, false, "varchar", "abc", []byte("abc")},
 		{"date", ddl.Date{}, false, "", "2019-10-29", getDate("2019-10-29")},
 		{"float64", ddl.Float64{}, false, "", "42.6", float64(42.6)},
 		{"int64", ddl.Int64{}, false, "", "42", int64(42)},
+		{"numeric", ddl.Numeric{}, false, "", "42.50", "42.5"},
 		{"string", ddl.String{Len: ddl.MaxLength{}}, false, "", "eh", "eh"},
 		{"timestamptz", ddl.Timestamp{}, false, "timestamptz", "2019-10-29 05:30:00+10", getTime(t, "2019-10-29T05:30:00+10:00")},
 		{"timestamp", ddl.Timestamp{}, false, "timestamp", "2019-10-29 05:30:00", getTime(t, "2019-10-29T05:30:00Z")},
@@ -84,7 +91,7 @@ func TestConvertData(t *testing.T) {
 		{"bool array", ddl.Bool{}, true, "", "{true,false,NULL}", []spanner.NullBool{
 			spanner.NullBool{Bool: true, Valid: true}, spanner.NullBool{Bool: false, Valid: true},
 			spanner.NullBool{Valid: false}}},
-		{"bytes array", ddl.Bytes{Len: ddl.MaxLength{}}, true, "", `{"\\x0001beef",NULL}`, [][]byte{{0x0, 0x1, 0xbe, 0xef}, nil}},
+		{"bytes array", ddl.Bytes{Len: ddl.MaxLength{}}, true, "bytea", `{"\\x0001beef",NULL}`, [][]byte{{0x0, 0x1, 0xbe, 0xef}, nil}},
 		{"date array", ddl.Date{}, true, "", "{2019-10-29,NULL,2019-10-28}", []spanner.NullDate{
 			spanner.NullDate{Date: getDate("2019-10-29"), Valid: true},
 			spanner.NullDate{Valid: false},
@@ -99,6 +106,10 @@ func TestConvertData(t *testing.T) {
 			spanner.NullInt64{Int64: 1, Valid: true},
 			spanner.NullInt64{Int64: 2, Valid: true},
 			spanner.NullInt64{Int64: 3, Valid: true}}},
+		{"numeric array", ddl.Numeric{}, true, "", "{NULL,1.10,-2}", []spanner.NullString{
+			spanner.NullString{Valid: false},
+			spanner.NullString{StringVal: "1.1", Valid: true},
+			spanner.NullString{StringVal: "-2", Valid: true}}},
 		{"string array", ddl.String{Len: ddl.MaxLength{}}, true, "", `{1,NULL,3,"NULL"}`, []spanner.NullString{
 			spanner.NullString{StringVal: "1", Valid: true},
 			spanner.NullString{Valid: false},
@@ -307,3 +318,59 @@ func getDate(s string) civil.Date {
 	d, _ := civil.ParseDate(s)
 	return d
 }
+
+func TestConvNumeric(t *testing.T) {
+	tc := []struct {
+		in  string
+		e   string
+		err bool
+	}{
+		{in: "0", e: "0"},
+		{in: "-0.000", e: "0"},
+		{in: "007.2500", e: "7.25"},
+		{in: "-1e3", e: "-1000"},
+		{in: "0.000000001", e: "0.000000001"},
+		{in: "99999999999999999999999999999.999999999", e: "99999999999999999999999999999.999999999"},
+		{in: "100000000000000000000000000000", err: true},
+		{in: "0.0000000001", err: true},
+		{in: "NaN", err: true},
+		{in: "1.2.3", err: true},
+	}
+	for _, c := range tc {
+		s, err := convNumeric(c.in)
+		if c.err {
+			assert.NotNil(t, err, c.in)
+			continue
+		}
+		assert.Nil(t, err, c.in)
+		assert.Equal(t, c.e, s, c.in)
+	}
+}
+
+func TestProcessPgDump_Bytea(t *testing.T) {
+	conv := MakeConv()
+	var buf bytes.Buffer
+	conv.SetBadRowWriter(NewBadRowWriter(&buf, "bad.jsonl", 1000))
+	conv, rows := runProcessPgDumpConv(conv, "CREATE TABLE b (id bigint PRIMARY KEY, v bytea);\n"+
+		"COPY public.b (id, v) FROM stdin;\n"+
+		"1\t\\\\x00ff80\n"+ // Hex format.
+		"2\ta\\\\000\\\\377\\\\\\\\\n"+ // Escape format.
+		"3\t\\\\xzz\n"+
+		"4\t\\\\9\n"+
+		"\\.\n"+
+		"INSERT INTO b VALUES (5, '\\x00ff80'), (6, E'\\\\000\\\\377');\n")
+	assert.Equal(t, []spannerData{
+		{table: "b", cols: []string{"id", "v"}, vals: []interface{}{int64(1), []byte{0x0, 0xff, 0x80}}},
+		{table: "b", cols: []string{"id", "v"}, vals: []interface{}{int64(2), []byte{'a', 0x0, 0xff, '\\'}}},
+		{table: "b", cols: []string{"id", "v"}, vals: []interface{}{int64(5), []byte{0x0, 0xff, 0x80}}},
+		{table: "b", cols: []string{"id", "v"}, vals: []interface{}{int64(6), []byte{0x0, 0xff}}},
+	}, rows)
+	assert.Equal(t, int64(2), conv.BadRows())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	for _, l := range lines {
+		var r badRowRecord
+		assert.Nil(t, json.Unmarshal([]byte(l), &r))
+		assert.Equal(t, BadRowBytea, r.Reason)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// DDL statements can be applied to the Spanner database after data
+// conversion, rather than when the database is created: secondary
+// indexes slow bulk writes dramatically, so it's much faster to load
+// data first and then create the indexes (backfilling them). The
+// statements are applied by the caller (see AddAppliedDDL), since that
+// needs access to Spanner. A statement that fails doesn't stop the rest
+// from being applied, and failures are listed in the report.
+
+// ddlApplication records the DDL statements applied after data
+// conversion.
+type ddlApplication struct {
+	applied  int64
+	failures []ddlFailure
+}
+
+// ddlFailure is a DDL statement that Spanner failed to apply.
+type ddlFailure struct {
+	stmt string
+	err  string
+}
+
+// AddAppliedDDL records that DDL statement stmt was applied to the
+// Spanner database after data conversion, or failed with err (if
+// non-nil). Reports then include a DDL application section.
+func (conv *Conv) AddAppliedDDL(stmt string, err error) {
+	if conv.ddlApplied == nil {
+		conv.ddlApplied = &ddlApplication{}
+	}
+	if err != nil {
+		conv.ddlApplied.failures = append(conv.ddlApplied.failures, ddlFailure{stmt: stmt, err: err.Error()})
+		return
+	}
+	conv.ddlApplied.applied++
+}
+
+// DDLFailures returns the number of DDL statements that failed to apply
+// after data conversion.
+func (conv *Conv) DDLFailures() int {
+	if conv.ddlApplied == nil {
+		return 0
+	}
+	return len(conv.ddlApplied.failures)
+}
+
+// ddlSummary returns a note on DDL applied after data conversion for
+// the report summary, or "" if there was none.
+func ddlSummary(conv *Conv) string {
+	a := conv.ddlApplied
+	if a == nil {
+		return ""
+	}
+	total := a.applied + int64(len(a.failures))
+	if len(a.failures) > 0 {
+		return fmt.Sprintf("DDL application: %d of %d statements applied after data conversion failed (see the DDL Application section)", len(a.failures), total)
+	}
+	return fmt.Sprintf("DDL application: all statements applied after data conversion succeeded (%d)", total)
+}
+
+func writeDDLApplication(r *Report, w *bufio.Writer) {
+	writeHeading(w, "DDL Application")
+	if len(r.DDLBatches) > 0 {
+		writeDDLBatches(r.DDLBatches, w)
+	}
+	if r.DDLApplied == nil {
+		return
+	}
+	justifyLines(w, fmt.Sprintf("Secondary indexes were created after data conversion, "+
+		"since creating them first slows data loading. Statements applied: %d. "+
+		"Statements that failed: %d.", *r.DDLApplied, len(r.DDLFailures)), 80, 0)
+	w.WriteString("\n")
+	if len(r.DDLFailures) > 0 {
+		justifyLines(w, "Failed statements (the rest of the schema is unaffected, "+
+			"so they can be fixed and applied by hand):", 80, 0)
+		w.WriteString("\n")
+		for i, f := range r.DDLFailures {
+			justifyLines(w, fmt.Sprintf("%d) %s\nError: %s\n", i+1, f.Statement, f.Error), 80, 3)
+		}
+	}
+	w.WriteString("\n")
+}
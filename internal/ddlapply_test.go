@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestGetTableDDL(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t (a bigint PRIMARY KEY, b text);\n" +
+		"CREATE TABLE u (a bigint PRIMARY KEY, t bigint REFERENCES t (a));\n" +
+		"CREATE INDEX b_idx ON t (b);\n")
+	c := ddl.Config{}
+	all := conv.GetDDL(c)
+	tables := conv.GetTableDDL(c)
+	indexes := conv.GetIndexDDL(c)
+	assert.Equal(t, []string{"CREATE INDEX b_idx ON t (b)"}, indexes)
+	assert.Equal(t, len(all), len(tables)+len(indexes))
+	assert.NotContains(t, tables, indexes[0])
+	// Foreign keys still follow the tables.
+	assert.Contains(t, tables[len(tables)-1], "FOREIGN KEY")
+}
+
+func TestReport_DDLApplication(t *testing.T) {
+	conv, _ := runProcessPgDumpConv(MakeConv(), verifyDump)
+	assert.Equal(t, 0, conv.DDLFailures())
+	conv.AddAppliedDDL("CREATE INDEX b_idx ON t (b)", nil)
+	conv.AddAppliedDDL("CREATE UNIQUE INDEX a_idx ON u (a)", fmt.Errorf("duplicate key"))
+	assert.Equal(t, 1, conv.DDLFailures())
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	summary := GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, summary, "DDL application: 1 of 2 statements applied after data conversion failed (see the DDL Application section).\n")
+	assert.Contains(t, normalizeSpace(buf.String()), "Statements applied: 1. Statements that failed: 1.")
+	assert.Contains(t, buf.String(), "1) CREATE UNIQUE INDEX a_idx ON u (a)\n   Error: duplicate key\n")
+
+	buf.Reset()
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, buf, nil))
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, []ReportDDLFailure{{"CREATE UNIQUE INDEX a_idx ON u (a)", "duplicate key"}}, r.DDLFailures)
+
+	conv, _ = runProcessPgDumpConv(MakeConv(), verifyDump)
+	conv.AddAppliedDDL("CREATE INDEX b_idx ON t (b)", nil)
+	assert.Contains(t, generateSummary(conv, analyzeTables(conv, nil), nil), "DDL application: all statements applied after data conversion succeeded (1).\n")
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// The DDL statements that create the Spanner schema are applied in
+// batches, since the admin API limits the size of schema updates.
+// Batches are applied in order, and so are the statements of each
+// batch, so if a statement fails, the statements before it have been
+// applied and the rest haven't. Schema creation can then be resumed
+// from the failed batch once the problem is fixed. The batches are
+// applied by the caller (see SetDDLBatches), since that needs access to
+// Spanner.
+
+// ddlBatches records the application of the schema's DDL batches.
+type ddlBatches struct {
+	batches []ddlBatch
+	from    int // First batch (numbered from 1) applied by this run; earlier ones were applied by a previous run.
+}
+
+type ddlBatch struct {
+	stmts    []string
+	done     bool   // Whether the batch was applied (up to the failed statement, if any).
+	previous int    // Leading statements already applied by a previous run (for the first resumed batch).
+	applied  int    // Statements applied by this run.
+	err      string // Error of the statement that failed, if any.
+}
+
+// DDL batch statuses.
+const (
+	ddlApplied     = "applied"
+	ddlPreviousRun = "previousRun"
+	ddlFailed      = "failed"
+	ddlNotApplied  = "notApplied"
+)
+
+// ddlStatusText is the text of DDL batch statuses in the text report.
+var ddlStatusText = map[string]string{
+	ddlApplied:     "applied",
+	ddlPreviousRun: "applied by a previous run",
+	ddlFailed:      "FAILED",
+	ddlNotApplied:  "not applied",
+}
+
+// SetDDLBatches records that the DDL statements that create the
+// Spanner schema are applied in batches, starting with batch from
+// (numbered from 1): earlier batches were applied by a previous run.
+// The result of each batch is recorded with SetDDLBatchResult.
+func (conv *Conv) SetDDLBatches(batches [][]string, from int) {
+	b := &ddlBatches{from: from}
+	for _, stmts := range batches {
+		b.batches = append(b.batches, ddlBatch{stmts: stmts})
+	}
+	conv.ddlBatches = b
+}
+
+// SetDDLBatchResult records the application of batch k (numbered from
+// 1): the first previous statements had already been applied by a
+// previous run, and were skipped; the next applied statements were
+// applied; and if err is non-nil, the statement after them failed.
+func (conv *Conv) SetDDLBatchResult(k, previous, applied int, err error) {
+	b := &conv.ddlBatches.batches[k-1]
+	b.done = true
+	b.previous = previous
+	b.applied = applied
+	if err != nil {
+		b.err = err.Error()
+	}
+}
+
+// failed returns the number of the batch that failed, or 0 if none did.
+func (b *ddlBatches) failed() int {
+	for i, x := range b.batches {
+		if x.err != "" {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// status returns the status of batch k.
+func (b *ddlBatches) status(k int) string {
+	x := b.batches[k-1]
+	switch {
+	case k < b.from:
+		return ddlPreviousRun
+	case x.err != "":
+		return ddlFailed
+	case x.done:
+		return ddlApplied
+	}
+	return ddlNotApplied
+}
+
+// first returns the number of the first statement of batch k, with
+// statements numbered from 1.
+func (b *ddlBatches) first(k int) int {
+	n := 1
+	for _, x := range b.batches[:k-1] {
+		n += len(x.stmts)
+	}
+	return n
+}
+
+// describe returns whether the report should describe the batches:
+// schemas applied in one batch, by one run, are unremarkable.
+func (b *ddlBatches) describe() bool {
+	return b != nil && (len(b.batches) > 1 || b.from > 1 || b.failed() > 0)
+}
+
+// DDLBatchFailed returns the number of the DDL batch (numbered from 1)
+// that failed to apply, or 0 if none did (see SetDDLBatches).
+func (conv *Conv) DDLBatchFailed() int {
+	if conv.ddlBatches == nil {
+		return 0
+	}
+	return conv.ddlBatches.failed()
+}
+
+// ddlBatchSummary returns a note on a failed DDL batch for the report
+// summary, or "" if none failed.
+func ddlBatchSummary(conv *Conv) string {
+	b := conv.ddlBatches
+	if b == nil || b.failed() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("DDL application: batch %d of %d failed, so the Spanner schema is incomplete (see the DDL Application section)", b.failed(), len(b.batches))
+}
+
+// writeDDLBatches writes the batches of the DDL Application section.
+// Schema creation was resumed from the batch after the last one applied
+// by a previous run.
+func writeDDLBatches(batches []ReportDDLBatch, w *bufio.Writer) {
+	n, from := 0, 1
+	var failed *ReportDDLBatch
+	for i, x := range batches {
+		n += x.Last - x.First + 1
+		switch x.Status {
+		case ddlPreviousRun:
+			from = x.Batch + 1
+		case ddlFailed:
+			failed = &batches[i]
+		}
+	}
+	msg := fmt.Sprintf("The Spanner schema has %d DDL statements, applied in %d batches.", n, len(batches))
+	if from > 1 {
+		msg += fmt.Sprintf(" Schema creation was resumed from batch %d: earlier batches were applied by a previous run.", from)
+	}
+	justifyLines(w, msg, 80, 0)
+	w.WriteString("\n")
+	w.WriteString("  --------------------------------------\n")
+	fmt.Fprintf(w, "  %6s  %11s  %s\n", "batch", "statements", "status")
+	w.WriteString("  --------------------------------------\n")
+	for _, x := range batches {
+		status := ddlStatusText[x.Status]
+		if x.Previous > 0 {
+			status += fmt.Sprintf(" (%d of them by a previous run)", x.Previous)
+		}
+		stmts := fmt.Sprintf("%d", x.First)
+		if x.Last > x.First {
+			stmts = fmt.Sprintf("%d-%d", x.First, x.Last)
+		}
+		fmt.Fprintf(w, "  %6d  %11s  %s\n", x.Batch, stmts, status)
+	}
+	w.WriteString("\n")
+	if failed == nil {
+		return
+	}
+	k, first := failed.Batch, failed.First
+	// Applied counts the statements applied by previous runs too.
+	bad := first + failed.Applied
+	justifyLines(w, fmt.Sprintf("Batch %d failed at statement %d: statements before it were applied, "+
+		"and it and the statements after it were not. The statements of batch %d:", k, bad, k), 80, 0)
+	w.WriteString("\n")
+	for i, s := range failed.Statements {
+		status := "applied"
+		switch {
+		case first+i == bad:
+			status = "FAILED"
+		case first+i > bad:
+			status = "not applied"
+		}
+		l := fmt.Sprintf("%d) %s: %s\n", first+i, status, firstLine(s))
+		if first+i == bad {
+			l += fmt.Sprintf("Error: %s\n", failed.Error)
+		}
+		justifyLines(w, l, 80, 3)
+	}
+	w.WriteString("\n")
+	justifyLines(w, fmt.Sprintf("Once the problem is fixed, resume schema creation from batch %d "+
+		"with -ddl-resume-from=%d, which applies the rest of the schema to the database "+
+		"and then runs data conversion. Statements of batch %d that the database already "+
+		"has are skipped.", k, k, k), 80, 0)
+	w.WriteString("\n\n")
+}
+
+// firstLine returns the first line of DDL statement s, which identifies
+// it (e.g. "CREATE TABLE `Singers` (").
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport_DDLBatches(t *testing.T) {
+	conv, _ := runProcessPgDumpConv(MakeConv(), verifyDump)
+	batches := [][]string{{"CREATE TABLE a (\n  x INT64\n) PRIMARY KEY (x)", "CREATE TABLE b"}, {"CREATE TABLE c", "CREATE INDEX bad", "CREATE TABLE d"}, {"CREATE TABLE e"}}
+	conv.SetDDLBatches(batches, 1)
+	conv.SetDDLBatchResult(1, 0, 2, nil)
+	conv.SetDDLBatchResult(2, 0, 1, fmt.Errorf("index exists"))
+	assert.Equal(t, 2, conv.DDLBatchFailed())
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	summary := GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, summary, "DDL application: batch 2 of 3 failed, so the Spanner schema is incomplete (see the DDL Application section).\n")
+	assert.Contains(t, buf.String(), ""+
+		"  --------------------------------------\n"+
+		"   batch   statements  status\n"+
+		"  --------------------------------------\n"+
+		"       1          1-2  applied\n"+
+		"       2          3-5  FAILED\n"+
+		"       3            6  not applied\n")
+	assert.Contains(t, buf.String(), ""+
+		"3) applied: CREATE TABLE c\n"+
+		"4) FAILED: CREATE INDEX bad\n"+
+		"   Error: index exists\n"+
+		"5) not applied: CREATE TABLE d\n")
+	assert.Contains(t, normalizeSpace(buf.String()), "resume schema creation from batch 2 with -ddl-resume-from=2")
+
+	buf.Reset()
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, buf, nil))
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, []ReportDDLBatch{
+		{1, 1, 2, "applied", 2, "", 0, nil},
+		{2, 3, 5, "failed", 1, "index exists", 0, batches[1]},
+		{3, 6, 6, "notApplied", 0, "", 0, nil},
+	}, r.DDLBatches)
+
+	// A resumed run.
+	conv, _ = runProcessPgDumpConv(MakeConv(), verifyDump)
+	conv.SetDDLBatches(batches, 2)
+	conv.SetDDLBatchResult(2, 1, 2, nil)
+	conv.SetDDLBatchResult(3, 0, 1, nil)
+	buf.Reset()
+	w = bufio.NewWriter(buf)
+	summary = GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.NotContains(t, summary, "DDL application")
+	assert.Contains(t, buf.String(), ""+
+		"       1          1-2  applied by a previous run\n"+
+		"       2          3-5  applied (1 of them by a previous run)\n"+
+		"       3            6  applied\n")
+
+	// Schemas applied in one batch aren't described.
+	conv, _ = runProcessPgDumpConv(MakeConv(), verifyDump)
+	conv.SetDDLBatches(batches[:1], 1)
+	conv.SetDDLBatchResult(1, 0, 2, nil)
+	buf.Reset()
+	w = bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.NotContains(t, buf.String(), "DDL Application")
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// The schema can be validated before the Spanner database is created,
+// by applying it to a throwaway database: a mistake in the schema (e.g.
+// from a bad type map) would otherwise only be found partway through
+// creating the real database. As for DDL applied after data conversion
+// (see ddlapply.go), the statements are applied by the caller (see
+// AddValidatedDDL), and a statement that is rejected doesn't stop the
+// rest from being tried, so that the report lists all of them.
+
+// ddlValidation records the DDL statements applied to a throwaway
+// database to validate the schema.
+type ddlValidation struct {
+	where    string // e.g. "a temporary database in instance test-instance".
+	accepted int64
+	rejected []ddlFailure
+}
+
+// StartDDLValidation records that the schema is about to be validated
+// by applying it to a throwaway database, described by where (e.g.
+// "the Spanner emulator at localhost:9010"). Reports then include a DDL
+// validation section.
+func (conv *Conv) StartDDLValidation(where string) {
+	conv.ddlValidation = &ddlValidation{where: where}
+}
+
+// AddValidatedDDL records that DDL statement stmt was accepted by the
+// throwaway database, or rejected with err (if non-nil). It must be
+// called after StartDDLValidation.
+func (conv *Conv) AddValidatedDDL(stmt string, err error) {
+	v := conv.ddlValidation
+	if err != nil {
+		v.rejected = append(v.rejected, ddlFailure{stmt: stmt, err: err.Error()})
+		return
+	}
+	v.accepted++
+}
+
+// DDLRejected returns the number of DDL statements rejected when the
+// schema was validated.
+func (conv *Conv) DDLRejected() int {
+	if conv.ddlValidation == nil {
+		return 0
+	}
+	return len(conv.ddlValidation.rejected)
+}
+
+func makeReportDDLValidation(conv *Conv) *ReportDDLValidation {
+	v := conv.ddlValidation
+	if v == nil {
+		return nil
+	}
+	r := &ReportDDLValidation{Where: v.where, Accepted: v.accepted, Rejected: []ReportDDLFailure{}}
+	for _, f := range v.rejected {
+		r.Rejected = append(r.Rejected, ReportDDLFailure{f.stmt, f.err})
+	}
+	return r
+}
+
+// ddlValidationSummary returns a note on schema validation for the
+// report summary, or "" if the schema wasn't validated.
+func ddlValidationSummary(conv *Conv) string {
+	v := conv.ddlValidation
+	if v == nil {
+		return ""
+	}
+	total := v.accepted + int64(len(v.rejected))
+	if len(v.rejected) > 0 {
+		return fmt.Sprintf("DDL validation: Spanner rejected %d of %d statements, so no database was created (see the DDL Validation section)", len(v.rejected), total)
+	}
+	return fmt.Sprintf("DDL validation: Spanner accepted all statements (%d)", total)
+}
+
+func writeDDLValidation(v *ReportDDLValidation, w *bufio.Writer) {
+	writeHeading(w, "DDL Validation")
+	justifyLines(w, fmt.Sprintf("The schema was validated by applying it to %s "+
+		"before creating the Spanner database. Statements accepted: %d. "+
+		"Statements rejected: %d.", v.Where, v.Accepted, len(v.Rejected)), 80, 0)
+	w.WriteString("\n")
+	if len(v.Rejected) > 0 {
+		justifyLines(w, "Rejected statements, with Spanner's errors (statements "+
+			"that depend on a rejected statement, e.g. indexes of a rejected "+
+			"table, are also rejected):", 80, 0)
+		w.WriteString("\n")
+		for i, f := range v.Rejected {
+			justifyLines(w, fmt.Sprintf("%d) %s\nError: %s\n", i+1, f.Statement, f.Error), 80, 3)
+		}
+	}
+	w.WriteString("\n")
+}
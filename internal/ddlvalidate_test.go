@@ -0,0 +1,57 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport_DDLValidation(t *testing.T) {
+	conv, _ := runProcessPgDumpConv(MakeConv(), verifyDump)
+	assert.Equal(t, 0, conv.DDLRejected())
+	conv.StartDDLValidation("the Spanner emulator at localhost:9010")
+	conv.AddValidatedDDL("CREATE TABLE t (\n    a INT64\n) PRIMARY KEY (a)", nil)
+	conv.AddValidatedDDL("CREATE INDEX b_idx ON t (b)", fmt.Errorf("column not found: b"))
+	assert.Equal(t, 1, conv.DDLRejected())
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	summary := GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, summary, "DDL validation: Spanner rejected 1 of 2 statements, so no database was created (see the DDL Validation section).\n")
+	assert.Contains(t, normalizeSpace(buf.String()), "applying it to the Spanner emulator at localhost:9010 before creating the Spanner database. Statements accepted: 1. Statements rejected: 1.")
+	assert.Contains(t, buf.String(), "1) CREATE INDEX b_idx ON t (b)\n   Error: column not found: b\n")
+
+	buf.Reset()
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, buf, nil))
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, &ReportDDLValidation{
+		Where:    "the Spanner emulator at localhost:9010",
+		Accepted: 1,
+		Rejected: []ReportDDLFailure{{"CREATE INDEX b_idx ON t (b)", "column not found: b"}},
+	}, r.DDLValidation)
+
+	conv, _ = runProcessPgDumpConv(MakeConv(), verifyDump)
+	assert.Nil(t, BuildReport(PgDumpSource, conv, nil).DDLValidation)
+	conv.StartDDLValidation("a temporary database in instance i")
+	conv.AddValidatedDDL("CREATE INDEX b_idx ON t (b)", nil)
+	assert.Contains(t, generateSummary(conv, analyzeTables(conv, nil), nil), "DDL validation: Spanner accepted all statements (1).\n")
+}
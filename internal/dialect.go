@@ -0,0 +1,93 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// Spanner databases use either the GoogleSQL dialect (the default) or
+// the PostgreSQL dialect. The dialect determines the syntax of the DDL
+// we print (see ddl.Config), and also some type mappings: PostgreSQL
+// numeric holds all values of PostgreSQL numeric types, so they map
+// cleanly, rather than needing warnings about the limits of GoogleSQL
+// NUMERIC.
+
+// pgIssues gives the descriptions and severities of schema issues that
+// differ for the PostgreSQL dialect.
+var pgIssues = map[schemaIssue]struct {
+	brief    string
+	severity severity
+}{
+	numeric:         {brief: "Spanner PostgreSQL numeric has up to 131072 digits before and 16383 digits after the decimal point, which holds all values of this type", severity: note},
+	numericThatFits: {brief: "Spanner PostgreSQL numeric has up to 131072 digits before and 16383 digits after the decimal point, which holds all values of this type", severity: note},
+}
+
+// ParseDialect parses the name of a Spanner database dialect e.g.
+// "postgresql". The empty string means the default (GoogleSQL).
+func ParseDialect(s string) (ddl.Dialect, error) {
+	switch x := ddl.Dialect(s); x {
+	case "":
+		return ddl.GoogleStandardSQL, nil
+	case ddl.GoogleStandardSQL, ddl.PostgreSQL:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown dialect %q: expected google_standard_sql or postgresql", s)
+}
+
+// SetDialect sets the dialect of the Spanner database that schema
+// conversion targets. It must be called before schema conversion.
+func (conv *Conv) SetDialect(d ddl.Dialect) {
+	conv.dialect = d
+}
+
+// Dialect returns the dialect of the Spanner database that schema
+// conversion targets, for printing DDL (see ddl.Config).
+func (conv *Conv) Dialect() ddl.Dialect {
+	if conv.dialect == "" {
+		return ddl.GoogleStandardSQL
+	}
+	return conv.dialect
+}
+
+// pg returns whether conversion targets a PostgreSQL-dialect database.
+func (conv *Conv) pg() bool {
+	return conv.dialect == ddl.PostgreSQL
+}
+
+// printType returns the Spanner type of cd as printed in the target
+// dialect e.g. STRING(50) or varchar(50).
+func (conv *Conv) printType(cd ddl.ColumnDef) string {
+	return cd.PrintColumnType(ddl.Config{Dialect: conv.Dialect()})
+}
+
+// issueBrief returns the description of issue i for the target dialect.
+func (conv *Conv) issueBrief(i schemaIssue) string {
+	if x, ok := pgIssues[i]; ok && conv.pg() {
+		return x.brief
+	}
+	return issueDB[i].brief
+}
+
+// dialectMsg describes the dialect of the Spanner database for the
+// report, or returns "" for the default dialect.
+func dialectMsg(d string) string {
+	if d != string(ddl.PostgreSQL) {
+		return ""
+	}
+	return "Spanner dialect: PostgreSQL (DDL and type names are for a PostgreSQL-dialect database)"
+}
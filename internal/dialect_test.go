@@ -0,0 +1,89 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDialect(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected ddl.Dialect
+		err      bool
+	}{
+		{"", ddl.GoogleStandardSQL, false},
+		{"google_standard_sql", ddl.GoogleStandardSQL, false},
+		{"postgresql", ddl.PostgreSQL, false},
+		{"postgres", "", true},
+		{"PostgreSQL", "", true},
+	} {
+		d, err := ParseDialect(tc.s)
+		if tc.err {
+			assert.NotNil(t, err, tc.s)
+			continue
+		}
+		assert.Nil(t, err, tc.s)
+		assert.Equal(t, tc.expected, d, tc.s)
+	}
+	assert.Equal(t, ddl.GoogleStandardSQL, MakeConv().Dialect())
+}
+
+func TestDialect_PostgreSQL(t *testing.T) {
+	conv := MakeConv()
+	conv.SetSchemaMode()
+	conv.SetDialect(ddl.PostgreSQL)
+	conv.srcSchema["t"] = schema.Table{
+		Name:     "t",
+		ColNames: []string{"id", "n", "s"},
+		ColDefs: map[string]schema.Column{
+			"id": {Name: "id", Type: schema.Type{Name: "int8"}},
+			"n":  {Name: "n", Type: schema.Type{Name: "numeric"}},
+			"s":  {Name: "s", Type: schema.Type{Name: "varchar", Mods: []int64{20}}},
+		},
+		PrimaryKeys: []schema.Key{{Column: "id"}},
+	}
+	assert.Nil(t, schemaToDDL(conv))
+	assert.Equal(t, map[string][]schemaIssue{"n": {numericThatFits}}, conv.issues["t"])
+	assert.Equal(t, note, conv.severity(numericThatFits))
+	assert.Equal(t, note, conv.severity(numeric))
+	assert.Contains(t, conv.issueBrief(numeric), "PostgreSQL numeric")
+
+	ddlStmts := conv.GetDDL(ddl.Config{Dialect: conv.Dialect()})
+	assert.Equal(t, []string{"CREATE TABLE t (\n    id bigint,\n    n numeric,\n    s varchar(20),\n    PRIMARY KEY (id)\n)"}, ddlStmts)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), "Spanner dialect: PostgreSQL")
+	assert.Equal(t, "postgresql", BuildReport(PgDumpSource, conv, nil).Dialect)
+
+	// The default dialect isn't mentioned in the text report.
+	conv = MakeConv()
+	buf.Reset()
+	w = bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.NotContains(t, buf.String(), "Spanner dialect")
+	assert.Equal(t, "google_standard_sql", BuildReport(PgDumpSource, conv, nil).Dialect)
+	assert.Equal(t, warning, conv.severity(numeric))
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	nodes "github.com/lfittl/pg_query_go/nodes"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+)
+
+// Spanner has no domains, so columns declared with a PostgreSQL domain
+// are converted as if they had been declared with the domain's base
+// type and constraints (e.g. NOT NULL).
+
+// domainDef is a domain defined by CREATE DOMAIN.
+type domainDef struct {
+	base        schema.Type
+	constraints []constraint // Column constraints (without cols).
+}
+
+// processCreateDomainStmt records the base type and constraints of
+// the domain defined by n. Domains over other domains are resolved to
+// the underlying base type.
+func processCreateDomainStmt(conv *Conv, n nodes.CreateDomainStmt) {
+	name := qualifiedName(n.Domainname)
+	if name == "" || n.TypeName == nil {
+		logStmtError(conv, n, fmt.Errorf("domain name or type is empty"))
+		return
+	}
+	tid, err := getTypeID(n.TypeName.Names.Items)
+	if err != nil {
+		logStmtError(conv, n, fmt.Errorf("can't get type id for domain %s: %w", name, err))
+		return
+	}
+	d := domainDef{
+		base: schema.Type{
+			Name:        tid,
+			Mods:        getTypeMods(conv, n.TypeName.Typmods),
+			ArrayBounds: getArrayBounds(conv, n.TypeName.ArrayBounds)},
+		constraints: extractConstraints(conv, n, name, n.Constraints.Items),
+	}
+	if b, ok := conv.domain(tid); ok {
+		d.base = resolveDomain(b, d.base)
+		d.constraints = append(append([]constraint{}, b.constraints...), d.constraints...)
+	}
+	conv.domains[name] = d
+	conv.schemaStatement([]nodes.Node{n})
+}
+
+// domain returns the domain named id (as returned by getTypeID), and
+// whether id is a known domain.
+func (conv *Conv) domain(id string) (domainDef, bool) {
+	d, ok := conv.domains[strings.TrimPrefix(id, "public.")]
+	return d, ok
+}
+
+// resolveDomain returns the type of a column declared as ty, where ty
+// names domain d. Array bounds of the column are added to any array
+// bounds of the domain's base type.
+func resolveDomain(d domainDef, ty schema.Type) schema.Type {
+	var bounds []int64
+	bounds = append(append(bounds, d.base.ArrayBounds...), ty.ArrayBounds...)
+	return schema.Type{Name: d.base.Name, Mods: d.base.Mods, ArrayBounds: bounds}
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	sp "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestProcessPgDump_Domains(t *testing.T) {
+	conv, rows := runProcessPgDump(
+		"CREATE DOMAIN public.email_t AS character varying(255) NOT NULL CONSTRAINT email_check CHECK (VALUE ~ '@');\n" +
+			"CREATE DOMAIN work_email_t AS public.email_t DEFAULT 'x@example.com';\n" +
+			"CREATE DOMAIN ints AS integer[];\n" +
+			"CREATE TYPE mood AS ENUM ('sad', 'happy');\n" +
+			"CREATE DOMAIN mood_t AS mood;\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, e public.email_t, w work_email_t, i ints, m mood_t, a email_t[]);\n" +
+			"COPY public.t (id, e, w, i, m, a) FROM stdin;\n" +
+			"1\ta@b\tc@d\t{1,2}\tsad\t{e@f}\n" +
+			"\\.\n")
+	noIssues(conv, t, "domains")
+	varchar := schema.Type{Name: "varchar", Mods: []int64{255}}
+	assert.Equal(t, map[string]schema.Column{
+		"id": {Name: "id", Type: schema.Type{Name: "int8"}, NotNull: true},
+		"e":  {Name: "e", Type: varchar, NotNull: true, Ignored: schema.Ignored{Check: true}, Domain: "public.email_t"},
+		"w":  {Name: "w", Type: varchar, NotNull: true, Ignored: schema.Ignored{Check: true, Default: true}, Domain: "work_email_t"},
+		"i":  {Name: "i", Type: schema.Type{Name: "int4", ArrayBounds: []int64{-1}}, Domain: "ints"},
+		"m":  {Name: "m", Type: schema.Type{Name: "mood"}, Domain: "mood_t"},
+		"a":  {Name: "a", Type: schema.Type{Name: "varchar", Mods: []int64{255}, ArrayBounds: []int64{-1}}, NotNull: true, Ignored: schema.Ignored{Check: true}, Domain: "email_t"},
+	}, conv.srcSchema["t"].ColDefs)
+	ct := stripSchemaComments(conv.spSchema)["t"]
+	assert.Equal(t, map[string]ddl.ColumnDef{
+		"id": {Name: "id", T: ddl.Int64{}, NotNull: true},
+		"e":  {Name: "e", T: ddl.String{Len: ddl.Int64Length{Value: 255}}, NotNull: true},
+		"w":  {Name: "w", T: ddl.String{Len: ddl.Int64Length{Value: 255}}, NotNull: true},
+		"i":  {Name: "i", T: ddl.Int64{}, IsArray: true},
+		"m":  {Name: "m", T: ddl.String{Len: ddl.Int64Length{Value: 5}}},
+		"a":  {Name: "a", T: ddl.String{Len: ddl.Int64Length{Value: 255}}, IsArray: true, NotNull: true},
+	}, ct.ColDefs)
+	assert.Equal(t, []schemaIssue{domain}, conv.issues["t"]["e"])
+	assert.Equal(t, []schemaIssue{defaultValue, domain}, conv.issues["t"]["w"])
+	assert.Equal(t, []schemaIssue{widened, domain}, conv.issues["t"]["i"])
+	assert.Equal(t, []schemaIssue{enum, domain}, conv.issues["t"]["m"])
+	assert.Equal(t, []spannerData{{table: "t", cols: []string{"id", "e", "w", "i", "m", "a"},
+		vals: []interface{}{int64(1), "a@b", "c@d", []sp.NullInt64{{Int64: 1, Valid: true}, {Int64: 2, Valid: true}}, "sad",
+			[]sp.NullString{{StringVal: "e@f", Valid: true}}}}}, rows)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	// Batched: only the first column is given.
+	assert.Contains(t, normalizeSpace(buf.String()), "Some columns use domains e.g. column 'a' uses domain 'email_t' resolved to varchar(255)[]. Spanner has no domains")
+	assert.NotContains(t, buf.String(), "column 'e' uses domain")
+}
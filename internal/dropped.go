@@ -0,0 +1,288 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+
+	nodes "github.com/lfittl/pg_query_go/nodes"
+)
+
+// maxDroppedSQL is the maximum length of the definition of a dropped
+// object kept for the report.
+const maxDroppedSQL = 300
+
+// droppedObject is a source DB object (e.g. a view) that was dropped
+// because it has no Spanner equivalent, or couldn't be converted.
+type droppedObject struct {
+	kind   string   // e.g. "view".
+	name   string   // Source DB name.
+	tables []string // Source tables the object is on or uses (empty if unknown).
+	sql    string   // Definition, truncated to maxDroppedSQL (empty if unknown).
+	reason string   // Why the object was dropped, if it isn't obvious from kind.
+	// Source comment on the object, if any (filled in by droppedGroups).
+	comment string
+}
+
+// Kinds of dropped objects, in the order they are reported.
+var droppedKinds = []struct{ kind, heading string }{
+	{"sequence", "Sequences"},
+	{"trigger", "Triggers"},
+	{"view", "Views"},
+	{"function", "Functions"},
+	{"procedure", "Procedures"},
+	{"index", "Indexes"},
+}
+
+// addDroppedObject records a dropped object with definition sql.
+// Dropped objects are only recorded in schema mode.
+func (conv *Conv) addDroppedObject(kind, name string, tables []string, sql, reason string) {
+	if !conv.schemaMode() {
+		return
+	}
+	conv.dropped = append(conv.dropped, droppedObject{kind: kind, name: name, tables: tables, sql: shortenSQL(sql), reason: reason})
+}
+
+// shortenSQL returns s with comments removed and whitespace collapsed,
+// truncated to maxDroppedSQL bytes.
+func shortenSQL(s string) string {
+	return shortenText(s, maxDroppedSQL)
+}
+
+// shortenText returns s with line comments removed and whitespace
+// collapsed, truncated to n bytes.
+func shortenText(s string, n int) string {
+	var l []string
+	for _, line := range strings.Split(s, "\n") {
+		if t := strings.TrimSpace(line); t != "" && !strings.HasPrefix(t, "--") {
+			l = append(l, strings.Fields(t)...)
+		}
+	}
+	s = strings.Join(l, " ")
+	if len(s) > n {
+		// Don't split a multi-byte character.
+		for n > 0 && (s[n]&0xC0) == 0x80 {
+			n--
+		}
+		s = s[:n] + "..."
+	}
+	return s
+}
+
+// stmtText returns the text of statement raw, which was parsed from s.
+func stmtText(s string, raw nodes.RawStmt) string {
+	start, end := raw.StmtLocation, len(s)
+	if raw.StmtLen > 0 {
+		end = start + raw.StmtLen
+	}
+	if start < 0 || start > end || end > len(s) {
+		return ""
+	}
+	return s[start:end]
+}
+
+// qualifiedName returns the name given by the list of strings l,
+// dropping the "public" schema (see getTableName).
+func qualifiedName(l nodes.List) string {
+	var parts []string
+	for _, n := range l.Items {
+		if s, err := getString(n); err == nil && !(s == "public" && len(parts) == 0) {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// selectTables returns the tables in the FROM clause of a SELECT
+// statement (including joins and set operations). It doesn't look
+// inside subqueries.
+func selectTables(conv *Conv, n nodes.Node) []string {
+	var tables []string
+	var visit func(n nodes.Node)
+	visit = func(n nodes.Node) {
+		switch x := n.(type) {
+		case nodes.SelectStmt:
+			for _, f := range x.FromClause.Items {
+				visit(f)
+			}
+			if x.Larg != nil {
+				visit(*x.Larg)
+			}
+			if x.Rarg != nil {
+				visit(*x.Rarg)
+			}
+		case nodes.JoinExpr:
+			visit(x.Larg)
+			visit(x.Rarg)
+		case nodes.RangeVar:
+			if t, err := getTableName(conv, x); err == nil {
+				tables = append(tables, t)
+			}
+		}
+	}
+	visit(n)
+	return dedupe(tables)
+}
+
+func dedupe(l []string) []string {
+	seen := make(map[string]bool)
+	var r []string
+	for _, s := range l {
+		if !seen[s] {
+			seen[s] = true
+			r = append(r, s)
+		}
+	}
+	return r
+}
+
+// setSequenceTable records that sequence seq is owned by table (from
+// ALTER SEQUENCE ... OWNED BY).
+func (conv *Conv) setSequenceTable(seq, table string) {
+	for i, d := range conv.dropped {
+		if d.kind == "sequence" && d.name == seq {
+			conv.dropped[i].tables = []string{table}
+		}
+	}
+}
+
+// processDroppedStmt records statement n (with text sql) as a dropped
+// object, if it creates an object that has no Spanner equivalent.
+func processDroppedStmt(conv *Conv, n nodes.Node, sql string) {
+	switch s := n.(type) {
+	case nodes.CreateSeqStmt:
+		if s.Sequence != nil {
+			if name, err := getTableName(conv, *s.Sequence); err == nil {
+				conv.addDroppedObject("sequence", name, nil, sql, "")
+			}
+		}
+	case nodes.AlterSeqStmt:
+		if s.Sequence == nil {
+			return
+		}
+		seq, err := getTableName(conv, *s.Sequence)
+		if err != nil {
+			return
+		}
+		for _, o := range s.Options.Items {
+			if d, ok := o.(nodes.DefElem); ok && d.Defname != nil && *d.Defname == "owned_by" {
+				if l, ok := d.Arg.(nodes.List); ok && len(l.Items) >= 2 {
+					// The last item is the column.
+					conv.setSequenceTable(seq, qualifiedName(nodes.List{Items: l.Items[:len(l.Items)-1]}))
+				}
+			}
+		}
+	case nodes.CreateTrigStmt:
+		var name string
+		if s.Trigname != nil {
+			name = *s.Trigname
+		}
+		var tables []string
+		if s.Relation != nil {
+			if t, err := getTableName(conv, *s.Relation); err == nil {
+				tables = []string{t}
+			}
+		}
+		conv.addDroppedObject("trigger", name, tables, sql, "")
+	case nodes.ViewStmt:
+		if s.View != nil {
+			if name, err := getTableName(conv, *s.View); err == nil {
+				conv.addDroppedObject("view", name, selectTables(conv, s.Query), sql, "")
+			}
+		}
+	case nodes.CreateFunctionStmt:
+		conv.addDroppedObject("function", qualifiedName(s.Funcname), nil, sql, "")
+	}
+}
+
+// writeDroppedObjects writes the "Dropped Objects" section of the
+// report, for objects l in report order (see droppedGroups).
+func writeDroppedObjects(l []ReportDroppedObject, w *bufio.Writer) {
+	writeHeading(w, "Dropped Objects")
+	justifyLines(w, "The following source DB objects have no Spanner "+
+		"equivalent (or couldn't be converted), and were dropped.", 80, 0)
+	w.WriteString("\n\n")
+	for _, k := range droppedKinds {
+		var objects []droppedObject
+		for _, d := range l {
+			if d.Kind == k.kind {
+				objects = append(objects, droppedObject{d.Kind, d.Name, d.Tables, d.SQL, d.Reason, d.Comment})
+			}
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s\n", k.heading)
+		for i, d := range objects {
+			s := fmt.Sprintf("%d) %s.\n", i+1, describeDropped(d))
+			if d.comment != "" {
+				s += "Comment: " + d.comment + "\n"
+			}
+			if d.sql != "" {
+				s += d.sql + "\n"
+			}
+			justifyLines(w, s, 80, 3)
+		}
+		w.WriteString("\n")
+	}
+}
+
+type droppedGroup struct {
+	heading string // e.g. "Views".
+	objects []droppedObject
+}
+
+// droppedGroups returns the dropped objects grouped by kind, in report
+// order. Objects of each kind are sorted by name.
+func droppedGroups(conv *Conv) []droppedGroup {
+	var groups []droppedGroup
+	for _, k := range droppedKinds {
+		var l []droppedObject
+		for _, d := range conv.dropped {
+			if d.kind == k.kind {
+				d.comment = conv.objectComment(d.kind, d.name)
+				l = append(l, d)
+			}
+		}
+		if len(l) == 0 {
+			continue
+		}
+		sort.SliceStable(l, func(i, j int) bool { return l[i].name < l[j].name })
+		groups = append(groups, droppedGroup{k.heading, l})
+	}
+	return groups
+}
+
+// describeDropped returns a one-line description of dropped object d.
+func describeDropped(d droppedObject) string {
+	s := d.name
+	if s == "" {
+		s = "(unnamed)"
+	}
+	switch len(d.tables) {
+	case 0:
+	case 1:
+		s += fmt.Sprintf(" (table %s)", d.tables[0])
+	default:
+		s += fmt.Sprintf(" (tables %s)", strings.Join(d.tables, ", "))
+	}
+	if d.reason != "" {
+		s += ": " + d.reason
+	}
+	return s
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDroppedObjects(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE t (id bigint PRIMARY KEY, a text);\n" +
+			"CREATE TABLE u (id bigint PRIMARY KEY);\n" +
+			"--\n-- Name: t_id_seq; Type: SEQUENCE\n--\n" +
+			"CREATE SEQUENCE public.t_id_seq\n    START WITH 1\n    INCREMENT BY 1;\n" +
+			"ALTER SEQUENCE public.t_id_seq OWNED BY public.t.id;\n" +
+			"CREATE FUNCTION public.touch() RETURNS trigger LANGUAGE plpgsql AS $$ BEGIN RETURN NEW; END; $$;\n" +
+			"CREATE TRIGGER t_touch BEFORE UPDATE ON public.t FOR EACH ROW EXECUTE PROCEDURE public.touch();\n" +
+			"CREATE VIEW tu AS SELECT t.a FROM t JOIN u ON t.id = u.id;\n" +
+			"CREATE INDEX mv_idx ON mv (a);\n" +
+			"CREATE INDEX t_idx ON t (a) WHERE a IS NOT NULL;\n")
+	var l []string
+	for _, g := range droppedGroups(conv) {
+		for _, d := range g.objects {
+			l = append(l, g.heading+": "+describeDropped(d)+": "+d.sql)
+		}
+	}
+	assert.Equal(t, []string{
+		"Sequences: t_id_seq (table t): CREATE SEQUENCE public.t_id_seq START WITH 1 INCREMENT BY 1",
+		"Triggers: t_touch (table t): CREATE TRIGGER t_touch BEFORE UPDATE ON public.t FOR EACH ROW EXECUTE PROCEDURE public.touch()",
+		"Views: tu (tables t, u): CREATE VIEW tu AS SELECT t.a FROM t JOIN u ON t.id = u.id",
+		"Functions: touch: CREATE FUNCTION public.touch() RETURNS trigger LANGUAGE plpgsql AS $$ BEGIN RETURN NEW; END; $$",
+		"Indexes: mv_idx (table mv): its table wasn't found (it may be a materialized view): CREATE INDEX mv_idx ON mv (a)",
+		"Indexes: t_idx (table t): it is a partial index (it has a WHERE clause), which Spanner doesn't support: CREATE INDEX t_idx ON t (a) WHERE a IS NOT NULL",
+	}, l)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), "Dropped Objects\n")
+	assert.Contains(t, buf.String(), "Triggers\n1) t_touch (table t).\n   CREATE TRIGGER t_touch BEFORE UPDATE ON public.t FOR EACH ROW EXECUTE\n   PROCEDURE public.touch()\n")
+}
+
+func TestShortenSQL(t *testing.T) {
+	assert.Equal(t, "CREATE VIEW v AS SELECT 1", shortenSQL("\n-- comment\nCREATE VIEW v AS\n  SELECT   1\n"))
+	long := "CREATE VIEW v AS SELECT '" + strings.Repeat("é", 200) + "'"
+	s := shortenSQL(long)
+	assert.True(t, strings.HasSuffix(s, "..."))
+	assert.True(t, len(s) <= maxDroppedSQL+3)
+	assert.Equal(t, strings.ToValidUTF8(s, "?"), s)
+}
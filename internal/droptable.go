@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dumps can define a table more than once: pg_dump --clean and
+// mysqldump write DROP TABLE IF EXISTS before each CREATE TABLE, and
+// concatenated dumps repeat definitions. DROP TABLE discards a table's
+// definition, so that a later CREATE TABLE defines it afresh. A CREATE
+// TABLE for a table that is already defined is skipped, keeping the
+// first definition.
+
+// tableDefStats counts DROP TABLE and duplicate CREATE TABLE statements.
+type tableDefStats struct {
+	dropped    int64 // Tables whose definitions were discarded by DROP TABLE.
+	duplicates int64 // CREATE TABLE statements skipped because the table was already defined.
+}
+
+// dropTable discards the definition of table, along with everything
+// recorded for it during schema conversion. It returns false if table
+// isn't defined.
+func (conv *Conv) dropTable(table string) bool {
+	_, defined := conv.srcSchema[table]
+	_, partition := conv.partitions.parent[table]
+	if !defined && !partition {
+		return false
+	}
+	if conv.stats.rows[table] > 0 {
+		conv.unexpected(fmt.Sprintf("DROP TABLE drops table %s after its data: the data is converted to the table's next definition", table))
+	}
+	delete(conv.srcSchema, table)
+	delete(conv.issues, table)
+	delete(conv.groupIssues, table)
+	delete(conv.indexSQL, table)
+	delete(conv.partitions.parent, table)
+	delete(conv.partitions.keys, table)
+	delete(conv.inheritance.parents, table)
+	delete(conv.inheritance.columns, table)
+	conv.tableDefs.dropped++
+	return true
+}
+
+// duplicateTable returns whether table is already defined, recording
+// the duplicate definition if so. A table defined by another of several
+// dump files isn't unexpected (see dumpfiles.go).
+func (conv *Conv) duplicateTable(table string) bool {
+	_, defined := conv.srcSchema[table]
+	_, partition := conv.partitions.parent[table]
+	if !defined && !partition {
+		conv.defineTable(table)
+		return false
+	}
+	if !conv.definedByOtherFile(table) {
+		conv.unexpected(fmt.Sprintf("Table %s is defined again without DROP TABLE: using the first definition", table))
+	}
+	conv.tableDefs.duplicates++
+	return true
+}
+
+// tableDefsMsg explains the statement stats of DROP TABLE and duplicate
+// CREATE TABLE statements, given the table definitions dropped and the
+// duplicate definitions skipped, or returns "" if there were none.
+func tableDefsMsg(dropped, duplicates int64) string {
+	var l []string
+	if n := dropped; n > 0 {
+		l = append(l, fmt.Sprintf("Table definitions discarded by DROP TABLE: %d.", n))
+	}
+	if n := duplicates; n > 0 {
+		l = append(l, fmt.Sprintf("CREATE TABLE statements skipped because the table was already defined (the first definition is used): %d.", n))
+	}
+	if len(l) == 0 {
+		return ""
+	}
+	return strings.Join(l, "\n")
+}
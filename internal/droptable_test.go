@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestProcessPgDump_DropTable(t *testing.T) {
+	conv, rows := runProcessPgDump(
+		"DROP TABLE IF EXISTS public.t;\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, old text);\n" +
+			"CREATE INDEX t_old ON t (old);\n" +
+			"DROP TABLE t, public.u;\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, name text);\n" +
+			"CREATE TABLE t (id text PRIMARY KEY);\n" +
+			"DROP VIEW v;\n" +
+			"COPY public.t (id, name) FROM stdin;\n1\ta\n\\.\n")
+	assert.Equal(t, map[string]ddl.ColumnDef{
+		"id":   {Name: "id", T: ddl.Int64{}, NotNull: true},
+		"name": {Name: "name", T: ddl.String{Len: ddl.MaxLength{}}},
+	}, stripSchemaComments(conv.spSchema)["t"].ColDefs)
+	assert.Empty(t, conv.spSchema["t"].Indexes)
+	assert.Empty(t, conv.indexSQL["t"])
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"id", "name"}, vals: []interface{}{int64(1), "a"}},
+	}, rows)
+	assert.Equal(t, statementStat{schema: 1, skip: 2}, *conv.stats.statement["DropStmt"])
+	assert.Equal(t, statementStat{schema: 2, skip: 1}, *conv.stats.statement["CreateStmt"])
+	assert.Equal(t, tableDefStats{dropped: 1, duplicates: 1}, conv.tableDefs)
+	assert.Equal(t, map[string]int64{"Table t is defined again without DROP TABLE: using the first definition": 1}, unexpectedCounts(conv))
+	b := new(bytes.Buffer)
+	w := bufio.NewWriter(b)
+	writeStmtStats(PgDumpSource, BuildReport(PgDumpSource, conv, nil), w)
+	w.Flush()
+	assert.Contains(t, b.String(), "Table definitions discarded by DROP TABLE: 1.\n"+
+		"CREATE TABLE statements skipped because the table was already defined (the first definition is used): 1.\n")
+}
+
+func TestProcessPgDump_DropTableAfterData(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE t (id bigint PRIMARY KEY);\n" +
+			"COPY public.t (id) FROM stdin;\n1\n\\.\n" +
+			"DROP TABLE t;\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, n bigint);\n")
+	assert.Equal(t, []string{"id", "n"}, conv.spSchema["t"].ColNames)
+	assert.Equal(t, map[string]int64{"DROP TABLE drops table t after its data: the data is converted to the table's next definition": 1}, unexpectedCounts(conv))
+}
+
+func TestProcessMySQLDump_DropTable(t *testing.T) {
+	const table = "DROP TABLE IF EXISTS `t`;\n" +
+		"CREATE TABLE `t` (`id` bigint NOT NULL, `name` varchar(10), PRIMARY KEY (`id`), KEY `t_name` (`name`));\n"
+	conv, rows := runProcessMySQLDump(table +
+		"INSERT INTO `t` VALUES (1,'a');\n" +
+		table +
+		"CREATE TABLE `t` (`id` varchar(10) NOT NULL, PRIMARY KEY (`id`));\n")
+	assert.Equal(t, map[string]ddl.ColumnDef{
+		"id":   {Name: "id", T: ddl.Int64{}, NotNull: true},
+		"name": {Name: "name", T: ddl.String{Len: ddl.Int64Length{Value: 10}}},
+	}, stripSchemaComments(conv.spSchema)["t"].ColDefs)
+	assert.Equal(t, 1, len(conv.spSchema["t"].Indexes))
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, statementStat{schema: 1, skip: 1}, *conv.stats.statement["DROP TABLE"])
+	assert.Equal(t, statementStat{schema: 2, skip: 1}, *conv.stats.statement["CREATE TABLE"])
+	assert.Equal(t, tableDefStats{dropped: 1, duplicates: 1}, conv.tableDefs)
+	assert.Equal(t, map[string]int64{
+		"DROP TABLE drops table t after its data: the data is converted to the table's next definition": 1,
+		"Table t is defined again without DROP TABLE: using the first definition":                       1,
+	}, unexpectedCounts(conv))
+}
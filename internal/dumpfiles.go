@@ -0,0 +1,206 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Several dump files, such as those written by pg_dump for each schema
+// or table, can be converted together into one Spanner database. The
+// caller calls StartDumpFile before processing each file, in each pass,
+// and FinishDumpFiles after the schema pass over all of them: the
+// Spanner schema is built once, from the tables of all the files. A
+// table defined by more than one file must have the same CREATE TABLE
+// statement in each (see DumpFileConflicts); the first definition is
+// used, as for tables defined again within a dump (see droptable.go).
+
+// dumpFiles records the dump files converted together.
+type dumpFiles struct {
+	files     []dumpFile
+	current   int                 // Index in files of the file being processed.
+	defs      map[string]tableDef // Definitions of tables, keyed by source table.
+	conflicts []string            // Tables defined differently by two files.
+}
+
+// dumpFile is a dump file converted along with others.
+type dumpFile struct {
+	name  string
+	start statementStat // Statement stats before the file was processed (see statementTotals).
+}
+
+// tableDef is the CREATE TABLE statement that defined a table.
+type tableDef struct {
+	file string
+	stmt string // Statement text, without comments and with whitespace normalized (see definitionText).
+}
+
+// StartDumpFile records that the dump file name, one of several
+// converted together, is about to be processed. Files must be
+// processed in the same order in each pass.
+func (conv *Conv) StartDumpFile(name string) {
+	d := &conv.dumpFiles
+	for i, f := range d.files {
+		if f.name == name {
+			d.current = i
+			return
+		}
+	}
+	d.files = append(d.files, dumpFile{name: name, start: conv.statementTotals()})
+	d.current = len(d.files) - 1
+}
+
+// FinishDumpFiles completes the schema pass over several dump files,
+// building the Spanner schema from the tables of all the files.
+func (conv *Conv) FinishDumpFiles() {
+	if conv.schemaMode() {
+		conv.mergeInherited()
+		schemaToDDL(conv)
+		conv.AddPrimaryKeys()
+	}
+}
+
+// DumpFileConflicts returns descriptions of the tables defined
+// differently by two of the dump files converted together, in the order
+// found.
+func (conv *Conv) DumpFileConflicts() []string {
+	return conv.dumpFiles.conflicts
+}
+
+// severalDumpFiles returns whether the dump being processed is one of
+// several converted together.
+func (conv *Conv) severalDumpFiles() bool {
+	return len(conv.dumpFiles.files) > 0
+}
+
+// defineTable records the statement being processed as the definition
+// of table, unless another dump file has already defined it.
+func (conv *Conv) defineTable(table string) {
+	if !conv.severalDumpFiles() || conv.definedByOtherFile(table) {
+		return
+	}
+	d := &conv.dumpFiles
+	if d.defs == nil {
+		d.defs = make(map[string]tableDef)
+	}
+	d.defs[table] = tableDef{file: d.files[d.current].name, stmt: definitionText(conv.dumpContext.text)}
+}
+
+// definedByOtherFile returns whether table was defined by a dump file
+// other than the one being processed, recording a conflict if the
+// statement being processed defines it differently. Definitions are
+// kept across DROP TABLE, so that a file can't silently redefine a
+// table defined by another one.
+func (conv *Conv) definedByOtherFile(table string) bool {
+	d := &conv.dumpFiles
+	if !conv.severalDumpFiles() {
+		return false
+	}
+	def, ok := d.defs[table]
+	file := d.files[d.current].name
+	if !ok || def.file == file {
+		return false
+	}
+	if def.stmt != definitionText(conv.dumpContext.text) {
+		d.conflicts = append(d.conflicts, fmt.Sprintf("table %s is defined differently in %s and %s", table, def.file, file))
+	}
+	return true
+}
+
+// statementTotals returns the statement stats summed over all
+// statement types.
+func (conv *Conv) statementTotals() statementStat {
+	var t statementStat
+	for _, x := range conv.stats.statement {
+		t.schema += x.schema
+		t.data += x.data
+		t.skip += x.skip
+		t.error += x.error
+	}
+	return t
+}
+
+// dumpFileStats returns the statement stats of each of the dump files
+// converted together, in the order they were processed, or nil if there
+// was a single dump.
+func (conv *Conv) dumpFileStats() []ReportDumpFile {
+	var l []ReportDumpFile
+	files := conv.dumpFiles.files
+	for i, f := range files {
+		end := conv.statementTotals()
+		if i+1 < len(files) {
+			end = files[i+1].start
+		}
+		l = append(l, ReportDumpFile{
+			File:   f.name,
+			Schema: end.schema - f.start.schema,
+			Data:   end.data - f.start.data,
+			Skip:   end.skip - f.start.skip,
+			Error:  end.error - f.start.error,
+		})
+	}
+	return l
+}
+
+// definitionText returns the text of statement s for comparing table
+// definitions: comments, such as the ones pg_dump writes before each
+// statement (which name the table's owner), are removed, and so is
+// whitespace, except for a single space between words. Quoted strings
+// and identifiers are kept as they are.
+func definitionText(s string) string {
+	var b strings.Builder
+	var quote, last byte
+	space := false // Whitespace (or a comment) since the last byte written.
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				end = len(s) - i
+			}
+			i += end
+			space = true
+			continue
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				end = len(s) - i - 2
+			}
+			i += end + 3
+			space = true
+			continue
+		default:
+			if space && last != 0 && !strings.ContainsRune("(),;", rune(last)) && !strings.ContainsRune("(),;", rune(c)) {
+				b.WriteByte(' ')
+			}
+			if c == '\'' || c == '"' || c == '`' {
+				quote = c
+			}
+		}
+		space = false
+		b.WriteByte(c)
+		last = c
+	}
+	return b.String()
+}
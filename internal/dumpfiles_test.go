@@ -0,0 +1,144 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+type testDumpFile struct {
+	name string
+	dump string
+}
+
+// runProcessDumpFiles converts files together, with process (e.g.
+// ProcessPgDump), as the conversion package does.
+func runProcessDumpFiles(process func(*Conv, *Reader) error, files []testDumpFile) (*Conv, []spannerData) {
+	conv := MakeConv()
+	conv.SetLocation(time.UTC)
+	conv.now = func() time.Time { return time.Time{} }
+	conv.SetSchemaMode()
+	for _, f := range files {
+		conv.StartDumpFile(f.name)
+		process(conv, NewReader(bufio.NewReader(strings.NewReader(f.dump)), nil))
+	}
+	conv.FinishDumpFiles()
+	conv.SetDataMode()
+	var rows []spannerData
+	conv.SetDataSink(
+		func(table string, cols []string, vals []interface{}) {
+			rows = append(rows, spannerData{table: table, cols: cols, vals: vals})
+		})
+	for _, f := range files {
+		conv.StartDumpFile(f.name)
+		process(conv, NewReader(bufio.NewReader(strings.NewReader(f.dump)), nil))
+	}
+	return conv, rows
+}
+
+func TestProcessPgDump_DumpFiles(t *testing.T) {
+	conv, rows := runProcessDumpFiles(ProcessPgDump, []testDumpFile{
+		{"a.sql", "SET search_path = a;\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, name text);\n" +
+			"--\n-- Name: shared; Type: TABLE; Schema: a; Owner: alice\n--\n" +
+			"CREATE TABLE a.shared (id bigint PRIMARY KEY);\n" +
+			"COPY t (id, name) FROM stdin;\n1\tx\n\\.\n" +
+			"COPY a.shared (id) FROM stdin;\n1\n\\.\n"},
+		{"b.sql", "SET search_path = b;\n" +
+			"CREATE TABLE u (id bigint PRIMARY KEY, t_id bigint REFERENCES a.t (id));\n" +
+			"--\n-- Name: shared; Type: TABLE; Schema: a; Owner: bob\n--\n" +
+			"CREATE TABLE a.shared (\n  id bigint PRIMARY KEY\n);\n" +
+			"CREATE VIEW v AS SELECT 1;\n" +
+			"COPY u (id, t_id) FROM stdin;\n2\t1\n\\.\n" +
+			"COPY a.shared (id) FROM stdin;\n1\n\\.\n"},
+	})
+	assert.Equal(t, []string{"a.shared", "a.t", "b.u"}, sortedSrcTables(conv))
+	assert.Equal(t, map[string]ddl.ColumnDef{
+		"id":   {Name: "id", T: ddl.Int64{}, NotNull: true},
+		"t_id": {Name: "t_id", T: ddl.Int64{}},
+	}, stripSchemaComments(conv.spSchema)["b_u"].ColDefs)
+	assert.Equal(t, 1, len(conv.spSchema["b_u"].ForeignKeys))
+	// The shared table's rows come from the first file.
+	assert.Equal(t, []spannerData{
+		{table: "a_t", cols: []string{"id", "name"}, vals: []interface{}{int64(1), "x"}},
+		{table: "a_shared", cols: []string{"id"}, vals: []interface{}{int64(1)}},
+		{table: "b_u", cols: []string{"id", "t_id"}, vals: []interface{}{int64(2), int64(1)}},
+	}, rows)
+	assert.Empty(t, conv.DumpFileConflicts())
+	assert.Equal(t, tableDefStats{duplicates: 1}, conv.tableDefs)
+	assert.NotContains(t, unexpectedCounts(conv), "Table a.shared is defined again without DROP TABLE: using the first definition")
+	assert.Equal(t, []ReportDumpFile{
+		{File: "a.sql", Schema: 2, Data: 2},
+		{File: "b.sql", Schema: 1, Data: 1, Skip: 3},
+	}, BuildReport(PgDumpSource, conv, nil).DumpFiles)
+	b := new(bytes.Buffer)
+	w := bufio.NewWriter(b)
+	writeStmtStats(PgDumpSource, BuildReport(PgDumpSource, conv, nil), w)
+	w.Flush()
+	assert.Contains(t, b.String(), "Statements by dump file:\n"+
+		"       2      2      0      0  a.sql\n"+
+		"       1      1      3      0  b.sql\n")
+}
+
+func TestProcessPgDump_DumpFileConflicts(t *testing.T) {
+	conv, _ := runProcessDumpFiles(ProcessPgDump, []testDumpFile{
+		{"a.sql", "CREATE TABLE t (id bigint PRIMARY KEY);\n" +
+			"CREATE TABLE u (id bigint PRIMARY KEY);\n"},
+		{"b.sql", "CREATE TABLE t (id text PRIMARY KEY);\n" +
+			// Dropping u doesn't allow b.sql to redefine it.
+			"DROP TABLE IF EXISTS u;\n" +
+			"CREATE TABLE u (id bigint PRIMARY KEY, name text);\n"},
+	})
+	assert.Equal(t, []string{
+		"table t is defined differently in a.sql and b.sql",
+		"table u is defined differently in a.sql and b.sql",
+	}, conv.DumpFileConflicts())
+}
+
+func TestDefinitionText(t *testing.T) {
+	for _, tc := range []struct{ s, want string }{
+		{"CREATE TABLE t (id bigint);", "CREATE TABLE t(id bigint);"},
+		{"--\n-- Name: t\n--\n\nCREATE TABLE t (\n    id bigint\n);", "CREATE TABLE t(id bigint);"},
+		{"CREATE TABLE t (id bigint /* key */, s text DEFAULT 'a  -- /*');", "CREATE TABLE t(id bigint,s text DEFAULT 'a  -- /*');"},
+		{"CREATE TABLE \"a--b\" (id bigint) -- end", "CREATE TABLE \"a--b\"(id bigint)"},
+		{"CREATE TABLE t /* unterminated", "CREATE TABLE t"},
+	} {
+		assert.Equal(t, tc.want, definitionText(tc.s), tc.s)
+	}
+}
+
+func TestProcessMySQLDump_DumpFiles(t *testing.T) {
+	conv, rows := runProcessDumpFiles(ProcessMySQLDump, []testDumpFile{
+		{"a.sql", "CREATE TABLE `t` (`id` int NOT NULL, PRIMARY KEY (`id`));\n" +
+			"INSERT INTO `t` VALUES (1);\n"},
+		{"b.sql", "CREATE TABLE `u` (`id` int NOT NULL, PRIMARY KEY (`id`));\n" +
+			"CREATE TABLE `t` (`id` bigint NOT NULL, PRIMARY KEY (`id`));\n" +
+			"INSERT INTO `u` VALUES (2);\n"},
+	})
+	assert.Equal(t, []string{"t", "u"}, sortedSrcTables(conv))
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"id"}, vals: []interface{}{int64(1)}},
+		{table: "u", cols: []string{"id"}, vals: []interface{}{int64(2)}},
+	}, rows)
+	assert.Equal(t, []string{"table t is defined differently in a.sql and b.sql"}, conv.DumpFileConflicts())
+}
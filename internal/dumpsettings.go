@@ -0,0 +1,279 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	nodes "github.com/lfittl/pg_query_go/nodes"
+	"golang.org/x/text/encoding"
+)
+
+// pg_dump starts with SET statements that determine how the rest of
+// the dump must be read: client_encoding gives the encoding of all of
+// its text, and standard_conforming_strings whether backslashes in
+// ordinary string literals are escapes. Like search_path, these
+// settings are needed in both passes.
+
+// dumpSettings are the settings from a dump's SET statements.
+type dumpSettings struct {
+	escapeStrings bool              // standard_conforming_strings is off: backslashes in ordinary string literals are escapes.
+	encoding      string            // client_encoding, as given in the dump (empty if not set).
+	decoder       encoding.Encoding // Decodes text in the client encoding (nil for UTF-8, and encodings we can't decode).
+}
+
+// pgEncodings maps PostgreSQL encoding names, normalized as in
+// normalizeEncoding, to IANA charset names. An empty charset means that
+// no decoding is needed: SQL_ASCII text isn't checked by PostgreSQL
+// either.
+var pgEncodings = map[string]string{
+	"UTF8":     "",
+	"UNICODE":  "",
+	"SQLASCII": "",
+	"LATIN1":   "ISO-8859-1",
+	"LATIN2":   "ISO-8859-2",
+	"LATIN3":   "ISO-8859-3",
+	"LATIN4":   "ISO-8859-4",
+	"LATIN5":   "ISO-8859-9",
+	"LATIN6":   "ISO-8859-10",
+	"LATIN7":   "ISO-8859-13",
+	"LATIN8":   "ISO-8859-14",
+	"LATIN9":   "ISO-8859-15",
+	"LATIN10":  "ISO-8859-16",
+	"ISO88595": "ISO-8859-5",
+	"ISO88596": "ISO-8859-6",
+	"ISO88597": "ISO-8859-7",
+	"ISO88598": "ISO-8859-8",
+	"WIN866":   "IBM866",
+	"WIN874":   "windows-874",
+	"WIN1250":  "windows-1250",
+	"WIN1251":  "windows-1251",
+	"WIN1252":  "windows-1252",
+	"WIN1253":  "windows-1253",
+	"WIN1254":  "windows-1254",
+	"WIN1255":  "windows-1255",
+	"WIN1256":  "windows-1256",
+	"WIN1257":  "windows-1257",
+	"WIN1258":  "windows-1258",
+	"KOI8R":    "KOI8-R",
+	"KOI8U":    "KOI8-U",
+	"EUCJP":    "EUC-JP",
+	"EUCKR":    "EUC-KR",
+	"EUCCN":    "GB2312",
+	"UHC":      "EUC-KR", // Go's EUC-KR decoder handles the UHC extensions.
+	"SJIS":     "Shift_JIS",
+	"BIG5":     "Big5",
+	"GBK":      "GBK",
+	"GB18030":  "GB18030",
+}
+
+// normalizeEncoding normalizes an encoding name as PostgreSQL does:
+// case and punctuation are ignored e.g. "utf-8" is UTF8.
+func normalizeEncoding(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(name))
+}
+
+// pgEncoding returns the decoder for PostgreSQL encoding name (nil if
+// no decoding is needed), or an error if we can't decode it.
+func pgEncoding(name string) (encoding.Encoding, error) {
+	charset, ok := pgEncodings[normalizeEncoding(name)]
+	if !ok {
+		charset = name // Try it as an IANA name.
+	}
+	if charset == "" {
+		return nil, nil
+	}
+	e, err := charsetEncoding(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported client_encoding %s", name)
+	}
+	return e, nil
+}
+
+// processDumpSetting handles SET client_encoding and SET
+// standard_conforming_strings.
+func processDumpSetting(conv *Conv, n nodes.VariableSetStmt) {
+	var v string
+	if len(n.Args.Items) > 0 {
+		c, ok := n.Args.Items[0].(nodes.A_Const)
+		if !ok {
+			logStmtError(conv, n, fmt.Errorf("found %s node in Arg", prNodeType(n.Args.Items[0])))
+			return
+		}
+		s, err := getString(c.Val)
+		if err != nil {
+			logStmtError(conv, n, fmt.Errorf("can't get Arg: %w", err))
+			return
+		}
+		v = s
+	}
+	switch *n.Name {
+	case "standard_conforming_strings":
+		switch strings.ToLower(v) {
+		case "", "on", "true", "yes", "1": // Empty for SET ... TO DEFAULT.
+			conv.dumpSettings.escapeStrings = false
+		case "off", "false", "no", "0":
+			conv.dumpSettings.escapeStrings = true
+		default:
+			logStmtError(conv, n, fmt.Errorf("bad standard_conforming_strings %q", v))
+			return
+		}
+	case "client_encoding":
+		conv.dumpSettings.encoding = v
+		e, err := pgEncoding(v)
+		conv.dumpSettings.decoder = e
+		if err != nil && conv.schemaMode() {
+			// Recorded before any data is converted: the data is
+			// read as if it were UTF-8.
+			conv.badEncoding = v
+			conv.unexpected(fmt.Sprintf("Dump has %s: its text is read as UTF-8", err))
+		}
+	}
+	conv.schemaStatement([]nodes.Node{n})
+}
+
+// readDumpText prepares SQL text s of the dump for parsing, as
+// determined by the dump's settings.
+func (conv *Conv) readDumpText(s []byte) []byte {
+	if conv.dumpSettings.decoder == nil && !conv.dumpSettings.escapeStrings {
+		return s
+	}
+	t := conv.decodeDump(string(s))
+	if conv.dumpSettings.escapeStrings {
+		t = escapeStringLiterals(t)
+	}
+	return []byte(t)
+}
+
+// decodeDump decodes text s of the dump from the client encoding to
+// UTF-8. Text that can't be decoded is returned as it is, and then
+// handled like other invalid UTF-8 (see utf8.go).
+func (conv *Conv) decodeDump(s string) string {
+	e := conv.dumpSettings.decoder
+	if e == nil || isASCII(s) {
+		return s
+	}
+	d, err := e.NewDecoder().String(s)
+	if err != nil {
+		return s
+	}
+	return d
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeStringLiterals rewrites the ordinary string literals of SQL
+// text s that have backslashes as escape string literals (E'...'),
+// so that the parser (which assumes standard_conforming_strings is on)
+// reads them as PostgreSQL does when standard_conforming_strings is
+// off. Comments, quoted identifiers and dollar-quoted strings are left
+// as they are.
+func escapeStringLiterals(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		end := i + 1
+		switch {
+		case strings.HasPrefix(s[i:], "--"):
+			end = skipTo(s, i+2, "\n")
+		case strings.HasPrefix(s[i:], "/*"):
+			end = skipTo(s, i+2, "*/")
+		case s[i] == '"':
+			end = skipTo(s, i+1, `"`)
+		case s[i] == '$' && (i == 0 || !isIdentChar(s[i-1])):
+			if tag, ok := dollarTag(s[i:]); ok {
+				end = skipTo(s, i+len(tag), tag)
+			}
+		case s[i] == '\'':
+			escapes := false
+			for end < len(s) {
+				if s[end] == '\\' {
+					escapes = true
+					end += 2
+					continue
+				}
+				end++
+				if s[end-1] == '\'' {
+					if end < len(s) && s[end] == '\'' {
+						end++ // Doubled quote.
+						continue
+					}
+					break
+				}
+			}
+			if end > len(s) {
+				end = len(s)
+			}
+			// Literals with a prefix (e.g. E'...' or B'...') are left
+			// as they are.
+			if escapes && (i == 0 || !isIdentChar(s[i-1])) {
+				b.WriteByte('E')
+			}
+		}
+		b.WriteString(s[i:end])
+		i = end
+	}
+	return b.String()
+}
+
+// skipTo returns the offset in s just after the first occurrence of
+// delim at or after offset i, or len(s) if there is none.
+func skipTo(s string, i int, delim string) int {
+	if j := strings.Index(s[i:], delim); j >= 0 {
+		return i + j + len(delim)
+	}
+	return len(s)
+}
+
+// dollarTag returns the tag that s starts with, if any e.g. "$$" or
+// "$body$".
+func dollarTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '$':
+			return s[:i+1], true
+		case c >= '0' && c <= '9' && i == 1:
+			return "", false // A parameter e.g. $1.
+		case !isIdentChar(c):
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// encodingWarning returns a warning for the report if the dump's
+// client_encoding can't be decoded, or "" otherwise.
+func encodingWarning(conv *Conv) string {
+	if conv.badEncoding == "" {
+		return ""
+	}
+	return fmt.Sprintf("WARNING: the dump's client_encoding %s is not supported, so its text was read as UTF-8: text values may be wrong, or counted as bad rows", conv.badEncoding)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeStringLiterals(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{`INSERT INTO t VALUES ('a', 'it''s');`, `INSERT INTO t VALUES ('a', 'it''s');`},
+		{`INSERT INTO t VALUES ('a\\b', 'it\'s');`, `INSERT INTO t VALUES (E'a\\b', E'it\'s');`},
+		{`INSERT INTO t VALUES (E'a\\b', B'101');`, `INSERT INTO t VALUES (E'a\\b', B'101');`},
+		{`SELECT 'x\'' AS "a\'b"; -- 'c\d'`, `SELECT E'x\'' AS "a\'b"; -- 'c\d'`},
+		{`/* 'a\b' */ SELECT $$a\b$$, $f$'\'$f$, $1;`, `/* 'a\b' */ SELECT $$a\b$$, $f$'\'$f$, $1;`},
+		{`SELECT 'unterminated\`, `SELECT E'unterminated\`},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.out, escapeStringLiterals(tc.in), tc.in)
+	}
+}
+
+func TestPgEncoding(t *testing.T) {
+	for _, name := range []string{"UTF8", "utf-8", "SQL_ASCII", "unicode"} {
+		e, err := pgEncoding(name)
+		assert.Nil(t, err, name)
+		assert.Nil(t, e, name)
+	}
+	for _, name := range []string{"LATIN1", "latin9", "WIN1252", "SJIS", "EUC_JP", "KOI8-R", "windows-1251"} {
+		e, err := pgEncoding(name)
+		assert.Nil(t, err, name)
+		assert.NotNil(t, e, name)
+	}
+	for _, name := range []string{"EUC_TW", "MULE_INTERNAL"} {
+		_, err := pgEncoding(name)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestProcessPgDump_ClientEncoding(t *testing.T) {
+	conv, rows := runProcessPgDump(
+		"SET client_encoding = 'LATIN1';\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, \"caf\xe9\" text);\n" +
+			"INSERT INTO t VALUES (1, 'na\xefve');\n" +
+			"COPY public.t (id, \"caf\xe9\") FROM stdin;\n2\tcr\xe8me\n\\.\n")
+	assert.Equal(t, []string{"id", "café"}, conv.srcSchema["t"].ColNames)
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"id", "caf_"}, vals: []interface{}{int64(1), "naïve"}},
+		{table: "t", cols: []string{"id", "caf_"}, vals: []interface{}{int64(2), "crème"}},
+	}, rows)
+	assert.Equal(t, int64(1), conv.stats.statement["VariableSetStmt"].schema)
+	assert.Empty(t, conv.stats.unexpected)
+}
+
+func TestProcessPgDump_StandardConformingStrings(t *testing.T) {
+	const insert = "CREATE TABLE t (id bigint PRIMARY KEY, s text);\n" +
+		"INSERT INTO t VALUES (1, 'a\\\\b'), (2, E'c\\td');\n"
+	tests := []struct {
+		setting string
+		vals    []string
+	}{
+		{"on", []string{`a\\b`, "c\td"}},
+		{"off", []string{`a\b`, "c\td"}},
+	}
+	for _, tc := range tests {
+		_, rows := runProcessPgDump("SET standard_conforming_strings = " + tc.setting + ";\n" + insert)
+		var vals []string
+		for _, r := range rows {
+			vals = append(vals, r.vals[1].(string))
+		}
+		assert.Equal(t, tc.vals, vals, tc.setting)
+	}
+	// A quote escaped with a backslash.
+	_, rows := runProcessPgDump("SET standard_conforming_strings = off;\n" +
+		"CREATE TABLE t (id bigint PRIMARY KEY, s text);\n" +
+		"INSERT INTO t VALUES (1, 'it\\'s; fine');\n")
+	assert.Equal(t, []spannerData{{table: "t", cols: []string{"id", "s"}, vals: []interface{}{int64(1), "it's; fine"}}}, rows)
+}
+
+func TestProcessPgDump_UnsupportedEncoding(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"SET client_encoding = 'EUC_TW';\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, s text);\n" +
+			"INSERT INTO t VALUES (1, 'a');\n")
+	assert.Equal(t, map[string]int64{"Dump has unsupported client_encoding EUC_TW: its text is read as UTF-8": 1}, unexpectedCounts(conv))
+	b := new(bytes.Buffer)
+	w := bufio.NewWriter(b)
+	summary := GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.True(t, strings.HasPrefix(summary, "WARNING: the dump's client_encoding EUC_TW is not supported"), summary)
+	assert.Contains(t, b.String(), "WARNING: the dump's client_encoding EUC_TW is not supported")
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+)
+
+// Concatenated dump files can have more than one COPY-FROM block for
+// the same table, which would write the table's data more than once.
+// We track the tables whose COPY-FROM blocks have been completed in
+// each pass, and handle later blocks for them as configured (see
+// DuplicateCopy). Tables are tracked by the name given in the dump, so
+// blocks of partitions or inherited tables that are written to the
+// same Spanner table aren't duplicates.
+
+// DuplicateCopy determines what happens to a COPY-FROM block for a
+// table whose data was already given by an earlier block.
+type DuplicateCopy string
+
+// Duplicate COPY-FROM policies.
+const (
+	DuplicateCopySkip   DuplicateCopy = "skip"   // The block's rows are skipped (the default).
+	DuplicateCopyAppend DuplicateCopy = "append" // The block's rows are converted, like those of the first block.
+)
+
+// dupCopyStats counts the duplicate COPY-FROM blocks of a table.
+type dupCopyStats struct {
+	blocks int64 // Duplicate blocks i.e. not including the first one.
+	rows   int64 // Rows of the duplicate blocks.
+}
+
+// ParseDuplicateCopy returns the policy named s (empty for the
+// default).
+func ParseDuplicateCopy(s string) (DuplicateCopy, error) {
+	switch x := DuplicateCopy(s); x {
+	case "":
+		return DuplicateCopySkip, nil
+	case DuplicateCopySkip, DuplicateCopyAppend:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown duplicate COPY policy %q: expected skip or append", s)
+}
+
+// SetDuplicateCopy configures what happens to duplicate COPY-FROM
+// blocks.
+func (conv *Conv) SetDuplicateCopy(p DuplicateCopy) {
+	conv.dupCopyPolicy = p
+}
+
+// skipDuplicateCopies returns whether duplicate COPY-FROM blocks are
+// skipped.
+func (conv *Conv) skipDuplicateCopies() bool {
+	return conv.dupCopyPolicy != DuplicateCopyAppend
+}
+
+// duplicateCopy returns whether a COPY-FROM block for table name (as
+// given in the dump) is a duplicate: that is, a block for the same
+// table was already completed in this pass.
+func (conv *Conv) duplicateCopy(name string) bool {
+	return conv.copiedTables[name]
+}
+
+// copyDone records that a COPY-FROM block for table name (as given in
+// the dump) was completed.
+func (conv *Conv) copyDone(name string) {
+	if conv.copiedTables == nil {
+		conv.copiedTables = make(map[string]bool)
+	}
+	conv.copiedTables[name] = true
+}
+
+// addDuplicateCopy records a duplicate COPY-FROM block with n rows for
+// table name (as given in the dump), whose rows go to srcTable. Like
+// statement stats, duplicates are recorded on the first pass only.
+func (conv *Conv) addDuplicateCopy(name, srcTable string, n int64) {
+	if !conv.schemaMode() {
+		return
+	}
+	action := "skipped"
+	if !conv.skipDuplicateCopies() {
+		action = "appended"
+	}
+	conv.unexpected(fmt.Sprintf("Duplicate COPY-FROM block for table %s (%s)", name, action))
+	if conv.dupCopies == nil {
+		conv.dupCopies = make(map[string]*dupCopyStats)
+	}
+	s, ok := conv.dupCopies[srcTable]
+	if !ok {
+		s = &dupCopyStats{}
+		conv.dupCopies[srcTable] = s
+	}
+	s.blocks++
+	s.rows += n
+}
+
+// describeDuplicateCopies describes the duplicate COPY-FROM blocks of
+// srcTable for the report, or returns "" if there are none.
+func (conv *Conv) describeDuplicateCopies(srcTable string) string {
+	s, ok := conv.dupCopies[srcTable]
+	if !ok {
+		return ""
+	}
+	blocks := "a later block"
+	if s.blocks > 1 {
+		blocks = fmt.Sprintf("%d later blocks", s.blocks)
+	}
+	rows := "rows"
+	if s.rows == 1 {
+		rows = "row"
+	}
+	if conv.skipDuplicateCopies() {
+		return fmt.Sprintf("The dump repeats this table's data: %s had %d %s, which were skipped as duplicates (not included in the row counts)", blocks, s.rows, rows)
+	}
+	return fmt.Sprintf("The dump repeats this table's data: %s had %d %s, which were converted as well as those of the first block", blocks, s.rows, rows)
+}
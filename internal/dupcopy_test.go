@@ -0,0 +1,89 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDuplicateCopy(t *testing.T) {
+	p, err := ParseDuplicateCopy("")
+	assert.Nil(t, err)
+	assert.Equal(t, DuplicateCopySkip, p)
+	p, err = ParseDuplicateCopy("append")
+	assert.Nil(t, err)
+	assert.Equal(t, DuplicateCopyAppend, p)
+	_, err = ParseDuplicateCopy("merge")
+	assert.NotNil(t, err)
+}
+
+func TestProcessPgDump_DuplicateCopy(t *testing.T) {
+	const copyT = "COPY public.t (id, s) FROM stdin;\n" +
+		"1\ta\n" +
+		"2\tb\n" +
+		"\\.\n"
+	const dump = "CREATE TABLE t (id bigint PRIMARY KEY, s text);\n" +
+		"CREATE TABLE u (id bigint PRIMARY KEY);\n" +
+		copyT +
+		"COPY public.u (id) FROM stdin;\n" +
+		"1\n" +
+		"\\.\n" +
+		copyT
+	tests := []struct {
+		policy     DuplicateCopy
+		rows       int
+		count      int64
+		stmt       statementStat
+		report     string
+		unexpected string
+	}{
+		{
+			policy:     DuplicateCopySkip,
+			rows:       3,
+			count:      2,
+			stmt:       statementStat{data: 2, skip: 1},
+			report:     "The dump repeats this table's data: a later block had 2 rows, which were skipped as duplicates (not included in the row counts).",
+			unexpected: "Duplicate COPY-FROM block for table t (skipped)",
+		},
+		{
+			policy:     DuplicateCopyAppend,
+			rows:       5,
+			count:      4,
+			stmt:       statementStat{data: 3},
+			report:     "The dump repeats this table's data: a later block had 2 rows, which were converted as well as those of the first block.",
+			unexpected: "Duplicate COPY-FROM block for table t (appended)",
+		},
+	}
+	for _, tc := range tests {
+		conv := MakeConv()
+		conv.SetDuplicateCopy(tc.policy)
+		conv, rows := runProcessPgDumpConv(conv, dump)
+		assert.Equal(t, tc.rows, len(rows), string(tc.policy))
+		assert.Equal(t, tc.count, conv.stats.rows["t"], string(tc.policy))
+		assert.Equal(t, int64(1), conv.stats.rows["u"], string(tc.policy))
+		assert.Equal(t, map[string]*dupCopyStats{"t": {blocks: 1, rows: 2}}, conv.dupCopies, string(tc.policy))
+		assert.Equal(t, tc.stmt, *conv.stats.statement["CopyStmt"], string(tc.policy))
+		assert.Equal(t, map[string]int64{tc.unexpected: 1}, unexpectedCounts(conv), string(tc.policy))
+		b := new(bytes.Buffer)
+		w := bufio.NewWriter(b)
+		GenerateReport(PgDumpSource, conv, w, nil)
+		w.Flush()
+		assert.Contains(t, normalizeSpace(b.String()), tc.report, string(tc.policy))
+	}
+}
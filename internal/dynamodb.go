@@ -0,0 +1,513 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// DynamoDB tables are converted from exports to S3 in DynamoDB JSON
+// format: each line of the export's data files is an item, wrapped as
+// {"Item": {...}}, with each attribute value tagged with its DynamoDB
+// type e.g. {"N": "42"}. Items don't have a fixed set of attributes, or
+// a fixed type for each attribute, so the schema is inferred: each
+// attribute found in any item becomes a column, and its type comes from
+// a sample of its values. An export is of a single table, and doesn't
+// record the table's name or key, so these are configured (see
+// DynamoDBExport).
+
+// DefaultDynamoDBSampleSize is the default number of values of each
+// attribute used to infer its type.
+const DefaultDynamoDBSampleSize = 1000
+
+// DynamoDBExport configures the conversion of a DynamoDB export (see
+// Conv.SetDynamoDBExport).
+type DynamoDBExport struct {
+	Table      string   // Name of the exported table.
+	Key        []string // Partition key attribute, followed by the sort key attribute (if any). Empty for a synthetic primary key.
+	SampleSize int64    // Values of each attribute used to infer its type (0 means DefaultDynamoDBSampleSize).
+}
+
+// Validate returns an error if e isn't a valid export configuration.
+func (e DynamoDBExport) Validate() error {
+	if e.Table == "" {
+		return fmt.Errorf("no table name specified for the DynamoDB export")
+	}
+	if len(e.Key) > 2 {
+		return fmt.Errorf("bad DynamoDB key %s: expected a partition key attribute and an optional sort key attribute", strings.Join(e.Key, ","))
+	}
+	for _, k := range e.Key {
+		if k == "" {
+			return fmt.Errorf("bad DynamoDB key %s: attribute names can't be empty", strings.Join(e.Key, ","))
+		}
+	}
+	if len(e.Key) == 2 && e.Key[0] == e.Key[1] {
+		return fmt.Errorf("bad DynamoDB key %s: the partition and sort keys must be different attributes", strings.Join(e.Key, ","))
+	}
+	if e.SampleSize < 0 {
+		return fmt.Errorf("bad DynamoDB sample size %d: must not be negative", e.SampleSize)
+	}
+	return nil
+}
+
+// dynamoExport is the state of the conversion of a DynamoDB export.
+type dynamoExport struct {
+	DynamoDBExport
+	attrs map[string]*dynamoAttr // Attributes found in schema mode, keyed by name.
+	row   []string               // DynamoDB types of the values of the item being converted (empty for missing and NULL values).
+}
+
+// dynamoAttr has the values of an attribute sampled to infer its type.
+type dynamoAttr struct {
+	sampled    int64            // Values sampled (NULL values aren't counted).
+	types      map[string]int64 // Values sampled, broken down by DynamoDB type.
+	nonInt     bool             // Whether some sampled numbers aren't INT64 values.
+	nonNumeric bool             // Whether some sampled numbers don't fit a Spanner NUMERIC.
+}
+
+// SetDynamoDBExport configures conv to convert a DynamoDB export (see
+// ProcessDynamoDBExport). It must be called before schema conversion.
+func (conv *Conv) SetDynamoDBExport(e DynamoDBExport) {
+	if e.SampleSize == 0 {
+		e.SampleSize = DefaultDynamoDBSampleSize
+	}
+	conv.dynamo = &dynamoExport{DynamoDBExport: e, attrs: make(map[string]*dynamoAttr)}
+}
+
+// ProcessDynamoDBExport reads the data files of a DynamoDB export from
+// r (see SetDynamoDBExport), and does schema or data conversion,
+// depending on whether conv is configured for schema mode or data mode
+// (see ProcessPgDump). In schema mode, the table's schema is inferred
+// from the items; in data mode, each item is converted as a row.
+func ProcessDynamoDBExport(conv *Conv, r *Reader) error {
+	d := conv.dynamo
+	if d == nil {
+		return fmt.Errorf("DynamoDB export not configured")
+	}
+	for !r.EOF {
+		startOffset := r.Offset
+		start := conv.now()
+		line := r.ReadLine()
+		if r.Stopped() {
+			break // Ignore the incomplete line.
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		conv.setDumpContext(int64(startOffset), string(line))
+		item, err := parseDynamoItem(line)
+		if err != nil {
+			if conv.schemaMode() {
+				conv.unexpected(fmt.Sprintf("Processing DynamoDB item: %s", err))
+				conv.getStatementStat("Item").error++
+			}
+			continue
+		}
+		if conv.schemaMode() {
+			conv.getStatementStat("Item").data++
+			conv.statsAddRow(d.Table, true)
+			d.sample(item)
+			continue
+		}
+		conv.processDynamoItem(item)
+		conv.statsAddTiming(d.Table, conv.now().Sub(start), int64(r.Offset-startOffset))
+	}
+	conv.clearDumpContext()
+	if conv.dataMode() && !r.Stopped() {
+		conv.tableRead()
+	}
+	if conv.schemaMode() {
+		t, err := d.schemaTable()
+		if err != nil {
+			return err
+		}
+		if t != nil {
+			conv.srcSchema[t.Name] = *t
+		}
+		schemaToDDL(conv)
+		conv.AddPrimaryKeys()
+	}
+	return nil
+}
+
+// dynamoValue is an attribute value of an item, tagged with its
+// DynamoDB type e.g. N for {"N": "42"}.
+type dynamoValue struct {
+	typ string
+	raw json.RawMessage
+}
+
+// parseDynamoItem parses a line of a DynamoDB export, and returns its
+// item's attributes, keyed by name.
+func parseDynamoItem(line []byte) (map[string]dynamoValue, error) {
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal(line, &wrapper); err != nil {
+		return nil, fmt.Errorf("can't parse item: %w", err)
+	}
+	raw, ok := wrapper["Item"]
+	if !ok || len(wrapper) != 1 {
+		return nil, fmt.Errorf("line doesn't hold just an Item object")
+	}
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &attrs); err != nil {
+		return nil, fmt.Errorf("can't parse item: %w", err)
+	}
+	item := make(map[string]dynamoValue, len(attrs))
+	for name, a := range attrs {
+		v, err := parseDynamoValue(a)
+		if err != nil {
+			return nil, fmt.Errorf("bad value of attribute %s: %w", name, err)
+		}
+		item[name] = v
+	}
+	return item, nil
+}
+
+// parseDynamoValue parses an attribute value e.g. {"N": "42"}.
+func parseDynamoValue(a json.RawMessage) (dynamoValue, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(a, &m); err != nil {
+		return dynamoValue{}, err
+	}
+	if len(m) != 1 {
+		return dynamoValue{}, fmt.Errorf("expected a single DynamoDB type, but got %d", len(m))
+	}
+	for t, raw := range m {
+		switch t {
+		case "S", "N", "B", "BOOL", "NULL", "L", "M", "SS", "NS", "BS":
+			return dynamoValue{typ: t, raw: raw}, nil
+		}
+		return dynamoValue{}, fmt.Errorf("unknown DynamoDB type %s", t)
+	}
+	return dynamoValue{}, nil
+}
+
+// sample records the values of item's attributes, for type inference.
+func (d *dynamoExport) sample(item map[string]dynamoValue) {
+	for name, v := range item {
+		a, ok := d.attrs[name]
+		if !ok {
+			a = &dynamoAttr{types: make(map[string]int64)}
+			d.attrs[name] = a
+		}
+		if v.typ == "NULL" || a.sampled >= d.SampleSize {
+			continue
+		}
+		a.sampled++
+		a.types[v.typ]++
+		if v.typ != "N" {
+			continue
+		}
+		var n string
+		if err := json.Unmarshal(v.raw, &n); err != nil {
+			// Counted as a bad row in data mode.
+			continue
+		}
+		if _, err := strconv.ParseInt(n, 10, 64); err != nil {
+			a.nonInt = true
+		}
+		if _, err := convNumeric(n); err != nil {
+			a.nonNumeric = true
+		}
+	}
+}
+
+// sourceType returns the source type inferred for attribute a: its
+// DynamoDB type, with numbers split into "N" (INT64 values), "N
+// decimal" (values that fit a NUMERIC) and "N float". Attributes with
+// values of several types get all the types, separated by "|" e.g.
+// "N|S".
+func (a *dynamoAttr) sourceType() string {
+	if len(a.types) == 0 {
+		return "NULL"
+	}
+	var l []string
+	for t := range a.types {
+		l = append(l, t)
+	}
+	if len(l) > 1 {
+		sort.Strings(l)
+		return strings.Join(l, "|")
+	}
+	if l[0] == "N" {
+		switch {
+		case a.nonNumeric:
+			return "N float"
+		case a.nonInt:
+			return "N decimal"
+		}
+	}
+	return l[0]
+}
+
+// schemaTable returns the source table inferred from the items sampled,
+// or nil if there were no items. Key attributes come first, followed by
+// the other attributes in alphabetical order.
+func (d *dynamoExport) schemaTable() (*schema.Table, error) {
+	if len(d.attrs) == 0 {
+		return nil, nil
+	}
+	t := schema.Table{Name: d.Table, ColDefs: make(map[string]schema.Column)}
+	var names []string
+	for name := range d.attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := make(map[string]bool)
+	for _, k := range d.Key {
+		if _, ok := d.attrs[k]; !ok {
+			return nil, fmt.Errorf("key attribute %s isn't in any item of the DynamoDB export", k)
+		}
+		key[k] = true
+		t.ColNames = append(t.ColNames, k)
+		t.PrimaryKeys = append(t.PrimaryKeys, schema.Key{Column: k})
+	}
+	for _, name := range names {
+		if !key[name] {
+			t.ColNames = append(t.ColNames, name)
+		}
+	}
+	for _, name := range t.ColNames {
+		t.ColDefs[name] = schema.Column{Name: name, Type: schema.Type{Name: d.attrs[name].sourceType()}, NotNull: key[name]}
+	}
+	return &t, nil
+}
+
+// toSpannerTypeDynamo maps a DynamoDB source type (see
+// dynamoAttr.sourceType) into a Spanner type. It is the DynamoDB
+// equivalent of toSpannerType.
+func toSpannerTypeDynamo(conv *Conv, id string, mods []int64) (ddl.ScalarType, []schemaIssue) {
+	switch id {
+	case "N":
+		return ddl.Int64{}, nil
+	case "N decimal":
+		return ddl.Numeric{}, nil
+	case "N float":
+		return ddl.Float64{}, []schemaIssue{dynamoFloat}
+	case "S", "NULL":
+		return ddl.String{Len: ddl.MaxLength{}}, nil
+	case "B":
+		return ddl.Bytes{Len: ddl.MaxLength{}}, nil
+	case "BOOL":
+		return ddl.Bool{}, nil
+	case "L", "M", "SS", "NS", "BS":
+		return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{dynamoDocument}
+	}
+	if strings.Contains(id, "|") {
+		return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{dynamoMixedTypes}
+	}
+	conv.unexpected(fmt.Sprintf("Unknown DynamoDB source type %s", id))
+	return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{noGoodType}
+}
+
+// dynamoTypes returns the DynamoDB types of the values of a column of
+// source type id.
+func dynamoTypes(id string) []string {
+	if strings.HasPrefix(id, "N ") {
+		return []string{"N"}
+	}
+	return strings.Split(id, "|")
+}
+
+// processDynamoItem converts item as a row of the table, with a value
+// for each column of the source schema: attributes the item doesn't
+// have are NULL.
+func (conv *Conv) processDynamoItem(item map[string]dynamoValue) {
+	d := conv.dynamo
+	t, ok := conv.srcSchema[d.Table]
+	if !ok {
+		conv.unexpected(fmt.Sprintf("Table %s isn't in the schema", d.Table))
+		return
+	}
+	for name := range item {
+		if _, ok := t.ColDefs[name]; !ok {
+			conv.unexpected(fmt.Sprintf("Attribute %s isn't a column of table %s", name, d.Table))
+		}
+	}
+	vals := make([]string, len(t.ColNames))
+	nulls := make([]bool, len(t.ColNames))
+	d.row = make([]string, len(t.ColNames))
+	for i, c := range t.ColNames {
+		v, ok := item[c]
+		if !ok || v.typ == "NULL" {
+			nulls[i] = true
+			continue
+		}
+		s, err := dynamoText(v, t.ColDefs[c].Type.Name == "B")
+		if err != nil {
+			// Gives a bad row (see dynamoExport.checkType).
+			d.row[i] = "invalid " + v.typ
+			vals[i] = string(v.raw)
+			continue
+		}
+		vals[i] = s
+		d.row[i] = v.typ
+	}
+	processDataRow(conv, d.Table, t.ColNames, vals, nulls)
+	d.row = nil
+}
+
+// checkType returns an error if the i'th value of the item being
+// converted doesn't have one of the DynamoDB types of its column,
+// whose source type is id. For example, a string can't be converted
+// for a column inferred to hold numbers, even if it has digits.
+func (d *dynamoExport) checkType(id string, i int) error {
+	if i >= len(d.row) || d.row[i] == "" {
+		return nil
+	}
+	for _, t := range dynamoTypes(id) {
+		if t == d.row[i] {
+			return nil
+		}
+	}
+	return fmt.Errorf("can't convert DynamoDB %s value for column of type %s", d.row[i], id)
+}
+
+// dynamoText returns the text of value v for data conversion: numbers
+// as their digits, strings as is, booleans as true or false, and lists,
+// maps and sets as JSON (see dynamoJSON). Binary values are decoded if
+// decode is true (for BYTES columns), and otherwise left in base64.
+func dynamoText(v dynamoValue, decode bool) (string, error) {
+	switch v.typ {
+	case "S", "N", "B":
+		var s string
+		if err := json.Unmarshal(v.raw, &s); err != nil {
+			return "", err
+		}
+		if v.typ == "B" && decode {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return "", err
+			}
+			return string(b), nil
+		}
+		return s, nil
+	case "BOOL":
+		var b bool
+		if err := json.Unmarshal(v.raw, &b); err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(b), nil
+	}
+	x, err := dynamoJSON(v)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(x); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// dynamoJSON returns value v without its DynamoDB type tags, for
+// encoding as plain JSON e.g. {"M": {"a": {"N": "1"}}} gives {"a": 1}.
+// Binary values are left in base64, and sets become arrays.
+func dynamoJSON(v dynamoValue) (interface{}, error) {
+	switch v.typ {
+	case "S", "B":
+		var s string
+		err := json.Unmarshal(v.raw, &s)
+		return s, err
+	case "N":
+		var s string
+		if err := json.Unmarshal(v.raw, &s); err != nil {
+			return nil, err
+		}
+		return json.Number(s), nil
+	case "BOOL":
+		var b bool
+		err := json.Unmarshal(v.raw, &b)
+		return b, err
+	case "NULL":
+		return nil, nil
+	case "SS", "BS":
+		var l []string
+		err := json.Unmarshal(v.raw, &l)
+		return l, err
+	case "NS":
+		var l []json.Number
+		err := json.Unmarshal(v.raw, &l)
+		return l, err
+	case "L":
+		var l []json.RawMessage
+		if err := json.Unmarshal(v.raw, &l); err != nil {
+			return nil, err
+		}
+		x := make([]interface{}, len(l))
+		for i, a := range l {
+			e, err := parseDynamoValue(a)
+			if err != nil {
+				return nil, err
+			}
+			if x[i], err = dynamoJSON(e); err != nil {
+				return nil, err
+			}
+		}
+		return x, nil
+	case "M":
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(v.raw, &m); err != nil {
+			return nil, err
+		}
+		x := make(map[string]interface{}, len(m))
+		for k, a := range m {
+			e, err := parseDynamoValue(a)
+			if err != nil {
+				return nil, err
+			}
+			if x[k], err = dynamoJSON(e); err != nil {
+				return nil, err
+			}
+		}
+		return x, nil
+	}
+	return nil, fmt.Errorf("unknown DynamoDB type %s", v.typ)
+}
+
+// describeDynamoTypes returns a description of the types of the values
+// sampled for a column of srcTable mapped to spType because its values
+// have several types.
+func (conv *Conv) describeDynamoTypes(srcTable, srcCol, spType string) string {
+	var a *dynamoAttr
+	if d := conv.dynamo; d != nil && d.Table == srcTable {
+		a = d.attrs[srcCol]
+	}
+	if a == nil {
+		// E.g. the schema came from a session.
+		return fmt.Sprintf("Attribute '%s' has values of several types, so it is mapped to %s", srcCol, spType)
+	}
+	var types []string
+	for t := range a.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	var l []string
+	for _, t := range types {
+		l = append(l, fmt.Sprintf("%s in %d", t, a.types[t]))
+	}
+	return fmt.Sprintf("Attribute '%s' has values of several types (%s of %d sampled values), so it is mapped to %s", srcCol, strings.Join(l, ", "), a.sampled, spType)
+}
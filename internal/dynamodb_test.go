@@ -0,0 +1,168 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+const dynamoExportSample = `{"Item":{"user":{"S":"ann"},"ts":{"N":"1"},"score":{"N":"1.5"},"big":{"N":"1E+100"},"ok":{"BOOL":true},"pic":{"B":"vu8="},"tags":{"SS":["a","b"]},"prefs":{"M":{"theme":{"S":"<dark>"},"size":{"N":"12"},"on":{"NULL":true}}},"misc":{"N":"7"}}}
+{"Item":{"user":{"S":"ann"},"ts":{"N":"2"},"score":{"N":"2"},"misc":{"S":"seven"},"hist":{"L":[{"N":"1"},{"S":"x"}]}}}
+
+{"Item":{"user":{"S":"bob"},"ts":{"N":"3"},"ok":{"NULL":true},"misc":{"BOOL":false}}}
+`
+
+func TestProcessDynamoDBExport(t *testing.T) {
+	conv, rows := runProcessDynamoDBExport(DynamoDBExport{Table: "users", Key: []string{"user", "ts"}}, dynamoExportSample)
+	assert.Zero(t, len(conv.stats.unexpected), fmt.Sprintf("unexpected conditions: %v", unexpectedCounts(conv)))
+	assert.Zero(t, len(conv.stats.badRows), fmt.Sprintf("bad rows: %v", conv.stats.badRows))
+	assert.Equal(t, []string{"user", "ts", "big", "hist", "misc", "ok", "pic", "prefs", "score", "tags"}, conv.srcSchema["users"].ColNames)
+	st := stripSchemaComments(conv.spSchema)["users"]
+	assert.Equal(t, []ddl.IndexKey{{Col: "user"}, {Col: "ts"}}, st.Pks)
+	maxString := ddl.String{Len: ddl.MaxLength{}}
+	expected := map[string]ddl.ColumnDef{
+		"user":  {Name: "user", T: maxString, NotNull: true},
+		"ts":    {Name: "ts", T: ddl.Int64{}, NotNull: true},
+		"big":   {Name: "big", T: ddl.Float64{}},
+		"hist":  {Name: "hist", T: maxString},
+		"misc":  {Name: "misc", T: maxString},
+		"ok":    {Name: "ok", T: ddl.Bool{}},
+		"pic":   {Name: "pic", T: ddl.Bytes{Len: ddl.MaxLength{}}},
+		"prefs": {Name: "prefs", T: maxString},
+		"score": {Name: "score", T: ddl.Numeric{}},
+		"tags":  {Name: "tags", T: maxString},
+	}
+	for c, cd := range expected {
+		assert.Equal(t, cd, st.ColDefs[c], c)
+	}
+	issues := conv.issues["users"]
+	assert.Equal(t, []schemaIssue{dynamoFloat}, issues["big"])
+	assert.Equal(t, []schemaIssue{dynamoMixedTypes}, issues["misc"])
+	assert.Equal(t, []schemaIssue{dynamoDocument}, issues["prefs"])
+	assert.Equal(t, []schemaIssue{dynamoDocument}, issues["hist"])
+	assert.Empty(t, issues["score"])
+
+	expectedData := []spannerData{
+		{table: "users", cols: []string{"user", "ts", "big", "misc", "ok", "pic", "prefs", "score", "tags"},
+			vals: []interface{}{"ann", int64(1), 1e100, "7", true, []byte{0xbe, 0xef}, `{"on":null,"size":12,"theme":"<dark>"}`, "1.5", `["a","b"]`}},
+		{table: "users", cols: []string{"user", "ts", "hist", "misc", "score"}, vals: []interface{}{"ann", int64(2), `[1,"x"]`, "seven", "2"}},
+		{table: "users", cols: []string{"user", "ts", "misc"}, vals: []interface{}{"bob", int64(3), "false"}},
+	}
+	assert.Equal(t, expectedData, rows)
+	assert.Equal(t, int64(3), conv.stats.rows["users"])
+	assert.Equal(t, statementStat{data: 3}, *conv.stats.statement["Item"])
+}
+
+func TestProcessDynamoDBExport_SampleSize(t *testing.T) {
+	// Only the first value of each attribute is sampled, so the string
+	// isn't seen: it is a bad row, rather than a string in a column of
+	// numbers.
+	export := `{"Item":{"id":{"S":"a"},"n":{"N":"1"}}}
+{"Item":{"id":{"S":"b"},"n":{"S":"2"}}}
+{"Item":{"id":{"S":"c"},"n":{"N":"3.25"}}}
+`
+	conv, rows := runProcessDynamoDBExport(DynamoDBExport{Table: "t", Key: []string{"id"}, SampleSize: 1}, export)
+	assert.Equal(t, ddl.Int64{}, conv.spSchema["t"].ColDefs["n"].T)
+	assert.Equal(t, []spannerData{{table: "t", cols: []string{"id", "n"}, vals: []interface{}{"a", int64(1)}}}, rows)
+	assert.Equal(t, int64(2), conv.stats.badRows["t"])
+	assert.Equal(t, badRowCauses{{reason: BadRowParse, col: "n", typ: "N"}: 2}, conv.stats.badCauses["t"])
+
+	// Sampling all values gives a column of strings.
+	conv, rows = runProcessDynamoDBExport(DynamoDBExport{Table: "t", Key: []string{"id"}}, export)
+	assert.Equal(t, ddl.String{Len: ddl.MaxLength{}}, conv.spSchema["t"].ColDefs["n"].T)
+	assert.Equal(t, "3.25", rows[2].vals[1])
+	assert.Zero(t, len(conv.stats.badRows))
+}
+
+func TestProcessDynamoDBExport_SyntheticKey(t *testing.T) {
+	conv, rows := runProcessDynamoDBExport(DynamoDBExport{Table: "t"}, `{"Item":{"id":{"S":"a"}}}`+"\n")
+	assert.Equal(t, []ddl.IndexKey{{Col: "synth_id"}}, conv.spSchema["t"].Pks)
+	assert.Equal(t, []string{"id", "synth_id"}, rows[0].cols)
+}
+
+func TestProcessDynamoDBExport_Errors(t *testing.T) {
+	conv := MakeConv()
+	conv.SetDynamoDBExport(DynamoDBExport{Table: "t", Key: []string{"pk"}})
+	conv.SetSchemaMode()
+	err := ProcessDynamoDBExport(conv, NewReader(bufio.NewReader(strings.NewReader(`{"Item":{"id":{"S":"a"}}}`)), nil))
+	assert.EqualError(t, err, "key attribute pk isn't in any item of the DynamoDB export")
+
+	conv, _ = runProcessDynamoDBExport(DynamoDBExport{Table: "t"}, `{"id":{"S":"a"}}
+{"Item":{"id":{"X":"a"}}}
+not json
+{"Item":{"id":{"S":"b"}}}
+`)
+	assert.Equal(t, statementStat{data: 1, error: 3}, *conv.stats.statement["Item"])
+	assert.Equal(t, map[string]int64{
+		"Processing DynamoDB item: line doesn't hold just an Item object":                                   1,
+		"Processing DynamoDB item: bad value of attribute id: unknown DynamoDB type X":                      1,
+		"Processing DynamoDB item: can't parse item: invalid character 'o' in literal null (expecting 'u')": 1,
+	}, unexpectedCounts(conv))
+}
+
+func TestDynamoDBExport_Validate(t *testing.T) {
+	for _, tc := range []struct {
+		e   DynamoDBExport
+		err string
+	}{
+		{DynamoDBExport{Table: "t"}, ""},
+		{DynamoDBExport{Table: "t", Key: []string{"a", "b"}, SampleSize: 10}, ""},
+		{DynamoDBExport{}, "no table name specified for the DynamoDB export"},
+		{DynamoDBExport{Table: "t", Key: []string{"a", "b", "c"}}, "bad DynamoDB key a,b,c: expected a partition key attribute and an optional sort key attribute"},
+		{DynamoDBExport{Table: "t", Key: []string{"a", ""}}, "bad DynamoDB key a,: attribute names can't be empty"},
+		{DynamoDBExport{Table: "t", Key: []string{"a", "a"}}, "bad DynamoDB key a,a: the partition and sort keys must be different attributes"},
+		{DynamoDBExport{Table: "t", SampleSize: -1}, "bad DynamoDB sample size -1: must not be negative"},
+	} {
+		err := tc.e.Validate()
+		if tc.err == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.EqualError(t, err, tc.err)
+		}
+	}
+}
+
+func TestProcessDynamoDBExport_Report(t *testing.T) {
+	conv, _ := runProcessDynamoDBExport(DynamoDBExport{Table: "users", Key: []string{"user", "ts"}}, dynamoExportSample)
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	GenerateReport(DynamoDBSource, conv, w, nil)
+	w.Flush()
+	report := b.String()
+	assert.Contains(t, report, "Attribute 'misc' has values of several types (BOOL in 1, N in 1, S in 1 of 3\n   sampled values), so it is mapped to string(max).")
+	assert.Contains(t, report, "Analysis of statements in DynamoDB output")
+}
+
+func runProcessDynamoDBExport(e DynamoDBExport, s string) (*Conv, []spannerData) {
+	conv := MakeConv()
+	conv.SetDynamoDBExport(e)
+	conv.SetSchemaMode()
+	ProcessDynamoDBExport(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	conv.SetDataMode()
+	var rows []spannerData
+	conv.SetDataSink(
+		func(table string, cols []string, vals []interface{}) {
+			rows = append(rows, spannerData{table: table, cols: cols, vals: vals})
+		})
+	ProcessDynamoDBExport(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	return conv, rows
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	nodes "github.com/lfittl/pg_query_go/nodes"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// Spanner has no enum types, so we map columns of PostgreSQL enum
+// types to STRING, with a length that covers the longest label. The
+// restriction to the enum's labels is lost, so we note the allowed
+// values in the report.
+
+// processCreateEnumStmt records the labels of the enum type defined
+// by n (a CREATE TYPE ... AS ENUM statement).
+func processCreateEnumStmt(conv *Conv, n nodes.CreateEnumStmt) {
+	name := qualifiedName(n.TypeName)
+	if name == "" {
+		logStmtError(conv, n, fmt.Errorf("type name is empty"))
+		return
+	}
+	var labels []string
+	for _, v := range n.Vals.Items {
+		s, err := getString(v)
+		if err != nil {
+			logStmtError(conv, n, fmt.Errorf("can't get label of enum %s: %w", name, err))
+			return
+		}
+		labels = append(labels, s)
+	}
+	conv.enums[name] = labels
+	conv.schemaStatement([]nodes.Node{n})
+}
+
+// enumLabels returns the labels of enum type id (as returned by
+// getTypeID), and whether id is a known enum type.
+func (conv *Conv) enumLabels(id string) ([]string, bool) {
+	labels, ok := conv.enums[strings.TrimPrefix(id, "public.")]
+	return labels, ok
+}
+
+// enumType returns the Spanner type for enum labels: a STRING long
+// enough for the longest label.
+func enumType(labels []string) ddl.ScalarType {
+	n := int64(1) // Spanner doesn't allow STRING(0).
+	for _, l := range labels {
+		if m := int64(utf8.RuneCountInString(l)); m > n {
+			n = m
+		}
+	}
+	return ddl.String{Len: ddl.Int64Length{Value: n}}
+}
+
+// describeEnumLabels returns the labels of an enum for reports
+// e.g. "'sad', 'ok', 'happy'".
+func describeEnumLabels(labels []string) string {
+	var l []string
+	for _, s := range labels {
+		l = append(l, "'"+strings.ReplaceAll(s, "'", "''")+"'")
+	}
+	return strings.Join(l, ", ")
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	sp "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestProcessPgDump_Enums(t *testing.T) {
+	conv, rows := runProcessPgDump(
+		"CREATE TYPE public.mood AS ENUM ('sad', 'ok', 'happy');\n" +
+			"CREATE TYPE status AS ENUM ('größer', 'it''s');\n" +
+			"CREATE TYPE other.empty AS ENUM ();\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, m public.mood, s status, h mood[], e other.empty);\n" +
+			"COPY public.t (id, m, s, h, e) FROM stdin;\n" +
+			"1\thappy\tit's\t{sad,ok}\t\\N\n" +
+			"\\.\n")
+	noIssues(conv, t, "enums")
+	assert.Equal(t, map[string][]string{
+		"mood":        {"sad", "ok", "happy"},
+		"status":      {"größer", "it's"},
+		"other.empty": nil,
+	}, conv.enums)
+	ct := conv.spSchema["t"]
+	assert.Equal(t, ddl.String{Len: ddl.Int64Length{Value: 5}}, ct.ColDefs["m"].T)
+	assert.Equal(t, ddl.String{Len: ddl.Int64Length{Value: 6}}, ct.ColDefs["s"].T)
+	assert.Equal(t, ddl.ColumnDef{Name: "h", T: ddl.String{Len: ddl.Int64Length{Value: 5}}, IsArray: true}, stripSchemaComments(conv.spSchema)["t"].ColDefs["h"])
+	assert.Equal(t, ddl.String{Len: ddl.Int64Length{Value: 1}}, ct.ColDefs["e"].T)
+	for _, c := range []string{"m", "s", "h", "e"} {
+		assert.Equal(t, []schemaIssue{enum}, conv.issues["t"][c], c)
+	}
+	assert.Equal(t, []spannerData{{table: "t", cols: []string{"id", "m", "s", "h"},
+		vals: []interface{}{int64(1), "happy", "it's", []sp.NullString{{StringVal: "sad", Valid: true}, {StringVal: "ok", Valid: true}}}}}, rows)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, normalizeSpace(buf.String()), "Column 'm': enum type public.mood is mapped to string(5). Allowed values: 'sad', 'ok', 'happy'. Spanner doesn't restrict the column to these values")
+	assert.Contains(t, normalizeSpace(buf.String()), "Column 's': enum type status is mapped to string(6). Allowed values: 'größer', 'it''s'.")
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// Data can be converted into an existing Spanner database (e.g. one
+// created with Terraform) rather than one created from the generated
+// schema. CheckExistingSchema compares each table of the generated
+// schema with the database, and the rows of tables that don't match
+// are counted as bad rows (with reason BadRowTableMismatch) rather
+// than written, so that one incompatible table doesn't cause a write
+// error for every row, and doesn't stop the other tables loading.
+
+// existingDB records the tables of the generated schema that don't
+// match the existing database.
+type existingDB struct {
+	tables   int                 // Tables in the generated schema.
+	problems map[string][]string // Problems of each table that doesn't match, keyed by Spanner table.
+}
+
+// tableMismatchError is the error of rows that weren't converted
+// because their Spanner table doesn't match the existing database.
+type tableMismatchError struct {
+	table string
+}
+
+func (e *tableMismatchError) Error() string {
+	return fmt.Sprintf("table %s doesn't match the existing Spanner database", e.table)
+}
+
+// CheckExistingSchema compares the tables of the generated Spanner
+// schema with cols, the columns of an existing Spanner database. A
+// table matches if the database has it with the same primary key, and
+// each of its columns with a compatible type: the same type (with
+// STRING and BYTES lengths at least as long) and no NOT NULL
+// constraint that the generated column lacks. The database may have
+// other columns, as long as they are nullable. Rows of tables that
+// don't match are skipped during data conversion, and the problems
+// found are recorded for the report. It must be called after schema
+// conversion, and returns the Spanner tables that don't match, in
+// sorted order.
+func (conv *Conv) CheckExistingSchema(cols []SpannerColumn) []string {
+	db := make(map[string][]SpannerColumn) // Keyed by lower case table name.
+	for _, c := range cols {
+		t := strings.ToLower(c.Table)
+		db[t] = append(db[t], c)
+	}
+	e := &existingDB{tables: len(conv.spSchema), problems: make(map[string][]string)}
+	var mismatched []string
+	for _, t := range conv.SpannerTables() {
+		if l := tableProblems(conv.spSchema[t], db[strings.ToLower(t)]); len(l) > 0 {
+			e.problems[t] = l
+			mismatched = append(mismatched, t)
+		}
+	}
+	conv.existingDB = e
+	return mismatched
+}
+
+// tableProblems returns the ways that table ct differs from dbCols, the
+// columns of the table with the same name in the existing database.
+func tableProblems(ct ddl.CreateTable, dbCols []SpannerColumn) []string {
+	if len(dbCols) == 0 {
+		return []string{"table is not in the existing database"}
+	}
+	var l []string
+	byName := make(map[string]SpannerColumn)
+	for _, c := range dbCols {
+		byName[strings.ToLower(c.Column)] = c
+	}
+	for _, name := range ct.ColNames {
+		cd := ct.ColDefs[name]
+		dbCol, ok := byName[strings.ToLower(name)]
+		delete(byName, strings.ToLower(name))
+		switch {
+		case !ok:
+			l = append(l, fmt.Sprintf("column %s is not in the existing database", name))
+		case !compatibleType(cd, dbCol.Type):
+			l = append(l, fmt.Sprintf("column %s has type %s in the existing database, but %s in the generated schema", name, dbCol.Type, cd.PrintColumnDefType()))
+		case dbCol.NotNull && !cd.NotNull:
+			l = append(l, fmt.Sprintf("column %s is NOT NULL in the existing database, but nullable in the generated schema", name))
+		}
+	}
+	for _, c := range dbCols {
+		if _, ok := byName[strings.ToLower(c.Column)]; ok && c.NotNull {
+			l = append(l, fmt.Sprintf("column %s of the existing database is NOT NULL, but isn't in the generated schema", c.Column))
+		}
+	}
+	var keyCols []SpannerColumn
+	for _, c := range dbCols {
+		if c.KeyPos > 0 {
+			keyCols = append(keyCols, c)
+		}
+	}
+	sort.Slice(keyCols, func(i, j int) bool { return keyCols[i].KeyPos < keyCols[j].KeyPos })
+	var keys []ddl.IndexKey
+	for _, c := range keyCols {
+		keys = append(keys, ddl.IndexKey{Col: c.Column, Desc: c.KeyDesc})
+	}
+	if !sameKeys(ct.Pks, keys) {
+		l = append(l, fmt.Sprintf("primary key is (%s) in the existing database, but (%s) in the generated schema", printKeys(keys), printKeys(ct.Pks)))
+	}
+	return l
+}
+
+// compatibleType returns whether values of cd can be written to a
+// column with Spanner type t (as given by SPANNER_TYPE).
+func compatibleType(cd ddl.ColumnDef, t string) bool {
+	ty, isArray, err := parseColumnDefType(t)
+	if err != nil || isArray != cd.IsArray {
+		return false
+	}
+	switch x := cd.T.(type) {
+	case ddl.String:
+		y, ok := ty.(ddl.String)
+		return ok && lengthFits(x.Len, y.Len)
+	case ddl.Bytes:
+		y, ok := ty.(ddl.Bytes)
+		return ok && lengthFits(x.Len, y.Len)
+	}
+	return ty == cd.T
+}
+
+// lengthFits returns whether values of length at most l fit in max.
+func lengthFits(l, max ddl.Length) bool {
+	m, ok := max.(ddl.Int64Length)
+	if !ok {
+		return true
+	}
+	n, ok := l.(ddl.Int64Length)
+	return ok && n.Value <= m.Value
+}
+
+func sameKeys(a, b []ddl.IndexKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i].Col, b[i].Col) || a[i].Desc != b[i].Desc {
+			return false
+		}
+	}
+	return true
+}
+
+func printKeys(keys []ddl.IndexKey) string {
+	var l []string
+	for _, k := range keys {
+		l = append(l, k.PrintIndexKey(ddl.Config{}))
+	}
+	return strings.Join(l, ", ")
+}
+
+// mismatchedRow is called for each row of srcTable in data mode. If
+// the table's Spanner table doesn't match the existing database, it
+// counts the row (srcCols and vals) as bad and returns true, so that
+// the row is skipped.
+func (conv *Conv) mismatchedRow(srcTable string, srcCols, vals []string) bool {
+	e := conv.existingDB
+	if e == nil {
+		return false
+	}
+	sp, ok := conv.toSpanner[srcTable]
+	if !ok || len(e.problems[sp.name]) == 0 {
+		return false
+	}
+	conv.statsAddBadRow(srcTable, conv.dataMode())
+	conv.CollectBadRow(srcTable, srcCols, vals, &tableMismatchError{table: sp.name})
+	return true
+}
+
+func makeReportExistingDB(conv *Conv) *ReportExistingDB {
+	e := conv.existingDB
+	if e == nil {
+		return nil
+	}
+	r := &ReportExistingDB{Tables: e.tables, Mismatched: []ReportTableMismatch{}}
+	for _, t := range conv.SpannerTables() {
+		if l := e.problems[t]; len(l) > 0 {
+			r.Mismatched = append(r.Mismatched, ReportTableMismatch{Table: t, Problems: l})
+		}
+	}
+	return r
+}
+
+// existingDBSummary returns a note on the comparison of the generated
+// schema with the existing database for the report summary, or "" if
+// data wasn't converted into an existing database.
+func existingDBSummary(conv *Conv) string {
+	e := conv.existingDB
+	if e == nil {
+		return ""
+	}
+	if n := len(e.problems); n > 0 {
+		return fmt.Sprintf("Existing database: %d of %d tables don't match the generated schema, so their rows were not loaded (see the Existing Database section)", n, e.tables)
+	}
+	return fmt.Sprintf("Existing database: all tables (%d) match the generated schema", e.tables)
+}
+
+func writeExistingDB(e *ReportExistingDB, w *bufio.Writer) {
+	writeHeading(w, "Existing Database")
+	justifyLines(w, fmt.Sprintf("Data was converted into an existing Spanner "+
+		"database, whose tables were compared with the generated schema. "+
+		"Tables that match: %d of %d.", e.Tables-len(e.Mismatched), e.Tables), 80, 0)
+	w.WriteString("\n")
+	if len(e.Mismatched) > 0 {
+		justifyLines(w, "The rows of tables that don't match were not loaded "+
+			"(they are counted as bad rows). Fix the tables of the database "+
+			"(or the generated schema, e.g. with a session file) and "+
+			"convert their data again. Tables that don't match:", 80, 0)
+		w.WriteString("\n")
+		for i, m := range e.Mismatched {
+			s := fmt.Sprintf("%d) Table %s:\n", i+1, m.Table)
+			for _, p := range m.Problems {
+				s += fmt.Sprintf("- %s.\n", p)
+			}
+			justifyLines(w, s, 80, 3)
+		}
+	}
+	w.WriteString("\n")
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckExistingSchema(t *testing.T) {
+	conv := MakeConv()
+	conv.SetLocation(time.UTC)
+	conv.now = func() time.Time { return time.Time{} }
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(verifyDump)), nil))
+	// Table t has a column of the wrong type, and an extra NOT NULL
+	// column. Table u matches, with an extra nullable column.
+	mismatched := conv.CheckExistingSchema([]SpannerColumn{
+		{Table: "t", Column: "a", Type: "INT64", NotNull: true, KeyPos: 1},
+		{Table: "t", Column: "b", Type: "STRING(MAX)"},
+		{Table: "t", Column: "c", Type: "INT64", NotNull: true},
+		{Table: "U", Column: "A", Type: "INT64", NotNull: true, KeyPos: 1},
+		{Table: "U", Column: "note", Type: "STRING(100)"},
+	})
+	assert.Equal(t, []string{"t"}, mismatched)
+	var rows []spannerData
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {
+		rows = append(rows, spannerData{table: table, cols: cols, vals: vals})
+	})
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(verifyDump)), nil))
+	assert.Equal(t, []spannerData{
+		{table: "u", cols: []string{"a"}, vals: []interface{}{int64(1)}},
+		{table: "u", cols: []string{"a"}, vals: []interface{}{int64(2)}},
+	}, rows)
+	assert.Equal(t, int64(3), conv.BadRows())
+	assert.Equal(t, "Bad rows: 3 rows not loaded (table doesn't match the existing database)", badRowsMsg(conv.badRowCounts("t", 3, 0)))
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	summary := GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, summary, "Existing database: 1 of 2 tables don't match the generated schema, so their rows were not loaded (see the Existing Database section).\n")
+	assert.Contains(t, buf.String(), "Tables that match: 1 of 2.\n")
+	assert.Contains(t, buf.String(), "1) Table t:\n"+
+		"   - column b has type STRING(MAX) in the existing database, but INT64 in the\n"+
+		"   generated schema.\n"+
+		"   - column c of the existing database is NOT NULL, but isn't in the generated\n"+
+		"   schema.\n")
+
+	buf.Reset()
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, buf, nil))
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, &ReportExistingDB{
+		Tables: 2,
+		Mismatched: []ReportTableMismatch{{Table: "t", Problems: []string{
+			"column b has type STRING(MAX) in the existing database, but INT64 in the generated schema",
+			"column c of the existing database is NOT NULL, but isn't in the generated schema",
+		}}},
+	}, r.ExistingDB)
+
+	conv.CheckExistingSchema([]SpannerColumn{
+		{Table: "t", Column: "a", Type: "INT64", NotNull: true, KeyPos: 1},
+		{Table: "t", Column: "b", Type: "INT64"},
+		{Table: "u", Column: "a", Type: "INT64", NotNull: true, KeyPos: 1},
+	})
+	assert.Empty(t, BuildReport(PgDumpSource, conv, nil).ExistingDB.Mismatched)
+	assert.Contains(t, generateSummary(conv, analyzeTables(conv, nil), nil), "Existing database: all tables (2) match the generated schema.\n")
+}
+
+func TestTableProblems(t *testing.T) {
+	ct := ddl.CreateTable{
+		Name:     "t",
+		ColNames: []string{"id", "name", "tags"},
+		ColDefs: map[string]ddl.ColumnDef{
+			"id":   {Name: "id", T: ddl.Int64{}, NotNull: true},
+			"name": {Name: "name", T: ddl.String{Len: ddl.Int64Length{Value: 50}}},
+			"tags": {Name: "tags", T: ddl.String{Len: ddl.MaxLength{}}, IsArray: true},
+		},
+		Pks: []ddl.IndexKey{{Col: "id"}},
+	}
+	tests := []struct {
+		name     string
+		cols     []SpannerColumn
+		problems []string
+	}{
+		{"match", []SpannerColumn{
+			{Column: "id", Type: "INT64", NotNull: true, KeyPos: 1},
+			{Column: "Name", Type: "STRING(MAX)"},
+			{Column: "tags", Type: "ARRAY<STRING(MAX)>"},
+		}, nil},
+		{"missing table", nil, []string{"table is not in the existing database"}},
+		{"missing column and short string", []SpannerColumn{
+			{Column: "id", Type: "INT64", NotNull: true, KeyPos: 1},
+			{Column: "name", Type: "STRING(20)"},
+		}, []string{
+			"column name has type STRING(20) in the existing database, but STRING(50) in the generated schema",
+			"column tags is not in the existing database",
+		}},
+		{"not an array, and NOT NULL", []SpannerColumn{
+			{Column: "id", Type: "INT64", NotNull: true, KeyPos: 1},
+			{Column: "name", Type: "STRING(50)", NotNull: true},
+			{Column: "tags", Type: "STRING(MAX)"},
+		}, []string{
+			"column name is NOT NULL in the existing database, but nullable in the generated schema",
+			"column tags has type STRING(MAX) in the existing database, but ARRAY<STRING(MAX)> in the generated schema",
+		}},
+		{"different primary key", []SpannerColumn{
+			{Column: "id", Type: "INT64", NotNull: true, KeyPos: 2, KeyDesc: true},
+			{Column: "name", Type: "STRING(50)", KeyPos: 1},
+			{Column: "tags", Type: "ARRAY<STRING(MAX)>"},
+		}, []string{"primary key is (name, id DESC) in the existing database, but (id) in the generated schema"}},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.problems, tableProblems(ct, tc.cols), tc.name)
+	}
+}
@@ -15,7 +15,12 @@
 package internal
 
 import (
+	"fmt"
+	"hash/fnv"
 	"regexp"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
 )
 
 var nameRegexp = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9_]*$")
@@ -40,3 +45,71 @@ func FixName(name string) (string, bool) {
 	name = badOtherChar.ReplaceAllString(name, "_")
 	return name, true
 }
+
+// maxNameLength is the maximum length of Spanner table, column, index
+// and constraint names.
+const maxNameLength = ddl.MaxNameLength
+
+// reservedWords are the reserved keywords of Spanner's SQL dialect,
+// which can't be used as unquoted names.
+var reservedWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`ALL AND ANY ARRAY AS ASC ASSERT_ROWS_MODIFIED AT
+		BETWEEN BY CASE CAST COLLATE CONTAINS CREATE CROSS CUBE CURRENT DEFAULT
+		DEFINE DESC DISTINCT ELSE END ENUM ESCAPE EXCEPT EXCLUDE EXISTS EXTRACT
+		FALSE FETCH FOLLOWING FOR FROM FULL GROUP GROUPING GROUPS HASH HAVING IF
+		IGNORE IN INNER INTERSECT INTERVAL INTO IS JOIN LATERAL LEFT LIKE LIMIT
+		LOOKUP MERGE NATURAL NEW NO NOT NULL NULLS OF ON OR ORDER OUTER OVER
+		PARTITION PRECEDING PROTO RANGE RECURSIVE RESPECT RIGHT ROLLUP ROWS
+		SELECT SET SOME STRUCT TABLESAMPLE THEN TO TREAT TRUE UNBOUNDED UNION
+		UNNEST USING WHEN WHERE WINDOW WITH WITHIN`) {
+		reservedWords[w] = true
+	}
+}
+
+// spannerName maps a source name to a legal Spanner name: it applies
+// FixName, adds "_" to reserved words, and truncates names longer than
+// maxNameLength, adding a hash of the full name so that names with a
+// common prefix stay distinct. Returns the Spanner name and why it was
+// changed (empty if it wasn't). Callers must still resolve clashes with
+// other names (see suffixName).
+func spannerName(name string) (string, string) {
+	var why []string
+	sp, fixed := FixName(name)
+	if fixed {
+		why = append(why, "it has characters that Spanner names can't use")
+	}
+	if reservedWords[strings.ToUpper(sp)] {
+		sp += "_"
+		why = append(why, "it is a Spanner reserved word")
+	}
+	if len(sp) > maxNameLength {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		sp = suffixName(sp, fmt.Sprintf("_%08x", h.Sum32()))
+		why = append(why, fmt.Sprintf("it is longer than %d characters", maxNameLength))
+	}
+	return sp, strings.Join(why, " and ")
+}
+
+// suffixName adds suffix to name, truncating name if needed so that
+// the result is at most maxNameLength long.
+func suffixName(name, suffix string) string {
+	if n := maxNameLength - len(suffix); len(name) > n {
+		name = name[:n]
+	}
+	return name + suffix
+}
+
+// renameReason returns why source name was mapped to Spanner name sp.
+func renameReason(name, sp string) string {
+	want, why := spannerName(name)
+	if want == sp {
+		return why
+	}
+	if why != "" {
+		why += ", and "
+	}
+	return why + "the name clashes with another name"
+}
@@ -15,6 +15,7 @@
 package internal
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,3 +40,31 @@ func TestFixName(t *testing.T) {
 		assert.Equal(t, tc.changed, c, tc.name)
 	}
 }
+
+func TestSpannerName(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	tests := []struct {
+		name     string
+		in       string
+		expected string
+		why      string
+	}{
+		{"good", "mytable", "mytable", ""},
+		{"fixed", "my table", "my_table", "it has characters that Spanner names can't use"},
+		{"reserved", "order", "order_", "it is a Spanner reserved word"},
+		{"reserved mixed case", "Select", "Select_", "it is a Spanner reserved word"},
+		{"reserved after fix", "by\n", "by_", "it has characters that Spanner names can't use"},
+		{"at limit", strings.Repeat("a", 128), strings.Repeat("a", 128), ""},
+		{"too long", long, strings.Repeat("a", 119) + "_4e48052d", "it is longer than 128 characters"},
+		{"too long, same prefix", long[1:] + "b", strings.Repeat("a", 119) + "_4b480074", "it is longer than 128 characters"},
+		{"too long and fixed", "-" + long[1:], "A" + strings.Repeat("a", 118) + "_48648bb1", "it has characters that Spanner names can't use and it is longer than 128 characters"},
+	}
+	for _, tc := range tests {
+		n, why := spannerName(tc.in)
+		assert.Equal(t, tc.expected, n, tc.name)
+		assert.Equal(t, tc.why, why, tc.name)
+		assert.True(t, len(n) <= maxNameLength, tc.name)
+	}
+	assert.Equal(t, strings.Repeat("a", 126)+"_1", suffixName(long, "_1"))
+	assert.Equal(t, "ab_1", suffixName("ab", "_1"))
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"math"
+
+	sp "cloud.google.com/go/spanner"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// FloatPolicy determines what happens to special values converted to
+// FLOAT64: NaN, infinities, and values beyond FLOAT64's range (which
+// parse as infinities).
+type FloatPolicy string
+
+// Float policies.
+const (
+	FloatReject FloatPolicy = "reject" // The row is a bad row (the default).
+	FloatNull   FloatPolicy = "null"   // The value is NULL, or the row is a bad row if the column is NOT NULL.
+	FloatClamp  FloatPolicy = "clamp"  // Infinities become ±math.MaxFloat64; NaN is handled as for FloatNull.
+)
+
+// ParseFloatPolicy returns the policy named s (empty for the default).
+func ParseFloatPolicy(s string) (FloatPolicy, error) {
+	switch x := FloatPolicy(s); x {
+	case "":
+		return FloatReject, nil
+	case FloatReject, FloatNull, FloatClamp:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown float policy %q: expected reject, null or clamp", s)
+}
+
+// SetFloatPolicy configures what happens to NaN, infinite and
+// out-of-range values converted to FLOAT64.
+func (conv *Conv) SetFloatPolicy(p FloatPolicy) {
+	conv.floatPolicy = p
+}
+
+func (conv *Conv) floats() FloatPolicy {
+	if conv.floatPolicy == "" {
+		return FloatReject
+	}
+	return conv.floatPolicy
+}
+
+// floatError is the error for special FLOAT64 values that the float
+// policy rejects. Rows with such values are bad rows with reason
+// BadRowFloat.
+type floatError struct {
+	col string
+	val string
+}
+
+func (e *floatError) Error() string {
+	return fmt.Sprintf("can't convert to float64: value %q of column %s is NaN, infinite or out of range", e.val, e.col)
+}
+
+// fitFloat applies the float policy to converted value x of a column of
+// Spanner type t: a float64 or (for arrays) a slice of sp.NullFloat64.
+// val is the source value, for errors. It returns null if the value
+// should be written as NULL. Special values are counted, whatever the
+// policy does with them. Array elements are always nullable, so the
+// policy never rejects them unless it is FloatReject.
+func (conv *Conv) fitFloat(srcTable, srcCol string, t ddl.ScalarType, notNull bool, x interface{}, val string) (y interface{}, null bool, err error) {
+	if _, ok := t.(ddl.Float64); !ok {
+		return x, false, nil
+	}
+	// fit returns f's replacement, with ok false if it is NULL.
+	fit := func(f float64, nullable bool) (float64, bool, error) {
+		if !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f, true, nil
+		}
+		conv.addFloatSpecial(srcTable, srcCol)
+		p := conv.floats()
+		switch {
+		case p == FloatClamp && math.IsInf(f, 1):
+			return math.MaxFloat64, true, nil
+		case p == FloatClamp && math.IsInf(f, -1):
+			return -math.MaxFloat64, true, nil
+		case p != FloatReject && nullable:
+			return 0, false, nil
+		}
+		return f, false, &floatError{srcCol, val}
+	}
+	switch v := x.(type) {
+	case float64:
+		f, ok, err := fit(v, !notNull)
+		if err != nil {
+			return x, false, err
+		}
+		return f, !ok, nil
+	case []sp.NullFloat64:
+		for i := range v {
+			if !v[i].Valid {
+				continue
+			}
+			f, ok, err := fit(v[i].Float64, true)
+			if err != nil {
+				return x, false, err
+			}
+			v[i] = sp.NullFloat64{Float64: f, Valid: ok}
+		}
+	}
+	return x, false, nil
+}
+
+func (conv *Conv) addFloatSpecial(srcTable, srcCol string) {
+	if conv.floatSpecials[srcTable] == nil {
+		conv.floatSpecials[srcTable] = make(map[string]int64)
+	}
+	conv.floatSpecials[srcTable][srcCol]++
+}
+
+// describeFloatSpecial describes the NaN, infinite and out-of-range
+// values of srcCol of srcTable, and what the float policy did with
+// them, for reports.
+func (conv *Conv) describeFloatSpecial(srcTable, srcCol string, notNull bool) string {
+	n := conv.floatSpecials[srcTable][srcCol]
+	what := "values were"
+	if n == 1 {
+		what = "value was"
+	}
+	const rejected = "rejected (their rows are bad rows)"
+	var action string
+	switch p := conv.floats(); {
+	case p == FloatNull && !notNull:
+		action = "written as NULL"
+	case p == FloatNull:
+		action = rejected + ", since the column is NOT NULL"
+	case p == FloatClamp && !notNull:
+		action = "clamped to FLOAT64's range (NaN values were written as NULL)"
+	case p == FloatClamp:
+		action = "clamped to FLOAT64's range (rows with NaN values are bad rows, since the column is NOT NULL)"
+	default:
+		action = rejected
+	}
+	return fmt.Sprintf("Column '%s': %d %s NaN, infinite or beyond FLOAT64's range, and %s (float policy %s)", srcCol, n, what, action, conv.floats())
+}
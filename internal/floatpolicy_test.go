@@ -0,0 +1,107 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFloatPolicy(t *testing.T) {
+	p, err := ParseFloatPolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, FloatReject, p)
+	p, err = ParseFloatPolicy("clamp")
+	assert.Nil(t, err)
+	assert.Equal(t, FloatClamp, p)
+	_, err = ParseFloatPolicy("round")
+	assert.NotNil(t, err)
+}
+
+func TestProcessPgDump_FloatPolicy(t *testing.T) {
+	const dump = "CREATE TABLE t (id bigint PRIMARY KEY, f double precision, g real NOT NULL, a float8[]);\n" +
+		"COPY public.t (id, f, g, a) FROM stdin;\n" +
+		"1\t1.5\t2\t{1,NaN}\n" +
+		"2\tNaN\t0\t\\N\n" +
+		"3\tInfinity\t0\t\\N\n" +
+		"4\t1e400\t-Infinity\t\\N\n" +
+		"5\t0\tNaN\t\\N\n" +
+		"\\.\n"
+	tests := []struct {
+		policy  FloatPolicy
+		rows    []spannerData
+		badRows int64
+		g       int64 // Special values counted for g (a row is rejected at its first).
+		report  string
+	}{
+		{
+			policy:  FloatReject,
+			badRows: 5,
+			g:       1,
+			report:  "Column 'f': 3 values were NaN, infinite or beyond FLOAT64's range, and rejected (their rows are bad rows) (float policy reject)",
+		},
+		{
+			policy: FloatNull,
+			rows: []spannerData{
+				{table: "t", cols: []string{"id", "f", "g", "a"}, vals: []interface{}{int64(1), float64(1.5), float64(2), []spanner.NullFloat64{{Float64: 1, Valid: true}, {Valid: false}}}},
+				{table: "t", cols: []string{"id", "g"}, vals: []interface{}{int64(2), float64(0)}},
+				{table: "t", cols: []string{"id", "g"}, vals: []interface{}{int64(3), float64(0)}},
+			},
+			badRows: 2,
+			g:       2,
+			report:  "Column 'g': 2 values were NaN, infinite or beyond FLOAT64's range, and rejected (their rows are bad rows), since the column is NOT NULL (float policy null)",
+		},
+		{
+			policy: FloatClamp,
+			rows: []spannerData{
+				{table: "t", cols: []string{"id", "f", "g", "a"}, vals: []interface{}{int64(1), float64(1.5), float64(2), []spanner.NullFloat64{{Float64: 1, Valid: true}, {Valid: false}}}},
+				{table: "t", cols: []string{"id", "g"}, vals: []interface{}{int64(2), float64(0)}},
+				{table: "t", cols: []string{"id", "f", "g"}, vals: []interface{}{int64(3), math.MaxFloat64, float64(0)}},
+				{table: "t", cols: []string{"id", "f", "g"}, vals: []interface{}{int64(4), math.MaxFloat64, -math.MaxFloat64}},
+			},
+			badRows: 1,
+			g:       2,
+			report:  "Column 'f': 3 values were NaN, infinite or beyond FLOAT64's range, and clamped to FLOAT64's range (NaN values were written as NULL) (float policy clamp)",
+		},
+	}
+	for _, tc := range tests {
+		conv := MakeConv()
+		conv.SetFloatPolicy(tc.policy)
+		var buf bytes.Buffer
+		conv.SetBadRowWriter(NewBadRowWriter(&buf, "bad.jsonl", 1000))
+		conv, rows := runProcessPgDumpConv(conv, dump)
+		assert.Equal(t, tc.rows, rows, string(tc.policy))
+		assert.Equal(t, tc.badRows, conv.BadRows(), string(tc.policy))
+		assert.Equal(t, map[string]map[string]int64{"t": {"f": 3, "g": tc.g, "a": 1}}, conv.floatSpecials, string(tc.policy))
+		for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var r badRowRecord
+			assert.Nil(t, json.Unmarshal([]byte(l), &r))
+			assert.Equal(t, BadRowFloat, r.Reason, string(tc.policy))
+		}
+		assert.Equal(t, int64(3), buildTableReport(conv, "t", nil).warnings, string(tc.policy))
+		b := new(bytes.Buffer)
+		w := bufio.NewWriter(b)
+		GenerateReport(PgDumpSource, conv, w, nil)
+		w.Flush()
+		assert.Contains(t, normalizeSpace(b.String()), tc.report, string(tc.policy))
+	}
+}
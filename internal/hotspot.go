@@ -0,0 +1,71 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+)
+
+// Spanner splits tables into key ranges, and serves each range from a
+// single server. When the first primary key column increases over time
+// (e.g. it is a serial or a creation timestamp), new rows all go to the
+// end of the table, and so all writes go to a single server (a
+// hotspot). The hotspot check looks for such keys in the source
+// schema. Synthetic primary keys are covered separately (see
+// SyntheticPKStrategy).
+
+// hotspotKey describes a primary key whose first column increases
+// over time.
+type hotspotKey struct {
+	col    string // First primary key column.
+	reason string // Why its values increase, for the report e.g. "it has type serial".
+}
+
+// detectHotspotKey returns the first primary key column of srcTable if
+// its values increase over time.
+func (conv *Conv) detectHotspotKey(srcTable string) (hotspotKey, bool) {
+	t := conv.srcSchema[srcTable]
+	// Note that descending keys hotspot just the same: new rows go to
+	// the start of the table instead.
+	if len(t.PrimaryKeys) == 0 {
+		return hotspotKey{}, false
+	}
+	c := t.ColDefs[t.PrimaryKeys[0].Column]
+	if reason := increasingReason(c); reason != "" {
+		return hotspotKey{col: c.Name, reason: reason}, true
+	}
+	return hotspotKey{}, false
+}
+
+// increasingReason returns why the values of column c increase over
+// time, or "" if they don't (as far as we can tell).
+func increasingReason(c schema.Column) string {
+	name := strings.ToLower(c.Type.Name)
+	switch {
+	case len(c.Type.ArrayBounds) > 0:
+		return ""
+	case name == "serial" || name == "bigserial" || name == "smallserial" || name == "serial2" || name == "serial4" || name == "serial8":
+		return "it has type " + c.Type.Name
+	case c.Ignored.Identity:
+		return "it is an autoincrementing (identity) column"
+	case c.Ignored.Sequence:
+		return "its default is the next value of a sequence"
+	case c.Ignored.Now && (strings.HasPrefix(name, "timestamp") || name == "datetime"):
+		return "it is a timestamp whose default is the current time"
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectHotspotKey(t *testing.T) {
+	tc := []struct {
+		name     string
+		input    string
+		mysql    bool
+		expected *hotspotKey // Nil if no hotspot.
+	}{
+		{
+			name:     "serial",
+			input:    "CREATE TABLE t (id serial PRIMARY KEY, a text);\n",
+			expected: &hotspotKey{"id", "it has type serial"},
+		},
+		{
+			name:     "bigserial",
+			input:    "CREATE TABLE t (id bigserial, a text, PRIMARY KEY (id, a));\n",
+			expected: &hotspotKey{"id", "it has type bigserial"},
+		},
+		{
+			name: "pg_dump serial",
+			input: "CREATE TABLE public.t (id integer NOT NULL, a text);\n" +
+				"ALTER TABLE ONLY public.t ALTER COLUMN id SET DEFAULT nextval('public.t_id_seq'::regclass);\n" +
+				"ALTER TABLE ONLY public.t ADD CONSTRAINT t_pkey PRIMARY KEY (id);\n",
+			expected: &hotspotKey{"id", "its default is the next value of a sequence"},
+		},
+		{
+			name:     "identity",
+			input:    "CREATE TABLE t (id bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY, a text);\n",
+			expected: &hotspotKey{"id", "it is an autoincrementing (identity) column"},
+		},
+		{
+			name: "pg_dump identity",
+			input: "CREATE TABLE public.t (id bigint NOT NULL, a text);\n" +
+				"ALTER TABLE public.t ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (SEQUENCE NAME public.t_id_seq START WITH 1 INCREMENT BY 1);\n" +
+				"ALTER TABLE ONLY public.t ADD CONSTRAINT t_pkey PRIMARY KEY (id);\n",
+			expected: &hotspotKey{"id", "it is an autoincrementing (identity) column"},
+		},
+		{
+			name:     "timestamp default now()",
+			input:    "CREATE TABLE t (created timestamp with time zone DEFAULT now(), id bigint, PRIMARY KEY (created, id));\n",
+			expected: &hotspotKey{"created", "it is a timestamp whose default is the current time"},
+		},
+		{
+			name:     "timestamp default CURRENT_TIMESTAMP",
+			input:    "CREATE TABLE t (created timestamp DEFAULT CURRENT_TIMESTAMP PRIMARY KEY);\n",
+			expected: &hotspotKey{"created", "it is a timestamp whose default is the current time"},
+		},
+		{
+			name:  "timestamp without default",
+			input: "CREATE TABLE t (created timestamp PRIMARY KEY);\n",
+		},
+		{
+			name:  "other default",
+			input: "CREATE TABLE t (id bigint DEFAULT 42 PRIMARY KEY);\n",
+		},
+		{
+			name:  "serial not first in key",
+			input: "CREATE TABLE t (tenant text, id serial, PRIMARY KEY (tenant, id));\n",
+		},
+		{
+			name:  "no primary key",
+			input: "CREATE TABLE t (id serial);\n",
+		},
+		{
+			name:     "mysql auto_increment",
+			input:    "CREATE TABLE `t` (`id` int NOT NULL AUTO_INCREMENT, `a` text, PRIMARY KEY (`id`));\n",
+			mysql:    true,
+			expected: &hotspotKey{"id", "it is an autoincrementing (identity) column"},
+		},
+		{
+			name:     "mysql timestamp default current_timestamp",
+			input:    "CREATE TABLE `t` (`created` timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3), `id` int NOT NULL, PRIMARY KEY (`created`, `id`));\n",
+			mysql:    true,
+			expected: &hotspotKey{"created", "it is a timestamp whose default is the current time"},
+		},
+		{
+			name:  "mysql datetime with constant default",
+			input: "CREATE TABLE `t` (`created` datetime NOT NULL DEFAULT '2020-01-01 00:00:00', PRIMARY KEY (`created`));\n",
+			mysql: true,
+		},
+	}
+	for _, tc := range tc {
+		var conv *Conv
+		if tc.mysql {
+			conv, _ = runProcessMySQLDump(tc.input)
+		} else {
+			conv, _ = runProcessPgDump(tc.input)
+		}
+		h, ok := conv.detectHotspotKey("t")
+		if tc.expected == nil {
+			assert.False(t, ok, tc.name)
+			continue
+		}
+		assert.True(t, ok, tc.name)
+		assert.Equal(t, *tc.expected, h, tc.name)
+	}
+}
+
+func TestInfoSchemaDefaultKind(t *testing.T) {
+	tc := []struct {
+		d             string
+		sequence, now bool
+	}{
+		{"nextval('t_id_seq'::regclass)", true, false},
+		{"now()", false, true},
+		{"CURRENT_TIMESTAMP", false, true},
+		{"clock_timestamp()", false, true},
+		{"'2020-01-01 00:00:00'::timestamp without time zone", false, false},
+		{"42", false, false},
+	}
+	for _, tc := range tc {
+		sequence, now := infoSchemaDefaultKind(tc.d)
+		assert.Equal(t, tc.sequence, sequence, tc.d)
+		assert.Equal(t, tc.now, now, tc.d)
+	}
+}
+
+func TestReport_Hotspot(t *testing.T) {
+	conv := MakeConv()
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(
+		"CREATE TABLE t (id serial PRIMARY KEY, a text);\n"+
+			"CREATE TABLE u (created timestamptz DEFAULT now() PRIMARY KEY, a text);\n"+
+			"CREATE TABLE v (id bigint PRIMARY KEY, a text);\n")), nil))
+	// The serial column already has a warning, so the hotspot warning
+	// doesn't add to the count.
+	tr := buildTableReport(conv, "t", nil)
+	assert.Equal(t, int64(1), tr.warnings)
+	assert.Equal(t, "id", tr.hotspotKey)
+	assert.Equal(t, reportLine{hotspot, []string{"id"}, "Column 'id' is the first primary key column, and it has type serial, " +
+		"so new rows are all written to the end of the table (a hotspot). " + issueDB[hotspot].brief}, tr.body[0].lines[1])
+	tr = buildTableReport(conv, "u", nil)
+	assert.Equal(t, int64(2), tr.warnings) // Hotspot and default value.
+	assert.Equal(t, "created", tr.hotspotKey)
+
+	reports := analyzeTables(conv, nil)
+	assert.Equal(t, []string{"t", "u"}, hotspotTables(reports))
+	assert.Contains(t, generateSummary(conv, reports, nil), "Tables at risk of hotspots: 2 (")
+}
@@ -0,0 +1,363 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GenerateHTMLReport writes an HTML version of the report generated by
+// GenerateReport to w. It is intended for schemas with many tables,
+// which are hard to navigate in report.txt: it starts with a summary
+// and a table of contents listing every table with its ratings, and
+// each table's details (warnings, notes, column statistics) are in
+// collapsible sections. banner is shown at the top of the page.
+func GenerateHTMLReport(src Source, conv *Conv, w io.Writer, badWrites map[string]int64, banner string) error {
+	reports := analyzeTables(conv, badWrites)
+	s := summarize(conv, reports, badWrites)
+	r := htmlReport{
+		Banner:     strings.TrimSpace(banner),
+		Format:     inputFormatMsg(string(conv.inputFormat)),
+		Dialect:    dialectMsg(string(conv.Dialect())),
+		Writes:     writeOptionsMsg(string(conv.writePriority), conv.writeTag),
+		Overrides:  issueOverridesMsg(conv.suppressedIssues(), conv.issueSeverities()),
+		Schema:     makeHTMLRating(rateSchema(s.schema, true, conv.ratingThresholds())),
+		Data:       makeHTMLRating(rateData(s.rows, s.badRows, s.dataSkipped, conv.WrittenTo(), conv.ratingThresholds())),
+		Time:       formatThroughput(conv.totalTiming()),
+		Storage:    storageMsg(conv.totalStorage()),
+		Sampling:   samplingSummary(conv),
+		Hotspots:   hotspotSummary(reports),
+		Verified:   verifySummary(verification(conv, badWrites)),
+		Verify:     makeHTMLVerify(conv, badWrites),
+		Issues:     reportIssueSummary(conv, reports),
+		Ignored:    ignoredStatements(conv),
+		Mismatch:   conv.mismatches,
+		Dropped:    makeHTMLDropped(conv),
+		BadRows:    badRowsFileSummary(conv),
+		SourceName: src.Name,
+		HasStmts:   src.Statements,
+		Reparsed:   conv.stats.reparsed,
+	}
+	if src.Queries {
+		r.Queries = makeReportQueryStats(conv)
+	}
+	if src.Statements {
+		var stmts []string
+		for s := range conv.stats.statement {
+			stmts = append(stmts, s)
+		}
+		sort.Strings(stmts)
+		for _, s := range stmts {
+			x := conv.stats.statement[s]
+			r.Statements = append(r.Statements, ReportStatement{s, x.schema, x.data, x.skip, x.error})
+		}
+		r.DumpFiles = conv.dumpFileStats()
+	}
+	for i, t := range reports {
+		r.Tables = append(r.Tables, makeHTMLTable(conv, i, t))
+	}
+	if u := conv.usage; u != nil {
+		r.Usage = [][2]string{
+			{"Peak memory (RSS)", formatBytes(u.PeakRSS)},
+			{"Peak Go heap", formatBytes(u.PeakHeap)},
+			{"Peak goroutines", fmt.Sprintf("%d", u.PeakGoroutines)},
+			{"Bytes read from input", formatBytes(u.BytesRead)},
+			{"Bytes written to temp files", formatBytes(u.TempFileBytes)},
+		}
+	}
+	r.Unexpected = reportUnexpected(conv)
+	return htmlReportTemplate.Execute(w, r)
+}
+
+type htmlReport struct {
+	Banner     string
+	Format     string // Format of the dump (empty if not recorded).
+	Dialect    string // Dialect of the Spanner database (empty for GoogleSQL).
+	Writes     string // Priority and tag of writes to Spanner (empty if not set).
+	Overrides  string // Schema issues suppressed or with overridden severities (empty if none).
+	Schema     htmlRating
+	Data       htmlRating
+	Time       string // Data conversion time and throughput (empty if unknown).
+	Storage    string // Estimated Spanner storage of all tables (empty if unknown).
+	Sampling   string // Summary of row sampling (empty if all rows were converted).
+	Hotspots   string // Summary of tables whose primary keys may hotspot (empty if none).
+	Verified   string // Summary of the verification pass (empty if none).
+	Ignored    []string
+	Mismatch   []string // Problems found applying a session file or existing Spanner schema.
+	BadRows    []string // Summary of the bad-rows file (if any).
+	SourceName string   // e.g. "pg_dump".
+	HasStmts   bool     // Whether there are statement stats.
+	Statements []ReportStatement
+	DumpFiles  []ReportDumpFile  // Statements by dump file, when several were converted together.
+	Queries    *ReportQueryStats // Nil unless there are query stats.
+	Dropped    []htmlDroppedGroup
+	Verify     []htmlVerifyRow
+	Issues     []ReportIssueCount // Schema issues aggregated over all tables (see issueSummary).
+	Tables     []htmlTable
+	Usage      [][2]string
+	Unexpected []ReportUnexpected
+	Reparsed   int64
+}
+
+type htmlDroppedGroup struct {
+	Heading string // e.g. "Views".
+	Objects []htmlDroppedObject
+}
+
+type htmlDroppedObject struct {
+	Description string
+	SQL         string // Possibly truncated.
+	Comment     string // Source comment, if any.
+}
+
+func makeHTMLDropped(conv *Conv) []htmlDroppedGroup {
+	var l []htmlDroppedGroup
+	for _, g := range droppedGroups(conv) {
+		hg := htmlDroppedGroup{Heading: g.heading}
+		for _, d := range g.objects {
+			hg.Objects = append(hg.Objects, htmlDroppedObject{describeDropped(d), d.sql, d.comment})
+		}
+		l = append(l, hg)
+	}
+	return l
+}
+
+type htmlVerifyRow struct {
+	SrcTable string
+	Source   string // "?" if the count failed.
+	Report   int64
+	Spanner  string // "?" if the count failed.
+	Mismatch bool
+}
+
+func makeHTMLVerify(conv *Conv, badWrites map[string]int64) []htmlVerifyRow {
+	var l []htmlVerifyRow
+	for _, v := range verification(conv, badWrites) {
+		l = append(l, htmlVerifyRow{v.srcTable, formatVerifyCount(v.source), v.report, formatVerifyCount(v.spanner), v.mismatch})
+	}
+	return l
+}
+
+type htmlRating struct {
+	Category    string // e.g. "GOOD".
+	Description string // Full rating e.g. "GOOD (most columns mapped cleanly)".
+}
+
+type htmlTable struct {
+	ID            string // HTML anchor for the table's section.
+	SrcTable      string
+	SpTable       string
+	Schema        htmlRating
+	Data          htmlRating
+	Time          string // Data conversion time and throughput (empty if unknown).
+	Storage       string // Estimated Spanner storage (empty if unknown).
+	Sampled       string // Row sampling note (empty if all rows were converted).
+	TooLarge      string // Rows that exceed Spanner's commit size limit (empty if none).
+	BadRows       string // Rows written to the bad-rows file (empty if none).
+	InternalError string
+	Sections      []htmlSection
+	ColStats      []columnStatsSummary
+}
+
+type htmlSection struct {
+	Heading string
+	Open    bool // Whether the section is expanded by default.
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Text   string
+	DocURL string // Link to the issue's documentation (empty if none, or already linked in this table).
+}
+
+func makeHTMLRating(r Rating, desc string) htmlRating {
+	return htmlRating{Category: r.String(), Description: desc}
+}
+
+func makeHTMLTable(conv *Conv, i int, t tableReport) htmlTable {
+	var badRowsPath string
+	if conv.badRowsOut != nil {
+		badRowsPath = conv.badRowsOut.name
+	}
+	ht := htmlTable{
+		// Table names can contain arbitrary characters, so use
+		// the table's position for anchors.
+		ID:            fmt.Sprintf("table-%d", i+1),
+		SrcTable:      t.srcTable,
+		SpTable:       t.spTable,
+		Schema:        makeHTMLRating(rateSchema(t.schemaCounts(), false, conv.ratingThresholds())),
+		Data:          makeHTMLRating(rateData(t.rows, t.badRows, t.dataSkipped, conv.WrittenTo(), conv.ratingThresholds())),
+		Time:          formatThroughput(t.timing, t.rows),
+		Storage:       storageMsg(t.storage),
+		Sampled:       samplingMsg(conv.rowSampling(), t.rows, t.unsampled),
+		TooLarge:      tooLargeMsg(t.tooLargeRows),
+		BadRows:       badRowsLoggedMsg(badRowsPath, t.badRowsLogged),
+		InternalError: t.internalError,
+		ColStats:      t.colStats,
+	}
+	if t.internalError != "" {
+		return ht
+	}
+	docs := make(docLinker)
+	for _, b := range t.body {
+		sec := htmlSection{Heading: b.heading, Open: strings.HasPrefix(b.heading, "Warning")}
+		for _, l := range b.lines {
+			sec.Lines = append(sec.Lines, htmlLine{l.text, docs.url(l.issue.String(), issueDocURL(l.issue))})
+		}
+		ht.Sections = append(ht.Sections, sec)
+	}
+	return ht
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"lower": strings.ToLower,
+	"pct":   func(f float64) string { return fmt.Sprintf("%.1f%%", 100*f) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>HarbourBridge Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+td.num { text-align: right; }
+summary { cursor: pointer; }
+details.table { margin: 0.5em 0; }
+details.table > summary { font-weight: bold; }
+details.table > div { margin-left: 1.5em; }
+.excellent { color: #137333; }
+.good { color: #1e8e3e; }
+.ok { color: #b06000; }
+.poor, .inconsistent { color: #c5221f; }
+.none, .skipped { color: #5f6368; }
+</style>
+</head>
+<body>
+<h1>HarbourBridge Report</h1>
+{{with .Banner}}<p>{{.}}</p>
+{{end}}{{with .Format}}<p>{{.}}.</p>
+{{end}}{{with .Dialect}}<p>{{.}}.</p>
+{{end}}{{with .Writes}}<p>{{.}}.</p>
+{{end}}{{with .Overrides}}<p>{{.}}.</p>
+{{end}}
+<h2>Summary of Conversion</h2>
+<p>Schema conversion: <span class="{{lower .Schema.Category}}">{{.Schema.Description}}</span>.<br>
+Data conversion: <span class="{{lower .Data.Category}}">{{.Data.Description}}</span>.</p>
+{{with .Time}}<p>Data conversion time: {{.}}.</p>
+{{end}}{{with .Storage}}<p>{{.}} (all tables).</p>
+{{end}}{{with .Sampling}}<p>{{.}}.</p>
+{{end}}{{with .Hotspots}}<p>{{.}}.</p>
+{{end}}{{with .Verified}}<p>{{.}}.</p>
+{{end}}{{range .BadRows}}<p>{{.}}.</p>
+{{end}}{{with .Ignored}}<p>Note that the following source DB statements were detected but ignored: {{range $i, $s := .}}{{if $i}}, {{end}}{{$s}}{{end}}.</p>
+{{end}}{{with .Mismatch}}<h2>Schema Mismatch</h2>
+<p>The Spanner schema (from a session file, or from the existing Spanner database for a data-only conversion) doesn't match the source database, so data conversion was not run. Either fix the session file or Spanner database, or regenerate them by re-running schema conversion. Problems found:</p>
+<ol>
+{{range .}}<li>{{.}}</li>
+{{end}}</ol>
+{{end}}{{with .Verify}}<h2>Verification</h2>
+<p>Row counts after data conversion: rows in the source database (for dumps, rows in the dump), rows this report counts as written to Spanner, and rows in the Spanner table.</p>
+<table>
+<tr><th>Table</th><th>source</th><th>report</th><th>spanner</th><th></th></tr>
+{{range .}}<tr><td>{{.SrcTable}}</td><td class="num">{{.Source}}</td><td class="num">{{.Report}}</td><td class="num">{{.Spanner}}</td><td>{{if .Mismatch}}<span class="poor">MISMATCH</span>{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{with .Issues}}<h2>Issues by Type</h2>
+<p>Schema conversion issues aggregated over all tables, most common first. Each table's details describe its issues.</p>
+<table>
+<tr><th>tables</th><th>columns</th><th>severity</th><th>issue</th></tr>
+{{range .}}<tr><td class="num">{{.Tables}}</td><td class="num">{{.Columns}}</td><td>{{.Severity}}</td><td>{{if .DocURL}}<a href="{{.DocURL}}">{{.Issue}}</a>{{else}}{{.Issue}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Tables</h2>
+<table>
+<tr><th>Table</th><th>Schema conversion</th><th>Data conversion</th></tr>
+{{range .Tables}}<tr><td><a href="#{{.ID}}">{{.SrcTable}}</a></td><td class="{{lower .Schema.Category}}">{{.Schema.Category}}</td><td class="{{lower .Data.Category}}">{{.Data.Category}}</td></tr>
+{{end}}</table>
+<h2>Table Details</h2>
+{{range .Tables}}<details class="table" id="{{.ID}}">
+<summary>Table {{.SrcTable}}{{if ne .SrcTable .SpTable}} (mapped to Spanner table {{.SpTable}}){{end}}</summary>
+<div>
+<p>Schema conversion: <span class="{{lower .Schema.Category}}">{{.Schema.Description}}</span>.<br>
+Data conversion: <span class="{{lower .Data.Category}}">{{.Data.Description}}</span>.</p>
+{{with .Time}}<p>Time: {{.}}.</p>
+{{end}}{{with .Storage}}<p>{{.}}.</p>
+{{end}}{{with .Sampled}}<p>{{.}}.</p>
+{{end}}{{with .TooLarge}}<p>{{.}}.</p>
+{{end}}{{with .BadRows}}<p>{{.}}.</p>
+{{end}}{{with .InternalError}}<p>Internal error: {{.}}</p>
+{{end}}{{range .Sections}}<details{{if .Open}} open{{end}}>
+<summary>{{.Heading}}</summary>
+<ol>
+{{range .Lines}}<li>{{.Text}}{{with .DocURL}} (see <a href="{{.}}">docs</a>){{end}}.</li>
+{{end}}</ol>
+</details>
+{{end}}{{with .ColStats}}<details>
+<summary>Column statistics</summary>
+<table>
+<tr><th>column</th><th>null%</th><th>distinct (approx)</th></tr>
+{{range .}}<tr><td>{{.Col}}</td><td class="num">{{pct .NullFrac}}</td><td class="num">{{.Distinct}}</td></tr>
+{{end}}</table>
+</details>
+{{end}}</div>
+</details>
+{{end}}{{if .HasStmts}}<h2>Statements Processed</h2>
+<p>Analysis of statements in {{.SourceName}} output, broken down by statement type.</p>
+<table>
+<tr><th>statement</th><th>schema</th><th>data</th><th>skip</th><th>error</th></tr>
+{{range .Statements}}<tr><td>{{.Statement}}</td><td class="num">{{.Schema}}</td><td class="num">{{.Data}}</td><td class="num">{{.Skip}}</td><td class="num">{{.Error}}</td></tr>
+{{end}}</table>
+{{with .DumpFiles}}<p>Statements by dump file:</p>
+<table>
+<tr><th>file</th><th>schema</th><th>data</th><th>skip</th><th>error</th></tr>
+{{range .}}<tr><td>{{.File}}</td><td class="num">{{.Schema}}</td><td class="num">{{.Data}}</td><td class="num">{{.Skip}}</td><td class="num">{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{with .Queries}}<h2>Queries Processed</h2>
+<p>Analysis of queries of the {{$.SourceName}} database.</p>
+<table>
+<tr><td>tables read</td><td class="num">{{.TablesRead}}</td></tr>
+<tr><td>columns read</td><td class="num">{{.ColumnsRead}}</td></tr>
+<tr><td>queries issued</td><td class="num">{{.Queries}}</td></tr>
+<tr><td>query errors</td><td class="num">{{.Errors}}</td></tr>
+</table>
+{{with .Schemas}}<p>Queries about tables, by source schema:</p>
+<table>
+<tr><th>schema</th><th>tables</th><th>columns</th><th>queries</th><th>errors</th></tr>
+{{range .}}<tr><td>{{.Schema}}</td><td class="num">{{.TablesRead}}</td><td class="num">{{.ColumnsRead}}</td><td class="num">{{.Queries}}</td><td class="num">{{.Errors}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{with .Dropped}}<h2>Dropped Objects</h2>
+<p>The following source DB objects have no Spanner equivalent (or couldn't be converted), and were dropped.</p>
+{{range .}}<h3>{{.Heading}}</h3>
+<ol>
+{{range .Objects}}<li>{{.Description}}.{{with .Comment}}<br>Comment: {{.}}{{end}}{{with .SQL}}<br><code>{{.}}</code>{{end}}</li>
+{{end}}</ol>
+{{end}}{{end}}{{with .Usage}}<h2>Resource Usage</h2>
+<table>
+{{range .}}<tr><td>{{index . 0}}</td><td class="num">{{index . 1}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Unexpected Conditions</h2>
+{{if .Unexpected}}<table>
+<tr><th>count</th><th>condition</th></tr>
+{{range .Unexpected}}<tr><td class="num">{{.Count}}</td><td>{{.Condition}}{{with .Offset}}<br>First at byte {{.}}{{end}}{{with .Snippet}}: <code>{{.}}</code>{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p>There were no unexpected conditions encountered during processing.</p>
+{{end}}{{with .Reparsed}}<p>Note: there were {{.}} pg_dump reparse events while looking for statement boundaries.</p>
+{{end}}</body>
+</html>
+`))
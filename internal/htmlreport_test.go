@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTMLReport_Golden(t *testing.T) {
+	var reports []string
+	for seed := int64(0); seed < 5; seed++ {
+		conv, badWrites := buildGoldenConv(seed)
+		buf := new(bytes.Buffer)
+		assert.Nil(t, GenerateHTMLReport(PgDumpSource, conv, buf, badWrites, "Generated for golden test\n\n"))
+		reports = append(reports, buf.String())
+	}
+	for i := 1; i < len(reports); i++ {
+		assert.Equal(t, reports[0], reports[i], "report differs for seed %d", i)
+	}
+	golden := filepath.Join("..", "test_data", "report.html.golden")
+	if *update {
+		assert.Nil(t, ioutil.WriteFile(golden, []byte(reports[0]), 0644))
+	}
+	expected, err := ioutil.ReadFile(golden)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), reports[0])
+}
+
+func TestHTMLReport(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE \"a<b>\" (id bigint PRIMARY KEY, c timestamp);\n" +
+			"CREATE TABLE t (x int4, y text);\n")
+	buf := new(bytes.Buffer)
+	assert.Nil(t, GenerateHTMLReport(PostgresSource, conv, buf, nil, ""))
+	s := buf.String()
+	// Table names are escaped, and anchors don't depend on them.
+	assert.NotContains(t, s, "a<b>")
+	assert.Contains(t, s, `<a href="#table-1">a&lt;b&gt;</a>`)
+	assert.Contains(t, s, `<details class="table" id="table-2">`)
+	// Warnings are expanded by default, notes are not.
+	assert.Contains(t, s, "<details open>\n<summary>Warning</summary>")
+	assert.Equal(t, 2, strings.Count(s, "<details>\n<summary>Note"))
+	// Statement stats are only shown for pg_dump input.
+	assert.NotContains(t, s, "Statements Processed")
+	assert.Contains(t, s, "There were no unexpected conditions")
+}
@@ -15,11 +15,12 @@
 package internal
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"math/bits"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/civil"
@@ -39,15 +40,22 @@ import (
 // schema tables. These tables are a broadly supported ANSI standard,
 // and we use them to obtain source database's schema information.
 func ProcessInfoSchema(conv *Conv, db *sql.DB) error {
-	tables, err := getTables(db)
+	tables, err := getTables(conv, db)
 	if err != nil {
 		return err
 	}
+	found := make(map[string]bool)
 	for _, t := range tables {
+		found[t.schema] = true
 		if err := processTable(conv, db, t); err != nil {
 			return err
 		}
 	}
+	for _, s := range sortedSchemas(conv.schemas) {
+		if !found[s] {
+			conv.unexpected(fmt.Sprintf("No tables found in source schema %s", s))
+		}
+	}
 	schemaToDDL(conv)
 	conv.AddPrimaryKeys()
 	return nil
@@ -72,56 +80,14 @@ func ProcessInfoSchema(conv *Conv, db *sql.DB) error {
 // We choose to do all type conversions explicitly ourselves so that
 // we can generate more targeted error messages: hence we pass
 // *interface{} parameters to row.Scan.
-func ProcessSqlData(conv *Conv, db *sql.DB) {
-	// TODO: refactor to use the set of tables computed by
-	// ProcessInfoSchema instead of computing them again.
-	tables, err := getTables(db)
-	if err != nil {
-		conv.unexpected(fmt.Sprintf("Couldn't get list of table: %s", err))
-		return
-	}
-	for _, t := range tables {
-		// PostgreSQL schema and name can be arbitrary strings.
-		// Ideally we would pass schema/name as a query parameter,
-		// but PostgreSQL doesn't support this. So we quote it instead.
-		q := fmt.Sprintf(`SELECT * FROM "%s"."%s";`, t.schema, t.name)
-		rows, err := db.Query(q)
-		if err != nil {
-			conv.unexpected(fmt.Sprintf("Couldn't get data for table: %s", err))
-			continue
-		}
-		defer rows.Close()
-		srcTable := buildTableName(t.schema, t.name)
-		srcCols, err1 := rows.Columns()
-		spTable, err2 := GetSpannerTable(conv, srcTable)
-		spCols, err3 := GetSpannerCols(conv, srcTable, srcCols)
-		spSchema, ok1 := conv.spSchema[spTable]
-		srcSchema, ok2 := conv.srcSchema[srcTable]
-		if err1 != nil || err2 != nil || err3 != nil || !ok1 || !ok2 {
-			conv.statsAddBadRows(srcTable, conv.stats.rows[srcTable])
-			conv.unexpected(fmt.Sprintf("Can't get cols and schemas for table %s: err1=%s, err2=%s, err3=%s, ok1=%t, ok2=%t",
-				srcTable, err1, err2, err3, ok1, ok2))
-			continue
-		}
-		v, iv := buildVals(len(srcCols))
-		for rows.Next() {
-			err := rows.Scan(iv...)
-			if err != nil {
-				conv.unexpected(fmt.Sprintf("Couldn't process sql data row: %s", err))
-				// Scan failed, so we don't have any data to add to bad rows.
-				conv.statsAddBadRow(srcTable, conv.dataMode())
-				continue
-			}
-			cvtCols, cvtVals, err := ConvertSqlRow(conv, srcTable, srcCols, srcSchema, spTable, spCols, spSchema, v)
-			if err != nil {
-				conv.unexpected(fmt.Sprintf("Couldn't process sql data row: %s", err))
-				conv.statsAddBadRow(srcTable, conv.dataMode())
-				conv.CollectBadRow(srcTable, srcCols, valsToStrings(v))
-				continue
-			}
-			conv.WriteRow(srcTable, spTable, cvtCols, cvtVals)
-		}
-	}
+func ProcessSqlData(conv *Conv, db SqlQueryer) {
+	ProcessSqlDataContext(context.Background(), conv, db)
+}
+
+// ProcessSqlDataContext is like ProcessSqlData, but stops once ctx is
+// done, so that data conversion can be interrupted.
+func ProcessSqlDataContext(ctx context.Context, conv *Conv, db SqlQueryer) {
+	ProcessSqlDataParallel(ctx, conv, []SqlQueryer{db}, SqlReadOptions{})
 }
 
 // ConvertSqlRow performs data conversion for a single row of data
@@ -139,7 +105,11 @@ func ConvertSqlRow(conv *Conv, srcTable string, srcCols []string, srcSchema sche
 		if !ok1 || !ok2 {
 			return nil, nil, fmt.Errorf("data conversion: can't find schema for column %s of table %s", srcCols[i], srcTable)
 		}
+		conv.observeSqlValue(srcTable, srcCols[i], srcVals[i])
 		if srcVals[i] == nil {
+			if spCd.NotNull {
+				return nil, nil, &nullError{srcCols[i]}
+			}
 			continue // Skip NULL values (nil is used by database/sql to represent NULL values).
 		}
 		var spVal interface{}
@@ -150,36 +120,53 @@ func ConvertSqlRow(conv *Conv, srcTable string, srcCols []string, srcSchema sche
 			spVal, err = cvtSqlScalar(conv, srcCd, spCd, srcVals[i])
 		}
 		if err != nil { // Skip entire row if we hit error.
-			return nil, nil, fmt.Errorf("can't convert sql data for column %s of table %s: %w", srcCols[i], srcTable, err)
+			err = fmt.Errorf("can't convert sql data for column %s of table %s: %w", srcCols[i], srcTable, err)
+			return nil, nil, &columnError{col: srcCols[i], typ: srcCd.Type.Name, err: err}
 		}
 		vs = append(vs, spVal)
 		cs = append(cs, srcCols[i])
 	}
-	if aux, ok := conv.syntheticPKeys[spTable]; ok {
-		cs = append(cs, aux.col)
-		vs = append(vs, int64(bits.Reverse64(uint64(aux.sequence))))
-		aux.sequence++
-		conv.syntheticPKeys[spTable] = aux
+	if col, x, ok := conv.nextSyntheticPK(spTable); ok {
+		cs = append(cs, col)
+		vs = append(vs, x)
+	}
+	if col, x, ok := conv.nextCommitTimestamp(spTable); ok {
+		cs = append(cs, col)
+		vs = append(vs, x)
 	}
 	return cs, vs, nil
 }
 
 // SetRowStats populates conv with the number of rows in each table.
-func SetRowStats(conv *Conv, db *sql.DB) {
+func SetRowStats(conv *Conv, db SqlQueryer) {
+	for t, count := range CountSqlRows(conv, db) {
+		conv.statsAddRows(t, count)
+	}
+}
+
+// CountSqlRows returns the number of rows in each table, keyed by
+// source table name. Tables that couldn't be counted are omitted.
+func CountSqlRows(conv *Conv, db SqlQueryer) map[string]int64 {
 	// TODO: refactor to use the set of tables computed by
 	// ProcessInfoSchema instead of computing them again.
-	tables, err := getTables(db)
+	tables, err := getTables(conv, db)
 	if err != nil {
 		conv.unexpected(fmt.Sprintf("Couldn't get list of table: %s", err))
-		return
+		return nil
 	}
+	counts := make(map[string]int64)
 	for _, t := range tables {
 		// PostgreSQL schema and name can be arbitrary strings.
 		// Ideally we would pass schema/name as a query parameter,
 		// but PostgreSQL doesn't support this. So we quote it instead.
-		q := fmt.Sprintf(`SELECT COUNT(*) FROM "%s"."%s";`, t.schema, t.name)
-		tableName := buildTableName(t.schema, t.name)
+		fn := "COUNT(*)"
+		if conv.sqlserver {
+			fn = "COUNT_BIG(*)" // COUNT(*) is an int, which can overflow.
+		}
+		q := fmt.Sprintf(`SELECT %s FROM "%s"."%s";`, fn, t.schema, t.name)
+		tableName := buildTableName(conv, t.schema, t.name)
 		rows, err := db.Query(q)
+		conv.tableQueryIssued(t.schema, err)
 		if err != nil {
 			conv.unexpected(fmt.Sprintf("Couldn't get number of rows for table %s", tableName))
 			continue
@@ -192,9 +179,49 @@ func SetRowStats(conv *Conv, db *sql.DB) {
 				fmt.Printf("Can't get row count: %s\n", err)
 				continue
 			}
-			conv.statsAddRows(tableName, count)
+			counts[tableName] = count
 		}
 	}
+	return counts
+}
+
+// EstimateSqlRows returns approximate row counts for each table from
+// PostgreSQL's statistics (pg_class.reltuples), keyed by source table
+// name. This is much cheaper than CountSqlRows for big tables, but is
+// only as fresh as the last VACUUM or ANALYZE. Tables without
+// statistics are omitted. For SQL Server, the counts come from
+// sys.partitions, which SQL Server maintains as rows change, though
+// not exactly.
+func EstimateSqlRows(conv *Conv, db SqlQueryer) map[string]int64 {
+	tables, err := getTables(conv, db)
+	if err != nil {
+		conv.unexpected(fmt.Sprintf("Couldn't get list of table: %s", err))
+		return nil
+	}
+	q := `SELECT c.reltuples FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2`
+	if conv.sqlserver {
+		// The rows of the heap or clustered index, in all partitions.
+		q = `SELECT CAST(COALESCE(SUM(p.rows), 0) AS float) FROM sys.partitions AS p
+		INNER JOIN sys.tables AS t ON t.object_id = p.object_id INNER JOIN sys.schemas AS s ON s.schema_id = t.schema_id
+		WHERE s.name = @p1 AND t.name = @p2 AND p.index_id IN (0, 1)`
+	}
+	estimates := make(map[string]int64)
+	for _, t := range tables {
+		var n float64
+		err := db.QueryRow(q, t.schema, t.name).Scan(&n)
+		conv.tableQueryIssued(t.schema, err)
+		if err != nil {
+			conv.unexpected(fmt.Sprintf("Couldn't get row estimate for table %s: %s", buildTableName(conv, t.schema, t.name), err))
+			continue
+		}
+		// reltuples is -1 (or 0 in older versions) for tables that
+		// have never been analyzed.
+		if n > 0 {
+			estimates[buildTableName(conv, t.schema, t.name)] = int64(n + 0.5)
+		}
+	}
+	return estimates
 }
 
 type schemaAndName struct {
@@ -202,14 +229,19 @@ type schemaAndName struct {
 	name   string
 }
 
-func getTables(db *sql.DB) ([]schemaAndName, error) {
+func getTables(conv *Conv, db SqlQueryer) ([]schemaAndName, error) {
 	ignored := make(map[string]bool)
 	// Ignore all system tables: we just want to convert user tables.
 	for _, s := range []string{"information_schema", "postgres", "pg_catalog", "pg_temp_1", "pg_toast", "pg_toast_temp_1"} {
 		ignored[s] = true
 	}
 	q := "SELECT table_schema, table_name FROM information_schema.tables where table_type = 'BASE TABLE'"
+	if conv.sqlserver {
+		// Only user tables (SQL Server's own aren't listed).
+		q = "SELECT s.name, t.name FROM sys.tables AS t INNER JOIN sys.schemas AS s ON s.schema_id = t.schema_id WHERE t.is_ms_shipped = 0 ORDER BY s.name, t.name"
+	}
 	rows, err := db.Query(q)
+	conv.queryIssued(err)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't get tables: %w\n", err)
 	}
@@ -218,7 +250,7 @@ func getTables(db *sql.DB) ([]schemaAndName, error) {
 	var tables []schemaAndName
 	for rows.Next() {
 		rows.Scan(&tableSchema, &tableName)
-		if !ignored[tableSchema] {
+		if !ignored[tableSchema] && conv.convertsSchema(tableSchema) {
 			tables = append(tables, schemaAndName{schema: tableSchema, name: tableName})
 		}
 	}
@@ -226,7 +258,7 @@ func getTables(db *sql.DB) ([]schemaAndName, error) {
 }
 
 func processTable(conv *Conv, db *sql.DB, table schemaAndName) error {
-	cols, err := getColumns(table, db)
+	cols, err := getColumns(conv, table, db)
 	if err != nil {
 		return fmt.Errorf("couldn't get schema for table %s.%s: %s\n", table.schema, table.name, err)
 	}
@@ -235,8 +267,16 @@ func processTable(conv *Conv, db *sql.DB, table schemaAndName) error {
 	if err != nil {
 		return fmt.Errorf("couldn't get constraints for table %s.%s: %s\n", table.schema, table.name, err)
 	}
+	foreignKeys, err := getForeignKeys(conv, db, table)
+	if err != nil {
+		return fmt.Errorf("couldn't get foreign key constraints for table %s.%s: %s\n", table.schema, table.name, err)
+	}
+	indexes, err := getIndexes(conv, db, table)
+	if err != nil {
+		return fmt.Errorf("couldn't get indexes for table %s.%s: %s\n", table.schema, table.name, err)
+	}
 	colDefs, colNames := processColumns(conv, cols, constraints)
-	name := buildTableName(table.schema, table.name)
+	name := buildTableName(conv, table.schema, table.name)
 	var schemaPKeys []schema.Key
 	for _, k := range primaryKeys {
 		schemaPKeys = append(schemaPKeys, schema.Key{Column: k})
@@ -245,17 +285,22 @@ func processTable(conv *Conv, db *sql.DB, table schemaAndName) error {
 		Name:        name,
 		ColNames:    colNames,
 		ColDefs:     colDefs,
-		PrimaryKeys: schemaPKeys}
+		PrimaryKeys: schemaPKeys,
+		Indexes:     indexes,
+		ForeignKeys: foreignKeys}
+	conv.tableSchemaRead(table.schema, len(colNames))
 	return nil
 }
 
-func getColumns(table schemaAndName, db *sql.DB) (*sql.Rows, error) {
+func getColumns(conv *Conv, table schemaAndName, db *sql.DB) (*sql.Rows, error) {
 	q := `SELECT c.column_name, c.data_type, e.data_type, c.is_nullable, c.column_default, c.character_maximum_length, c.numeric_precision, c.numeric_scale
               FROM information_schema.COLUMNS c LEFT JOIN information_schema.element_types e
                  ON ((c.table_catalog, c.table_schema, c.table_name, 'TABLE', c.dtd_identifier)
                      = (e.object_catalog, e.object_schema, e.object_name, e.object_type, e.collection_type_identifier))
               where table_schema = $1 and table_name = $2 ORDER BY c.ordinal_position;`
-	return db.Query(q, table.schema, table.name)
+	rows, err := db.Query(q, table.schema, table.name)
+	conv.tableQueryIssued(table.schema, err)
+	return rows, err
 }
 
 func processColumns(conv *Conv, cols *sql.Rows, constraints map[string][]string) (map[string]schema.Column, []string) {
@@ -275,17 +320,19 @@ func processColumns(conv *Conv, cols *sql.Rows, constraints map[string][]string)
 		for _, c := range constraints[colName] {
 			// c can be UNIQUE, PRIMARY KEY, FOREIGN KEY,
 			// or CHECK (based on msql, sql server, postgres docs).
-			// We've already filtered out PRIMARY KEY.
+			// We've already filtered out PRIMARY KEY, and foreign
+			// keys are handled by getForeignKeys.
 			switch c {
 			case "UNIQUE":
 				unique = true
-			case "FOREIGN KEY":
-				ignored.ForeignKey = true
 			case "CHECK":
 				ignored.Check = true
 			}
 		}
 		ignored.Default = colDefault.Valid
+		if colDefault.Valid {
+			ignored.Sequence, ignored.Now = infoSchemaDefaultKind(colDefault.String)
+		}
 		c := schema.Column{
 			Name:    colName,
 			Type:    toType(dataType, elementDataType, charMaxLen, numericPrecision, numericScale),
@@ -299,6 +346,21 @@ func processColumns(conv *Conv, cols *sql.Rows, constraints map[string][]string)
 	return colDefs, colNames
 }
 
+// infoSchemaDefaultKind reports whether column default d (from
+// information_schema.columns e.g. "nextval('t_id_seq'::regclass)") is
+// the next value of a sequence, or the current time.
+func infoSchemaDefaultKind(d string) (sequence, now bool) {
+	d = strings.ToLower(d)
+	switch {
+	case strings.HasPrefix(d, "nextval("):
+		return true, false
+	case strings.HasPrefix(d, "now()"), strings.HasPrefix(d, "current_timestamp"), strings.HasPrefix(d, "localtimestamp"),
+		strings.HasPrefix(d, "clock_timestamp()"), strings.HasPrefix(d, "statement_timestamp()"), strings.HasPrefix(d, "transaction_timestamp()"):
+		return false, true
+	}
+	return false, false
+}
+
 // getConstraints returns a list of primary keys and by-column map of
 // other constraints.  Note: we need to preserve ordinal order of
 // columns in primary key constraints.
@@ -309,6 +371,7 @@ func getConstraints(conv *Conv, db *sql.DB, table schemaAndName) ([]string, map[
                   ON t.CONSTRAINT_NAME = k.CONSTRAINT_NAME AND t.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA
               WHERE k.TABLE_SCHEMA = $1 AND k.TABLE_NAME = $2 ORDER BY k.ordinal_position;`
 	rows, err := db.Query(q, table.schema, table.name)
+	conv.tableQueryIssued(table.schema, err)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -336,6 +399,106 @@ func getConstraints(conv *Conv, db *sql.DB, table schemaAndName) ([]string, map[
 	return primaryKeys, m, nil
 }
 
+// getForeignKeys returns the foreign key constraints of a table.
+// Information schema has one row per column of each constraint, so
+// we group rows by constraint. Note: we need to preserve ordinal
+// order of columns, and match each column with the column it
+// references in the referenced table's unique constraint.
+func getForeignKeys(conv *Conv, db *sql.DB, table schemaAndName) ([]schema.ForeignKey, error) {
+	q := `SELECT rc.constraint_name, k.column_name, r.table_schema, r.table_name, r.column_name, rc.delete_rule, rc.update_rule
+              FROM information_schema.referential_constraints AS rc
+                INNER JOIN information_schema.key_column_usage AS k
+                  ON rc.constraint_name = k.constraint_name AND rc.constraint_schema = k.constraint_schema
+                INNER JOIN information_schema.key_column_usage AS r
+                  ON rc.unique_constraint_name = r.constraint_name AND rc.unique_constraint_schema = r.constraint_schema
+                    AND k.position_in_unique_constraint = r.ordinal_position
+              WHERE k.table_schema = $1 AND k.table_name = $2 ORDER BY rc.constraint_name, k.ordinal_position;`
+	rows, err := db.Query(q, table.schema, table.name)
+	conv.tableQueryIssued(table.schema, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fks []schema.ForeignKey
+	var name, col, referSchema, referTable, referCol, onDelete, onUpdate string
+	for rows.Next() {
+		err := rows.Scan(&name, &col, &referSchema, &referTable, &referCol, &onDelete, &onUpdate)
+		if err != nil {
+			conv.unexpected(fmt.Sprintf("Can't scan foreign key: %v", err))
+			continue
+		}
+		if n := len(fks); n > 0 && fks[n-1].Name == name {
+			fks[n-1].Columns = append(fks[n-1].Columns, col)
+			fks[n-1].ReferColumns = append(fks[n-1].ReferColumns, referCol)
+			continue
+		}
+		fks = append(fks, schema.ForeignKey{
+			Name:         name,
+			Columns:      []string{col},
+			ReferTable:   buildTableName(conv, referSchema, referTable),
+			ReferColumns: []string{referCol},
+			OnDelete:     onDelete,
+			OnUpdate:     onUpdate,
+		})
+	}
+	return fks, nil
+}
+
+// getIndexes returns the secondary indexes of a table (including the
+// indexes that implement unique constraints). Information schema
+// doesn't cover indexes, so we use PostgreSQL's catalog. It has one
+// row per index column, and we group rows by index. Notes:
+// a) indclass has an entry for each key column, but not for INCLUDE
+//    columns, so we use it to distinguish them (this also works for
+//    versions of PostgreSQL before INCLUDE was added).
+// b) key columns that are expressions have attnum 0, and so have no
+//    column name.
+func getIndexes(conv *Conv, db *sql.DB, table schemaAndName) ([]schema.Index, error) {
+	q := `SELECT i.relname, ix.indisunique, a.attname, k.n <= array_length(ix.indclass::oid[], 1),
+                COALESCE(ix.indoption[k.n - 1] & 1 = 1, false), am.amname, ix.indpred IS NOT NULL
+              FROM pg_catalog.pg_index AS ix
+                INNER JOIN pg_catalog.pg_class AS t ON t.oid = ix.indrelid
+                INNER JOIN pg_catalog.pg_namespace AS ns ON ns.oid = t.relnamespace
+                INNER JOIN pg_catalog.pg_class AS i ON i.oid = ix.indexrelid
+                INNER JOIN pg_catalog.pg_am AS am ON am.oid = i.relam
+                CROSS JOIN LATERAL unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, n)
+                LEFT JOIN pg_catalog.pg_attribute AS a ON a.attrelid = t.oid AND a.attnum = k.attnum
+              WHERE ns.nspname = $1 AND t.relname = $2 AND NOT ix.indisprimary ORDER BY i.relname, k.n;`
+	rows, err := db.Query(q, table.schema, table.name)
+	conv.tableQueryIssued(table.schema, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var indexes []schema.Index
+	var name, method string
+	var col sql.NullString
+	var unique, key, desc, predicate bool
+	for rows.Next() {
+		err := rows.Scan(&name, &unique, &col, &key, &desc, &method, &predicate)
+		if err != nil {
+			conv.unexpected(fmt.Sprintf("Can't scan index: %v", err))
+			continue
+		}
+		if n := len(indexes); n == 0 || indexes[n-1].Name != name {
+			index := schema.Index{Name: name, Unique: unique}
+			index.Ignored.Predicate = predicate
+			index.Ignored.Method = unsupportedIndexMethod(method)
+			indexes = append(indexes, index)
+		}
+		index := &indexes[len(indexes)-1]
+		switch {
+		case !col.Valid:
+			index.Ignored.Expression = true
+		case key:
+			index.Keys = append(index.Keys, schema.Key{Column: col.String, Desc: desc})
+		default:
+			index.Storing = append(index.Storing, col.String)
+		}
+	}
+	return indexes, nil
+}
+
 func toType(dataType string, elementDataType sql.NullString, charLen sql.NullInt64, numericPrecision, numericScale sql.NullInt64) schema.Type {
 	switch {
 	case dataType == "ARRAY" && elementDataType.Valid:
@@ -369,7 +532,7 @@ func cvtSqlArray(conv *Conv, srcCd schema.Column, spCd ddl.ColumnDef, val interf
 	if !ok {
 		return nil, fmt.Errorf("can't convert array values to []byte")
 	}
-	return convArray(spCd.T, srcCd.Type.Name, conv.location, string(a))
+	return convArray(spCd.T, srcCd.Type.Name, conv.location, conv.naiveZone(), string(a))
 }
 
 // cvtSqlScalar converts a values returned from a SQL query to a
@@ -399,6 +562,8 @@ func cvtSqlScalar(conv *Conv, srcCd schema.Column, spCd ddl.ColumnDef, val inter
 		switch v := val.(type) {
 		case []byte:
 			return v, nil
+		case string:
+			return []byte(v), nil
 		}
 	case ddl.Date:
 		// The PostgreSQL driver uses time.Time to represent
@@ -435,11 +600,25 @@ func cvtSqlScalar(conv *Conv, srcCd schema.Column, spCd ddl.ColumnDef, val inter
 		case string:
 			return convFloat64(v)
 		}
+	case ddl.Numeric:
+		switch v := val.(type) {
+		case []byte: // Note: PostgreSQL uses []byte for numeric.
+			return convNumeric(string(v))
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			return convNumeric(strconv.FormatFloat(v, 'f', -1, 64))
+		case string:
+			return convNumeric(v)
+		}
 	case ddl.String:
 		switch v := val.(type) {
 		case bool:
 			return strconv.FormatBool(v), nil
 		case []byte:
+			if srcCd.Type.Name == "uniqueidentifier" && len(v) == 16 {
+				return sqlServerUUID(v), nil
+			}
 			return string(v), nil
 		case int64:
 			return strconv.FormatInt(v, 10), nil
@@ -448,13 +627,22 @@ func cvtSqlScalar(conv *Conv, srcCd schema.Column, spCd ddl.ColumnDef, val inter
 		case string:
 			return v, nil
 		case time.Time:
+			if srcCd.Type.Name == "time" {
+				// SQL Server time values have a zero date.
+				return v.Format("15:04:05.9999999"), nil
+			}
 			return v.String(), nil
 		}
 	case ddl.Timestamp:
 		switch v := val.(type) {
 		case string:
-			return convTimestamp(srcCd.Type.Name, conv.location, v)
+			return convTimestamp(srcCd.Type.Name, conv.location, conv.naiveZone(), v)
 		case time.Time:
+			if conv.sqlserver && sqlServerDataType(srcCd.Type.Name) == "timestamp" {
+				// The driver returns times without a time zone
+				// as UTC times with the same date and time.
+				return time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), conv.naiveZone()), nil
+			}
 			return v, nil
 		}
 	}
@@ -491,9 +679,22 @@ func valsToStrings(vals []interface{}) []string {
 	return s
 }
 
-func buildTableName(schema, name string) string {
-	if schema == "public" { // Drop 'public' prefix.
-		return name
+// valsBytes returns the approximate size in bytes of the values of a
+// row returned from a 'SELECT *' query. Strings and byte slices count
+// their length; other non-NULL values count as 8 bytes.
+func valsBytes(vals []interface{}) int64 {
+	var n int64
+	for _, val := range vals {
+		switch v := val.(type) {
+		case nil:
+		case []byte:
+			n += int64(len(v))
+		case string:
+			n += int64(len(v))
+		default:
+			n += 8
+		}
 	}
-	return fmt.Sprintf("%s.%s", schema, name)
+	return n
 }
+
@@ -57,6 +57,14 @@ func TestProcessInfoSchema(t *testing.T) {
 			rows: [][]driver.Value{
 				{"productid", "PRIMARY KEY"},
 				{"userid", "PRIMARY KEY"}},
+		}, {
+			query: "SELECT (.+) FROM information_schema.referential_constraints (.+)",
+			args:  []driver.Value{"public", "cart"},
+			cols:  []string{"constraint_name", "column_name", "table_schema", "table_name", "column_name", "delete_rule", "update_rule"},
+		}, {
+			query: "SELECT (.+) FROM pg_catalog.pg_index (.+)",
+			args:  []driver.Value{"public", "cart"},
+			cols:  []string{"relname", "indisunique", "attname", "key", "desc", "amname", "predicate"},
 		}, {
 			query: "SELECT (.+) FROM information_schema.COLUMNS (.+)",
 			args:  []driver.Value{"public", "test"},
@@ -90,6 +98,25 @@ func TestProcessInfoSchema(t *testing.T) {
 			cols:  []string{"column_name", "constraint_type"},
 			rows:  [][]driver.Value{{"id", "PRIMARY KEY"}},
 		},
+		{
+			query: "SELECT (.+) FROM information_schema.referential_constraints (.+)",
+			args:  []driver.Value{"public", "test"},
+			cols:  []string{"constraint_name", "column_name", "table_schema", "table_name", "column_name", "delete_rule", "update_rule"},
+			rows: [][]driver.Value{
+				{"fk_test_i8", "i8", "public", "test", "id", "CASCADE", "NO ACTION"},
+				{"fk_test_txt", "txt", "public", "cart", "productid", "SET NULL", "NO ACTION"},
+				{"fk_test_txt", "vc", "public", "cart", "userid", "SET NULL", "NO ACTION"}},
+		},
+		{
+			query: "SELECT (.+) FROM pg_catalog.pg_index (.+)",
+			args:  []driver.Value{"public", "test"},
+			cols:  []string{"relname", "indisunique", "attname", "key", "desc", "amname", "predicate"},
+			rows: [][]driver.Value{
+				{"test_i8_idx", false, "i8", true, true, "btree", false},
+				{"test_i8_idx", false, "txt", false, false, "btree", false}, // INCLUDE column.
+				{"test_lower_idx", false, nil, true, false, "btree", false},
+				{"test_vc_key", true, "vc", true, false, "btree", false}},
+		},
 	}
 	db := mkMockDB(t, ms)
 	conv := MakeConv()
@@ -107,14 +134,14 @@ func TestProcessInfoSchema(t *testing.T) {
 			Pks: []ddl.IndexKey{ddl.IndexKey{Col: "productid"}, ddl.IndexKey{Col: "userid"}}},
 		"test": ddl.CreateTable{
 			Name:     "test",
-			ColNames: []string{"id", "aint", "atext", "b", "bs", "by", "c", "c8", "d", "f8", "f4", "i8", "i4", "i2", "num", "s", "ts", "tz", "txt", "vc", "vc6"},
+			ColNames: []string{"id", "aint", "atext", "b", "bs", "by_", "c", "c8", "d", "f8", "f4", "i8", "i4", "i2", "num", "s", "ts", "tz", "txt", "vc", "vc6"},
 			ColDefs: map[string]ddl.ColumnDef{
 				"id":    ddl.ColumnDef{Name: "id", T: ddl.Int64{}, NotNull: true},
 				"aint":  ddl.ColumnDef{Name: "aint", T: ddl.Int64{}, IsArray: true},
 				"atext": ddl.ColumnDef{Name: "atext", T: ddl.String{Len: ddl.MaxLength{}}, IsArray: true},
 				"b":     ddl.ColumnDef{Name: "b", T: ddl.Bool{}},
 				"bs":    ddl.ColumnDef{Name: "bs", T: ddl.Int64{}, NotNull: true},
-				"by":    ddl.ColumnDef{Name: "by", T: ddl.Bytes{Len: ddl.MaxLength{}}},
+				"by_":   ddl.ColumnDef{Name: "by_", T: ddl.Bytes{Len: ddl.MaxLength{}}}, // BY is a reserved word.
 				"c":     ddl.ColumnDef{Name: "c", T: ddl.String{Len: ddl.Int64Length{Value: 1}}},
 				"c8":    ddl.ColumnDef{Name: "c8", T: ddl.String{Len: ddl.Int64Length{Value: 8}}},
 				"d":     ddl.ColumnDef{Name: "d", T: ddl.Date{}},
@@ -123,7 +150,7 @@ func TestProcessInfoSchema(t *testing.T) {
 				"i8":    ddl.ColumnDef{Name: "i8", T: ddl.Int64{}},
 				"i4":    ddl.ColumnDef{Name: "i4", T: ddl.Int64{}},
 				"i2":    ddl.ColumnDef{Name: "i2", T: ddl.Int64{}},
-				"num":   ddl.ColumnDef{Name: "num", T: ddl.Float64{}},
+				"num":   ddl.ColumnDef{Name: "num", T: ddl.Numeric{}},
 				"s":     ddl.ColumnDef{Name: "s", T: ddl.Int64{}, NotNull: true},
 				"ts":    ddl.ColumnDef{Name: "ts", T: ddl.Timestamp{}},
 				"tz":    ddl.ColumnDef{Name: "tz", T: ddl.Timestamp{}},
@@ -131,7 +158,11 @@ func TestProcessInfoSchema(t *testing.T) {
 				"vc":    ddl.ColumnDef{Name: "vc", T: ddl.String{Len: ddl.MaxLength{}}},
 				"vc6":   ddl.ColumnDef{Name: "vc6", T: ddl.String{Len: ddl.Int64Length{Value: 6}}},
 			},
-			Pks: []ddl.IndexKey{ddl.IndexKey{Col: "id"}}},
+			Pks:         []ddl.IndexKey{ddl.IndexKey{Col: "id"}},
+			ForeignKeys: []ddl.ForeignKey{{Name: "fk_test_i8", Columns: []string{"i8"}, ReferTable: "test", ReferColumns: []string{"id"}, OnDelete: "CASCADE"}},
+			Indexes: []ddl.CreateIndex{
+				{Name: "test_i8_idx", Table: "test", Keys: []ddl.IndexKey{{Col: "i8", Desc: true}}, Storing: []string{"txt"}},
+				{Name: "test_vc_key", Table: "test", Keys: []ddl.IndexKey{{Col: "vc"}}, Unique: true, NullFiltered: true}}},
 	}
 	assert.Equal(t, expectedSchema, stripSchemaComments(conv.spSchema))
 	assert.Equal(t, len(conv.issues["cart"]), 0)
@@ -146,7 +177,51 @@ func TestProcessInfoSchema(t *testing.T) {
 		"ts":   []schemaIssue{timestamp},
 	}
 	assert.Equal(t, expectedIssues, conv.issues["test"])
+	expectedGroupIssues := []groupIssue{
+		{issue: renamed, cols: []string{"by"}, construct: "column by_", detail: "it is a Spanner reserved word"},
+		{issue: indexUnsupported, construct: "index test_lower_idx", detail: "it indexes expressions, which Spanner doesn't support"},
+		{issue: foreignKey, cols: []string{"i8"}, construct: "foreign key fk_test_i8 (i8) referencing test (id)"},
+		{issue: foreignKeyUnsupported, cols: []string{"txt", "vc"}, construct: "foreign key fk_test_txt (txt, vc) referencing cart (productid, userid)",
+			detail: "Spanner doesn't support ON DELETE SET NULL"},
+	}
+	assert.Equal(t, expectedGroupIssues, conv.groupIssues["test"])
 	assert.Equal(t, int64(0), conv.Unexpecteds())
+	// A query for the tables, then 4 for each table.
+	assert.Equal(t, queryStats{tables: 2, columns: 24, queries: 9}, conv.stats.queries)
+	assert.Equal(t, map[string]*queryStats{"public": {tables: 2, columns: 24, queries: 8}}, conv.stats.bySchema)
+}
+
+func TestProcessInfoSchema_Schemas(t *testing.T) {
+	ms := []mockSpec{
+		{
+			query: "SELECT table_schema, table_name FROM information_schema.tables where table_type = 'BASE TABLE'",
+			cols:  []string{"table_schema", "table_name"},
+			rows:  [][]driver.Value{{"public", "user"}, {"pg_catalog", "pg_class"}},
+		},
+	}
+	db := mkMockDB(t, ms)
+	conv := MakeConv()
+	conv.SetSourceSchemas([]string{"audit"})
+	assert.Nil(t, ProcessInfoSchema(conv, db))
+	assert.Equal(t, 0, len(conv.srcSchema))
+	assert.Equal(t, int64(1), conv.Unexpecteds())
+	assert.NotNil(t, conv.stats.unexpected["No tables found in source schema audit"])
+
+	ms = append(ms, mockSpec{
+		query: "SELECT table_schema, table_name FROM information_schema.tables where table_type = 'BASE TABLE'",
+		cols:  []string{"table_schema", "table_name"},
+		rows:  [][]driver.Value{{"public", "user"}, {"audit", "log"}, {"sales", "orders"}, {"pg_catalog", "pg_class"}},
+	})
+	db = mkMockDB(t, ms[1:])
+	conv.SetSourceSchemas([]string{"audit", "sales"})
+	tables, err := getTables(conv, db)
+	assert.Nil(t, err)
+	assert.Equal(t, []schemaAndName{{schema: "audit", name: "log"}, {schema: "sales", name: "orders"}}, tables)
+	conv.SetSourceSchemas(nil)
+	db = mkMockDB(t, ms[1:])
+	tables, err = getTables(conv, db)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(tables))
 }
 
 // TestProcessSqlData is a basic test of ProcessSqlData that checks
@@ -202,6 +277,7 @@ func TestProcessSqlData(t *testing.T) {
 	assert.Equal(t, conv.BadRows(), int64(1))
 	assert.Equal(t, conv.SampleBadRows(10), []string{"table=te st cols=[a a  b  c ] data=[6.6 2006-01-02 dog]\n"})
 	assert.Equal(t, int64(1), conv.Unexpecteds()) // Bad row generates an entry in unexpected.
+	assert.Equal(t, queryStats{queries: 2}, conv.stats.queries)
 }
 
 func TestConvertSqlRow_SingleCol(t *testing.T) {
@@ -227,6 +303,9 @@ func TestConvertSqlRow_SingleCol(t *testing.T) {
 		{name: "float64 string", srcType: schema.Type{Name: "text"}, spType: ddl.Float64{}, in: "42.6", e: float64(42.6)},
 		{name: "float64 int", srcType: schema.Type{Name: "bigint"}, spType: ddl.Float64{}, in: int64(42), e: float64(42)},
 		{name: "float64 byte", srcType: schema.Type{Name: "numeric"}, spType: ddl.Float64{}, in: []byte("42.6"), e: float64(42.6)},
+		{name: "numeric byte", srcType: schema.Type{Name: "numeric"}, spType: ddl.Numeric{}, in: []byte("42.60"), e: "42.6"},
+		{name: "numeric int64", srcType: schema.Type{Name: "bigint"}, spType: ddl.Numeric{}, in: int64(42), e: "42"},
+		{name: "numeric float64", srcType: schema.Type{Name: "float8"}, spType: ddl.Numeric{}, in: float64(42.6), e: "42.6"},
 		{name: "string", srcType: schema.Type{Name: "text"}, spType: ddl.String{Len: ddl.MaxLength{}}, in: "eh", e: "eh"},
 		{name: "string bool", srcType: schema.Type{Name: "bool"}, spType: ddl.String{Len: ddl.MaxLength{}}, in: true, e: "true"},
 		{name: "string byte", srcType: schema.Type{Name: "bytea"}, spType: ddl.String{Len: ddl.MaxLength{}}, in: []byte("abc"), e: "abc"},
@@ -301,6 +380,16 @@ func TestConvertSqlRow_MultiCol(t *testing.T) {
 			cols:  []string{"column_name", "constraint_type"},
 			rows:  [][]driver.Value{}, // No primary key --> force generation of synthetic key.
 		},
+		{
+			query: "SELECT (.+) FROM information_schema.referential_constraints (.+)",
+			args:  []driver.Value{"public", "test"},
+			cols:  []string{"constraint_name", "column_name", "table_schema", "table_name", "column_name", "delete_rule", "update_rule"},
+		},
+		{
+			query: "SELECT (.+) FROM pg_catalog.pg_index (.+)",
+			args:  []driver.Value{"public", "test"},
+			cols:  []string{"relname", "indisunique", "attname", "key", "desc", "amname", "predicate"},
+		},
 		// Note: go-sqlmock mocks specify an ordered sequence
 		// of queries and results.  This (repeated) entry is
 		// needed because ProcessSqlData (redundantly) gets
@@ -360,6 +449,31 @@ func TestSetRowStats(t *testing.T) {
 	assert.Equal(t, int64(0), conv.Unexpecteds())
 }
 
+func TestEstimateSqlRows(t *testing.T) {
+	ms := []mockSpec{
+		{
+			query: "SELECT table_schema, table_name FROM information_schema.tables where table_type = 'BASE TABLE'",
+			cols:  []string{"table_schema", "table_name"},
+			rows:  [][]driver.Value{{"public", "test1"}, {"s", "test2"}},
+		}, {
+			query: "SELECT c.reltuples FROM pg_class (.+)",
+			args:  []driver.Value{"public", "test1"},
+			cols:  []string{"reltuples"},
+			rows:  [][]driver.Value{{1234.0}},
+		}, {
+			// Never analyzed.
+			query: "SELECT c.reltuples FROM pg_class (.+)",
+			args:  []driver.Value{"s", "test2"},
+			cols:  []string{"reltuples"},
+			rows:  [][]driver.Value{{-1.0}},
+		},
+	}
+	db := mkMockDB(t, ms)
+	conv := MakeConv()
+	assert.Equal(t, map[string]int64{"test1": 1234}, EstimateSqlRows(conv, db))
+	assert.Equal(t, int64(0), conv.Unexpecteds())
+}
+
 func mkMockDB(t *testing.T, ms []mockSpec) *sql.DB {
 	db, mock, err := sqlmock.New()
 	assert.Nil(t, err)
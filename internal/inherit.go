@@ -0,0 +1,245 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+)
+
+// PostgreSQL tables can inherit from other tables (CREATE TABLE ...
+// INHERITS), which Spanner doesn't support. pg_dump gives only the
+// child's own columns, so we copy the inherited columns from the
+// parents into the child's schema. Then, depending on the
+// InheritanceStrategy, children are either converted to separate
+// Spanner tables, or their rows are merged into the table they inherit
+// from, with a discriminator column giving the source table of each
+// row.
+
+// InheritanceStrategy determines how tables that inherit from other
+// tables are converted.
+type InheritanceStrategy string
+
+// Inheritance strategies.
+const (
+	InheritanceSeparate InheritanceStrategy = "separate" // Children are separate Spanner tables (the default).
+	InheritanceMerge    InheritanceStrategy = "merge"    // Rows of children are written to the table they inherit from.
+)
+
+// ParseInheritanceStrategy returns the strategy named s (empty for the
+// default).
+func ParseInheritanceStrategy(s string) (InheritanceStrategy, error) {
+	switch x := InheritanceStrategy(s); x {
+	case "":
+		return InheritanceSeparate, nil
+	case InheritanceSeparate, InheritanceMerge:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown inheritance strategy %q: expected separate or merge", s)
+}
+
+// SetInheritanceStrategy configures the conversion of tables that
+// inherit from other tables. It must be called before schema
+// conversion.
+func (conv *Conv) SetInheritanceStrategy(s InheritanceStrategy) {
+	conv.inheritance.strategy = s
+}
+
+// inheritance tracks tables that inherit from other tables.
+type inheritance struct {
+	strategy InheritanceStrategy
+	parents  map[string][]string // Maps child table to the tables it inherits from, in order.
+	columns  map[string][]string // Maps child table to the columns it inherits.
+	merged   map[string]string   // Maps merged child table to the table its rows are written to (merge strategy only).
+	column   map[string]string   // Discriminator column of tables that children were merged into.
+	order    map[string][]string // Columns of merged child tables, for INSERTs that don't list them.
+}
+
+// inheritColumns adds the columns that table inherits from parents to
+// its columns (colNames and colDef), and records the inheritance.
+// As in PostgreSQL, inherited columns come first, and columns of the
+// same name are merged.
+func (conv *Conv) inheritColumns(table string, parents []string, colNames []string, colDef map[string]schema.Column) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range parents {
+		pt, ok := conv.srcSchema[p]
+		if !ok {
+			conv.unexpected(fmt.Sprintf("Table %s inherits from %s, which wasn't found", table, p))
+			continue
+		}
+		for _, c := range pt.ColNames {
+			col := pt.ColDefs[c]
+			if local, ok := colDef[c]; ok {
+				col.NotNull = col.NotNull || local.NotNull
+				col.Ignored.Default = col.Ignored.Default || local.Ignored.Default
+			}
+			colDef[c] = col
+			if !seen[c] {
+				seen[c] = true
+				names = append(names, c)
+			}
+		}
+	}
+	inherited := names
+	for _, c := range colNames {
+		if !seen[c] {
+			names = append(names, c)
+		}
+	}
+	conv.inheritance.parents[table] = parents
+	conv.inheritance.columns[table] = inherited
+	return names
+}
+
+// mergeInherited merges the schemas of children into the tables they
+// inherit from, for the merge strategy. Children that inherit from
+// several tables aren't merged. It must be called at the end of the
+// schema pass, before schemaToDDL.
+func (conv *Conv) mergeInherited() {
+	if conv.inheritance.strategy != InheritanceMerge {
+		return
+	}
+	var children []string
+	for c, parents := range conv.inheritance.parents {
+		if _, ok := conv.srcSchema[c]; ok && len(parents) == 1 {
+			conv.inheritance.merged[c] = parents[0]
+			children = append(children, c)
+		}
+	}
+	sort.Strings(children)
+	for _, c := range children {
+		root := conv.mergeRoot(c)
+		rt, ok := conv.srcSchema[root]
+		if !ok {
+			delete(conv.inheritance.merged, c)
+			continue
+		}
+		col, ok := conv.inheritance.column[root]
+		if !ok {
+			col = discriminatorName(rt)
+			conv.inheritance.column[root] = col
+			rt.ColNames = append(rt.ColNames, col)
+			rt.ColDefs[col] = schema.Column{Name: col, Type: schema.Type{Name: "text"}, NotNull: true}
+		}
+		// Columns the child adds are nullable, since rows of other
+		// tables don't have them.
+		ct := conv.srcSchema[c]
+		for _, name := range ct.ColNames {
+			if _, ok := rt.ColDefs[name]; !ok {
+				cd := ct.ColDefs[name]
+				cd.NotNull = false
+				rt.ColNames = append(rt.ColNames, name)
+				rt.ColDefs[name] = cd
+			}
+		}
+		conv.srcSchema[root] = rt
+		for _, i := range ct.Indexes {
+			conv.addDroppedObject("index", i.Name, []string{c}, conv.indexSQL[c][i.Name], fmt.Sprintf("its table was merged into table %s", root))
+		}
+		conv.inheritance.order[c] = ct.ColNames
+		delete(conv.srcSchema, c)
+		// Rows were counted in the schema pass, before we knew where
+		// they would be written.
+		conv.statsMoveRows(c, root)
+	}
+	// PostgreSQL doesn't enforce primary keys across inheritance, so
+	// rows of different tables can have the same key.
+	for root, col := range conv.inheritance.column {
+		rt := conv.srcSchema[root]
+		if len(rt.PrimaryKeys) > 0 {
+			rt.PrimaryKeys = append(rt.PrimaryKeys, schema.Key{Column: col})
+			conv.srcSchema[root] = rt
+		}
+	}
+}
+
+// mergeRoot returns the table that the rows of table are written to:
+// the top of its chain of merged tables.
+func (conv *Conv) mergeRoot(table string) string {
+	for i := 0; i <= len(conv.inheritance.merged); i++ { // Guard against cycles.
+		parent, ok := conv.inheritance.merged[table]
+		if !ok {
+			break
+		}
+		table = parent
+	}
+	return table
+}
+
+// mergeTarget returns the table that rows of source table are written
+// to, and the discriminator column to add to its rows (empty if none).
+func (conv *Conv) mergeTarget(table string) (string, string) {
+	root := conv.mergeRoot(table)
+	return root, conv.inheritance.column[root]
+}
+
+// addDiscriminator adds discriminator column disc (if non-empty) with
+// value srcTable to the columns and values of a row.
+func addDiscriminator(cols, vals []string, disc, srcTable string) ([]string, []string) {
+	if disc == "" {
+		return cols, vals
+	}
+	return append(cols, disc), append(vals, srcTable)
+}
+
+// discriminatorName returns a name for the discriminator column of
+// table t that doesn't clash with its columns.
+func discriminatorName(t schema.Table) string {
+	base := "source_table"
+	name := base
+	for i := 0; ; i++ {
+		if _, ok := t.ColDefs[name]; !ok {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// inheritanceIssues returns warnings for the inheritance of table:
+// for a child table, the columns it inherits, and for a table that
+// children were merged into, a warning for each merged child.
+func (conv *Conv) inheritanceIssues(table string) []groupIssue {
+	var l []groupIssue
+	if parents, ok := conv.inheritance.parents[table]; ok {
+		detail := ""
+		if conv.inheritance.strategy == InheritanceMerge {
+			detail = "it wasn't merged because it inherits from several tables"
+		}
+		l = append(l, groupIssue{issue: inherited, cols: conv.inheritance.columns[table], construct: strings.Join(parents, ", "), detail: detail})
+	}
+	col, ok := conv.inheritance.column[table]
+	if !ok {
+		return l
+	}
+	var children []string
+	for c := range conv.inheritance.merged {
+		if conv.mergeRoot(c) == table {
+			children = append(children, c)
+		}
+	}
+	sort.Strings(children)
+	detail := ""
+	if pks := conv.srcSchema[table].PrimaryKeys; len(pks) > 0 && pks[len(pks)-1].Column == col {
+		detail = fmt.Sprintf("Column '%s' was added to the primary key, since PostgreSQL doesn't enforce primary keys across inherited tables", col)
+	}
+	for _, c := range children {
+		l = append(l, groupIssue{issue: inheritedMerged, cols: []string{col}, construct: c, detail: detail})
+	}
+	return l
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+const inheritDump = "CREATE TABLE public.p (id bigint NOT NULL, name text);\n" +
+	"CREATE TABLE public.q (flag boolean);\n" +
+	"CREATE TABLE public.c (extra bigint, name text NOT NULL) INHERITS (public.p);\n" +
+	"CREATE TABLE public.g (more text) INHERITS (public.c);\n" +
+	"CREATE TABLE public.m (x bigint) INHERITS (public.p, public.q);\n" +
+	"COPY public.p (id, name) FROM stdin;\n1\ta\n\\.\n" +
+	"COPY public.c (id, name, extra) FROM stdin;\n2\tb\t7\n\\.\n" +
+	"INSERT INTO public.g (id, name, extra, more) VALUES (3, 'c', 8, 'z');\n" +
+	"COPY public.m (id, name, flag, x) FROM stdin;\n4\td\tt\t9\n\\.\n" +
+	"ALTER TABLE ONLY public.p ADD CONSTRAINT p_pkey PRIMARY KEY (id);\n" +
+	"CREATE INDEX c_extra_idx ON public.c USING btree (extra);\n"
+
+func TestProcessPgDump_InheritanceSeparate(t *testing.T) {
+	conv, rows := runProcessPgDump(inheritDump)
+	noIssues(conv, t, "separate")
+	assert.Equal(t, []string{"c", "g", "m", "p", "q"}, sortedSrcTables(conv))
+	assert.Equal(t, []string{"id", "name", "extra"}, conv.srcSchema["c"].ColNames)
+	assert.True(t, conv.srcSchema["c"].ColDefs["name"].NotNull)
+	assert.Equal(t, []string{"id", "name", "extra", "more"}, conv.srcSchema["g"].ColNames)
+	assert.Equal(t, []string{"id", "name", "flag", "x"}, conv.srcSchema["m"].ColNames)
+	assert.Equal(t, []groupIssue{{issue: inherited, cols: []string{"id", "name", "extra"}, construct: "c"}}, conv.groupIssues["g"])
+	assert.Equal(t, []groupIssue{{issue: inherited, cols: []string{"id", "name", "flag"}, construct: "p, q"}}, conv.groupIssues["m"])
+	assert.Equal(t, 1, len(conv.spSchema["c"].Indexes))
+	assert.Equal(t, []spannerData{
+		{table: "p", cols: []string{"id", "name"}, vals: []interface{}{int64(1), "a"}},
+		{table: "c", cols: []string{"id", "name", "extra", "synth_id"}, vals: []interface{}{int64(2), "b", int64(7), int64(0)}},
+		{table: "g", cols: []string{"id", "name", "extra", "more", "synth_id"}, vals: []interface{}{int64(3), "c", int64(8), "z", int64(0)}},
+		{table: "m", cols: []string{"id", "name", "flag", "x", "synth_id"}, vals: []interface{}{int64(4), "d", true, int64(9), int64(0)}},
+	}, rows)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, normalizeSpace(buf.String()), "Table inherits from p, q: its inherited columns (id, name, flag) were copied into this table, which was converted as a separate table. Spanner has no table inheritance")
+}
+
+func TestProcessPgDump_InheritanceMerge(t *testing.T) {
+	conv := MakeConv()
+	conv.SetInheritanceStrategy(InheritanceMerge)
+	conv, rows := runProcessPgDumpConv(conv, inheritDump)
+	noIssues(conv, t, "merge")
+	// m inherits from several tables, so it isn't merged.
+	assert.Equal(t, []string{"m", "p", "q"}, sortedSrcTables(conv))
+	pt := stripSchemaComments(conv.spSchema)["p"]
+	assert.Equal(t, []string{"id", "name", "source_table", "extra", "more"}, pt.ColNames)
+	assert.Equal(t, ddl.ColumnDef{Name: "source_table", T: ddl.String{Len: ddl.MaxLength{}}, NotNull: true}, pt.ColDefs["source_table"])
+	assert.False(t, pt.ColDefs["extra"].NotNull)
+	assert.Equal(t, []ddl.IndexKey{{Col: "id"}, {Col: "source_table"}}, pt.Pks)
+	assert.Empty(t, pt.Indexes)
+	assert.Equal(t, 1, len(conv.dropped))
+	assert.Equal(t, "c_extra_idx", conv.dropped[0].name)
+	assert.Equal(t, int64(3), conv.stats.rows["p"])
+	assert.Equal(t, []spannerData{
+		{table: "p", cols: []string{"id", "name", "source_table"}, vals: []interface{}{int64(1), "a", "p"}},
+		{table: "p", cols: []string{"id", "name", "extra", "source_table"}, vals: []interface{}{int64(2), "b", int64(7), "c"}},
+		{table: "p", cols: []string{"id", "name", "extra", "more", "source_table"}, vals: []interface{}{int64(3), "c", int64(8), "z", "g"}},
+		{table: "m", cols: []string{"id", "name", "flag", "x", "synth_id"}, vals: []interface{}{int64(4), "d", true, int64(9), int64(0)}},
+	}, rows)
+	detail := "Column 'source_table' was added to the primary key, since PostgreSQL doesn't enforce primary keys across inherited tables"
+	assert.Equal(t, []groupIssue{
+		{issue: inheritedMerged, cols: []string{"source_table"}, construct: "c", detail: detail},
+		{issue: inheritedMerged, cols: []string{"source_table"}, construct: "g", detail: detail},
+	}, conv.groupIssues["p"])
+	assert.Equal(t, "it wasn't merged because it inherits from several tables", conv.groupIssues["m"][0].detail)
+}
+
+func TestParseInheritanceStrategy(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected InheritanceStrategy
+		err      bool
+	}{
+		{"", InheritanceSeparate, false},
+		{"separate", InheritanceSeparate, false},
+		{"merge", InheritanceMerge, false},
+		{"flatten", "", true},
+	}
+	for _, tc := range tests {
+		s, err := ParseInheritanceStrategy(tc.s)
+		assert.Equal(t, tc.err, err != nil, tc.s)
+		assert.Equal(t, tc.expected, s, tc.s)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// InputFormat is the format of a dump, as recognized from its start by
+// DetectInputFormat.
+type InputFormat string
+
+// Input formats.
+const (
+	InputFormatUnknown   InputFormat = "unknown"
+	InputFormatPgDump    InputFormat = "pg_dump"         // Plain-format (SQL) pg_dump output.
+	InputFormatPgArchive InputFormat = "pg_dump archive" // Custom-format (pg_dump -Fc) or tar-format (pg_dump -Ft) archive.
+	InputFormatMySQLDump InputFormat = "mysqldump"       // mysqldump (or MariaDB's mariadb-dump) output.
+)
+
+// InputSniffBytes is the number of bytes at the start of a dump that
+// DetectInputFormat looks at.
+const InputSniffBytes = 4096
+
+// DetectInputFormat recognizes the format of a dump from its first
+// bytes (up to InputSniffBytes of them), which must be
+// decompressed. pg_dump archives start with "PGDMP" (custom format) or
+// a tar header for toc.dat (tar format). Plain-format pg_dump output
+// and mysqldump output are recognized by the comments they start with,
+// or failing that, by the statements that set up the session: pg_dump
+// calls set_config and sets statement_timeout, while mysqldump uses
+// MySQL's versioned comments (e.g. "/*!40101 SET NAMES utf8 */").
+func DetectInputFormat(b []byte) InputFormat {
+	if len(b) > InputSniffBytes {
+		b = b[:InputSniffBytes]
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")) // UTF-8 byte order mark.
+	switch {
+	case bytes.HasPrefix(b, []byte("PGDMP")), bytes.HasPrefix(b, []byte("toc.dat\x00")):
+		return InputFormatPgArchive
+	case bytes.Contains(b, []byte("-- MySQL dump")), bytes.Contains(b, []byte("-- MariaDB dump")):
+		return InputFormatMySQLDump
+	case bytes.Contains(b, []byte("-- PostgreSQL database dump")):
+		return InputFormatPgDump
+	case bytes.Contains(b, []byte("/*!40")), bytes.Contains(b, []byte("/*M!")):
+		return InputFormatMySQLDump
+	case bytes.Contains(b, []byte("pg_catalog.set_config(")), bytes.Contains(b, []byte("SET statement_timeout")):
+		return InputFormatPgDump
+	}
+	return InputFormatUnknown
+}
+
+// SetInputFormat records the format of the dump being converted, as
+// recognized by DetectInputFormat, for the report.
+func (conv *Conv) SetInputFormat(f InputFormat) {
+	conv.inputFormat = f
+}
+
+// inputFormatMsg describes input format f (see DetectInputFormat) for
+// the report header, or returns "" if f wasn't recorded.
+func inputFormatMsg(f string) string {
+	switch InputFormat(f) {
+	case "":
+		return ""
+	case InputFormatUnknown:
+		return "Input format: not recognized from the start of the input"
+	}
+	return fmt.Sprintf("Input format: %s (recognized from the start of the input)", f)
+}
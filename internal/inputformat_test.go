@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectInputFormat(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  InputFormat
+	}{
+		{"PGDMP\x01\x0e\x00\x04\x08\x01\x01\x01", InputFormatPgArchive},
+		{"toc.dat\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x000000600", InputFormatPgArchive},
+		{"--\n-- PostgreSQL database dump\n--\n\n-- Dumped from database version 13.4\n", InputFormatPgDump},
+		{"\xef\xbb\xbf--\n-- PostgreSQL database dump\n--\n", InputFormatPgDump},
+		{"SET statement_timeout = 0;\nSET lock_timeout = 0;\n", InputFormatPgDump},
+		{"SELECT pg_catalog.set_config('search_path', '', false);\n", InputFormatPgDump},
+		{"-- MySQL dump 10.13  Distrib 8.0.26, for Linux (x86_64)\n--\n-- Host: localhost    Database: shop\n", InputFormatMySQLDump},
+		{"-- MariaDB dump 10.19  Distrib 10.5.12-MariaDB, for Linux (x86_64)\n", InputFormatMySQLDump},
+		{"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n", InputFormatMySQLDump},
+		{"CREATE TABLE t (id bigint PRIMARY KEY);\n", InputFormatUnknown},
+		{"", InputFormatUnknown},
+		// Only the start of the input is looked at.
+		{strings.Repeat("\n", InputSniffBytes) + "-- MySQL dump 10.13\n", InputFormatUnknown},
+	} {
+		assert.Equal(t, tc.want, DetectInputFormat([]byte(tc.input)), tc.input)
+	}
+}
+
+func TestInputFormatReport(t *testing.T) {
+	for _, tc := range []struct {
+		format InputFormat
+		want   string
+	}{
+		{"", ""},
+		{InputFormatUnknown, "Input format: not recognized from the start of the input.\n"},
+		{InputFormatMySQLDump, "Input format: mysqldump (recognized from the start of the input).\n"},
+	} {
+		conv, _ := runProcessMySQLDump("CREATE TABLE t (id int PRIMARY KEY);\n")
+		conv.SetInputFormat(tc.format)
+		b := new(bytes.Buffer)
+		w := bufio.NewWriter(b)
+		GenerateReport(MySQLDumpSource, conv, w, nil)
+		w.Flush()
+		assert.Equal(t, tc.want != "", strings.HasPrefix(b.String(), tc.want+"\n"), b.String())
+		assert.Equal(t, string(tc.format), BuildReport(MySQLDumpSource, conv, nil).InputFormat)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "fmt"
+
+// SetCreatedInstance records that the Spanner instance name (its full
+// name) was created for the conversion, with instance config config
+// and compute capacity nodes or processingUnits, so that the report
+// header gives them.
+func (conv *Conv) SetCreatedInstance(name, config string, nodes, processingUnits int32) {
+	conv.newInstance = &ReportCreatedInstance{Name: name, Config: config, Nodes: nodes, ProcessingUnits: processingUnits}
+}
+
+// createdInstanceMsg describes the Spanner instance created for the
+// conversion, for the report header, or returns "" if none was.
+func createdInstanceMsg(i *ReportCreatedInstance) string {
+	if i == nil {
+		return ""
+	}
+	capacity := fmt.Sprintf("%d processing units", i.ProcessingUnits)
+	switch {
+	case i.Nodes == 1:
+		capacity = "1 node"
+	case i.Nodes > 1:
+		capacity = fmt.Sprintf("%d nodes", i.Nodes)
+	}
+	return fmt.Sprintf("Spanner instance created for this conversion: %s (config %s, %s)", i.Name, i.Config, capacity)
+}
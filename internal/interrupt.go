@@ -0,0 +1,166 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+)
+
+// Conversions can be interrupted (e.g. by SIGINT), in which case data
+// conversion stops reading the source, and the report covers the rows
+// read until then. Rows that weren't read are left out of the row
+// counts, as rows skipped by row sampling are, and the summary says
+// where conversion stopped.
+
+// interruption describes an interrupted conversion.
+type interruption struct {
+	unread    map[string]int64 // Rows not read, keyed by source table.
+	abandoned int64            // Rows converted, but not written because of the interruption.
+}
+
+// lastRow is the last data row read: the row-th row of table.
+type lastRow struct {
+	table string
+	row   int64
+}
+
+// rowRead records that a row of srcTable, with values of size bytes,
+// was read during data conversion.
+func (conv *Conv) rowRead(srcTable string, bytes int64) {
+	if srcTable != conv.lastRead.table {
+		conv.tableRead()
+		conv.lastRead = lastRow{table: srcTable}
+		conv.metrics.setTable(srcTable)
+	}
+	conv.lastRead.row++
+	conv.metrics.add(metricRowsRead, srcTable, 1)
+	conv.metrics.add(metricBytesRead, srcTable, bytes)
+	if conv.progress != nil {
+		conv.progress.AddRow(srcTable, bytes)
+	}
+}
+
+// sqlRowRead is like rowRead, for sources whose tables can be read
+// concurrently (see ProcessSqlDataParallel): row is the position of the
+// row in srcTable (from 1), and the end of each table is signaled by
+// sqlTableDone.
+func (conv *Conv) sqlRowRead(srcTable string, row, bytes int64) {
+	conv.lastRead = lastRow{table: srcTable, row: row}
+	conv.metrics.add(metricRowsRead, srcTable, 1)
+	conv.metrics.add(metricBytesRead, srcTable, bytes)
+	if conv.progress != nil {
+		conv.progress.AddRow(srcTable, bytes)
+	}
+}
+
+// sqlTableDone logs that all rows rows of srcTable were read (see
+// sqlRowRead).
+func (conv *Conv) sqlTableDone(srcTable string, rows int64) {
+	conv.metrics.endTable(srcTable)
+	conv.tableDone(srcTable, rows)
+}
+
+// tableRead logs that the rows of the last table read have all been
+// read. Sources give each table's rows together, except for dumps with
+// several COPY-FROM blocks or INSERT statements for a table, where each
+// run of rows is logged.
+func (conv *Conv) tableRead() {
+	if t := conv.lastRead.table; t != "" {
+		conv.tableDone(t, conv.lastRead.row)
+	}
+}
+
+// tableDone logs that rows rows of srcTable were read, and that no
+// more are being read for now.
+func (conv *Conv) tableDone(srcTable string, rows int64) {
+	Log().Infof("Finished reading table %s: %d rows read (%d converted and %d bad so far)", srcTable, rows, conv.stats.goodRows[srcTable], conv.stats.badRows[srcTable])
+	if conv.progress != nil {
+		conv.progress.TableDone(srcTable)
+	}
+}
+
+// SetInterrupted records that the conversion was interrupted: data
+// conversion stopped reading the source (or never started), so rows
+// after the last one read weren't converted. abandoned gives the rows
+// that were converted, but not written because of the interruption,
+// keyed by source table (they should also be included in the bad
+// writes passed to GenerateReport).
+func (conv *Conv) SetInterrupted(abandoned map[string]int64) {
+	in := &interruption{unread: make(map[string]int64)}
+	for t, rows := range conv.stats.rows {
+		n := rows - conv.unsampledRows(t) - conv.stats.goodRows[t] - conv.stats.badRows[t]
+		if n > 0 {
+			in.unread[t] = n
+		}
+	}
+	for _, n := range abandoned {
+		in.abandoned += n
+	}
+	conv.interrupted = in
+}
+
+// Interrupted returns whether the conversion was interrupted (see
+// SetInterrupted).
+func (conv *Conv) Interrupted() bool {
+	return conv.interrupted != nil
+}
+
+// unreadRows returns the number of data rows of srcTable that weren't
+// read because the conversion was interrupted. If srcTable is empty,
+// it returns the total for all tables.
+func (conv *Conv) unreadRows(srcTable string) int64 {
+	if conv.interrupted == nil {
+		return 0
+	}
+	if srcTable != "" {
+		return conv.interrupted.unread[srcTable]
+	}
+	n := int64(0)
+	for _, x := range conv.interrupted.unread {
+		n += x
+	}
+	return n
+}
+
+// interruptSummary returns a note on the interruption for the report
+// summary, or "" if the conversion wasn't interrupted.
+func interruptSummary(conv *Conv) string {
+	in := conv.interrupted
+	if in == nil {
+		return ""
+	}
+	msg := "CONVERSION INTERRUPTED: "
+	if conv.lastRead.table == "" {
+		msg += "the conversion stopped before any data rows were read"
+	} else {
+		msg += fmt.Sprintf("data conversion stopped after reading row %d of table %s", conv.lastRead.row, conv.lastRead.table)
+	}
+	if n := conv.unreadRows(""); n > 0 {
+		msg += fmt.Sprintf(". Rows not read, and left out of this report: %d", n)
+	}
+	if in.abandoned > 0 {
+		msg += fmt.Sprintf(". Rows converted, but not written to %s because of the interruption: %d", conv.DataTarget(), in.abandoned)
+	}
+	return msg
+}
+
+// unreadMsg describes n rows of a table that weren't read because the
+// conversion was interrupted. Returns "" if n is zero.
+func unreadMsg(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Rows not read because the conversion was interrupted (not included above): %d", n)
+}
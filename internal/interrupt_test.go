@@ -0,0 +1,82 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterrupted(t *testing.T) {
+	s := "CREATE TABLE a (id bigint PRIMARY KEY);\n" +
+		"CREATE TABLE b (id bigint PRIMARY KEY);\n" +
+		"COPY public.a (id) FROM stdin;\n" +
+		"1\n" +
+		"2\n" +
+		"\\.\n" +
+		"COPY public.b (id) FROM stdin;\n" +
+		"3\n" +
+		"4\n" +
+		"5\n" +
+		"\\.\n"
+	conv := MakeConv()
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	conv.SetDataMode()
+	// Interrupt data conversion once the first row of b is converted.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var rows []int64
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {
+		rows = append(rows, vals[0].(int64))
+		if table == "b" {
+			cancel()
+		}
+	})
+	ProcessPgDump(conv, NewReaderContext(ctx, bufio.NewReader(strings.NewReader(s)), nil))
+	assert.Equal(t, []int64{1, 2, 3}, rows)
+	assert.False(t, conv.Interrupted())
+	conv.SetInterrupted(map[string]int64{"b": 1})
+	assert.True(t, conv.Interrupted())
+	assert.Equal(t, map[string]int64{"b": 2}, conv.interrupted.unread)
+
+	badWrites := map[string]int64{"b": 1}
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	summary := GenerateReport(PgDumpSource, conv, w, badWrites)
+	w.Flush()
+	assert.True(t, strings.HasPrefix(summary, "CONVERSION INTERRUPTED: data conversion stopped after reading row 1 of table b. "+
+		"Rows not read, and left out of this report: 2. "+
+		"Rows converted, but not written to Spanner because of the interruption: 1.\n"), summary)
+	r := buf.String()
+	assert.Contains(t, r, "Rows not read because the conversion was interrupted (not included above): 2.\n")
+	// Rows that weren't read don't count against the ratings.
+	assert.Contains(t, r, "Data conversion: POOR (67% of 3 rows written to Spanner)")
+	assert.NotContains(t, r, "Inconsistent row counts")
+
+	report := BuildReport(PgDumpSource, conv, badWrites)
+	assert.Equal(t, &ReportInterruption{LastTable: "b", LastRow: 1, UnreadRows: 2, AbandonedRows: 1}, report.Interrupted)
+	assert.Equal(t, int64(2), report.Tables[1].UnreadRows)
+
+	// Nothing read at all.
+	conv = MakeConv()
+	conv.SetInterrupted(nil)
+	assert.Equal(t, "CONVERSION INTERRUPTED: the conversion stopped before any data rows were read", interruptSummary(conv))
+}
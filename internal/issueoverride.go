@@ -0,0 +1,190 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Some issues (e.g. the timestamp and widened notes) can dominate a
+// report without being of interest. Users can suppress issues, which
+// leaves them out of the report and out of the warning counts used for
+// schema ratings, or override their severity e.g. downgrade a warning
+// to a note. The report header lists any overrides, so that shared
+// reports aren't misleading.
+
+// IssueOverrides changes how schema issues are reported. Issues are
+// named as in the JSON report e.g. "widened".
+type IssueOverrides struct {
+	Suppress []string          // Issues left out of the report.
+	Severity map[string]string // Severity ("warning" or "note") to report issues with, keyed by issue name.
+}
+
+// issueOverrides is the validated form of IssueOverrides.
+type issueOverrides struct {
+	suppress map[schemaIssue]bool
+	severity map[schemaIssue]severity
+}
+
+// ParseIssueNames parses a comma-separated list of issue names e.g.
+// "timestamp,widened". The names are checked by SetIssueOverrides.
+func ParseIssueNames(s string) []string {
+	var l []string
+	for _, n := range strings.Split(s, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			l = append(l, n)
+		}
+	}
+	return l
+}
+
+// ParseIssueSeverities parses a comma-separated list of issue=severity
+// pairs e.g. "numeric=note,widened=warning". The names and severities
+// are checked by SetIssueOverrides.
+func ParseIssueSeverities(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, p := range ParseIssueNames(s) {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad issue severity %q: expected issue=severity e.g. widened=warning", p)
+		}
+		m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return m, nil
+}
+
+// Validate returns an error if o names unknown issues or severities.
+func (o IssueOverrides) Validate() error {
+	_, err := o.resolve()
+	return err
+}
+
+func (o IssueOverrides) resolve() (issueOverrides, error) {
+	r := issueOverrides{suppress: make(map[schemaIssue]bool), severity: make(map[schemaIssue]severity)}
+	for _, n := range o.Suppress {
+		i, err := issueNamed(n)
+		if err != nil {
+			return r, err
+		}
+		r.suppress[i] = true
+	}
+	for n, s := range o.Severity {
+		i, err := issueNamed(n)
+		if err != nil {
+			return r, err
+		}
+		switch strings.ToLower(s) {
+		case "warning":
+			r.severity[i] = warning
+		case "note":
+			r.severity[i] = note
+		default:
+			return r, fmt.Errorf("unknown severity %q for issue %s: must be warning or note", s, i)
+		}
+	}
+	return r, nil
+}
+
+// issueNamed returns the issue named n (case insensitive).
+func issueNamed(n string) (schemaIssue, error) {
+	var names []string
+	for i := range issueDB {
+		if strings.EqualFold(i.String(), n) {
+			if i == missingPrimaryKey {
+				return 0, fmt.Errorf("issue %s can't be suppressed or overridden, since the synthetic primary key column it describes is part of the Spanner schema", i)
+			}
+			return i, nil
+		}
+		if i != missingPrimaryKey {
+			names = append(names, i.String())
+		}
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("unknown issue %q: must be one of %s", n, strings.Join(names, ", "))
+}
+
+// SetIssueOverrides sets how schema issues are reported. It returns an
+// error if o names unknown issues or severities.
+func (conv *Conv) SetIssueOverrides(o IssueOverrides) error {
+	r, err := o.resolve()
+	if err != nil {
+		return err
+	}
+	conv.issueOverrides = r
+	return nil
+}
+
+// issueSuppressed returns whether issue i is left out of the report.
+func (conv *Conv) issueSuppressed(i schemaIssue) bool {
+	return conv.issueOverrides.suppress[i]
+}
+
+// severity returns the severity that issue i is reported with.
+func (conv *Conv) severity(i schemaIssue) severity {
+	if s, ok := conv.issueOverrides.severity[i]; ok {
+		return s
+	}
+	if x, ok := pgIssues[i]; ok && conv.pg() {
+		return x.severity
+	}
+	return issueDB[i].severity
+}
+
+// suppressedIssues returns the names of the suppressed issues, sorted.
+func (conv *Conv) suppressedIssues() []string {
+	var l []string
+	for i := range conv.issueOverrides.suppress {
+		l = append(l, i.String())
+	}
+	sort.Strings(l)
+	return l
+}
+
+// issueSeverities returns the severities of issues whose severity was
+// overridden, keyed by issue name (nil if none).
+func (conv *Conv) issueSeverities() map[string]string {
+	if len(conv.issueOverrides.severity) == 0 {
+		return nil
+	}
+	m := make(map[string]string)
+	for i, s := range conv.issueOverrides.severity {
+		m[i.String()] = s.String()
+	}
+	return m
+}
+
+// issueOverridesMsg describes the issue overrides, given as the
+// suppressed issues and the overridden severities keyed by issue name,
+// for the report header (empty if there are none).
+func issueOverridesMsg(suppressed []string, m map[string]string) string {
+	var l []string
+	if len(suppressed) > 0 {
+		l = append(l, "suppressed "+strings.Join(suppressed, ", "))
+	}
+	var names []string
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		l = append(l, fmt.Sprintf("%s reported as a %s", n, m[n]))
+	}
+	if len(l) == 0 {
+		return ""
+	}
+	return "Schema issue overrides: " + strings.Join(l, "; ")
+}
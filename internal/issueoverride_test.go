@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIssueSeverities(t *testing.T) {
+	m, err := ParseIssueSeverities(" numeric=note, widened = warning,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"numeric": "note", "widened": "warning"}, m)
+	_, err = ParseIssueSeverities("numeric")
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"timestamp", "widened"}, ParseIssueNames("timestamp, widened,"))
+}
+
+func TestIssueOverrides_Validate(t *testing.T) {
+	tests := []struct {
+		o   IssueOverrides
+		err string // Substring of the error (empty if none).
+	}{
+		{IssueOverrides{}, ""},
+		{IssueOverrides{Suppress: []string{"Timestamp", "widened"}, Severity: map[string]string{"numeric": "NOTE"}}, ""},
+		{IssueOverrides{Suppress: []string{"widen"}}, `unknown issue "widen": must be one of badValue, `},
+		{IssueOverrides{Severity: map[string]string{"numeric": "error"}}, `unknown severity "error" for issue numeric`},
+		{IssueOverrides{Suppress: []string{"missingPrimaryKey"}}, "issue missingPrimaryKey can't be suppressed"},
+	}
+	for _, tc := range tests {
+		err := tc.o.Validate()
+		if tc.err == "" {
+			assert.Nil(t, err, "%v", tc.o)
+		} else if assert.NotNil(t, err, "%v", tc.o) {
+			assert.Contains(t, err.Error(), tc.err)
+		}
+	}
+}
+
+func TestIssueOverrides_Report(t *testing.T) {
+	const dump = "CREATE TABLE t (id bigint PRIMARY KEY, n numeric, i integer, ts timestamp);\n"
+	conv, _ := runProcessPgDump(dump)
+	tr := buildTableReport(conv, "t", nil)
+	assert.Equal(t, int64(1), tr.warnings)
+	assert.Equal(t, map[schemaIssue]int64{numeric: 1, widened: 1, timestamp: 1}, tr.issueCols)
+
+	conv = MakeConv()
+	assert.Nil(t, conv.SetIssueOverrides(IssueOverrides{Suppress: []string{"timestamp", "widened"}, Severity: map[string]string{"numeric": "note"}}))
+	runProcessPgDumpConv(conv, dump)
+	tr = buildTableReport(conv, "t", nil)
+	// The numeric warning is now a note, so it doesn't count, and the
+	// suppressed notes are left out.
+	assert.Equal(t, int64(0), tr.warnings)
+	assert.Equal(t, map[schemaIssue]int64{numeric: 1}, tr.issueCols)
+	assert.Equal(t, []tableReportBody{{
+		heading: "Note",
+		lines: []reportLine{
+			{numeric, []string{"n"}, "Column 'n': type numeric is mapped to numeric. " + issueDB[numeric].brief},
+		},
+	}}, tr.body)
+	assert.Equal(t, []issueCount{{issue: numeric, severity: note, tables: 1, cols: 1}}, issueSummary(conv, []tableReport{tr}))
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), "Schema issue overrides: suppressed timestamp, widened; numeric reported as a note.\n")
+	r := BuildReport(PgDumpSource, conv, nil)
+	assert.Equal(t, []string{"timestamp", "widened"}, r.SuppressedIssues)
+	assert.Equal(t, map[string]string{"numeric": "note"}, r.IssueSeverities)
+	assert.Equal(t, "note", r.Tables[0].Issues[0].Severity)
+}
@@ -0,0 +1,636 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// jsonReportVersion is the version of the JSON report schema. It
+// should be incremented for any change that could break consumers
+// (e.g. removing or renaming fields). Adding fields is fine.
+const jsonReportVersion = 1
+
+// The following types define the JSON report schema. They are also
+// the structured report for Go programs (see BuildReport), so that
+// ratings, issues and row stats can be used without parsing the text
+// report. Slices are always emitted (as [] when empty) so consumers
+// don't need to special-case missing fields.
+
+// Report is a structured version of the report generated by
+// GenerateReport.
+type Report struct {
+	Version              int                   `json:"version"`
+	Summary              ReportRatings         `json:"summary"`
+	WritePriority        string                `json:"writePriority,omitempty"`
+	WriteTag             string                `json:"writeTag,omitempty"`
+	DryRun               bool                  `json:"dryRun,omitempty"`      // Data was converted, but not written (see Conv.SetDryRun).
+	Interrupted          *ReportInterruption   `json:"interrupted,omitempty"` // Nil unless the conversion was interrupted.
+	IgnoredStatements    []string              `json:"ignoredStatements"`
+	SchemaMismatch       []string              `json:"schemaMismatch,omitempty"` // Problems found applying a session file or existing Spanner schema (see Conv.ApplySession).
+	StatementStats       []ReportStatement     `json:"statementStats"`
+	DroppedObjects       []ReportDroppedObject `json:"droppedObjects,omitempty"` // In the same order as in the text report.
+	IssueSummary         []ReportIssueCount    `json:"issueSummary"`             // Schema issues aggregated over all tables, most common first.
+	Tables               []ReportTable         `json:"tables"`
+	HotspotTables        []string              `json:"hotspotTables,omitempty"` // Tables whose primary keys increase over time (see the hotspot issue).
+	Verification         []ReportVerification  `json:"verification,omitempty"`  // Nil if there was no verification pass.
+	DDLBatches           []ReportDDLBatch      `json:"ddlBatches,omitempty"`    // Batches of DDL statements that created the Spanner schema (see Conv.SetDDLBatches).
+	DDLFailures          []ReportDDLFailure    `json:"ddlFailures,omitempty"`   // DDL statements applied after data conversion that failed (see Conv.AddAppliedDDL).
+	Timing               *ReportTiming         `json:"timing,omitempty"`
+	Storage              *ReportStorage        `json:"storage,omitempty"`       // Total over all tables.
+	CommitRetries        int64                 `json:"commitRetries,omitempty"` // Writes retried because they failed with transient errors.
+	ResumedRows          int64                 `json:"resumedRows,omitempty"`   // Rows processed by previous runs (see internal.Checkpoint).
+	Sampling             *ReportSampling       `json:"sampling,omitempty"`      // Nil if all rows were converted.
+	BadRowsFile          *ReportBadRowsFile    `json:"badRowsFile,omitempty"`
+	ResourceUsage        *ReportResourceUsage  `json:"resourceUsage,omitempty"`
+	UnexpectedConditions []ReportUnexpected    `json:"unexpectedConditions"`
+	// Schema issues left out of the report, and overridden severities of
+	// schema issues keyed by issue name (see IssueOverrides).
+	SuppressedIssues []string          `json:"suppressedIssues,omitempty"`
+	IssueSeverities  map[string]string `json:"issueSeverities,omitempty"`
+	// The summary as it appears at the top of report.txt (see
+	// GenerateSummary).
+	SummaryText string `json:"summaryText"`
+	// Table definitions discarded by DROP TABLE, and CREATE TABLE
+	// statements skipped because the table was already defined (dumps
+	// only).
+	DroppedTableDefs   int64 `json:"droppedTableDefs,omitempty"`
+	DuplicateTableDefs int64 `json:"duplicateTableDefs,omitempty"`
+	// Statements processed from each dump file, when several were
+	// converted together (see Conv.StartDumpFile).
+	DumpFiles []ReportDumpFile `json:"dumpFiles,omitempty"`
+	// Format of the dump, as recognized from the start of the input
+	// (see DetectInputFormat), or "" if not recorded.
+	InputFormat string `json:"inputFormat,omitempty"`
+	// Statements applied after data conversion (see Conv.AddAppliedDDL),
+	// or nil if none were deferred. Failures are in DDLFailures.
+	DDLApplied *int64 `json:"ddlApplied,omitempty"`
+	// Number of pg_dump reparse events while looking for statement
+	// boundaries.
+	ReparseEvents int64 `json:"reparseEvents,omitempty"`
+	// Queries of the source database, for direct connections (nil for
+	// dumps, which have StatementStats instead).
+	QueryStats *ReportQueryStats `json:"queryStats,omitempty"`
+	// Dialect of the Spanner database: google_standard_sql or
+	// postgresql (see Conv.SetDialect).
+	Dialect string `json:"dialect"`
+	// Validation of the schema against a throwaway database, or nil if
+	// the schema wasn't validated (see Conv.StartDDLValidation).
+	DDLValidation *ReportDDLValidation `json:"ddlValidation,omitempty"`
+	// Comparison of the generated schema with the existing database
+	// that data was converted into, or nil if data wasn't converted into
+	// an existing database (see Conv.CheckExistingSchema).
+	ExistingDB *ReportExistingDB `json:"existingDB,omitempty"`
+	// Full name of the Spanner database that data was written to, or
+	// "" if none (see Conv.SetDatabase).
+	Database string `json:"database,omitempty"`
+	// Spanner instance created for the conversion, or nil if the
+	// instance already existed (see Conv.SetCreatedInstance).
+	CreatedInstance *ReportCreatedInstance `json:"createdInstance,omitempty"`
+	// Snapshot of the source database that data was read in, or nil if
+	// data wasn't read from a database (see BeginSnapshot).
+	Snapshot *ReportSnapshot `json:"snapshot,omitempty"`
+}
+
+// ReportSnapshot is the point in time that data read from a source
+// database represents: the database's snapshot (for PostgreSQL, the
+// txid_current_snapshot of the transaction) and the time it was taken.
+type ReportSnapshot struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// ReportCreatedInstance describes the Spanner instance created for a
+// conversion. Its compute capacity is in Nodes or ProcessingUnits.
+type ReportCreatedInstance struct {
+	Name            string `json:"name"`
+	Config          string `json:"config"`
+	Nodes           int32  `json:"nodes,omitempty"`
+	ProcessingUnits int32  `json:"processingUnits,omitempty"`
+}
+
+// ReportExistingDB describes the tables of the generated schema that
+// don't match the existing database that data was converted into.
+type ReportExistingDB struct {
+	Tables     int                   `json:"tables"`
+	Mismatched []ReportTableMismatch `json:"mismatched"`
+}
+
+// ReportTableMismatch gives the ways that a table of the generated
+// schema differs from the existing database.
+type ReportTableMismatch struct {
+	Table    string   `json:"table"`
+	Problems []string `json:"problems"`
+}
+
+// ReportDDLValidation describes the validation of the schema by
+// applying it to a throwaway database.
+type ReportDDLValidation struct {
+	Where    string             `json:"where"`
+	Accepted int64              `json:"accepted"`
+	Rejected []ReportDDLFailure `json:"rejected"`
+}
+
+// ReportQueryStats counts the queries of a source database, for direct
+// connections.
+type ReportQueryStats struct {
+	TablesRead  int64 `json:"tablesRead"`
+	ColumnsRead int64 `json:"columnsRead"`
+	Queries     int64 `json:"queries"`
+	Errors      int64 `json:"errors"`
+	// Queries about tables, broken down by source schema.
+	Schemas []ReportSchemaQueryStats `json:"schemas,omitempty"`
+}
+
+// ReportSchemaQueryStats counts the queries about the tables of a source
+// schema.
+type ReportSchemaQueryStats struct {
+	Schema      string `json:"schema"`
+	TablesRead  int64  `json:"tablesRead"`
+	ColumnsRead int64  `json:"columnsRead"`
+	Queries     int64  `json:"queries"`
+	Errors      int64  `json:"errors"`
+}
+
+// ReportInterruption describes an interrupted conversion (see
+// Conv.SetInterrupted).
+type ReportInterruption struct {
+	LastTable     string `json:"lastTable,omitempty"` // Table of the last data row read (empty if none was).
+	LastRow       int64  `json:"lastRow,omitempty"`   // Number of the last data row read in LastTable, from 1.
+	UnreadRows    int64  `json:"unreadRows"`
+	AbandonedRows int64  `json:"abandonedRows"` // Rows converted, but not written because of the interruption.
+}
+
+// ReportRatings are the schema and data conversion ratings, overall or
+// for a table.
+type ReportRatings struct {
+	Schema ReportRating `json:"schema"`
+	Data   ReportRating `json:"data"`
+}
+
+// ReportRating is a schema or data conversion rating.
+type ReportRating struct {
+	Rating      string `json:"rating"`      // One of EXCELLENT, GOOD, OK, POOR, NONE, SKIPPED, INCONSISTENT.
+	Description string `json:"description"` // Rating as it appears in report.txt.
+}
+
+// ReportStatement is the number of statements of a type processed
+// (dumps only).
+type ReportStatement struct {
+	Statement string `json:"statement"`
+	Schema    int64  `json:"schema"`
+	Data      int64  `json:"data"`
+	Skip      int64  `json:"skip"`
+	Error     int64  `json:"error"`
+}
+
+// ReportDumpFile is the number of statements processed from a dump
+// file, when several were converted together.
+type ReportDumpFile struct {
+	File   string `json:"file"`
+	Schema int64  `json:"schema"`
+	Data   int64  `json:"data"`
+	Skip   int64  `json:"skip"`
+	Error  int64  `json:"error"`
+}
+
+// ReportTable is the report for a table.
+type ReportTable struct {
+	SrcTable      string              `json:"srcTable"`
+	SpTable       string              `json:"spTable"`
+	Rows          int64               `json:"rows"`
+	BadRows       int64               `json:"badRows"`
+	UnsampledRows int64               `json:"unsampledRows,omitempty"` // Rows skipped by row sampling (not included in Rows).
+	UnreadRows    int64               `json:"unreadRows,omitempty"`    // Rows not read because the conversion was interrupted (not included in Rows).
+	TooLargeRows  int64               `json:"tooLargeRows,omitempty"`  // Bad rows that exceed Spanner's commit size limit.
+	BadRowsLogged int64               `json:"badRowsLogged,omitempty"` // Bad rows written to the bad-rows file.
+	BadRowCauses  []ReportBadRowCause `json:"badRowCauses,omitempty"`  // Bad rows by cause, most common first.
+	Cols          int64               `json:"cols"`
+	Warnings      int64               `json:"warnings"`
+	SyntheticPKey string              `json:"syntheticPrimaryKey,omitempty"`
+	InternalError string              `json:"internalError,omitempty"`
+	Rating        ReportRatings       `json:"rating"`
+	Timing        *ReportTiming       `json:"timing,omitempty"`
+	Storage       *ReportStorage      `json:"storage,omitempty"`
+	Issues        []ReportIssue       `json:"issues"`
+	ColumnStats   []ReportColumnStats `json:"columnStats,omitempty"`
+	// Column mappings, if enabled (see Conv.EnableColumnMappings).
+	Mappings []ReportColumnMapping `json:"mappings,omitempty"`
+}
+
+// ReportBadRowCause is the number of bad rows of a table with a cause.
+type ReportBadRowCause struct {
+	Reason string `json:"reason"` // A BadRowReason, or "other" if the cause isn't known.
+	Column string `json:"column,omitempty"`
+	Type   string `json:"type,omitempty"` // Source type of Column, for parse errors.
+	Code   string `json:"code,omitempty"` // gRPC code, for write errors.
+	Rows   int64  `json:"rows"`
+}
+
+// ReportSampling describes row sampling (see Conv.SetRowSampling).
+type ReportSampling struct {
+	Limit         int64   `json:"limit,omitempty"`   // Max rows converted per table.
+	Percent       float64 `json:"percent,omitempty"` // Percentage of rows converted.
+	Rows          int64   `json:"rows"`              // Rows converted.
+	UnsampledRows int64   `json:"unsampledRows"`     // Rows skipped.
+}
+
+// ReportVerification is the verification result for a table (see
+// Conv.SetVerifyCounts). Counts that failed are -1.
+type ReportVerification struct {
+	SrcTable    string `json:"srcTable"`
+	SourceRows  int64  `json:"sourceRows"`
+	ReportRows  int64  `json:"reportRows"`
+	SpannerRows int64  `json:"spannerRows"`
+	Mismatch    bool   `json:"mismatch"`
+}
+
+// ReportDDLBatch is a batch of the DDL statements that created the
+// Spanner schema. Statements are numbered from 1.
+type ReportDDLBatch struct {
+	Batch   int    `json:"batch"`
+	First   int    `json:"first"`
+	Last    int    `json:"last"`
+	Status  string `json:"status"`          // One of applied, previousRun, failed, notApplied.
+	Applied int    `json:"applied"`         // Statements applied (by this run or a previous one).
+	Error   string `json:"error,omitempty"` // Error of the statement that failed, if the batch failed.
+	// Leading statements applied by a previous run (for the first
+	// resumed batch), and the statements of the batch if it failed.
+	Previous   int      `json:"previous,omitempty"`
+	Statements []string `json:"statements,omitempty"`
+}
+
+// ReportDDLFailure is a DDL statement that Spanner failed to apply
+// after data conversion.
+type ReportDDLFailure struct {
+	Statement string `json:"statement"`
+	Error     string `json:"error"`
+}
+
+// ReportBadRowsFile describes the bad-rows file (see Conv.SetBadRowWriter).
+type ReportBadRowsFile struct {
+	Path      string `json:"path"`
+	Rows      int64  `json:"rows"`      // Bad rows written to the file.
+	Truncated int64  `json:"truncated"` // Bad rows not written because of the file's size limit.
+}
+
+// ReportIssue is a schema conversion warning or note for a table.
+type ReportIssue struct {
+	Issue    string   `json:"issue"`    // schemaIssue name e.g. "widened".
+	Severity string   `json:"severity"` // "warning" or "note".
+	Columns  []string `json:"columns"`  // Affected columns.
+	Text     string   `json:"text"`     // Description as it appears in report.txt.
+	// Link to further documentation of the issue (omitted if none).
+	DocURL string `json:"docURL,omitempty"`
+}
+
+// ReportIssueCount is the number of tables and columns with a schema
+// issue, over all tables.
+type ReportIssueCount struct {
+	Issue    string `json:"issue"`    // schemaIssue name e.g. "widened".
+	Severity string `json:"severity"` // "warning" or "note".
+	Tables   int64  `json:"tables"`
+	Columns  int64  `json:"columns"` // Columns with the issue, including those not listed because the issue is batched.
+	// Link to further documentation of the issue (omitted if none).
+	DocURL string `json:"docURL,omitempty"`
+}
+
+// ReportTiming is the time spent converting data, and the bytes of
+// source data processed (approximate for database/sql sources).
+type ReportTiming struct {
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	Bytes          int64   `json:"bytes"`
+}
+
+// ReportStorage is the estimated storage of a table (or all tables) in
+// Spanner.
+type ReportStorage struct {
+	Bytes      int64 `json:"bytes"`
+	Rows       int64 `json:"rows"`       // Rows the estimate is based on.
+	FromSchema bool  `json:"fromSchema"` // Estimated from column types and source statistics (schema-only conversions).
+}
+
+// ReportColumnStats are statistics for a column (see
+// Conv.EnableColumnStats).
+type ReportColumnStats struct {
+	Column   string  `json:"column"`
+	Values   int64   `json:"values"`
+	NullFrac float64 `json:"nullFrac"`
+	Distinct int64   `json:"distinct"`
+}
+
+// ReportColumnMapping is the mapping of a source column to a Spanner
+// column. Columns that only exist in Spanner have no source column, and
+// Added says why they were added.
+type ReportColumnMapping struct {
+	SrcColumn  string `json:"srcColumn,omitempty"`
+	SrcType    string `json:"srcType,omitempty"`
+	SpColumn   string `json:"spColumn,omitempty"` // Empty if the source column has no Spanner column.
+	SpType     string `json:"spType,omitempty"`
+	NotNull    bool   `json:"notNull"`
+	PrimaryKey bool   `json:"primaryKey"`
+	Added      string `json:"added,omitempty"` // e.g. "synthetic primary key".
+}
+
+// ReportResourceUsage is the resource usage of the conversion (see
+// Conv.SetResourceUsage).
+type ReportResourceUsage struct {
+	PeakRSS        int64 `json:"peakRSS"`
+	PeakHeap       int64 `json:"peakHeap"`
+	PeakGoroutines int64 `json:"peakGoroutines"`
+	BytesRead      int64 `json:"bytesRead"`
+	TempFileBytes  int64 `json:"tempFileBytes"`
+}
+
+// ReportDroppedObject is a source DB object that has no Spanner
+// equivalent.
+type ReportDroppedObject struct {
+	Kind   string   `json:"kind"` // e.g. "view".
+	Name   string   `json:"name"`
+	Tables []string `json:"tables,omitempty"`
+	SQL    string   `json:"sql,omitempty"` // Possibly truncated.
+	Reason string   `json:"reason,omitempty"`
+	// Source comment on the object, if any.
+	Comment string `json:"comment,omitempty"`
+}
+
+// ReportUnexpected is an unexpected condition encountered during
+// processing, and how many times it occurred. If snippets are enabled,
+// it also gives the approximate byte offset in the dump of the first
+// occurrence, and a snippet of the dump text being processed.
+type ReportUnexpected struct {
+	Condition string `json:"condition"`
+	Count     int64  `json:"count"`
+	Offset    *int64 `json:"offset,omitempty"`
+	Snippet   string `json:"snippet,omitempty"`
+}
+
+// GenerateJSONReport writes a machine-readable version of the report
+// generated by GenerateReport to w. It contains the same information
+// (overall and per-table ratings, schema issues, ignored statements,
+// statement stats and unexpected conditions), but in a stable JSON
+// schema so that conversion results can be consumed by tools such as
+// CI pipelines. Tables, issues and unexpected conditions appear in the
+// same order as in the text report.
+func GenerateJSONReport(src Source, conv *Conv, w io.Writer, badWrites map[string]int64) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(BuildReport(src, conv, badWrites))
+}
+
+// BuildReport returns a structured version of the report generated by
+// GenerateReport (it is what GenerateJSONReport writes).
+func BuildReport(src Source, conv *Conv, badWrites map[string]int64) *Report {
+	reports := analyzeTables(conv, badWrites)
+	s := summarize(conv, reports, badWrites)
+	r := &Report{
+		Version:              jsonReportVersion,
+		Summary:              makeReportRatings(s.rows, s.badRows, s.schema, true, s.dataSkipped, conv.WrittenTo(), conv.ratingThresholds()),
+		WritePriority:        string(conv.writePriority),
+		WriteTag:             conv.writeTag,
+		SuppressedIssues:     conv.suppressedIssues(),
+		IssueSeverities:      conv.issueSeverities(),
+		DryRun:               conv.dryRun,
+		Dialect:              string(conv.Dialect()),
+		IgnoredStatements:    ignoredStatements(conv),
+		StatementStats:       []ReportStatement{},
+		IssueSummary:         []ReportIssueCount{},
+		Tables:               []ReportTable{},
+		UnexpectedConditions: []ReportUnexpected{},
+	}
+	if r.IgnoredStatements == nil {
+		r.IgnoredStatements = []string{}
+	}
+	if in := conv.interrupted; in != nil {
+		r.Interrupted = &ReportInterruption{conv.lastRead.table, conv.lastRead.row, conv.unreadRows(""), in.abandoned}
+	}
+	r.SchemaMismatch = conv.mismatches
+	r.HotspotTables = hotspotTables(reports)
+	for _, v := range verification(conv, badWrites) {
+		r.Verification = append(r.Verification, ReportVerification{v.srcTable, v.source, v.report, v.spanner, v.mismatch})
+	}
+	if b := conv.ddlBatches; b.describe() {
+		for i, x := range b.batches {
+			k := i + 1
+			first := b.first(k)
+			applied := x.previous + x.applied
+			if b.status(k) == ddlPreviousRun {
+				applied = len(x.stmts)
+			}
+			db := ReportDDLBatch{k, first, first + len(x.stmts) - 1, b.status(k), applied, x.err, x.previous, nil}
+			if db.Status == ddlFailed {
+				db.Statements = x.stmts
+			}
+			r.DDLBatches = append(r.DDLBatches, db)
+		}
+	}
+	if a := conv.ddlApplied; a != nil {
+		applied := a.applied
+		r.DDLApplied = &applied
+		for _, f := range a.failures {
+			r.DDLFailures = append(r.DDLFailures, ReportDDLFailure{f.stmt, f.err})
+		}
+	}
+	for _, g := range droppedGroups(conv) {
+		for _, d := range g.objects {
+			r.DroppedObjects = append(r.DroppedObjects, ReportDroppedObject{d.kind, d.name, d.tables, d.sql, d.reason, d.comment})
+		}
+	}
+	r.CommitRetries = conv.stats.retries
+	r.ResumedRows = conv.stats.resumed
+	r.Sampling = makeReportSampling(conv, s.rows)
+	r.BadRowsFile = makeReportBadRowsFile(conv)
+	r.DDLValidation = makeReportDDLValidation(conv)
+	r.ExistingDB = makeReportExistingDB(conv)
+	r.Database = conv.database
+	r.CreatedInstance = conv.newInstance
+	r.Snapshot = conv.snapshot
+	r.InputFormat = string(conv.inputFormat)
+	if src.Statements {
+		var stmts []string
+		for s := range conv.stats.statement {
+			stmts = append(stmts, s)
+		}
+		sort.Strings(stmts)
+		for _, s := range stmts {
+			x := conv.stats.statement[s]
+			r.StatementStats = append(r.StatementStats, ReportStatement{s, x.schema, x.data, x.skip, x.error})
+		}
+		r.DroppedTableDefs = conv.tableDefs.dropped
+		r.DuplicateTableDefs = conv.tableDefs.duplicates
+		r.DumpFiles = conv.dumpFileStats()
+	}
+	if src.Queries {
+		r.QueryStats = makeReportQueryStats(conv)
+	}
+	r.IssueSummary = append(r.IssueSummary, reportIssueSummary(conv, reports)...)
+	for _, t := range reports {
+		r.Tables = append(r.Tables, makeReportTable(conv, t))
+	}
+	total, _ := conv.totalTiming()
+	r.Timing = makeReportTiming(total)
+	r.Storage = makeReportStorage(conv.totalStorage())
+	if u := conv.usage; u != nil {
+		r.ResourceUsage = &ReportResourceUsage{u.PeakRSS, u.PeakHeap, u.PeakGoroutines, u.BytesRead, u.TempFileBytes}
+	}
+	r.SummaryText = generateSummary(conv, reports, badWrites)
+	// Last, so that conditions found while building the report are
+	// included.
+	r.UnexpectedConditions = append(r.UnexpectedConditions, reportUnexpected(conv)...)
+	r.ReparseEvents = conv.stats.reparsed
+	return r
+}
+
+// makeReportSampling returns the row sampling of conv, with rows the
+// rows converted, or nil if all rows were converted.
+func makeReportSampling(conv *Conv, rows int64) *ReportSampling {
+	sp := conv.rowSampling()
+	if sp == nil {
+		return nil
+	}
+	return &ReportSampling{Limit: sp.Limit, Percent: sp.Percent, Rows: rows, UnsampledRows: conv.unsampledRows("")}
+}
+
+func makeReportBadRowsFile(conv *Conv) *ReportBadRowsFile {
+	w := conv.badRowsOut
+	if w == nil {
+		return nil
+	}
+	rows, truncated := w.summary()
+	return &ReportBadRowsFile{Path: w.name, Rows: rows, Truncated: truncated}
+}
+
+// reportIssueSummary returns the schema issues of tables r by issue type
+// for the JSON and HTML reports (see issueSummary).
+func reportIssueSummary(conv *Conv, r []tableReport) []ReportIssueCount {
+	var l []ReportIssueCount
+	for _, c := range issueSummary(conv, r) {
+		l = append(l, ReportIssueCount{c.issue.String(), c.severity.String(), c.tables, c.cols, issueDocURL(c.issue)})
+	}
+	return l
+}
+
+func makeReportBadRowCauses(l []badRowCount) []ReportBadRowCause {
+	var r []ReportBadRowCause
+	for _, x := range l {
+		reason := string(x.cause.reason)
+		if reason == "" {
+			reason = "other"
+		}
+		r = append(r, ReportBadRowCause{Reason: reason, Column: x.cause.col, Type: x.cause.typ, Code: x.cause.code, Rows: x.rows})
+	}
+	return r
+}
+
+// reportBadRowCounts is the inverse of makeReportBadRowCauses, for
+// rendering the text report.
+func reportBadRowCounts(l []ReportBadRowCause) []badRowCount {
+	var r []badRowCount
+	for _, x := range l {
+		reason := BadRowReason(x.Reason)
+		if x.Reason == "other" {
+			reason = ""
+		}
+		r = append(r, badRowCount{badRowCause{reason: reason, col: x.Column, typ: x.Type, code: x.Code}, x.Rows})
+	}
+	return r
+}
+
+func makeReportTable(conv *Conv, t tableReport) ReportTable {
+	written := conv.WrittenTo()
+	jt := ReportTable{
+		SrcTable:      t.srcTable,
+		SpTable:       t.spTable,
+		Rows:          t.rows,
+		BadRows:       t.badRows,
+		UnsampledRows: t.unsampled,
+		UnreadRows:    t.unread,
+		TooLargeRows:  t.tooLargeRows,
+		BadRowsLogged: t.badRowsLogged,
+		BadRowCauses:  makeReportBadRowCauses(t.badCauses),
+		Cols:          t.cols,
+		Warnings:      t.warnings,
+		SyntheticPKey: t.syntheticPKey,
+		InternalError: t.internalError,
+		Rating:        makeReportRatings(t.rows, t.badRows, t.schemaCounts(), false, t.dataSkipped, written, conv.ratingThresholds()),
+		Timing:        makeReportTiming(t.timing),
+		Storage:       makeReportStorage(t.storage),
+		Issues:        []ReportIssue{},
+	}
+	for _, b := range t.body {
+		for _, l := range b.lines {
+			jt.Issues = append(jt.Issues, ReportIssue{
+				Issue:    l.issue.String(),
+				Severity: conv.severity(l.issue).String(),
+				Columns:  append([]string{}, l.cols...),
+				Text:     l.text,
+				DocURL:   issueDocURL(l.issue),
+			})
+		}
+	}
+	for _, cs := range t.colStats {
+		jt.ColumnStats = append(jt.ColumnStats, ReportColumnStats{cs.Col, cs.Values, cs.NullFrac, cs.Distinct})
+	}
+	for _, m := range t.mappings {
+		jt.Mappings = append(jt.Mappings, ReportColumnMapping{m.srcCol, m.srcType, m.spCol, m.spType, m.notNull, m.pk, m.added})
+	}
+	return jt
+}
+
+func makeReportRatings(rows, badRows int64, sc schemaCounts, summary, dataSkipped bool, written string, th RatingThresholds) ReportRatings {
+	schema, schemaDesc := rateSchema(sc, summary, th)
+	data, dataDesc := rateData(rows, badRows, dataSkipped, written, th)
+	return ReportRatings{
+		Schema: ReportRating{Rating: schema.String(), Description: schemaDesc},
+		Data:   ReportRating{Rating: data.String(), Description: dataDesc},
+	}
+}
+
+func makeReportTiming(t tableTiming) *ReportTiming {
+	if t.elapsed <= 0 {
+		return nil
+	}
+	return &ReportTiming{ElapsedSeconds: t.elapsed.Seconds(), Bytes: t.bytes}
+}
+
+func makeReportStorage(s *storageEstimate) *ReportStorage {
+	if s == nil {
+		return nil
+	}
+	return &ReportStorage{Bytes: s.bytes, Rows: s.rows, FromSchema: s.fromSchema}
+}
+
+// reportTableTiming is the inverse of makeReportTiming, for rendering
+// the text report.
+func reportTableTiming(t *ReportTiming) tableTiming {
+	if t == nil {
+		return tableTiming{}
+	}
+	// Round to undo the conversion to seconds exactly.
+	return tableTiming{elapsed: time.Duration(math.Round(t.ElapsedSeconds * float64(time.Second))), bytes: t.Bytes}
+}
+
+// reportStorageEstimate is the inverse of makeReportStorage, for
+// rendering the text report.
+func reportStorageEstimate(s *ReportStorage) *storageEstimate {
+	if s == nil {
+		return nil
+	}
+	return &storageEstimate{bytes: s.Bytes, rows: s.Rows, fromSchema: s.FromSchema}
+}
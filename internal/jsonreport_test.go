@@ -0,0 +1,207 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONReport_Golden(t *testing.T) {
+	var reports []string
+	for seed := int64(0); seed < 5; seed++ {
+		conv, badWrites := buildGoldenConv(seed)
+		buf := new(bytes.Buffer)
+		assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, buf, badWrites))
+		reports = append(reports, buf.String())
+	}
+	for i := 1; i < len(reports); i++ {
+		assert.Equal(t, reports[0], reports[i], "report differs for seed %d", i)
+	}
+	golden := filepath.Join("..", "test_data", "report.json.golden")
+	if *update {
+		assert.Nil(t, ioutil.WriteFile(golden, []byte(reports[0]), 0644))
+	}
+	expected, err := ioutil.ReadFile(golden)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), reports[0])
+}
+
+// TestJSONReport_MatchesText checks that the JSON report carries the
+// same ratings and issue text as the text report.
+func TestJSONReport_MatchesText(t *testing.T) {
+	conv, badWrites := buildGoldenConv(0)
+	buf := new(bytes.Buffer)
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, buf, badWrites))
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	text := new(bytes.Buffer)
+	w := bufio.NewWriter(text)
+	summary := GenerateReport(PgDumpSource, conv, w, badWrites)
+	w.Flush()
+	assert.Equal(t, jsonReportVersion, r.Version)
+	assert.Contains(t, summary, "Schema conversion: "+r.Summary.Schema.Description+".")
+	assert.Contains(t, summary, "Data conversion: "+r.Summary.Data.Description+".")
+	flat := strings.Join(strings.Fields(text.String()), " ")
+	for _, tbl := range r.Tables {
+		for _, i := range tbl.Issues {
+			assert.Contains(t, flat, strings.Join(strings.Fields(i.Text), " "))
+		}
+	}
+}
+
+func TestJSONReport_Issues(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE orgs (org_id bigint, user_id bigint, PRIMARY KEY (org_id, user_id));\n" +
+			"CREATE TABLE t (a smallint, b timestamp, c bigint REFERENCES orgs(org_id), org_id bigint, user_id bigint, " +
+			"FOREIGN KEY (org_id, user_id) REFERENCES orgs (org_id, user_id) ON DELETE SET NULL);\n")
+	buf := new(bytes.Buffer)
+	assert.Nil(t, GenerateJSONReport(PostgresSource, conv, buf, nil))
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, []ReportStatement{}, r.StatementStats)
+	assert.Equal(t, 2, len(r.Tables))
+	tbl := r.Tables[1]
+	assert.Equal(t, "t", tbl.SrcTable)
+	assert.Equal(t, "synth_id", tbl.SyntheticPKey)
+	assert.Equal(t, "POOR", tbl.Rating.Schema.Rating)
+	assert.Equal(t, "NONE", tbl.Rating.Data.Rating)
+	type issue struct {
+		issue, severity string
+		cols            []string
+	}
+	var got []issue
+	for _, i := range tbl.Issues {
+		got = append(got, issue{i.Issue, i.Severity, i.Columns})
+	}
+	expected := []issue{
+		{"missingPrimaryKey", "warning", []string{"synth_id"}},
+		{"foreignKeyUnsupported", "warning", []string{"org_id", "user_id"}},
+		{"widened", "note", []string{"a"}},
+		{"timestamp", "note", []string{"b"}},
+		{"foreignKey", "note", []string{"c"}},
+	}
+	assert.Equal(t, expected, got)
+}
+
+func TestJSONReport_GroupIssues(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE t (id bigint PRIMARY KEY, a bigint, b bigint, c bigint, " +
+			"CONSTRAINT t_range CHECK (((c > a) AND (c > b))));\n" +
+			"CREATE UNIQUE INDEX t_ab ON t USING btree (a, b) WHERE (a > 0);\n")
+	buf := new(bytes.Buffer)
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, buf, nil))
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	var got []ReportIssue
+	for _, i := range r.Tables[0].Issues {
+		if i.Severity == "warning" {
+			got = append(got, i)
+		}
+	}
+	expected := []ReportIssue{
+		{Issue: "checkConstraint", Severity: "warning", Columns: []string{"c", "a", "b"},
+			Text: "The check constraint t_range (c, a, b) was dropped. " + issueDB[checkConstraint].brief, DocURL: issueDocURL(checkConstraint)},
+		{Issue: "uniqueUnsupported", Severity: "warning", Columns: []string{"a", "b"},
+			Text:   "The unique index t_ab (a, b) was dropped because it is a partial index (it has a WHERE clause), which Spanner doesn't support. " + issueDB[uniqueUnsupported].brief,
+			DocURL: issueDocURL(uniqueUnsupported)},
+	}
+	assert.Equal(t, expected, got)
+	// The warnings count once per constraint, but the issue summary
+	// counts their columns.
+	assert.Equal(t, int64(2), r.Tables[0].Warnings)
+	for _, c := range r.IssueSummary {
+		if c.Issue == "checkConstraint" {
+			assert.Equal(t, int64(3), c.Columns)
+		}
+	}
+}
+
+// TestBuildReport checks the structured report for a fixture conversion
+// field by field, without going through the text report.
+func TestBuildReport(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE a (id bigint PRIMARY KEY, b integer);\n" +
+			"CREATE TABLE c (x text);\n" +
+			"CREATE VIEW v AS SELECT id FROM a;\n" +
+			"COPY a (id, b) FROM stdin;\n" +
+			"1\t2\n" +
+			"2\tx\n" +
+			"\\.\n")
+	conv.stats.unexpected["Testing unexpected messages"] = &conditionStat{count: 3, offset: -1}
+	r := BuildReport(PgDumpSource, conv, map[string]int64{"a": 1})
+	assert.Equal(t, jsonReportVersion, r.Version)
+	assert.Equal(t, "GOOD", r.Summary.Schema.Rating)
+	assert.Equal(t, "POOR", r.Summary.Data.Rating)
+	assert.Equal(t, []string{"views"}, r.IgnoredStatements)
+	var stmts []string
+	for _, s := range r.StatementStats {
+		stmts = append(stmts, s.Statement)
+	}
+	assert.Equal(t, []string{"CopyStmt", "CreateStmt", "ViewStmt"}, stmts)
+	type table struct {
+		src           string
+		rows, badRows int64
+		syntheticPKey string
+		issues        []string
+	}
+	var tables []table
+	for _, x := range r.Tables {
+		var issues []string
+		for _, i := range x.Issues {
+			issues = append(issues, i.Issue)
+		}
+		tables = append(tables, table{x.SrcTable, x.Rows, x.BadRows, x.SyntheticPKey, issues})
+	}
+	assert.Equal(t, []table{
+		{"a", 2, 2, "", []string{"widened"}},
+		{"c", 0, 0, "synth_id", []string{"missingPrimaryKey"}},
+	}, tables)
+	assert.Equal(t, []ReportUnexpected{
+		{Condition: "Testing unexpected messages", Count: 3},
+		{Condition: "Error while converting data: can't convert to int64: strconv.ParseInt: parsing \"...\": invalid syntax\n", Count: 1},
+	}, r.UnexpectedConditions)
+	assert.Equal(t, GenerateSummary(conv, map[string]int64{"a": 1}), r.SummaryText)
+}
+
+func TestIssueSections(t *testing.T) {
+	issues := []ReportIssue{
+		{Issue: "hotspot", Severity: "warning"},
+		{Issue: "widened", Severity: "note"},
+		{Issue: "timestamp", Severity: "note"},
+		{Issue: "badValue", Severity: "warning"},
+		{Issue: "badValue", Severity: "warning"},
+	}
+	var got []string
+	for _, sec := range issueSections(issues) {
+		got = append(got, fmt.Sprintf("%s: %d", sec.heading, len(sec.issues)))
+	}
+	assert.Equal(t, []string{"Warning: 1", "Notes: 2", "Examples of data conversion problems: 2"}, got)
+	assert.Empty(t, issueSections(nil))
+}
+
+func TestSeverityString(t *testing.T) {
+	assert.Equal(t, "warning", warning.String())
+	assert.Equal(t, "note", note.String())
+	assert.Equal(t, "severity(7)", severity(7).String())
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Diagnostics are written to a leveled log (by default, warnings and
+// errors to stderr as text), so that problems are visible as they
+// happen, rather than only in the report at the end of a long
+// conversion. The JSON format has one object per line, for running
+// HarbourBridge as part of a larger orchestration.
+
+// LogLevel is the severity of a log message. Loggers write messages at
+// their level and below.
+type LogLevel int
+
+const (
+	LogError LogLevel = iota
+	LogWarn
+	LogInfo
+	LogDebug
+)
+
+var logLevelNames = []string{"error", "warn", "info", "debug"}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel parses a log level: error, warn, info or debug.
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, n := range logLevelNames {
+		if strings.ToLower(s) == n {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q: must be one of %s", s, strings.Join(logLevelNames, ", "))
+}
+
+// LogFormat is the format of log messages.
+type LogFormat string
+
+const (
+	LogText LogFormat = "text" // e.g. 2020-06-01T10:00:00.000Z WARN Unexpected condition: ...
+	LogJSON LogFormat = "json" // e.g. {"time":"2020-06-01T10:00:00.000Z","level":"warn","msg":"Unexpected condition: ..."}
+)
+
+// ParseLogFormat parses a log format: text or json.
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch f := LogFormat(strings.ToLower(s)); f {
+	case LogText, LogJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown log format %q: must be %s or %s", s, LogText, LogJSON)
+}
+
+// Logger writes log messages at or below its level to a writer. It is
+// threadsafe.
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  LogLevel
+	format LogFormat
+	now    func() time.Time
+}
+
+// NewLogger returns a Logger that writes messages at or below level to
+// w, in the given format (empty means text).
+func NewLogger(w io.Writer, level LogLevel, format LogFormat) *Logger {
+	if format == "" {
+		format = LogText
+	}
+	return &Logger{w: w, level: level, format: format, now: time.Now}
+}
+
+// Enabled returns whether l writes messages at level.
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level <= l.level
+}
+
+func (l *Logger) Errorf(format string, a ...interface{}) { l.logf(LogError, format, a...) }
+func (l *Logger) Warnf(format string, a ...interface{})  { l.logf(LogWarn, format, a...) }
+func (l *Logger) Infof(format string, a ...interface{})  { l.logf(LogInfo, format, a...) }
+func (l *Logger) Debugf(format string, a ...interface{}) { l.logf(LogDebug, format, a...) }
+
+// Printf logs at info level, so that status messages (see
+// conversion.Options.Logger) can go to the log.
+func (l *Logger) Printf(format string, a ...interface{}) { l.logf(LogInfo, format, a...) }
+
+func (l *Logger) logf(level LogLevel, format string, a ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, a...), "\n")
+	t := l.now().UTC().Format("2006-01-02T15:04:05.000Z")
+	var line []byte
+	if l.format == LogJSON {
+		line, _ = json.Marshal(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{t, level.String(), msg})
+	} else {
+		line = []byte(fmt.Sprintf("%s %s %s", t, strings.ToUpper(level.String()), msg))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(line, '\n'))
+}
+
+var logger = NewLogger(os.Stderr, LogWarn, LogText)
+
+// Log returns the logger used by HarbourBridge's packages.
+func Log() *Logger {
+	return logger
+}
+
+// SetLog sets the logger used by HarbourBridge's packages. Generally
+// there should be one call to SetLog at startup.
+func SetLog(l *Logger) {
+	logger = l
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLog sets the log to write messages at or below level (as text,
+// without times) to the returned buffer, until restore is called.
+func captureLog(level LogLevel) (buf *bytes.Buffer, restore func()) {
+	old := Log()
+	buf = new(bytes.Buffer)
+	l := NewLogger(buf, level, LogText)
+	l.now = func() time.Time { return time.Time{} }
+	SetLog(l)
+	return buf, func() { SetLog(old) }
+}
+
+// logLines returns the messages in buf, without their times.
+func logLines(buf *bytes.Buffer) []string {
+	var l []string
+	for _, s := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if s != "" {
+			l = append(l, strings.TrimPrefix(s, "0001-01-01T00:00:00.000Z "))
+		}
+	}
+	return l
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, s := range []string{"error", "warn", "info", "debug"} {
+		l, err := ParseLogLevel(strings.ToUpper(s))
+		assert.Nil(t, err)
+		assert.Equal(t, s, l.String())
+	}
+	_, err := ParseLogLevel("verbose")
+	assert.NotNil(t, err)
+}
+
+func TestParseLogFormat(t *testing.T) {
+	f, err := ParseLogFormat("JSON")
+	assert.Nil(t, err)
+	assert.Equal(t, LogJSON, f)
+	_, err = ParseLogFormat("xml")
+	assert.NotNil(t, err)
+}
+
+func TestLogger(t *testing.T) {
+	now := func() time.Time { return time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		level    LogLevel
+		format   LogFormat
+		expected string
+	}{
+		{LogWarn, LogText, "2020-06-01T10:00:00.000Z ERROR Can't write\n" +
+			"2020-06-01T10:00:00.000Z WARN Unexpected condition: x\n"},
+		{LogDebug, "", "2020-06-01T10:00:00.000Z ERROR Can't write\n" +
+			"2020-06-01T10:00:00.000Z WARN Unexpected condition: x\n" +
+			"2020-06-01T10:00:00.000Z INFO Finished reading table t\n" +
+			"2020-06-01T10:00:00.000Z INFO Wrote schema\n" +
+			"2020-06-01T10:00:00.000Z DEBUG Wrote 10 rows\n"},
+		{LogInfo, LogJSON, `{"time":"2020-06-01T10:00:00.000Z","level":"error","msg":"Can't write"}` + "\n" +
+			`{"time":"2020-06-01T10:00:00.000Z","level":"warn","msg":"Unexpected condition: x"}` + "\n" +
+			`{"time":"2020-06-01T10:00:00.000Z","level":"info","msg":"Finished reading table t"}` + "\n" +
+			`{"time":"2020-06-01T10:00:00.000Z","level":"info","msg":"Wrote schema"}` + "\n"},
+	}
+	for _, tc := range tests {
+		buf := new(bytes.Buffer)
+		l := NewLogger(buf, tc.level, tc.format)
+		l.now = now
+		l.Errorf("Can't write")
+		l.Warnf("Unexpected condition: %s", "x")
+		l.Infof("Finished reading table %s", "t")
+		l.Printf("Wrote schema\n") // Trailing newlines are dropped.
+		l.Debugf("Wrote %d rows", 10)
+		assert.Equal(t, tc.expected, buf.String(), "%s %s", tc.level, tc.format)
+	}
+}
+
+func TestLog_Conversion(t *testing.T) {
+	buf, restore := captureLog(LogInfo)
+	defer restore()
+	runProcessPgDump(
+		"CREATE TABLE t (id bigint PRIMARY KEY, n bigint);\n" +
+			"CREATE TABLE u (id bigint PRIMARY KEY);\n" +
+			"ALTER TABLE t ALTER COLUMN missing SET NOT NULL;\n" +
+			"ALTER TABLE u ALTER COLUMN missing SET NOT NULL;\n" +
+			"COPY public.t (id, n) FROM stdin;\n1\t2\n2\tx\n3\ty\n\\.\n" +
+			"COPY public.u (id) FROM stdin;\n1\n\\.\n")
+	// Unexpected conditions are logged once, without their values.
+	assert.Equal(t, []string{
+		"WARN Unexpected condition: ALTER TABLE alters column missing of table t, but it doesn't exist",
+		"WARN Unexpected condition: ALTER TABLE alters column missing of table u, but it doesn't exist",
+		`WARN Unexpected condition: Error while converting data: can't convert to int64: strconv.ParseInt: parsing "...": invalid syntax`,
+		"INFO Finished reading table t: 3 rows read (1 converted and 2 bad so far)",
+		"INFO Finished reading table u: 1 rows read (1 converted and 0 bad so far)",
+	}, logLines(buf))
+}
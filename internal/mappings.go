@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// Reviewers signing off on a conversion often want a plain listing of
+// how each source column was mapped, without reading the schema file.
+// The listing is opt-in (see EnableColumnMappings), since it makes the
+// report much longer.
+
+// columnMapping is the mapping of a source column to a Spanner column.
+type columnMapping struct {
+	srcCol  string // Empty for columns that only exist in Spanner.
+	srcType string
+	spCol   string // Empty if the source column has no Spanner column.
+	spType  string
+	notNull bool
+	pk      bool   // Part of the Spanner primary key.
+	added   string // Why a Spanner-only column was added e.g. "synthetic primary key".
+}
+
+// EnableColumnMappings adds a listing of the column mappings of each
+// table (source column and type, Spanner column and type) to the
+// table's section of the report.
+func (conv *Conv) EnableColumnMappings() {
+	conv.showMappings = true
+}
+
+// columnMappings returns the column mappings of source table srcTable,
+// in source column order, followed by the columns that only exist in
+// Spanner table spSchema.
+func (conv *Conv) columnMappings(srcTable string, srcSchema schema.Table, spSchema ddl.CreateTable) []columnMapping {
+	pks := make(map[string]bool)
+	for _, k := range spSchema.Pks {
+		pks[k.Col] = true
+	}
+	mapped := make(map[string]bool)
+	var l []columnMapping
+	for _, srcCol := range srcSchema.ColNames {
+		m := columnMapping{srcCol: srcCol, srcType: printSourceType(srcSchema.ColDefs[srcCol].Type)}
+		if spCol, err := GetSpannerCol(conv, srcTable, srcCol, true); err == nil {
+			if cd, ok := spSchema.ColDefs[spCol]; ok {
+				m.spCol, m.spType, m.notNull, m.pk = spCol, conv.printType(cd), cd.NotNull, pks[spCol]
+				mapped[spCol] = true
+			}
+		}
+		l = append(l, m)
+	}
+	for _, spCol := range spSchema.ColNames {
+		if mapped[spCol] {
+			continue
+		}
+		cd := spSchema.ColDefs[spCol]
+		m := columnMapping{spCol: spCol, spType: conv.printType(cd), notNull: cd.NotNull, pk: pks[spCol], added: "added"}
+		if pk, ok := conv.syntheticPKeys[spSchema.Name]; ok && pk.col == spCol {
+			m.added = "synthetic primary key"
+		} else if c, ok := conv.commitTS[spSchema.Name]; ok && c.col == spCol {
+			m.added = "commit timestamp"
+		}
+		l = append(l, m)
+	}
+	return l
+}
+
+// writeColumnMappings writes the column mappings appendix of a table's
+// section of the report as a fixed-width table.
+func writeColumnMappings(l []ReportColumnMapping, w *bufio.Writer) {
+	rows := [][]string{{"source column", "source type", "spanner column", "spanner type", "nullable", "key"}}
+	for _, m := range l {
+		src, spCol := m.SrcColumn, m.SpColumn
+		if m.Added != "" {
+			src = "(" + m.Added + ")"
+		}
+		if spCol == "" {
+			spCol = "(none)"
+		}
+		nullable, key := "yes", ""
+		if m.NotNull {
+			nullable = "no"
+		}
+		if m.PrimaryKey {
+			key = "PK"
+		}
+		rows = append(rows, []string{src, m.SrcType, spCol, m.SpType, nullable, key})
+	}
+	widths := make([]int, len(rows[0]))
+	for _, r := range rows {
+		for i, s := range r {
+			if len(s) > widths[i] {
+				widths[i] = len(s)
+			}
+		}
+	}
+	w.WriteString("Column mappings\n")
+	for _, r := range rows {
+		var line string
+		for i, s := range r {
+			line += fmt.Sprintf("  %-*s", widths[i], s)
+		}
+		w.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	w.WriteString("\n")
+}
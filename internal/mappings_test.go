@@ -0,0 +1,59 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColumnMappings(t *testing.T) {
+	conv := MakeConv()
+	conv.EnableColumnMappings()
+	conv, _ = runProcessPgDumpConv(conv,
+		"CREATE TABLE a (id bigint PRIMARY KEY, name varchar(20) NOT NULL, \"Select\" text);\n"+
+			"CREATE TABLE b (x integer);\n")
+	assert.Nil(t, conv.ApplyTableOptions(map[string]TableOptions{
+		"a": TableOptions{CommitTimestamp: &CommitTimestampOption{Column: "update_time"}},
+	}, time.Now()))
+	r := BuildReport(PgDumpSource, conv, nil)
+	assert.Equal(t, []ReportColumnMapping{
+		{SrcColumn: "id", SrcType: "int8", SpColumn: "id", SpType: "INT64", NotNull: true, PrimaryKey: true},
+		{SrcColumn: "name", SrcType: "varchar(20)", SpColumn: "name", SpType: "STRING(20)", NotNull: true},
+		{SrcColumn: "Select", SrcType: "text", SpColumn: "Select_", SpType: "STRING(MAX)"},
+		{SpColumn: "update_time", SpType: "TIMESTAMP", Added: "commit timestamp"},
+	}, r.Tables[0].Mappings)
+	assert.Equal(t, []ReportColumnMapping{
+		{SrcColumn: "x", SrcType: "int4", SpColumn: "x", SpType: "INT64"},
+		{SpColumn: "synth_id", SpType: "INT64", PrimaryKey: true, Added: "synthetic primary key"},
+	}, r.Tables[1].Mappings)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), "Column mappings\n"+
+		"  source column            source type  spanner column  spanner type  nullable  key\n"+
+		"  x                        int4         x               INT64         yes\n"+
+		"  (synthetic primary key)               synth_id        INT64         yes       PK\n\n")
+
+	// Without the option, there are no mappings.
+	conv, _ = runProcessPgDump("CREATE TABLE b (x integer);\n")
+	assert.Nil(t, BuildReport(PgDumpSource, conv, nil).Tables[0].Mappings)
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Long conversions can be monitored with metrics, served in the
+// Prometheus text format (which OpenTelemetry collectors can also
+// scrape). Metrics are updated by the same helpers that update the
+// stats for the report (statsAddRow etc.), so that the report and the
+// metrics can't diverge.
+
+// Metric names.
+const (
+	metricRows         = "harbourbridge_rows"
+	metricGoodRows     = "harbourbridge_good_rows_total"
+	metricBadRows      = "harbourbridge_bad_rows_total"
+	metricRowsRead     = "harbourbridge_rows_read_total"
+	metricBytesRead    = "harbourbridge_bytes_read_total"
+	metricCurrentTable = "harbourbridge_current_table"
+	metricCommits      = "harbourbridge_spanner_commits_total"
+	metricRetries      = "harbourbridge_spanner_commit_retries_total"
+	metricLatency      = "harbourbridge_spanner_write_latency_seconds"
+)
+
+type metricDef struct {
+	name  string
+	typ   string // Prometheus metric type.
+	label string // Name of the metric's label (empty if none).
+	help  string
+}
+
+// metricDefs are the metrics other than metricLatency, in the order
+// they are written.
+var metricDefs = []metricDef{
+	{metricRows, "gauge", "table", "Rows found in the source, as in the report's row counts."},
+	{metricGoodRows, "counter", "table", "Rows successfully converted."},
+	{metricBadRows, "counter", "table", "Rows where conversion failed."},
+	{metricRowsRead, "counter", "table", "Rows read during data conversion."},
+	{metricBytesRead, "counter", "table", "Bytes of values of rows read during data conversion."},
+	{metricCurrentTable, "gauge", "table", "1 for each table currently being read during data conversion."},
+	{metricCommits, "counter", "code", "Writes (commit attempts) to Spanner, by gRPC code."},
+	{metricRetries, "counter", "", "Retries of writes to Spanner that failed with transient errors."},
+}
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of
+// the Spanner write latency histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics holds metrics about a conversion. It is threadsafe, and its
+// unexported methods do nothing if it is nil.
+type Metrics struct {
+	mu      sync.Mutex
+	values  map[string]map[string]int64 // Keyed by metric name, then label value.
+	latency histogram                   // Latency of writes to Spanner.
+}
+
+type histogram struct {
+	counts []int64 // Count in each bucket of latencyBuckets (not cumulative), then above the last.
+	sum    float64
+	count  int64
+}
+
+// NewMetrics returns an empty Metrics. Pass it to Conv.SetMetrics, and
+// serve it (it is an http.Handler) to monitor a conversion.
+func NewMetrics() *Metrics {
+	m := &Metrics{values: make(map[string]map[string]int64)}
+	for _, d := range metricDefs {
+		m.values[d.name] = make(map[string]int64)
+	}
+	m.latency.counts = make([]int64, len(latencyBuckets)+1)
+	return m
+}
+
+// SetMetrics sets m to be updated as conv's stats change.
+func (conv *Conv) SetMetrics(m *Metrics) {
+	conv.metrics = m
+}
+
+// RecordCommit records a write to Spanner (one commit attempt) that
+// took latency and finished with gRPC code (OK if it succeeded). Unlike
+// most Conv methods, it is threadsafe.
+func (conv *Conv) RecordCommit(code string, latency time.Duration) {
+	m := conv.metrics
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[metricCommits][code]++
+	s := latency.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, s)
+	m.latency.counts[i]++
+	m.latency.sum += s
+	m.latency.count++
+}
+
+// add adds n to the value of metric name for label value l.
+func (m *Metrics) add(name, l string, n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[name][l] += n
+}
+
+// setTable sets the current table to t.
+func (m *Metrics) setTable(t string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[metricCurrentTable] = map[string]int64{t: 1}
+}
+
+// startTable marks t as being read, along with any other tables being
+// read (see ProcessSqlDataParallel).
+func (m *Metrics) startTable(t string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[metricCurrentTable][t] = 1
+}
+
+// endTable marks t as no longer being read.
+func (m *Metrics) endTable(t string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values[metricCurrentTable], t)
+}
+
+// moveRows moves the rows of table from to table to (see
+// mergeInherited).
+func (m *Metrics) moveRows(from, to string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v := m.values[metricRows]
+	if n, ok := v[from]; ok {
+		v[to] += n
+		delete(v, from)
+	}
+}
+
+// Write writes the metrics to w in the Prometheus text format.
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := bufio.NewWriter(w)
+	for _, d := range metricDefs {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+		v := m.values[d.name]
+		if d.label == "" {
+			fmt.Fprintf(b, "%s %d\n", d.name, v[""])
+			continue
+		}
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(b, "%s{%s=\"%s\"} %d\n", d.name, d.label, escapeLabel(k), v[k])
+		}
+	}
+	fmt.Fprintf(b, "# HELP %s Latency of writes (commit attempts) to Spanner.\n# TYPE %s histogram\n", metricLatency, metricLatency)
+	var n int64
+	for i, c := range m.latency.counts {
+		n += c
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(b, "%s_bucket{le=\"%s\"} %d\n", metricLatency, le, n)
+	}
+	fmt.Fprintf(b, "%s_sum %s\n", metricLatency, strconv.FormatFloat(m.latency.sum, 'g', -1, 64))
+	fmt.Fprintf(b, "%s_count %d\n", metricLatency, m.latency.count)
+	return b.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Write(w)
+}
+
+// escapeLabel escapes a label value for the Prometheus text format.
+var escapeLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
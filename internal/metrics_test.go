@@ -0,0 +1,124 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	conv := MakeConv()
+	m := NewMetrics()
+	conv.SetMetrics(m)
+	runProcessPgDumpConv(conv,
+		"CREATE TABLE t (id bigint PRIMARY KEY, n bigint);\n"+
+			"CREATE TABLE \"u\"\"v\" (id bigint PRIMARY KEY);\n"+
+			"COPY public.t (id, n) FROM stdin;\n1\t2\n2\tx\n3\t4\n\\.\n"+
+			"COPY public.\"u\"\"v\" (id) FROM stdin;\n1\n\\.\n")
+	conv.RecordCommit("OK", 3*time.Millisecond)
+	conv.RecordCommit("ABORTED", 2*time.Second)
+	conv.AddCommitRetries(1)
+	conv.RecordCommit("OK", 20*time.Second)
+
+	// Metrics agree with the stats in the report.
+	assert.Equal(t, map[string]int64{"t": 3, `u"v`: 1}, conv.stats.rows)
+	assert.Equal(t, map[string]int64{"t": 2, `u"v`: 1}, conv.stats.goodRows)
+	assert.Equal(t, map[string]int64{"t": 1}, conv.stats.badRows)
+	assert.Equal(t, int64(1), conv.stats.retries)
+	buf := new(bytes.Buffer)
+	assert.Nil(t, m.Write(buf))
+	assert.Equal(t, `# HELP harbourbridge_rows Rows found in the source, as in the report's row counts.
+# TYPE harbourbridge_rows gauge
+harbourbridge_rows{table="t"} 3
+harbourbridge_rows{table="u\"v"} 1
+# HELP harbourbridge_good_rows_total Rows successfully converted.
+# TYPE harbourbridge_good_rows_total counter
+harbourbridge_good_rows_total{table="t"} 2
+harbourbridge_good_rows_total{table="u\"v"} 1
+# HELP harbourbridge_bad_rows_total Rows where conversion failed.
+# TYPE harbourbridge_bad_rows_total counter
+harbourbridge_bad_rows_total{table="t"} 1
+# HELP harbourbridge_rows_read_total Rows read during data conversion.
+# TYPE harbourbridge_rows_read_total counter
+harbourbridge_rows_read_total{table="t"} 3
+harbourbridge_rows_read_total{table="u\"v"} 1
+# HELP harbourbridge_bytes_read_total Bytes of values of rows read during data conversion.
+# TYPE harbourbridge_bytes_read_total counter
+harbourbridge_bytes_read_total{table="t"} 6
+harbourbridge_bytes_read_total{table="u\"v"} 1
+# HELP harbourbridge_current_table 1 for each table currently being read during data conversion.
+# TYPE harbourbridge_current_table gauge
+harbourbridge_current_table{table="u\"v"} 1
+# HELP harbourbridge_spanner_commits_total Writes (commit attempts) to Spanner, by gRPC code.
+# TYPE harbourbridge_spanner_commits_total counter
+harbourbridge_spanner_commits_total{code="ABORTED"} 1
+harbourbridge_spanner_commits_total{code="OK"} 2
+# HELP harbourbridge_spanner_commit_retries_total Retries of writes to Spanner that failed with transient errors.
+# TYPE harbourbridge_spanner_commit_retries_total counter
+harbourbridge_spanner_commit_retries_total 1
+# HELP harbourbridge_spanner_write_latency_seconds Latency of writes (commit attempts) to Spanner.
+# TYPE harbourbridge_spanner_write_latency_seconds histogram
+harbourbridge_spanner_write_latency_seconds_bucket{le="0.005"} 1
+harbourbridge_spanner_write_latency_seconds_bucket{le="0.01"} 1
+harbourbridge_spanner_write_latency_seconds_bucket{le="0.025"} 1
+harbourbridge_spanner_write_latency_seconds_bucket{le="0.05"} 1
+harbourbridge_spanner_write_latency_seconds_bucket{le="0.1"} 1
+harbourbridge_spanner_write_latency_seconds_bucket{le="0.25"} 1
+harbourbridge_spanner_write_latency_seconds_bucket{le="0.5"} 1
+harbourbridge_spanner_write_latency_seconds_bucket{le="1"} 1
+harbourbridge_spanner_write_latency_seconds_bucket{le="2.5"} 2
+harbourbridge_spanner_write_latency_seconds_bucket{le="5"} 2
+harbourbridge_spanner_write_latency_seconds_bucket{le="10"} 2
+harbourbridge_spanner_write_latency_seconds_bucket{le="30"} 3
+harbourbridge_spanner_write_latency_seconds_bucket{le="60"} 3
+harbourbridge_spanner_write_latency_seconds_bucket{le="+Inf"} 3
+harbourbridge_spanner_write_latency_seconds_sum 22.003
+harbourbridge_spanner_write_latency_seconds_count 3
+`, buf.String())
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, buf.String(), rec.Body.String())
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+}
+
+func TestMetrics_Inherited(t *testing.T) {
+	conv := MakeConv()
+	m := NewMetrics()
+	conv.SetMetrics(m)
+	conv.SetInheritanceStrategy(InheritanceMerge)
+	runProcessPgDumpConv(conv,
+		"CREATE TABLE p (id bigint PRIMARY KEY);\n"+
+			"CREATE TABLE c (x bigint) INHERITS (p);\n"+
+			"COPY public.p (id) FROM stdin;\n1\n\\.\n"+
+			"COPY public.c (id, x) FROM stdin;\n2\t3\n\\.\n")
+	// Rows of merged tables count towards the table they're written to.
+	assert.Equal(t, map[string]int64{"p": 2}, conv.stats.rows)
+	assert.Equal(t, conv.stats.rows, m.values[metricRows])
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	conv := MakeConv()
+	conv.RecordCommit("OK", time.Second)
+	conv.AddCommitRetries(2)
+	conv.statsAddRows("t", 1)
+	assert.Equal(t, int64(2), conv.stats.retries)
+}
@@ -0,0 +1,1138 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+)
+
+// ProcessMySQLDump reads mysqldump data from r and does schema or data
+// conversion, depending on whether conv is configured for schema mode
+// or data mode (see ProcessPgDump). We handle the statements mysqldump
+// generates: CREATE TABLE statements provide the schema, and INSERT
+// statements provide the data. Views, triggers and stored routines are
+// reported as dropped objects, and other statements are skipped.
+func ProcessMySQLDump(conv *Conv, r *Reader) error {
+	conv.mysql = true
+	s := mysqlScanner{r: r, delim: ";"}
+	for {
+		startLine := r.LineNumber
+		startOffset := r.Offset
+		offset := r.Offset - 1 - len(s.line) // Start of the unscanned text.
+		start := conv.now()
+		stmt, ok := s.next()
+		if !ok {
+			break
+		}
+		conv.setDumpContext(int64(offset), stmt)
+		table := processMySQLStmt(conv, stmt)
+		VerbosePrintf("Parsed SQL command at line=%d/fpos=%d: %d lines, %d bytes\n", startLine, startOffset, r.LineNumber-startLine, len(stmt))
+		if table != "" {
+			conv.statsAddTiming(table, conv.now().Sub(start), int64(r.Offset-startOffset))
+		}
+	}
+	conv.clearDumpContext()
+	if conv.dataMode() && !r.Stopped() {
+		conv.tableRead()
+	}
+	if conv.schemaMode() && !conv.severalDumpFiles() { // Otherwise, see FinishDumpFiles.
+		schemaToDDL(conv)
+		conv.AddPrimaryKeys()
+	}
+	return nil
+}
+
+// mysqlScanner splits mysqldump output into statements. It tracks
+// quotes and comments, so that delimiters inside them are ignored, and
+// handles the mysql client's DELIMITER command (which mysqldump uses
+// around triggers and stored routines).
+type mysqlScanner struct {
+	r       *Reader
+	delim   string
+	line    []byte // Unscanned part of the current line.
+	quote   byte   // Quote character of the current string or quoted identifier (0 if none).
+	comment bool   // Inside a /* ... */ comment.
+}
+
+// next returns the next statement (without its delimiter), or false at
+// the end of the input. Line comments are dropped, but other comments
+// are kept (see mysqlUncomment).
+func (s *mysqlScanner) next() (string, bool) {
+	var stmt []byte
+	for {
+		if len(s.line) == 0 {
+			if s.r.EOF {
+				// The last statement may be missing its delimiter.
+				return string(stmt), len(bytes.TrimSpace(stmt)) > 0
+			}
+			s.line = s.r.ReadLine()
+			if s.r.Stopped() {
+				return "", false // Ignore the incomplete statement.
+			}
+			if s.quote == 0 && !s.comment && len(bytes.TrimSpace(stmt)) == 0 {
+				if f := strings.Fields(string(s.line)); len(f) == 2 && strings.EqualFold(f[0], "DELIMITER") {
+					s.delim = f[1]
+					s.line = nil
+					continue
+				}
+			}
+		}
+		n := len(s.line)
+		for i := 0; i < len(s.line); i++ {
+			c := s.line[i]
+			switch {
+			case s.quote != 0:
+				if c == '\\' && s.quote != '`' {
+					i++ // Skip the escaped character.
+				} else if c == s.quote {
+					// Note: a doubled quote (an escaped quote) ends the
+					// string and immediately starts another one.
+					s.quote = 0
+				}
+			case s.comment:
+				if c == '*' && i+1 < len(s.line) && s.line[i+1] == '/' {
+					s.comment = false
+					i++
+				}
+			case c == '\'' || c == '"' || c == '`':
+				s.quote = c
+			case c == '/' && i+1 < len(s.line) && s.line[i+1] == '*':
+				s.comment = true
+				i++
+			case c == '#' || isMySQLLineComment(s.line[i:]):
+				// Drop the rest of the line, but keep the statement
+				// text on either side of it apart.
+				stmt = append(stmt, s.line[:i]...)
+				stmt = append(stmt, '\n')
+				n = -1
+			case bytes.HasPrefix(s.line[i:], []byte(s.delim)):
+				stmt = append(stmt, s.line[:i]...)
+				s.line = s.line[i+len(s.delim):]
+				return string(stmt), true
+			}
+			if n < 0 {
+				break
+			}
+		}
+		if n >= 0 {
+			stmt = append(stmt, s.line...)
+		}
+		s.line = nil
+	}
+}
+
+// isMySQLLineComment returns true if b starts with a "-- " comment.
+// MySQL requires whitespace (or the end of the line) after the dashes.
+func isMySQLLineComment(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("--")) && (len(b) == 2 || b[2] == ' ' || b[2] == '\t' || b[2] == '\r' || b[2] == '\n')
+}
+
+// mysqlUncomment removes comments from statement s. The body of
+// versioned comments such as "/*!40101 SET NAMES utf8 */" is kept,
+// since MySQL executes it: mysqldump wraps many statements (and parts
+// of statements) in them.
+func mysqlUncomment(s string) string {
+	if !strings.Contains(s, "/*") {
+		return s
+	}
+	var b strings.Builder
+	var quote byte
+	versioned := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(s) {
+				b.WriteByte(c)
+				i++
+				c = s[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case strings.HasPrefix(s[i:], "/*!") || strings.HasPrefix(s[i:], "/*M!"):
+			// Skip the version number (MariaDB's are prefixed by M).
+			i = strings.IndexByte(s[i:], '!') + i + 1
+			for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+				i++
+			}
+			i--
+			versioned = true
+			b.WriteByte(' ')
+			continue
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return b.String()
+			}
+			i += end + 3
+			b.WriteByte(' ')
+			continue
+		case versioned && strings.HasPrefix(s[i:], "*/"):
+			versioned = false
+			i++
+			b.WriteByte(' ')
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+type mysqlTokenKind int
+
+const (
+	mysqlEnd         mysqlTokenKind = iota // No more tokens.
+	mysqlWord                              // Keyword or unquoted identifier.
+	mysqlQuotedIdent                       // Identifier quoted with backticks.
+	mysqlString                            // String (or hex) literal.
+	mysqlNumber                            // Number (or bit) literal.
+	mysqlPunct                             // Any other character e.g. '(' or ','.
+)
+
+// mysqlToken is a token of a MySQL statement. For quoted identifiers
+// and literals, val is the decoded value (e.g. without quotes).
+type mysqlToken struct {
+	kind       mysqlTokenKind
+	val        string
+	start, end int // Offsets in the statement.
+}
+
+// mysqlTokens splits MySQL statement s (without comments) into tokens.
+func mysqlTokens(s string) []mysqlToken {
+	var l []mysqlToken
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isWord := func(c byte) bool {
+		return c == '_' || c == '$' || isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'z') || c >= 0x80
+	}
+	// digits returns the length of the prefix of s[i:] made of digits
+	// in the given base.
+	digits := func(i int, hex bool) int {
+		n := 0
+		for i+n < len(s) && (isDigit(s[i+n]) || (hex && (s[i+n]|0x20 >= 'a' && s[i+n]|0x20 <= 'f'))) {
+			n++
+		}
+		return n
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		t := mysqlToken{start: i}
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			continue
+		case c == '\'' || c == '"':
+			var n int
+			t.kind = mysqlString
+			t.val, n = decodeMySQLString(s[i:])
+			i += n
+		case c == '`':
+			t.kind = mysqlQuotedIdent
+			var b strings.Builder
+			for i++; i < len(s); i++ {
+				if s[i] == '`' {
+					if i+1 < len(s) && s[i+1] == '`' {
+						i++
+					} else {
+						i++
+						break
+					}
+				}
+				b.WriteByte(s[i])
+			}
+			t.val = b.String()
+		case c == '0' && i+2 < len(s) && s[i+1] == 'x' && digits(i+2, true) > 0:
+			n := digits(i+2, true)
+			t.kind = mysqlString
+			t.val = decodeMySQLHex(s[i+2 : i+2+n])
+			i += 2 + n
+		case c == '0' && i+2 < len(s) && s[i+1] == 'b' && digits(i+2, false) > 0:
+			n := digits(i+2, false)
+			t.kind = mysqlNumber
+			t.val = decodeMySQLBits(s[i+2 : i+2+n])
+			i += 2 + n
+		case (c|0x20 == 'x' || c|0x20 == 'b') && i+1 < len(s) && s[i+1] == '\'':
+			// X'0A0B' or b'0101'.
+			v, n := decodeMySQLString(s[i+1:])
+			if c|0x20 == 'x' {
+				t.kind = mysqlString
+				t.val = decodeMySQLHex(v)
+			} else {
+				t.kind = mysqlNumber
+				t.val = decodeMySQLBits(v)
+			}
+			i += 1 + n
+		case isDigit(c) || (c == '.' && i+1 < len(s) && isDigit(s[i+1])):
+			t.kind = mysqlNumber
+			j := i + digits(i, false)
+			if j < len(s) && s[j] == '.' {
+				j += 1 + digits(j+1, false)
+			}
+			if j < len(s) && s[j]|0x20 == 'e' {
+				k := j + 1
+				if k < len(s) && (s[k] == '+' || s[k] == '-') {
+					k++
+				}
+				if n := digits(k, false); n > 0 {
+					j = k + n
+				}
+			}
+			if j < len(s) && isWord(s[j]) {
+				// An identifier that starts with digits.
+				t.kind = mysqlWord
+				for j < len(s) && isWord(s[j]) {
+					j++
+				}
+			}
+			t.val = s[i:j]
+			i = j
+		case isWord(c):
+			t.kind = mysqlWord
+			j := i
+			for j < len(s) && isWord(s[j]) {
+				j++
+			}
+			t.val = s[i:j]
+			i = j
+		default:
+			t.kind = mysqlPunct
+			t.val = s[i : i+1]
+			i++
+		}
+		t.end = i
+		l = append(l, t)
+	}
+	return l
+}
+
+// decodeMySQLString decodes the quoted string at the start of s, and
+// returns it and the number of bytes it used. It handles backslash
+// escapes and doubled quotes.
+func decodeMySQLString(s string) (string, int) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case '0':
+				b.WriteByte(0)
+			case 'b':
+				b.WriteByte('\b')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'Z':
+				b.WriteByte(0x1A)
+			case '%', '_':
+				// These are only escaped in LIKE patterns, so MySQL
+				// keeps the backslash.
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte(s[i])
+			}
+		case c == quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				b.WriteByte(quote)
+				i++
+				continue
+			}
+			return b.String(), i + 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), len(s) // Unterminated string.
+}
+
+// decodeMySQLHex returns the bytes represented by hex digits h, as a
+// string (hex literals are binary strings in MySQL).
+func decodeMySQLHex(h string) string {
+	if len(h)%2 == 1 {
+		h = "0" + h
+	}
+	b, _ := hex.DecodeString(h) // Callers only pass hex digits.
+	return string(b)
+}
+
+// decodeMySQLBits returns the value of binary digits b, in decimal.
+func decodeMySQLBits(b string) string {
+	if b == "" {
+		return "0"
+	}
+	n, err := strconv.ParseUint(b, 2, 64)
+	if err != nil {
+		return b // Too many bits: let data conversion report it.
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// mysqlParser is a simple recursive-descent parser for the subset of
+// MySQL statements that mysqldump generates.
+type mysqlParser struct {
+	s    string // Statement.
+	toks []mysqlToken
+	i    int
+}
+
+func (p *mysqlParser) done() bool {
+	return p.i >= len(p.toks)
+}
+
+func (p *mysqlParser) peek() mysqlToken {
+	if p.done() {
+		return mysqlToken{kind: mysqlEnd, start: len(p.s), end: len(p.s)}
+	}
+	return p.toks[p.i]
+}
+
+func (p *mysqlParser) next() mysqlToken {
+	t := p.peek()
+	if !p.done() {
+		p.i++
+	}
+	return t
+}
+
+// pos returns the offset of the next token in the statement.
+func (p *mysqlParser) pos() int {
+	return p.peek().start
+}
+
+// keyword consumes the words in kws (ignoring case) if they are next,
+// and returns true if they were.
+func (p *mysqlParser) keyword(kws ...string) bool {
+	for j, kw := range kws {
+		if p.i+j >= len(p.toks) {
+			return false
+		}
+		t := p.toks[p.i+j]
+		if t.kind != mysqlWord || !strings.EqualFold(t.val, kw) {
+			return false
+		}
+	}
+	p.i += len(kws)
+	return true
+}
+
+// punct consumes punctuation c if it is next, and returns true if it was.
+func (p *mysqlParser) punct(c string) bool {
+	if t := p.peek(); t.kind == mysqlPunct && t.val == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *mysqlParser) atPunct(c string) bool {
+	t := p.peek()
+	return t.kind == mysqlPunct && t.val == c
+}
+
+// ident consumes an identifier. Qualified names (e.g. db.table) are
+// allowed, but only the last part is returned.
+func (p *mysqlParser) ident() (string, bool) {
+	t := p.peek()
+	if t.kind != mysqlWord && t.kind != mysqlQuotedIdent {
+		return "", false
+	}
+	p.i++
+	if p.atPunct(".") && p.i+1 < len(p.toks) && (p.toks[p.i+1].kind == mysqlWord || p.toks[p.i+1].kind == mysqlQuotedIdent) {
+		p.i++
+		return p.ident()
+	}
+	return t.val, true
+}
+
+// skip consumes the next token, or the next parenthesized group.
+func (p *mysqlParser) skip() {
+	if !p.punct("(") {
+		p.next()
+		return
+	}
+	for depth := 1; depth > 0 && !p.done(); {
+		switch t := p.next(); {
+		case t.kind == mysqlPunct && t.val == "(":
+			depth++
+		case t.kind == mysqlPunct && t.val == ")":
+			depth--
+		}
+	}
+}
+
+// atElementEnd returns true at the end of an element of a list
+// (e.g. a column definition).
+func (p *mysqlParser) atElementEnd() bool {
+	return p.done() || p.atPunct(",") || p.atPunct(")")
+}
+
+// skipElement skips the rest of an element of a list.
+func (p *mysqlParser) skipElement() {
+	for !p.atElementEnd() {
+		p.skip()
+	}
+}
+
+// mysqlStmtKind returns the kind of a statement, for statement stats
+// e.g. "CREATE TABLE" or "INSERT".
+func mysqlStmtKind(toks []mysqlToken) string {
+	if toks[0].kind != mysqlWord {
+		return "(unrecognized)"
+	}
+	first := strings.ToUpper(toks[0].val)
+	switch first {
+	case "CREATE", "DROP", "ALTER":
+		// Skip options e.g. CREATE ALGORITHM=UNDEFINED DEFINER=`u`@`h` SQL SECURITY DEFINER VIEW.
+		for j := 1; j < len(toks) && j < 16; j++ {
+			if toks[j].kind != mysqlWord {
+				continue
+			}
+			switch u := strings.ToUpper(toks[j].val); u {
+			case "TABLE", "VIEW", "TRIGGER", "PROCEDURE", "FUNCTION", "EVENT", "DATABASE", "SCHEMA", "INDEX":
+				return first + " " + u
+			}
+		}
+	case "LOCK", "UNLOCK":
+		if len(toks) > 1 && toks[1].kind == mysqlWord {
+			return first + " " + strings.ToUpper(toks[1].val)
+		}
+	}
+	return first
+}
+
+// mysqlStatement returns the stats for statements of kind k. As for
+// pg_dump, statement stats are only recorded on the first pass (schema
+// mode): in data mode, the returned stats are discarded.
+func (conv *Conv) mysqlStatement(k string) *statementStat {
+	if !conv.schemaMode() {
+		return &statementStat{}
+	}
+	return conv.getStatementStat(k)
+}
+
+func logMySQLStmtError(conv *Conv, kind string, err error) {
+	conv.unexpected(fmt.Sprintf("Processing %s statement: %s", kind, err))
+	conv.mysqlStatement(kind).error++
+}
+
+// processMySQLStmt processes statement s, updating the schema (in
+// schema mode) or converting data (in data mode). For INSERT
+// statements, it returns the table the data is for.
+func processMySQLStmt(conv *Conv, s string) string {
+	s = mysqlUncomment(s)
+	toks := mysqlTokens(s)
+	if len(toks) == 0 {
+		return "" // Just comments.
+	}
+	kind := mysqlStmtKind(toks)
+	p := &mysqlParser{s: s, toks: toks}
+	switch kind {
+	case "CREATE TABLE":
+		if conv.schemaMode() {
+			if ok, err := processMySQLCreateTable(conv, p); err != nil {
+				logMySQLStmtError(conv, kind, err)
+			} else if ok {
+				conv.mysqlStatement(kind).schema++
+			} else {
+				conv.mysqlStatement(kind).skip++
+			}
+		}
+	case "DROP TABLE":
+		if conv.schemaMode() && processMySQLDropTable(conv, p) {
+			conv.mysqlStatement(kind).schema++
+		} else {
+			conv.mysqlStatement(kind).skip++
+		}
+	case "INSERT", "REPLACE":
+		return processMySQLInsert(conv, p, kind)
+	case "CREATE VIEW", "CREATE TRIGGER", "CREATE PROCEDURE", "CREATE FUNCTION":
+		if conv.schemaMode() {
+			processMySQLDroppedStmt(conv, p, kind, s)
+		}
+		conv.mysqlStatement(kind).skip++
+	case "SET":
+		if conv.schemaMode() {
+			processMySQLSet(conv, p)
+		}
+		conv.mysqlStatement(kind).skip++
+	default:
+		VerbosePrintf("Skipping statement: %s\n", kind)
+		conv.mysqlStatement(kind).skip++
+	}
+	return ""
+}
+
+// processMySQLCreateTable adds the table defined by a CREATE TABLE
+// statement to the source schema. It returns false if the table was
+// already defined (see droptable.go).
+func processMySQLCreateTable(conv *Conv, p *mysqlParser) (bool, error) {
+	p.next() // CREATE.
+	p.keyword("TEMPORARY")
+	p.keyword("TABLE")
+	p.keyword("IF", "NOT", "EXISTS")
+	table, ok := p.ident()
+	if !ok {
+		return false, fmt.Errorf("can't get table name")
+	}
+	if conv.duplicateTable(table) {
+		return false, nil
+	}
+	if !p.punct("(") {
+		return false, fmt.Errorf("table %s has no column definitions (CREATE TABLE ... LIKE and CREATE TABLE ... SELECT are not supported)", table)
+	}
+	t := schema.Table{Name: table, ColDefs: make(map[string]schema.Column)}
+	for {
+		start := p.pos()
+		index, err := processMySQLTableElement(conv, p, &t)
+		if err != nil {
+			return false, fmt.Errorf("table %s: %w", table, err)
+		}
+		p.skipElement() // Ignore the rest e.g. USING BTREE.
+		if index != "" {
+			if conv.indexSQL[table] == nil {
+				conv.indexSQL[table] = make(map[string]string)
+			}
+			conv.indexSQL[table][index] = strings.TrimSpace(p.s[start:p.pos()])
+		}
+		if p.punct(")") {
+			break
+		}
+		if !p.punct(",") {
+			return false, fmt.Errorf("table %s: unexpected end of statement", table)
+		}
+	}
+	// Table options (e.g. ENGINE=InnoDB) are ignored.
+	conv.srcSchema[table] = t
+	return true, nil
+}
+
+// processMySQLDropTable handles DROP TABLE, discarding the definitions
+// of the tables it drops (see droptable.go). It returns whether any
+// table was dropped: mysqldump drops each table before creating it.
+func processMySQLDropTable(conv *Conv, p *mysqlParser) bool {
+	p.next() // DROP.
+	p.keyword("TEMPORARY")
+	p.keyword("TABLE")
+	p.keyword("IF", "EXISTS")
+	dropped := false
+	for {
+		table, ok := p.ident()
+		if !ok {
+			break
+		}
+		if conv.dropTable(table) {
+			dropped = true
+		}
+		if !p.punct(",") {
+			break
+		}
+	}
+	return dropped
+}
+
+// processMySQLTableElement processes an element of the table definition
+// of t (a column, key or constraint). It returns the name of the
+// index, if the element defines one.
+func processMySQLTableElement(conv *Conv, p *mysqlParser, t *schema.Table) (string, error) {
+	var name string // Constraint name.
+	if p.keyword("CONSTRAINT") {
+		if u := strings.ToUpper(p.peek().val); p.peek().kind != mysqlWord || (u != "PRIMARY" && u != "UNIQUE" && u != "FOREIGN" && u != "CHECK") {
+			name, _ = p.ident()
+		}
+	}
+	switch {
+	case p.keyword("PRIMARY", "KEY"):
+		p.indexType()
+		keys, _, err := p.keyList()
+		if err != nil {
+			return "", err
+		}
+		checkEmpty(conv, t.PrimaryKeys, "CREATE TABLE")
+		t.PrimaryKeys = keys
+		for _, k := range keys {
+			// As for PostgreSQL, primary key columns are NOT NULL.
+			if c, ok := t.ColDefs[k.Column]; ok {
+				c.NotNull = true
+				t.ColDefs[k.Column] = c
+			}
+		}
+	case p.keyword("FOREIGN", "KEY"):
+		p.indexName() // MySQL's name for the foreign key's index.
+		keys, _, err := p.keyList()
+		if err != nil {
+			return "", err
+		}
+		if !p.keyword("REFERENCES") {
+			return "", fmt.Errorf("foreign key has no REFERENCES clause")
+		}
+		fk := schema.ForeignKey{Name: name, OnDelete: "NO ACTION", OnUpdate: "NO ACTION"}
+		if fk.ReferTable, _ = p.ident(); fk.ReferTable == "" {
+			return "", fmt.Errorf("can't get referenced table of foreign key")
+		}
+		referKeys, _, err := p.keyList()
+		if err != nil {
+			return "", err
+		}
+		for _, k := range keys {
+			fk.Columns = append(fk.Columns, k.Column)
+		}
+		for _, k := range referKeys {
+			fk.ReferColumns = append(fk.ReferColumns, k.Column)
+		}
+		for {
+			if p.keyword("ON", "DELETE") {
+				fk.OnDelete = p.refAction()
+			} else if p.keyword("ON", "UPDATE") {
+				fk.OnUpdate = p.refAction()
+			} else {
+				break
+			}
+		}
+		t.ForeignKeys = append(t.ForeignKeys, fk)
+	case p.keyword("CHECK"):
+		// Check constraints are ignored.
+	case p.keyword("UNIQUE"), p.keyword("KEY"), p.keyword("INDEX"), p.keyword("FULLTEXT"), p.keyword("SPATIAL"):
+		i := schema.Index{Unique: strings.EqualFold(p.toks[p.i-1].val, "UNIQUE")}
+		if u := strings.ToLower(p.toks[p.i-1].val); u == "fulltext" || u == "spatial" {
+			i.Ignored.Method = u
+		}
+		if !p.keyword("KEY") {
+			p.keyword("INDEX")
+		}
+		if i.Name = p.indexName(); i.Name == "" {
+			i.Name = name
+		}
+		p.indexType()
+		keys, expr, err := p.keyList()
+		if err != nil {
+			return "", err
+		}
+		i.Keys, i.Ignored.Expression = keys, expr
+		if i.Name == "" && len(keys) > 0 {
+			i.Name = keys[0].Column // MySQL names unnamed indexes after their first column.
+		}
+		t.Indexes = append(t.Indexes, i)
+		return i.Name, nil
+	case name != "":
+		// A constraint we don't know about.
+	default:
+		return "", processMySQLColumn(conv, p, t)
+	}
+	return "", nil
+}
+
+// processMySQLColumn adds the column defined by the next element of a
+// CREATE TABLE statement to t.
+func processMySQLColumn(conv *Conv, p *mysqlParser, t *schema.Table) error {
+	name, ok := p.ident()
+	if !ok {
+		return fmt.Errorf("can't get column name")
+	}
+	tt := p.next()
+	if tt.kind != mysqlWord {
+		return fmt.Errorf("can't get type of column %s", name)
+	}
+	ty := strings.ToLower(tt.val)
+	if ty == "double" && p.keyword("PRECISION") {
+		ty = "double precision"
+	}
+	var mods []int64
+	var values []string // Values of enum and set types.
+	if p.punct("(") {
+		for !p.punct(")") {
+			switch x := p.next(); x.kind {
+			case mysqlNumber:
+				n, err := strconv.ParseInt(x.val, 10, 64)
+				if err != nil {
+					return fmt.Errorf("can't get type modifier of column %s: %w", name, err)
+				}
+				mods = append(mods, n)
+			case mysqlString:
+				values = append(values, x.val)
+			case mysqlEnd:
+				return fmt.Errorf("can't get type of column %s", name)
+			}
+		}
+	}
+	col := schema.Column{Name: name}
+	unsigned := false
+	for !p.atElementEnd() {
+		switch {
+		case p.keyword("UNSIGNED"):
+			unsigned = true
+		case p.keyword("NOT", "NULL"):
+			col.NotNull = true
+		case p.keyword("DEFAULT"):
+			// mysqldump shows DEFAULT NULL for all nullable columns
+			// without a default, so we don't count it as a default.
+			if !p.keyword("NULL") {
+				col.Ignored.Default = true
+				col.Ignored.Now = p.atCurrentTime()
+				p.skipValue()
+			}
+		case p.keyword("AUTO_INCREMENT"):
+			col.Ignored.Identity = true
+		case p.keyword("PRIMARY", "KEY"), p.keyword("KEY"):
+			t.PrimaryKeys = append(t.PrimaryKeys, schema.Key{Column: name})
+			col.NotNull = true
+		case p.keyword("UNIQUE"):
+			if !p.keyword("KEY") {
+				p.keyword("INDEX")
+			}
+			t.Indexes = append(t.Indexes, schema.Index{Name: name, Keys: []schema.Key{{Column: name}}, Unique: true})
+		case p.keyword("CHECK"):
+			col.Ignored.Check = true
+		default:
+			// Other attributes e.g. COMMENT, COLLATE and ON UPDATE.
+			p.skip()
+		}
+	}
+	switch ty {
+	case "enum", "set":
+		var l []string
+		for _, v := range values {
+			l = append(l, "'"+strings.ReplaceAll(v, "'", "''")+"'")
+		}
+		ty = fmt.Sprintf("%s(%s)", ty, strings.Join(l, ","))
+		mods = nil
+	case "tinyint":
+		if len(mods) != 1 || mods[0] != 1 {
+			mods = nil
+		}
+	case "smallint", "mediumint", "int", "integer", "bigint", "year":
+		// Display widths don't affect the values of integer types.
+		mods = nil
+	}
+	if unsigned {
+		ty += " unsigned"
+	}
+	col.Type = schema.Type{Name: ty, Mods: mods}
+	if _, ok := t.ColDefs[name]; !ok {
+		t.ColNames = append(t.ColNames, name)
+	}
+	t.ColDefs[name] = col
+	return nil
+}
+
+// indexName consumes an (optional) index name.
+func (p *mysqlParser) indexName() string {
+	t := p.peek()
+	if t.kind == mysqlQuotedIdent || (t.kind == mysqlWord && !strings.EqualFold(t.val, "USING")) {
+		p.i++
+		return t.val
+	}
+	return ""
+}
+
+// indexType consumes an (optional) index type e.g. USING BTREE.
+func (p *mysqlParser) indexType() {
+	if p.keyword("USING") {
+		p.next()
+	}
+}
+
+// keyList consumes a parenthesized list of index keys. Prefix lengths
+// (e.g. `name`(10)) are ignored: the whole column is used. It also
+// returns true if some keys are expressions.
+func (p *mysqlParser) keyList() ([]schema.Key, bool, error) {
+	if !p.punct("(") {
+		return nil, false, fmt.Errorf("expected list of key columns")
+	}
+	var keys []schema.Key
+	expr := false
+	for {
+		if p.atPunct("(") {
+			expr = true
+			p.skip()
+		} else {
+			col, ok := p.ident()
+			if !ok {
+				return nil, false, fmt.Errorf("can't get key column")
+			}
+			if p.atPunct("(") {
+				p.skip() // Prefix length.
+			}
+			k := schema.Key{Column: col}
+			if p.keyword("DESC") {
+				k.Desc = true
+			} else {
+				p.keyword("ASC")
+			}
+			keys = append(keys, k)
+		}
+		if p.punct(")") {
+			return keys, expr, nil
+		}
+		if !p.punct(",") {
+			return nil, false, fmt.Errorf("malformed list of key columns")
+		}
+	}
+}
+
+// refAction consumes a foreign key's referential action.
+func (p *mysqlParser) refAction() string {
+	for _, a := range []string{"RESTRICT", "CASCADE", "SET NULL", "SET DEFAULT", "NO ACTION"} {
+		if p.keyword(strings.Fields(a)...) {
+			return a
+		}
+	}
+	return strings.ToUpper(p.next().val)
+}
+
+// skipValue skips a simple expression, such as a default value.
+func (p *mysqlParser) skipValue() {
+	if !p.punct("-") {
+		p.punct("+")
+	}
+	t := p.peek()
+	p.skip()
+	// Function calls e.g. CURRENT_TIMESTAMP(6), and strings with
+	// character set introducers e.g. _utf8mb4'abc'.
+	if t.kind == mysqlWord && (p.atPunct("(") || (strings.HasPrefix(t.val, "_") && p.peek().kind == mysqlString)) {
+		p.skip()
+	}
+}
+
+// atCurrentTime reports whether the next value is the current time
+// e.g. CURRENT_TIMESTAMP(6) or NOW().
+func (p *mysqlParser) atCurrentTime() bool {
+	t := p.peek()
+	if t.kind != mysqlWord {
+		return false
+	}
+	switch strings.ToUpper(t.val) {
+	case "CURRENT_TIMESTAMP", "NOW", "LOCALTIMESTAMP", "LOCALTIME":
+		return true
+	}
+	return false
+}
+
+// processMySQLInsert processes an INSERT (or REPLACE) statement. In
+// schema mode, we just count rows, but in data mode, rows are
+// converted and written to Spanner. Returns the table.
+func processMySQLInsert(conv *Conv, p *mysqlParser, kind string) string {
+	p.next() // INSERT or REPLACE.
+	for p.keyword("LOW_PRIORITY") || p.keyword("DELAYED") || p.keyword("HIGH_PRIORITY") || p.keyword("IGNORE") {
+	}
+	p.keyword("INTO")
+	table, ok := p.ident()
+	if !ok {
+		logMySQLStmtError(conv, kind, fmt.Errorf("can't get table name"))
+		return ""
+	}
+	var cols []string
+	if p.punct("(") {
+		for !p.punct(")") {
+			c, ok := p.ident()
+			if !ok {
+				logMySQLStmtError(conv, kind, fmt.Errorf("can't get column names for table %s", table))
+				return ""
+			}
+			cols = append(cols, c)
+			p.punct(",")
+		}
+	} else {
+		cols = conv.srcSchema[table].ColNames
+	}
+	if !p.keyword("VALUES") && !p.keyword("VALUE") {
+		logMySQLStmtError(conv, kind, fmt.Errorf("only INSERT ... VALUES is supported (table %s)", table))
+		return ""
+	}
+	conv.mysqlStatement(kind).data++
+	for p.punct("(") {
+		vals, err := processMySQLValues(conv, p)
+		if err != nil {
+			conv.unexpected(fmt.Sprintf("Processing %s statement for table %s: %s", kind, table, err))
+			break
+		}
+		conv.statsAddRow(table, conv.schemaMode())
+		if conv.dataMode() {
+			processMySQLRow(conv, table, cols, vals)
+		}
+		if !p.punct(",") {
+			break
+		}
+	}
+	// Any ON DUPLICATE KEY UPDATE clause is ignored.
+	return table
+}
+
+// mysqlValue is a value from an INSERT statement.
+type mysqlValue struct {
+	val  string
+	null bool
+}
+
+// processMySQLValues consumes the rest of a (parenthesized) list of
+// values of an INSERT statement, and returns the values.
+func processMySQLValues(conv *Conv, p *mysqlParser) ([]mysqlValue, error) {
+	var l []mysqlValue
+	if p.punct(")") {
+		return l, nil
+	}
+	for {
+		start := p.pos()
+		t := p.next()
+		switch {
+		case t.kind == mysqlString || t.kind == mysqlNumber:
+			l = append(l, mysqlValue{val: t.val})
+		case t.kind == mysqlWord && strings.EqualFold(t.val, "NULL"):
+			l = append(l, mysqlValue{null: true})
+		case t.kind == mysqlWord && strings.EqualFold(t.val, "TRUE"):
+			l = append(l, mysqlValue{val: "1"})
+		case t.kind == mysqlWord && strings.EqualFold(t.val, "FALSE"):
+			l = append(l, mysqlValue{val: "0"})
+		case t.kind == mysqlPunct && (t.val == "-" || t.val == "+") && p.peek().kind == mysqlNumber:
+			v := p.next().val
+			if t.val == "-" {
+				v = "-" + v
+			}
+			l = append(l, mysqlValue{val: v})
+		case t.kind == mysqlWord && strings.HasPrefix(t.val, "_") && p.peek().kind == mysqlString:
+			// Character set introducer e.g. _binary 'abc'.
+			l = append(l, mysqlValue{val: p.next().val})
+		default:
+			// Not a literal (e.g. a function call). Use its text,
+			// which will most likely fail data conversion.
+			p.i--
+			p.skipElement()
+			v := strings.TrimSpace(p.s[start:p.pos()])
+			conv.unexpected(fmt.Sprintf("Found non-literal value %s in VALUES list", v))
+			l = append(l, mysqlValue{val: v})
+		}
+		if p.punct(")") {
+			return l, nil
+		}
+		if !p.punct(",") {
+			return nil, fmt.Errorf("malformed VALUES list")
+		}
+	}
+}
+
+// processMySQLRow converts a row of an INSERT statement and writes it
+// out to Spanner (see ProcessDataRow). NULL values are dropped, as for
+// pg_dump data (see ConvertData).
+func processMySQLRow(conv *Conv, table string, cols []string, vals []mysqlValue) {
+	var c, v []string
+	for i, x := range vals {
+		if len(vals) == len(cols) && x.null {
+			conv.observeValue(table, cols[i], "", true)
+			continue
+		}
+		if i < len(cols) {
+			c = append(c, cols[i])
+		}
+		if x.null {
+			x.val = "NULL"
+		}
+		v = append(v, x.val)
+	}
+	ProcessDataRow(conv, table, c, v)
+}
+
+var mysqlOffsetRE = regexp.MustCompile(`^([+-])(\d{1,2}):(\d{2})$`)
+
+// processMySQLSet processes a SET statement. We only care about the
+// time zone, which mysqldump sets (usually to UTC) before dumping
+// timestamp data.
+func processMySQLSet(conv *Conv, p *mysqlParser) {
+	for i := 0; i+2 < len(p.toks); i++ {
+		t := p.toks[i]
+		if t.kind != mysqlWord || !strings.EqualFold(t.val, "TIME_ZONE") || p.toks[i+1].val != "=" || p.toks[i+2].kind != mysqlString {
+			continue
+		}
+		tz := p.toks[i+2].val
+		if m := mysqlOffsetRE.FindStringSubmatch(tz); m != nil {
+			h, _ := strconv.Atoi(m[2])
+			min, _ := strconv.Atoi(m[3])
+			offset := h*3600 + min*60
+			if m[1] == "-" {
+				offset = -offset
+			}
+			conv.SetLocation(time.FixedZone(tz, offset))
+		} else if !strings.EqualFold(tz, "SYSTEM") {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				logMySQLStmtError(conv, "SET", err)
+				return
+			}
+			conv.SetLocation(loc)
+		}
+	}
+}
+
+// processMySQLDroppedStmt records a view, trigger or stored routine as
+// a dropped object.
+func processMySQLDroppedStmt(conv *Conv, p *mysqlParser, kind, sql string) {
+	obj := strings.TrimPrefix(kind, "CREATE ")
+	for !p.done() && !p.keyword(obj) {
+		p.next()
+	}
+	p.keyword("IF", "NOT", "EXISTS")
+	name, _ := p.ident()
+	var tables []string
+	switch obj {
+	case "VIEW":
+		// mysqldump first creates a placeholder for each view (a
+		// table, or a view with constant columns), and replaces it
+		// once all tables are created.
+		delete(conv.srcSchema, name)
+		delete(conv.indexSQL, name)
+		var l []droppedObject
+		for _, d := range conv.dropped {
+			if d.kind != "view" || d.name != name {
+				l = append(l, d)
+			}
+		}
+		conv.dropped = l
+		// The tables used are named after FROM and JOIN.
+		for !p.done() {
+			if p.keyword("FROM") || p.keyword("JOIN") {
+				for p.punct("(") {
+				}
+				if t, ok := p.ident(); ok && t != name {
+					if _, ok := conv.srcSchema[t]; ok {
+						tables = append(tables, t)
+					}
+				}
+				continue
+			}
+			p.next()
+		}
+	case "TRIGGER":
+		for !p.done() && !p.keyword("ON") {
+			p.next()
+		}
+		if t, ok := p.ident(); ok {
+			tables = []string{t}
+		}
+	}
+	conv.addDroppedObject(strings.ToLower(obj), name, dedupe(tables), sql, "")
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestProcessMySQLDump_Scalar(t *testing.T) {
+	scalarTests := []struct {
+		ty       string
+		expected ddl.ScalarType
+	}{
+		{"bigint", ddl.Int64{}},
+		{"bigint(20) unsigned", ddl.Int64{}},
+		{"binary(16)", ddl.Bytes{Len: ddl.Int64Length{Value: 16}}},
+		{"bit(1)", ddl.Bool{}},
+		{"blob", ddl.Bytes{Len: ddl.MaxLength{}}},
+		{"char(42)", ddl.String{Len: ddl.Int64Length{Value: 42}}},
+		{"date", ddl.Date{}},
+		{"decimal(10,2)", ddl.Numeric{}},
+		{"decimal(65,30)", ddl.String{Len: ddl.MaxLength{}}},
+		{"double", ddl.Float64{}},
+		{"double precision", ddl.Float64{}},
+		{"enum('small','medium','large')", ddl.String{Len: ddl.Int64Length{Value: 6}}},
+		{"float", ddl.Float64{}},
+		{"int(11)", ddl.Int64{}},
+		{"json", ddl.String{Len: ddl.MaxLength{}}},
+		{"longtext", ddl.String{Len: ddl.MaxLength{}}},
+		{"set('a','bc')", ddl.String{Len: ddl.Int64Length{Value: 4}}},
+		{"smallint(6)", ddl.Int64{}},
+		{"text", ddl.String{Len: ddl.MaxLength{}}},
+		{"timestamp", ddl.Timestamp{}},
+		{"tinyint(1)", ddl.Bool{}},
+		{"tinyint(4)", ddl.Int64{}},
+		{"varbinary(255)", ddl.Bytes{Len: ddl.Int64Length{Value: 255}}},
+		{"varchar(42)", ddl.String{Len: ddl.Int64Length{Value: 42}}},
+	}
+	for _, tc := range scalarTests {
+		conv, _ := runProcessMySQLDump(fmt.Sprintf("CREATE TABLE `t` (`a` %s);", tc.ty))
+		assert.Zero(t, len(conv.stats.unexpected), "Scalar type: "+tc.ty)
+		assert.Equal(t, tc.expected, conv.spSchema["t"].ColDefs["a"].T, "Scalar type: "+tc.ty)
+	}
+}
+
+const mysqlDumpSample = "-- MySQL dump 10.13  Distrib 8.0.22, for Linux (x86_64)\n" +
+	"/*!40101 SET NAMES utf8mb4 */;\n" +
+	"/*!40103 SET TIME_ZONE='+00:00' */;\n" +
+	"\n" +
+	"DROP TABLE IF EXISTS `orgs`;\n" +
+	"CREATE TABLE `orgs` (\n" +
+	"  `id` int(11) NOT NULL AUTO_INCREMENT,\n" +
+	"  `name` varchar(100) NOT NULL DEFAULT '',\n" +
+	"  `size` enum('small','large') DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  UNIQUE KEY `name_idx` (`name`)\n" +
+	") ENGINE=InnoDB AUTO_INCREMENT=3 DEFAULT CHARSET=utf8mb4;\n" +
+	"\n" +
+	"LOCK TABLES `orgs` WRITE;\n" +
+	"/*!40000 ALTER TABLE `orgs` DISABLE KEYS */;\n" +
+	"INSERT INTO `orgs` VALUES (1,'It\\'s; ok','small'),(2,'Two\\nlines',NULL);\n" +
+	"/*!40000 ALTER TABLE `orgs` ENABLE KEYS */;\n" +
+	"UNLOCK TABLES;\n" +
+	"\n" +
+	"CREATE TABLE `members` (\n" +
+	"  `org_id` int(11) NOT NULL,\n" +
+	"  `user` varchar(20) NOT NULL,\n" +
+	"  `active` tinyint(1) NOT NULL DEFAULT '1',\n" +
+	"  `avatar` blob,\n" +
+	"  `joined` datetime DEFAULT NULL,\n" +
+	"  `seen` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,\n" +
+	"  PRIMARY KEY (`org_id`,`user`),\n" +
+	"  KEY `user_idx` (`user`(10) DESC),\n" +
+	"  CONSTRAINT `fk_org` FOREIGN KEY (`org_id`) REFERENCES `orgs` (`id`) ON DELETE CASCADE\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n" +
+	"\n" +
+	"INSERT INTO `members` VALUES (1,'ann',1,0xBEEF,'2019-10-29 05:30:00','2019-10-29 05:30:00'),(2,'bob',0,NULL,NULL,NULL);\n" +
+	"\n" +
+	"/*!50001 DROP VIEW IF EXISTS `active_members`*/;\n" +
+	"/*!50001 CREATE VIEW `active_members` AS SELECT 1 AS `user`*/;\n" +
+	"/*!50001 DROP VIEW IF EXISTS `active_members`*/;\n" +
+	"/*!50001 CREATE ALGORITHM=UNDEFINED */\n" +
+	"/*!50013 DEFINER=`root`@`localhost` SQL SECURITY DEFINER */\n" +
+	"/*!50001 VIEW `active_members` AS select `members`.`user` AS `user` from `members` where `members`.`active` */;\n" +
+	"\n" +
+	"DELIMITER ;;\n" +
+	"/*!50003 CREATE*/ /*!50017 DEFINER=`root`@`localhost`*/ /*!50003 TRIGGER `members_bi` BEFORE INSERT ON `members` FOR EACH ROW BEGIN\n" +
+	"  SET NEW.user = LOWER(NEW.user);\n" +
+	"END */;;\n" +
+	"DELIMITER ;\n" +
+	"-- Dump completed\n"
+
+func TestProcessMySQLDump(t *testing.T) {
+	conv, rows := runProcessMySQLDump(mysqlDumpSample)
+	assert.Zero(t, len(conv.stats.unexpected), fmt.Sprintf("unexpected conditions: %v", unexpectedCounts(conv)))
+	assert.Zero(t, len(conv.stats.badRows), fmt.Sprintf("bad rows: %v", conv.stats.badRows))
+	expectedSchema := map[string]ddl.CreateTable{
+		"orgs": ddl.CreateTable{
+			Name:     "orgs",
+			ColNames: []string{"id", "name", "size"},
+			ColDefs: map[string]ddl.ColumnDef{
+				"id":   ddl.ColumnDef{Name: "id", T: ddl.Int64{}, NotNull: true},
+				"name": ddl.ColumnDef{Name: "name", T: ddl.String{Len: ddl.Int64Length{Value: 100}}, NotNull: true},
+				"size": ddl.ColumnDef{Name: "size", T: ddl.String{Len: ddl.Int64Length{Value: 5}}},
+			},
+			Pks:     []ddl.IndexKey{ddl.IndexKey{Col: "id"}},
+			Indexes: []ddl.CreateIndex{ddl.CreateIndex{Name: "name_idx", Table: "orgs", Unique: true, Keys: []ddl.IndexKey{ddl.IndexKey{Col: "name"}}}},
+		},
+		"members": ddl.CreateTable{
+			Name:     "members",
+			ColNames: []string{"org_id", "user", "active", "avatar", "joined", "seen"},
+			ColDefs: map[string]ddl.ColumnDef{
+				"org_id": ddl.ColumnDef{Name: "org_id", T: ddl.Int64{}, NotNull: true},
+				"user":   ddl.ColumnDef{Name: "user", T: ddl.String{Len: ddl.Int64Length{Value: 20}}, NotNull: true},
+				"active": ddl.ColumnDef{Name: "active", T: ddl.Bool{}, NotNull: true},
+				"avatar": ddl.ColumnDef{Name: "avatar", T: ddl.Bytes{Len: ddl.MaxLength{}}},
+				"joined": ddl.ColumnDef{Name: "joined", T: ddl.Timestamp{}},
+				"seen":   ddl.ColumnDef{Name: "seen", T: ddl.Timestamp{}},
+			},
+			Pks:         []ddl.IndexKey{ddl.IndexKey{Col: "org_id"}, ddl.IndexKey{Col: "user"}},
+			ForeignKeys: []ddl.ForeignKey{ddl.ForeignKey{Name: "fk_org", Columns: []string{"org_id"}, ReferTable: "orgs", ReferColumns: []string{"id"}, OnDelete: "CASCADE"}},
+			Indexes:     []ddl.CreateIndex{ddl.CreateIndex{Name: "user_idx", Table: "members", Keys: []ddl.IndexKey{ddl.IndexKey{Col: "user", Desc: true}}}},
+		},
+	}
+	assert.Equal(t, expectedSchema, stripSchemaComments(conv.spSchema))
+	expectedData := []spannerData{
+		spannerData{table: "orgs", cols: []string{"id", "name", "size"}, vals: []interface{}{int64(1), "It's; ok", "small"}},
+		spannerData{table: "orgs", cols: []string{"id", "name"}, vals: []interface{}{int64(2), "Two\nlines"}},
+		spannerData{table: "members", cols: []string{"org_id", "user", "active", "avatar", "joined", "seen"},
+			vals: []interface{}{int64(1), "ann", true, []byte{0xbe, 0xef}, getTime(t, "2019-10-29T05:30:00Z"), getTime(t, "2019-10-29T05:30:00Z")}},
+		spannerData{table: "members", cols: []string{"org_id", "user", "active"}, vals: []interface{}{int64(2), "bob", false}},
+	}
+	assert.Equal(t, expectedData, utcTimes(rows))
+
+	// Views and triggers are dropped (and view placeholders don't
+	// become tables).
+	var dropped []string
+	for _, d := range conv.dropped {
+		dropped = append(dropped, fmt.Sprintf("%s %s %v", d.kind, d.name, d.tables))
+	}
+	assert.Equal(t, []string{"view active_members [members]", "trigger members_bi [members]"}, dropped)
+
+	// Check statement stats.
+	stmts := make(map[string]statementStat)
+	for k, s := range conv.stats.statement {
+		stmts[k] = *s
+	}
+	assert.Equal(t, statementStat{schema: 2}, stmts["CREATE TABLE"])
+	assert.Equal(t, statementStat{data: 2}, stmts["INSERT"])
+	assert.Equal(t, statementStat{skip: 2}, stmts["CREATE VIEW"])
+	assert.Equal(t, statementStat{skip: 1}, stmts["CREATE TRIGGER"])
+	assert.Equal(t, statementStat{skip: 1}, stmts["LOCK TABLES"])
+}
+
+func TestProcessMySQLDump_Issues(t *testing.T) {
+	conv, _ := runProcessMySQLDump(mysqlDumpSample)
+	issues := func(table, col string) []schemaIssue {
+		return conv.issues[table][col]
+	}
+	assert.Equal(t, []schemaIssue{widened, serial}, issues("orgs", "id"))
+	assert.Equal(t, []schemaIssue{datetime}, issues("members", "joined"))
+	assert.Equal(t, []schemaIssue{defaultValue}, issues("members", "active"))
+}
+
+func TestProcessMySQLDump_TimeZone(t *testing.T) {
+	conv, rows := runProcessMySQLDump("SET TIME_ZONE='+10:30';\n" +
+		"CREATE TABLE t (a int NOT NULL PRIMARY KEY, b timestamp, c datetime);\n" +
+		"INSERT INTO t VALUES (1,'2019-10-29 05:30:00','2019-10-29 05:30:00');\n")
+	assert.Zero(t, len(conv.stats.unexpected))
+	assert.Equal(t, []spannerData{
+		spannerData{table: "t", cols: []string{"a", "b", "c"},
+			vals: []interface{}{int64(1), getTime(t, "2019-10-29T05:30:00+10:30").UTC(), getTime(t, "2019-10-29T05:30:00Z")}}}, utcTimes(rows))
+}
+
+func TestProcessMySQLDump_Errors(t *testing.T) {
+	conv, rows := runProcessMySQLDump("CREATE TABLE t LIKE u;\n" +
+		"CREATE TABLE v (a int NOT NULL PRIMARY KEY);\n" +
+		"INSERT INTO v VALUES (1),(NOW()),('x');\n")
+	assert.Equal(t, int64(1), conv.stats.statement["CREATE TABLE"].error)
+	assert.Equal(t, []spannerData{spannerData{table: "v", cols: []string{"a"}, vals: []interface{}{int64(1)}}}, rows)
+	assert.Equal(t, int64(3), conv.stats.rows["v"])
+	assert.Equal(t, int64(2), conv.stats.badRows["v"])
+}
+
+func TestMySQLScanner(t *testing.T) {
+	s := "SELECT 'a;b', \"c;d\", `e;f`; -- x;y\n" +
+		"SELECT /* g;h */ 1 # i;j\n" +
+		";\n" +
+		"DELIMITER //\n" +
+		"CREATE PROCEDURE p() BEGIN SELECT 1; END//\n" +
+		"DELIMITER ;\n" +
+		"SELECT 'it''s;', 'k\\';'\n"
+	sc := mysqlScanner{r: NewReader(bufio.NewReader(strings.NewReader(s)), nil), delim: ";"}
+	var stmts []string
+	for {
+		stmt, ok := sc.next()
+		if !ok {
+			break
+		}
+		stmts = append(stmts, strings.TrimSpace(stmt))
+	}
+	assert.Equal(t, []string{
+		"SELECT 'a;b', \"c;d\", `e;f`",
+		"SELECT /* g;h */ 1",
+		"CREATE PROCEDURE p() BEGIN SELECT 1; END",
+		"SELECT 'it''s;', 'k\\';'",
+	}, stmts)
+}
+
+func TestMySQLTokens(t *testing.T) {
+	var l []string
+	for _, x := range mysqlTokens("INSERT INTO `a``b` VALUES (-1.5e3,'x\\0y''z',X'4142',0x43,b'101',_binary 'q')") {
+		l = append(l, fmt.Sprintf("%d:%q", x.kind, x.val))
+	}
+	assert.Equal(t, []string{
+		`1:"INSERT"`, `1:"INTO"`, `2:"a` + "`" + `b"`, `1:"VALUES"`, `5:"("`, `5:"-"`, `4:"1.5e3"`, `5:","`,
+		`3:"x\x00y'z"`, `5:","`, `3:"AB"`, `5:","`, `3:"C"`, `5:","`, `4:"5"`, `5:","`,
+		`1:"_binary"`, `3:"q"`, `5:")"`,
+	}, l)
+}
+
+func TestMySQLUncomment(t *testing.T) {
+	assert.Equal(t, "  SET NAMES utf8    ", mysqlUncomment("/*!40101 SET NAMES utf8 */ /* x */"))
+	assert.Equal(t, "SELECT '/* a */'  ", mysqlUncomment("SELECT '/* a */' /* b */"))
+}
+
+func TestProcessMySQLDump_Report(t *testing.T) {
+	conv, _ := runProcessMySQLDump(mysqlDumpSample)
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	GenerateReport(MySQLDumpSource, conv, w, nil)
+	w.Flush()
+	report := b.String()
+	assert.Contains(t, report, "detected but ignored: triggers,\nviews.")
+	assert.Contains(t, report, "stats on the mysqldump statements\nprocessed")
+	assert.Contains(t, report, "Analysis of statements in mysqldump output")
+	assert.Contains(t, report, "Some columns have source DB type 'datetime' which is mapped to Spanner type\n   timestamp e.g. column 'joined' (values interpreted as UTC).")
+	assert.Contains(t, report, "Triggers\n1) members_bi (table members).")
+}
+
+func runProcessMySQLDump(s string) (*Conv, []spannerData) {
+	return runProcessMySQLDumpConv(MakeConv(), s)
+}
+
+// runProcessMySQLDumpConv is like runProcessMySQLDump, but uses conv
+// (which can be pre-configured).
+func runProcessMySQLDumpConv(conv *Conv, s string) (*Conv, []spannerData) {
+	conv.SetLocation(time.UTC)
+	conv.now = func() time.Time { return time.Time{} }
+	conv.SetSchemaMode()
+	ProcessMySQLDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	conv.SetDataMode()
+	var rows []spannerData
+	conv.SetDataSink(
+		func(table string, cols []string, vals []interface{}) {
+			rows = append(rows, spannerData{table: table, cols: cols, vals: vals})
+		})
+	ProcessMySQLDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	return conv, rows
+}
+
+// utcTimes converts timestamps in rows to UTC, so that they can be
+// compared regardless of the time zone used to parse them.
+func utcTimes(rows []spannerData) []spannerData {
+	for _, r := range rows {
+		for i, v := range r.vals {
+			if x, ok := v.(time.Time); ok {
+				r.vals[i] = x.UTC()
+			}
+		}
+	}
+	return rows
+}
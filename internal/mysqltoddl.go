@@ -0,0 +1,156 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// toSpannerTypeMySQL maps a scalar MySQL type (defined by id and mods)
+// into a Spanner type. It is the MySQL equivalent of toSpannerType.
+// Unsigned integer types have an " unsigned" suffix, and enum and set
+// types include their values e.g. "enum('a','b')" (see
+// processMySQLColumn).
+func toSpannerTypeMySQL(conv *Conv, id string, mods []int64) (ddl.ScalarType, []schemaIssue) {
+	maxExpectedMods := func(n int) {
+		if len(mods) > n {
+			conv.unexpected(fmt.Sprintf("Found %d mods while processing type id=%s", len(mods), id))
+		}
+	}
+	switch {
+	case strings.HasPrefix(id, "enum("):
+		// The longest value.
+		var n int64
+		for _, v := range mysqlTypeValues(id) {
+			if l := int64(utf8.RuneCountInString(v)); l > n {
+				n = l
+			}
+		}
+		return ddl.String{Len: ddl.Int64Length{Value: n}}, nil
+	case strings.HasPrefix(id, "set("):
+		// All values, separated by commas.
+		var n int64
+		for i, v := range mysqlTypeValues(id) {
+			if i > 0 {
+				n++
+			}
+			n += int64(utf8.RuneCountInString(v))
+		}
+		return ddl.String{Len: ddl.Int64Length{Value: n}}, nil
+	}
+	base := strings.TrimSuffix(id, " unsigned")
+	switch base {
+	case "bool", "boolean":
+		maxExpectedMods(0)
+		return ddl.Bool{}, nil
+	case "tinyint":
+		maxExpectedMods(1)
+		// By convention, tinyint(1) is used for booleans (and
+		// mysqldump shows bool columns as tinyint(1)).
+		if len(mods) == 1 && mods[0] == 1 && base == id {
+			return ddl.Bool{}, nil
+		}
+		return ddl.Int64{}, []schemaIssue{widened}
+	case "bit":
+		maxExpectedMods(1)
+		if len(mods) == 0 || mods[0] == 1 {
+			return ddl.Bool{}, nil
+		}
+		return ddl.Int64{}, nil
+	case "smallint", "mediumint", "int", "integer", "year":
+		maxExpectedMods(0)
+		return ddl.Int64{}, []schemaIssue{widened}
+	case "bigint":
+		// Note: bigint unsigned values above 2^63-1 don't fit, and are
+		// counted as bad rows.
+		maxExpectedMods(0)
+		return ddl.Int64{}, nil
+	case "float":
+		maxExpectedMods(2)
+		return ddl.Float64{}, []schemaIssue{widened}
+	case "double", "double precision", "real":
+		maxExpectedMods(2)
+		return ddl.Float64{}, nil
+	case "decimal", "numeric", "dec", "fixed":
+		maxExpectedMods(2)
+		if len(mods) == 0 {
+			mods = []int64{10} // MySQL's default precision.
+		}
+		if numericFits(mods) {
+			return ddl.Numeric{}, []schemaIssue{numericThatFits}
+		}
+		return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{numericOutOfRange}
+	case "char", "varchar":
+		maxExpectedMods(1)
+		if len(mods) > 0 {
+			return ddl.String{Len: ddl.Int64Length{Value: mods[0]}}, nil
+		}
+		return ddl.String{Len: ddl.Int64Length{Value: 1}}, nil
+	case "tinytext", "text", "mediumtext", "longtext", "json":
+		maxExpectedMods(1)
+		return ddl.String{Len: ddl.MaxLength{}}, nil
+	case "binary", "varbinary":
+		maxExpectedMods(1)
+		if len(mods) > 0 {
+			return ddl.Bytes{Len: ddl.Int64Length{Value: mods[0]}}, nil
+		}
+		return ddl.Bytes{Len: ddl.Int64Length{Value: 1}}, nil
+	case "tinyblob", "blob", "mediumblob", "longblob":
+		maxExpectedMods(1)
+		return ddl.Bytes{Len: ddl.MaxLength{}}, nil
+	case "date":
+		maxExpectedMods(0)
+		return ddl.Date{}, nil
+	case "datetime":
+		maxExpectedMods(1)
+		return ddl.Timestamp{}, []schemaIssue{datetime}
+	case "timestamp":
+		maxExpectedMods(1)
+		return ddl.Timestamp{}, nil
+	}
+	return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{noGoodType}
+}
+
+// mysqlDataType returns the name of the PostgreSQL type whose values
+// are converted in the same way as values of MySQL type id (see
+// convScalar), so that MySQL data can share PostgreSQL's data
+// conversion.
+func mysqlDataType(id string) string {
+	switch id {
+	case "timestamp":
+		// MySQL timestamps are points in time, written by mysqldump in
+		// the time zone set by the dump (see processMySQLSet).
+		return "timestamptz"
+	case "datetime":
+		return "timestamp"
+	}
+	return id
+}
+
+// mysqlTypeValues returns the values of an enum or set type id
+// e.g. "enum('a','b')".
+func mysqlTypeValues(id string) []string {
+	var l []string
+	for _, t := range mysqlTokens(id) {
+		if t.kind == mysqlString {
+			l = append(l, t.val)
+		}
+	}
+	return l
+}
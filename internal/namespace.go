@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+
+	nodes "github.com/lfittl/pg_query_go/nodes"
+)
+
+// PostgreSQL tables live in schemas (namespaces), whereas Spanner has
+// a single namespace. Source tables in the default schema (public,
+// unless configured otherwise) are named by their bare name, and other
+// tables are named schema.table e.g. audit.users. Spanner names can't
+// contain ".", so audit.users becomes the Spanner table audit_users
+// (see FixName), and GetSpannerTable renames it if that clashes with
+// another table.
+
+// DefaultSchema is the default for SetDefaultSchema.
+const DefaultSchema = "public"
+
+// defaultSQLServerSchema is the default for SetDefaultSchema for SQL
+// Server databases.
+const defaultSQLServerSchema = "dbo"
+
+// SetDefaultSchema sets the source schema whose tables are named
+// without a schema prefix (and so are converted to Spanner tables of
+// the same name). It must be called before schema conversion.
+func (conv *Conv) SetDefaultSchema(s string) {
+	conv.defaultSchema = s
+}
+
+// SetSourceSchemas limits the tables converted from a source database
+// (see ProcessInfoSchema) to those in schemas. Tables in all schemas
+// other than PostgreSQL's own are converted if schemas is empty. It
+// must be called before schema conversion.
+func (conv *Conv) SetSourceSchemas(schemas []string) {
+	conv.schemas = nil
+	for _, s := range schemas {
+		if conv.schemas == nil {
+			conv.schemas = make(map[string]bool)
+		}
+		conv.schemas[s] = true
+	}
+}
+
+// convertsSchema returns whether tables in source schema s are
+// converted (see SetSourceSchemas).
+func (conv *Conv) convertsSchema(s string) bool {
+	return conv.schemas == nil || conv.schemas[s]
+}
+
+// sortedSchemas returns the schemas of m, sorted.
+func sortedSchemas(m map[string]bool) []string {
+	var l []string
+	for s := range m {
+		l = append(l, s)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// schemaName returns the default source schema.
+func (conv *Conv) schemaName() string {
+	if conv.defaultSchema == "" {
+		if conv.sqlserver {
+			return defaultSQLServerSchema
+		}
+		return DefaultSchema
+	}
+	return conv.defaultSchema
+}
+
+// buildTableName returns the source table name for table name in
+// schema.
+func buildTableName(conv *Conv, schema, name string) string {
+	if schema == "" || schema == conv.schemaName() { // Drop default schema prefix.
+		return name
+	}
+	return fmt.Sprintf("%s.%s", schema, name)
+}
+
+// processSearchPath handles SET search_path in a pg_dump, which gives
+// the schema of unqualified names in subsequent statements (older
+// versions of pg_dump only qualify names this way). We use the first
+// schema other than pg_catalog.
+func processSearchPath(conv *Conv, n nodes.VariableSetStmt) {
+	conv.searchPath = ""
+	for _, a := range n.Args.Items {
+		c, ok := a.(nodes.A_Const)
+		if !ok {
+			continue
+		}
+		s, err := getString(c.Val)
+		if err != nil {
+			logStmtError(conv, n, fmt.Errorf("can't get search_path: %w", err))
+			return
+		}
+		if s != "pg_catalog" && s != "$user" {
+			conv.searchPath = s
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestProcessPgDump_Schemas(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name: "qualified names",
+			input: "CREATE TABLE public.users (id bigint PRIMARY KEY, name text);\n" +
+				"CREATE TABLE audit.users (id bigint PRIMARY KEY, ts date);\n" +
+				"CREATE TABLE public.audit_users (x bigint PRIMARY KEY);\n" +
+				"COPY public.users (id, name) FROM stdin;\n1\ta\n\\.\n" +
+				"COPY audit.users (id, ts) FROM stdin;\n2\t\\N\n\\.\n" +
+				"INSERT INTO audit_users (x) VALUES (3);\n" +
+				"CREATE INDEX users_ts_idx ON audit.users USING btree (ts);\n",
+		},
+		{
+			// Older versions of pg_dump use SET search_path rather
+			// than qualified names.
+			name: "search_path",
+			input: "SET search_path = public, pg_catalog;\n" +
+				"CREATE TABLE users (id bigint PRIMARY KEY, name text);\n" +
+				"CREATE TABLE audit_users (x bigint PRIMARY KEY);\n" +
+				"SET search_path = audit, pg_catalog;\n" +
+				"CREATE TABLE users (id bigint PRIMARY KEY, ts date);\n" +
+				"SET search_path = public, pg_catalog;\n" +
+				"COPY users (id, name) FROM stdin;\n1\ta\n\\.\n" +
+				"SET search_path = audit, pg_catalog;\n" +
+				"COPY users (id, ts) FROM stdin;\n2\t\\N\n\\.\n" +
+				"INSERT INTO public.audit_users (x) VALUES (3);\n" +
+				"CREATE INDEX users_ts_idx ON users USING btree (ts);\n",
+		},
+	}
+	for _, tc := range tests {
+		conv, rows := runProcessPgDump(tc.input)
+		noIssues(conv, t, tc.name)
+		assert.Equal(t, []string{"audit.users", "audit_users", "users"}, sortedSrcTables(conv), tc.name)
+		// audit.users and audit_users must not be merged.
+		assert.Equal(t, "audit_users", conv.toSpanner["audit.users"].name, tc.name)
+		assert.Equal(t, "audit_users_1", conv.toSpanner["audit_users"].name, tc.name)
+		assert.Equal(t, []string{"id", "ts"}, conv.spSchema["audit_users"].ColNames, tc.name)
+		assert.Equal(t, []ddl.CreateIndex{{Name: "users_ts_idx", Table: "audit_users", Keys: []ddl.IndexKey{{Col: "ts"}}}}, conv.spSchema["audit_users"].Indexes, tc.name)
+		assert.Equal(t, []string{"id", "name"}, conv.spSchema["users"].ColNames, tc.name)
+		assert.Equal(t, []spannerData{
+			{table: "users", cols: []string{"id", "name"}, vals: []interface{}{int64(1), "a"}},
+			{table: "audit_users", cols: []string{"id"}, vals: []interface{}{int64(2)}},
+			{table: "audit_users_1", cols: []string{"x"}, vals: []interface{}{int64(3)}},
+		}, rows, tc.name)
+
+		buf := new(bytes.Buffer)
+		w := bufio.NewWriter(buf)
+		GenerateReport(PgDumpSource, conv, w, nil)
+		w.Flush()
+		assert.Contains(t, buf.String(), "Table audit.users (mapped to Spanner table audit_users)", tc.name)
+		assert.Contains(t, buf.String(), "Table audit_users (mapped to Spanner table audit_users_1)", tc.name)
+	}
+}
+
+func TestProcessPgDump_DefaultSchema(t *testing.T) {
+	conv := MakeConv()
+	conv.SetDefaultSchema("app")
+	conv, rows := runProcessPgDumpConv(conv,
+		"CREATE TABLE app.users (id bigint PRIMARY KEY);\n"+
+			"CREATE TABLE public.users (id bigint PRIMARY KEY);\n"+
+			"COPY app.users (id) FROM stdin;\n1\n\\.\n")
+	noIssues(conv, t, "default schema")
+	assert.Equal(t, []string{"public.users", "users"}, sortedSrcTables(conv))
+	assert.Equal(t, "public_users", conv.toSpanner["public.users"].name)
+	assert.Equal(t, []spannerData{{table: "users", cols: []string{"id"}, vals: []interface{}{int64(1)}}}, rows)
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"regexp"
+
+	pg_query "github.com/lfittl/pg_query_go"
+	nodes "github.com/lfittl/pg_query_go/nodes"
+)
+
+// PostgreSQL's declaratively partitioned tables consist of a parent
+// table (CREATE TABLE ... PARTITION BY) and partitions, which are
+// either created with CREATE TABLE ... PARTITION OF, or created as
+// regular tables and then attached with ALTER TABLE ... ATTACH
+// PARTITION. Spanner splits tables automatically, so we convert each
+// partitioned table to a single Spanner table: partitions don't
+// become tables, and their data is written to the (root) partitioned
+// table.
+
+// partitions tracks the partitioned tables of the source DB.
+type partitions struct {
+	parent map[string]string   // Maps partition to its parent table.
+	keys   map[string][]string // Partition key columns of partitioned tables (expressions are omitted).
+}
+
+// addPartitioned records that table is partitioned, using spec.
+func (conv *Conv) addPartitioned(table string, spec nodes.PartitionSpec) {
+	keys := []string{}
+	for _, p := range spec.PartParams.Items {
+		if e, ok := p.(nodes.PartitionElem); ok && e.Name != nil {
+			keys = append(keys, *e.Name)
+		}
+	}
+	conv.partitions.keys[table] = keys
+}
+
+// addPartition records that table is a partition of parent. The
+// partition's columns are those of parent, so any schema recorded for
+// the partition is dropped.
+func (conv *Conv) addPartition(table, parent string) {
+	conv.partitions.parent[table] = parent
+	delete(conv.srcSchema, table)
+}
+
+// processAttachPartition handles command a of n, which attaches a
+// partition to table (ALTER TABLE ... ATTACH PARTITION).
+func processAttachPartition(conv *Conv, n nodes.AlterTableStmt, a nodes.AlterTableCmd, table string) {
+	if p, ok := a.Def.(nodes.PartitionCmd); ok && p.Name != nil {
+		if partition, err := getTableName(conv, *p.Name); err == nil {
+			conv.addPartition(partition, table)
+			conv.schemaStatement([]nodes.Node{n, a})
+			return
+		}
+	}
+	conv.skipStatement([]nodes.Node{n, a})
+}
+
+// partitionRoot returns the table that rows of source table should be
+// written to: the root of its partition hierarchy if it is a
+// partition, and table otherwise.
+func (conv *Conv) partitionRoot(table string) string {
+	for i := 0; i < len(conv.partitions.parent); i++ { // Guard against cycles.
+		parent, ok := conv.partitions.parent[table]
+		if !ok {
+			break
+		}
+		table = parent
+	}
+	return table
+}
+
+// partitionIssue returns a note on the partitions merged into table,
+// and false if table isn't partitioned.
+func (conv *Conv) partitionIssue(table string) (groupIssue, bool) {
+	keys, ok := conv.partitions.keys[table]
+	if !ok {
+		return groupIssue{}, false
+	}
+	n := 0
+	for p := range conv.partitions.parent {
+		if _, partitioned := conv.partitions.keys[p]; !partitioned && conv.partitionRoot(p) == table {
+			n++
+		}
+	}
+	detail := fmt.Sprintf("%d partitions", n)
+	if n == 1 {
+		detail = "1 partition"
+	}
+	return groupIssue{issue: partitioned, cols: keys, construct: "partition key", detail: detail}, true
+}
+
+// Several partitioning statements that pg_dump generates for
+// PostgreSQL 11 and later aren't supported by the parser we use:
+// DEFAULT and hash partition bounds, CREATE INDEX ... ON ONLY, and
+// ALTER INDEX ... ATTACH PARTITION. We ignore partition bounds and
+// the indexes of partitions, so we rewrite these statements into a
+// form the parser accepts (or drop them).
+var partitionRewrites = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?is)\bALTER\s+INDEX\s[^;]*\bATTACH\s+PARTITION\s[^;]*;`), ""},
+	{regexp.MustCompile(`(?is)(\bPARTITION\s+OF\s[^;]*?|\bATTACH\s+PARTITION\s+\S+\s+)\bDEFAULT(\s*(?:;|\bPARTITION\s+BY\b))`), "${1}FOR VALUES IN (NULL)${2}"},
+	{regexp.MustCompile(`(?is)\bFOR\s+VALUES\s+WITH\s*\([^)]*\)`), "FOR VALUES IN (NULL)"},
+	{regexp.MustCompile(`(?is)(\bCREATE\s+(?:UNIQUE\s+)?INDEX\s[^;]*?\bON\s+)ONLY\s+`), "${1}"},
+}
+
+// parsePartitioning parses s if it contains partitioning statements
+// that need rewriting (see partitionRewrites). Returns false if s
+// can't be parsed this way.
+func parsePartitioning(s string) ([]nodes.Node, bool) {
+	r := s
+	for _, pr := range partitionRewrites {
+		r = pr.re.ReplaceAllString(r, pr.repl)
+	}
+	if r == s {
+		return nil, false
+	}
+	tree, err := pg_query.Parse(r)
+	if err != nil {
+		return parseIndexInclude(r)
+	}
+	return tree.Statements, true
+}
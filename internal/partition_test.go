@@ -0,0 +1,98 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestProcessPgDump_Partitions(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			// pg_dump of PostgreSQL 10.
+			name: "partition of",
+			input: "CREATE TABLE public.m (id bigint NOT NULL, d date NOT NULL, v text) PARTITION BY RANGE (d);\n" +
+				"CREATE TABLE public.m_2020 PARTITION OF public.m FOR VALUES FROM ('2020-01-01') TO ('2021-01-01');\n" +
+				"CREATE TABLE public.m_2021 PARTITION OF public.m (v NOT NULL) FOR VALUES FROM ('2021-01-01') TO ('2022-01-01') PARTITION BY LIST (v);\n" +
+				"CREATE TABLE public.m_2021_a PARTITION OF public.m_2021 FOR VALUES IN ('a');\n" +
+				"CREATE TABLE public.m_2021_b PARTITION OF public.m_2021 DEFAULT;\n" +
+				"COPY public.m_2020 (id, d, v) FROM stdin;\n1\t2020-02-03\ta\n\\.\n" +
+				"COPY public.m_2021_a (id, d, v) FROM stdin;\n2\t2021-02-03\ta\n\\.\n" +
+				"INSERT INTO public.m_2021_b (id, d, v) VALUES (3, '2021-03-04', 'b');\n" +
+				"ALTER TABLE ONLY public.m ADD CONSTRAINT m_pkey PRIMARY KEY (id, d);\n" +
+				"ALTER TABLE ONLY public.m_2020 ADD CONSTRAINT m_2020_pkey PRIMARY KEY (id, d);\n" +
+				"CREATE INDEX m_v_idx ON public.m_2020 USING btree (v);\n",
+		},
+		{
+			// pg_dump of PostgreSQL 12 and later.
+			name: "attach partition",
+			input: "CREATE TABLE public.m (id bigint NOT NULL, d date NOT NULL, v text) PARTITION BY RANGE (d);\n" +
+				"CREATE TABLE public.m_2020 (id bigint NOT NULL, d date NOT NULL, v text);\n" +
+				"ALTER TABLE ONLY public.m ATTACH PARTITION public.m_2020 FOR VALUES FROM ('2020-01-01') TO ('2021-01-01');\n" +
+				"CREATE TABLE public.m_2021 (id bigint NOT NULL, d date NOT NULL, v text NOT NULL) PARTITION BY HASH (v);\n" +
+				"ALTER TABLE ONLY public.m ATTACH PARTITION public.m_2021 FOR VALUES FROM ('2021-01-01') TO ('2022-01-01');\n" +
+				"CREATE TABLE public.m_2021_a (id bigint NOT NULL, d date NOT NULL, v text NOT NULL);\n" +
+				"ALTER TABLE ONLY public.m_2021 ATTACH PARTITION public.m_2021_a FOR VALUES WITH (modulus 2, remainder 0);\n" +
+				"CREATE TABLE public.m_2021_b (id bigint NOT NULL, d date NOT NULL, v text NOT NULL);\n" +
+				"ALTER TABLE ONLY public.m_2021 ATTACH PARTITION public.m_2021_b DEFAULT;\n" +
+				"COPY public.m_2020 (id, d, v) FROM stdin;\n1\t2020-02-03\ta\n\\.\n" +
+				"COPY public.m_2021_a (id, d, v) FROM stdin;\n2\t2021-02-03\ta\n\\.\n" +
+				"COPY public.m_2021_b (id, d, v) FROM stdin;\n3\t2021-03-04\tb\n\\.\n" +
+				"ALTER TABLE ONLY public.m ADD CONSTRAINT m_pkey PRIMARY KEY (id, d);\n" +
+				"ALTER TABLE ONLY public.m_2020 ADD CONSTRAINT m_2020_pkey PRIMARY KEY (id, d);\n" +
+				"CREATE INDEX m_v_idx ON ONLY public.m USING btree (v);\n" +
+				"CREATE INDEX m_2020_v_idx ON public.m_2020 USING btree (v);\n" +
+				"ALTER INDEX public.m_v_idx ATTACH PARTITION public.m_2020_v_idx;\n" +
+				"ALTER INDEX public.m_pkey ATTACH PARTITION public.m_2020_pkey;\n",
+		},
+	}
+	for _, tc := range tests {
+		conv, rows := runProcessPgDump(tc.input)
+		noIssues(conv, t, tc.name)
+		assert.Equal(t, []string{"m"}, sortedSrcTables(conv), tc.name)
+		ct := stripSchemaComments(conv.spSchema)["m"]
+		assert.Equal(t, []string{"id", "d", "v"}, ct.ColNames, tc.name)
+		assert.Equal(t, []ddl.IndexKey{{Col: "id"}, {Col: "d"}}, ct.Pks, tc.name)
+		assert.Equal(t, int64(3), conv.stats.rows["m"], tc.name)
+		assert.Equal(t, []spannerData{
+			{table: "m", cols: []string{"id", "d", "v"}, vals: []interface{}{int64(1), civil.Date{Year: 2020, Month: 2, Day: 3}, "a"}},
+			{table: "m", cols: []string{"id", "d", "v"}, vals: []interface{}{int64(2), civil.Date{Year: 2021, Month: 2, Day: 3}, "a"}},
+			{table: "m", cols: []string{"id", "d", "v"}, vals: []interface{}{int64(3), civil.Date{Year: 2021, Month: 3, Day: 4}, "b"}},
+		}, rows, tc.name)
+		assert.Equal(t, []groupIssue{{issue: partitioned, cols: []string{"d"}, construct: "partition key", detail: "3 partitions"}}, conv.groupIssues["m"], tc.name)
+		assert.Empty(t, conv.dropped, tc.name)
+
+		buf := new(bytes.Buffer)
+		w := bufio.NewWriter(buf)
+		GenerateReport(PgDumpSource, conv, w, nil)
+		w.Flush()
+		assert.Contains(t, normalizeSpace(buf.String()), "Table was partitioned in the source DB: its 3 partitions were merged into this table (partition key: d). Spanner splits tables", tc.name)
+	}
+	conv, _ := runProcessPgDump("CREATE TABLE public.m (id bigint PRIMARY KEY, d date NOT NULL, v text) PARTITION BY RANGE (d);\n" +
+		"CREATE INDEX m_v_idx ON ONLY public.m USING btree (v) INCLUDE (d);\n")
+	noIssues(conv, t, "index on only with include")
+	assert.Equal(t, 1, len(conv.spSchema["m"].Indexes))
+	assert.Equal(t, []string{"d"}, conv.spSchema["m"].Indexes[0].Storing)
+}
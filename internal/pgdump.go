@@ -17,6 +17,7 @@ package internal
 import (
 	"fmt"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -31,7 +32,18 @@ type copyOrInsert struct {
 	stmt  stmtType
 	table string
 	cols  []string
-	vals  []string // Empty for COPY-FROM.
+	rows  []insertRow // Empty for COPY-FROM.
+	extra []string    // Values appended to each row of COPY-FROM (see addDiscriminator).
+	name  string      // COPY-FROM table name as given in the dump (see dupcopy.go).
+	dup   bool        // COPY-FROM block is a duplicate (see Conv.duplicateCopy).
+}
+
+// insertRow is a row of an INSERT statement. Columns set to DEFAULT
+// are omitted, so rows of the same statement can have different cols.
+type insertRow struct {
+	cols  []string
+	vals  []string
+	nulls []bool // See processDataRow.
 }
 
 type stmtType int
@@ -47,28 +59,49 @@ const (
 // In data mode, ProcessPgDump uses this schema to convert PostgreSQL data
 // and writes it to Spanner, using the data sink specified in conv.
 func ProcessPgDump(conv *Conv, r *Reader) error {
+	conv.searchPath = "" // Each pass starts with the default search_path.
+	conv.dumpSettings = dumpSettings{}
+	if conv.dumpFiles.current == 0 {
+		// COPY-FROM blocks are tracked across the files of a pass
+		// (see dumpfiles.go).
+		conv.copiedTables = nil
+	}
 	for {
 		startLine := r.LineNumber
 		startOffset := r.Offset
+		start := conv.now()
 		b, stmts, err := readAndParseChunk(conv, r)
 		if err != nil {
 			return err
 		}
-		ci := processStatements(conv, stmts)
-		VerbosePrintf("Parsed SQL command at line=%d/fpos=%d: %d stmts (%d lines, %d bytes) ci=%v\n", startLine, startOffset, len(stmts), r.LineNumber-startLine, len(b), ci != nil)
-		if ci != nil {
+		conv.setDumpContext(int64(startOffset-1), string(b))
+		cis := processStatements(conv, string(b), stmts)
+		VerbosePrintf("Parsed SQL command at line=%d/fpos=%d: %d stmts (%d lines, %d bytes) cis=%d\n", startLine, startOffset, len(stmts), r.LineNumber-startLine, len(b), len(cis))
+		for _, ci := range cis {
 			switch ci.stmt {
 			case copyFrom:
-				processCopyBlock(conv, ci.table, ci.cols, r)
+				processCopyBlock(conv, ci, r)
 			case insert:
-				ProcessDataRow(conv, ci.table, ci.cols, ci.vals)
+				for _, row := range ci.rows {
+					processDataRow(conv, ci.table, row.cols, row.vals, row.nulls)
+				}
 			}
 		}
+		if len(cis) > 0 {
+			// Attribute the chunk to its last table: a COPY-FROM
+			// (whose data dominates) is always last.
+			conv.statsAddTiming(cis[len(cis)-1].table, conv.now().Sub(start), int64(r.Offset-startOffset))
+		}
 		if r.EOF {
 			break
 		}
 	}
-	if conv.schemaMode() {
+	conv.clearDumpContext()
+	if conv.dataMode() && !r.Stopped() {
+		conv.tableRead()
+	}
+	if conv.schemaMode() && !conv.severalDumpFiles() { // Otherwise, see FinishDumpFiles.
+		conv.mergeInherited()
 		schemaToDDL(conv)
 		conv.AddPrimaryKeys()
 	}
@@ -80,12 +113,16 @@ func ProcessPgDump(conv *Conv, r *Reader) error {
 // the parsed AST (nil if nothing read), and whether we've hit end-of-file.
 func readAndParseChunk(conv *Conv, r *Reader) ([]byte, []nodes.Node, error) {
 	var l [][]byte
+	sc := stmtScanner{escapes: conv.dumpSettings.escapeStrings}
 	for {
 		b := r.ReadLine()
+		if r.Stopped() {
+			return nil, nil, nil
+		}
 		l = append(l, b)
-		// If we see a semicolon or eof, we're likely to have a command, so try to parse it.
-		// Note: we could just parse every iteration, but that would mean more attempts at parsing.
-		if strings.Contains(string(b), ";") || r.EOF {
+		// If we've reached the end of a statement or eof, try to parse
+		// what we have.
+		if sc.scan(b) || r.EOF {
 			n := 0
 			for i := range l {
 				n += len(l[i])
@@ -95,14 +132,20 @@ func readAndParseChunk(conv *Conv, r *Reader) ([]byte, []nodes.Node, error) {
 			for i := range l {
 				n += copy(s[n:], l[i])
 			}
+			s = conv.readDumpText(s)
 			tree, err := pg_query.Parse(string(s))
 			if err == nil {
 				return s, tree.Statements, nil
 			}
-			// Likely causes of failing to parse:
-			// a) complex statements with embedded semicolons e.g. 'CREATE FUNCTION'
-			// b) a semicolon embedded in a multi-line comment, or
-			// c) a semicolon embedded a string constant or column/table name.
+			if stmts, ok := parseIndexInclude(string(s)); ok {
+				return s, stmts, nil
+			}
+			if stmts, ok := parsePartitioning(string(s)); ok {
+				return s, stmts, nil
+			}
+			// stmtScanner skips semicolons in strings, comments and
+			// function bodies, so this is rare: the statement may be
+			// one we can't parse, or the scanner may have misread it.
 			// We deal with this case by reading another line and trying again.
 			conv.stats.reparsed++
 		}
@@ -112,77 +155,135 @@ func readAndParseChunk(conv *Conv, r *Reader) ([]byte, []nodes.Node, error) {
 	}
 }
 
-func processCopyBlock(conv *Conv, srcTable string, srcCols []string, r *Reader) {
+func processCopyBlock(conv *Conv, ci *copyOrInsert, r *Reader) {
 	VerbosePrintf("Parsing COPY-FROM stdin block starting at line=%d/fpos=%d\n", r.LineNumber, r.Offset)
+	srcTable := ci.table
+	skip := ci.dup && conv.skipDuplicateCopies()
+	n := int64(0)
 	for {
+		offset := r.Offset - 1
 		b := r.ReadLine()
 		if string(b) == "\\.\n" || string(b) == "\\.\r\n" {
 			VerbosePrintf("Parsed COPY-FROM stdin block ending at line=%d/fpos=%d\n", r.LineNumber, r.Offset)
+			if ci.dup {
+				conv.addDuplicateCopy(ci.name, srcTable, n)
+			}
+			conv.copyDone(ci.name)
 			return
 		}
 		if r.EOF {
-			conv.unexpected("Reached eof while parsing copy-block")
+			if !r.Stopped() {
+				conv.unexpected("Reached eof while parsing copy-block")
+			}
 			return
 		}
+		for continuesRow(b) && !r.EOF {
+			b = append(b, r.ReadLine()...)
+		}
+		if r.Stopped() {
+			return // The row may be incomplete.
+		}
+		n++
+		if skip {
+			// Rows of duplicate blocks are only counted as such.
+			continue
+		}
 		conv.statsAddRow(srcTable, conv.schemaMode())
 		// We have to read the copy-block data so that we can process the remaining
 		// pg_dump content. However, if we don't want the data, stop here.
-		// In particular, avoid the strings.Split and ProcessDataRow calls below, which
+		// In particular, avoid the decodeCopyRow and processDataRow calls below, which
 		// will be expensive for huge datasets.
 		if !conv.dataMode() {
 			continue
 		}
-		// Pgdump escapes backslash in copy-block statements. For example:
-		// a) a\"b becomes a\\"b in COPY-BLOCK (but 'a\"b' in INSERT-INTO)
-		// b) {"a\"b"} becomes {"a\\"b"} in COPY-BLOCK (but '{"a\"b"}' in INSERT-INTO)
-		// Note: a'b and {a'b} are unchanged in COPY-BLOCK and INSERT-INTO.
-		s := strings.ReplaceAll(string(b), `\\`, `\`)
-		// COPY-FROM blocks use tabs to separate data items. Note that space within data
-		// items is significant e.g. if a table row contains data items "a ", " b "
-		// it will be shown in the COPY-FROM block as "a \t b ".
-		ProcessDataRow(conv, srcTable, srcCols, strings.Split(strings.Trim(s, "\r\n"), "\t"))
+		// Note that space within data items is significant e.g. if a table
+		// row contains data items "a ", " b " it will be shown in the
+		// COPY-FROM block as "a \t b ".
+		row := string(b)
+		conv.setDumpContext(int64(offset), row)
+		vals, nulls := decodeCopyRow(conv.decodeDump(row))
+		processDataRow(conv, srcTable, ci.cols, append(vals, ci.extra...), nulls)
 	}
 }
 
 // processStatements extracts schema information and data from PostgreSQL
 // statements, updating Conv with new schema information, and returning
-// copyOrInsert if a COPY-FROM or INSERT statement is encountered.
+// a copyOrInsert for each COPY-FROM or INSERT statement encountered.
 // Note that the actual parsing/processing of COPY-FROM data blocks is
-// handled elsewhere (see process.go).
-func processStatements(conv *Conv, statements []nodes.Node) *copyOrInsert {
+// handled elsewhere (see process.go). s is the text the statements were
+// parsed from.
+func processStatements(conv *Conv, s string, statements []nodes.Node) (cis []*copyOrInsert) {
+	chunk := conv.dumpContext
 	// Typically we'll have only one statement, but we handle the general case.
 	for i, node := range statements {
+		var sql string
 		switch n := node.(type) {
 		// Unwrap RawStatement.
 		case nodes.RawStmt:
 			node = n.Stmt
+			sql = stmtText(s, n)
+			if chunk.known && sql != "" {
+				t := strings.TrimLeft(sql, " \t\r\n")
+				conv.setDumpContext(chunk.offset+int64(n.StmtLocation+len(sql)-len(t)), t)
+			}
 		}
 		switch n := node.(type) {
 		case nodes.AlterTableStmt:
 			if conv.schemaMode() {
 				processAlterTableStmt(conv, n)
 			}
+		case nodes.CommentStmt:
+			if conv.schemaMode() {
+				processCommentStmt(conv, n)
+			}
 		case nodes.CopyStmt:
 			if i != len(statements)-1 {
 				conv.unexpected("CopyFrom is not the last statement in batch: ignoring following statements")
 				conv.errorInStatement([]nodes.Node{node})
 			}
-			return processCopyStmt(conv, n)
+			return append(cis, processCopyStmt(conv, n))
+		case nodes.CreateDomainStmt:
+			if conv.schemaMode() {
+				processCreateDomainStmt(conv, n)
+			}
+		case nodes.CreateEnumStmt:
+			if conv.schemaMode() {
+				processCreateEnumStmt(conv, n)
+			}
 		case nodes.CreateStmt:
 			if conv.schemaMode() {
 				processCreateStmt(conv, n)
 			}
+		case nodes.DropStmt:
+			if conv.schemaMode() && n.RemoveType == nodes.OBJECT_TABLE {
+				processDropTable(conv, n)
+			} else {
+				conv.skipStatement([]nodes.Node{node})
+			}
+		case nodes.IndexStmt:
+			if conv.schemaMode() {
+				processIndexStmt(conv, n, sql)
+			}
 		case nodes.InsertStmt:
-			return processInsertStmt(conv, n)
+			if ci := processInsertStmt(conv, n); ci != nil {
+				cis = append(cis, ci)
+			}
 		case nodes.VariableSetStmt:
-			if conv.schemaMode() {
+			if n.Name != nil && *n.Name == "search_path" {
+				// Needed in both passes, to resolve table names.
+				processSearchPath(conv, n)
+			} else if n.Name != nil && (*n.Name == "client_encoding" || *n.Name == "standard_conforming_strings") {
+				// Needed in both passes, to read the rest of the dump.
+				processDumpSetting(conv, n)
+			} else if conv.schemaMode() {
 				processVariableSetStmt(conv, n)
 			}
 		default:
+			processDroppedStmt(conv, node, sql)
 			conv.skipStatement([]nodes.Node{node})
 		}
 	}
-	return nil
+	return cis
 }
 
 func processAlterTableStmt(conv *Conv, n nodes.AlterTableStmt) {
@@ -200,10 +301,23 @@ func processAlterTableStmt(conv *Conv, n nodes.AlterTableStmt) {
 			switch a := i.(type) {
 			case nodes.AlterTableCmd:
 				switch {
-				case a.Subtype == nodes.AT_SetNotNull && a.Name != nil:
-					c := constraint{ct: nodes.CONSTR_NOTNULL, cols: []string{*a.Name}}
+				case (a.Subtype == nodes.AT_SetNotNull || a.Subtype == nodes.AT_DropNotNull || a.Subtype == nodes.AT_AlterColumnType) && a.Name != nil:
+					processAlterColumn(conv, n, a, table)
+				case a.Subtype == nodes.AT_ColumnDefault && a.Name != nil && a.Def != nil:
+					// pg_dump sets the defaults of serial columns this way.
+					c := constraint{ct: nodes.CONSTR_DEFAULT, cols: []string{*a.Name}}
+					c.sequence, c.now = defaultKind(a.Def)
 					updateSchema(conv, table, []constraint{c}, "ALTER TABLE")
 					conv.schemaStatement([]nodes.Node{n, a})
+				case a.Subtype == nodes.AT_AddIdentity && a.Name != nil:
+					// pg_dump adds identity columns this way.
+					c := constraint{ct: nodes.CONSTR_IDENTITY, cols: []string{*a.Name}}
+					updateSchema(conv, table, []constraint{c}, "ALTER TABLE")
+					conv.schemaStatement([]nodes.Node{n, a})
+				case a.Subtype == nodes.AT_AddColumn && a.Def != nil:
+					processAddColumn(conv, n, a, table)
+				case a.Subtype == nodes.AT_AttachPartition:
+					processAttachPartition(conv, n, a, table)
 				case a.Subtype == nodes.AT_AddConstraint && a.Def != nil:
 					switch d := a.Def.(type) {
 					case nodes.Constraint:
@@ -219,6 +333,16 @@ func processAlterTableStmt(conv *Conv, n nodes.AlterTableStmt) {
 				conv.skipStatement([]nodes.Node{n, a})
 			}
 		}
+	} else if _, ok := conv.partitions.keys[table]; ok {
+		// A partitioned table that is itself a partition: we only
+		// track its partitions.
+		for _, i := range n.Cmds.Items {
+			if a, ok := i.(nodes.AlterTableCmd); ok && a.Subtype == nodes.AT_AttachPartition {
+				processAttachPartition(conv, n, a, table)
+			} else {
+				conv.skipStatement([]nodes.Node{n, i})
+			}
+		}
 	} else {
 		// In PostgreSQL, AlterTable statements can be applied to views,
 		// sequences and indexes in addition to tables. Since we only
@@ -230,6 +354,111 @@ func processAlterTableStmt(conv *Conv, n nodes.AlterTableStmt) {
 	}
 }
 
+// processAddColumn handles ALTER TABLE ... ADD COLUMN, appending the
+// column to the existing definition of table.
+func processAddColumn(conv *Conv, n nodes.AlterTableStmt, a nodes.AlterTableCmd, table string) {
+	d, ok := a.Def.(nodes.ColumnDef)
+	if !ok {
+		conv.skipStatement([]nodes.Node{n, a, a.Def})
+		return
+	}
+	name, col, constraints, err := processColumn(conv, d, table)
+	if err != nil {
+		logStmtError(conv, n, err)
+		return
+	}
+	t := conv.srcSchema[table]
+	if _, ok := t.ColDefs[name]; ok {
+		if !a.MissingOk {
+			conv.unexpected(fmt.Sprintf("ALTER TABLE adds column %s to table %s, but it already exists", name, table))
+		}
+		conv.skipStatement([]nodes.Node{n, a})
+		return
+	}
+	t.ColNames = append(t.ColNames, name)
+	t.ColDefs[name] = col
+	conv.srcSchema[table] = t
+	updateSchema(conv, table, constraints, "ALTER TABLE")
+	conv.schemaStatement([]nodes.Node{n, a, d})
+}
+
+// processAlterColumn handles ALTER TABLE ... ALTER COLUMN changes to the
+// type or nullability of a column of table. Hand-written migration
+// files make these changes after CREATE TABLE, and sometimes even after
+// the table's data. Since all data is converted with the final schema,
+// we report the latter.
+func processAlterColumn(conv *Conv, n nodes.AlterTableStmt, a nodes.AlterTableCmd, table string) {
+	name := *a.Name
+	t := conv.srcSchema[table]
+	col, ok := t.ColDefs[name]
+	if !ok {
+		conv.unexpected(fmt.Sprintf("ALTER TABLE alters column %s of table %s, but it doesn't exist", name, table))
+		conv.skipStatement([]nodes.Node{n, a})
+		return
+	}
+	var change string
+	var constraints []constraint
+	switch a.Subtype {
+	case nodes.AT_SetNotNull:
+		change = "sets NOT NULL on"
+		constraints = []constraint{{ct: nodes.CONSTR_NOTNULL, cols: []string{name}}}
+	case nodes.AT_DropNotNull:
+		change = "drops NOT NULL from"
+		col.NotNull = false
+	case nodes.AT_AlterColumnType:
+		d, ok := a.Def.(nodes.ColumnDef)
+		if !ok || d.TypeName == nil {
+			conv.skipStatement([]nodes.Node{n, a, a.Def})
+			return
+		}
+		change = "changes the type of"
+		d.Colname = a.Name
+		_, c, cs, err := processColumn(conv, d, table)
+		if err != nil {
+			logStmtError(conv, n, err)
+			return
+		}
+		// The type mapping is re-run when the schema is converted (see
+		// schemaToDDL), so we only have to replace the source type.
+		col.Type, col.Domain = c.Type, c.Domain
+		constraints = cs
+	}
+	t.ColDefs[name] = col
+	conv.srcSchema[table] = t
+	updateSchema(conv, table, constraints, "ALTER TABLE")
+	if conv.stats.rows[table] > 0 {
+		conv.unexpected(fmt.Sprintf("ALTER TABLE %s column %s of table %s after the table's data: all of its data is converted as altered", change, name, table))
+	}
+	conv.schemaStatement([]nodes.Node{n, a})
+}
+
+// processDropTable handles DROP TABLE, discarding the definitions of the
+// tables it drops (see droptable.go). Tables that aren't defined, as
+// with pg_dump --clean, are ignored.
+func processDropTable(conv *Conv, n nodes.DropStmt) {
+	dropped := false
+	for _, o := range n.Objects.Items {
+		l, ok := o.(nodes.List)
+		if !ok {
+			conv.unexpected(fmt.Sprintf("Found %s node while processing DropStmt Objects", prNodeType(o)))
+			continue
+		}
+		table, err := getListName(conv, l)
+		if err != nil {
+			logStmtError(conv, n, fmt.Errorf("can't get table name: %w", err))
+			return
+		}
+		if conv.dropTable(table) {
+			dropped = true
+		}
+	}
+	if dropped {
+		conv.schemaStatement([]nodes.Node{n})
+	} else {
+		conv.skipStatement([]nodes.Node{n})
+	}
+}
+
 func processCreateStmt(conv *Conv, n nodes.CreateStmt) {
 	var colNames []string
 	colDef := make(map[string]schema.Column)
@@ -242,6 +471,32 @@ func processCreateStmt(conv *Conv, n nodes.CreateStmt) {
 		logStmtError(conv, n, fmt.Errorf("can't get table name: %w", err))
 		return
 	}
+	if conv.duplicateTable(table) {
+		conv.skipStatement([]nodes.Node{n})
+		return
+	}
+	if n.Partspec != nil {
+		conv.addPartitioned(table, *n.Partspec)
+	}
+	var parents []string
+	for _, i := range n.InhRelations.Items {
+		if r, ok := i.(nodes.RangeVar); ok {
+			if parent, err := getTableName(conv, r); err == nil {
+				parents = append(parents, parent)
+			}
+		}
+	}
+	if n.Partbound != nil && len(n.InhRelations.Items) == 1 {
+		// CREATE TABLE ... PARTITION OF: the partition has the columns
+		// of its parent, so its TableElts only add constraints.
+		if r, ok := n.InhRelations.Items[0].(nodes.RangeVar); ok {
+			if parent, err := getTableName(conv, r); err == nil {
+				conv.addPartition(table, parent)
+				conv.schemaStatement([]nodes.Node{n})
+				return
+			}
+		}
+	}
 	var constraints []constraint
 	for _, te := range n.TableElts.Items {
 		switch i := te.(type) {
@@ -258,11 +513,14 @@ func processCreateStmt(conv *Conv, n nodes.CreateStmt) {
 			// Note: there should be at most one Constraint node in
 			// n.TableElts.Items. We don't check this. We just keep
 			// collecting constraints.
-			constraints = extractConstraints(conv, n, table, []nodes.Node{i})
+			constraints = append(constraints, extractConstraints(conv, n, table, []nodes.Node{i})...)
 		default:
 			conv.unexpected(fmt.Sprintf("Found %s node while processing CreateStmt TableElts", prNodeType(i)))
 		}
 	}
+	if n.Partbound == nil && len(parents) > 0 {
+		colNames = conv.inheritColumns(table, parents, colNames, colDef)
+	}
 	conv.schemaStatement([]nodes.Node{n})
 	conv.srcSchema[table] = schema.Table{
 		Name:     table,
@@ -286,7 +544,169 @@ func processColumn(conv *Conv, n nodes.ColumnDef, table string) (string, schema.
 		Name:        tid,
 		Mods:        mods,
 		ArrayBounds: getArrayBounds(conv, n.TypeName.ArrayBounds)}
-	return name, schema.Column{Name: name, Type: ty}, analyzeColDefConstraints(conv, n, table, n.Constraints.Items, name), nil
+	col := schema.Column{Name: name, Type: ty}
+	var constraints []constraint
+	if d, ok := conv.domain(tid); ok {
+		col.Type = resolveDomain(d, ty)
+		col.Domain = tid
+		for _, c := range d.constraints {
+			c.cols = []string{name}
+			constraints = append(constraints, c)
+		}
+	}
+	constraints = append(constraints, analyzeColDefConstraints(conv, n, table, n.Constraints.Items, name)...)
+	return name, col, constraints, nil
+}
+
+func processIndexStmt(conv *Conv, n nodes.IndexStmt, sql string) {
+	if n.Relation == nil {
+		logStmtError(conv, n, fmt.Errorf("relation is nil"))
+		return
+	}
+	table, err := getTableName(conv, *n.Relation)
+	if err != nil {
+		logStmtError(conv, n, fmt.Errorf("can't get table name: %w", err))
+		return
+	}
+	if _, ok := conv.partitions.parent[table]; ok {
+		// Indexes of partitions are partitions of an index on the
+		// partitioned table (which we convert).
+		conv.skipStatement([]nodes.Node{n})
+		return
+	}
+	ct, ok := conv.srcSchema[table]
+	if !ok {
+		// Indexes can also be created on materialized views, which we
+		// don't track.
+		var name string
+		if n.Idxname != nil {
+			name = *n.Idxname
+		}
+		conv.addDroppedObject("index", name, []string{table}, sql, "its table wasn't found (it may be a materialized view)")
+		conv.skipStatement([]nodes.Node{n})
+		VerbosePrintf("Processing %v statement: table %s not found", reflect.TypeOf(n), table)
+		return
+	}
+	index := schema.Index{Unique: n.Unique}
+	if n.AccessMethod != nil {
+		index.Ignored.Method = unsupportedIndexMethod(*n.AccessMethod)
+	}
+	var cols []string
+	for _, i := range n.IndexParams.Items {
+		switch e := i.(type) {
+		case nodes.IndexElem:
+			if e.Name == nil {
+				index.Ignored.Expression = true
+				continue
+			}
+			cols = append(cols, *e.Name)
+			index.Keys = append(index.Keys, schema.Key{Column: *e.Name, Desc: e.Ordering == nodes.SORTBY_DESC})
+		default:
+			conv.unexpected(fmt.Sprintf("Found %s node while processing IndexStmt IndexParams", prNodeType(i)))
+		}
+	}
+	index.Ignored.Predicate = n.WhereClause != nil
+	index.Storing = getIndexInclude(conv, n)
+	if n.Idxname != nil {
+		index.Name = *n.Idxname
+	} else {
+		index.Name = defaultIndexName(table, cols, "idx")
+	}
+	ct.Indexes = append(ct.Indexes, index)
+	conv.srcSchema[table] = ct
+	if conv.indexSQL[table] == nil {
+		conv.indexSQL[table] = make(map[string]string)
+	}
+	conv.indexSQL[table][index.Name] = sql
+	conv.schemaStatement([]nodes.Node{n})
+}
+
+// unsupportedIndexMethod returns m if PostgreSQL index access method m
+// can't be converted to a Spanner index, and the empty string otherwise.
+// Spanner indexes support equality and range lookups (like btree), but
+// not the specialized operators of methods such as gin and gist.
+func unsupportedIndexMethod(m string) string {
+	switch m {
+	case "btree", "hash":
+		return ""
+	}
+	return m
+}
+
+// The INCLUDE clause of CREATE INDEX was added in PostgreSQL 11, and
+// isn't supported by the parser we use. We handle it by parsing the
+// statement without the clause, and then adding the included columns
+// to the options of the parsed IndexStmt, using a namespace that
+// can't clash with real index options (see getIndexInclude).
+var indexIncludeRegexp = regexp.MustCompile(`(?is)^(.*\bCREATE\s+(?:UNIQUE\s+)?INDEX\s.*\))\s*INCLUDE\s*\(([^)]*)\)(.*)$`)
+
+const indexIncludeNamespace = "harbourbridge"
+
+// parseIndexInclude parses s if it is a CREATE INDEX statement with an
+// INCLUDE clause. Returns false if s can't be parsed this way.
+func parseIndexInclude(s string) ([]nodes.Node, bool) {
+	m := indexIncludeRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	tree, err := pg_query.Parse(m[1] + m[3])
+	if err != nil || len(tree.Statements) != 1 {
+		return nil, false
+	}
+	raw, ok := tree.Statements[0].(nodes.RawStmt)
+	if !ok {
+		return nil, false
+	}
+	n, ok := raw.Stmt.(nodes.IndexStmt)
+	if !ok {
+		return nil, false
+	}
+	var cols []nodes.Node
+	for _, c := range strings.Split(m[2], ",") {
+		cols = append(cols, nodes.String{Str: unquoteIdent(strings.TrimSpace(c))})
+	}
+	namespace, name := indexIncludeNamespace, "include"
+	n.Options.Items = append(n.Options.Items, nodes.DefElem{Defnamespace: &namespace, Defname: &name, Arg: nodes.List{Items: cols}})
+	raw.Stmt = n
+	return []nodes.Node{raw}, true
+}
+
+// getIndexInclude returns the INCLUDE columns of index n, as recorded
+// by parseIndexInclude.
+func getIndexInclude(conv *Conv, n nodes.IndexStmt) (cols []string) {
+	for _, o := range n.Options.Items {
+		d, ok := o.(nodes.DefElem)
+		if !ok || d.Defnamespace == nil || *d.Defnamespace != indexIncludeNamespace {
+			continue
+		}
+		if l, ok := d.Arg.(nodes.List); ok {
+			for _, i := range l.Items {
+				c, err := getString(i)
+				if err != nil {
+					conv.unexpected(fmt.Sprintf("Processing %v statement: error processing INCLUDE: %s", reflect.TypeOf(n), err))
+					continue
+				}
+				cols = append(cols, c)
+			}
+		}
+	}
+	return cols
+}
+
+// unquoteIdent returns the name of PostgreSQL identifier s. Quoted
+// identifiers are case-sensitive, whereas unquoted identifiers are
+// folded to lower case.
+func unquoteIdent(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+	}
+	return strings.ToLower(s)
+}
+
+// defaultIndexName returns the name PostgreSQL uses for an index or
+// constraint that isn't explicitly named e.g. "users_email_key".
+func defaultIndexName(table string, cols []string, suffix string) string {
+	return strings.Join(append(append([]string{table}, cols...), suffix), "_")
 }
 
 func processInsertStmt(conv *Conv, n nodes.InsertStmt) *copyOrInsert {
@@ -299,23 +719,47 @@ func processInsertStmt(conv *Conv, n nodes.InsertStmt) *copyOrInsert {
 		logStmtError(conv, n, fmt.Errorf("can't get table name: %w", err))
 		return nil
 	}
-	conv.statsAddRow(table, conv.schemaMode())
-	colNames, err := getCols(conv, table, n.Cols.Items)
+	srcTable := conv.partitionRoot(table)
+	table, disc := conv.mergeTarget(srcTable)
+	sel, ok := n.SelectStmt.(nodes.SelectStmt)
+	if !ok {
+		conv.statsAddRow(table, conv.schemaMode())
+		conv.unexpected(fmt.Sprintf("Found %s node while processing InsertStmt SelectStmt", prNodeType(n.SelectStmt)))
+		return nil
+	}
+	nrows := int64(len(sel.ValuesLists))
+	if nrows == 0 {
+		// INSERT ... SELECT: we count it as one (bad) row.
+		conv.statsAddRow(table, conv.schemaMode())
+		conv.statsAddBadRow(table, conv.schemaMode())
+		conv.unexpected(fmt.Sprintf("Processing %v statement: INSERT without VALUES", reflect.TypeOf(n)))
+		return nil
+	}
+	if conv.schemaMode() {
+		conv.statsAddRows(table, nrows)
+	}
+	colNames, err := getCols(conv, srcTable, n.Cols.Items)
 	if err != nil {
 		logStmtError(conv, n, fmt.Errorf("can't get col name: %w", err))
-		conv.statsAddBadRow(table, conv.schemaMode())
+		if conv.schemaMode() {
+			conv.statsAddBadRows(table, nrows)
+		}
 		return nil
 	}
-	var values []string
-	switch sel := n.SelectStmt.(type) {
-	case nodes.SelectStmt:
-		values = getVals(conv, sel.ValuesLists, n)
-		conv.dataStatement([]nodes.Node{n})
-		if conv.dataMode() {
-			return &copyOrInsert{stmt: insert, table: table, cols: colNames, vals: values}
+	var rows []insertRow
+	for _, vl := range sel.ValuesLists {
+		row, err := getVals(conv, colNames, len(n.Cols.Items) > 0, vl)
+		if err != nil {
+			conv.unexpected(fmt.Sprintf("Processing %v statement: %s", reflect.TypeOf(n), err))
+			conv.statsAddBadRow(table, conv.schemaMode())
+			continue
 		}
-	default:
-		conv.unexpected(fmt.Sprintf("Found %s node while processing InsertStmt SelectStmt", prNodeType(sel)))
+		row.cols, row.vals = addDiscriminator(row.cols, row.vals, disc, srcTable)
+		rows = append(rows, row)
+	}
+	conv.dataStatement([]nodes.Node{n})
+	if conv.dataMode() {
+		return &copyOrInsert{stmt: insert, table: table, rows: rows}
 	}
 	return nil
 }
@@ -326,15 +770,20 @@ func processCopyStmt(conv *Conv, n nodes.CopyStmt) *copyOrInsert {
 	// the data portion of the COPY-FROM statement, and we'll
 	// likely get stuck at this point in the pg_dump file.
 	table := "BOGUS_COPY_FROM_TABLE"
+	name := table // As given in the dump (see dupcopy.go).
 	var err error
 	if n.Relation != nil {
 		table, err = getTableName(conv, *n.Relation)
 		if err != nil {
 			conv.unexpected(fmt.Sprintf("Processing %v statement: %s", reflect.TypeOf(n), err))
 		}
+		name = table
+		table = conv.partitionRoot(table)
 	} else {
 		logStmtError(conv, n, fmt.Errorf("relation is nil"))
 	}
+	srcTable := table
+	table, disc := conv.mergeTarget(srcTable)
 	var cols []string
 	for _, a := range n.Attlist.Items {
 		s, err := getString(a)
@@ -344,8 +793,14 @@ func processCopyStmt(conv *Conv, n nodes.CopyStmt) *copyOrInsert {
 		}
 		cols = append(cols, s)
 	}
-	conv.dataStatement([]nodes.Node{n})
-	return &copyOrInsert{stmt: copyFrom, table: table, cols: cols}
+	cols, extra := addDiscriminator(cols, nil, disc, srcTable)
+	dup := conv.duplicateCopy(name)
+	if dup && conv.skipDuplicateCopies() {
+		conv.skipStatement([]nodes.Node{n})
+	} else {
+		conv.dataStatement([]nodes.Node{n})
+	}
+	return &copyOrInsert{stmt: copyFrom, table: table, cols: cols, extra: extra, name: name, dup: dup}
 }
 
 func processVariableSetStmt(conv *Conv, n nodes.VariableSetStmt) {
@@ -428,32 +883,70 @@ func getTypeID(l []nodes.Node) (string, error) {
 func getTableName(conv *Conv, n nodes.RangeVar) (string, error) {
 	// RangeVar is used to represent table names. It consists of three components:
 	//  Catalogname: database name; either not specified or the current database
-	//  Schemaname: schemas are PostgreSql namepaces; often unspecified; defaults to "public"
+	//  Schemaname: schemas are PostgreSql namepaces; often unspecified; defaults to
+	//    the schema given by SET search_path, or else "public"
 	//  Relname: name of the table
 	// We build a table name from these three components as follows:
 	// a) nil components are dropped.
 	// b) if more than one component is specified, they are joined using "."
 	//    (Note that Spanner doesn't allow "." in table names, so this
 	//    will eventually get re-mapped when we construct the Spanner table name).
-	// c) Schemaname is dropped if it is "public".
+	// c) Schemaname is dropped if it is the default schema (see
+	//    SetDefaultSchema).
 	// d) return error if Relname is nil or "".
+	if n.Relname == nil || *n.Relname == "" {
+		return "", fmt.Errorf("relname is empty: can't build table name")
+	}
 	var l []string
 	if n.Catalogname != nil {
 		l = append(l, *n.Catalogname)
 	}
-	if n.Schemaname != nil && *n.Schemaname != "public" { // Don't include "public".
-		l = append(l, *n.Schemaname)
-	}
-	if n.Relname == nil && *n.Relname == "" {
-		return "", fmt.Errorf("relname is empty: can't build table name")
+	schema := conv.searchPath
+	if n.Schemaname != nil {
+		schema = *n.Schemaname
 	}
-	l = append(l, *n.Relname)
+	l = append(l, buildTableName(conv, schema, *n.Relname))
 	return strings.Join(l, "."), nil
 }
 
+// getListName is like getTableName, for names given as a list of
+// strings e.g. by DROP TABLE and COMMENT ON.
+func getListName(conv *Conv, l nodes.List) (string, error) {
+	if len(l.Items) == 0 || len(l.Items) > 3 {
+		return "", fmt.Errorf("can't build name from %d components", len(l.Items))
+	}
+	var parts []*string
+	for _, i := range l.Items {
+		s, err := getString(i)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, &s)
+	}
+	rv := nodes.RangeVar{Relname: parts[len(parts)-1]}
+	if len(parts) > 1 {
+		rv.Schemaname = parts[len(parts)-2]
+	}
+	if len(parts) > 2 {
+		rv.Catalogname = parts[0]
+	}
+	return getTableName(conv, rv)
+}
+
 type constraint struct {
 	ct   nodes.ConstrType
 	cols []string
+	name string // Constraint name (empty if unnamed).
+	// Fields used for foreign keys.
+	referTable string
+	referCols  []string
+	onDelete   string
+	onUpdate   string
+	// Fields used for defaults (see schema.Ignored).
+	sequence bool
+	now      bool
+	// Columns used by the expression of a check constraint.
+	exprCols []string
 }
 
 // extractConstraints traverses a list of nodes (expecting them to be
@@ -463,18 +956,31 @@ func extractConstraints(conv *Conv, n nodes.Node, table string, l []nodes.Node)
 	for _, i := range l {
 		switch d := i.(type) {
 		case nodes.Constraint:
-			var cols []string
-			for _, j := range d.Keys.Items {
-				k, err := getString(j)
-				if err == nil {
-					cols = append(cols, k)
-				}
-				if err != nil {
-					conv.unexpected(fmt.Sprintf("Processing %v statement: error processing constraints: %s", reflect.TypeOf(n), err.Error()))
-					conv.errorInStatement([]nodes.Node{n, d})
+			c := constraint{ct: d.Contype}
+			if d.Conname != nil {
+				c.name = *d.Conname
+			}
+			keys := d.Keys.Items
+			if d.Contype == nodes.CONSTR_FOREIGN {
+				// Foreign keys use FkAttrs rather than Keys.
+				keys = d.FkAttrs.Items
+				c.referCols = getStrings(conv, n, d, d.PkAttrs.Items)
+				if d.Pktable != nil {
+					if t, err := getTableName(conv, *d.Pktable); err == nil {
+						c.referTable = t
+					}
 				}
+				c.onDelete = fkAction(conv, d.FkDelAction)
+				c.onUpdate = fkAction(conv, d.FkUpdAction)
+			}
+			if d.Contype == nodes.CONSTR_DEFAULT {
+				c.sequence, c.now = defaultKind(d.RawExpr)
 			}
-			cs = append(cs, constraint{ct: d.Contype, cols: cols})
+			if d.Contype == nodes.CONSTR_CHECK {
+				c.exprCols = exprColumns(d.RawExpr)
+			}
+			c.cols = getStrings(conv, n, d, keys)
+			cs = append(cs, c)
 		default:
 			conv.unexpected(fmt.Sprintf("Processing %v statement: found %s node while processing constraints\n", reflect.TypeOf(n), reflect.TypeOf(d)))
 		}
@@ -482,6 +988,42 @@ func extractConstraints(conv *Conv, n nodes.Node, table string, l []nodes.Node)
 	return cs
 }
 
+// fkAction maps a PostgreSQL foreign key action code (see
+// FKCONSTR_ACTION_xxx in PostgreSQL's parsenodes.h) to the name of
+// the action e.g. "CASCADE".
+func fkAction(conv *Conv, a byte) string {
+	switch a {
+	case 0, 'a':
+		return "NO ACTION"
+	case 'r':
+		return "RESTRICT"
+	case 'c':
+		return "CASCADE"
+	case 'n':
+		return "SET NULL"
+	case 'd':
+		return "SET DEFAULT"
+	}
+	conv.unexpected(fmt.Sprintf("Unknown foreign key action '%c'", a))
+	return string(a)
+}
+
+// getStrings extracts a list of strings (e.g. column names) from
+// constraint d of statement n.
+func getStrings(conv *Conv, n nodes.Node, d nodes.Constraint, l []nodes.Node) (cols []string) {
+	for _, j := range l {
+		k, err := getString(j)
+		if err == nil {
+			cols = append(cols, k)
+		}
+		if err != nil {
+			conv.unexpected(fmt.Sprintf("Processing %v statement: error processing constraints: %s", reflect.TypeOf(n), err.Error()))
+			conv.errorInStatement([]nodes.Node{n, d})
+		}
+	}
+	return cols
+}
+
 // analyzeColDefConstraints is like extractConstraints, but is specifially for
 // ColDef constraints. These constraints don't specify a key since they
 // are constraints for the column defined by ColDef.
@@ -512,34 +1054,149 @@ func updateSchema(conv *Conv, table string, cs []constraint, s string) {
 			// In PostgreSQL, the primary key constraint is a combination of
 			// NOT NULL and UNIQUE i.e. primary keys must be NOT NULL.
 			// We preserve PostgreSQL semantics and enforce NOT NULL.
-			updateCols(nodes.CONSTR_NOTNULL, c.cols, ct.ColDefs)
+			updateCols(constraint{ct: nodes.CONSTR_NOTNULL, cols: c.cols}, ct.ColDefs)
+			conv.srcSchema[table] = ct
+		case nodes.CONSTR_UNIQUE:
+			// PostgreSQL implements unique constraints using unique
+			// indexes, and so do we.
+			ct := conv.srcSchema[table]
+			name := c.name
+			if name == "" {
+				name = defaultIndexName(table, c.cols, "key")
+			}
+			ct.Indexes = append(ct.Indexes, schema.Index{Name: name, Keys: toSchemaKeys(conv, table, c.cols), Unique: true})
+			conv.srcSchema[table] = ct
+		case nodes.CONSTR_FOREIGN:
+			ct := conv.srcSchema[table]
+			ct.ForeignKeys = append(ct.ForeignKeys, schema.ForeignKey{
+				Name:         c.name,
+				Columns:      c.cols,
+				ReferTable:   c.referTable,
+				ReferColumns: c.referCols,
+				OnDelete:     c.onDelete,
+				OnUpdate:     c.onUpdate,
+			})
+			conv.srcSchema[table] = ct
+		case nodes.CONSTR_CHECK:
+			// Column constraints apply to their column, and table
+			// constraints to the columns their expression uses.
+			ct := conv.srcSchema[table]
+			cols := c.cols
+			if len(cols) == 0 {
+				cols = c.exprCols
+			}
+			if len(cols) > 1 {
+				// Reported as a group (see schemaToDDL), rather than
+				// for each column.
+				ct.Checks = append(ct.Checks, schema.Check{Name: c.name, Columns: cols})
+			} else {
+				updateCols(constraint{ct: c.ct, cols: cols}, ct.ColDefs)
+			}
 			conv.srcSchema[table] = ct
 		default:
 			ct := conv.srcSchema[table]
-			updateCols(c.ct, c.cols, ct.ColDefs)
+			updateCols(c, ct.ColDefs)
 			conv.srcSchema[table] = ct
 		}
 	}
 }
 
+// exprColumns returns the columns used by expression e, in order of
+// first use. It handles the kinds of expression pg_dump writes for
+// check constraints e.g. comparisons, boolean operators, function
+// calls and casts; columns in other kinds of expression are missed.
+func exprColumns(e nodes.Node) []string {
+	var cols []string
+	seen := make(map[string]bool)
+	var walk func(n nodes.Node)
+	walkList := func(l nodes.List) {
+		for _, i := range l.Items {
+			walk(i)
+		}
+	}
+	walk = func(n nodes.Node) {
+		switch n := n.(type) {
+		case nodes.ColumnRef:
+			if k := len(n.Fields.Items); k > 0 {
+				if c, err := getString(n.Fields.Items[k-1]); err == nil && !seen[c] {
+					seen[c] = true
+					cols = append(cols, c)
+				}
+			}
+		case nodes.A_Expr:
+			walk(n.Lexpr)
+			walk(n.Rexpr)
+		case nodes.BoolExpr:
+			walkList(n.Args)
+		case nodes.FuncCall:
+			walkList(n.Args)
+		case nodes.TypeCast:
+			walk(n.Arg)
+		case nodes.NullTest:
+			walk(n.Arg)
+		case nodes.BooleanTest:
+			walk(n.Arg)
+		case nodes.A_ArrayExpr:
+			walkList(n.Elements)
+		case nodes.List:
+			walkList(n)
+		}
+	}
+	walk(e)
+	return cols
+}
+
 // updateCols updates colDef with new constraints. Specifically, we apply
-// 'ct' to each column in colNames.
-func updateCols(ct nodes.ConstrType, colNames []string, colDef map[string]schema.Column) {
+// constraint c to each of its columns.
+func updateCols(c constraint, colDef map[string]schema.Column) {
 	// TODO: add cases for other constraints.
-	for _, c := range colNames {
-		cd := colDef[c]
-		switch ct {
+	for _, col := range c.cols {
+		cd := colDef[col]
+		switch c.ct {
 		case nodes.CONSTR_NOTNULL:
 			cd.NotNull = true
 		case nodes.CONSTR_DEFAULT:
 			cd.Ignored.Default = true
-		case nodes.CONSTR_FOREIGN:
-			cd.Ignored.ForeignKey = true
+			cd.Ignored.Sequence = c.sequence
+			cd.Ignored.Now = c.now
+		case nodes.CONSTR_IDENTITY:
+			cd.Ignored.Identity = true
+		case nodes.CONSTR_CHECK:
+			cd.Ignored.Check = true
 		}
-		colDef[c] = cd
+		colDef[col] = cd
 	}
 }
 
+// defaultKind reports whether the default expression e is the next
+// value of a sequence (as pg_dump writes defaults for serial columns),
+// or the current time.
+func defaultKind(e nodes.Node) (sequence, now bool) {
+	switch f := e.(type) {
+	case nodes.FuncCall:
+		if len(f.Funcname.Items) == 0 {
+			return false, false
+		}
+		// Ignore any schema qualification e.g. pg_catalog.now().
+		name, err := getString(f.Funcname.Items[len(f.Funcname.Items)-1])
+		if err != nil {
+			return false, false
+		}
+		switch name {
+		case "nextval":
+			return true, false
+		case "now", "clock_timestamp", "statement_timestamp", "transaction_timestamp":
+			return false, true
+		}
+	case nodes.SQLValueFunction:
+		switch f.Op {
+		case nodes.SVFOP_CURRENT_TIMESTAMP, nodes.SVFOP_CURRENT_TIMESTAMP_N, nodes.SVFOP_LOCALTIMESTAMP, nodes.SVFOP_LOCALTIMESTAMP_N:
+			return false, true
+		}
+	}
+	return false, false
+}
+
 // toSchemaKeys converts a string list of PostgreSQL primary keys to
 // schema primary keys.
 func toSchemaKeys(conv *Conv, table string, s []string) (l []schema.Key) {
@@ -552,7 +1209,28 @@ func toSchemaKeys(conv *Conv, table string, s []string) (l []schema.Key) {
 }
 
 // getCols extracts and returns the column names for an InsertStatement.
+// If the statement doesn't list its columns, they are the columns of
+// table, in order.
 func getCols(conv *Conv, table string, l []nodes.Node) (cols []string, err error) {
+	if len(l) == 0 {
+		if cols, ok := conv.inheritance.order[table]; ok {
+			return cols, nil // Table was merged.
+		}
+		t, ok := conv.srcSchema[table]
+		if !ok {
+			return nil, fmt.Errorf("table %s has no schema", table)
+		}
+		if disc, ok := conv.inheritance.column[table]; ok {
+			// The discriminator isn't a column of the source table.
+			for _, c := range t.ColNames {
+				if c != disc {
+					cols = append(cols, c)
+				}
+			}
+			return cols, nil
+		}
+		return t.ColNames, nil
+	}
 	for _, n := range l {
 		switch r := n.(type) {
 		case nodes.ResTarget:
@@ -566,30 +1244,57 @@ func getCols(conv *Conv, table string, l []nodes.Node) (cols []string, err error
 	return cols, nil
 }
 
-// getVals extracts and returns the values for an InsertStatement.
-func getVals(conv *Conv, l [][]nodes.Node, n nodes.InsertStmt) (values []string) {
-	for _, vl := range l {
-		for _, v := range vl {
-			switch c := v.(type) {
-			case nodes.A_Const:
-				switch st := c.Val.(type) {
-				case nodes.String:
-					values = append(values, st.Str)
-				case nodes.Integer:
-					// For uniformity, convert to string and handle everything in
-					// dataConversion(). If performance of insert statements becomes a
-					// high priority (it isn't right now), then consider preserving int64
-					// here to avoid the int64 -> string -> int64 conversions.
-					values = append(values, strconv.FormatInt(st.Ival, 10))
-				default:
-					conv.unexpected(fmt.Sprintf("Processing %v statement: found %s node for A_Const Val", reflect.TypeOf(n), reflect.TypeOf(c.Val)))
-				}
-			default:
-				conv.unexpected(fmt.Sprintf("Processing %v statement: found %s node in ValuesList", reflect.TypeOf(n), reflect.TypeOf(v)))
-			}
+// getVals extracts the values of values list vl of an InsertStatement,
+// returning them with their columns (from colNames). Columns set to
+// DEFAULT are omitted. If explicit is false (the statement doesn't list
+// its columns), vl can have fewer values than colNames, and the
+// remaining columns get their defaults, as in PostgreSQL.
+func getVals(conv *Conv, colNames []string, explicit bool, vl []nodes.Node) (row insertRow, err error) {
+	if len(vl) > len(colNames) || (explicit && len(vl) != len(colNames)) {
+		return row, fmt.Errorf("row has %d values for %d columns", len(vl), len(colNames))
+	}
+	for i, v := range vl {
+		if _, ok := v.(nodes.SetToDefault); ok {
+			continue
+		}
+		val, null, err := getVal(v)
+		if err != nil {
+			return row, err
+		}
+		row.cols = append(row.cols, colNames[i])
+		row.vals = append(row.vals, val)
+		row.nulls = append(row.nulls, null)
+	}
+	return row, nil
+}
+
+// getVal returns the value of constant v as a string, and whether it is
+// NULL. Note that the parser has already decoded escapes in string
+// literals (doubled quotes and E'...' escapes).
+func getVal(v nodes.Node) (string, bool, error) {
+	switch c := v.(type) {
+	case nodes.A_Const:
+		switch st := c.Val.(type) {
+		case nodes.String:
+			return st.Str, false, nil
+		case nodes.Integer:
+			// For uniformity, convert to string and handle everything in
+			// dataConversion(). If performance of insert statements becomes a
+			// high priority (it isn't right now), then consider preserving int64
+			// here to avoid the int64 -> string -> int64 conversions.
+			return strconv.FormatInt(st.Ival, 10), false, nil
+		case nodes.Float:
+			return st.Str, false, nil
+		case nodes.Null:
+			return nullMarker, true, nil
 		}
+		return "", false, fmt.Errorf("found %s node for A_Const Val", reflect.TypeOf(c.Val))
+	case nodes.TypeCast:
+		// e.g. true (which the parser represents as 't'::boolean) and
+		// '2020-01-01'::date.
+		return getVal(c.Arg)
 	}
-	return values
+	return "", false, fmt.Errorf("found %s node in ValuesList", reflect.TypeOf(v))
 }
 
 func logStmtError(conv *Conv, n nodes.Node, err error) {
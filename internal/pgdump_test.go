@@ -26,6 +26,7 @@ import (
 	pg_query "github.com/lfittl/pg_query_go"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
 	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
 )
 
@@ -47,14 +48,14 @@ func TestProcessPgDump(t *testing.T) {
 		{"bytea", ddl.Bytes{Len: ddl.MaxLength{}}},
 		{"char(42)", ddl.String{Len: ddl.Int64Length{Value: 42}}},
 		{"date", ddl.Date{}},
-		{"decimal", ddl.Float64{}}, // pg parser maps this to numeric.
+		{"decimal", ddl.Numeric{}}, // pg parser maps this to numeric.
 		{"double precision", ddl.Float64{}},
 		{"float8", ddl.Float64{}},
 		{"float4", ddl.Float64{}},
 		{"integer", ddl.Int64{}},
-		{"numeric", ddl.Float64{}},
-		{"numeric(4)", ddl.Float64{}},
-		{"numeric(6, 4)", ddl.Float64{}},
+		{"numeric", ddl.Numeric{}},
+		{"numeric(4)", ddl.Numeric{}},
+		{"numeric(6, 4)", ddl.Numeric{}},
 		{"real", ddl.Float64{}},
 		{"smallint", ddl.Int64{}},
 		{"text", ddl.String{Len: ddl.MaxLength{}}},
@@ -380,7 +381,7 @@ COPY test (id, a, b, c, d) FROM stdin;
 \.
 `,
 			expectedData: []spannerData{
-				spannerData{table: "test", cols: []string{"id", "a", "b", "c", "d"}, vals: []interface{}{int64(1), int64(88), int64(44), int64(22), float64(444.9876)}}},
+				spannerData{table: "test", cols: []string{"id", "a", "b", "c", "d"}, vals: []interface{}{int64(1), int64(88), int64(44), int64(22), "444.9876"}}},
 		},
 		{
 			name: "Data conversion: serial, text, timestamp, timestamptz, varchar",
@@ -393,6 +394,22 @@ COPY test (id, a, b, c, d, e) FROM stdin;
 			expectedData: []spannerData{
 				spannerData{table: "test", cols: []string{"id", "a", "b", "c", "d", "e"}, vals: []interface{}{int64(1), int64(2), "my text", getTime(t, "2019-10-29T05:30:00Z"), getTime(t, "2019-10-29T05:30:00+10:30"), "my varchar"}}},
 		},
+		{
+			name: "Data conversion: numeric, numeric array, wide numeric",
+			input: `
+CREATE TABLE test (id integer PRIMARY KEY, a numeric(6, 2), b numeric[], c numeric(40, 2));
+COPY test (id, a, b, c) FROM stdin;
+1	-0012.50	{1.5,NULL,-0.000000001}	12345678901234567890123456789012.34
+\.
+`,
+			expectedData: []spannerData{
+				spannerData{table: "test", cols: []string{"id", "a", "b", "c"}, vals: []interface{}{int64(1), "-12.5",
+					[]spanner.NullString{
+						spanner.NullString{StringVal: "1.5", Valid: true},
+						spanner.NullString{Valid: false},
+						spanner.NullString{StringVal: "-0.000000001", Valid: true}},
+					"12345678901234567890123456789012.34"}}},
+		},
 	}
 	for _, tc := range multiColTests {
 		conv, rows := runProcessPgDump(tc.input)
@@ -436,9 +453,9 @@ COPY test (id, a, b, c, d, e) FROM stdin;
 				"\\N	\\N	\\N	\\N	2019-10-29	\\N	\\N\n" + // Good
 				"\\N	\\N	\\N	\\N	2019-10-42	\\N	\\N\n" + // Error
 				"\\N	\\N	\\N	\\N	\\N	\\\\x0001beef	\\N\n" + // Good
-				"\\N	\\N	\\N	\\N	\\N	\\ \\x0001beef	\\N\n" + // Error
+				"\\N	\\N	\\N	\\N	\\N	\\\\x0001beeg	\\N\n" + // Error
 				"\\N	\\N	\\N	\\N	\\N	\\N	{42,6}\n" + // Good
-				"\\N	\\N	\\N	\\N	\\N	\\N	{42, 6}\n" + // Error
+				"\\N	\\N	\\N	\\N	\\N	\\N	{42,,6}\n" + // Error
 				"\\.\n",
 			expectedData: []spannerData{
 				spannerData{
@@ -560,8 +577,16 @@ func TestProcessPgDump_WithUnparsableContent(t *testing.T) {
 }
 
 func runProcessPgDump(s string) (*Conv, []spannerData) {
-	conv := MakeConv()
+	return runProcessPgDumpConv(MakeConv(), s)
+}
+
+// runProcessPgDumpConv is like runProcessPgDump, but uses conv (which
+// can be pre-configured e.g. with a type map).
+func runProcessPgDumpConv(conv *Conv, s string) (*Conv, []spannerData) {
 	conv.SetLocation(time.UTC)
+	// Freeze the clock so that reports don't include (nondeterministic)
+	// per-table timing.
+	conv.now = func() time.Time { return time.Time{} }
 	conv.SetSchemaMode()
 	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
 	conv.SetDataMode()
@@ -579,7 +604,7 @@ func runProcessPgDump(s string) (*Conv, []spannerData) {
 // many tests are issue-free, but several explicitly test handling of
 // various issues (so don't call nonIssue for them!).
 func noIssues(conv *Conv, t *testing.T, name string) {
-	assert.Zero(t, len(conv.stats.unexpected), fmt.Sprintf("'%s' generated unexpected conditions: %v", name, conv.stats.unexpected))
+	assert.Zero(t, len(conv.stats.unexpected), fmt.Sprintf("'%s' generated unexpected conditions: %v", name, unexpectedCounts(conv)))
 	for s, stat := range conv.stats.statement {
 		assert.Zero(t, stat.error, fmt.Sprintf("'%s' generated %d errors for %s statements", name, stat.error, s))
 		if stat.error > 0 {
@@ -628,3 +653,222 @@ func printJSON(s string) {
 func printJSONType(ty string) {
 	printJSON(fmt.Sprintf("CREATE TABLE t (a %s);", ty))
 }
+
+func TestProcessPgDump_ForeignKeys(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE orgs (org_id bigint, user_id bigint, PRIMARY KEY (org_id, user_id));\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, org_id bigint, user_id bigint, " +
+			"c bigint REFERENCES orgs(org_id) ON DELETE CASCADE ON UPDATE RESTRICT, " +
+			"CONSTRAINT t_fk FOREIGN KEY (org_id, user_id) REFERENCES orgs (org_id, user_id) ON DELETE SET NULL);\n" +
+			"ALTER TABLE ONLY t ADD CONSTRAINT t_fk2 FOREIGN KEY (id) REFERENCES orgs(user_id) ON UPDATE CASCADE;\n")
+	expected := []schema.ForeignKey{
+		{Columns: []string{"c"}, ReferTable: "orgs", ReferColumns: []string{"org_id"}, OnDelete: "CASCADE", OnUpdate: "RESTRICT"},
+		{Name: "t_fk", Columns: []string{"org_id", "user_id"}, ReferTable: "orgs", ReferColumns: []string{"org_id", "user_id"}, OnDelete: "SET NULL", OnUpdate: "NO ACTION"},
+		{Name: "t_fk2", Columns: []string{"id"}, ReferTable: "orgs", ReferColumns: []string{"user_id"}, OnDelete: "NO ACTION", OnUpdate: "CASCADE"},
+	}
+	assert.Equal(t, expected, conv.srcSchema["t"].ForeignKeys)
+	// Column-level constraints must not be lost when a table has table-level constraints.
+	assert.True(t, conv.srcSchema["t"].ColDefs["id"].NotNull)
+	noIssues(conv, t, "foreign keys")
+}
+
+func TestProcessPgDump_Checks(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE t (id bigint PRIMARY KEY, a bigint CHECK (a > 0), b bigint, c text, " +
+			"CONSTRAINT t_b_check CHECK ((b >= 0)), " +
+			"CONSTRAINT t_range CHECK (((b > a) AND (char_length(c) < (b)::integer) AND (c IS NOT NULL))));\n" +
+			"ALTER TABLE ONLY t ADD CONSTRAINT t_ca CHECK ((c <> (a)::text));\n")
+	// Checks on a single column are recorded for the column, and checks
+	// on several columns for the table.
+	expected := []schema.Check{
+		{Name: "t_range", Columns: []string{"b", "a", "c"}},
+		{Name: "t_ca", Columns: []string{"c", "a"}},
+	}
+	assert.Equal(t, expected, conv.srcSchema["t"].Checks)
+	for col, check := range map[string]bool{"id": false, "a": true, "b": true, "c": false} {
+		assert.Equal(t, check, conv.srcSchema["t"].ColDefs[col].Ignored.Check, col)
+	}
+}
+
+func TestProcessPgDump_MixedCase(t *testing.T) {
+	conv, rows := runProcessPgDump(
+		"CREATE TABLE public.\"OrderItems\" (\"userId\" bigint PRIMARY KEY, userid bigint, \"Note\" text);\n" +
+			"CREATE TABLE public.orderitems (id bigint PRIMARY KEY);\n" +
+			"COPY public.\"OrderItems\" (\"userId\", userid, \"Note\") FROM stdin;\n1\t2\tx\n\\.\n" +
+			"INSERT INTO public.\"OrderItems\" (\"Note\", \"userId\") VALUES ('y', 3);\n" +
+			"COPY public.orderitems (id) FROM stdin;\n4\n\\.\n" +
+			"CREATE INDEX \"NoteIdx\" ON public.\"OrderItems\" (\"Note\");\n")
+	noIssues(conv, t, "mixed case")
+	assert.Equal(t, []string{"OrderItems", "orderitems"}, sortedSrcTables(conv))
+	// Spanner names are case-insensitive, so names that differ only by
+	// case must be renamed.
+	assert.Equal(t, "OrderItems", conv.toSpanner["OrderItems"].name)
+	assert.Equal(t, "orderitems_1", conv.toSpanner["orderitems"].name)
+	ct := conv.spSchema["OrderItems"]
+	assert.Equal(t, []string{"userId", "userid_1", "Note"}, ct.ColNames)
+	assert.Equal(t, []ddl.IndexKey{{Col: "userId"}}, ct.Pks)
+	assert.Equal(t, []ddl.CreateIndex{{Name: "NoteIdx", Table: "OrderItems", Keys: []ddl.IndexKey{{Col: "Note"}}}}, ct.Indexes)
+	assert.Equal(t, []string{"id"}, conv.spSchema["orderitems_1"].ColNames)
+	assert.Equal(t, []spannerData{
+		{table: "OrderItems", cols: []string{"userId", "userid_1", "Note"}, vals: []interface{}{int64(1), int64(2), "x"}},
+		{table: "OrderItems", cols: []string{"Note", "userId"}, vals: []interface{}{"y", int64(3)}},
+		{table: "orderitems_1", cols: []string{"id"}, vals: []interface{}{int64(4)}},
+	}, rows)
+	assert.Equal(t, map[string]int64{"OrderItems": 2, "orderitems": 1}, conv.stats.rows)
+	for _, srcTable := range sortedSrcTables(conv) {
+		tr := buildTableReport(conv, srcTable, nil)
+		assert.Empty(t, tr.internalError, srcTable)
+	}
+}
+
+func TestProcessPgDump_AddColumn(t *testing.T) {
+	conv, rows := runProcessPgDump(
+		"CREATE TABLE t (id bigint PRIMARY KEY);\n" +
+			"ALTER TABLE t ADD COLUMN name text NOT NULL DEFAULT 'x', ADD COLUMN IF NOT EXISTS id bigint;\n" +
+			"COPY public.t (id, name) FROM stdin;\n1\ta\n\\.\n" +
+			"ALTER TABLE ONLY public.t ADD COLUMN n integer;\n" +
+			"INSERT INTO t (id, name, n) VALUES (2, 'b', 3);\n")
+	noIssues(conv, t, "add column")
+	assert.Equal(t, []string{"id", "name", "n"}, conv.srcSchema["t"].ColNames)
+	assert.Equal(t, schema.Column{Name: "name", Type: schema.Type{Name: "text"}, NotNull: true, Ignored: schema.Ignored{Default: true}}, conv.srcSchema["t"].ColDefs["name"])
+	assert.Equal(t, map[string]ddl.ColumnDef{
+		"id":   {Name: "id", T: ddl.Int64{}, NotNull: true},
+		"name": {Name: "name", T: ddl.String{Len: ddl.MaxLength{}}, NotNull: true},
+		"n":    {Name: "n", T: ddl.Int64{}},
+	}, stripSchemaComments(conv.spSchema)["t"].ColDefs)
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"id", "name"}, vals: []interface{}{int64(1), "a"}},
+		{table: "t", cols: []string{"id", "name", "n"}, vals: []interface{}{int64(2), "b", int64(3)}},
+	}, rows)
+	assert.Equal(t, int64(2), conv.stats.statement["AlterTableStmt.AlterTableCmd.ColumnDef"].schema)
+	assert.Equal(t, int64(1), conv.stats.statement["AlterTableStmt.AlterTableCmd"].skip) // IF NOT EXISTS id.
+}
+
+func TestProcessPgDump_AlterColumn(t *testing.T) {
+	conv, rows := runProcessPgDump(
+		"CREATE TABLE t (id integer PRIMARY KEY, name varchar(10) NOT NULL, score integer);\n" +
+			"ALTER TABLE t ALTER COLUMN id TYPE bigint, ALTER COLUMN name DROP NOT NULL;\n" +
+			"ALTER TABLE ONLY public.t ALTER COLUMN name TYPE text, ALTER COLUMN score SET NOT NULL;\n" +
+			"ALTER TABLE t ALTER COLUMN missing DROP NOT NULL;\n" +
+			"COPY public.t (id, name, score) FROM stdin;\n1\ta\t2\n\\.\n")
+	assert.Equal(t, schema.Column{Name: "id", Type: schema.Type{Name: "int8"}, NotNull: true}, conv.srcSchema["t"].ColDefs["id"])
+	assert.Equal(t, map[string]ddl.ColumnDef{
+		"id":    {Name: "id", T: ddl.Int64{}, NotNull: true},
+		"name":  {Name: "name", T: ddl.String{Len: ddl.MaxLength{}}},
+		"score": {Name: "score", T: ddl.Int64{}, NotNull: true},
+	}, stripSchemaComments(conv.spSchema)["t"].ColDefs)
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"id", "name", "score"}, vals: []interface{}{int64(1), "a", int64(2)}},
+	}, rows)
+	assert.Equal(t, int64(4), conv.stats.statement["AlterTableStmt.AlterTableCmd"].schema)
+	assert.Equal(t, int64(1), conv.stats.statement["AlterTableStmt.AlterTableCmd"].skip) // Column missing.
+	assert.Equal(t, map[string]int64{"ALTER TABLE alters column missing of table t, but it doesn't exist": 1}, unexpectedCounts(conv))
+}
+
+func TestProcessPgDump_AlterColumnAfterData(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE t (id bigint PRIMARY KEY, n integer);\n" +
+			"COPY public.t (id, n) FROM stdin;\n1\t2\n\\.\n" +
+			"ALTER TABLE t ALTER COLUMN n TYPE text;\n")
+	assert.Equal(t, ddl.ColumnDef{Name: "n", T: ddl.String{Len: ddl.MaxLength{}}}, stripSchemaComments(conv.spSchema)["t"].ColDefs["n"])
+	assert.Equal(t, map[string]int64{"ALTER TABLE changes the type of column n of table t after the table's data: all of its data is converted as altered": 1}, unexpectedCounts(conv))
+}
+
+const (
+	copyFixture = "CREATE TABLE t (id bigint PRIMARY KEY, name text, score float8, ok boolean, d date, b bytea, tags text[]);\n" +
+		"COPY public.t (id, name, score, ok, d, b, tags) FROM stdin;\n" +
+		"1\tit's\t-1.5\tt\t2020-01-02\t\\\\x00ff\t{a,b}\n" +
+		"2\ta\\\\b\t\\N\tf\t\\N\t\\N\t\\N\n" +
+		"3\tx\t2\t\\N\t\\N\t\\N\t\\N\n" +
+		"4\ty\tbad\t\\N\t\\N\t\\N\t\\N\n" +
+		"\\.\n"
+	insertFixture = "CREATE TABLE t (id bigint PRIMARY KEY, name text, score float8, ok boolean, d date, b bytea, tags text[]);\n" +
+		"INSERT INTO public.t VALUES (1, 'it''s', -1.5, true, '2020-01-02', '\\x00ff', '{a,b}'),\n" +
+		"  (2, E'a\\\\b', NULL, false, NULL, NULL, NULL);\n" +
+		"INSERT INTO t (id, name, score, ok) VALUES (3, 'x', 2, DEFAULT); INSERT INTO t (id, name, score) VALUES (4, 'y', 'bad');\n"
+)
+
+func TestProcessPgDump_MultiRowInsert(t *testing.T) {
+	copyConv, copyRows := runProcessPgDump(copyFixture)
+	conv, rows := runProcessPgDump(insertFixture)
+	assert.Equal(t, copyRows, rows)
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"id", "name", "score", "ok", "d", "b", "tags"}, vals: []interface{}{int64(1), "it's", float64(-1.5), true,
+			getDate("2020-01-02"), []byte{0x0, 0xff}, []spanner.NullString{{StringVal: "a", Valid: true}, {StringVal: "b", Valid: true}}}},
+		{table: "t", cols: []string{"id", "name", "ok"}, vals: []interface{}{int64(2), `a\b`, false}},
+		{table: "t", cols: []string{"id", "name", "score"}, vals: []interface{}{int64(3), "x", float64(2)}},
+	}, rows)
+	assert.Equal(t, copyConv.stats.rows, conv.stats.rows)
+	assert.Equal(t, copyConv.stats.goodRows, conv.stats.goodRows)
+	assert.Equal(t, copyConv.stats.badRows, conv.stats.badRows)
+	assert.Equal(t, int64(4), conv.Rows())
+	assert.Equal(t, int64(1), conv.BadRows())
+	assert.Equal(t, int64(3), conv.stats.statement["InsertStmt"].data)
+
+	// Columns can be listed in any order, and omitted columns get
+	// their defaults.
+	conv, rows = runProcessPgDump("CREATE TABLE t (id bigint PRIMARY KEY, name text, n bigint);\n" +
+		"INSERT INTO t (name, id) VALUES ('a', 1); INSERT INTO t (n, id) VALUES (DEFAULT, 2), (7, 3); INSERT INTO t VALUES (4);\n")
+	noIssues(conv, t, "multi-row insert")
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"name", "id"}, vals: []interface{}{"a", int64(1)}},
+		{table: "t", cols: []string{"id"}, vals: []interface{}{int64(2)}},
+		{table: "t", cols: []string{"n", "id"}, vals: []interface{}{int64(7), int64(3)}},
+		{table: "t", cols: []string{"id"}, vals: []interface{}{int64(4)}},
+	}, rows)
+
+	// Rows with too many values are bad rows.
+	conv, rows = runProcessPgDump("CREATE TABLE t (id bigint PRIMARY KEY, name text);\n" +
+		"INSERT INTO t (id) VALUES (1), (2, 'b');\n")
+	assert.Equal(t, []spannerData{{table: "t", cols: []string{"id"}, vals: []interface{}{int64(1)}}}, rows)
+	assert.Equal(t, int64(2), conv.Rows())
+	assert.Equal(t, int64(1), conv.BadRows())
+}
+
+func TestProcessPgDump_Indexes(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE t (id bigint PRIMARY KEY, a text NOT NULL, b bigint UNIQUE, c text, d bigint[], " +
+			"CONSTRAINT t_a_c_uniq UNIQUE (a, c));\n" +
+			"--\n" +
+			"-- Name: t_b_c_idx; Type: INDEX; Schema: public; Owner: -\n" +
+			"--\n\n" +
+			"CREATE INDEX t_b_c_idx ON public.t USING btree (b DESC, c);\n" +
+			"CREATE UNIQUE INDEX t_a_idx ON public.t USING btree (a) INCLUDE (b, \"c\");\n" +
+			"CREATE INDEX t_lower_idx ON public.t USING btree (lower(c));\n" +
+			"CREATE INDEX t_partial_idx ON public.t USING btree (b) WHERE (b > 0);\n" +
+			"CREATE INDEX t_d_idx ON public.t USING gin (d);\n" +
+			"CREATE INDEX t_d_b_idx ON public.t (d, b);\n" +
+			"CREATE INDEX ON t (c);\n")
+	srcExpected := []schema.Index{
+		{Name: "t_b_key", Keys: []schema.Key{{Column: "b"}}, Unique: true},
+		{Name: "t_a_c_uniq", Keys: []schema.Key{{Column: "a"}, {Column: "c"}}, Unique: true},
+		{Name: "t_b_c_idx", Keys: []schema.Key{{Column: "b", Desc: true}, {Column: "c"}}},
+		{Name: "t_a_idx", Keys: []schema.Key{{Column: "a"}}, Unique: true, Storing: []string{"b", "c"}},
+		{Name: "t_lower_idx", Ignored: schema.IndexIgnored{Expression: true}},
+		{Name: "t_partial_idx", Keys: []schema.Key{{Column: "b"}}, Ignored: schema.IndexIgnored{Predicate: true}},
+		{Name: "t_d_idx", Keys: []schema.Key{{Column: "d"}}, Ignored: schema.IndexIgnored{Method: "gin"}},
+		{Name: "t_d_b_idx", Keys: []schema.Key{{Column: "d"}, {Column: "b"}}},
+		{Name: "t_c_idx", Keys: []schema.Key{{Column: "c"}}},
+	}
+	assert.Equal(t, srcExpected, conv.srcSchema["t"].Indexes)
+	spExpected := []ddl.CreateIndex{
+		{Name: "t_b_key", Table: "t", Keys: []ddl.IndexKey{{Col: "b"}}, Unique: true, NullFiltered: true},
+		{Name: "t_a_c_uniq", Table: "t", Keys: []ddl.IndexKey{{Col: "a"}, {Col: "c"}}, Unique: true, NullFiltered: true},
+		{Name: "t_b_c_idx", Table: "t", Keys: []ddl.IndexKey{{Col: "b", Desc: true}, {Col: "c"}}},
+		{Name: "t_a_idx", Table: "t", Keys: []ddl.IndexKey{{Col: "a"}}, Unique: true, Storing: []string{"b", "c"}},
+		{Name: "t_c_idx", Table: "t", Keys: []ddl.IndexKey{{Col: "c"}}},
+	}
+	assert.Equal(t, spExpected, conv.spSchema["t"].Indexes)
+	var dropped []string
+	for _, g := range conv.groupIssues["t"] {
+		assert.Equal(t, indexUnsupported, g.issue)
+		dropped = append(dropped, g.construct+": "+g.detail)
+	}
+	assert.Equal(t, []string{
+		"index t_lower_idx: it indexes expressions, which Spanner doesn't support",
+		"index t_partial_idx: it is a partial index (it has a WHERE clause), which Spanner doesn't support",
+		"index t_d_idx: Spanner doesn't support gin indexes",
+		"index t_d_b_idx: Spanner doesn't support indexes on array columns",
+	}, dropped)
+	assert.Equal(t, int64(0), conv.Unexpecteds())
+}
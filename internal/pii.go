@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The PII key check is an opt-in heuristic (see EnablePIIKeyCheck)
+// that flags primary key columns that look like they contain personal
+// data such as email addresses, national ID numbers or phone
+// numbers. These make poor Spanner keys: keys appear in traces and
+// logs, can't be encrypted separately from the row, and often hotspot
+// on common prefixes.
+//
+// The check is entirely local: it looks at column names, and (when
+// column statistics are enabled) at a sample of the values of each
+// column. False positives are expected, which is why matches are
+// reported as notes rather than warnings.
+
+type piiKind int
+
+const (
+	piiEmail piiKind = iota
+	piiNationalID
+	piiPhone
+	numPIIKinds
+)
+
+var piiKinds = [numPIIKinds]struct {
+	desc  string         // Description used in the report.
+	name  *regexp.Regexp // Matches lower-cased column names.
+	value *regexp.Regexp // Matches values.
+}{
+	piiEmail: {
+		desc:  "email addresses",
+		name:  regexp.MustCompile(`e_?mail`),
+		value: regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`),
+	},
+	piiNationalID: {
+		desc:  "national ID numbers",
+		name:  regexp.MustCompile(`(^|[^a-z])(ssn|sin|nino)([^a-z]|$)|social_?security|national_?id|passport`),
+		value: regexp.MustCompile(`^\d{3}-\d{2}-\d{4}$`),
+	},
+	piiPhone: {
+		desc: "phone numbers",
+		name: regexp.MustCompile(`phone|mobile|msisdn|(^|[^a-z])tel([^a-z]|$)`),
+		// Require a leading '+' or separators: plain digit strings are
+		// far more likely to be ordinary integer ids.
+		value: regexp.MustCompile(`^(\+\d[\d\s\-().]{6,18}|\(?\d{3}\)?[\s\-.]\d{3}[\s\-.]\d{4})$`),
+	},
+}
+
+const (
+	piiSampleSize = 1000 // Number of values per column checked against value patterns.
+	piiMatchFrac  = 0.8  // Fraction of sampled values that must match a pattern.
+)
+
+// EnablePIIKeyCheck turns on the heuristic check for primary key
+// columns that look like they contain personal data. Value-based
+// checks also require column statistics (see EnableColumnStats).
+func (conv *Conv) EnablePIIKeyCheck() {
+	conv.piiKeyCheck = true
+}
+
+// observePII checks val against the PII value patterns. Only the first
+// piiSampleSize non-NULL values of each column are checked, so the cost
+// is bounded regardless of table size.
+func (conv *Conv) observePII(cs *columnStats, val string) {
+	if !conv.piiKeyCheck || cs.piiSampled >= piiSampleSize {
+		return
+	}
+	cs.piiSampled++
+	for k := range piiKinds {
+		if piiKinds[k].value.MatchString(val) {
+			cs.piiMatches[k]++
+		}
+	}
+}
+
+// piiKeyMatch describes a primary key column that looks like it
+// contains personal data.
+type piiKeyMatch struct {
+	col     string
+	kind    piiKind
+	byValue bool // True if the match was based on sampled values (rather than the column name).
+}
+
+// detectPIIKeys returns the primary key columns of srcTable that look
+// like they contain personal data, in key order. Returns nil unless the
+// PII key check is enabled.
+func (conv *Conv) detectPIIKeys(srcTable string) []piiKeyMatch {
+	if !conv.piiKeyCheck {
+		return nil
+	}
+	var l []piiKeyMatch
+	for _, k := range conv.srcSchema[srcTable].PrimaryKeys {
+		if m, ok := conv.matchPIIKey(srcTable, k.Column); ok {
+			l = append(l, m)
+		}
+	}
+	return l
+}
+
+func (conv *Conv) matchPIIKey(srcTable, srcCol string) (piiKeyMatch, bool) {
+	name := strings.ToLower(srcCol)
+	for k := range piiKinds {
+		if piiKinds[k].name.MatchString(name) {
+			return piiKeyMatch{col: srcCol, kind: piiKind(k)}, true
+		}
+	}
+	cs, ok := conv.colStats[srcTable][srcCol]
+	if !ok || cs.piiSampled == 0 {
+		return piiKeyMatch{}, false
+	}
+	for k := range piiKinds {
+		if float64(cs.piiMatches[k]) >= piiMatchFrac*float64(cs.piiSampled) {
+			return piiKeyMatch{col: srcCol, kind: piiKind(k), byValue: true}, true
+		}
+	}
+	return piiKeyMatch{}, false
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectPIIKeys(t *testing.T) {
+	data := "COPY public.t (id, k) FROM stdin;\n"
+	tc := []struct {
+		name        string
+		input       string
+		columnStats bool
+		expected    []piiKeyMatch
+	}{
+		{
+			name:     "email name",
+			input:    "CREATE TABLE t (email text PRIMARY KEY, b text);\n",
+			expected: []piiKeyMatch{{col: "email", kind: piiEmail}},
+		},
+		{
+			name:  "multi-column key",
+			input: "CREATE TABLE t (user_ssn text, MobilePhone text, b text, PRIMARY KEY (user_ssn, MobilePhone));\n",
+			expected: []piiKeyMatch{
+				{col: "user_ssn", kind: piiNationalID},
+				{col: "mobilephone", kind: piiPhone},
+			},
+		},
+		{
+			name:  "non-key columns are ignored",
+			input: "CREATE TABLE t (id bigint PRIMARY KEY, email text);\n",
+		},
+		{
+			name:  "no false match on substrings",
+			input: "CREATE TABLE t (basin text, hotel text, PRIMARY KEY (basin, hotel));\n",
+		},
+		{
+			name: "values ignored without column stats",
+			input: "CREATE TABLE t (id bigint, k text, PRIMARY KEY (id, k));\n" + data +
+				"1	a@example.com\n2	b@example.com\n\\.\n",
+		},
+		{
+			name: "email values",
+			input: "CREATE TABLE t (id bigint, k text, PRIMARY KEY (id, k));\n" + data +
+				"1	a@example.com\n2	b@example.com\n\\.\n",
+			columnStats: true,
+			expected:    []piiKeyMatch{{col: "k", kind: piiEmail, byValue: true}},
+		},
+		{
+			name: "ssn and phone values",
+			input: "CREATE TABLE t (id text, k text, PRIMARY KEY (id, k));\n" + data +
+				"123-45-6789	+1 650 555 0100\n987-65-4321	(650) 555-0199\n\\.\n",
+			columnStats: true,
+			expected: []piiKeyMatch{
+				{col: "id", kind: piiNationalID, byValue: true},
+				{col: "k", kind: piiPhone, byValue: true},
+			},
+		},
+		{
+			name: "integer ids are not phone numbers",
+			input: "CREATE TABLE t (id bigint, k text, PRIMARY KEY (id, k));\n" + data +
+				"16505550100	x\n16505550101	y\n\\.\n",
+			columnStats: true,
+		},
+		{
+			name: "too few matching values",
+			input: "CREATE TABLE t (id bigint, k text, PRIMARY KEY (id, k));\n" + data +
+				"1	a@example.com\n2	b\n\\.\n",
+			columnStats: true,
+		},
+	}
+	for _, tc := range tc {
+		conv := MakeConv()
+		conv.SetLocation(time.UTC)
+		conv.EnablePIIKeyCheck()
+		if tc.columnStats {
+			conv.EnableColumnStats()
+		}
+		conv.SetSchemaMode()
+		ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(tc.input)), nil))
+		conv.SetDataMode()
+		conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+		ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(tc.input)), nil))
+		assert.Equal(t, tc.expected, conv.detectPIIKeys("t"), tc.name)
+	}
+}
+
+func TestDetectPIIKeysDisabled(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t (email text PRIMARY KEY);\n")
+	assert.Nil(t, conv.detectPIIKeys("t"))
+}
+
+func TestReport_PIIKey(t *testing.T) {
+	conv := MakeConv()
+	conv.EnablePIIKeyCheck()
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(
+		"CREATE TABLE t (email text, phone text, b bigint, PRIMARY KEY (email, phone));\n")), nil))
+	tr := buildTableReport(conv, "t", nil)
+	assert.Equal(t, int64(0), tr.warnings)
+	expected := []tableReportBody{{
+		heading: "Note",
+		lines: []reportLine{{piiKey, []string{"email", "phone"}, "Primary key columns may contain personal data: 'email' (column name suggests email addresses), " +
+			"'phone' (column name suggests phone numbers). " + issueDB[piiKey].brief}},
+	}}
+	assert.Equal(t, expected, tr.body)
+}
@@ -16,7 +16,47 @@
 // HarbourBridge.
 package internal
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// ProgressSink receives progress updates. Progress and TableProgress
+// report them to the console; NopProgress discards them.
+type ProgressSink interface {
+	// MaybeReport updates the progress measure e.g. the bytes of a
+	// dump read so far.
+	MaybeReport(progress int64)
+	// AddRow records that a row of source table srcTable, with values
+	// of size bytes, was read during data conversion. If tables are
+	// read concurrently (see ProcessSqlDataParallel), rows of different
+	// tables interleave.
+	AddRow(srcTable string, bytes int64)
+	// TableDone records that the rows of srcTable read so far are all
+	// there are, until AddRow is next called for it.
+	TableDone(srcTable string)
+	// Done signals completion.
+	Done()
+}
+
+// NopProgress is a ProgressSink that discards progress updates.
+type NopProgress struct{}
+
+// MaybeReport does nothing.
+func (NopProgress) MaybeReport(progress int64) {}
+
+// AddRow does nothing.
+func (NopProgress) AddRow(srcTable string, bytes int64) {}
+
+// TableDone does nothing.
+func (NopProgress) TableDone(srcTable string) {}
+
+// Done does nothing.
+func (NopProgress) Done() {}
 
 // Progress provides console progress functionality. i.e. it reports what
 // percentage of a task is complete to the console, overwriting previous
@@ -27,11 +67,22 @@ type Progress struct {
 	pct      int    // Percentage done i.e. progress/total * 100
 	message  string // Name of task being monitored.
 	verbose  bool   // If true, print detailed info about each progress step.
+	out      io.Writer
 }
 
-// NewProgress creates and returns a Progress instance.
+// NewProgress creates and returns a Progress instance that reports
+// to stdout.
 func NewProgress(total int64, message string, verbose bool) *Progress {
-	p := &Progress{total, 0, 0, message, verbose}
+	return NewProgressWriter(total, message, verbose, os.Stdout)
+}
+
+// NewProgressWriter is like NewProgress, but reports to out. If out
+// is nil, progress is not reported.
+func NewProgressWriter(total int64, message string, verbose bool, out io.Writer) *Progress {
+	if out == nil {
+		out = ioutil.Discard
+	}
+	p := &Progress{total, 0, 0, message, verbose, out}
 	if total == 0 {
 		p.pct = 100
 	}
@@ -63,6 +114,12 @@ func (p *Progress) MaybeReport(progress int64) {
 	}
 }
 
+// AddRow does nothing: Progress only tracks the overall measure.
+func (p *Progress) AddRow(srcTable string, bytes int64) {}
+
+// TableDone does nothing.
+func (p *Progress) TableDone(srcTable string) {}
+
 // Done signals completion, and will report 100% if it hasn't already
 // been reported.
 func (p *Progress) Done() {
@@ -71,15 +128,183 @@ func (p *Progress) Done() {
 
 func (p *Progress) report(firstCall bool) {
 	if p.verbose {
-		fmt.Printf("%s: %2d%%\n", p.message, p.pct)
+		fmt.Fprintf(p.out, "%s: %2d%%\n", p.message, p.pct)
 		return
 	}
 	if firstCall {
-		fmt.Printf("%s: %2d%%", p.message, p.pct)
+		fmt.Fprintf(p.out, "%s: %2d%%", p.message, p.pct)
 	} else {
-		fmt.Printf("\b\b\b%2d%%", p.pct)
+		fmt.Fprintf(p.out, "\b\b\b%2d%%", p.pct)
 	}
 	if p.pct == 100 {
-		fmt.Printf("\n")
+		fmt.Fprintf(p.out, "\n")
+	}
+}
+
+// tableProgressInterval is the minimum time between TableProgress
+// reports, except when a new table starts.
+const tableProgressInterval = time.Second
+
+// maxTablesShown is the most tables being converted that a
+// TableProgress report lists: others are just counted.
+const maxTablesShown = 3
+
+// TableProgress reports the progress of data conversion to the
+// console, table by table: the tables being converted (in the order
+// they started), the rows and bytes read from each so far, the overall percentage done, the current
+// and average throughput, and an estimate of the time remaining. For
+// dumps, the percentage and estimate are based on the bytes of the
+// dump read (see MaybeReport); otherwise they are based on rows.
+type TableProgress struct {
+	message    string
+	verbose    bool
+	out        io.Writer
+	now        func() time.Time
+	start      time.Time
+	dumpBytes  int64            // Size of the dump (zero if the source isn't a dump).
+	tableTotal map[string]int64 // Rows of each source table (missing if unknown).
+	totalRows  int64            // Rows of all tables.
+	tables     []*tableStatus   // Source tables being converted, in the order they started.
+	rows       int64            // Rows read from all tables.
+	bytes      int64            // Bytes read from all tables (or the dump, for dumps).
+	last       time.Time        // Time of the last report.
+	lastBytes  int64            // bytes at the last report.
+	width      int              // Length of the last report, for overwriting it.
+}
+
+// tableStatus is the progress of a table being converted.
+type tableStatus struct {
+	name  string
+	rows  int64 // Rows read so far.
+	bytes int64 // Bytes read so far.
+	done  bool  // See TableDone.
+}
+
+// NewTableProgress creates and returns a TableProgress that reports to
+// out. dumpBytes is the size of the dump being converted (zero if the
+// source isn't a dump), and rows gives the (possibly approximate) row
+// count of source tables, keyed by table name.
+func NewTableProgress(message string, dumpBytes int64, rows map[string]int64, verbose bool, out io.Writer) *TableProgress {
+	p := &TableProgress{message: message, verbose: verbose, out: out, now: time.Now, dumpBytes: dumpBytes, tableTotal: rows}
+	for _, n := range rows {
+		p.totalRows += n
+	}
+	p.start = p.now()
+	p.last = p.start
+	return p
+}
+
+// MaybeReport records that the first n bytes of the dump were read.
+func (p *TableProgress) MaybeReport(n int64) {
+	if n > p.bytes {
+		p.bytes = n
+	}
+}
+
+// AddRow records that a row of source table srcTable, with values of
+// size bytes, was read, and reports progress if it's time to.
+func (p *TableProgress) AddRow(srcTable string, bytes int64) {
+	var t *tableStatus
+	tables := p.tables[:0]
+	for _, x := range p.tables {
+		switch {
+		case x.name == srcTable && !x.done:
+			t = x
+		case x.done:
+			continue
+		}
+		tables = append(tables, x)
+	}
+	p.tables = tables
+	if t == nil {
+		t = &tableStatus{name: srcTable}
+		p.tables = append(p.tables, t)
+		p.last = time.Time{} // Report the new table right away.
+	}
+	t.rows++
+	t.bytes += bytes
+	p.rows++
+	if p.dumpBytes == 0 {
+		p.bytes += bytes
+	}
+	if now := p.now(); now.Sub(p.last) >= tableProgressInterval {
+		p.report(now)
+	}
+}
+
+// TableDone records that srcTable is no longer being converted. Reports
+// leave it out once a row of any table is read after that, so that the
+// last table converted is in the final report.
+func (p *TableProgress) TableDone(srcTable string) {
+	for _, x := range p.tables {
+		if x.name == srcTable {
+			x.done = true
+		}
+	}
+}
+
+// Done reports the final progress.
+func (p *TableProgress) Done() {
+	p.report(p.now())
+	if !p.verbose {
+		fmt.Fprintf(p.out, "\n")
+	}
+}
+
+func (p *TableProgress) report(now time.Time) {
+	l := p.status(now)
+	p.lastBytes = p.bytes
+	p.last = now
+	if p.verbose {
+		fmt.Fprintf(p.out, "%s\n", l)
+		return
+	}
+	// Overwrite the previous report, padding to erase any leftovers.
+	n := len(l)
+	if n < p.width {
+		l += strings.Repeat(" ", p.width-n)
+	}
+	p.width = n
+	fmt.Fprintf(p.out, "\r%s", l)
+}
+
+// status returns a line describing the progress at time now.
+func (p *TableProgress) status(now time.Time) string {
+	done, total := p.rows, p.totalRows
+	if p.dumpBytes > 0 {
+		done, total = p.bytes, p.dumpBytes
+	}
+	pct := 100
+	if total > 0 && done < total {
+		pct = int(done * 100 / total)
+	}
+	l := []string{fmt.Sprintf("%s: %2d%%", p.message, pct)}
+	for i, t := range p.tables {
+		if i == maxTablesShown {
+			more := fmt.Sprintf("%d more tables", len(p.tables)-i)
+			if len(p.tables)-i == 1 {
+				more = "1 more table"
+			}
+			l = append(l, more)
+			break
+		}
+		rows := formatCount(t.rows)
+		if n, ok := p.tableTotal[t.name]; ok {
+			rows += " of " + formatCount(n)
+		}
+		l = append(l, fmt.Sprintf("table %s: %s rows, %s", t.name, rows, formatBytes(t.bytes)))
+	}
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		avg := float64(p.bytes) / elapsed
+		rate := fmt.Sprintf("avg %s/s", formatBytes(int64(avg)))
+		if d := now.Sub(p.last).Seconds(); !p.last.IsZero() && d > 0 {
+			rate = fmt.Sprintf("%s/s (%s)", formatBytes(int64(float64(p.bytes-p.lastBytes)/d)), rate)
+		}
+		l = append(l, rate)
+		if done > 0 && done < total {
+			eta := time.Duration(float64(total-done) / float64(done) * elapsed * float64(time.Second))
+			l = append(l, "ETA "+formatDuration(eta.Round(time.Second)))
+		}
 	}
+	return strings.Join(l, " | ")
 }
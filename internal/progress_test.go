@@ -15,6 +15,9 @@
 package internal
 
 import (
+	"bufio"
+	"bytes"
+	"strings"
 	"testing"
 	"time"
 
@@ -47,3 +50,72 @@ func TestDone(t *testing.T) {
 	p.Done()
 	assert.Equal(t, 100, p.pct)
 }
+
+func TestTableProgress(t *testing.T) {
+	var out bytes.Buffer
+	p := NewTableProgress("Converting", 1000, map[string]int64{"a": 2, "b": 10}, true, &out)
+	now := p.start
+	p.now = func() time.Time { return now }
+	now = now.Add(time.Second)
+	p.MaybeReport(100)
+	p.AddRow("a", 40) // New table, so reported right away.
+	now = now.Add(500 * time.Millisecond)
+	p.MaybeReport(150)
+	p.AddRow("a", 50) // Too soon to report.
+	now = now.Add(500 * time.Millisecond)
+	p.MaybeReport(250)
+	p.TableDone("a")
+	p.AddRow("b", 60)
+	now = now.Add(2 * time.Second)
+	p.MaybeReport(1000)
+	p.AddRow("b", 70)
+	p.Done()
+	assert.Equal(t, []string{
+		"Converting: 10% | table a: 1 of 2 rows, 40 B | avg 100 B/s | ETA 9s",
+		"Converting: 25% | table b: 1 of 10 rows, 60 B | avg 125 B/s | ETA 6s",
+		"Converting: 100% | table b: 2 of 10 rows, 130 B | 375 B/s (avg 250 B/s)",
+		"Converting: 100% | table b: 2 of 10 rows, 130 B | avg 250 B/s",
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+
+	// Without a dump, progress is based on rows.
+	out.Reset()
+	p = NewTableProgress("Converting", 0, map[string]int64{"a": 4}, false, &out)
+	p.now = func() time.Time { return p.start.Add(time.Second) }
+	p.AddRow("a", 10)
+	p.AddRow("a", 10)
+	assert.Equal(t, "\rConverting: 25% | table a: 1 of 4 rows, 10 B | avg 10 B/s | ETA 3s", out.String())
+	p.Done()
+	assert.True(t, strings.HasSuffix(out.String(), "\rConverting: 50% | table a: 2 of 4 rows, 20 B | avg 20 B/s | ETA 1s\n"), out.String())
+}
+
+func TestTableProgress_Concurrent(t *testing.T) {
+	var out bytes.Buffer
+	p := NewTableProgress("Converting", 0, map[string]int64{"a": 2, "b": 2, "c": 2, "d": 2, "e": 2}, true, &out)
+	p.now = func() time.Time { return p.start.Add(time.Second) }
+	for _, x := range []string{"a", "b", "c", "d", "e"} {
+		p.AddRow(x, 10)
+	}
+	p.TableDone("b")
+	p.AddRow("a", 10)
+	p.Done()
+	assert.Equal(t, []string{
+		"Converting: 10% | table a: 1 of 2 rows, 10 B | avg 10 B/s | ETA 9s",
+		"Converting: 20% | table a: 1 of 2 rows, 10 B | table b: 1 of 2 rows, 10 B | avg 20 B/s | ETA 4s",
+		"Converting: 30% | table a: 1 of 2 rows, 10 B | table b: 1 of 2 rows, 10 B | table c: 1 of 2 rows, 10 B | avg 30 B/s | ETA 2s",
+		"Converting: 40% | table a: 1 of 2 rows, 10 B | table b: 1 of 2 rows, 10 B | table c: 1 of 2 rows, 10 B | 1 more table | avg 40 B/s | ETA 2s",
+		"Converting: 50% | table a: 1 of 2 rows, 10 B | table b: 1 of 2 rows, 10 B | table c: 1 of 2 rows, 10 B | 2 more tables | avg 50 B/s | ETA 1s",
+		"Converting: 60% | table a: 2 of 2 rows, 20 B | table c: 1 of 2 rows, 10 B | table d: 1 of 2 rows, 10 B | 1 more table | avg 60 B/s | ETA 1s",
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+}
+
+func TestNopProgress(t *testing.T) {
+	conv := MakeConv()
+	conv.SetProgress(NopProgress{})
+	conv.SetSchemaMode()
+	s := "CREATE TABLE t (a bigint PRIMARY KEY);\nCOPY public.t (a) FROM stdin;\n1\n\\.\n"
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), NopProgress{}))
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), NopProgress{}))
+	assert.Equal(t, int64(1), conv.Rows())
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+)
+
+// For direct connections to the source database (see infoschema.go),
+// there are no dump statements to count. Instead, we count the tables
+// and columns read from the information schema, and the queries
+// issued, so that the report can show how much of the source database
+// was processed, and whether any queries failed. Queries about tables
+// are also counted for each source schema.
+
+// queryStats counts queries of the source database.
+type queryStats struct {
+	tables  int64 // Tables whose schema was read.
+	columns int64 // Columns whose schema was read.
+	queries int64 // Queries issued, for schema information, row counts or data.
+	errors  int64 // Queries that failed.
+}
+
+// queryIssued records a query of the source database, which failed if
+// err is non-nil.
+func (conv *Conv) queryIssued(err error) {
+	conv.stats.queries.queries++
+	if err != nil {
+		conv.stats.queries.errors++
+	}
+}
+
+// tableQueryIssued is like queryIssued, for a query about a table in
+// source schema schema (e.g. for its columns or its rows).
+func (conv *Conv) tableQueryIssued(schema string, err error) {
+	conv.queryIssued(err)
+	s := conv.schemaQueryStats(schema)
+	s.queries++
+	if err != nil {
+		s.errors++
+	}
+}
+
+// tableSchemaRead records that the schema of a table in source schema
+// schema, with cols columns, was read.
+func (conv *Conv) tableSchemaRead(schema string, cols int) {
+	conv.stats.queries.tables++
+	conv.stats.queries.columns += int64(cols)
+	s := conv.schemaQueryStats(schema)
+	s.tables++
+	s.columns += int64(cols)
+}
+
+// schemaQueryStats returns the query stats of source schema schema.
+func (conv *Conv) schemaQueryStats(schema string) *queryStats {
+	if conv.stats.bySchema == nil {
+		conv.stats.bySchema = make(map[string]*queryStats)
+	}
+	s, ok := conv.stats.bySchema[schema]
+	if !ok {
+		s = &queryStats{}
+		conv.stats.bySchema[schema] = s
+	}
+	return s
+}
+
+// makeReportQueryStats returns the query stats for the report.
+func makeReportQueryStats(conv *Conv) *ReportQueryStats {
+	q := conv.stats.queries
+	r := &ReportQueryStats{TablesRead: q.tables, ColumnsRead: q.columns, Queries: q.queries, Errors: q.errors}
+	var schemas []string
+	for s := range conv.stats.bySchema {
+		schemas = append(schemas, s)
+	}
+	sort.Strings(schemas)
+	for _, s := range schemas {
+		q := conv.stats.bySchema[s]
+		r.Schemas = append(r.Schemas, ReportSchemaQueryStats{Schema: s, TablesRead: q.tables, ColumnsRead: q.columns, Queries: q.queries, Errors: q.errors})
+	}
+	return r
+}
+
+func writeQueryStats(src Source, q ReportQueryStats, w *bufio.Writer) {
+	writeHeading(w, "Queries Processed")
+	fmt.Fprintf(w, "Analysis of queries of the %s database.\n", src.Name)
+	fmt.Fprintf(w, "  tables read:     %d\n", q.TablesRead)
+	fmt.Fprintf(w, "  columns read:    %d\n", q.ColumnsRead)
+	fmt.Fprintf(w, "  queries issued:  %d\n", q.Queries)
+	fmt.Fprintf(w, "  query errors:    %d\n", q.Errors)
+	if len(q.Schemas) > 0 {
+		w.WriteString("Queries about tables, by source schema:\n")
+		n := len("schema")
+		for _, s := range q.Schemas {
+			if len(s.Schema) > n {
+				n = len(s.Schema)
+			}
+		}
+		fmt.Fprintf(w, "  %-*s  %7s  %7s  %7s  %7s\n", n, "schema", "tables", "columns", "queries", "errors")
+		for _, s := range q.Schemas {
+			fmt.Fprintf(w, "  %-*s  %7d  %7d  %7d  %7d\n", n, s.Schema, s.TablesRead, s.ColumnsRead, s.Queries, s.Errors)
+		}
+	}
+	if src.StmtTypes != "" {
+		w.WriteString(src.StmtTypes + "\n")
+	}
+	w.WriteString("\n")
+}
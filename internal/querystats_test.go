@@ -0,0 +1,81 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryStats(t *testing.T) {
+	conv := MakeConv()
+	conv.queryIssued(nil)
+	conv.queryIssued(errors.New("permission denied"))
+	conv.stats.queries.tables = 3
+	conv.stats.queries.columns = 12
+	r := BuildReport(PostgresSource, conv, nil)
+	assert.Equal(t, &ReportQueryStats{TablesRead: 3, ColumnsRead: 12, Queries: 2, Errors: 1}, r.QueryStats)
+	assert.Nil(t, BuildReport(PgDumpSource, conv, nil).QueryStats)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PostgresSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), "stats on the PostgreSQL queries processed,")
+	assert.Contains(t, buf.String(), `----------------------------
+Queries Processed
+----------------------------
+Analysis of queries of the PostgreSQL database.
+  tables read:     3
+  columns read:    12
+  queries issued:  2
+  query errors:    1
+Schema information is read from the database's information schema and catalog,
+and data with a SELECT query for each table.
+
+`)
+	assert.NotContains(t, buf.String(), "pg_query_go")
+	assert.NotContains(t, buf.String(), "Statements Processed")
+}
+
+func TestQueryStats_Schemas(t *testing.T) {
+	conv := MakeConv()
+	conv.queryIssued(nil)
+	conv.tableSchemaRead("public", 3)
+	conv.tableQueryIssued("public", nil)
+	conv.tableSchemaRead("audit", 2)
+	conv.tableQueryIssued("audit", nil)
+	conv.tableQueryIssued("audit", errors.New("permission denied"))
+	r := BuildReport(PostgresSource, conv, nil)
+	assert.Equal(t, &ReportQueryStats{TablesRead: 2, ColumnsRead: 5, Queries: 4, Errors: 1, Schemas: []ReportSchemaQueryStats{
+		{Schema: "audit", TablesRead: 1, ColumnsRead: 2, Queries: 2, Errors: 1},
+		{Schema: "public", TablesRead: 1, ColumnsRead: 3, Queries: 1},
+	}}, r.QueryStats)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PostgresSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), `  query errors:    1
+Queries about tables, by source schema:
+  schema   tables  columns  queries   errors
+  audit         1        2        2        1
+  public        1        3        1        0
+`)
+}
@@ -16,6 +16,7 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 )
@@ -28,19 +29,43 @@ type Reader struct {
 	Offset     int // Character offset from start of input. Starts with character 1.
 	EOF        bool
 	r          *bufio.Reader
-	progress   *Progress
+	progress   ProgressSink
+	ctx        context.Context
+	stopped    bool
 }
 
 // NewReader builds and returns an instance of Reader.
-func NewReader(r *bufio.Reader, progress *Progress) *Reader {
+func NewReader(r *bufio.Reader, progress ProgressSink) *Reader {
 	return &Reader{LineNumber: 1, Offset: 1, EOF: false, r: r, progress: progress}
 }
 
+// NewReaderContext is like NewReader, but the Reader stops (i.e.
+// reports eof) once ctx is done, so that processing of its input can
+// be interrupted.
+func NewReaderContext(ctx context.Context, r *bufio.Reader, progress ProgressSink) *Reader {
+	rd := NewReader(r, progress)
+	rd.ctx = ctx
+	return rd
+}
+
+// Stopped returns whether r stopped reading its input because its
+// context is done (see NewReaderContext), rather than at eof. Input
+// read before then may end with an incomplete statement, which should
+// be ignored.
+func (r *Reader) Stopped() bool {
+	return r.stopped
+}
+
 // ReadLine returns a line of input.
 func (r *Reader) ReadLine() []byte {
 	if r.EOF {
 		return []byte{}
 	}
+	if r.ctx != nil && r.ctx.Err() != nil {
+		r.EOF = true
+		r.stopped = true
+		return []byte{}
+	}
 	b, err := r.r.ReadBytes('\n')
 	if err == io.EOF {
 		r.EOF = true
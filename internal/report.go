@@ -24,52 +24,267 @@ import (
 	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
 )
 
+// Source describes the source of a conversion, so that reports can
+// adjust their wording (and sections) to it.
+type Source struct {
+	Name       string // Name used in reports e.g. "pg_dump".
+	Statements bool   // Whether statement stats were collected (dump sources only).
+	Queries    bool   // Whether query stats were collected (direct connections only).
+	StmtTypes  string // Explains the statement types listed in statement stats, or the queries counted in query stats.
+	Unexpected string // Intro to the unexpected conditions section (empty for the default).
+}
+
+// Sources of conversions.
+var (
+	PgDumpSource = Source{
+		Name:       "pg_dump",
+		Statements: true,
+		StmtTypes: "See github.com/lfittl/pg_query_go/nodes for definitions of statement types\n" +
+			"(lfittl/pg_query_go is the library we use for parsing pg_dump output).",
+		Unexpected: "For debugging only. This section provides details of unexpected conditions\n" +
+			"encountered as we processed the pg_dump data. In particular, the AST node\n" +
+			"representation used by the lfittl/pg_query_go library used for parsing\n" +
+			"pg_dump output is highly permissive: almost any construct can appear at\n" +
+			"any node in the AST tree. The list details all unexpected nodes and\n" +
+			"conditions.\n",
+	}
+	MySQLDumpSource = Source{
+		Name:       "mysqldump",
+		Statements: true,
+		StmtTypes:  "Statement types are the leading keywords of each statement.",
+	}
+	CSVSource = Source{
+		Name: "CSV",
+	}
+	DynamoDBSource = Source{
+		Name:       "DynamoDB",
+		Statements: true,
+		StmtTypes:  "Each line of a DynamoDB export holds an item, which is counted as an Item statement.",
+	}
+	SQLServerSource = Source{
+		Name:    "SQL Server",
+		Queries: true,
+		StmtTypes: "Schema information is read from the database's catalog views (sys.tables, sys.columns,\n" +
+			"sys.indexes and sys.foreign_keys), and data with a SELECT query for each table.",
+	}
+	PostgresSource = Source{
+		Name:    "PostgreSQL",
+		Queries: true,
+		StmtTypes: "Schema information is read from the database's information schema and catalog,\n" +
+			"and data with a SELECT query for each table.",
+	}
+)
+
 // GenerateReport analyzes schema and data conversion stats and writes a
-// detailed report to w and returns a brief summary (as a string).
-func GenerateReport(fromPgDump bool, conv *Conv, w *bufio.Writer, badWrites map[string]int64) string {
-	reports := analyzeTables(conv, badWrites)
-	summary := generateSummary(conv, reports, badWrites)
+// detailed report to w and returns a brief summary (as a string). The
+// report is a rendering of BuildReport's structured report.
+func GenerateReport(src Source, conv *Conv, w *bufio.Writer, badWrites map[string]int64) string {
+	return generateReport(src, conv, w, badWrites, nil)
+}
+
+// GenerateSplitReport is like GenerateReport, but leaves out the
+// table-by-table details, which are written to separate files (see
+// WriteTableReport). Instead, it lists the tables with their ratings
+// and files, which tableFiles gives keyed by source table.
+func GenerateSplitReport(src Source, conv *Conv, w *bufio.Writer, badWrites map[string]int64, tableFiles map[string]string) string {
+	return generateReport(src, conv, w, badWrites, tableFiles)
+}
+
+// WriteTableReport writes the details of the schema and data
+// conversion of source table srcTable to w, as in the table-by-table
+// listing of GenerateReport.
+func WriteTableReport(conv *Conv, srcTable string, w *bufio.Writer, badWrites map[string]int64) {
+	// Table sections only use the report's sampling and bad-rows file.
+	r := &Report{Sampling: makeReportSampling(conv, 0), BadRowsFile: makeReportBadRowsFile(conv)}
+	writeTableReport(r, makeReportTable(conv, buildTableReport(conv, srcTable, badWrites)), w)
+}
+
+func generateReport(src Source, conv *Conv, w *bufio.Writer, badWrites map[string]int64, tableFiles map[string]string) string {
+	r := BuildReport(src, conv, badWrites)
+	writeReport(src, r, conv.reportLevel, w, tableFiles)
+	return r.SummaryText
+}
+
+// writeReport writes the text report for r to w, with the level of
+// detail given by level. If tableFiles is non-nil, tables are listed
+// with their files instead of their details (see GenerateSplitReport).
+func writeReport(src Source, r *Report, level ReportLevel, w *bufio.Writer, tableFiles map[string]string) {
+	// Part of the header, along with the banner.
+	for _, msg := range []string{inputFormatMsg(r.InputFormat), createdInstanceMsg(r.CreatedInstance), snapshotMsg(r.Snapshot), dialectMsg(r.Dialect), writeOptionsMsg(r.WritePriority, r.WriteTag), issueOverridesMsg(r.SuppressedIssues, r.IssueSeverities)} {
+		if msg != "" {
+			w.WriteString(msg + ".\n\n")
+		}
+	}
 	writeHeading(w, "Summary of Conversion")
-	w.WriteString(summary)
-	ignored := ignoredStatements(conv)
+	w.WriteString(r.SummaryText)
 	w.WriteString("\n")
-	if len(ignored) > 0 {
+	if len(r.IgnoredStatements) > 0 {
 		justifyLines(w, fmt.Sprintf("Note that the following source DB statements "+
 			"were detected but ignored: %s.",
-			strings.Join(ignored, ", ")), 80, 0)
+			strings.Join(r.IgnoredStatements, ", ")), 80, 0)
 		w.WriteString("\n\n")
 	}
 	statementsMsg := ""
-	if fromPgDump {
-		statementsMsg = "stats on the pg_dump statements processed, followed by "
+	switch {
+	case src.Statements:
+		statementsMsg = fmt.Sprintf("stats on the %s statements processed, followed by ", src.Name)
+	case r.QueryStats != nil:
+		statementsMsg = fmt.Sprintf("stats on the %s queries processed, followed by ", src.Name)
 	}
-	justifyLines(w, "The remainder of this report provides "+statementsMsg+
-		"a table-by-table listing of schema and data conversion details. "+
+	tablesMsg := "a table-by-table listing of schema and data conversion details"
+	if tableFiles != nil {
+		tablesMsg = "a list of tables with the files that have their schema and data conversion details"
+	}
+	justifyLines(w, "The remainder of this report provides "+statementsMsg+tablesMsg+". "+
 		"For background on the schema and data conversion process used, "+
 		"and explanations of the terms and notes used in this "+
 		"report, see HarbourBridge's README.", 80, 0)
 	w.WriteString("\n\n")
-	if fromPgDump {
-		writeStmtStats(conv, w)
+	if len(r.IssueSummary) > 0 {
+		writeIssueSummary(r.IssueSummary, w)
+	}
+	if len(r.SchemaMismatch) > 0 {
+		writeSchemaMismatch(r.SchemaMismatch, w)
+	}
+	if len(r.Verification) > 0 {
+		writeVerification(r.Verification, w)
+	}
+	if r.DDLValidation != nil {
+		writeDDLValidation(r.DDLValidation, w)
+	}
+	if r.ExistingDB != nil {
+		writeExistingDB(r.ExistingDB, w)
+	}
+	if r.DDLApplied != nil || len(r.DDLBatches) > 0 {
+		writeDDLApplication(r, w)
+	}
+	if src.Statements {
+		writeStmtStats(src, r, w)
+	}
+	if r.QueryStats != nil {
+		writeQueryStats(src, *r.QueryStats, w)
+	}
+	if len(r.DroppedObjects) > 0 {
+		writeDroppedObjects(r.DroppedObjects, w)
+	}
+	if tableFiles != nil {
+		writeTableFiles(r.Tables, tableFiles, w)
+	} else {
+		writeTableReports(r, level, w)
+	}
+	if u := r.ResourceUsage; u != nil {
+		writeResourceUsage(ResourceUsage{u.PeakRSS, u.PeakHeap, u.PeakGoroutines, u.BytesRead, u.TempFileBytes}, w)
+	}
+	writeUnexpectedConditions(src, r, w)
+}
+
+// writeTableReport writes the details of table t of report r, as in the
+// table-by-table listing of the report.
+func writeTableReport(r *Report, t ReportTable, w *bufio.Writer) {
+	h := fmt.Sprintf("Table %s", t.SrcTable)
+	if t.SrcTable != t.SpTable {
+		h = h + fmt.Sprintf(" (mapped to Spanner table %s)", t.SpTable)
 	}
-	for _, t := range reports {
-		h := fmt.Sprintf("Table %s", t.srcTable)
-		if t.srcTable != t.spTable {
-			h = h + fmt.Sprintf(" (mapped to Spanner table %s)", t.spTable)
+	writeHeading(w, h)
+	fmt.Fprintf(w, "Schema conversion: %s.\n", t.Rating.Schema.Description)
+	fmt.Fprintf(w, "Data conversion: %s.\n", t.Rating.Data.Description)
+	if msg := badRowsMsg(reportBadRowCounts(t.BadRowCauses)); msg != "" {
+		justifyLines(w, msg+".\n", 80, 2)
+	}
+	if tp := formatThroughput(reportTableTiming(t.Timing), t.Rows); tp != "" {
+		fmt.Fprintf(w, "Time: %s.\n", tp)
+	}
+	if msg := storageMsg(reportStorageEstimate(t.Storage)); msg != "" {
+		fmt.Fprintf(w, "%s.\n", msg)
+	}
+	var sampling *RowSampling
+	if s := r.Sampling; s != nil {
+		sampling = &RowSampling{Limit: s.Limit, Percent: s.Percent}
+	}
+	if msg := samplingMsg(sampling, t.Rows, t.UnsampledRows); msg != "" {
+		fmt.Fprintf(w, "%s.\n", msg)
+	}
+	if msg := unreadMsg(t.UnreadRows); msg != "" {
+		fmt.Fprintf(w, "%s.\n", msg)
+	}
+	var badRowsPath string
+	if r.BadRowsFile != nil {
+		badRowsPath = r.BadRowsFile.Path
+	}
+	if msg := badRowsLoggedMsg(badRowsPath, t.BadRowsLogged); msg != "" {
+		fmt.Fprintf(w, "%s.\n", msg)
+	}
+	w.WriteString("\n")
+	if t.InternalError != "" {
+		fmt.Fprintf(w, "Internal error: %s\n\n", t.InternalError)
+	}
+	docs := make(docLinker)
+	for _, sec := range issueSections(t.Issues) {
+		fmt.Fprintf(w, "%s\n", sec.heading)
+		for i, x := range sec.issues {
+			text := x.Text
+			if u := docs.url(x.Issue, x.DocURL); u != "" {
+				text += fmt.Sprintf(" (see %s)", u)
+			}
+			justifyLines(w, fmt.Sprintf("%d) %s.\n", i+1, text), 80, 3)
 		}
-		writeHeading(w, h)
-		w.WriteString(rateConversion(t.rows, t.badRows, t.cols, t.warnings, t.syntheticPKey != "", false))
 		w.WriteString("\n")
-		for _, x := range t.body {
-			fmt.Fprintf(w, "%s\n", x.heading)
-			for i, l := range x.lines {
-				justifyLines(w, fmt.Sprintf("%d) %s.\n", i+1, l), 80, 3)
-			}
-			w.WriteString("\n")
+	}
+	if len(t.ColumnStats) > 0 {
+		var l []columnStatsSummary
+		for _, cs := range t.ColumnStats {
+			l = append(l, columnStatsSummary{cs.Column, cs.Values, cs.NullFrac, cs.Distinct})
 		}
+		writeColumnStats(l, w)
+	}
+	if len(t.Mappings) > 0 {
+		writeColumnMappings(t.Mappings, w)
 	}
-	writeUnexpectedConditions(conv, w)
-	return summary
+}
+
+// issueSection is a group of consecutive issues of a table report, with
+// the heading they are listed under in the text report.
+type issueSection struct {
+	heading string
+	issues  []ReportIssue
+}
+
+// issueSections groups issues (in report order: warnings, then notes,
+// then examples of bad values) into sections of the text report.
+func issueSections(issues []ReportIssue) []issueSection {
+	var l []issueSection
+	for _, x := range issues {
+		h := "Note"
+		switch {
+		case x.Issue == badValue.String():
+			h = badValueHeading
+		case x.Severity == warning.String():
+			h = "Warning"
+		}
+		if len(l) == 0 || l[len(l)-1].heading != h {
+			l = append(l, issueSection{heading: h})
+		}
+		l[len(l)-1].issues = append(l[len(l)-1].issues, x)
+	}
+	for i := range l {
+		if len(l[i].issues) > 1 && l[i].heading != badValueHeading {
+			l[i].heading += "s"
+		}
+	}
+	return l
+}
+
+const badValueHeading = "Examples of data conversion problems"
+
+// writeTableFiles lists tables with their ratings and the files that
+// have their details (see GenerateSplitReport).
+func writeTableFiles(tables []ReportTable, tableFiles map[string]string, w *bufio.Writer) {
+	writeHeading(w, "Tables")
+	w.WriteString("Schema and data conversion ratings of each table, and the file with its details.\n")
+	for _, t := range tables {
+		fmt.Fprintf(w, "  %s: schema %s, data %s (see %s)\n", t.SrcTable, t.Rating.Schema.Rating, t.Rating.Data.Rating, tableFiles[t.SrcTable])
+	}
+	w.WriteString("\n")
 }
 
 type tableReport struct {
@@ -78,19 +293,62 @@ type tableReport struct {
 	rows          int64
 	badRows       int64
 	cols          int64
-	warnings      int64
-	syntheticPKey string // Empty string means no synthetic primary key was needed.
+	warnings      int64       // Warnings for rating the table (see analyzeCols).
+	listed        int64       // Warnings listed in body, for rating descriptions (see schemaCounts).
+	syntheticPKey string      // Empty string means no synthetic primary key was needed.
+	hotspotKey    string      // First primary key column, if its values increase over time (see Conv.detectHotspotKey).
+	internalError string      // Non-empty if the table couldn't be analyzed.
+	timing        tableTiming // Zero if there is no timing information.
+	dataSkipped   bool        // Data conversion was not run (see Conv.SkipDataConversion).
+	tooLargeRows  int64       // Bad rows that exceed Spanner's commit size limit (see Conv.AddTooLargeRows).
+	badRowsLogged int64       // Bad rows written to the bad-rows file (see Conv.SetBadRowWriter).
+	unsampled     int64       // Rows skipped by row sampling (see Conv.SetRowSampling); not included in rows.
+	unread        int64       // Rows not read because conversion was interrupted (see Conv.SetInterrupted); not included in rows.
 	body          []tableReportBody
+	badCauses     []badRowCount        // Bad rows by cause, most common first (see badcause.go).
+	colStats      []columnStatsSummary // Empty unless column statistics are enabled.
+	storage       *storageEstimate     // Nil if there is no estimate (see storage.go).
+	mappings      []columnMapping      // Empty unless column mappings are enabled (see mappings.go).
+	// Columns with each issue, including every instance of batched issues.
+	issueCols map[schemaIssue]int64
+}
+
+// schemaCounts returns the inputs to the schema rating of table t.
+func (t tableReport) schemaCounts() schemaCounts {
+	return schemaCounts{cols: t.cols, warnings: t.warnings, listed: t.listed, missingPKey: t.syntheticPKey != ""}
+}
+
+// listedWarnings returns the number of warnings in report body b, other
+// than missingPrimaryKey (which ratings describe separately).
+func listedWarnings(conv *Conv, b []tableReportBody) int64 {
+	n := int64(0)
+	for _, x := range b {
+		for _, l := range x.lines {
+			if l.issue != missingPrimaryKey && conv.severity(l.issue) == warning {
+				n++
+			}
+		}
+	}
+	return n
 }
 
 type tableReportBody struct {
 	heading string
-	lines   []string
+	lines   []reportLine
+}
+
+// reportLine is a single issue in a table report. In addition to the
+// text, we keep the issue type and the columns it affects, so that
+// structured reports (see GenerateJSONReport) can be filtered by tools.
+type reportLine struct {
+	issue schemaIssue
+	cols  []string // Source columns affected (for missingPrimaryKey, the synthetic Spanner column).
+	text  string
 }
 
 func analyzeTables(conv *Conv, badWrites map[string]int64) (r []tableReport) {
-	// Process tables in alphabetical order. This ensures that tables
-	// appear in alphabetical order in report.txt.
+	// Process tables in alphabetical order, and then sort them into the
+	// configured report order (see reportorder.go).
 	var tables []string
 	for t := range conv.srcSchema {
 		tables = append(tables, t)
@@ -99,6 +357,7 @@ func analyzeTables(conv *Conv, badWrites map[string]int64) (r []tableReport) {
 	for _, srcTable := range tables {
 		r = append(r, buildTableReport(conv, srcTable, badWrites))
 	}
+	conv.sortTableReports(r)
 	return r
 }
 
@@ -106,23 +365,37 @@ func buildTableReport(conv *Conv, srcTable string, badWrites map[string]int64) t
 	spTable, err := GetSpannerTable(conv, srcTable)
 	srcSchema, ok1 := conv.srcSchema[srcTable]
 	spSchema, ok2 := conv.spSchema[spTable]
-	tr := tableReport{srcTable: srcTable, spTable: spTable}
+	tr := tableReport{srcTable: srcTable, spTable: spTable, dataSkipped: conv.dataSkipped}
 	if err != nil || !ok1 || !ok2 {
 		m := "bad source-DB-to-Spanner table mapping or Spanner schema"
 		conv.unexpected("report: " + m)
+		tr.internalError = m
 		tr.body = []tableReportBody{tableReportBody{heading: "Internal error: " + m}}
 		return tr
 	}
-	issues, cols, warnings := analyzeCols(conv, srcTable, spTable)
+	issues, cols, warnings, counts := analyzeCols(conv, srcTable, spTable)
 	tr.cols = cols
 	tr.warnings = warnings
+	tr.issueCols = counts
+	if h, ok := conv.detectHotspotKey(srcTable); ok && !conv.issueSuppressed(hotspot) {
+		tr.hotspotKey = h.col
+	}
 	if pk, ok := conv.syntheticPKeys[spTable]; ok {
 		tr.syntheticPKey = pk.col
 		tr.body = buildTableReportBody(conv, srcTable, issues, spSchema, srcSchema, &pk.col)
 	} else {
 		tr.body = buildTableReportBody(conv, srcTable, issues, spSchema, srcSchema, nil)
 	}
+	tr.listed = listedWarnings(conv, tr.body)
 	fillRowStats(conv, srcTable, badWrites, &tr)
+	if t, ok := conv.stats.timing[srcTable]; ok {
+		tr.timing = *t
+	}
+	tr.storage = conv.storageEstimate(srcTable)
+	tr.colStats = conv.getColumnStats(srcTable)
+	if conv.showMappings {
+		tr.mappings = conv.columnMappings(srcTable, srcSchema, spSchema)
+	}
 	return tr
 }
 
@@ -141,19 +414,36 @@ func buildTableReportBody(conv *Conv, srcTable string, issues map[string][]schem
 			cols = append(cols, t)
 		}
 		sort.Strings(cols)
-		var l []string
+		var l []reportLine
 		if syntheticPK != nil {
 			// Warnings about synthetic primary keys must be handled as a special case
 			// because we have a Spanner column with no matching source DB col.
 			// Much of the generic code for processing issues assumes we have both.
-			if p.severity == warning {
-				l = append(l, fmt.Sprintf("Column '%s' was added because this table didn't have a primary key. Spanner requires a primary key for every table", *syntheticPK))
+			if conv.severity(missingPrimaryKey) == p.severity {
+				l = append(l, reportLine{missingPrimaryKey, []string{*syntheticPK}, fmt.Sprintf("Column '%s' was added because this table didn't have a primary key. %s. %s", *syntheticPK, conv.issueBrief(missingPrimaryKey), conv.syntheticPKDesc())})
 			}
 		}
+		// Likewise for commit timestamp columns.
+		if c, ok := conv.commitTS[spSchema.Name]; ok && conv.reported(commitTimestamp, p.severity) {
+			l = append(l, reportLine{commitTimestamp, []string{c.col}, fmt.Sprintf("%s. %s", conv.describeCommitTimestamp(spSchema.Name), conv.issueBrief(commitTimestamp))})
+		}
+		// And for duplicate COPY-FROM blocks, which aren't specific to a
+		// column.
+		if msg := conv.describeDuplicateCopies(srcTable); msg != "" && conv.reported(dupCopy, p.severity) {
+			l = append(l, reportLine{dupCopy, nil, fmt.Sprintf("%s. %s", msg, conv.issueBrief(dupCopy))})
+		}
+		// And for source comments, which are also listed together.
+		if cols, msg := conv.describeComments(srcTable); msg != "" && conv.reported(comment, p.severity) {
+			l = append(l, reportLine{comment, cols, fmt.Sprintf("%s. %s", msg, conv.issueBrief(comment))})
+		}
+		// And for column transforms, which are listed together.
+		if cols, msg := conv.describeTransforms(srcTable); msg != "" && conv.reported(transformed, p.severity) {
+			l = append(l, reportLine{transformed, cols, fmt.Sprintf("%s. %s", msg, conv.issueBrief(transformed))})
+		}
 		issueBatcher := make(map[schemaIssue]bool)
 		for _, srcCol := range cols {
 			for _, i := range issues[srcCol] {
-				if issueDB[i].severity != p.severity {
+				if !conv.reported(i, p.severity) {
 					continue
 				}
 				if issueDB[i].batch {
@@ -169,7 +459,7 @@ func buildTableReportBody(conv *Conv, srcTable string, issues map[string][]schem
 					conv.unexpected(err.Error())
 				}
 				srcType := printSourceType(srcSchema.ColDefs[srcCol].Type)
-				spType := spSchema.ColDefs[spCol].PrintColumnDefType()
+				spType := conv.printType(spSchema.ColDefs[spCol])
 				// A note on case: Spanner types are case insensitive, but
 				// default to upper case. In particular, the Spanner AST uses
 				// upper case, so spType is upper case. Many source DBs
@@ -180,20 +470,132 @@ func buildTableReportBody(conv *Conv, srcTable string, issues map[string][]schem
 				// on case of srcType.
 				spType = strings.ToLower(spType)
 				switch i {
+				case datetime:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Some columns have source DB type 'datetime' which is mapped to Spanner type timestamp e.g. column '%s' (values interpreted as %s). %s", srcCol, conv.naiveZone(), conv.issueBrief(i))})
 				case defaultValue:
-					l = append(l, fmt.Sprintf("%s e.g. column '%s'", issueDB[i].brief, srcCol))
-				case foreignKey:
-					l = append(l, fmt.Sprintf("Column '%s' uses foreign keys which Spanner does not support", srcCol))
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("%s e.g. column '%s'", conv.issueBrief(i), srcCol)})
+				case domain:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Some columns use domains e.g. column '%s' uses domain '%s' resolved to %s. %s", srcCol, srcSchema.ColDefs[srcCol].Domain, srcType, conv.issueBrief(i))})
+				case dynamoMixedTypes:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("%s. %s", conv.describeDynamoTypes(srcTable, srcCol, spType), conv.issueBrief(i))})
+				case enum:
+					labels, _ := conv.enumLabels(srcSchema.ColDefs[srcCol].Type.Name)
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Column '%s': enum type %s is mapped to %s. Allowed values: %s. %s", srcCol, srcType, spType, describeEnumLabels(labels), conv.issueBrief(i))})
+				case floatSpecial:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("%s. %s", conv.describeFloatSpecial(srcTable, srcCol, spSchema.ColDefs[spCol].NotNull), conv.issueBrief(i))})
+				case hotspot:
+					h, _ := conv.detectHotspotKey(srcTable)
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Column '%s' is the first primary key column, and %s, so new rows are all written to the end of the table (a hotspot). %s", srcCol, h.reason, conv.issueBrief(i))})
+				case invalidUTF8:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("%s. %s", conv.describeInvalidUTF8(srcTable, srcCol), conv.issueBrief(i))})
+				case multiDimensionalArray:
+					if conv.multiDimRows[srcTable][srcCol] > 0 {
+						// A column mapped to an array, with values that
+						// turned out to be multi-dimensional.
+						l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("%s. %s", conv.describeMultiDimArray(srcTable, srcCol), conv.issueBrief(i))})
+					} else {
+						l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Column '%s': type %s is mapped to %s. %s", srcCol, srcType, spType, conv.issueBrief(i))})
+					}
+				case piiKey:
+					// Batched: list all matching key columns in one note.
+					var cols, descs []string
+					for _, p := range conv.detectPIIKeys(srcTable) {
+						how := "column name suggests"
+						if p.byValue {
+							how = "sampled values suggest"
+						}
+						cols = append(cols, p.col)
+						descs = append(descs, fmt.Sprintf("'%s' (%s %s)", p.col, how, piiKinds[p.kind].desc))
+					}
+					l = append(l, reportLine{i, cols, fmt.Sprintf("Primary key columns may contain personal data: %s. %s", strings.Join(descs, ", "), conv.issueBrief(i))})
+				case commitTimestamp:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Column '%s' has allow_commit_timestamp = true (see table options), so Spanner rejects values in the future. %s", srcCol, conv.issueBrief(i))})
+				case rowDeletionPolicy:
+					rd := conv.rowDeletion[srcTable]
+					m := fmt.Sprintf("Rows will be deleted by Spanner once column '%s' is more than %d days old (row deletion policy)", srcCol, rd.days)
+					if rd.expired > 0 {
+						m += fmt.Sprintf(". %d rows are already older than this, and will be deleted soon after they are written", rd.expired)
+					}
+					if rd.farFuture > 0 {
+						m += fmt.Sprintf(". %d rows have timestamps more than %d years in the future (possibly sentinel values), and will effectively never be deleted", rd.farFuture, farFutureYears)
+					}
+					l = append(l, reportLine{i, []string{srcCol}, m})
+				case rowDeletionPolicyNullable:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Column '%s' is used by the row deletion policy but is nullable. %s", srcCol, conv.issueBrief(i))})
+				case stringBounded:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Some columns would be mapped to string(max), but are mapped to %s e.g. column '%s' of type %s. %s", spType, srcCol, srcType, conv.issueBrief(i))})
+				case stringOverflow:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("%s. %s", conv.describeOverflow(srcTable, srcCol, spType), conv.issueBrief(i))})
 				case timestamp:
 					// Avoid the confusing "timestamp is mapped to timestamp" message.
-					l = append(l, fmt.Sprintf("Some columns have source DB type 'timestamp without timezone' which is mapped to Spanner type timestamp e.g. column '%s'. %s", srcCol, issueDB[i].brief))
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Some columns have source DB type 'timestamp without timezone' which is mapped to Spanner type timestamp e.g. column '%s' (values interpreted as %s). %s", srcCol, conv.naiveZone(), conv.issueBrief(i))})
+				case timestampRange:
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("%s. %s", conv.describeTimestampRange(srcTable, srcCol), conv.issueBrief(i))})
+				case typeOverride:
+					o := conv.typeOverrides[srcTable][srcCol]
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Column '%s': type %s is mapped to %s via user override (%s). %s", srcCol, srcType, spType, o.describe(), conv.issueBrief(i))})
 				case widened:
-					l = append(l, fmt.Sprintf("%s e.g. for column '%s', source DB type %s is mapped to Spanner type %s", issueDB[i].brief, srcCol, srcType, spType))
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("%s e.g. for column '%s', source DB type %s is mapped to Spanner type %s", conv.issueBrief(i), srcCol, srcType, spType)})
 				default:
-					l = append(l, fmt.Sprintf("Column '%s': type %s is mapped to %s. %s", srcCol, srcType, spType, issueDB[i].brief))
+					l = append(l, reportLine{i, []string{srcCol}, fmt.Sprintf("Column '%s': type %s is mapped to %s. %s", srcCol, srcType, spType, conv.issueBrief(i))})
 				}
 			}
 		}
+		for _, g := range conv.groupIssues[srcTable] {
+			if !conv.reported(g.issue, p.severity) {
+				continue
+			}
+			cols := strings.Join(g.cols, ", ")
+			switch g.issue {
+			case foreignKey:
+				m := fmt.Sprintf("The %s was converted to a Spanner foreign key", g.construct)
+				if g.detail != "" {
+					m += ", but " + g.detail
+				}
+				l = append(l, reportLine{g.issue, g.cols, fmt.Sprintf("%s. %s", m, conv.issueBrief(g.issue))})
+			case foreignKeyUnsupported, indexUnsupported, uniqueUnsupported:
+				l = append(l, reportLine{g.issue, g.cols, fmt.Sprintf("The %s was dropped because %s. %s", g.construct, g.detail, conv.issueBrief(g.issue))})
+			case checkConstraint:
+				l = append(l, reportLine{g.issue, g.cols, fmt.Sprintf("The %s was dropped. %s", g.construct, conv.issueBrief(g.issue))})
+			case inherited:
+				m := fmt.Sprintf("Table inherits from %s: its inherited columns (%s) were copied into this table, which was converted as a separate table", g.construct, cols)
+				if g.detail != "" {
+					m += " (" + g.detail + ")"
+				}
+				l = append(l, reportLine{g.issue, g.cols, fmt.Sprintf("%s. %s", m, conv.issueBrief(g.issue))})
+			case inheritedMerged:
+				m := fmt.Sprintf("Table %s (which inherits from this table) was merged into this table: its rows were written to this table, with '%s' in column '%s'", g.construct, g.construct, cols)
+				if g.detail != "" {
+					m += ". " + g.detail
+				}
+				l = append(l, reportLine{g.issue, g.cols, fmt.Sprintf("%s. %s", m, conv.issueBrief(g.issue))})
+			case partitioned:
+				m := fmt.Sprintf("Table was partitioned in the source DB: its %s were merged into this table", g.detail)
+				if len(g.cols) > 0 {
+					m += fmt.Sprintf(" (%s: %s)", g.construct, cols)
+				}
+				l = append(l, reportLine{g.issue, g.cols, fmt.Sprintf("%s. %s", m, conv.issueBrief(g.issue))})
+			case renamed:
+				what := "Table"
+				if len(g.cols) > 0 {
+					what = fmt.Sprintf("Column '%s'", cols)
+				}
+				l = append(l, reportLine{g.issue, g.cols, fmt.Sprintf("%s was mapped to Spanner %s because %s. %s", what, g.construct, g.detail, conv.issueBrief(g.issue))})
+			case orderingChanged:
+				srcCol := g.cols[0]
+				spCol, err := GetSpannerCol(conv, srcTable, srcCol, true)
+				if err != nil {
+					conv.unexpected(err.Error())
+				}
+				srcType := srcSchema.ColDefs[srcCol].Type
+				spType := spSchema.ColDefs[spCol]
+				l = append(l, reportLine{g.issue, g.cols, fmt.Sprintf("Column '%s' is part of the %s, and is mapped from %s to %s: %s. %s",
+					srcCol, g.construct, printSourceType(srcType), strings.ToLower(conv.printType(spType)),
+					orderingChangeReason(srcType, spType.T), conv.issueBrief(g.issue))})
+			default:
+				l = append(l, reportLine{g.issue, g.cols, fmt.Sprintf("Columns (%s): %s", cols, conv.issueBrief(g.issue))})
+			}
+		}
 		if len(l) == 0 {
 			continue
 		}
@@ -203,6 +605,9 @@ func buildTableReportBody(conv *Conv, srcTable string, issues map[string][]schem
 		}
 		body = append(body, tableReportBody{heading: heading, lines: l})
 	}
+	if l := badValueLines(conv, srcTable); len(l) > 0 {
+		body = append(body, tableReportBody{heading: badValueHeading, lines: l})
+	}
 	return body
 }
 
@@ -216,11 +621,28 @@ func fillRowStats(conv *Conv, srcTable string, badWrites map[string]int64, tr *t
 	// goodConvRows: rows we successfully converted.
 	// badConvRows: rows we failed to convert.
 	// badRowWrites: rows we converted, but could not write to Spanner.
+	// Rows skipped by row sampling weren't converted, so don't count them.
+	unsampled := conv.unsampledRows(srcTable)
+	rows -= unsampled
+	// Likewise for rows that weren't read because conversion was
+	// interrupted.
+	tr.unread = conv.unreadRows(srcTable)
+	rows -= tr.unread
+	// Every row is either converted or not, and only converted rows can
+	// fail to be written. More bad rows than rows (e.g. bad writes counted
+	// twice) would give negative percentages, so rateData rates the table
+	// as inconsistent.
 	if rows != goodConvRows+badConvRows || badRowWrites > goodConvRows {
-		conv.unexpected(fmt.Sprintf("Inconsistent row counts for table %s: %d %d %d %d\n", srcTable, rows, goodConvRows, badConvRows, badRowWrites))
+		conv.unexpected(fmt.Sprintf("Inconsistent row counts for table %s: %d rows, %d converted, %d failed conversion, %d failed writes", srcTable, rows, goodConvRows, badConvRows, badRowWrites))
 	}
 	tr.rows = rows
+	tr.unsampled = unsampled
 	tr.badRows = badConvRows + badRowWrites
+	tr.tooLargeRows = conv.stats.tooLarge[srcTable]
+	tr.badCauses = conv.badRowCounts(srcTable, badConvRows, badRowWrites)
+	if conv.badRowsOut != nil {
+		tr.badRowsLogged = conv.badRowsOut.tableRows(srcTable)
+	}
 }
 
 // Provides a description and severity for each schema issue.
@@ -229,22 +651,84 @@ func fillRowStats(conv *Conv, srcTable string, badWrites map[string]int64, tr *t
 // of the issue in the same table has little value and could be very noisy.
 // This is controlled via 'batch': if true, we count only the first instance
 // for assessing warnings, and we give only the first instance in the report.
-// TODO: add links in these descriptions to further documentation
-// e.g. for timestamp description.
+// Every issue has a docURL, which is noDocURL if there is nothing to link
+// to beyond the brief description.
 var issueDB = map[schemaIssue]struct {
 	brief    string // Short description of issue.
 	severity severity
-	batch    bool // Whether multiple instances of this issue are combined.
+	batch    bool   // Whether multiple instances of this issue are combined.
+	docURL   string // Further documentation of the issue, or noDocURL.
 }{
-	defaultValue:          {brief: "Some columns have default values which Spanner does not support", severity: warning, batch: true},
-	foreignKey:            {brief: "Spanner does not support foreign keys", severity: warning},
-	multiDimensionalArray: {brief: "Spanner doesn't support multi-dimensional arrays", severity: warning},
-	noGoodType:            {brief: "No appropriate Spanner type", severity: warning},
-	numeric:               {brief: "Spanner does not support numeric. This type mapping could lose precision and is not recommended for production use", severity: warning},
-	numericThatFits:       {brief: "Spanner does not support numeric, but this type mapping preserves the numeric's specified precision", severity: note},
-	serial:                {brief: "Spanner does not support autoincrementing types", severity: warning},
-	timestamp:             {brief: "Spanner timestamp is closer to PostgreSQL timestamptz", severity: note, batch: true},
-	widened:               {brief: "Some columns will consume more storage in Spanner", severity: note, batch: true},
+	badValue:                  {brief: "Rows with values like these are bad rows, and weren't written to Spanner", severity: warning, docURL: readmeURL + "data-conversion"},
+	checkConstraint:           {brief: "Spanner won't enforce this constraint, so the application must", severity: warning, docURL: readmeURL + "check-constraints"},
+	comment:                   {brief: "Spanner doesn't store comments, so they are only kept in the schema file", severity: note, docURL: readmeURL + "comments"},
+	commitTimestamp:           {brief: "Applications can track changes to rows by writing the commit timestamp (e.g. spanner.CommitTimestamp in Go) to this column", severity: note, docURL: readmeURL + "commit-timestamps"},
+	datetime:                  {brief: "Spanner timestamp is a point in time, whereas datetime values have no time zone, so they are converted as times in the configured zone", severity: note, batch: true, docURL: readmeURL + "timestamps-and-timezones"},
+	defaultValue:              {brief: "Some columns have default values which Spanner does not support", severity: warning, batch: true, docURL: readmeURL + "default-values"},
+	domain:                    {brief: "Spanner has no domains, so columns are mapped using the domain's base type, and its CHECK constraints are dropped", severity: note, batch: true, docURL: readmeURL + "domains"},
+	dupCopy:                   {brief: "Concatenated dump files can repeat a table's data. If the blocks have different rows, they can be appended instead", severity: note, docURL: noDocURL},
+	dynamoDocument:            {brief: "Spanner has no document type, so lists, maps and sets are stored as JSON text (with binary values in base64)", severity: note, batch: true, docURL: readmeURL + "dynamodb"},
+	dynamoFloat:               {brief: "Some sampled numbers don't fit a Spanner numeric (at most 29 digits before and 9 digits after the decimal point), so the column is FLOAT64, which keeps about 16 significant digits", severity: warning, docURL: readmeURL + "dynamodb"},
+	dynamoMixedTypes:          {brief: "Values of all types are stored as strings (numbers as their digits, binary values in base64, and lists, maps and sets as JSON), and values of types that weren't sampled are bad rows", severity: warning, docURL: readmeURL + "dynamodb"},
+	enum:                      {brief: "Spanner doesn't restrict the column to these values, so the application must enforce this", severity: note, docURL: readmeURL + "enum-types"},
+	floatSpecial:              {brief: "FLOAT64 can't hold values beyond its range, and applications may not expect NaN or infinities", severity: warning, docURL: readmeURL + "floating-point-values"},
+	foreignKey:                {brief: "Spanner creates backing indexes for foreign keys, which use storage and add to the cost of writes", severity: note, docURL: readmeURL + "foreign-keys"},
+	foreignKeyUnsupported:     {brief: "Referential integrity for this relationship will not be enforced by Spanner", severity: warning, docURL: readmeURL + "foreign-keys"},
+	hotspot:                   {brief: "Spanner splits tables by primary key range, so keys that increase over time send all writes of new rows to a single split. Consider a UUID key, a bit-reversed sequence, or putting a well-distributed column (e.g. a hash of this column) first in the key", severity: warning, docURL: readmeURL + "primary-keys"},
+	indexUnsupported:          {brief: "Queries that use this index may be slow in Spanner. Consider an alternative index (e.g. on a generated column)", severity: warning, docURL: readmeURL + "indexes"},
+	inherited:                 {brief: "Spanner has no table inheritance, so queries of the parent tables won't include rows of this table", severity: warning, docURL: readmeURL + "inheritance"},
+	inheritedMerged:           {brief: "Spanner has no table inheritance, so queries of this table now include the rows of merged tables (filter on the discriminator column to get the rows of a single table)", severity: warning, docURL: readmeURL + "inheritance"},
+	invalidUTF8:               {brief: "Spanner STRING values must be valid UTF-8, so source data in other encodings (e.g. Latin-1) must be fixed or transcoded", severity: warning, docURL: readmeURL + "character-encodings"},
+	missingPrimaryKey:         {brief: "Spanner requires a primary key for every table", severity: warning, docURL: readmeURL + "primary-keys"},
+	multiDimensionalArray:     {brief: "Spanner doesn't support multi-dimensional arrays", severity: warning, docURL: readmeURL + "arrays"},
+	noGoodType:                {brief: "No appropriate Spanner type", severity: warning, docURL: noDocURL},
+	numeric:                   {brief: "Spanner numeric has at most 29 digits before and 9 digits after the decimal point, so values outside this range can't be converted", severity: warning, docURL: readmeURL + "numeric"},
+	numericOutOfRange:         {brief: "Spanner numeric has at most 29 digits before and 9 digits after the decimal point, which can't hold all values of this type, so they are stored as strings", severity: warning, docURL: readmeURL + "numeric"},
+	numericThatFits:           {brief: "Spanner numeric has at most 29 digits before and 9 digits after the decimal point, which holds all values of this type", severity: note, docURL: readmeURL + "numeric"},
+	orderingChanged:           {brief: "Ordering and comparison semantics change, which affects range scans, pagination and uniqueness that depend on this key", severity: warning, docURL: readmeURL + "primary-keys"},
+	partitioned:               {brief: "Spanner splits tables into ranges of rows automatically, so partitions aren't needed", severity: note, docURL: readmeURL + "partitioned-tables"},
+	piiKey:                    {brief: "Personal data makes a poor key: keys appear in logs and traces, can't be encrypted separately, and can hotspot. Consider using a surrogate key (with a secondary index on these columns if needed)", severity: note, batch: true, docURL: noDocURL},
+	renamed:                   {brief: "Spanner names must start with a letter, use only letters, digits and '_', be at most 128 characters long, and not be reserved words", severity: note, docURL: readmeURL + "names"},
+	rowDeletionPolicy:         {brief: "Rows are deleted by Spanner once their timestamp column is older than the policy's interval", severity: note, docURL: spannerTTLURL},
+	rowDeletionPolicyNullable: {brief: "Rows where this column is NULL will never be deleted", severity: warning, docURL: spannerTTLURL},
+	rowVersion:                {brief: "Spanner has no rowversion type, so values are copied as bytes, but aren't updated when rows change, and new rows don't get them", severity: warning, docURL: readmeURL + "sql-server-support"},
+	serial:                    {brief: "Spanner does not support autoincrementing types", severity: warning, docURL: readmeURL + "bigserial-and-serial"},
+	stringBounded:             {brief: "STRING(MAX) was avoided, as configured, so longer values can't be stored", severity: note, batch: true, docURL: readmeURL + "string-lengths"},
+	stringOverflow:            {brief: "Spanner STRING(N) columns can't hold values longer than N characters", severity: warning, docURL: readmeURL + "string-lengths"},
+	timestamp:                 {brief: "Spanner timestamp is closer to PostgreSQL timestamptz", severity: note, batch: true, docURL: readmeURL + "timestamp"},
+	timestampRange:            {brief: "Spanner timestamps must be in years 1 to 9999", severity: warning, docURL: readmeURL + "timestamp"},
+	transformed:               {brief: "Spanner gets the transformed values, not the source values, so these columns can't be compared with the source", severity: note, docURL: noDocURL},
+	typeOverride:              {brief: "Values that can't be converted to this type will be counted as bad rows", severity: note, docURL: noDocURL},
+	uniqueUnsupported:         {brief: "Spanner won't enforce uniqueness of these columns, so the application must", severity: warning, docURL: readmeURL + "indexes"},
+	widened:                   {brief: "Some columns will consume more storage in Spanner", severity: note, batch: true, docURL: readmeURL + "storage-use"},
+}
+
+const (
+	readmeURL     = "https://github.com/cloudspannerecosystem/harbourbridge#"
+	spannerTTLURL = "https://cloud.google.com/spanner/docs/ttl"
+	noDocURL      = "none"
+)
+
+// issueDocURL returns the URL of further documentation of issue i
+// (empty if there is none).
+func issueDocURL(i schemaIssue) string {
+	if u := issueDB[i].docURL; u != noDocURL {
+		return u
+	}
+	return ""
+}
+
+// docLinker links each issue type to its documentation once per table:
+// url returns u, the doc URL of the issue named i, the first time the
+// issue is seen, and "" after that.
+type docLinker map[string]bool
+
+func (d docLinker) url(i, u string) string {
+	if d[i] {
+		return ""
+	}
+	d[i] = true
+	return u
 }
 
 type severity int
@@ -254,9 +738,23 @@ const (
 	note
 )
 
+// String returns the name of a severity, as used in structured reports.
+func (s severity) String() string {
+	switch s {
+	case warning:
+		return "warning"
+	case note:
+		return "note"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
 // analyzeCols returns information about the quality of schema mappings
-// for table 'srcTable'. It assumes 'srcTable' is in the conv.srcSchema map.
-func analyzeCols(conv *Conv, srcTable, spTable string) (map[string][]schemaIssue, int64, int64) {
+// for table 'srcTable': the issues of each column, the number of
+// columns, the number of warnings (for rating the table), and the
+// number of columns with each issue (for the summary of issues by
+// type). It assumes 'srcTable' is in the conv.srcSchema map.
+func analyzeCols(conv *Conv, srcTable, spTable string) (map[string][]schemaIssue, int64, int64, map[schemaIssue]int64) {
 	srcSchema := conv.srcSchema[srcTable]
 	m := make(map[string][]schemaIssue)
 	warnings := int64(0)
@@ -267,12 +765,15 @@ func analyzeCols(conv *Conv, srcTable, spTable string) (map[string][]schemaIssue
 	// batched warnings: count at most one warning per table.
 	for c, l := range conv.issues[srcTable] {
 		colWarning := false
-		m[c] = l
 		for _, i := range l {
+			if conv.issueSuppressed(i) {
+				continue
+			}
+			m[c] = append(m[c], i)
 			switch {
-			case issueDB[i].severity == warning && issueDB[i].batch:
+			case conv.severity(i) == warning && issueDB[i].batch:
 				warningBatcher[i] = true
-			case issueDB[i].severity == warning && !issueDB[i].batch:
+			case conv.severity(i) == warning && !issueDB[i].batch:
 				colWarning = true
 			}
 		}
@@ -280,137 +781,544 @@ func analyzeCols(conv *Conv, srcTable, spTable string) (map[string][]schemaIssue
 			warnings++
 		}
 	}
+	// Issues for groups of columns count once for the whole group.
+	for _, g := range conv.groupIssues[srcTable] {
+		switch {
+		case conv.issueSuppressed(g.issue):
+		case conv.severity(g.issue) == warning && issueDB[g.issue].batch:
+			warningBatcher[g.issue] = true
+		case conv.severity(g.issue) == warning && !issueDB[g.issue].batch:
+			warnings++
+		}
+	}
 	warnings += int64(len(warningBatcher))
-	return m, int64(len(srcSchema.ColDefs)), warnings
+	for _, p := range conv.detectPIIKeys(srcTable) {
+		warnings += conv.addColIssue(m, p.col, piiKey)
+	}
+	if h, ok := conv.detectHotspotKey(srcTable); ok {
+		warnings += conv.addColIssue(m, h.col, hotspot)
+	}
+	// Values that were too long for their columns, or out of range, are
+	// only known after data conversion.
+	for c := range conv.overflows[srcTable] {
+		warnings += conv.addColIssue(m, c, stringOverflow)
+	}
+	for c := range conv.tsOutOfRange[srcTable] {
+		warnings += conv.addColIssue(m, c, timestampRange)
+	}
+	for c := range conv.floatSpecials[srcTable] {
+		warnings += conv.addColIssue(m, c, floatSpecial)
+	}
+	for c := range conv.invalidUTF8[srcTable] {
+		warnings += conv.addColIssue(m, c, invalidUTF8)
+	}
+	for c := range conv.multiDimRows[srcTable] {
+		warnings += conv.addColIssue(m, c, multiDimensionalArray)
+	}
+	return m, int64(len(srcSchema.ColDefs)), warnings, issueCols(conv, srcTable, spTable, m)
+}
+
+// issueCols returns the number of columns of srcTable with each issue,
+// given the issues of each column m. Unlike the warning count, this
+// includes every instance of batched issues, and issues for groups of
+// columns count each column in the group.
+func issueCols(conv *Conv, srcTable, spTable string, m map[string][]schemaIssue) map[schemaIssue]int64 {
+	cols := make(map[schemaIssue]map[string]bool)
+	add := func(i schemaIssue, col string) {
+		if cols[i] == nil {
+			cols[i] = make(map[string]bool)
+		}
+		cols[i][col] = true
+	}
+	for c, l := range m {
+		for _, i := range l {
+			add(i, c)
+		}
+	}
+	for _, g := range conv.groupIssues[srcTable] {
+		if conv.issueSuppressed(g.issue) {
+			continue
+		}
+		for _, c := range g.cols {
+			add(g.issue, c)
+		}
+		if len(g.cols) == 0 {
+			add(g.issue, "")
+		}
+	}
+	if pk, ok := conv.syntheticPKeys[spTable]; ok {
+		add(missingPrimaryKey, pk.col)
+	}
+	counts := make(map[schemaIssue]int64)
+	for i, c := range cols {
+		counts[i] = int64(len(c))
+	}
+	return counts
 }
 
-// rateSchema returns an string summarizing the quality of source DB
-// to Spanner schema conversion. 'cols' and 'warnings' are respectively
-// the number of columns converted and the warnings encountered
-// (both weighted by number of data rows).
-// 'missingPKey' indicates whether the source DB schema had a primary key.
+// addColIssue adds issue i to the issues of column col in m (unless it
+// is suppressed), returning the number of warnings this adds to col's
+// table: zero if i isn't a warning, or col already has a (non-batched)
+// warning.
+func (conv *Conv) addColIssue(m map[string][]schemaIssue, col string, i schemaIssue) int64 {
+	if conv.issueSuppressed(i) {
+		return 0
+	}
+	colWarning := false
+	for _, j := range m[col] {
+		colWarning = colWarning || (conv.severity(j) == warning && !issueDB[j].batch)
+	}
+	m[col] = append(append([]schemaIssue{}, m[col]...), i)
+	if colWarning || conv.severity(i) != warning {
+		return 0
+	}
+	return 1
+}
+
+// reported returns whether issue i is reported in the section of a
+// table's report for severity s.
+func (conv *Conv) reported(i schemaIssue, s severity) bool {
+	return !conv.issueSuppressed(i) && conv.severity(i) == s
+}
+
+// Rating is a category of conversion quality. Ratings are ordered:
+// a higher rating is better, so ratings can be compared directly.
+type Rating int
+
+const (
+	// RatingNone means there was nothing to rate (e.g. no data rows).
+	RatingNone Rating = iota
+	// RatingSkipped means the conversion step was deliberately not run
+	// (e.g. data conversion for a schema-only conversion).
+	RatingSkipped
+	// RatingInconsistent means the row counts don't add up (e.g. more
+	// bad rows than rows), so the data conversion can't be rated. It is
+	// below RatingPoor, since the counts need investigating.
+	RatingInconsistent
+	RatingPoor
+	RatingOK
+	RatingGood
+	RatingExcellent
+)
+
+// String returns the name of a rating, as used in reports e.g. "GOOD".
+func (r Rating) String() string {
+	switch r {
+	case RatingNone:
+		return "NONE"
+	case RatingSkipped:
+		return "SKIPPED"
+	case RatingInconsistent:
+		return "INCONSISTENT"
+	case RatingPoor:
+		return "POOR"
+	case RatingOK:
+		return "OK"
+	case RatingGood:
+		return "GOOD"
+	case RatingExcellent:
+		return "EXCELLENT"
+	}
+	return fmt.Sprintf("Rating(%d)", int(r))
+}
+
+// ParseRating parses a rating name (case insensitive). RatingNone,
+// RatingSkipped and RatingInconsistent can't be parsed since they aren't
+// levels of quality.
+func ParseRating(s string) (Rating, error) {
+	for _, r := range []Rating{RatingPoor, RatingOK, RatingGood, RatingExcellent} {
+		if strings.EqualFold(s, r.String()) {
+			return r, nil
+		}
+	}
+	return RatingNone, fmt.Errorf("unknown rating %q: must be one of excellent, good, ok or poor", s)
+}
+
+// schemaCounts are the inputs to a schema rating.
+type schemaCounts struct {
+	cols, warnings int64
+	missingPKey    bool // Whether the source DB schema lacked a primary key.
+	// For overall ratings: rows of the tables with warnings, and of all
+	// tables (zero if there are no rows).
+	warnRows, rows int64
+
+	// Warnings are weighted for rating (see analyzeCols) e.g. a column
+	// with several warnings counts once, so descriptions instead give
+	// the number of warnings listed in the report.
+	listed int64
+}
+
+// rateSchema rates the quality of source DB to Spanner schema
+// conversion with thresholds th, and returns the rating and a string
+// summarizing it. The string gives the number of listed warnings and columns
+// (and for overall ratings, the rows of the tables with warnings), so
+// that users can judge for themselves.
 // 'summary' indicates whether this is a per-table rating or an overall
 // summary rating.
-func rateSchema(cols, warnings int64, missingPKey, summary bool) string {
+func rateSchema(c schemaCounts, summary bool, th RatingThresholds) (Rating, string) {
 	pkMsg := "missing primary key"
 	if summary {
 		pkMsg = "some missing primary keys"
 	}
+	cols, warnings, missingPKey := c.cols, c.warnings, c.missingPKey
+	var r Rating
+	var s string
 	switch {
 	case cols == 0:
-		return "NONE (no schema found)"
+		r, s = RatingNone, "no schema found"
 	case warnings == 0 && !missingPKey:
-		return "EXCELLENT (all columns mapped cleanly)"
+		r, s = RatingExcellent, "all columns mapped cleanly"
 	case warnings == 0 && missingPKey:
-		return fmt.Sprintf("GOOD (all columns mapped cleanly, but %s)", pkMsg)
-	case good(cols, warnings) && !missingPKey:
-		return "GOOD (most columns mapped cleanly)"
-	case good(cols, warnings) && missingPKey:
-		return fmt.Sprintf("GOOD (most columns mapped cleanly, but %s)", pkMsg)
-	case ok(cols, warnings) && !missingPKey:
-		return "OK (some columns did not map cleanly)"
-	case ok(cols, warnings) && missingPKey:
-		return fmt.Sprintf("OK (some columns did not map cleanly + %s)", pkMsg)
+		r, s = RatingGood, fmt.Sprintf("all columns mapped cleanly, but %s", pkMsg)
+	case th.good(cols, warnings) && !missingPKey:
+		r, s = RatingGood, "most columns mapped cleanly"
+	case th.good(cols, warnings) && missingPKey:
+		r, s = RatingGood, fmt.Sprintf("most columns mapped cleanly, but %s", pkMsg)
+	case th.ok(cols, warnings) && !missingPKey:
+		r, s = RatingOK, "some columns did not map cleanly"
+	case th.ok(cols, warnings) && missingPKey:
+		r, s = RatingOK, fmt.Sprintf("some columns did not map cleanly + %s", pkMsg)
 	case !missingPKey:
-		return "POOR (many columns did not map cleanly)"
+		r, s = RatingPoor, "many columns did not map cleanly"
 	default:
-		return fmt.Sprintf("POOR (many columns did not map cleanly + %s)", pkMsg)
+		r, s = RatingPoor, fmt.Sprintf("many columns did not map cleanly + %s", pkMsg)
+	}
+	if cols > 0 && warnings > 0 {
+		s += fmt.Sprintf("; %s across %s", plural(c.listed, "warning"), plural(cols, "column"))
+		if summary && c.rows > 0 {
+			s += fmt.Sprintf(", affecting %s of rows", rowsPct(c.warnRows, c.rows))
+		}
 	}
+	return r, fmt.Sprintf("%s (%s)", r, s)
 }
 
-func rateData(rows int64, badRows int64) string {
-	s := fmt.Sprintf(" (%s%% of %d rows written to Spanner)", pct(rows, badRows), rows)
+// plural returns n and noun, pluralized if n isn't 1 e.g. "3 warnings".
+func plural(n int64, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// rowsPct returns n of total rows as a whole percentage, without
+// rounding a few rows to 0% or nearly all rows to 100%.
+func rowsPct(n, total int64) string {
+	p := 100 * float64(n) / float64(total)
 	switch {
+	case n > 0 && p < 1:
+		return "<1%"
+	case n < total && p > 99:
+		return ">99%"
+	}
+	return fmt.Sprintf("%.0f%%", p)
+}
+
+// rateData rates the quality of data conversion, and returns the
+// rating and a string summarizing it. If skipped is true, data
+// conversion wasn't run, so there is nothing to rate. If badRows
+// exceeds rows, the counts are wrong (see fillRowStats), so the rating
+// is RatingInconsistent.
+// rateData rates data conversion of rows, of which badRows weren't
+// written. written says how data is written (see Conv.WrittenTo).
+func rateData(rows int64, badRows int64, skipped bool, written string, th RatingThresholds) (Rating, string) {
+	s := fmt.Sprintf("%s%% of %d rows %s", pct(rows, badRows), rows, written)
+	var r Rating
+	switch {
+	case skipped:
+		r, s = RatingSkipped, "data conversion not run"
+	case badRows > rows:
+		r, s = RatingInconsistent, "bad row count exceeds total rows — see Unexpected Conditions"
 	case rows == 0:
-		return "NONE (no data rows found)"
+		r, s = RatingNone, "no data rows found"
 	case badRows == 0:
-		return fmt.Sprintf("EXCELLENT (all %d rows written to Spanner)", rows)
-	case good(rows, badRows):
-		return "GOOD" + s
-	case ok(rows, badRows):
-		return "OK" + s
+		r, s = RatingExcellent, fmt.Sprintf("all %d rows %s", rows, written)
+	case th.good(rows, badRows):
+		r = RatingGood
+	case th.ok(rows, badRows):
+		r = RatingOK
 	default:
-		return "POOR" + s
+		r = RatingPoor
+	}
+	return r, fmt.Sprintf("%s (%s)", r, s)
+}
+
+// dryRunSummary is the first line of the report summary for dry runs
+// (see Conv.SetDryRun).
+const dryRunSummary = "DRY RUN — no data was written to Spanner"
+
+// tooLargeMsg describes n rows that weren't written because they exceed
+// Spanner's commit size limit. Returns "" if n is zero.
+func tooLargeMsg(n int64) string {
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return "1 row exceeded Spanner's commit size limit"
+	}
+	return fmt.Sprintf("%d rows exceeded Spanner's commit size limit", n)
+}
+
+// badRowsLoggedMsg describes n bad rows of a table written to the
+// bad-rows file path. Returns "" if n is zero or there is no file.
+func badRowsLoggedMsg(path string, n int64) string {
+	if n == 0 || path == "" {
+		return ""
+	}
+	return fmt.Sprintf("Bad rows written to %s: %d", path, n)
+}
+
+// badRowsFileSummary describes the bad-rows file (if any) for the
+// report summary.
+// hotspotSummary returns a summary of the tables whose primary keys
+// may hotspot, or "" if there are none.
+func hotspotSummary(r []tableReport) string {
+	n := len(hotspotTables(r))
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Tables at risk of hotspots: %d (their primary keys increase over time; see the warnings for each table)", n)
+}
+
+// hotspotTables returns the source tables whose primary keys may
+// hotspot, in report order.
+func hotspotTables(r []tableReport) []string {
+	var l []string
+	for _, t := range r {
+		if t.hotspotKey != "" {
+			l = append(l, t.srcTable)
+		}
 	}
+	return l
 }
 
-func good(total, badCount int64) bool {
-	return badCount < total/20
+func badRowsFileSummary(conv *Conv) []string {
+	w := conv.badRowsOut
+	if w == nil {
+		return nil
+	}
+	rows, truncated := w.summary()
+	l := []string{fmt.Sprintf("Bad rows written to %s: %d", w.name, rows)}
+	if truncated > 0 {
+		l = append(l, fmt.Sprintf("Note: %s is incomplete: %d bad rows were not written to it (its size limit is %d bytes)", w.name, truncated, w.limit))
+	}
+	return l
 }
 
-func ok(total, badCount int64) bool {
-	return badCount < total/3
+func rateConversion(rows, badRows int64, sc schemaCounts, summary, dataSkipped bool, written string, th RatingThresholds) string {
+	_, schema := rateSchema(sc, summary, th)
+	_, data := rateData(rows, badRows, dataSkipped, written, th)
+	return fmt.Sprintf("Schema conversion: %s.\n", schema) +
+		fmt.Sprintf("Data conversion: %s.\n", data)
 }
 
-func rateConversion(rows, badRows, cols, warnings int64, missingPKey, summary bool) string {
-	return fmt.Sprintf("Schema conversion: %s.\n", rateSchema(cols, warnings, missingPKey, summary)) +
-		fmt.Sprintf("Data conversion: %s.\n", rateData(rows, badRows))
+// GenerateSummary returns the brief summary of a conversion that
+// GenerateReport writes at the top of the report.
+func GenerateSummary(conv *Conv, badWrites map[string]int64) string {
+	return generateSummary(conv, analyzeTables(conv, badWrites), badWrites)
+}
+
+// Ratings are the overall schema and data ratings of a conversion.
+type Ratings struct {
+	Schema     Rating
+	Data       Rating
+	SchemaDesc string // e.g. "GOOD (most columns mapped cleanly)".
+	DataDesc   string // e.g. "EXCELLENT (all 1000 rows written to Spanner)".
+}
+
+// OverallRatings returns the overall schema and data conversion ratings.
+func OverallRatings(conv *Conv, badWrites map[string]int64) Ratings {
+	s := summarize(conv, analyzeTables(conv, badWrites), badWrites)
+	var r Ratings
+	r.Schema, r.SchemaDesc = rateSchema(s.schema, true, conv.ratingThresholds())
+	r.Data, r.DataDesc = rateData(s.rows, s.badRows, s.dataSkipped, conv.WrittenTo(), conv.ratingThresholds())
+	return r
 }
 
 func generateSummary(conv *Conv, r []tableReport, badWrites map[string]int64) string {
-	cols := int64(0)
-	warnings := int64(0)
-	missingPKey := false
+	s := summarize(conv, r, badWrites)
+	summary := rateConversion(s.rows, s.badRows, s.schema, true, s.dataSkipped, conv.WrittenTo(), conv.ratingThresholds())
+	if conv.dryRun {
+		summary = dryRunSummary + ".\n" + summary
+	}
+	if msg := encodingWarning(conv); msg != "" {
+		summary = msg + ".\n" + summary
+	}
+	if conv.database != "" {
+		summary = fmt.Sprintf("Spanner database: %s\n", conv.database) + summary
+	}
+	if msg := interruptSummary(conv); msg != "" {
+		// First, so that it can't be missed.
+		summary = msg + ".\n" + summary
+	}
+	if tp := formatThroughput(conv.totalTiming()); tp != "" {
+		summary += fmt.Sprintf("Data conversion time: %s.\n", tp)
+	}
+	if msg := storageMsg(conv.totalStorage()); msg != "" {
+		summary += msg + " (all tables).\n"
+	}
+	if msg := samplingSummary(conv); msg != "" {
+		summary += msg + ".\n"
+	}
+	if msg := hotspotSummary(r); msg != "" {
+		summary += msg + ".\n"
+	}
+	if msg := renameSummary(conv); msg != "" {
+		summary += msg + ".\n"
+	}
+	if msg := verifySummary(verification(conv, badWrites)); msg != "" {
+		summary += msg + ".\n"
+	}
+	if msg := ddlValidationSummary(conv); msg != "" {
+		summary += msg + ".\n"
+	}
+	if msg := existingDBSummary(conv); msg != "" {
+		summary += msg + ".\n"
+	}
+	if msg := ddlBatchSummary(conv); msg != "" {
+		summary += msg + ".\n"
+	}
+	if msg := ddlSummary(conv); msg != "" {
+		summary += msg + ".\n"
+	}
+	for _, l := range badRowsFileSummary(conv) {
+		summary += l + ".\n"
+	}
+	if conv.stats.resumed > 0 {
+		summary += fmt.Sprintf("Resumed from checkpoint: %d rows were processed by previous runs, and skipped by this one.\n", conv.stats.resumed)
+	}
+	if conv.stats.retries > 0 {
+		summary += fmt.Sprintf("Commit retries: %d (writes to Spanner that failed with transient errors, and were retried).\n", conv.stats.retries)
+	}
+	return summary
+}
+
+// summaryStats are the inputs to the overall conversion rating.
+type summaryStats struct {
+	rows, badRows int64
+	schema        schemaCounts
+	dataSkipped   bool
+}
+
+func summarize(conv *Conv, r []tableReport, badWrites map[string]int64) summaryStats {
+	// Columns and warnings aren't weighted by rows, so that a warning
+	// on a single column of a huge table doesn't dominate the rating.
+	// Instead, the rating gives the rows of tables with warnings.
+	var sc schemaCounts
 	for _, t := range r {
-		weight := t.rows // Weight col data by how many rows in table.
-		if weight == 0 { // Tables without data count as if they had one row.
-			weight = 1
+		sc.cols += t.cols
+		sc.warnings += t.warnings
+		sc.listed += t.listed
+		sc.rows += t.rows
+		if t.warnings > 0 {
+			sc.warnRows += t.rows
 		}
-		cols += t.cols * weight
-		warnings += t.warnings * weight
 		if t.syntheticPKey != "" {
-			missingPKey = true
+			sc.missingPKey = true
 		}
 	}
 	// Don't use tableReport for rows/badRows stats because tableReport
 	// provides per-table stats for each table in the schema i.e. it omits
 	// rows for tables not in the schema. To handle this corner-case, use
 	// the source of truth for row stats: conv.stats.
-	rows := conv.Rows()
+	// With row sampling, we only rate the rows in the sample.
+	rows := conv.Rows() - conv.unsampledRows("") - conv.unreadRows("")
 	badRows := conv.BadRows() // Bad rows encountered during data conversion.
 	// Add in bad rows while writing to Spanner.
 	for _, n := range badWrites {
 		badRows += n
 	}
-	return rateConversion(rows, badRows, cols, warnings, missingPKey, true)
+	return summaryStats{rows: rows, badRows: badRows, schema: sc, dataSkipped: conv.dataSkipped}
+}
+
+// issueCount is the number of tables and columns with a schema issue.
+type issueCount struct {
+	issue    schemaIssue
+	severity severity
+	tables   int64
+	cols     int64
+}
+
+// issueSummary aggregates the schema issues of tables r by issue type,
+// most common first, so that issues that affect many tables are visible
+// without reading every table's section of the report.
+func issueSummary(conv *Conv, r []tableReport) []issueCount {
+	m := make(map[schemaIssue]*issueCount)
+	for _, t := range r {
+		for i, n := range t.issueCols {
+			if m[i] == nil {
+				m[i] = &issueCount{issue: i, severity: conv.severity(i)}
+			}
+			m[i].tables++
+			m[i].cols += n
+		}
+	}
+	var l []issueCount
+	for _, c := range m {
+		l = append(l, *c)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		switch {
+		case l[i].tables != l[j].tables:
+			return l[i].tables > l[j].tables
+		case l[i].cols != l[j].cols:
+			return l[i].cols > l[j].cols
+		}
+		return l[i].issue.String() < l[j].issue.String()
+	})
+	return l
+}
+
+func writeIssueSummary(l []ReportIssueCount, w *bufio.Writer) {
+	writeHeading(w, "Issues by Type")
+	w.WriteString("Schema conversion issues aggregated over all tables, most common first.\n")
+	w.WriteString("Each table's section of the report describes its issues.\n")
+	w.WriteString("  --------------------------------------\n")
+	fmt.Fprintf(w, "  %6s  %7s  %-8s  %s\n", "tables", "columns", "severity", "issue")
+	w.WriteString("  --------------------------------------\n")
+	for _, c := range l {
+		fmt.Fprintf(w, "  %6d  %7d  %-8s  %s\n", c.Tables, c.Columns, c.Severity, c.Issue)
+	}
+	w.WriteString("\n")
 }
 
 func ignoredStatements(conv *Conv) (l []string) {
+	// Iterate over statements in sorted order (rather than map order)
+	// so that the result doesn't depend on map iteration, even if
+	// several statement types share a description.
+	var stmts []string
 	for s := range conv.stats.statement {
+		stmts = append(stmts, s)
+	}
+	sort.Strings(stmts)
+	seen := make(map[string]bool)
+	for _, s := range stmts {
+		var d string
 		switch s {
-		case "CreateFunctionStmt":
-			l = append(l, "functions")
+		case "CreateFunctionStmt", "CREATE FUNCTION":
+			d = "functions"
 		case "CreateSeqStmt":
-			l = append(l, "sequences")
-		case "CreatePLangStmt":
-			l = append(l, "procedures")
-		case "CreateTrigStmt":
-			l = append(l, "triggers")
-		case "IndexStmt":
-			l = append(l, "(non-primary) indexes")
-		case "ViewStmt":
-			l = append(l, "views")
+			d = "sequences"
+		case "CreatePLangStmt", "CREATE PROCEDURE":
+			d = "procedures"
+		case "CreateTrigStmt", "CREATE TRIGGER":
+			d = "triggers"
+		case "ViewStmt", "CREATE VIEW":
+			d = "views"
+		}
+		if d != "" && !seen[d] {
+			seen[d] = true
+			l = append(l, d)
 		}
 	}
 	sort.Strings(l)
 	return l
 }
 
-func writeStmtStats(conv *Conv, w *bufio.Writer) {
-	type stat struct {
-		statement string
-		count     int64
-	}
-	var l []stat
-	for s, x := range conv.stats.statement {
-		l = append(l, stat{s, x.schema + x.data + x.skip + x.error})
-	}
-	// Sort by alphabetical order of statements.
-	sort.Slice(l, func(i, j int) bool {
-		return l[i].statement < l[j].statement
-	})
+// writeStmtStats writes the statement stats of r, which are in
+// alphabetical order of statements.
+func writeStmtStats(src Source, r *Report, w *bufio.Writer) {
 	writeHeading(w, "Statements Processed")
-	w.WriteString("Analysis of statements in pg_dump output, broken down by statement type.\n")
+	fmt.Fprintf(w, "Analysis of statements in %s output, broken down by statement type.\n", src.Name)
 	w.WriteString("  schema: statements successfully processed for Spanner schema information.\n")
 	w.WriteString("    data: statements successfully processed for data.\n")
 	w.WriteString("    skip: statements not relevant for Spanner schema or data.\n")
@@ -418,73 +1326,169 @@ func writeStmtStats(conv *Conv, w *bufio.Writer) {
 	w.WriteString("  --------------------------------------\n")
 	fmt.Fprintf(w, "  %6s %6s %6s %6s  %s\n", "schema", "data", "skip", "error", "statement")
 	w.WriteString("  --------------------------------------\n")
-	for _, x := range l {
-		s := conv.stats.statement[x.statement]
-		fmt.Fprintf(w, "  %6d %6d %6d %6d  %s\n", s.schema, s.data, s.skip, s.error, x.statement)
+	for _, s := range r.StatementStats {
+		fmt.Fprintf(w, "  %6d %6d %6d %6d  %s\n", s.Schema, s.Data, s.Skip, s.Error, s.Statement)
+	}
+	if msg := tableDefsMsg(r.DroppedTableDefs, r.DuplicateTableDefs); msg != "" {
+		w.WriteString(msg + "\n")
+	}
+	if len(r.DumpFiles) > 0 {
+		w.WriteString("Statements by dump file:\n")
+		for _, f := range r.DumpFiles {
+			fmt.Fprintf(w, "  %6d %6d %6d %6d  %s\n", f.Schema, f.Data, f.Skip, f.Error, f.File)
+		}
+	}
+	if src.StmtTypes != "" {
+		w.WriteString(src.StmtTypes + "\n")
+	}
+	w.WriteString("\n")
+}
+
+func writeColumnStats(l []columnStatsSummary, w *bufio.Writer) {
+	fmt.Fprintf(w, "Column statistics (approximate: distinct counts have a standard error of %.1f%%)\n", 100*hllStdError)
+	n := len("column")
+	for _, cs := range l {
+		if len(cs.Col) > n {
+			n = len(cs.Col)
+		}
+	}
+	fmt.Fprintf(w, "  %-*s  %7s  %10s\n", n, "column", "null%", "distinct")
+	for _, cs := range l {
+		fmt.Fprintf(w, "  %-*s  %6.1f%%  %10s\n", n, cs.Col, 100*cs.NullFrac, fmt.Sprintf("~%d", cs.Distinct))
+	}
+	w.WriteString("\n")
+}
+
+func writeResourceUsage(u ResourceUsage, w *bufio.Writer) {
+	writeHeading(w, "Resource Usage")
+	w.WriteString("High-water marks for resources used during this run (useful for\n")
+	w.WriteString("capacity planning of migration hosts).\n")
+	fmt.Fprintf(w, "  Peak memory (RSS):            %s\n", formatBytes(u.PeakRSS))
+	fmt.Fprintf(w, "  Peak Go heap:                 %s\n", formatBytes(u.PeakHeap))
+	fmt.Fprintf(w, "  Peak goroutines:              %d\n", u.PeakGoroutines)
+	fmt.Fprintf(w, "  Bytes read from input:        %s\n", formatBytes(u.BytesRead))
+	fmt.Fprintf(w, "  Bytes written to temp files:  %s\n", formatBytes(u.TempFileBytes))
+	w.WriteString("\n")
+}
+
+func writeSchemaMismatch(mismatches []string, w *bufio.Writer) {
+	writeHeading(w, "Schema Mismatch")
+	justifyLines(w, "The Spanner schema (from a session file, or from the "+
+		"existing Spanner database for a data-only conversion) doesn't "+
+		"match the source database, so data conversion was not run. "+
+		"Either fix the session file or Spanner database, or regenerate "+
+		"them by re-running schema conversion. Problems found:", 80, 0)
+	w.WriteString("\n")
+	for i, p := range mismatches {
+		justifyLines(w, fmt.Sprintf("%d) %s.\n", i+1, p), 80, 3)
 	}
-	w.WriteString("See github.com/lfittl/pg_query_go/nodes for definitions of statement types\n")
-	w.WriteString("(lfittl/pg_query_go is the library we use for parsing pg_dump output).\n")
 	w.WriteString("\n")
 }
 
-func writeUnexpectedConditions(conv *Conv, w *bufio.Writer) {
+func writeUnexpectedConditions(src Source, r *Report, w *bufio.Writer) {
 	reparseInfo := func() {
-		if conv.stats.reparsed > 0 {
-			fmt.Fprintf(w, "Note: there were %d pg_dump reparse events while looking for statement boundaries.\n\n", conv.stats.reparsed)
+		if r.ReparseEvents > 0 {
+			fmt.Fprintf(w, "Note: there were %d pg_dump reparse events while looking for statement boundaries.\n\n", r.ReparseEvents)
 		}
 	}
 	writeHeading(w, "Unexpected Conditions")
-	if len(conv.stats.unexpected) == 0 {
+	if len(r.UnexpectedConditions) == 0 {
 		w.WriteString("There were no unexpected conditions encountered during processing.\n\n")
 		reparseInfo()
 		return
 	}
-	w.WriteString("For debugging only. This section provides details of unexpected conditions\n")
-	w.WriteString("encountered as we processed the pg_dump data. In particular, the AST node\n")
-	w.WriteString("representation used by the lfittl/pg_query_go library used for parsing\n")
-	w.WriteString("pg_dump output is highly permissive: almost any construct can appear at\n")
-	w.WriteString("any node in the AST tree. The list details all unexpected nodes and\n")
-	w.WriteString("conditions.\n")
+	if src.Unexpected != "" {
+		w.WriteString(src.Unexpected)
+	} else {
+		justifyLines(w, fmt.Sprintf("For debugging only. This section provides details of "+
+			"unexpected conditions encountered as we processed the %s data.\n", src.Name), 80, 0)
+	}
 	w.WriteString("  --------------------------------------\n")
 	fmt.Fprintf(w, "  %6s  %s\n", "count", "condition")
 	w.WriteString("  --------------------------------------\n")
-	for s, n := range conv.stats.unexpected {
-		fmt.Fprintf(w, "  %6d  %s\n", n, s)
+	for _, u := range r.UnexpectedConditions {
+		fmt.Fprintf(w, "  %6d  %s\n", u.Count, u.Condition)
+		if u.Offset != nil {
+			fmt.Fprintf(w, "          first at byte %d: %s\n", *u.Offset, u.Snippet)
+		}
 	}
 	w.WriteString("\n")
 	reparseInfo()
 }
 
+// sortedUnexpected returns unexpected conditions sorted by count (most
+// frequent first), then by condition, so that reports are deterministic.
+func sortedUnexpected(conv *Conv) []string {
+	var l []string
+	for s := range conv.stats.unexpected {
+		l = append(l, s)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		ni, nj := conv.stats.unexpected[l[i]].count, conv.stats.unexpected[l[j]].count
+		if ni != nj {
+			return ni > nj
+		}
+		return l[i] < l[j]
+	})
+	return l
+}
+
 // justifyLines writes s out to w, adding newlines between words
 // to keep line length under 'limit'. Newlines are indented
-// 'indent' spaces.
+// 'indent' spaces. Newlines in s are treated as hard line breaks.
+// Words are never split: a word too long to fit is started on a
+// fresh line and allowed to overrun the limit.
 func justifyLines(w *bufio.Writer, s string, limit int, indent int) {
 	n := 0
-	startOfLine := true
-	words := strings.Split(s, " ") // This only handles spaces (newlines, tabs ignored).
-	for _, x := range words {
-		if n+len(x) > limit && !startOfLine {
+	paragraphs := strings.Split(s, "\n")
+	for i, p := range paragraphs {
+		if i > 0 {
 			w.WriteString("\n")
+			if p == "" {
+				// Avoid trailing whitespace on blank lines and
+				// at the end of s.
+				n = 0
+				continue
+			}
 			w.WriteString(strings.Repeat(" ", indent))
 			n = indent
-			startOfLine = true
 		}
-		if startOfLine {
-			w.WriteString(x)
-			n += len(x)
-		} else {
-			w.WriteString(" " + x)
-			n += len(x) + 1
+		startOfLine := true
+		words := strings.Split(p, " ")
+		for j, x := range words {
+			// For consistency with lines that wrap, a line ending in a
+			// newline must fit the newline within the limit.
+			l := len(x)
+			if j == len(words)-1 && i < len(paragraphs)-1 {
+				l++
+			}
+			if n+l > limit && !startOfLine {
+				w.WriteString("\n")
+				w.WriteString(strings.Repeat(" ", indent))
+				n = indent
+				startOfLine = true
+			}
+			if startOfLine {
+				w.WriteString(x)
+				n += len(x)
+			} else {
+				w.WriteString(" " + x)
+				n += len(x) + 1
+			}
+			startOfLine = false
 		}
-		startOfLine = false
 	}
 }
 
-// pct prints a percentage representation of (total-bad)/total
+// pct prints a percentage representation of (total-bad)/total, clamped
+// to [0, 100] in case bad is out of range.
 func pct(total, bad int64) string {
-	if bad == 0 || total == 0 {
+	if bad <= 0 || total <= 0 {
 		return "100"
 	}
+	if bad > total {
+		bad = total
+	}
 	pct := 100.0 * float64(total-bad) / float64(total)
 	if pct > 99.9 {
 		return fmt.Sprintf("%2.5f", pct)
@@ -495,6 +1499,21 @@ func pct(total, bad int64) string {
 	return fmt.Sprintf("%2.0f", pct)
 }
 
+// formatBytes prints a human-readable representation of n bytes
+// using binary units e.g. "14.2 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func writeHeading(w *bufio.Writer, s string) {
 	w.WriteString(strings.Join([]string{
 		"----------------------------\n",
@@ -17,9 +17,14 @@ package internal
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -51,10 +56,10 @@ func TestReport(t *testing.T) {
 	conv.stats.goodRows = map[string]int64{"bad_schema": 990, "no_pk": 3000}
 	conv.stats.badRows = map[string]int64{"bad_schema": 10, "no_pk": 2000}
 	badWrites := map[string]int64{"bad_schema": 50, "no_pk": 0}
-	conv.stats.unexpected["Testing unexpected messages"] = 5
+	conv.stats.unexpected["Testing unexpected messages"] = &conditionStat{count: 5, offset: -1}
 	buf := new(bytes.Buffer)
 	w := bufio.NewWriter(buf)
-	GenerateReport(true, conv, w, badWrites)
+	GenerateReport(PgDumpSource, conv, w, badWrites)
 	w.Flush()
 	// Print copy of report to stdout (shows up when running go test -v).
 	fmt.Print(buf.String())
@@ -66,7 +71,7 @@ func TestReport(t *testing.T) {
 		`----------------------------
 Summary of Conversion
 ----------------------------
-Schema conversion: OK (some columns did not map cleanly + some missing primary keys).
+Schema conversion: POOR (many columns did not map cleanly + some missing primary keys; 4 warnings across 13 columns, affecting 17% of rows).
 Data conversion: POOR (66% of 6000 rows written to Spanner).
 
 The remainder of this report provides stats on the pg_dump statements processed,
@@ -74,6 +79,22 @@ followed by a table-by-table listing of schema and data conversion details. For
 background on the schema and data conversion process used, and explanations of
 the terms and notes used in this report, see HarbourBridge's README.
 
+----------------------------
+Issues by Type
+----------------------------
+Schema conversion issues aggregated over all tables, most common first.
+Each table's section of the report describes its issues.
+  --------------------------------------
+  tables  columns  severity  issue
+  --------------------------------------
+       2        3  note      widened
+       2        2  warning   missingPrimaryKey
+       1        1  warning   defaultValue
+       1        1  note      foreignKey
+       1        1  warning   multiDimensionalArray
+       1        1  warning   noGoodType
+       1        1  warning   numeric
+
 ----------------------------
 Statements Processed
 ----------------------------
@@ -92,33 +113,40 @@ See github.com/lfittl/pg_query_go/nodes for definitions of statement types
 ----------------------------
 Table bad_schema
 ----------------------------
-Schema conversion: POOR (many columns did not map cleanly + missing primary key).
+Schema conversion: POOR (many columns did not map cleanly + missing primary key; 3 warnings across 4 columns).
 Data conversion: OK (94% of 1000 rows written to Spanner).
+Bad rows: 50 write errors, 10 other.
 
 Warnings
 1) Column 'synth_id' was added because this table didn't have a primary key.
-   Spanner requires a primary key for every table.
-2) Column 'a': type numeric is mapped to float64. Spanner does not support
-   numeric. This type mapping could lose precision and is not recommended for
-   production use.
+   Spanner requires a primary key for every table. It is filled with a
+   bit-reversed sequence, to spread writes across the table (see
+   https://github.com/cloudspannerecosystem/harbourbridge#primary-keys).
+2) Column 'a': type numeric is mapped to numeric. Spanner numeric has at most 29
+   digits before and 9 digits after the decimal point, so values outside this
+   range can't be converted (see
+   https://github.com/cloudspannerecosystem/harbourbridge#numeric).
 3) Column 'c': type int4[4][2] is mapped to string(max). Spanner doesn't support
-   multi-dimensional arrays.
+   multi-dimensional arrays (see
+   https://github.com/cloudspannerecosystem/harbourbridge#arrays).
 4) Column 'd': type circle is mapped to string(max). No appropriate Spanner
    type.
 
 Note
 1) Some columns will consume more storage in Spanner e.g. for column 'b', source
-   DB type int4 is mapped to Spanner type int64.
+   DB type int4 is mapped to Spanner type int64 (see
+   https://github.com/cloudspannerecosystem/harbourbridge#storage-use).
 
 ----------------------------
 Table default_value
 ----------------------------
-Schema conversion: POOR (many columns did not map cleanly).
+Schema conversion: POOR (many columns did not map cleanly; 1 warning across 2 columns).
 Data conversion: NONE (no data rows found).
 
 Warning
 1) Some columns have default values which Spanner does not support e.g. column
-   'b'.
+   'b' (see
+   https://github.com/cloudspannerecosystem/harbourbridge#default-values).
 
 ----------------------------
 Table excellent_schema
@@ -129,25 +157,32 @@ Data conversion: NONE (no data rows found).
 ----------------------------
 Table foreign_key
 ----------------------------
-Schema conversion: POOR (many columns did not map cleanly).
+Schema conversion: EXCELLENT (all columns mapped cleanly).
 Data conversion: NONE (no data rows found).
 
-Warning
-1) Column 'a' uses foreign keys which Spanner does not support.
+Note
+1) The foreign key (a) referencing excellent_schema (a) was converted to a
+   Spanner foreign key. Spanner creates backing indexes for foreign keys, which
+   use storage and add to the cost of writes (see
+   https://github.com/cloudspannerecosystem/harbourbridge#foreign-keys).
 
 ----------------------------
 Table no_pk
 ----------------------------
 Schema conversion: GOOD (all columns mapped cleanly, but missing primary key).
 Data conversion: POOR (60% of 5000 rows written to Spanner).
+Bad rows: 2000 other.
 
 Warning
 1) Column 'synth_id' was added because this table didn't have a primary key.
-   Spanner requires a primary key for every table.
+   Spanner requires a primary key for every table. It is filled with a
+   bit-reversed sequence, to spread writes across the table (see
+   https://github.com/cloudspannerecosystem/harbourbridge#primary-keys).
 
 Note
 1) Some columns will consume more storage in Spanner e.g. for column 'b', source
-   DB type int4 is mapped to Spanner type int64.
+   DB type int4 is mapped to Spanner type int64 (see
+   https://github.com/cloudspannerecosystem/harbourbridge#storage-use).
 
 ----------------------------
 Unexpected Conditions
@@ -166,3 +201,650 @@ conditions.
 `
 	assert.Equal(t, expected, buf.String())
 }
+
+func TestReport_ResourceUsage(t *testing.T) {
+	conv := MakeConv()
+	conv.SetResourceUsage(ResourceUsage{
+		PeakRSS:        300 * 1024 * 1024,
+		PeakHeap:       120 * 1024 * 1024,
+		PeakGoroutines: 45,
+		BytesRead:      15 * 1024 * 1024 * 1024,
+		TempFileBytes:  2048,
+	})
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	writeResourceUsage(*conv.usage, w)
+	w.Flush()
+	expected :=
+		`----------------------------
+Resource Usage
+----------------------------
+High-water marks for resources used during this run (useful for
+capacity planning of migration hosts).
+  Peak memory (RSS):            300.0 MiB
+  Peak Go heap:                 120.0 MiB
+  Peak goroutines:              45
+  Bytes read from input:        15.0 GiB
+  Bytes written to temp files:  2.0 KiB
+
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestReport_TooLargeRows(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE \"a-b\" (id bigint PRIMARY KEY, doc text);\n" +
+		"INSERT INTO \"a-b\" (id, doc) VALUES (1, 'x');\n" +
+		"INSERT INTO \"a-b\" (id, doc) VALUES (2, 'y');\n" +
+		"INSERT INTO \"a-b\" (id, doc) VALUES (3, 'z');\n")
+	// Bad writes from the batch writer are keyed by Spanner table.
+	badWrites := BySourceTable(conv, map[string]int64{"a_b": 2})
+	conv.AddTooLargeRows(BySourceTable(conv, map[string]int64{"a_b": 2}))
+	assert.Equal(t, map[string]int64{"a-b": 2}, badWrites)
+	tr := buildTableReport(conv, "a-b", badWrites)
+	assert.Equal(t, int64(2), tr.badRows)
+	assert.Equal(t, int64(2), tr.tooLargeRows)
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, badWrites)
+	w.Flush()
+	assert.Contains(t, buf.String(), "Bad rows: 2 rows exceeding Spanner's commit size limit.\n")
+	assert.Equal(t, "1 row exceeded Spanner's commit size limit", tooLargeMsg(1))
+	assert.Equal(t, "", tooLargeMsg(0))
+}
+
+func TestGenerateSplitReport(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE a (id bigint PRIMARY KEY);\n" +
+		"CREATE TABLE b (id bigint PRIMARY KEY, x point);\n")
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateSplitReport(PgDumpSource, conv, w, nil, map[string]string{"a": "report/a.txt", "b": "report/b.txt"})
+	w.Flush()
+	r := buf.String()
+	assert.Contains(t, r, "  a: schema EXCELLENT, data ")
+	assert.Contains(t, r, "(see report/a.txt)\n")
+	assert.Contains(t, r, "  b: schema ")
+	assert.NotContains(t, r, "Table a\n")
+
+	buf.Reset()
+	WriteTableReport(conv, "b", w, nil)
+	w.Flush()
+	assert.True(t, strings.HasPrefix(buf.String(), "-"), buf.String())
+	assert.Contains(t, buf.String(), "Table b\n")
+	assert.NotContains(t, buf.String(), "Table a\n")
+}
+
+func TestReport_CommitRetries(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t (id bigint PRIMARY KEY);\n")
+	assert.NotContains(t, GenerateSummary(conv, nil), "Commit retries")
+	conv.AddCommitRetries(2)
+	conv.AddCommitRetries(1)
+	assert.Contains(t, GenerateSummary(conv, nil), "Commit retries: 3 (writes to Spanner that failed with transient errors, and were retried).\n")
+	var buf bytes.Buffer
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, &buf, nil))
+	assert.Contains(t, buf.String(), `"commitRetries": 3`)
+}
+
+func TestReport_Database(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t (id bigint PRIMARY KEY);\n")
+	assert.NotContains(t, GenerateSummary(conv, nil), "Spanner database")
+	conv.SetDatabase("projects/p/instances/i/databases/orders_20201231-235959")
+	assert.True(t, strings.HasPrefix(GenerateSummary(conv, nil), "Spanner database: projects/p/instances/i/databases/orders_20201231-235959\n"))
+	var buf bytes.Buffer
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, &buf, nil))
+	assert.Contains(t, buf.String(), `"database": "projects/p/instances/i/databases/orders_20201231-235959"`)
+}
+
+func TestReport_CreatedInstance(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t (id bigint PRIMARY KEY);\n")
+	conv.SetCreatedInstance("projects/p/instances/i", "regional-us-central1", 1, 1000)
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), "Spanner instance created for this conversion: projects/p/instances/i (config regional-us-central1, 1 node)")
+	buf.Reset()
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, &buf, nil))
+	assert.Contains(t, buf.String(), `"createdInstance"`)
+	assert.Contains(t, buf.String(), `"config": "regional-us-central1"`)
+}
+
+func TestPct(t *testing.T) {
+	tests := []struct {
+		total, bad int64
+		expected   string
+	}{
+		{0, 0, "100"},
+		{0, 5, "100"},
+		{10, 0, "100"},
+		{10, 10, " 0"},
+		{10, 11, " 0"},
+		{3000, 3360, " 0"},
+		{10, -1, "100"},
+		// Precision depends on the percentage: whole percentages up to
+		// 95%, 3 decimal places up to 99.9%, and 5 above that.
+		{100, 5, "95"},
+		{1000, 49, "95.100"},
+		{1000, 1, "99.900"},
+		{10000, 9, "99.91000"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, pct(tc.total, tc.bad), "pct(%d, %d)", tc.total, tc.bad)
+	}
+}
+
+func TestReport_BadRowsExceedRows(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t (id bigint PRIMARY KEY);\n" +
+		"INSERT INTO t (id) VALUES (1);\n" +
+		"INSERT INTO t (id) VALUES (2);\n")
+	// Bad writes double-counted e.g. by a retry bug.
+	tr := buildTableReport(conv, "t", map[string]int64{"t": 3})
+	assert.Equal(t, int64(2), tr.rows)
+	assert.Equal(t, int64(3), tr.badRows)
+	// A single condition describes the inconsistency.
+	assert.Equal(t, map[string]int64{"Inconsistent row counts for table t: N rows, N converted, N failed conversion, N failed writes": 1}, unexpectedCounts(conv))
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, map[string]int64{"t": 3})
+	w.Flush()
+	assert.Contains(t, buf.String(), "Data conversion: INCONSISTENT (bad row count exceeds total rows — see Unexpected Conditions).\n")
+	assert.Contains(t, buf.String(), "Bad rows: 3 write errors.\n")
+	assert.NotContains(t, buf.String(), "(-")
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{14*1024*1024*1024 + 200*1024*1024, "14.2 GiB"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, formatBytes(tc.n))
+	}
+}
+
+func TestJustifyLines(t *testing.T) {
+	url := "https://cloud.google.com/spanner/docs/schema-design#primary-key-prevent-hotspots"
+	id := strings.Repeat("abcdefghij", 12) // 120 characters.
+	tests := []struct {
+		name     string
+		s        string
+		limit    int
+		indent   int
+		expected string
+	}{
+		{
+			name:     "short",
+			s:        "1) A short line.\n",
+			limit:    20,
+			indent:   3,
+			expected: "1) A short line.\n",
+		},
+		{
+			name:     "wrap",
+			s:        "1) The quick brown fox jumps over the lazy dog.\n",
+			limit:    20,
+			indent:   3,
+			expected: "1) The quick brown\n   fox jumps over the\n   lazy dog.\n",
+		},
+		{
+			name:     "url",
+			s:        "1) See " + url + " for details.\n",
+			limit:    40,
+			indent:   3,
+			expected: "1) See\n   " + url + "\n   for details.\n",
+		},
+		{
+			name:     "long identifier at start",
+			s:        id + " is too long.",
+			limit:    80,
+			indent:   3,
+			expected: id + "\n   is too long.",
+		},
+		{
+			name:     "long identifier in middle",
+			s:        "1) Column '" + id + "' is too long.\n",
+			limit:    80,
+			indent:   3,
+			expected: "1) Column\n   '" + id + "'\n   is too long.\n",
+		},
+		{
+			name:     "multi-paragraph",
+			s:        "1) First paragraph that wraps.\nSecond paragraph.\n\nThird.\n",
+			limit:    20,
+			indent:   3,
+			expected: "1) First paragraph\n   that wraps.\n   Second paragraph.\n\n   Third.\n",
+		},
+		{
+			name:     "newline resets line position",
+			s:        "aaaaaaaaaaaaaaa\nbbb ccc",
+			limit:    20,
+			indent:   0,
+			expected: "aaaaaaaaaaaaaaa\nbbb ccc",
+		},
+	}
+	for _, tc := range tests {
+		buf := new(bytes.Buffer)
+		w := bufio.NewWriter(buf)
+		justifyLines(w, tc.s, tc.limit, tc.indent)
+		w.Flush()
+		assert.Equal(t, tc.expected, buf.String(), tc.name)
+	}
+}
+
+// oldJustifyLines is the original implementation of justifyLines,
+// which didn't handle embedded newlines. We keep it to verify that
+// output is unchanged for inputs without embedded newlines.
+func oldJustifyLines(w *bufio.Writer, s string, limit int, indent int) {
+	n := 0
+	startOfLine := true
+	words := strings.Split(s, " ")
+	for _, x := range words {
+		if n+len(x) > limit && !startOfLine {
+			w.WriteString("\n")
+			w.WriteString(strings.Repeat(" ", indent))
+			n = indent
+			startOfLine = true
+		}
+		if startOfLine {
+			w.WriteString(x)
+			n += len(x)
+		} else {
+			w.WriteString(" " + x)
+			n += len(x) + 1
+		}
+		startOfLine = false
+	}
+}
+
+func TestJustifyLinesUnchanged(t *testing.T) {
+	var inputs []string
+	// Lines generated by existing reports (these end in a newline).
+	for _, i := range issueDB {
+		inputs = append(inputs, fmt.Sprintf("1) Column 'some_column_name': type numeric is mapped to float64. %s.\n", i.brief))
+	}
+	inputs = append(inputs, "The remainder of this report provides stats on the pg_dump statements processed, "+
+		"followed by a table-by-table listing of schema and data conversion details. For background on the "+
+		"schema and data conversion process used, and explanations of the terms and notes used in this "+
+		"report, see HarbourBridge's README.")
+	// Random inputs, with words of varying lengths (including empty
+	// words from repeated spaces and words longer than the limit).
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		var words []string
+		for j := r.Intn(40); j > 0; j-- {
+			words = append(words, strings.Repeat("x", r.Intn(30)))
+		}
+		s := strings.Join(words, " ")
+		if r.Intn(2) == 0 {
+			s += "\n"
+		}
+		inputs = append(inputs, s)
+	}
+	for _, s := range inputs {
+		for _, indent := range []int{0, 3} {
+			for _, limit := range []int{20, 80} {
+				b1, b2 := new(bytes.Buffer), new(bytes.Buffer)
+				w1, w2 := bufio.NewWriter(b1), bufio.NewWriter(b2)
+				oldJustifyLines(w1, s, limit, indent)
+				justifyLines(w2, s, limit, indent)
+				w1.Flush()
+				w2.Flush()
+				assert.Equal(t, b1.String(), b2.String(), "input %q", s)
+			}
+		}
+	}
+}
+
+func TestReport_GroupIssues(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE orgs (org_id bigint, user_id bigint, name text, PRIMARY KEY (org_id, user_id));\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY, org_id bigint, user_id bigint, c bigint REFERENCES orgs(org_id) ON UPDATE CASCADE, " +
+			"d text REFERENCES orgs(org_id), " +
+			"CONSTRAINT fk_members FOREIGN KEY (org_id, user_id) REFERENCES orgs (org_id, user_id) ON DELETE SET NULL);\n")
+	tr := buildTableReport(conv, "t", nil)
+	// Two warnings for the foreign keys that were dropped, and one
+	// note for the foreign key that was converted.
+	assert.Equal(t, int64(2), tr.warnings)
+	expected := []tableReportBody{
+		{
+			heading: "Warnings",
+			lines: []reportLine{
+				{foreignKeyUnsupported, []string{"d"}, "The foreign key (d) referencing orgs (org_id) was dropped because " +
+					"column 'd' maps to string(max) but the referenced column 'org_id' maps to int64, and Spanner requires them to have the same type. " +
+					issueDB[foreignKeyUnsupported].brief},
+				{foreignKeyUnsupported, []string{"org_id", "user_id"}, "The foreign key fk_members (org_id, user_id) referencing orgs (org_id, user_id) " +
+					"was dropped because Spanner doesn't support ON DELETE SET NULL. " + issueDB[foreignKeyUnsupported].brief},
+			},
+		},
+		{
+			heading: "Note",
+			lines: []reportLine{
+				{foreignKey, []string{"c"}, "The foreign key (c) referencing orgs (org_id) was converted to a Spanner foreign key, " +
+					"but Spanner doesn't support ON UPDATE CASCADE, so updates to referenced columns that would break the foreign key will fail instead. " +
+					issueDB[foreignKey].brief},
+			},
+		},
+	}
+	assert.Equal(t, expected, tr.body)
+}
+
+func TestReport_SeveralWarningsPerColumn(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t (id serial PRIMARY KEY, name text);\n")
+	tr := buildTableReport(conv, "t", nil)
+	var issues []schemaIssue
+	for _, b := range tr.body {
+		for _, l := range b.lines {
+			issues = append(issues, l.issue)
+		}
+	}
+	assert.Equal(t, []schemaIssue{serial, hotspot}, issues)
+	// The rating counts column id once, but its description gives
+	// both warnings.
+	assert.Equal(t, int64(1), tr.warnings)
+	assert.Equal(t, int64(2), tr.listed)
+	_, s := rateSchema(tr.schemaCounts(), false, conv.ratingThresholds())
+	assert.Equal(t, "POOR (many columns did not map cleanly; 2 warnings across 2 columns)", s)
+	summary := GenerateSummary(conv, nil)
+	assert.Contains(t, summary, "Schema conversion: POOR (many columns did not map cleanly; 2 warnings across 2 columns).\n")
+}
+
+func TestReport_GroupIssuesConstraints(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE t (id bigint PRIMARY KEY, a bigint CHECK (a > 0), b bigint, c text, " +
+			"CONSTRAINT t_range CHECK (((b > a) AND (c IS NOT NULL))), " +
+			"CONSTRAINT t_bc UNIQUE (b, c));\n" +
+			"CREATE UNIQUE INDEX t_ab ON t USING btree (a, b) WHERE (a > 0);\n" +
+			"CREATE INDEX t_c ON t USING gin (c);\n")
+	tr := buildTableReport(conv, "t", nil)
+	// One warning for each constraint, however many columns it has.
+	assert.Equal(t, int64(3), tr.warnings)
+	expected := []tableReportBody{
+		{
+			heading: "Warnings",
+			lines: []reportLine{
+				{checkConstraint, []string{"b", "a", "c"}, "The check constraint t_range (b, a, c) was dropped. " + issueDB[checkConstraint].brief},
+				{uniqueUnsupported, []string{"a", "b"}, "The unique index t_ab (a, b) was dropped because it is a partial index (it has a WHERE clause), which Spanner doesn't support. " +
+					issueDB[uniqueUnsupported].brief},
+				{indexUnsupported, []string{"c"}, "The index t_c was dropped because Spanner doesn't support gin indexes. " + issueDB[indexUnsupported].brief},
+			},
+		},
+	}
+	assert.Equal(t, expected, tr.body)
+}
+
+func TestIssueSummary(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE a (id bigint PRIMARY KEY, x bigint DEFAULT 1, y bigint DEFAULT 2, z bigint DEFAULT 3);\n" +
+			"CREATE TABLE b (id bigint PRIMARY KEY, x bigint DEFAULT 1, s serial);\n" +
+			"CREATE TABLE c (id bigint PRIMARY KEY, s serial);\n")
+	r := analyzeTables(conv, nil)
+	// defaultValue is batched, so table a's section lists it once, but
+	// the summary counts all three columns.
+	lines := 0
+	for _, b := range r[0].body {
+		for _, l := range b.lines {
+			if l.issue == defaultValue {
+				lines++
+			}
+		}
+	}
+	assert.Equal(t, 1, lines)
+	assert.Equal(t, []issueCount{
+		{issue: defaultValue, severity: warning, tables: 2, cols: 4},
+		{issue: serial, severity: warning, tables: 2, cols: 2},
+	}, issueSummary(conv, r))
+}
+
+func TestIssueDocURLs(t *testing.T) {
+	for i, x := range issueDB {
+		if x.docURL == noDocURL {
+			assert.Equal(t, "", issueDocURL(i), i.String())
+			continue
+		}
+		assert.True(t, strings.HasPrefix(x.docURL, "https://"), "%s has docURL %q: want a URL or noDocURL", i, x.docURL)
+		assert.Equal(t, x.docURL, issueDocURL(i), i.String())
+	}
+}
+
+func TestReport_DocLinks(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t (a bigint PRIMARY KEY, b serial, c serial, d circle);\n")
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	// Each issue type is linked once per table, and issues without docs
+	// are unchanged.
+	assert.Contains(t, buf.String(), "1) Column 'b': type serial is mapped to int64. Spanner does not support\n"+
+		"   autoincrementing types (see\n"+
+		"   https://github.com/cloudspannerecosystem/harbourbridge#bigserial-and-serial).\n"+
+		"2) Column 'c': type serial is mapped to int64. Spanner does not support\n"+
+		"   autoincrementing types.\n"+
+		"3) Column 'd': type circle is mapped to string(max). No appropriate Spanner\n"+
+		"   type.\n")
+
+	r := BuildReport(PgDumpSource, conv, nil)
+	assert.Equal(t, "https://github.com/cloudspannerecosystem/harbourbridge#bigserial-and-serial", r.Tables[0].Issues[1].DocURL)
+	assert.Equal(t, "", r.Tables[0].Issues[2].DocURL)
+}
+
+func TestReport_Renamed(t *testing.T) {
+	conv, _ := runProcessPgDump(
+		"CREATE TABLE \"select\" (id bigint PRIMARY KEY, \"my col\" text, \"order\" bigint);\n" +
+			"CREATE TABLE t (id bigint PRIMARY KEY);\n")
+	tr := buildTableReport(conv, "select", nil)
+	assert.Equal(t, "select_", tr.spTable)
+	expected := []tableReportBody{
+		{
+			heading: "Notes",
+			lines: []reportLine{
+				{renamed, nil, "Table was mapped to Spanner table select_ because it is a Spanner reserved word. " + issueDB[renamed].brief},
+				{renamed, []string{"my col"}, "Column 'my col' was mapped to Spanner column my_col because it has characters that Spanner names can't use. " + issueDB[renamed].brief},
+				{renamed, []string{"order"}, "Column 'order' was mapped to Spanner column order_ because it is a Spanner reserved word. " + issueDB[renamed].brief},
+			},
+		},
+	}
+	assert.Equal(t, expected, tr.body)
+	assert.Empty(t, conv.groupIssues["t"])
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), "Table select (mapped to Spanner table select_)")
+	assert.Contains(t, buf.String(), "Names changed: 1 tables, 2 columns (renamed to make them legal Spanner names; see the notes for each table).")
+}
+
+func TestReport_OrderingChanged(t *testing.T) {
+	conv, _ := runProcessPgDump("CREATE TABLE t (id uuid PRIMARY KEY, v bigint);\n")
+	tr := buildTableReport(conv, "t", nil)
+	// One warning for the uuid type, and one for its use in the primary key.
+	assert.Equal(t, int64(2), tr.warnings)
+	expected := []tableReportBody{{
+		heading: "Warnings",
+		lines: []reportLine{
+			{noGoodType, []string{"id"}, "Column 'id': type uuid is mapped to string(max). No appropriate Spanner type"},
+			{orderingChanged, []string{"id"}, "Column 'id' is part of the primary key, and is mapped from uuid to string(max): " +
+				"Spanner compares the values as strings, so the same uuid written in different case or format is treated as a distinct value. " +
+				issueDB[orderingChanged].brief},
+		},
+	}}
+	assert.Equal(t, expected, tr.body)
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+// buildGoldenConv builds a Conv for golden report tests. Statements,
+// unexpected conditions, etc are added in an order determined by seed,
+// so that tests can check that report output doesn't depend on
+// insertion order (or map iteration order).
+func buildGoldenConv(seed int64) (*Conv, map[string]int64) {
+	r := rand.New(rand.NewSource(seed))
+	creates := []string{
+		"CREATE TABLE cart (productid text, userid text, quantity bigint, PRIMARY KEY (productid, userid));\n",
+		"CREATE TABLE products (productid text PRIMARY KEY, price numeric(6, 2), description text DEFAULT 'none');\n",
+		"CREATE TABLE events (id serial, ts timestamp, a int[][], n numeric);\n",
+		"CREATE TABLE orgs (org_id bigint, user_id bigint, PRIMARY KEY (org_id, user_id));\n",
+		"CREATE TABLE members (id bigint PRIMARY KEY, org_id bigint, user_id bigint, c int REFERENCES orgs(org_id), " +
+			"FOREIGN KEY (org_id, user_id) REFERENCES orgs (org_id, user_id));\n",
+	}
+	// Like pg_dump, we create other objects after the tables they use.
+	others := []string{
+		"CREATE VIEW v AS SELECT * FROM cart;\n",
+		"CREATE SEQUENCE s;\n",
+		"CREATE INDEX idx ON cart (quantity);\n",
+		"CREATE INDEX idx_lower ON products (lower(description));\n",
+	}
+	inserts := []string{
+		"INSERT INTO cart (productid, userid, quantity) VALUES ('p1', 'u1', 1);\n",
+		"INSERT INTO cart (productid, userid, quantity) VALUES ('p2', 'u1', 'bad');\n",
+		"INSERT INTO products (productid, price) VALUES ('p1', '1.50');\n",
+		"INSERT INTO events (id, ts) VALUES (1, '2020-01-01 00:00:00');\n",
+		"INSERT INTO orgs (org_id, user_id) VALUES (1, 2);\n",
+	}
+	r.Shuffle(len(creates), func(i, j int) { creates[i], creates[j] = creates[j], creates[i] })
+	r.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	r.Shuffle(len(inserts), func(i, j int) { inserts[i], inserts[j] = inserts[j], inserts[i] })
+	conv, _ := runProcessPgDump(strings.Join(creates, "") + strings.Join(others, "") + strings.Join(inserts, ""))
+	unexpected := []string{"condition a", "condition a", "condition b", "condition c", "condition c", "condition d"}
+	r.Shuffle(len(unexpected), func(i, j int) { unexpected[i], unexpected[j] = unexpected[j], unexpected[i] })
+	for _, u := range unexpected {
+		conv.unexpected(u)
+	}
+	// Real timings aren't deterministic, so use fixed ones.
+	conv.stats.timing = map[string]*tableTiming{
+		"cart": {2500 * time.Millisecond, 12 * 1000 * 1000},
+		"orgs": {4*time.Minute + 32*time.Second, 3346 * 1000 * 1000},
+	}
+	badWrites := make(map[string]int64)
+	for _, t := range r.Perm(2) {
+		badWrites[[]string{"cart", "orgs"}[t]] = 1
+	}
+	return conv, badWrites
+}
+
+// TestReport_Golden checks that reports are deterministic, and match
+// the golden file. To regenerate the golden file, run
+//
+//	go test ./internal -run TestReport_Golden -update
+func TestReport_Golden(t *testing.T) {
+	var reports []string
+	for seed := int64(0); seed < 5; seed++ {
+		conv, badWrites := buildGoldenConv(seed)
+		buf := new(bytes.Buffer)
+		w := bufio.NewWriter(buf)
+		GenerateReport(PgDumpSource, conv, w, badWrites)
+		w.Flush()
+		reports = append(reports, buf.String())
+	}
+	for i := 1; i < len(reports); i++ {
+		assert.Equal(t, reports[0], reports[i], "report differs for seed %d", i)
+	}
+	golden := filepath.Join("..", "test_data", "report.golden")
+	if *update {
+		assert.Nil(t, ioutil.WriteFile(golden, []byte(reports[0]), 0644))
+	}
+	expected, err := ioutil.ReadFile(golden)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), reports[0])
+}
+
+func TestRateSchemaAndData(t *testing.T) {
+	th := DefaultRatingThresholds
+	r, s := rateSchema(schemaCounts{cols: 10}, false, th)
+	assert.Equal(t, RatingExcellent, r)
+	assert.Equal(t, "EXCELLENT (all columns mapped cleanly)", s)
+	r, s = rateSchema(schemaCounts{cols: 10, missingPKey: true}, true, th)
+	assert.Equal(t, RatingGood, r)
+	assert.Equal(t, "GOOD (all columns mapped cleanly, but some missing primary keys)", s)
+	r, s = rateSchema(schemaCounts{cols: 4, warnings: 3, listed: 3}, false, th)
+	assert.Equal(t, RatingPoor, r)
+	assert.Equal(t, "POOR (many columns did not map cleanly; 3 warnings across 4 columns)", s)
+	r, s = rateSchema(schemaCounts{cols: 0}, false, th)
+	assert.Equal(t, RatingNone, r)
+	assert.Equal(t, "NONE (no schema found)", s)
+	r, s = rateData(1000, 0, false, "written to Spanner", th)
+	assert.Equal(t, RatingExcellent, r)
+	assert.Equal(t, "EXCELLENT (all 1000 rows written to Spanner)", s)
+	r, s = rateData(2, 1, false, "written to Spanner", th)
+	assert.Equal(t, RatingPoor, r)
+	assert.Equal(t, "POOR (50% of 2 rows written to Spanner)", s)
+	r, s = rateData(2, 0, false, "would be written to Spanner", th)
+	assert.Equal(t, RatingExcellent, r)
+	assert.Equal(t, "EXCELLENT (all 2 rows would be written to Spanner)", s)
+	r, s = rateData(0, 0, false, "written to Spanner", th)
+	assert.Equal(t, RatingNone, r)
+	assert.Equal(t, "NONE (no data rows found)", s)
+	r, s = rateData(0, 0, true, "written to Spanner", th)
+	assert.Equal(t, RatingSkipped, r)
+	assert.Equal(t, "SKIPPED (data conversion not run)", s)
+	r, s = rateData(3000, 3360, false, "written to Spanner", th)
+	assert.Equal(t, RatingInconsistent, r)
+	assert.Equal(t, "INCONSISTENT (bad row count exceeds total rows — see Unexpected Conditions)", s)
+	r, _ = rateData(0, 1, false, "written to Spanner", th)
+	assert.Equal(t, RatingInconsistent, r)
+	assert.True(t, RatingInconsistent < RatingPoor && RatingPoor < RatingOK && RatingOK < RatingGood && RatingGood < RatingExcellent)
+}
+
+func TestRateSchema_Thresholds(t *testing.T) {
+	th := DefaultRatingThresholds
+	for _, tc := range []struct {
+		c        schemaCounts
+		summary  bool
+		expected string
+	}{
+		// Boundaries of the default thresholds: a warning is GOOD for
+		// 40 columns (under 5%), but not for 39.
+		{schemaCounts{cols: 40, warnings: 1, listed: 1}, false, "GOOD (most columns mapped cleanly; 1 warning across 40 columns)"},
+		{schemaCounts{cols: 39, warnings: 1, listed: 1}, false, "OK (some columns did not map cleanly; 1 warning across 39 columns)"},
+		{schemaCounts{cols: 20, warnings: 1, listed: 1}, false, "OK (some columns did not map cleanly; 1 warning across 20 columns)"},
+		{schemaCounts{cols: 6, warnings: 1, listed: 1}, false, "OK (some columns did not map cleanly; 1 warning across 6 columns)"},
+		{schemaCounts{cols: 5, warnings: 1, listed: 1}, false, "POOR (many columns did not map cleanly; 1 warning across 5 columns)"},
+		{schemaCounts{cols: 40, warnings: 1, listed: 1, missingPKey: true}, false, "GOOD (most columns mapped cleanly, but missing primary key; 1 warning across 40 columns)"},
+		// Corner cases: no columns, and more warnings than columns.
+		{schemaCounts{cols: 0, warnings: 2, listed: 2}, false, "NONE (no schema found)"},
+		{schemaCounts{cols: 2, warnings: 3, listed: 3}, false, "POOR (many columns did not map cleanly; 3 warnings across 2 columns)"},
+		// Overall ratings give the rows of tables with warnings.
+		{schemaCounts{cols: 100, warnings: 1, listed: 1, warnRows: 1e9, rows: 1e9 + 10}, true, "GOOD (most columns mapped cleanly; 1 warning across 100 columns, affecting >99% of rows)"},
+		{schemaCounts{cols: 100, warnings: 1, listed: 1, warnRows: 10, rows: 1e9 + 10}, true, "GOOD (most columns mapped cleanly; 1 warning across 100 columns, affecting <1% of rows)"},
+		{schemaCounts{cols: 100, warnings: 10, listed: 10, warnRows: 500, rows: 1000}, true, "OK (some columns did not map cleanly; 10 warnings across 100 columns, affecting 50% of rows)"},
+		{schemaCounts{cols: 100, warnings: 10, listed: 10}, true, "OK (some columns did not map cleanly; 10 warnings across 100 columns)"},
+	} {
+		_, s := rateSchema(tc.c, tc.summary, th)
+		assert.Equal(t, tc.expected, s)
+	}
+	// Custom thresholds.
+	strict := RatingThresholds{Good: 1, OK: 10}
+	r, _ := rateSchema(schemaCounts{cols: 40, warnings: 1, listed: 1}, false, strict)
+	assert.Equal(t, RatingOK, r)
+	r, _ = rateSchema(schemaCounts{cols: 10, warnings: 1, listed: 1}, false, strict)
+	assert.Equal(t, RatingPoor, r)
+	r, _ = rateData(200, 1, false, "written to Spanner", strict)
+	assert.Equal(t, RatingGood, r)
+	r, _ = rateData(100, 1, false, "written to Spanner", strict)
+	assert.Equal(t, RatingOK, r)
+}
+
+func TestParseRating(t *testing.T) {
+	for _, tc := range []struct {
+		s string
+		r Rating
+	}{
+		{"excellent", RatingExcellent},
+		{"GOOD", RatingGood},
+		{"Ok", RatingOK},
+		{"poor", RatingPoor},
+	} {
+		r, err := ParseRating(tc.s)
+		assert.Nil(t, err, tc.s)
+		assert.Equal(t, tc.r, r, tc.s)
+	}
+	for _, s := range []string{"", "none", "skipped", "inconsistent", "great"} {
+		_, err := ParseRating(s)
+		assert.NotNil(t, err, s)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// In a mostly clean schema, most of the text report is table sections
+// that only give ratings. A terse report lists each clean table on one
+// line instead, keeping full sections for tables with anything to
+// report. The HTML report already collapses table sections, and the
+// JSON report is for programs, so both always give every table.
+
+// ReportLevel determines how much detail the text report gives for
+// tables that converted cleanly.
+type ReportLevel string
+
+// Report levels.
+const (
+	ReportLevelFull  ReportLevel = "full"  // A section for every table (the default).
+	ReportLevelTerse ReportLevel = "terse" // A line for each clean table (see tableReport.clean), and a section for every other table.
+)
+
+// ParseReportLevel returns the report level named s (empty for the
+// default).
+func ParseReportLevel(s string) (ReportLevel, error) {
+	switch x := ReportLevel(s); x {
+	case "":
+		return ReportLevelFull, nil
+	case ReportLevelFull, ReportLevelTerse:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown report level %q: expected full or terse", s)
+}
+
+// SetReportLevel sets how much detail the text report gives for tables
+// that converted cleanly.
+func (conv *Conv) SetReportLevel(l ReportLevel) {
+	conv.reportLevel = l
+}
+
+// clean returns whether table t has nothing to report beyond its
+// ratings and stats: no schema issues (warnings or notes), no synthetic
+// primary key, no bad rows, and no rows left unread by an interruption.
+// Tables with column statistics or mappings aren't clean, since those
+// were asked for.
+func (t ReportTable) clean() bool {
+	return t.InternalError == "" && len(t.Issues) == 0 && t.SyntheticPKey == "" &&
+		t.BadRows == 0 && t.UnreadRows == 0 && len(t.ColumnStats) == 0 &&
+		len(t.Mappings) == 0
+}
+
+// writeTableReports writes the table-by-table listing of the report:
+// a section for each table, except that clean tables are listed
+// together, one line each, for terse reports.
+func writeTableReports(r *Report, level ReportLevel, w *bufio.Writer) {
+	var clean []ReportTable
+	for _, t := range r.Tables {
+		if level == ReportLevelTerse && t.clean() {
+			clean = append(clean, t)
+			continue
+		}
+		writeTableReport(r, t, w)
+	}
+	if len(clean) == 0 {
+		return
+	}
+	writeHeading(w, "Clean Tables")
+	w.WriteString("Tables with no schema issues and no bad rows, whose sections are left out of\n")
+	w.WriteString("this terse report.\n")
+	for _, t := range clean {
+		fmt.Fprintf(w, "  %s: %s schema, %s data, %s rows\n", t.SrcTable, t.Rating.Schema.Rating, t.Rating.Data.Rating, formatCount(t.Rows))
+	}
+	w.WriteString("\n")
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReportLevel(t *testing.T) {
+	l, err := ParseReportLevel("")
+	assert.Nil(t, err)
+	assert.Equal(t, ReportLevelFull, l)
+	l, err = ParseReportLevel("terse")
+	assert.Nil(t, err)
+	assert.Equal(t, ReportLevelTerse, l)
+	_, err = ParseReportLevel("brief")
+	assert.NotNil(t, err)
+}
+
+func TestReport_Terse(t *testing.T) {
+	report := func(level ReportLevel) string {
+		conv, _ := runProcessPgDump(
+			"CREATE TABLE clean (id bigint PRIMARY KEY);\n" +
+				"CREATE TABLE empty (id bigint PRIMARY KEY);\n" +
+				"CREATE TABLE nopk (a bigint);\n" +
+				"CREATE TABLE notes (id bigint PRIMARY KEY, n int4);\n" +
+				"CREATE TABLE writes (id bigint PRIMARY KEY);\n")
+		for _, t := range []string{"clean", "nopk", "notes", "writes"} {
+			conv.stats.rows[t] = 1500
+			conv.stats.goodRows[t] = 1500
+		}
+		conv.SetReportLevel(level)
+		buf := new(bytes.Buffer)
+		w := bufio.NewWriter(buf)
+		GenerateReport(PgDumpSource, conv, w, map[string]int64{"writes": 1})
+		w.Flush()
+		return buf.String()
+	}
+
+	r := report(ReportLevelTerse)
+	// Tables with only notes, only bad writes, or a synthetic primary key
+	// keep their sections.
+	for _, table := range []string{"nopk", "notes", "writes"} {
+		assert.Contains(t, r, "\nTable "+table+"\n", table)
+	}
+	for _, table := range []string{"clean", "empty"} {
+		assert.NotContains(t, r, "\nTable "+table+"\n", table)
+	}
+	assert.Contains(t, r, "Clean Tables\n"+
+		"----------------------------\n"+
+		"Tables with no schema issues and no bad rows, whose sections are left out of\n"+
+		"this terse report.\n"+
+		"  clean: EXCELLENT schema, EXCELLENT data, 1,500 rows\n"+
+		"  empty: EXCELLENT schema, NONE data, 0 rows\n\n")
+
+	r = report(ReportLevelFull)
+	for _, table := range []string{"clean", "empty", "nopk", "notes", "writes"} {
+		assert.Contains(t, r, "\nTable "+table+"\n", table)
+	}
+	assert.NotContains(t, r, "Clean Tables")
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// By default, tables are listed in the report alphabetically, which can
+// bury the tables that most need attention in a large report. Tables
+// can instead be listed by size, or by how badly they converted. The
+// order applies to the text, HTML and JSON reports alike, since they
+// are all built from analyzeTables.
+
+// ReportOrder determines the order that tables are listed in reports.
+type ReportOrder string
+
+// Report orders.
+const (
+	ReportOrderAlpha    ReportOrder = "alpha"    // Alphabetical by source table name (the default).
+	ReportOrderRows     ReportOrder = "rows"     // Most rows first.
+	ReportOrderProblems ReportOrder = "problems" // Highest badness (see badness) first.
+)
+
+// ParseReportOrder returns the report order named s (empty for the
+// default).
+func ParseReportOrder(s string) (ReportOrder, error) {
+	switch x := ReportOrder(s); x {
+	case "":
+		return ReportOrderAlpha, nil
+	case ReportOrderAlpha, ReportOrderRows, ReportOrderProblems:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown report order %q: expected alpha, rows or problems", s)
+}
+
+// SetReportOrder sets the order that tables are listed in reports.
+func (conv *Conv) SetReportOrder(o ReportOrder) {
+	conv.reportOrder = o
+}
+
+// sortTableReports sorts r, which is in alphabetical order, into the
+// configured report order. The sort is stable, so tables that tie are
+// left in alphabetical order.
+func (conv *Conv) sortTableReports(r []tableReport) {
+	switch conv.reportOrder {
+	case ReportOrderRows:
+		sort.SliceStable(r, func(i, j int) bool { return r[i].rows > r[j].rows })
+	case ReportOrderProblems:
+		sort.SliceStable(r, func(i, j int) bool { return badness(r[i]) > badness(r[j]) })
+	}
+}
+
+// badness scores how badly table t converted, for ReportOrderProblems.
+// Each schema warning scores the log of the table's rows (so that
+// warnings matter more for big tables, without big tables swamping the
+// order), and each percent of bad rows scores 1. Tables that couldn't
+// be analyzed come first.
+func badness(t tableReport) float64 {
+	if t.internalError != "" {
+		return math.Inf(1)
+	}
+	s := float64(t.warnings) * math.Log10(float64(t.rows)+10)
+	if t.rows > 0 {
+		s += 100 * float64(t.badRows) / float64(t.rows)
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReportOrder(t *testing.T) {
+	o, err := ParseReportOrder("")
+	assert.Nil(t, err)
+	assert.Equal(t, ReportOrderAlpha, o)
+	o, err = ParseReportOrder("problems")
+	assert.Nil(t, err)
+	assert.Equal(t, ReportOrderProblems, o)
+	_, err = ParseReportOrder("size")
+	assert.NotNil(t, err)
+}
+
+func TestReportOrder(t *testing.T) {
+	tests := []struct {
+		order    ReportOrder
+		expected []string
+	}{
+		{"", []string{"a", "b", "c", "d"}},
+		{ReportOrderAlpha, []string{"a", "b", "c", "d"}},
+		{ReportOrderRows, []string{"b", "c", "a", "d"}},
+		{ReportOrderProblems, []string{"c", "b", "a", "d"}},
+	}
+	for _, tc := range tests {
+		conv, _ := runProcessPgDump(
+			"CREATE TABLE a (id bigint PRIMARY KEY);\n" +
+				"CREATE TABLE b (id bigint PRIMARY KEY, s serial);\n" +
+				"CREATE TABLE c (id bigint PRIMARY KEY);\n" +
+				"CREATE TABLE d (id bigint PRIMARY KEY);\n")
+		// b has a warning and the most rows, c has half its rows bad,
+		// and a and d tie.
+		for t, n := range map[string]int64{"a": 10, "b": 1000, "c": 100, "d": 10} {
+			conv.stats.rows[t] = n
+		}
+		conv.stats.badRows["c"] = 50
+		conv.SetReportOrder(tc.order)
+
+		var tables []string
+		for _, r := range analyzeTables(conv, nil) {
+			tables = append(tables, r.srcTable)
+		}
+		assert.Equal(t, tc.expected, tables, tc.order)
+
+		tables = nil
+		for _, r := range BuildReport(PgDumpSource, conv, nil).Tables {
+			tables = append(tables, r.SrcTable)
+		}
+		assert.Equal(t, tc.expected, tables, tc.order)
+
+		buf := new(bytes.Buffer)
+		w := bufio.NewWriter(buf)
+		GenerateReport(PgDumpSource, conv, w, nil)
+		w.Flush()
+		assert.Equal(t, tc.expected, headingOrder(buf.String(), "\nTable "), tc.order)
+
+		buf.Reset()
+		assert.Nil(t, GenerateHTMLReport(PgDumpSource, conv, buf, nil, ""))
+		assert.Equal(t, tc.expected, headingOrder(buf.String(), "<summary>Table "), tc.order)
+	}
+}
+
+// headingOrder returns the (one-letter) names of the tables in the order
+// that their headings (prefix followed by the table name) appear in
+// report.
+func headingOrder(report, prefix string) []string {
+	var l []string
+	for _, t := range strings.Split(report, prefix)[1:] {
+		l = append(l, t[:1])
+	}
+	return l
+}
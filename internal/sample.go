@@ -0,0 +1,196 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Row sampling is for trial conversions, which validate schema and type
+// mappings without converting every row. Rows not in the sample are
+// read (so that we can find the rest of the dump), but not converted or
+// written to Spanner, and reports rate data conversion on the rows in
+// the sample.
+
+// RowSampling configures which data rows of each table are converted
+// (see Conv.SetRowSampling). The zero value converts all rows.
+type RowSampling struct {
+	Limit   int64   // Max rows converted per table (0 means no limit).
+	Percent float64 // Percentage of rows converted, chosen pseudo-randomly (0 means all rows).
+}
+
+// Enabled returns true if s converts only a sample of the rows.
+func (s RowSampling) Enabled() bool {
+	return s.Limit > 0 || s.Percent > 0
+}
+
+// Validate returns an error if s isn't a valid sampling configuration.
+func (s RowSampling) Validate() error {
+	if s.Limit < 0 {
+		return fmt.Errorf("bad row limit %d: must not be negative", s.Limit)
+	}
+	if s.Percent < 0 || s.Percent > 100 {
+		return fmt.Errorf("bad sample percentage %g: must be between 0 and 100", s.Percent)
+	}
+	return nil
+}
+
+// describe returns a description of s for reports e.g. "10% of rows,
+// up to 1000 per table".
+func (s RowSampling) describe() string {
+	switch {
+	case s.Limit > 0 && s.Percent > 0:
+		return fmt.Sprintf("%g%% of rows, up to %d per table", s.Percent, s.Limit)
+	case s.Limit > 0:
+		return fmt.Sprintf("first %d rows of each table", s.Limit)
+	}
+	return fmt.Sprintf("%g%% of rows", s.Percent)
+}
+
+// rowSampler tracks the rows of each table included in the sample.
+type rowSampler struct {
+	RowSampling
+	seen  map[string]int64 // Data rows seen so far, broken down by source table.
+	taken map[string]int64 // Data rows included in the sample, broken down by source table.
+}
+
+// SetRowSampling configures data conversion to convert only a sample of
+// the rows of each table. It must be called before data conversion.
+// Sampling is deterministic: the same dump always gives the same sample.
+func (conv *Conv) SetRowSampling(s RowSampling) {
+	if !s.Enabled() {
+		conv.sampler = nil
+		return
+	}
+	conv.sampler = &rowSampler{RowSampling: s, seen: make(map[string]int64), taken: make(map[string]int64)}
+}
+
+// sampleRow is called for each row of srcTable in data mode. It returns
+// false if the row isn't in the sample (and so should be skipped).
+func (conv *Conv) sampleRow(srcTable string) bool {
+	s := conv.sampler
+	if s == nil {
+		return true
+	}
+	pos := s.seen[srcTable]
+	s.seen[srcTable]++
+	if s.Limit > 0 && s.taken[srcTable] >= s.Limit {
+		return false
+	}
+	if s.Percent > 0 && samplePoint(srcTable, pos) >= s.Percent/100 {
+		return false
+	}
+	s.taken[srcTable]++
+	return true
+}
+
+// toSample returns how many of the next n rows of srcTable would be in
+// the sample, e.g. for rows that couldn't be read.
+func (s *rowSampler) toSample(srcTable string, n int64) int64 {
+	if s.Percent > 0 {
+		n = int64(float64(n) * s.Percent / 100)
+	}
+	if left := s.Limit - s.taken[srcTable]; s.Limit > 0 && n > left {
+		n = left
+	}
+	return n
+}
+
+// samplePoint maps row pos of srcTable to a pseudo-random point in
+// [0, 1). Rows whose point is below the sampling fraction are sampled.
+func samplePoint(srcTable string, pos int64) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(srcTable))
+	x := h.Sum64() ^ uint64(pos)
+	// The splitmix64 finalizer, so that consecutive rows get
+	// unrelated points.
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// unsampledRows returns the number of data rows of srcTable that were
+// skipped because they weren't in the sample. If srcTable is empty, it
+// returns the total for all tables.
+func (conv *Conv) unsampledRows(srcTable string) int64 {
+	s := conv.sampler
+	if s == nil {
+		return 0
+	}
+	if srcTable != "" {
+		return s.seen[srcTable] - s.taken[srcTable]
+	}
+	n := int64(0)
+	for t := range s.seen {
+		n += s.seen[t] - s.taken[t]
+	}
+	return n
+}
+
+// RowsToConvert returns the number of data rows that data conversion
+// will convert: all rows, or (an estimate of) the rows in the sample
+// if row sampling is configured. It is used for progress reporting,
+// and must be called after schema conversion.
+func (conv *Conv) RowsToConvert() int64 {
+	s := conv.sampler
+	if s == nil {
+		return conv.Rows()
+	}
+	n := int64(0)
+	for _, rows := range conv.stats.rows {
+		if s.Percent > 0 {
+			rows = int64(float64(rows) * s.Percent / 100)
+		}
+		if s.Limit > 0 && rows > s.Limit {
+			rows = s.Limit
+		}
+		n += rows
+	}
+	return n
+}
+
+// samplingSummary returns a note on row sampling for the report
+// summary, or "" if row sampling wasn't in effect.
+func samplingSummary(conv *Conv) string {
+	if conv.sampler == nil || conv.dataSkipped {
+		return ""
+	}
+	unsampled := conv.unsampledRows("")
+	rows := conv.Rows() - unsampled
+	return fmt.Sprintf("Row sampling: %d of %d rows were converted (%s). The data conversion rating is for these rows only", rows, rows+unsampled, conv.sampler.describe())
+}
+
+// rowSampling returns the row sampling in effect, or nil if all rows
+// were converted (or data conversion wasn't run).
+func (conv *Conv) rowSampling() *RowSampling {
+	if conv.sampler == nil || conv.dataSkipped {
+		return nil
+	}
+	return &conv.sampler.RowSampling
+}
+
+// samplingMsg returns a note on row sampling s for a table report, with
+// rows the rows converted and unsampled the rows skipped. Returns ""
+// if s is nil.
+func samplingMsg(s *RowSampling, rows, unsampled int64) string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("Sampled: %d of %d rows were converted (%s), and data conversion is rated on these rows", rows, rows+unsampled, s.describe())
+}
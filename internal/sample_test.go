@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRowSampling_Validate(t *testing.T) {
+	for _, tc := range []struct {
+		s   RowSampling
+		err bool
+	}{
+		{RowSampling{}, false},
+		{RowSampling{Limit: 10}, false},
+		{RowSampling{Percent: 0.5}, false},
+		{RowSampling{Limit: 10, Percent: 100}, false},
+		{RowSampling{Limit: -1}, true},
+		{RowSampling{Percent: -1}, true},
+		{RowSampling{Percent: 100.5}, true},
+	} {
+		assert.Equal(t, tc.err, tc.s.Validate() != nil, fmt.Sprintf("%+v", tc.s))
+	}
+}
+
+func TestSampleRow(t *testing.T) {
+	sample := func(s RowSampling, table string, n int) []int {
+		conv := MakeConv()
+		conv.SetRowSampling(s)
+		var l []int
+		for i := 0; i < n; i++ {
+			if conv.sampleRow(table) {
+				l = append(l, i)
+			}
+		}
+		assert.Equal(t, int64(n-len(l)), conv.unsampledRows(table))
+		return l
+	}
+	assert.Equal(t, 1000, len(sample(RowSampling{}, "t", 1000)))
+	assert.Equal(t, []int{0, 1, 2}, sample(RowSampling{Limit: 3}, "t", 1000))
+
+	// Percentage samples are deterministic, roughly the right size,
+	// and spread across the table.
+	l := sample(RowSampling{Percent: 10}, "t", 10000)
+	assert.Equal(t, l, sample(RowSampling{Percent: 10}, "t", 10000))
+	assert.True(t, len(l) > 900 && len(l) < 1100, len(l))
+	assert.True(t, l[0] < 100 && l[len(l)-1] > 9900, l)
+	assert.NotEqual(t, l, sample(RowSampling{Percent: 10}, "u", 10000))
+
+	// The limit applies to the rows in the sample.
+	assert.Equal(t, l[:5], sample(RowSampling{Percent: 10, Limit: 5}, "t", 10000))
+}
+
+func TestRowsToConvert(t *testing.T) {
+	conv := MakeConv()
+	conv.stats.rows["t"] = 1000
+	conv.stats.rows["u"] = 10
+	assert.Equal(t, int64(1010), conv.RowsToConvert())
+	conv.SetRowSampling(RowSampling{Limit: 50})
+	assert.Equal(t, int64(60), conv.RowsToConvert())
+	conv.SetRowSampling(RowSampling{Percent: 10, Limit: 50})
+	assert.Equal(t, int64(51), conv.RowsToConvert())
+}
+
+func TestReport_RowSampling(t *testing.T) {
+	conv := MakeConv()
+	conv.SetRowSampling(RowSampling{Limit: 2})
+	_, rows := runProcessPgDumpConv(conv,
+		"CREATE TABLE t (a bigint PRIMARY KEY, b int4);\n"+
+			"COPY public.t (a, b) FROM stdin;\n"+
+			"1\t1\n2\tx\n3\t3\n4\tx\n5\t5\n\\.\n")
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, int64(5), conv.Rows())
+
+	tr := buildTableReport(conv, "t", nil)
+	assert.Equal(t, int64(2), tr.rows)
+	assert.Equal(t, int64(1), tr.badRows)
+	assert.Equal(t, int64(3), tr.unsampled)
+	for u := range conv.stats.unexpected {
+		assert.NotContains(t, u, "Inconsistent row counts")
+	}
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	summary := GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, summary, "Data conversion: POOR (50% of 2 rows written to Spanner).")
+	assert.Contains(t, summary, "Row sampling: 2 of 5 rows were converted (first 2 rows of each table).")
+	assert.Contains(t, strings.Join(strings.Fields(buf.String()), " "), "Sampled: 2 of 5 rows were converted (first 2 rows of each table), and data conversion is rated on these rows.")
+
+	buf.Reset()
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, buf, nil))
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, &ReportSampling{Limit: 2, Rows: 2, UnsampledRows: 3}, r.Sampling)
+	assert.Equal(t, int64(3), r.Tables[0].UnsampledRows)
+}
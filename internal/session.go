@@ -0,0 +1,396 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// sessionVersion is the version of the session file format. It should
+// be incremented for any change that makes old session files unusable.
+const sessionVersion = 1
+
+// Session is a snapshot of the schema conversion state of a Conv: the
+// source and Spanner schemas and the mapping between them. Sessions
+// are written as JSON (see WriteSession) so that the Spanner schema
+// and mapping can be reviewed and hand-edited after schema conversion,
+// and then used for data conversion on a later run (see ApplySession).
+type Session struct {
+	Version int            `json:"version"`
+	Tables  []SessionTable `json:"tables"` // Sorted by source table name.
+}
+
+// SessionTable describes the conversion of a single source table.
+type SessionTable struct {
+	Source       schema.Table        `json:"source"` // Source table schema, as found by schema conversion. Not intended to be edited.
+	Spanner      SessionSpannerTable `json:"spanner"`
+	SyntheticKey string              `json:"syntheticKey,omitempty"` // Spanner column added as primary key (if any).
+	Issues       map[string][]string `json:"issues,omitempty"`       // Names of schema issues (e.g. "widened"), keyed by source column.
+}
+
+// SessionSpannerTable is the Spanner table a source table is mapped to.
+type SessionSpannerTable struct {
+	Name              string                 `json:"name"`
+	Columns           []SessionColumn        `json:"columns"`
+	PrimaryKeys       []ddl.IndexKey         `json:"primaryKeys"`
+	ForeignKeys       []ddl.ForeignKey       `json:"foreignKeys,omitempty"`
+	Indexes           []ddl.CreateIndex      `json:"indexes,omitempty"`
+	Comment           string                 `json:"comment,omitempty"`
+	RowDeletionPolicy *ddl.RowDeletionPolicy `json:"rowDeletionPolicy,omitempty"`
+}
+
+// SessionColumn is a Spanner column, and the source column it is
+// mapped from.
+type SessionColumn struct {
+	Name    string `json:"name"`
+	Source  string `json:"source,omitempty"` // Source column. Empty only for a synthetic primary key.
+	Type    string `json:"type"`             // Spanner type e.g. "STRING(MAX)" or "ARRAY<INT64>".
+	NotNull bool   `json:"notNull,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	// Column options keyed by option name e.g. allow_commit_timestamp,
+	// which is set for commit timestamp columns (these may have no
+	// source column, see ApplyTableOptions).
+	Options map[string]string `json:"options,omitempty"`
+	// AllowCommitTimestamp is the same as the allow_commit_timestamp
+	// option. Sessions written before Options have it instead.
+	AllowCommitTimestamp bool `json:"allowCommitTimestamp,omitempty"`
+}
+
+// Session returns a snapshot of conv's schema conversion state.
+func (conv *Conv) Session() *Session {
+	s := &Session{Version: sessionVersion, Tables: []SessionTable{}}
+	for _, srcTable := range sortedSrcTables(conv) {
+		spTable := conv.toSpanner[srcTable].name
+		ct := conv.spSchema[spTable]
+		toSrc := conv.toSource[spTable].cols
+		st := SessionTable{
+			Source: conv.srcSchema[srcTable],
+			Spanner: SessionSpannerTable{
+				Name:              spTable,
+				Columns:           []SessionColumn{},
+				PrimaryKeys:       ct.Pks,
+				ForeignKeys:       ct.ForeignKeys,
+				Indexes:           ct.Indexes,
+				Comment:           ct.Comment,
+				RowDeletionPolicy: ct.RowDeletionPolicy,
+			},
+			SyntheticKey: conv.syntheticPKeys[spTable].col,
+		}
+		for _, c := range ct.ColNames {
+			cd := ct.ColDefs[c]
+			st.Spanner.Columns = append(st.Spanner.Columns, SessionColumn{
+				Name:    c,
+				Source:  toSrc[c],
+				Type:    cd.PrintColumnDefType(),
+				NotNull: cd.NotNull,
+				Comment: cd.Comment,
+				Options: copyOptions(cd.Options),
+			})
+		}
+		for col, issues := range conv.issues[srcTable] {
+			if len(issues) == 0 {
+				continue
+			}
+			if st.Issues == nil {
+				st.Issues = make(map[string][]string)
+			}
+			for _, i := range issues {
+				st.Issues[col] = append(st.Issues[col], i.String())
+			}
+		}
+		s.Tables = append(s.Tables, st)
+	}
+	return s
+}
+
+// WriteSession writes a snapshot of conv's schema conversion state
+// (see Session) to w as JSON.
+func (conv *Conv) WriteSession(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(conv.Session())
+}
+
+// ReadSession reads a session file written by WriteSession (and
+// possibly hand-edited since).
+func ReadSession(r io.Reader) (*Session, error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	var s Session
+	if err := d.Decode(&s); err != nil {
+		return nil, fmt.Errorf("can't parse session: %w", err)
+	}
+	if s.Version != sessionVersion {
+		return nil, fmt.Errorf("unsupported session version %d (expected %d)", s.Version, sessionVersion)
+	}
+	return &s, nil
+}
+
+// ApplySession replaces conv's Spanner schema, table and column name
+// mappings, synthetic primary keys and schema issues with those from
+// s. It must be called after schema conversion, since it checks that
+// s matches the source schema found by schema conversion (same tables,
+// and the same columns in each table). If s doesn't match, conv is
+// unchanged, the problems found are recorded for the report, and an
+// error is returned.
+func (conv *Conv) ApplySession(s *Session) error {
+	var problems []string
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	inSession := make(map[string]bool)
+	for _, st := range s.Tables {
+		inSession[st.Source.Name] = true
+		if _, ok := conv.srcSchema[st.Source.Name]; !ok {
+			problem("Table %s is in the session, but not in the source database", st.Source.Name)
+		}
+	}
+	for _, t := range sortedSrcTables(conv) {
+		if !inSession[t] {
+			problem("Table %s is in the source database, but not in the session", t)
+		}
+	}
+	spSchema := make(map[string]ddl.CreateTable)
+	toSpanner := make(map[string]nameAndCols)
+	toSource := make(map[string]nameAndCols)
+	syntheticPKeys := make(map[string]syntheticPKey)
+	issues := make(map[string]map[string][]schemaIssue)
+	spTables := make(map[string]bool) // Spanner table names (lower case) used so far.
+	for _, st := range s.Tables {
+		srcTable, ok := conv.srcSchema[st.Source.Name]
+		if !ok {
+			continue
+		}
+		if n, m := len(st.Source.ColNames), len(srcTable.ColNames); n != m {
+			problem("Table %s has %d columns in the session, but %d in the source database", srcTable.Name, n, m)
+			continue
+		}
+		sp := st.Spanner
+		if sp.Name == "" {
+			problem("Table %s is mapped to a Spanner table with no name", srcTable.Name)
+			continue
+		}
+		if spTables[strings.ToLower(sp.Name)] {
+			problem("Table %s is mapped to Spanner table %s, which is already used", srcTable.Name, sp.Name)
+			continue
+		}
+		spTables[strings.ToLower(sp.Name)] = true
+		ct := ddl.CreateTable{
+			Name:              sp.Name,
+			ColDefs:           make(map[string]ddl.ColumnDef),
+			Pks:               sp.PrimaryKeys,
+			ForeignKeys:       sp.ForeignKeys,
+			Indexes:           sp.Indexes,
+			Comment:           sp.Comment,
+			RowDeletionPolicy: sp.RowDeletionPolicy,
+		}
+		toSp := nameAndCols{name: sp.Name, cols: make(map[string]string)}
+		toSrc := nameAndCols{name: srcTable.Name, cols: make(map[string]string)}
+		for _, c := range sp.Columns {
+			if _, ok := ct.ColDefs[c.Name]; ok || c.Name == "" {
+				problem("Spanner table %s has a missing or duplicate column name %q", sp.Name, c.Name)
+				continue
+			}
+			ty, isArray, err := parseColumnDefType(c.Type)
+			if err != nil {
+				problem("Column %s of Spanner table %s has a bad type: %s", c.Name, sp.Name, err)
+				continue
+			}
+			cd := ddl.ColumnDef{Name: c.Name, T: ty, IsArray: isArray, NotNull: c.NotNull, Comment: c.Comment, Options: copyOptions(c.Options)}
+			if c.AllowCommitTimestamp {
+				cd.SetOption(ddl.AllowCommitTimestamp, "true")
+			}
+			switch _, found := srcTable.ColDefs[c.Source]; {
+			case c.Source == "" && c.Name != st.SyntheticKey && !cd.AllowsCommitTimestamp():
+				problem("Column %s of Spanner table %s has no source column", c.Name, sp.Name)
+			case c.Source != "" && !found:
+				problem("Column %s of Spanner table %s is mapped from %s, which is not a column of source table %s", c.Name, sp.Name, c.Source, srcTable.Name)
+			case c.Source != "" && toSp.cols[c.Source] != "":
+				problem("Column %s of source table %s is mapped to more than one Spanner column", c.Source, srcTable.Name)
+			case c.Source != "":
+				toSp.cols[c.Source] = c.Name
+				toSrc.cols[c.Name] = c.Source
+			}
+			ct.ColNames = append(ct.ColNames, c.Name)
+			ct.ColDefs[c.Name] = cd
+		}
+		for _, col := range srcTable.ColNames {
+			if _, ok := toSp.cols[col]; !ok {
+				problem("Column %s of source table %s is not mapped to a Spanner column", col, srcTable.Name)
+			}
+		}
+		for _, k := range ct.Pks {
+			if _, ok := ct.ColDefs[k.Col]; !ok {
+				problem("Primary key of Spanner table %s uses unknown column %s", sp.Name, k.Col)
+			}
+		}
+		for _, i := range ct.Indexes {
+			if err := i.Validate(ct); err != nil {
+				problem("Spanner table %s has a bad index: %s", sp.Name, err)
+			}
+		}
+		if st.SyntheticKey != "" {
+			if _, ok := ct.ColDefs[st.SyntheticKey]; !ok {
+				problem("Synthetic primary key %s is not a column of Spanner table %s", st.SyntheticKey, sp.Name)
+			}
+			syntheticPKeys[sp.Name] = syntheticPKey{col: st.SyntheticKey}
+		}
+		issues[srcTable.Name] = make(map[string][]schemaIssue)
+		for col, names := range st.Issues {
+			for _, n := range names {
+				i, ok := parseSchemaIssue(n)
+				if !ok {
+					problem("Column %s of source table %s has unknown issue %q", col, srcTable.Name, n)
+					continue
+				}
+				issues[srcTable.Name][col] = append(issues[srcTable.Name][col], i)
+			}
+		}
+		spSchema[sp.Name] = ct
+		toSpanner[srcTable.Name] = toSp
+		toSource[sp.Name] = toSrc
+	}
+	if len(problems) > 0 {
+		conv.mismatches = problems
+		return fmt.Errorf("session doesn't match the source database (%d problems, see report), first problem: %s", len(problems), problems[0])
+	}
+	conv.spSchema = spSchema
+	conv.toSpanner = toSpanner
+	conv.toSource = toSource
+	conv.syntheticPKeys = syntheticPKeys
+	conv.issues = issues
+	return nil
+}
+
+// SpannerColumn describes a column of an existing Spanner database, as
+// found in Spanner's INFORMATION_SCHEMA.COLUMNS table.
+type SpannerColumn struct {
+	Table   string
+	Column  string
+	Type    string // SPANNER_TYPE e.g. "STRING(MAX)" or "ARRAY<INT64>".
+	NotNull bool
+	KeyPos  int64 // Position in the table's primary key (from 1), or zero if not a key column.
+	KeyDesc bool  // Key column is in descending order.
+}
+
+// ApplySpannerSchema updates conv's Spanner schema to match the columns
+// of an existing Spanner database, so that data conversion uses the
+// schema of the database (which may have been edited after schema
+// conversion e.g. during review) rather than the one generated by
+// schema conversion. Tables and columns are matched by Spanner name
+// (case insensitive), and column types and NOT NULL constraints are
+// taken from cols. Like ApplySession, it must be called after schema
+// conversion, and if the database doesn't match, conv is unchanged, the
+// problems found are recorded for the report, and an error is returned.
+func (conv *Conv) ApplySpannerSchema(cols []SpannerColumn) error {
+	var problems []string
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	db := make(map[string][]SpannerColumn) // Keyed by lower case table name.
+	for _, c := range cols {
+		t := strings.ToLower(c.Table)
+		db[t] = append(db[t], c)
+	}
+	s := conv.Session()
+	for i := range s.Tables {
+		sp := &s.Tables[i].Spanner
+		dbCols, ok := db[strings.ToLower(sp.Name)]
+		if !ok {
+			problem("Table %s is not in the Spanner database", sp.Name)
+			continue
+		}
+		byName := make(map[string]SpannerColumn)
+		for _, c := range dbCols {
+			byName[strings.ToLower(c.Column)] = c
+		}
+		mapped := make(map[string]bool)
+		for j := range sp.Columns {
+			c := &sp.Columns[j]
+			dbCol, ok := byName[strings.ToLower(c.Name)]
+			if !ok {
+				problem("Column %s of Spanner table %s is not in the Spanner database", c.Name, sp.Name)
+				continue
+			}
+			mapped[strings.ToLower(c.Name)] = true
+			c.Type = dbCol.Type
+			c.NotNull = dbCol.NotNull
+		}
+		for _, c := range dbCols {
+			if c.NotNull && !mapped[strings.ToLower(c.Column)] {
+				problem("Column %s of Spanner table %s is NOT NULL, but no source column is mapped to it", c.Column, sp.Name)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		conv.mismatches = problems
+		return fmt.Errorf("existing Spanner database doesn't match the source database (%d problems, see report), first problem: %s", len(problems), problems[0])
+	}
+	return conv.ApplySession(s)
+}
+
+// copyOptions returns a copy of column options m (nil if m is empty),
+// so that sessions and schemas don't share maps.
+func copyOptions(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// parseColumnDefType parses a Spanner column type as printed by
+// ddl.ColumnDef.PrintColumnDefType e.g. "STRING(MAX)" or "ARRAY<INT64>".
+func parseColumnDefType(s string) (ddl.ScalarType, bool, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	isArray := strings.HasPrefix(t, "ARRAY<") && strings.HasSuffix(t, ">")
+	if isArray {
+		t = strings.TrimSpace(t[len("ARRAY<") : len(t)-1])
+	}
+	o := TypeOverride{Type: t}
+	if i := strings.Index(t, "("); i >= 0 && strings.HasSuffix(t, ")") {
+		o.Type = strings.TrimSpace(t[:i])
+		if l := strings.TrimSpace(t[i+1 : len(t)-1]); l != "MAX" {
+			n, err := strconv.ParseInt(l, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, false, fmt.Errorf("bad length in %q", s)
+			}
+			o.Length = n
+		}
+	}
+	ty, err := o.spannerType()
+	return ty, isArray, err
+}
+
+// parseSchemaIssue maps the name of a schema issue (see
+// schemaIssue.String) back to the issue.
+func parseSchemaIssue(s string) (schemaIssue, bool) {
+	for i := range issueDB {
+		if i.String() == s {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+const sessionDump = "CREATE TABLE \"a-b\" (id bigint PRIMARY KEY, \"n m\" numeric, arr text[]);\n" +
+	"CREATE TABLE nopk (x int4);\n" +
+	"CREATE INDEX nopk_x ON nopk (x);\n"
+
+func TestSession_RoundTrip(t *testing.T) {
+	conv, _ := runProcessPgDump(sessionDump)
+	var buf bytes.Buffer
+	assert.Nil(t, conv.WriteSession(&buf))
+	s, err := ReadSession(&buf)
+	assert.Nil(t, err)
+
+	conv2, _ := runProcessPgDump(sessionDump)
+	conv2.spSchema = make(map[string]ddl.CreateTable)
+	assert.Nil(t, conv2.ApplySession(s))
+	assert.Equal(t, conv.spSchema, conv2.spSchema)
+	assert.Equal(t, conv.toSpanner, conv2.toSpanner)
+	assert.Equal(t, conv.toSource, conv2.toSource)
+	assert.Equal(t, conv.issues, conv2.issues)
+	assert.Equal(t, map[string]syntheticPKey{"nopk": syntheticPKey{col: "synth_id"}}, conv2.syntheticPKeys)
+	assert.Nil(t, conv2.mismatches)
+}
+
+func TestSession_ColumnOptions(t *testing.T) {
+	conv, _ := runProcessPgDump(sessionDump)
+	assert.Nil(t, conv.ApplyTableOptions(map[string]TableOptions{"nopk": {CommitTimestamp: &CommitTimestampOption{Column: "updated"}}}, time.Now()))
+	s := conv.Session()
+	cols := s.Tables[1].Spanner.Columns
+	assert.Equal(t, map[string]string{ddl.AllowCommitTimestamp: "true"}, cols[len(cols)-1].Options)
+
+	// Sessions written before column options use allowCommitTimestamp.
+	cols[len(cols)-1].Options = nil
+	cols[len(cols)-1].AllowCommitTimestamp = true
+	conv2, _ := runProcessPgDump(sessionDump)
+	assert.Nil(t, conv2.ApplySession(s))
+	assert.True(t, conv2.spSchema["nopk"].ColDefs["updated"].AllowsCommitTimestamp())
+}
+
+func TestSession_Mismatch(t *testing.T) {
+	conv, _ := runProcessPgDump(sessionDump)
+	tc := []struct {
+		name     string
+		edit     func(s *Session)
+		expected []string
+	}{
+		{
+			name: "missing table",
+			edit: func(s *Session) { s.Tables = s.Tables[1:] },
+			expected: []string{
+				"Table a-b is in the source database, but not in the session"},
+		},
+		{
+			name: "extra table",
+			edit: func(s *Session) {
+				st := s.Tables[1]
+				st.Source.Name = "other"
+				st.Spanner.Name = "other"
+				s.Tables = append(s.Tables, st)
+			},
+			expected: []string{
+				"Table other is in the session, but not in the source database"},
+		},
+		{
+			name: "column count",
+			edit: func(s *Session) { s.Tables[0].Source.ColNames = s.Tables[0].Source.ColNames[1:] },
+			expected: []string{
+				"Table a-b has 2 columns in the session, but 3 in the source database"},
+		},
+		{
+			name: "bad columns",
+			edit: func(s *Session) {
+				cols := s.Tables[0].Spanner.Columns
+				cols[0].Type = "STRING(-1)"
+				cols[1].Source = "nosuchcol"
+				cols[2].Source = ""
+			},
+			expected: []string{
+				"Column id of Spanner table a_b has a bad type: bad length in \"STRING(-1)\"",
+				"Column n_m of Spanner table a_b is mapped from nosuchcol, which is not a column of source table a-b",
+				"Column arr of Spanner table a_b has no source column",
+				"Column id of source table a-b is not mapped to a Spanner column",
+				"Column n m of source table a-b is not mapped to a Spanner column",
+				"Column arr of source table a-b is not mapped to a Spanner column",
+				"Primary key of Spanner table a_b uses unknown column id"},
+		},
+		{
+			name: "bad index",
+			edit: func(s *Session) { s.Tables[1].Spanner.Indexes[0].Keys[0].Col = "nosuchcol" },
+			expected: []string{
+				"Spanner table nopk has a bad index: index nopk_x uses unknown column nosuchcol"},
+		},
+		{
+			name: "duplicate table and unknown issue",
+			edit: func(s *Session) {
+				s.Tables[1].Spanner.Name = "A_B"
+				s.Tables[0].Issues["n m"] = []string{"nosuchissue"}
+			},
+			expected: []string{
+				"Column n m of source table a-b has unknown issue \"nosuchissue\"",
+				"Table nopk is mapped to Spanner table A_B, which is already used"},
+		},
+	}
+	for _, tc := range tc {
+		var buf bytes.Buffer
+		assert.Nil(t, conv.WriteSession(&buf))
+		s, err := ReadSession(&buf)
+		assert.Nil(t, err)
+		tc.edit(s)
+		conv2, _ := runProcessPgDump(sessionDump)
+		spSchema := conv2.spSchema
+		err = conv2.ApplySession(s)
+		assert.NotNil(t, err, tc.name)
+		assert.Equal(t, tc.expected, conv2.mismatches, tc.name)
+		assert.Equal(t, spSchema, conv2.spSchema, tc.name)
+	}
+}
+
+func TestApplySpannerSchema(t *testing.T) {
+	dbCols := []SpannerColumn{
+		{"A_B", "id", "INT64", true, 1, false},
+		{"a_b", "N_M", "STRING(100)", false, 0, false},
+		{"a_b", "arr", "ARRAY<STRING(MAX)>", false, 0, false},
+		{"nopk", "x", "INT64", false, 0, false},
+		{"nopk", "synth_id", "INT64", true, 1, false},
+		{"other", "y", "INT64", true, 1, false},
+	}
+	conv, _ := runProcessPgDump(sessionDump)
+	assert.Nil(t, conv.ApplySpannerSchema(dbCols))
+	assert.Nil(t, conv.mismatches)
+	cd := conv.spSchema["a_b"].ColDefs["n_m"]
+	assert.Equal(t, ddl.String{Len: ddl.Int64Length{Value: 100}}, cd.T)
+	assert.Equal(t, "n_m", conv.toSpanner["a-b"].cols["n m"])
+
+	conv, _ = runProcessPgDump(sessionDump)
+	spSchema := conv.spSchema
+	// Missing columns, and an extra NOT NULL column.
+	err := conv.ApplySpannerSchema([]SpannerColumn{dbCols[1], dbCols[2], dbCols[3], {"nopk", "z", "INT64", true, 0, false}})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{
+		"Column id of Spanner table a_b is not in the Spanner database",
+		"Column synth_id of Spanner table nopk is not in the Spanner database",
+		"Column z of Spanner table nopk is NOT NULL, but no source column is mapped to it"}, conv.mismatches)
+	assert.Equal(t, spSchema, conv.spSchema)
+
+	conv, _ = runProcessPgDump(sessionDump)
+	assert.NotNil(t, conv.ApplySpannerSchema(nil))
+	assert.Equal(t, []string{
+		"Table a_b is not in the Spanner database",
+		"Table nopk is not in the Spanner database"}, conv.mismatches)
+}
+
+func TestReadSession(t *testing.T) {
+	_, err := ReadSession(strings.NewReader(`{"version": 2, "tables": []}`))
+	assert.NotNil(t, err)
+	_, err = ReadSession(strings.NewReader(`{"version": 1, "tables": [], "extra": 1}`))
+	assert.NotNil(t, err)
+	s, err := ReadSession(strings.NewReader(`{"version": 1, "tables": []}`))
+	assert.Nil(t, err)
+	assert.Equal(t, &Session{Version: 1, Tables: []SessionTable{}}, s)
+}
+
+func TestParseColumnDefType(t *testing.T) {
+	tc := []struct {
+		in      string
+		ty      ddl.ScalarType
+		isArray bool
+		err     bool
+	}{
+		{in: "INT64", ty: ddl.Int64{}},
+		{in: "string(max)", ty: ddl.String{Len: ddl.MaxLength{}}},
+		{in: "STRING(42)", ty: ddl.String{Len: ddl.Int64Length{Value: 42}}},
+		{in: "ARRAY<BYTES(MAX)>", ty: ddl.Bytes{Len: ddl.MaxLength{}}, isArray: true},
+		{in: " ARRAY< NUMERIC > ", ty: ddl.Numeric{}, isArray: true},
+		{in: "STRING(0)", err: true},
+		{in: "STRING(x)", err: true},
+		{in: "INT64(8)", err: true},
+		{in: "ARRAY<FOO>", err: true},
+	}
+	for _, c := range tc {
+		ty, isArray, err := parseColumnDefType(c.in)
+		if c.err {
+			assert.NotNil(t, err, c.in)
+			continue
+		}
+		assert.Nil(t, err, c.in)
+		assert.Equal(t, c.ty, ty, c.in)
+		assert.Equal(t, c.isArray, isArray, c.in)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SqlQueryer is the part of *sql.DB and *sql.Tx used to read data and
+// row counts, so that they can be read in one transaction (see
+// BeginSnapshot).
+type SqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// BeginSnapshot starts a read-only REPEATABLE READ transaction on the
+// PostgreSQL database db. Reads in the transaction (e.g. SetRowStats
+// and ProcessSqlDataContext) all see the database as of the same point
+// in time, so tables are consistent with each other (e.g. for foreign
+// keys), and row counts match the rows read, however long the
+// conversion takes. The snapshot and its time are recorded in conv for
+// the report. The caller must end the transaction (e.g. with Rollback)
+// once data conversion is done.
+func BeginSnapshot(ctx context.Context, conv *Conv, db *sql.DB) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("can't start a read-only transaction: %w", err)
+	}
+	// PostgreSQL takes the snapshot at the transaction's first query, so
+	// this is the snapshot that all reads use.
+	var s ReportSnapshot
+	err = tx.QueryRowContext(ctx, "SELECT txid_current_snapshot()::text, now()").Scan(&s.ID, &s.Time)
+	conv.queryIssued(err)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("can't get the transaction's snapshot: %w", err)
+	}
+	conv.snapshot = &s
+	return tx, nil
+}
+
+// ExportSnapshot exports the snapshot of tx, a transaction started by
+// BeginSnapshot, and returns its identifier, so that other transactions
+// can read in the same snapshot (see JoinSnapshot). The snapshot can be
+// joined until tx ends.
+func ExportSnapshot(ctx context.Context, tx *sql.Tx) (string, error) {
+	var id string
+	if err := tx.QueryRowContext(ctx, "SELECT pg_export_snapshot()").Scan(&id); err != nil {
+		return "", fmt.Errorf("can't export the snapshot: %w", err)
+	}
+	return id, nil
+}
+
+// JoinSnapshot starts a read-only REPEATABLE READ transaction on db (in
+// a connection of its own) that reads in the snapshot exported as id
+// (see ExportSnapshot), so that its reads are consistent with those of
+// the exporting transaction.
+func JoinSnapshot(ctx context.Context, db *sql.DB, id string) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("can't start a read-only transaction: %w", err)
+	}
+	// SET TRANSACTION SNAPSHOT doesn't take parameters.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", strings.ReplaceAll(id, "'", "''"))); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("can't join snapshot %s: %w", id, err)
+	}
+	return tx, nil
+}
+
+// sourceReadError is the error of rows that couldn't be read from the
+// source database.
+type sourceReadError struct {
+	table string
+	err   error
+}
+
+func (e *sourceReadError) Error() string {
+	return fmt.Sprintf("can't read table %s from the source database: %v", e.table, e.err)
+}
+
+func (e *sourceReadError) Unwrap() error { return e.err }
+
+// readFailed is called when reading srcTable from the source database
+// fails with err, e.g. because the connection dropped. The table's rows
+// that weren't read (going by its row count) are counted as bad, so
+// that the report accounts for them, rather than the table just being
+// short of rows.
+func (conv *Conv) readFailed(srcTable string, err error) {
+	err = &sourceReadError{table: srcTable, err: err}
+	conv.unexpected(err.Error())
+	n := conv.stats.rows[srcTable] - conv.unsampledRows(srcTable) - conv.stats.goodRows[srcTable] - conv.stats.badRows[srcTable]
+	if s := conv.sampler; s != nil {
+		n = s.toSample(srcTable, n)
+	}
+	if n <= 0 {
+		return
+	}
+	conv.statsAddBadRows(srcTable, n)
+	conv.addBadRowCause(srcTable, badRowCause{reason: BadRowRead}, n)
+}
+
+// snapshotMsg describes the snapshot that data was read in, for the
+// report header, or returns "" if there was none.
+func snapshotMsg(s *ReportSnapshot) string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("Source data read in one snapshot as of %s (PostgreSQL snapshot %s)", s.Time.UTC().Format(time.RFC3339), s.ID)
+}
+
+// SourceDatabase returns the name of the database db is connected to.
+func SourceDatabase(ctx context.Context, db *sql.DB) (string, error) {
+	var name string
+	if err := db.QueryRowContext(ctx, "SELECT current_database()").Scan(&name); err != nil {
+		return "", fmt.Errorf("can't get the name of the source database: %w", err)
+	}
+	return name, nil
+}
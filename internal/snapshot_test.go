@@ -0,0 +1,85 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestBeginSnapshot(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	start := time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT txid_current_snapshot").WillReturnRows(sqlmock.NewRows([]string{"snapshot", "now"}).AddRow("1000:1005:1002", start))
+	mock.ExpectRollback()
+	conv := MakeConv()
+	tx, err := BeginSnapshot(context.Background(), conv, db)
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Rollback())
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, &ReportSnapshot{ID: "1000:1005:1002", Time: start}, conv.snapshot)
+	assert.Equal(t, "Source data read in one snapshot as of 2020-12-31T23:59:59Z (PostgreSQL snapshot 1000:1005:1002)", snapshotMsg(conv.snapshot))
+	var buf bytes.Buffer
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, &buf, nil))
+	assert.Contains(t, buf.String(), `"id": "1000:1005:1002"`)
+
+	db, mock, err = sqlmock.New()
+	assert.Nil(t, err)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT txid_current_snapshot").WillReturnError(errors.New("function txid_current_snapshot() does not exist"))
+	mock.ExpectRollback()
+	conv = MakeConv()
+	_, err = BeginSnapshot(context.Background(), conv, db)
+	assert.NotNil(t, err)
+	assert.Nil(t, conv.snapshot)
+	assert.Equal(t, "", snapshotMsg(nil))
+}
+
+func TestProcessSqlData_ReadErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	mock.ExpectQuery("SELECT table_schema, table_name FROM information_schema.tables").WillReturnRows(
+		sqlmock.NewRows([]string{"table_schema", "table_name"}).AddRow("public", "t").AddRow("public", "u"))
+	// The connection drops after two rows of t, so u can't be read.
+	mock.ExpectQuery(`SELECT [*] FROM "public"."t"`).WillReturnRows(
+		sqlmock.NewRows([]string{"a"}).AddRow(1).AddRow(2).AddRow(3).RowError(2, errors.New("connection reset by peer")))
+	mock.ExpectQuery(`SELECT [*] FROM "public"."u"`).WillReturnError(errors.New("driver: bad connection"))
+	conv := buildConv(
+		ddl.CreateTable{Name: "t", ColNames: []string{"a"}, ColDefs: map[string]ddl.ColumnDef{"a": {Name: "a", T: ddl.Int64{}}}},
+		schema.Table{Name: "t", ColNames: []string{"a"}, ColDefs: map[string]schema.Column{"a": {Name: "a", Type: schema.Type{Name: "int8"}}}})
+	conv.SetDataMode()
+	conv.statsAddRows("t", 5)
+	conv.statsAddRows("u", 4)
+	var written int
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) { written++ })
+	ProcessSqlData(conv, db)
+	assert.Equal(t, 2, written)
+	assert.Equal(t, int64(3), conv.stats.badRows["t"])
+	assert.Equal(t, int64(4), conv.stats.badRows["u"])
+	assert.Equal(t, badRowCauses{{reason: BadRowRead}: 3}, conv.stats.badCauses["t"])
+	assert.Equal(t, badRowCauses{{reason: BadRowRead}: 4}, conv.stats.badCauses["u"])
+	assert.Equal(t, "3 rows not read (error reading the source database)", badRowCause{reason: BadRowRead}.describe(3))
+}
@@ -0,0 +1,491 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// Tables of a source database can be read concurrently by several
+// readers (e.g. transactions in one snapshot, see JoinSnapshot), each
+// reading one table at a time. Readers only read: they send what they
+// read on a channel to the goroutine that called
+// ProcessSqlDataParallel, which converts the rows and updates the
+// stats. So Conv is only used by one goroutine, and the rows of each
+// table are converted in the order they were read.
+//
+// Tables with a primary key can be read in pages, in key order (see
+// SqlReadOptions.FetchSize). Each page starts after the key of the last
+// row read, so if a reader's connection is lost, a new connection can
+// carry on from there, and checkpoints can record how far each table
+// has been converted by the key of its last settled row.
+
+// sqlReadBuffer is the number of rows read ahead of conversion.
+const sqlReadBuffer = 1000
+
+// maxSqlReconnects is the limit on attempts to replace a reader's lost
+// connection while reading a table, without any rows being read in
+// between.
+const maxSqlReconnects = 3
+
+// SqlReadOptions configures how ProcessSqlDataParallel reads tables.
+type SqlReadOptions struct {
+	// If FetchSize is positive, tables with a primary key are read in
+	// pages of FetchSize rows, in key order, so that the source database
+	// doesn't hold the results of one query for the whole table. Other
+	// tables are read in one query.
+	FetchSize int64
+
+	// If Reconnect is non-nil, it is called to replace a reader whose
+	// connection was lost (e.g. with a new transaction in the same
+	// snapshot). Tables read in pages resume after the last row read;
+	// other tables resume from the start only if no rows were read.
+	Reconnect func(ctx context.Context) (SqlQueryer, error)
+}
+
+type sqlReadKind int
+
+const (
+	sqlTableStart sqlReadKind = iota // A reader queried a table.
+	sqlRow                           // A reader read a row of a table.
+	sqlTableEnd                      // A reader finished reading a table.
+	sqlQuery                         // A reader queried a table again, e.g. for the next page.
+	sqlReconnect                     // A reader replaced its lost connection.
+)
+
+// sqlRead is a message from a reader about table tables[table].
+type sqlRead struct {
+	table int
+	kind  sqlReadKind
+	cols  []string      // The table's columns (sqlTableStart only).
+	vals  []interface{} // The row's values (sqlRow only).
+	key   []string      // The row's primary key, for tables read in pages (sqlRow only, see keyText).
+	err   error         // The query's error, or (if it succeeded) the error getting its columns for sqlTableStart and sqlQuery; the scan error for sqlRow; the error that stopped reading for sqlTableEnd; the error that lost the connection for sqlReconnect.
+}
+
+// sqlTablePlan says how a reader reads a table.
+type sqlTablePlan struct {
+	table schemaAndName
+	key   []string // Primary key columns, if the table is read in pages.
+	after []string // Key to start after (see keyText), for tables resumed from a checkpoint.
+}
+
+// sqlReader is a reader of ProcessSqlDataParallel.
+type sqlReader struct {
+	db   SqlQueryer
+	opts SqlReadOptions
+}
+
+// sqlTableRead is the state of a table being converted.
+type sqlTableRead struct {
+	srcTable  string
+	spTable   string
+	srcCols   []string
+	spCols    []string
+	srcSchema schema.Table
+	spSchema  ddl.CreateTable
+	skip      bool // Rows aren't converted, since the table couldn't be read or its schemas weren't found.
+	done      bool
+	start     time.Time
+	rows      int64 // Rows read.
+	bytes     int64 // Bytes of rows read in the sample.
+}
+
+// ProcessSqlDataParallel is like ProcessSqlDataContext, but reads
+// tables concurrently, one with each of readers (e.g. connections to
+// the same database, or transactions in the same snapshot), as
+// configured by opts. It stops once ctx is done.
+func ProcessSqlDataParallel(ctx context.Context, conv *Conv, readers []SqlQueryer, opts SqlReadOptions) {
+	// TODO: refactor to use the set of tables computed by
+	// ProcessInfoSchema instead of computing them again.
+	tables, err := getTables(conv, readers[0])
+	if err != nil {
+		conv.unexpected(fmt.Sprintf("Couldn't get list of table: %s", err))
+		return
+	}
+	plans := make([]sqlTablePlan, len(tables))
+	next := make(chan int, len(tables))
+	for i, t := range tables {
+		plans[i] = planSqlRead(conv, t, opts.FetchSize)
+		next <- i
+	}
+	close(next)
+	out := make(chan sqlRead, sqlReadBuffer)
+	var wg sync.WaitGroup
+	for _, db := range readers {
+		wg.Add(1)
+		go func(rd *sqlReader) {
+			defer wg.Done()
+			for i := range next {
+				if !readSqlTable(ctx, rd, i, plans[i], out) {
+					return
+				}
+			}
+		}(&sqlReader{db: db, opts: opts})
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	state := make([]*sqlTableRead, len(tables))
+	for r := range out {
+		if ctx.Err() != nil {
+			continue // Readers stop once they see ctx is done.
+		}
+		switch r.kind {
+		case sqlTableStart:
+			state[r.table] = startSqlTable(conv, tables[r.table], r)
+		case sqlRow:
+			convertSqlRead(conv, state[r.table], r)
+		case sqlQuery:
+			conv.tableQueryIssued(tables[r.table].schema, r.err)
+		case sqlReconnect:
+			t := tables[r.table]
+			conv.unexpected(fmt.Sprintf("Reconnected to the source database to read table %s: %s", buildTableName(conv, t.schema, t.name), r.err))
+		case sqlTableEnd:
+			s := state[r.table]
+			if s.skip {
+				continue
+			}
+			if r.err != nil {
+				// Rows after the error weren't read (see readFailed).
+				conv.readFailed(s.srcTable, r.err)
+			}
+			s.done = true
+			conv.statsAddTiming(s.srcTable, conv.now().Sub(s.start), s.bytes)
+			conv.sqlTableDone(s.srcTable, s.rows)
+		}
+	}
+	// Tables still being read when ctx was done.
+	for _, s := range state {
+		if s != nil && !s.skip && !s.done {
+			conv.statsAddTiming(s.srcTable, conv.now().Sub(s.start), s.bytes)
+		}
+	}
+}
+
+// planSqlRead returns how table t is read: in pages of fetchSize rows
+// if it has a primary key (and fetchSize is positive), starting after
+// the key recorded by the checkpoint (if any). SQL Server tables are
+// read in one query, since the page queries use PostgreSQL's syntax.
+func planSqlRead(conv *Conv, t schemaAndName, fetchSize int64) sqlTablePlan {
+	p := sqlTablePlan{table: t}
+	if fetchSize <= 0 || conv.sqlserver {
+		return p
+	}
+	srcTable := buildTableName(conv, t.schema, t.name)
+	srcSchema := conv.srcSchema[srcTable]
+	for _, k := range srcSchema.PrimaryKeys {
+		if srcSchema.ColDefs[k.Column].Type.Name == "bytea" {
+			// Keys are passed to queries as text (see keyText),
+			// which doesn't work for bytea.
+			return sqlTablePlan{table: t}
+		}
+		p.key = append(p.key, k.Column)
+	}
+	if p.key != nil && conv.checkpoint != nil {
+		p.after = conv.checkpoint.resumeKey(srcTable)
+	}
+	return p
+}
+
+// query returns the query for the rows of p after key after (see
+// keyText), or from the start if after is nil, and its arguments.
+func (p sqlTablePlan) query(after []string, fetchSize int64) (string, []interface{}) {
+	// PostgreSQL schema and name can be arbitrary strings.
+	// Ideally we would pass schema/name as a query parameter,
+	// but PostgreSQL doesn't support this. So we quote it instead.
+	q := fmt.Sprintf(`SELECT * FROM "%s"."%s"`, p.table.schema, p.table.name)
+	if p.key == nil {
+		return q + ";", nil
+	}
+	var cols, params []string
+	var args []interface{}
+	for _, k := range p.key {
+		cols = append(cols, fmt.Sprintf(`"%s"`, k))
+	}
+	for i, v := range after {
+		params = append(params, fmt.Sprintf("$%d", i+1))
+		args = append(args, v)
+	}
+	if after != nil {
+		q += fmt.Sprintf(" WHERE (%s) > (%s)", strings.Join(cols, ", "), strings.Join(params, ", "))
+	}
+	return q + fmt.Sprintf(" ORDER BY %s LIMIT %d;", strings.Join(cols, ", "), fetchSize), args
+}
+
+// readSqlTable reads the table of plan t (tables[i]) with rd, sending
+// what it reads to out. It returns false if ctx is done.
+func readSqlTable(ctx context.Context, rd *sqlReader, i int, t sqlTablePlan, out chan<- sqlRead) bool {
+	send := func(r sqlRead) bool {
+		select {
+		case out <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	started := false
+	after := t.after
+	var keyIdx []int
+	failures := 0
+	for {
+		q, args := t.query(after, rd.opts.FetchSize)
+		rows, err := rd.db.QueryContext(ctx, q, args...)
+		var cols []string
+		if err == nil {
+			if cols, err = rows.Columns(); err != nil {
+				rows.Close()
+			}
+		}
+		if err != nil {
+			if (!started || t.key != nil) && rd.reconnect(ctx, err, &failures) {
+				if !send(sqlRead{table: i, kind: sqlQuery, err: err}) || !send(sqlRead{table: i, kind: sqlReconnect, err: err}) {
+					return false
+				}
+				continue
+			}
+			if !started {
+				return send(sqlRead{table: i, kind: sqlTableStart, err: err})
+			}
+			return send(sqlRead{table: i, kind: sqlQuery, err: err}) && send(sqlRead{table: i, kind: sqlTableEnd, err: err})
+		}
+		if started {
+			if !send(sqlRead{table: i, kind: sqlQuery}) {
+				rows.Close()
+				return false
+			}
+		} else {
+			started = true
+			if !send(sqlRead{table: i, kind: sqlTableStart, cols: cols}) {
+				rows.Close()
+				return false
+			}
+			if keyIdx, err = keyIndexes(cols, t.key); err != nil {
+				rows.Close()
+				return send(sqlRead{table: i, kind: sqlTableEnd, err: err})
+			}
+		}
+		var n int64
+		advanced := false
+		for ctx.Err() == nil && rows.Next() {
+			v, iv := buildVals(len(cols))
+			err := rows.Scan(iv...)
+			var key []string
+			if keyIdx != nil && err == nil {
+				key = keyText(v, keyIdx)
+				after, advanced = key, true
+			}
+			if !send(sqlRead{table: i, kind: sqlRow, vals: v, key: key, err: err}) {
+				rows.Close()
+				return false
+			}
+			n++
+		}
+		err = rows.Err()
+		rows.Close()
+		if ctx.Err() != nil {
+			return false
+		}
+		if n > 0 {
+			failures = 0
+		}
+		if err != nil {
+			// Rows of tables that aren't read in pages can't be
+			// resumed, but the next table needs a connection.
+			if rd.reconnect(ctx, err, &failures) && t.key != nil {
+				if !send(sqlRead{table: i, kind: sqlReconnect, err: err}) {
+					return false
+				}
+				continue
+			}
+			return send(sqlRead{table: i, kind: sqlTableEnd, err: err})
+		}
+		if t.key == nil || n < rd.opts.FetchSize {
+			return send(sqlRead{table: i, kind: sqlTableEnd})
+		}
+		if !advanced {
+			return send(sqlRead{table: i, kind: sqlTableEnd, err: fmt.Errorf("can't read past row with key %v", after)})
+		}
+	}
+}
+
+// canReconnect returns whether rd can replace its connection after err,
+// given the number of failures since rows were last read.
+func (rd *sqlReader) canReconnect(err error, failures int) bool {
+	return rd.opts.Reconnect != nil && connectionLost(err) && failures < maxSqlReconnects
+}
+
+// reconnect replaces rd's connection if it was lost (as reported by
+// err), counting the attempt in failures. It returns false if the
+// connection wasn't replaced.
+func (rd *sqlReader) reconnect(ctx context.Context, err error, failures *int) bool {
+	if !rd.canReconnect(err, *failures) {
+		return false
+	}
+	*failures++
+	db, err := rd.opts.Reconnect(ctx)
+	if err != nil {
+		Log().Warnf("Can't reconnect to the source database: %s", err)
+		return false
+	}
+	rd.db = db
+	return true
+}
+
+// connectionLost returns whether err means that the connection to the
+// source database was lost, so that a new connection might succeed.
+func connectionLost(err error) bool {
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return true
+	case errors.As(err, &pqErr):
+		// Connection exceptions, and the server shutting down.
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02"
+	case errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
+
+// keyIndexes returns the positions of the key columns in cols.
+func keyIndexes(cols, key []string) ([]int, error) {
+	var idx []int
+	for _, k := range key {
+		i := 0
+		for i < len(cols) && cols[i] != k {
+			i++
+		}
+		if i == len(cols) {
+			return nil, fmt.Errorf("primary key column %s wasn't read", k)
+		}
+		idx = append(idx, i)
+	}
+	return idx, nil
+}
+
+// keyText returns the values of vals at idx (the key columns of a row)
+// as text that PostgreSQL reads back as the same values, when passed as
+// query parameters compared with the key columns.
+func keyText(vals []interface{}, idx []int) []string {
+	var key []string
+	for _, i := range idx {
+		switch v := vals[i].(type) {
+		case []byte:
+			key = append(key, string(v))
+		case time.Time:
+			key = append(key, v.Format(time.RFC3339Nano))
+		case float64:
+			key = append(key, strconv.FormatFloat(v, 'g', -1, 64))
+		default:
+			key = append(key, fmt.Sprint(v))
+		}
+	}
+	return key
+}
+
+// startSqlTable sets up the conversion of the rows of table t, given
+// r, the sqlTableStart message of its reader.
+func startSqlTable(conv *Conv, t schemaAndName, r sqlRead) *sqlTableRead {
+	srcTable := buildTableName(conv, t.schema, t.name)
+	s := &sqlTableRead{srcTable: srcTable, srcCols: r.cols, skip: true}
+	if r.cols == nil {
+		conv.tableQueryIssued(t.schema, r.err)
+		if r.err != nil {
+			conv.readFailed(srcTable, r.err)
+			return s
+		}
+	} else {
+		conv.tableQueryIssued(t.schema, nil)
+	}
+	spTable, err2 := GetSpannerTable(conv, srcTable)
+	spCols, err3 := GetSpannerCols(conv, srcTable, r.cols)
+	spSchema, ok1 := conv.spSchema[spTable]
+	srcSchema, ok2 := conv.srcSchema[srcTable]
+	if r.err != nil || err2 != nil || err3 != nil || !ok1 || !ok2 {
+		conv.statsAddBadRows(srcTable, conv.stats.rows[srcTable])
+		conv.unexpected(fmt.Sprintf("Can't get cols and schemas for table %s: err1=%s, err2=%s, err3=%s, ok1=%t, ok2=%t",
+			srcTable, r.err, err2, err3, ok1, ok2))
+		return s
+	}
+	s.spTable, s.spCols, s.spSchema, s.srcSchema = spTable, spCols, spSchema, srcSchema
+	s.skip = false
+	s.start = conv.now()
+	conv.metrics.startTable(srcTable)
+	return s
+}
+
+// convertSqlRead converts a row of s, given r, the sqlRow message of its
+// reader.
+func convertSqlRead(conv *Conv, s *sqlTableRead, r sqlRead) {
+	if s.skip {
+		return
+	}
+	srcTable := s.srcTable
+	if r.err != nil {
+		if conv.checkpoint != nil && conv.checkpoint.startKeyed(srcTable, r.key) {
+			conv.checkpoint.badConversion()
+		}
+		conv.unexpected(fmt.Sprintf("Couldn't process sql data row: %s", r.err))
+		// Scan failed, so we don't have any data to add to bad rows.
+		conv.statsAddBadRow(srcTable, conv.dataMode())
+		return
+	}
+	v := r.vals
+	n := valsBytes(v)
+	s.rows++
+	conv.sqlRowRead(srcTable, s.rows, n)
+	if !conv.sampleRow(srcTable) {
+		return
+	}
+	s.bytes += n
+	if conv.checkpoint != nil && !conv.checkpoint.startKeyed(srcTable, r.key) {
+		return // Row was settled by a previous run.
+	}
+	if conv.mismatchedRow(srcTable, s.srcCols, valsToStrings(v)) {
+		if conv.checkpoint != nil {
+			conv.checkpoint.badConversion()
+		}
+		return
+	}
+	cvtCols, cvtVals, err := ConvertSqlRow(conv, srcTable, s.srcCols, s.srcSchema, s.spTable, s.spCols, s.spSchema, v)
+	if err != nil {
+		if conv.checkpoint != nil {
+			conv.checkpoint.badConversion()
+		}
+		conv.unexpected(fmt.Sprintf("Couldn't process sql data row: %s", err))
+		conv.statsAddBadRow(srcTable, conv.dataMode())
+		conv.CollectBadRow(srcTable, s.srcCols, valsToStrings(v), err)
+		return
+	}
+	conv.WriteRow(srcTable, s.spTable, cvtCols, cvtVals)
+}
@@ -0,0 +1,204 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestProcessSqlDataParallel(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	// Which reader reads which table varies, so both share db.
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT table_schema, table_name FROM information_schema.tables").WillReturnRows(
+		sqlmock.NewRows([]string{"table_schema", "table_name"}).AddRow("public", "t").AddRow("public", "u").AddRow("public", "v"))
+	for _, x := range []string{"t", "u", "v"} {
+		rows := sqlmock.NewRows([]string{"a"})
+		for i := 1; i <= 50; i++ {
+			rows.AddRow(i)
+		}
+		mock.ExpectQuery(`SELECT [*] FROM "public"."` + x + `"`).WillReturnRows(rows)
+	}
+	conv := MakeConv()
+	for _, x := range []string{"t", "u", "v"} {
+		c := buildConv(
+			ddl.CreateTable{Name: x, ColNames: []string{"a"}, ColDefs: map[string]ddl.ColumnDef{"a": {Name: "a", T: ddl.Int64{}}}},
+			schema.Table{Name: x, ColNames: []string{"a"}, ColDefs: map[string]schema.Column{"a": {Name: "a", Type: schema.Type{Name: "int8"}}}})
+		conv.spSchema[x], conv.srcSchema[x] = c.spSchema[x], c.srcSchema[x]
+		conv.toSource[x], conv.toSpanner[x] = c.toSource[x], c.toSpanner[x]
+		conv.statsAddRows(x, 50)
+	}
+	conv.SetDataMode()
+	written := map[string][]int64{}
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {
+		written[table] = append(written[table], vals[0].(int64))
+	})
+	ProcessSqlDataParallel(context.Background(), conv, []SqlQueryer{db, db}, SqlReadOptions{})
+	assert.Nil(t, mock.ExpectationsWereMet())
+	for _, x := range []string{"t", "u", "v"} {
+		// Rows of each table are converted in order.
+		assert.Len(t, written[x], 50, x)
+		for i, v := range written[x] {
+			assert.Equal(t, int64(i+1), v, x)
+		}
+		assert.Equal(t, int64(50), conv.stats.goodRows[x], x)
+	}
+	assert.Equal(t, int64(0), conv.BadRows())
+	assert.Equal(t, queryStats{queries: 4}, conv.stats.queries)
+}
+
+// keyedConv returns a Conv in data mode for table t, with primary key
+// column a, and 5 rows. Converted values of a are appended to written.
+func keyedConv(written *[]int64) *Conv {
+	conv := buildConv(
+		ddl.CreateTable{Name: "t", ColNames: []string{"a"}, ColDefs: map[string]ddl.ColumnDef{"a": {Name: "a", T: ddl.Int64{}}}, Pks: []ddl.IndexKey{{Col: "a"}}},
+		schema.Table{Name: "t", ColNames: []string{"a"}, ColDefs: map[string]schema.Column{"a": {Name: "a", Type: schema.Type{Name: "int8"}}}, PrimaryKeys: []schema.Key{{Column: "a"}}})
+	conv.statsAddRows("t", 5)
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {
+		*written = append(*written, vals[0].(int64))
+	})
+	return conv
+}
+
+func TestSqlTablePlan_Query(t *testing.T) {
+	table := schemaAndName{schema: "public", name: "t"}
+	tests := []struct {
+		name  string
+		plan  sqlTablePlan
+		after []string
+		query string
+		args  []interface{}
+	}{
+		{"no key", sqlTablePlan{table: table}, nil, `SELECT * FROM "public"."t";`, nil},
+		{"first page", sqlTablePlan{table: table, key: []string{"a"}}, nil, `SELECT * FROM "public"."t" ORDER BY "a" LIMIT 100;`, nil},
+		{"next page", sqlTablePlan{table: table, key: []string{"a"}}, []string{"7"}, `SELECT * FROM "public"."t" WHERE ("a") > ($1) ORDER BY "a" LIMIT 100;`, []interface{}{"7"}},
+		{"composite key", sqlTablePlan{table: table, key: []string{"a", "b"}}, []string{"7", "x"}, `SELECT * FROM "public"."t" WHERE ("a", "b") > ($1, $2) ORDER BY "a", "b" LIMIT 100;`, []interface{}{"7", "x"}},
+	}
+	for _, tc := range tests {
+		q, args := tc.plan.query(tc.after, 100)
+		assert.Equal(t, tc.query, q, tc.name)
+		assert.Equal(t, tc.args, args, tc.name)
+	}
+}
+
+func TestPlanSqlRead(t *testing.T) {
+	conv := buildConv(
+		ddl.CreateTable{Name: "t", ColNames: []string{"a", "b"}},
+		schema.Table{Name: "t", ColNames: []string{"a", "b"}, ColDefs: map[string]schema.Column{"a": {Name: "a", Type: schema.Type{Name: "int8"}}, "b": {Name: "b", Type: schema.Type{Name: "bytea"}}}, PrimaryKeys: []schema.Key{{Column: "a"}}})
+	table := schemaAndName{schema: "public", name: "t"}
+	assert.Equal(t, sqlTablePlan{table: table, key: []string{"a"}}, planSqlRead(conv, table, 100))
+	assert.Equal(t, sqlTablePlan{table: table}, planSqlRead(conv, table, 0))
+	// Keys are passed to queries as text, which doesn't work for bytea.
+	conv.srcSchema["t"] = schema.Table{Name: "t", ColNames: []string{"a", "b"}, ColDefs: conv.srcSchema["t"].ColDefs, PrimaryKeys: []schema.Key{{Column: "a"}, {Column: "b"}}}
+	assert.Equal(t, sqlTablePlan{table: table}, planSqlRead(conv, table, 100))
+}
+
+func TestKeyText(t *testing.T) {
+	vals := []interface{}{int64(7), []byte("x"), time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC), 0.1, true}
+	assert.Equal(t, []string{"7", "x", "2020-01-02T03:04:05.0000006Z", "0.1", "true"}, keyText(vals, []int{0, 1, 2, 3, 4}))
+	assert.Equal(t, []string{"true", "7"}, keyText(vals, []int{4, 0}))
+}
+
+func TestProcessSqlDataParallel_Reconnect(t *testing.T) {
+	db1, mock1, err := sqlmock.New()
+	assert.Nil(t, err)
+	db2, mock2, err := sqlmock.New()
+	assert.Nil(t, err)
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	mock1.ExpectQuery("SELECT table_schema, table_name FROM information_schema.tables").WillReturnRows(
+		sqlmock.NewRows([]string{"table_schema", "table_name"}).AddRow("public", "t"))
+	// The connection is reset after the first 2 rows of the first page.
+	mock1.ExpectQuery(`SELECT [*] FROM "public"."t" ORDER BY "a" LIMIT 3;`).WillReturnRows(
+		sqlmock.NewRows([]string{"a"}).AddRow(1).AddRow(2).AddRow(3).RowError(2, reset))
+	mock2.ExpectQuery(`SELECT [*] FROM "public"."t" WHERE [(]"a"[)] > [(][$]1[)] ORDER BY "a" LIMIT 3;`).WithArgs("2").WillReturnRows(
+		sqlmock.NewRows([]string{"a"}).AddRow(3).AddRow(4).AddRow(5))
+	mock2.ExpectQuery(`SELECT [*] FROM "public"."t" WHERE [(]"a"[)] > [(][$]1[)] ORDER BY "a" LIMIT 3;`).WithArgs("5").WillReturnRows(
+		sqlmock.NewRows([]string{"a"}))
+	var written []int64
+	conv := keyedConv(&written)
+	reconnects := 0
+	opts := SqlReadOptions{
+		FetchSize: 3,
+		Reconnect: func(ctx context.Context) (SqlQueryer, error) {
+			reconnects++
+			return db2, nil
+		},
+	}
+	ProcessSqlDataParallel(context.Background(), conv, []SqlQueryer{db1}, opts)
+	assert.Nil(t, mock1.ExpectationsWereMet())
+	assert.Nil(t, mock2.ExpectationsWereMet())
+	assert.Equal(t, 1, reconnects)
+	// Reading resumes after the last row read, so no row is converted
+	// twice.
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, written)
+	assert.Equal(t, int64(5), conv.stats.goodRows["t"])
+	assert.Equal(t, int64(0), conv.BadRows())
+	assert.Equal(t, queryStats{queries: 4}, conv.stats.queries)
+	assert.Equal(t, 1, len(conv.stats.unexpected))
+	for u := range conv.stats.unexpected {
+		assert.Contains(t, u, "Reconnected to the source database to read table t")
+	}
+}
+
+func TestProcessSqlDataParallel_Checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	db, mock, err := sqlmock.New()
+	assert.Nil(t, err)
+	mock.ExpectQuery("SELECT table_schema, table_name FROM information_schema.tables").WillReturnRows(
+		sqlmock.NewRows([]string{"table_schema", "table_name"}).AddRow("public", "t"))
+	// The previous run settled rows up to key 2.
+	mock.ExpectQuery(`SELECT [*] FROM "public"."t" WHERE [(]"a"[)] > [(][$]1[)] ORDER BY "a" LIMIT 10;`).WithArgs("2").WillReturnRows(
+		sqlmock.NewRows([]string{"a"}).AddRow(3).AddRow(4).AddRow(5))
+	resume := &Checkpoint{
+		Version:  checkpointVersion,
+		DumpHash: "postgres:db",
+		Tables:   map[string]TableCheckpoint{"t": {Rows: 2, Key: []string{"2"}}},
+	}
+	tracker, err := NewCheckpointTracker(filepath.Join(dir, "checkpoint.json"), "postgres:db", resume)
+	assert.Nil(t, err)
+	var written []int64
+	conv := keyedConv(&written)
+	var ids []int64
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {
+		written = append(written, vals[0].(int64))
+		ids = append(ids, tracker.CurrentRow())
+	})
+	conv.SetCheckpointTracker(tracker)
+	ProcessSqlDataParallel(context.Background(), conv, []SqlQueryer{db}, SqlReadOptions{FetchSize: 10})
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []int64{3, 4, 5}, written)
+	assert.Equal(t, int64(5), conv.stats.goodRows["t"])
+	tracker.Settle(ids[:2], nil)
+	assert.Equal(t, TableCheckpoint{Rows: 4, Key: []string{"4"}}, tracker.Checkpoint().Tables["t"])
+	tracker.Settle(ids[2:], nil)
+	assert.Equal(t, TableCheckpoint{Rows: 5, Key: []string{"5"}}, tracker.Checkpoint().Tables["t"])
+}
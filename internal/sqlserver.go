@@ -0,0 +1,272 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+)
+
+// SQL Server databases are read via database/sql, like PostgreSQL
+// databases, but their schema comes from SQL Server's catalog views
+// (sys.tables, sys.columns, sys.indexes and sys.foreign_keys), which
+// cover identity columns, rowversion columns and indexes. Tables in
+// the default schema (dbo) are named by their bare name. Data is read
+// with the same SELECT queries as PostgreSQL data (see
+// ProcessSqlDataParallel): SQL Server accepts the double-quoted names
+// they use, since connections have QUOTED_IDENTIFIER on by default.
+
+// ProcessSQLServerSchema performs schema conversion for SQL Server
+// database db.
+func ProcessSQLServerSchema(conv *Conv, db *sql.DB) error {
+	conv.sqlserver = true
+	tables, err := getTables(conv, db)
+	if err != nil {
+		return err
+	}
+	found := make(map[string]bool)
+	for _, t := range tables {
+		found[t.schema] = true
+		if err := processSQLServerTable(conv, db, t); err != nil {
+			return err
+		}
+	}
+	for _, s := range sortedSchemas(conv.schemas) {
+		if !found[s] {
+			conv.unexpected(fmt.Sprintf("No tables found in source schema %s", s))
+		}
+	}
+	schemaToDDL(conv)
+	conv.AddPrimaryKeys()
+	return nil
+}
+
+func processSQLServerTable(conv *Conv, db *sql.DB, table schemaAndName) error {
+	colDefs, colNames, err := getSQLServerColumns(conv, db, table)
+	if err != nil {
+		return fmt.Errorf("couldn't get schema for table %s.%s: %s", table.schema, table.name, err)
+	}
+	primaryKeys, indexes, err := getSQLServerIndexes(conv, db, table)
+	if err != nil {
+		return fmt.Errorf("couldn't get indexes for table %s.%s: %s", table.schema, table.name, err)
+	}
+	foreignKeys, err := getSQLServerForeignKeys(conv, db, table)
+	if err != nil {
+		return fmt.Errorf("couldn't get foreign key constraints for table %s.%s: %s", table.schema, table.name, err)
+	}
+	name := buildTableName(conv, table.schema, table.name)
+	conv.srcSchema[name] = schema.Table{
+		Name:        name,
+		ColNames:    colNames,
+		ColDefs:     colDefs,
+		PrimaryKeys: primaryKeys,
+		Indexes:     indexes,
+		ForeignKeys: foreignKeys}
+	conv.tableSchemaRead(table.schema, len(colNames))
+	return nil
+}
+
+// getSQLServerColumns returns the columns of a table. Columns of alias
+// types (e.g. sysname) get the system type they are based on.
+func getSQLServerColumns(conv *Conv, db *sql.DB, table schemaAndName) (map[string]schema.Column, []string, error) {
+	q := `SELECT c.name, CASE WHEN ty.is_user_defined = 1 AND ty.is_assembly_type = 0 THEN TYPE_NAME(c.system_type_id) ELSE ty.name END,
+                c.max_length, c.precision, c.scale, c.is_nullable, c.is_identity, OBJECT_DEFINITION(c.default_object_id)
+              FROM sys.columns AS c
+                INNER JOIN sys.tables AS t ON t.object_id = c.object_id
+                INNER JOIN sys.schemas AS s ON s.schema_id = t.schema_id
+                INNER JOIN sys.types AS ty ON ty.user_type_id = c.user_type_id
+              WHERE s.name = @p1 AND t.name = @p2 ORDER BY c.column_id;`
+	rows, err := db.Query(q, table.schema, table.name)
+	conv.tableQueryIssued(table.schema, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	colDefs := make(map[string]schema.Column)
+	var colNames []string
+	var name, dataType string
+	var maxLength, precision, scale int64
+	var nullable, identity bool
+	var colDefault sql.NullString
+	for rows.Next() {
+		err := rows.Scan(&name, &dataType, &maxLength, &precision, &scale, &nullable, &identity, &colDefault)
+		if err != nil {
+			conv.unexpected(fmt.Sprintf("Can't scan column: %v", err))
+			continue
+		}
+		ignored := schema.Ignored{Identity: identity, Default: colDefault.Valid}
+		if colDefault.Valid {
+			ignored.Sequence, ignored.Now = sqlServerDefaultKind(colDefault.String)
+		}
+		colDefs[name] = schema.Column{
+			Name:    name,
+			Type:    sqlServerType(dataType, maxLength, precision, scale),
+			NotNull: !nullable,
+			Ignored: ignored,
+		}
+		colNames = append(colNames, name)
+	}
+	return colDefs, colNames, rows.Err()
+}
+
+// sqlServerType returns the source type of a column of SQL Server type
+// dataType, given its sys.columns max_length (in bytes, -1 for MAX),
+// precision and scale. The lengths of character types are in
+// characters, as in their declarations e.g. nvarchar(10).
+func sqlServerType(dataType string, maxLength, precision, scale int64) schema.Type {
+	switch dataType {
+	case "char", "varchar", "binary", "varbinary":
+		if maxLength > 0 {
+			return schema.Type{Name: dataType, Mods: []int64{maxLength}}
+		}
+	case "nchar", "nvarchar":
+		// UTF-16 code units, of 2 bytes each.
+		if maxLength > 0 {
+			return schema.Type{Name: dataType, Mods: []int64{maxLength / 2}}
+		}
+	case "decimal", "numeric":
+		return schema.Type{Name: dataType, Mods: []int64{precision, scale}}
+	case "timestamp":
+		// A deprecated synonym of rowversion, which is clearer (the
+		// values aren't times).
+		return schema.Type{Name: "rowversion"}
+	}
+	return schema.Type{Name: dataType}
+}
+
+// sqlServerDefaultKind reports whether column default d (from
+// OBJECT_DEFINITION e.g. "(getdate())") is the next value of a
+// sequence, or the current time.
+func sqlServerDefaultKind(d string) (sequence, now bool) {
+	d = strings.ToLower(strings.Trim(d, "() "))
+	switch {
+	case strings.HasPrefix(d, "next value for"):
+		return true, false
+	case strings.HasPrefix(d, "getdate"), strings.HasPrefix(d, "getutcdate"), strings.HasPrefix(d, "sysdatetime"),
+		strings.HasPrefix(d, "sysutcdatetime"), strings.HasPrefix(d, "current_timestamp"):
+		return false, true
+	}
+	return false, false
+}
+
+// getSQLServerIndexes returns the primary key and the secondary indexes
+// of a table (including the indexes that implement unique constraints).
+// sys.index_columns has one row per index column, and we group rows by
+// index. Indexes other than B-trees (e.g. columnstore and XML indexes)
+// have their kind as their method, so that they are reported as
+// unsupported.
+func getSQLServerIndexes(conv *Conv, db *sql.DB, table schemaAndName) ([]schema.Key, []schema.Index, error) {
+	q := `SELECT i.name, i.is_primary_key, i.is_unique, i.type_desc, i.has_filter, c.name, ic.is_descending_key, ic.is_included_column
+              FROM sys.indexes AS i
+                INNER JOIN sys.tables AS t ON t.object_id = i.object_id
+                INNER JOIN sys.schemas AS s ON s.schema_id = t.schema_id
+                INNER JOIN sys.index_columns AS ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+                INNER JOIN sys.columns AS c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+              WHERE s.name = @p1 AND t.name = @p2 AND i.type > 0 AND i.is_hypothetical = 0
+              ORDER BY i.name, ic.is_included_column, ic.key_ordinal, ic.index_column_id;`
+	rows, err := db.Query(q, table.schema, table.name)
+	conv.tableQueryIssued(table.schema, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var primaryKeys []schema.Key
+	var indexes []schema.Index
+	var name, kind, col string
+	var primary, unique, filter, desc, included bool
+	for rows.Next() {
+		err := rows.Scan(&name, &primary, &unique, &kind, &filter, &col, &desc, &included)
+		if err != nil {
+			conv.unexpected(fmt.Sprintf("Can't scan index: %v", err))
+			continue
+		}
+		if primary {
+			primaryKeys = append(primaryKeys, schema.Key{Column: col, Desc: desc})
+			continue
+		}
+		if n := len(indexes); n == 0 || indexes[n-1].Name != name {
+			index := schema.Index{Name: name, Unique: unique}
+			index.Ignored.Predicate = filter
+			if kind != "CLUSTERED" && kind != "NONCLUSTERED" {
+				index.Ignored.Method = strings.ToLower(kind)
+			}
+			indexes = append(indexes, index)
+		}
+		index := &indexes[len(indexes)-1]
+		if included {
+			index.Storing = append(index.Storing, col)
+		} else {
+			index.Keys = append(index.Keys, schema.Key{Column: col, Desc: desc})
+		}
+	}
+	return primaryKeys, indexes, rows.Err()
+}
+
+// getSQLServerForeignKeys returns the foreign key constraints of a
+// table. sys.foreign_key_columns has one row per column of each
+// constraint, and we group rows by constraint.
+func getSQLServerForeignKeys(conv *Conv, db *sql.DB, table schemaAndName) ([]schema.ForeignKey, error) {
+	q := `SELECT fk.name, pc.name, rs.name, rt.name, rc.name, fk.delete_referential_action_desc, fk.update_referential_action_desc
+              FROM sys.foreign_keys AS fk
+                INNER JOIN sys.tables AS t ON t.object_id = fk.parent_object_id
+                INNER JOIN sys.schemas AS s ON s.schema_id = t.schema_id
+                INNER JOIN sys.foreign_key_columns AS fkc ON fkc.constraint_object_id = fk.object_id
+                INNER JOIN sys.columns AS pc ON pc.object_id = fkc.parent_object_id AND pc.column_id = fkc.parent_column_id
+                INNER JOIN sys.tables AS rt ON rt.object_id = fkc.referenced_object_id
+                INNER JOIN sys.schemas AS rs ON rs.schema_id = rt.schema_id
+                INNER JOIN sys.columns AS rc ON rc.object_id = fkc.referenced_object_id AND rc.column_id = fkc.referenced_column_id
+              WHERE s.name = @p1 AND t.name = @p2 ORDER BY fk.name, fkc.constraint_column_id;`
+	rows, err := db.Query(q, table.schema, table.name)
+	conv.tableQueryIssued(table.schema, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fks []schema.ForeignKey
+	var name, col, referSchema, referTable, referCol, onDelete, onUpdate string
+	for rows.Next() {
+		err := rows.Scan(&name, &col, &referSchema, &referTable, &referCol, &onDelete, &onUpdate)
+		if err != nil {
+			conv.unexpected(fmt.Sprintf("Can't scan foreign key: %v", err))
+			continue
+		}
+		if n := len(fks); n > 0 && fks[n-1].Name == name {
+			fks[n-1].Columns = append(fks[n-1].Columns, col)
+			fks[n-1].ReferColumns = append(fks[n-1].ReferColumns, referCol)
+			continue
+		}
+		fks = append(fks, schema.ForeignKey{
+			Name:         name,
+			Columns:      []string{col},
+			ReferTable:   buildTableName(conv, referSchema, referTable),
+			ReferColumns: []string{referCol},
+			// e.g. SET_NULL, for SET NULL.
+			OnDelete: strings.Replace(onDelete, "_", " ", -1),
+			OnUpdate: strings.Replace(onUpdate, "_", " ", -1),
+		})
+	}
+	return fks, rows.Err()
+}
+
+// sqlServerUUID returns the text of uniqueidentifier value b e.g.
+// 6F9619FF-8B86-D011-B42D-00C04FC964FF, as SQL Server shows it. SQL
+// Server stores the first three groups little-endian.
+func sqlServerUUID(b []byte) string {
+	return fmt.Sprintf("%X-%X-%X-%X-%X",
+		[]byte{b[3], b[2], b[1], b[0]}, []byte{b[5], b[4]}, []byte{b[7], b[6]}, b[8:10], b[10:16])
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+const sqlServerTablesQuery = "SELECT s.name, t.name FROM sys.tables AS t (.+)"
+
+func TestProcessSQLServerSchema(t *testing.T) {
+	uid := []byte{0xff, 0x19, 0x96, 0x6f, 0x86, 0x8b, 0x11, 0xd0, 0xb4, 0x2d, 0x00, 0xc0, 0x4f, 0xc9, 0x64, 0xff}
+	joined := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ms := []mockSpec{
+		{
+			query: sqlServerTablesQuery,
+			cols:  []string{"schema", "name"},
+			rows:  [][]driver.Value{{"dbo", "users"}, {"sales", "orders"}},
+		}, {
+			query: "SELECT (.+) FROM sys.columns (.+)",
+			args:  []driver.Value{"dbo", "users"},
+			cols:  []string{"name", "type", "max_length", "precision", "scale", "is_nullable", "is_identity", "default"},
+			rows: [][]driver.Value{
+				{"id", "int", 4, 10, 0, false, true, nil},
+				{"uid", "uniqueidentifier", 16, 0, 0, false, false, "(newid())"},
+				{"name", "nvarchar", 100, 0, 0, false, false, nil},
+				{"bio", "nvarchar", -1, 0, 0, true, false, nil},
+				{"code", "varchar", 8, 0, 0, true, false, nil},
+				{"active", "bit", 1, 1, 0, true, false, nil},
+				{"joined", "datetime2", 8, 27, 7, true, false, "(sysdatetime())"},
+				{"alarm", "time", 5, 16, 7, true, false, nil},
+				{"pic", "varbinary", -1, 0, 0, true, false, nil},
+				{"rv", "timestamp", 8, 0, 0, false, false, nil},
+				{"geo", "geography", -1, 0, 0, true, false, nil}},
+		}, {
+			query: "SELECT (.+) FROM sys.indexes (.+)",
+			args:  []driver.Value{"dbo", "users"},
+			cols:  []string{"name", "is_primary_key", "is_unique", "type_desc", "has_filter", "column", "is_descending_key", "is_included_column"},
+			rows: [][]driver.Value{
+				{"IX_users_name", false, false, "NONCLUSTERED", false, "name", true, false},
+				{"IX_users_name", false, false, "NONCLUSTERED", false, "bio", false, true},
+				{"PK_users", true, true, "CLUSTERED", false, "id", false, false},
+				{"UQ_users_uid", false, true, "NONCLUSTERED", false, "uid", false, false},
+				{"XI_users_geo", false, false, "SPATIAL", false, "geo", false, false}},
+		}, {
+			query: "SELECT (.+) FROM sys.foreign_keys (.+)",
+			args:  []driver.Value{"dbo", "users"},
+			cols:  []string{"name", "column", "refer_schema", "refer_table", "refer_column", "on_delete", "on_update"},
+		}, {
+			query: "SELECT (.+) FROM sys.columns (.+)",
+			args:  []driver.Value{"sales", "orders"},
+			cols:  []string{"name", "type", "max_length", "precision", "scale", "is_nullable", "is_identity", "default"},
+			rows: [][]driver.Value{
+				{"id", "bigint", 8, 19, 0, false, false, "(NEXT VALUE FOR [sales].[order_ids])"},
+				{"user_id", "int", 4, 10, 0, false, false, nil},
+				{"total", "money", 8, 19, 4, true, false, "((0))"},
+				{"tax", "decimal", 17, 38, 2, true, false, nil},
+				{"note", "nvarchar", 256, 0, 0, true, false, nil}}, // A sysname column.
+		}, {
+			query: "SELECT (.+) FROM sys.indexes (.+)",
+			args:  []driver.Value{"sales", "orders"},
+			cols:  []string{"name", "is_primary_key", "is_unique", "type_desc", "has_filter", "column", "is_descending_key", "is_included_column"},
+			rows:  [][]driver.Value{{"PK_orders", true, true, "CLUSTERED", false, "id", true, false}},
+		}, {
+			query: "SELECT (.+) FROM sys.foreign_keys (.+)",
+			args:  []driver.Value{"sales", "orders"},
+			cols:  []string{"name", "column", "refer_schema", "refer_table", "refer_column", "on_delete", "on_update"},
+			rows:  [][]driver.Value{{"FK_orders_users", "user_id", "dbo", "users", "id", "CASCADE", "NO_ACTION"}},
+		},
+		// Data conversion.
+		{
+			query: sqlServerTablesQuery,
+			cols:  []string{"schema", "name"},
+			rows:  [][]driver.Value{{"dbo", "users"}},
+		}, {
+			query: `SELECT [*] FROM "dbo"."users"`,
+			cols:  []string{"id", "uid", "name", "bio", "code", "active", "joined", "alarm", "pic", "rv", "geo"},
+			rows: [][]driver.Value{
+				{1, uid, "ann", nil, "A1", true, joined, time.Date(1, 1, 1, 7, 30, 0, 500000000, time.UTC), []byte{1, 2}, []byte{0, 0, 0, 0, 0, 0, 7, 209}, nil}},
+		},
+	}
+	db := mkMockDB(t, ms)
+	conv := MakeConv()
+	assert.Nil(t, ProcessSQLServerSchema(conv, db))
+	assert.Equal(t, []string{"sales.orders", "users"}, conv.SourceTables())
+	users := stripSchemaComments(conv.spSchema)["users"]
+	assert.Equal(t, []ddl.IndexKey{{Col: "id"}}, users.Pks)
+	maxString := ddl.String{Len: ddl.MaxLength{}}
+	expected := map[string]ddl.ColumnDef{
+		"id":     {Name: "id", T: ddl.Int64{}, NotNull: true},
+		"uid":    {Name: "uid", T: ddl.String{Len: ddl.Int64Length{Value: 36}}, NotNull: true},
+		"name":   {Name: "name", T: ddl.String{Len: ddl.Int64Length{Value: 50}}, NotNull: true},
+		"bio":    {Name: "bio", T: maxString},
+		"code":   {Name: "code", T: ddl.String{Len: ddl.Int64Length{Value: 8}}},
+		"active": {Name: "active", T: ddl.Bool{}},
+		"joined": {Name: "joined", T: ddl.Timestamp{}},
+		"alarm":  {Name: "alarm", T: maxString},
+		"pic":    {Name: "pic", T: ddl.Bytes{Len: ddl.MaxLength{}}},
+		"rv":     {Name: "rv", T: ddl.Bytes{Len: ddl.Int64Length{Value: 8}}, NotNull: true},
+		"geo":    {Name: "geo", T: maxString},
+	}
+	for c, cd := range expected {
+		assert.Equal(t, cd, users.ColDefs[c], c)
+	}
+	assert.Equal(t, []ddl.CreateIndex{
+		{Name: "IX_users_name", Table: "users", Keys: []ddl.IndexKey{{Col: "name", Desc: true}}, Storing: []string{"bio"}},
+		{Name: "UQ_users_uid", Table: "users", Keys: []ddl.IndexKey{{Col: "uid"}}, Unique: true},
+	}, users.Indexes)
+	assert.Equal(t, map[string][]schemaIssue{
+		"id":     {widened, serial},
+		"uid":    {defaultValue},
+		"joined": {datetime, defaultValue},
+		"alarm":  {noGoodType},
+		"rv":     {rowVersion},
+		"geo":    {noGoodType},
+	}, conv.issues["users"])
+	assert.Equal(t, schema.Type{Name: "rowversion"}, conv.srcSchema["users"].ColDefs["rv"].Type)
+	assert.True(t, conv.srcSchema["users"].ColDefs["joined"].Ignored.Now)
+
+	orders := stripSchemaComments(conv.spSchema)["sales_orders"]
+	assert.Equal(t, []ddl.IndexKey{{Col: "id", Desc: true}}, orders.Pks)
+	assert.Equal(t, ddl.Numeric{}, orders.ColDefs["total"].T)
+	assert.Equal(t, maxString, orders.ColDefs["tax"].T)
+	assert.Equal(t, ddl.String{Len: ddl.Int64Length{Value: 128}}, orders.ColDefs["note"].T)
+	assert.Equal(t, []ddl.ForeignKey{{Name: "FK_orders_users", Columns: []string{"user_id"}, ReferTable: "users", ReferColumns: []string{"id"}, OnDelete: "CASCADE"}}, orders.ForeignKeys)
+	assert.Equal(t, map[string][]schemaIssue{
+		"id":      {defaultValue},
+		"user_id": {widened},
+		"total":   {numericThatFits, defaultValue},
+		"tax":     {numericOutOfRange},
+	}, conv.issues["sales.orders"])
+	assert.True(t, conv.srcSchema["sales.orders"].ColDefs["id"].Ignored.Sequence)
+	assert.Equal(t, int64(0), conv.Unexpecteds())
+
+	conv.SetDataMode()
+	var rows []spannerData
+	conv.SetDataSink(
+		func(table string, cols []string, vals []interface{}) {
+			rows = append(rows, spannerData{table: table, cols: cols, vals: vals})
+		})
+	ProcessSqlData(conv, db)
+	assert.Equal(t, []spannerData{{
+		table: "users",
+		cols:  []string{"id", "uid", "name", "code", "active", "joined", "alarm", "pic", "rv"},
+		vals:  []interface{}{int64(1), "6F9619FF-8B86-D011-B42D-00C04FC964FF", "ann", "A1", true, joined, "07:30:00.5", []byte{1, 2}, []byte{0, 0, 0, 0, 0, 0, 7, 209}},
+	}}, rows)
+	assert.Equal(t, int64(0), conv.Unexpecteds())
+}
+
+func TestToSpannerTypeSQLServer(t *testing.T) {
+	for _, tc := range []struct {
+		id     string
+		mods   []int64
+		ty     ddl.ScalarType
+		issues []schemaIssue
+	}{
+		{"tinyint", nil, ddl.Int64{}, []schemaIssue{widened}},
+		{"bigint", nil, ddl.Int64{}, nil},
+		{"real", nil, ddl.Float64{}, []schemaIssue{widened}},
+		{"float", nil, ddl.Float64{}, nil},
+		{"decimal", []int64{38, 9}, ddl.Numeric{}, []schemaIssue{numericThatFits}},
+		{"numeric", []int64{38, 10}, ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{numericOutOfRange}},
+		{"smallmoney", nil, ddl.Numeric{}, []schemaIssue{numericThatFits}},
+		{"nchar", []int64{10}, ddl.String{Len: ddl.Int64Length{Value: 10}}, nil},
+		{"ntext", nil, ddl.String{Len: ddl.MaxLength{}}, nil},
+		{"xml", nil, ddl.String{Len: ddl.MaxLength{}}, nil},
+		{"binary", []int64{16}, ddl.Bytes{Len: ddl.Int64Length{Value: 16}}, nil},
+		{"image", nil, ddl.Bytes{Len: ddl.MaxLength{}}, nil},
+		{"date", nil, ddl.Date{}, nil},
+		{"smalldatetime", nil, ddl.Timestamp{}, []schemaIssue{datetime}},
+		{"datetimeoffset", nil, ddl.Timestamp{}, nil},
+		{"sql_variant", nil, ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{noGoodType}},
+	} {
+		ty, issues := toSpannerTypeSQLServer(MakeConv(), tc.id, tc.mods)
+		assert.Equal(t, tc.ty, ty, tc.id)
+		assert.Equal(t, tc.issues, issues, tc.id)
+	}
+}
+
+func TestCvtSqlScalar_SQLServerDatetime(t *testing.T) {
+	conv := MakeConv()
+	conv.sqlserver = true
+	zone := time.FixedZone("+01", 3600)
+	conv.SetTimestampZone(zone)
+	v := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		srcType string
+		want    time.Time
+	}{
+		{"datetime2", time.Date(2020, 1, 2, 3, 4, 5, 0, zone)},
+		{"datetimeoffset", v},
+	} {
+		got, err := cvtSqlScalar(conv, schema.Column{Type: schema.Type{Name: tc.srcType}}, ddl.ColumnDef{T: ddl.Timestamp{}}, v)
+		assert.Nil(t, err)
+		assert.True(t, tc.want.Equal(got.(time.Time)), tc.srcType)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// toSpannerTypeSQLServer maps a scalar SQL Server type (defined by id
+// and mods) into a Spanner type. It is the SQL Server equivalent of
+// toSpannerType. Types are named as in sys.types, except that
+// timestamp is named rowversion (see sqlServerType).
+func toSpannerTypeSQLServer(conv *Conv, id string, mods []int64) (ddl.ScalarType, []schemaIssue) {
+	maxExpectedMods := func(n int) {
+		if len(mods) > n {
+			conv.unexpected(fmt.Sprintf("Found %d mods while processing type id=%s", len(mods), id))
+		}
+	}
+	switch strings.ToLower(id) {
+	case "bit":
+		maxExpectedMods(0)
+		return ddl.Bool{}, nil
+	case "tinyint", "smallint", "int":
+		maxExpectedMods(0)
+		return ddl.Int64{}, []schemaIssue{widened}
+	case "bigint":
+		maxExpectedMods(0)
+		return ddl.Int64{}, nil
+	case "real":
+		maxExpectedMods(0)
+		return ddl.Float64{}, []schemaIssue{widened}
+	case "float":
+		maxExpectedMods(0)
+		return ddl.Float64{}, nil
+	case "decimal", "numeric":
+		maxExpectedMods(2)
+		if len(mods) == 0 {
+			mods = []int64{18} // SQL Server's default precision.
+		}
+		if numericFits(mods) {
+			return ddl.Numeric{}, []schemaIssue{numericThatFits}
+		}
+		return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{numericOutOfRange}
+	case "money", "smallmoney":
+		// Fixed point, with 4 digits after the decimal point.
+		maxExpectedMods(0)
+		return ddl.Numeric{}, []schemaIssue{numericThatFits}
+	case "char", "varchar", "nchar", "nvarchar":
+		// No mods means MAX e.g. nvarchar(max).
+		maxExpectedMods(1)
+		if len(mods) > 0 {
+			return ddl.String{Len: ddl.Int64Length{Value: mods[0]}}, nil
+		}
+		return ddl.String{Len: ddl.MaxLength{}}, nil
+	case "text", "ntext", "xml":
+		maxExpectedMods(0)
+		return ddl.String{Len: ddl.MaxLength{}}, nil
+	case "uniqueidentifier":
+		// As text e.g. 6F9619FF-8B86-D011-B42D-00C04FC964FF.
+		maxExpectedMods(0)
+		return ddl.String{Len: ddl.Int64Length{Value: 36}}, nil
+	case "binary", "varbinary":
+		maxExpectedMods(1)
+		if len(mods) > 0 {
+			return ddl.Bytes{Len: ddl.Int64Length{Value: mods[0]}}, nil
+		}
+		return ddl.Bytes{Len: ddl.MaxLength{}}, nil
+	case "image":
+		maxExpectedMods(0)
+		return ddl.Bytes{Len: ddl.MaxLength{}}, nil
+	case "rowversion":
+		maxExpectedMods(0)
+		return ddl.Bytes{Len: ddl.Int64Length{Value: 8}}, []schemaIssue{rowVersion}
+	case "date":
+		maxExpectedMods(0)
+		return ddl.Date{}, nil
+	case "datetime", "datetime2", "smalldatetime":
+		maxExpectedMods(0)
+		return ddl.Timestamp{}, []schemaIssue{datetime}
+	case "datetimeoffset":
+		maxExpectedMods(0)
+		return ddl.Timestamp{}, nil
+	}
+	return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{noGoodType}
+}
+
+// sqlServerDataType returns the name of the PostgreSQL type whose values
+// are converted in the same way as values of SQL Server type id (see
+// convScalar).
+func sqlServerDataType(id string) string {
+	switch strings.ToLower(id) {
+	case "datetime", "datetime2", "smalldatetime":
+		return "timestamp"
+	case "datetimeoffset":
+		return "timestamptz"
+	}
+	return id
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+)
+
+// stmtScanner finds the ends of statements in pg_dump output, one line
+// at a time. It tracks string literals, quoted identifiers,
+// dollar-quoted strings (e.g. function bodies) and comments, so that
+// semicolons inside them don't end statements. This lets us parse each
+// statement once, rather than every time we see a semicolon.
+type stmtScanner struct {
+	escapes bool      // Backslashes are escapes in ordinary string literals (standard_conforming_strings is off).
+	state   scanState // Where the text scanned so far ends.
+	escaped bool      // The current string literal has backslash escapes.
+	tag     string    // Closing tag of the current dollar-quoted string e.g. "$body$".
+	depth   int       // Nesting depth of the current block comment.
+	end     bool      // The text scanned so far ends with a complete statement.
+}
+
+type scanState int
+
+const (
+	scanCode scanState = iota
+	scanString
+	scanIdent
+	scanDollar
+	scanComment // Block comment (line comments end with their line).
+)
+
+// scan scans the next line, and returns whether the text scanned so
+// far ends with a complete statement i.e. a semicolon, followed only
+// by whitespace and comments.
+func (sc *stmtScanner) scan(line []byte) bool {
+	s := string(line)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch sc.state {
+		case scanCode:
+			switch {
+			case c == ';':
+				sc.end = true
+			case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			case strings.HasPrefix(s[i:], "--"):
+				return sc.end
+			case strings.HasPrefix(s[i:], "/*"):
+				sc.state, sc.depth = scanComment, 1
+				i++
+			case c == '\'':
+				sc.state = scanString
+				// E'...' strings always have escapes.
+				sc.escaped = sc.escapes || i > 0 && (s[i-1] == 'E' || s[i-1] == 'e') && (i == 1 || !isIdentChar(s[i-2]))
+				sc.end = false
+			case c == '"':
+				sc.state, sc.end = scanIdent, false
+			case c == '$' && (i == 0 || !isIdentChar(s[i-1])):
+				sc.end = false
+				if tag, ok := dollarTag(s[i:]); ok {
+					sc.state, sc.tag = scanDollar, tag
+					i += len(tag)
+					continue
+				}
+			default:
+				sc.end = false
+			}
+			i++
+		case scanString:
+			switch {
+			case c == '\\' && sc.escaped:
+				i += 2
+			case c == '\'':
+				// A doubled quote is read as the end of one literal and
+				// the start of another, with the same effect.
+				sc.state = scanCode
+				i++
+			default:
+				i++
+			}
+		case scanIdent:
+			if c == '"' {
+				sc.state = scanCode
+			}
+			i++
+		case scanDollar:
+			j := strings.Index(s[i:], sc.tag)
+			if j < 0 {
+				return false
+			}
+			sc.state = scanCode
+			i += j + len(sc.tag)
+		case scanComment:
+			switch {
+			case strings.HasPrefix(s[i:], "/*"):
+				sc.depth++
+				i += 2
+			case strings.HasPrefix(s[i:], "*/"):
+				if sc.depth--; sc.depth == 0 {
+					sc.state = scanCode
+				}
+				i += 2
+			default:
+				i++
+			}
+		}
+	}
+	return sc.state == scanCode && sc.end
+}
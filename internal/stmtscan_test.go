@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStmtScanner(t *testing.T) {
+	tests := []struct {
+		name    string
+		escapes bool
+		lines   []string
+		ends    []bool // Whether a statement ends after each line.
+	}{
+		{"simple", false, []string{"CREATE TABLE t (\n", "  a text\n", ");\n"}, []bool{false, false, true}},
+		{"trailing comment", false, []string{"SET x = 1; -- done;\n"}, []bool{true}},
+		{"semicolon in line comment", false, []string{"SELECT 1 -- a;\n", ";\n"}, []bool{false, true}},
+		{"semicolon then more", false, []string{"SELECT 1; SELECT\n", "2;\n"}, []bool{false, true}},
+		{"string", false, []string{"INSERT INTO t VALUES ('a;\n", "b;', 'it''s;');\n"}, []bool{false, true}},
+		{"quoted identifier", false, []string{"CREATE TABLE \"a;\n", "b\" (c text);\n"}, []bool{false, true}},
+		{"block comment", false, []string{"/* a; /* nested; */\n", "still; */ SELECT 1;\n"}, []bool{false, true}},
+		{"dollar quote", false, []string{"CREATE FUNCTION f() RETURNS void AS $$\n", "BEGIN; END;\n", "$$ LANGUAGE plpgsql;\n"}, []bool{false, false, true}},
+		{"dollar tag", false, []string{"CREATE FUNCTION f() RETURNS text AS $body$\n", "SELECT $$;$$;\n", "$body$;\n"}, []bool{false, false, true}},
+		{"dollar in identifier", false, []string{"SELECT a$b; SELECT $1;\n"}, []bool{true}},
+		{"escape string", false, []string{"INSERT INTO t VALUES (E'a\\';');\n"}, []bool{true}},
+		{"backslash without escapes", false, []string{"INSERT INTO t VALUES ('a\\');\n"}, []bool{true}},
+		{"backslash with escapes", true, []string{"INSERT INTO t VALUES ('a\\';');\n"}, []bool{true}},
+	}
+	for _, tc := range tests {
+		sc := stmtScanner{escapes: tc.escapes}
+		var ends []bool
+		for _, l := range tc.lines {
+			ends = append(ends, sc.scan([]byte(l)))
+		}
+		assert.Equal(t, tc.ends, ends, tc.name)
+	}
+}
+
+// nastyFunctions has functions whose bodies have semicolons, COPY
+// statements, strings and comments, followed by data.
+const nastyFunctions = "CREATE TABLE t (id bigint PRIMARY KEY, s text);\n" +
+	"CREATE FUNCTION public.load() RETURNS void\n" +
+	"    LANGUAGE plpgsql\n" +
+	"    AS $$\n" +
+	"BEGIN\n" +
+	"  -- COPY t FROM stdin;\n" +
+	"  COPY t (id, s) FROM stdin;\n" +
+	"  EXECUTE 'COPY t FROM ''/tmp/x''; SELECT 1;';\n" +
+	"  /* Neither $body$ nor an unmatched ' ends the body; */\n" +
+	"END;\n" +
+	"$$;\n" +
+	"CREATE FUNCTION public.quote(x text) RETURNS text\n" +
+	"    LANGUAGE sql\n" +
+	"    AS $fn$ SELECT $$;$$ || x || ';'; $fn$;\n" +
+	"COMMENT ON FUNCTION public.quote(text) IS 'Quotes; with $$';\n" +
+	"COPY public.t (id, s) FROM stdin;\n" +
+	"1\tCOPY t FROM stdin;\n" +
+	"2\t$$;\n" +
+	"\\.\n" +
+	"INSERT INTO t VALUES (3, 'a;\n" +
+	"b'), (4, '$$');\n"
+
+func TestProcessPgDump_FunctionBodies(t *testing.T) {
+	conv, rows := runProcessPgDump(nastyFunctions)
+	assert.Equal(t, int64(0), conv.stats.reparsed)
+	assert.Equal(t, int64(4), conv.stats.rows["t"])
+	assert.Equal(t, int64(4), conv.stats.goodRows["t"])
+	var vals []string
+	for _, r := range rows {
+		vals = append(vals, r.vals[1].(string))
+	}
+	assert.Equal(t, []string{"COPY t FROM stdin;", "$$;", "a;\nb", "$$"}, vals)
+	assert.Equal(t, int64(2), conv.stats.statement["CreateFunctionStmt"].skip)
+	assert.Empty(t, conv.stats.unexpected)
+	assert.True(t, strings.Contains(conv.dropped[0].sql, "COPY t (id, s) FROM stdin;"))
+}
@@ -0,0 +1,245 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"reflect"
+
+	sp "cloud.google.com/go/spanner"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// Storage estimates give the approximate size of each table in
+// Spanner, for capacity planning. During data conversion, we add up
+// the sizes of the converted values of each row (strings and bytes by
+// length, 8 bytes per INT64 and FLOAT64, etc.), plus a per-row
+// overhead, plus the size of the row's entry in each secondary index.
+// For schema-only conversions, there are no values, so we estimate
+// each row's size from the column types, and multiply by the row
+// counts from the source database's statistics (if available, see
+// SetRowEstimates).
+
+// rowOverheadBytes is the approximate storage overhead of each row
+// (and each index entry) in Spanner, in addition to its values.
+const rowOverheadBytes = 16
+
+// Sizes used for schema-only estimates, where values aren't known.
+const (
+	defaultStringBytes = 32 // STRING(MAX) and BYTES(MAX) values.
+	defaultArrayItems  = 4
+)
+
+// statsAddStorage adds the estimated Spanner storage of a row of
+// spTable (with values vals for columns cols) to srcTable's estimate.
+func (conv *Conv) statsAddStorage(srcTable, spTable string, cols []string, vals []interface{}) {
+	if !conv.dataMode() {
+		return
+	}
+	ct, ok := conv.spSchema[spTable]
+	if !ok {
+		return
+	}
+	sizes := make(map[string]int64, len(cols))
+	for i, c := range cols {
+		if cd, ok := ct.ColDefs[c]; ok && i < len(vals) {
+			sizes[c] = valueBytes(cd, vals[i])
+		}
+	}
+	conv.stats.storage[srcTable] += rowBytes(ct, sizes)
+}
+
+// rowBytes returns the estimated storage of a row of ct, given the
+// sizes of its values (keyed by column; NULL columns are missing).
+func rowBytes(ct ddl.CreateTable, sizes map[string]int64) int64 {
+	n := int64(rowOverheadBytes)
+	for _, s := range sizes {
+		n += s
+	}
+	// Each index entry has the index's key columns and the primary key.
+	for _, index := range ct.Indexes {
+		n += rowOverheadBytes
+		seen := make(map[string]bool)
+		for _, k := range append(append([]ddl.IndexKey{}, index.Keys...), ct.Pks...) {
+			if !seen[k.Col] {
+				seen[k.Col] = true
+				n += sizes[k.Col]
+			}
+		}
+	}
+	return n
+}
+
+// valueBytes returns the estimated storage of v, a value of column cd
+// (as given to the Spanner client library e.g. int64 for INT64).
+func valueBytes(cd ddl.ColumnDef, v interface{}) int64 {
+	if !cd.IsArray {
+		return scalarBytes(cd.T, v)
+	}
+	a := reflect.ValueOf(v)
+	if a.Kind() != reflect.Slice {
+		return 0
+	}
+	n := int64(0)
+	for i := 0; i < a.Len(); i++ {
+		n += scalarBytes(cd.T, a.Index(i).Interface())
+	}
+	return n
+}
+
+func scalarBytes(t ddl.ScalarType, v interface{}) int64 {
+	switch v := v.(type) {
+	case string:
+		if _, ok := t.(ddl.Numeric); ok {
+			break // Numeric values are strings, but have fixed-size storage.
+		}
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case sp.NullString:
+		if !v.Valid {
+			return 0
+		}
+		return int64(len(v.StringVal))
+	case sp.NullableValue: // Elements of other arrays.
+		if v.IsNull() {
+			return 0
+		}
+	}
+	return typeBytes(t)
+}
+
+// typeBytes returns the storage of values of Spanner type t. For
+// variable-sized types (STRING and BYTES), it returns an estimate for
+// typical values: half the length limit, or defaultStringBytes for MAX.
+func typeBytes(t ddl.ScalarType) int64 {
+	switch t := t.(type) {
+	case ddl.Bool:
+		return 1
+	case ddl.Int64, ddl.Float64:
+		return 8
+	case ddl.Date:
+		return 4
+	case ddl.Timestamp:
+		return 12
+	case ddl.Numeric:
+		return 22
+	case ddl.String:
+		return lengthBytes(t.Len)
+	case ddl.Bytes:
+		return lengthBytes(t.Len)
+	}
+	return 0
+}
+
+func lengthBytes(l ddl.Length) int64 {
+	if n, ok := l.(ddl.Int64Length); ok && n.Value > 0 {
+		return (n.Value + 1) / 2
+	}
+	return defaultStringBytes
+}
+
+// typicalRowBytes returns the estimated storage of a row of ct, based
+// on its column types (for when values aren't known). All columns are
+// assumed to be non-NULL.
+func typicalRowBytes(ct ddl.CreateTable) int64 {
+	sizes := make(map[string]int64)
+	for _, c := range ct.ColNames {
+		cd := ct.ColDefs[c]
+		n := typeBytes(cd.T)
+		if cd.IsArray {
+			n *= defaultArrayItems
+		}
+		sizes[c] = n
+	}
+	return rowBytes(ct, sizes)
+}
+
+// SetRowEstimates records approximate row counts for source tables
+// (keyed by source table name) from the source database's statistics.
+// They are used for storage estimates when data isn't converted (see
+// SkipDataConversion).
+func (conv *Conv) SetRowEstimates(rows map[string]int64) {
+	conv.rowEstimates = rows
+}
+
+// storageEstimate is the estimated Spanner storage of a table.
+type storageEstimate struct {
+	bytes      int64
+	rows       int64 // Rows the estimate is based on.
+	fromSchema bool  // Estimated from column types and source statistics, rather than converted values.
+}
+
+// storageEstimate returns the estimated Spanner storage of srcTable,
+// or nil if there is no estimate (e.g. a schema-only conversion of a
+// dump, for which there are no row counts).
+func (conv *Conv) storageEstimate(srcTable string) *storageEstimate {
+	if conv.dataSkipped {
+		rows := conv.rowEstimates[srcTable]
+		if rows <= 0 {
+			return nil
+		}
+		spTable, err := GetSpannerTable(conv, srcTable)
+		if err != nil {
+			return nil
+		}
+		ct, ok := conv.spSchema[spTable]
+		if !ok {
+			return nil
+		}
+		return &storageEstimate{bytes: rows * typicalRowBytes(ct), rows: rows, fromSchema: true}
+	}
+	n, ok := conv.stats.storage[srcTable]
+	if !ok {
+		return nil
+	}
+	s := &storageEstimate{bytes: n, rows: conv.stats.goodRows[srcTable]}
+	// With row sampling, scale up to all rows of the table.
+	if u := conv.unsampledRows(srcTable); u > 0 && s.rows > 0 {
+		s.bytes = int64(float64(n) * float64(s.rows+u) / float64(s.rows))
+		s.rows += u
+	}
+	return s
+}
+
+// totalStorage returns the sum of the storage estimates of all tables,
+// or nil if no table has an estimate.
+func (conv *Conv) totalStorage() *storageEstimate {
+	var total *storageEstimate
+	for t := range conv.srcSchema {
+		if s := conv.storageEstimate(t); s != nil {
+			if total == nil {
+				total = &storageEstimate{fromSchema: s.fromSchema}
+			}
+			total.bytes += s.bytes
+			total.rows += s.rows
+		}
+	}
+	return total
+}
+
+// storageMsg returns the storage line for the report e.g. "Estimated
+// Spanner storage: 14.2 GiB", or "" if there is no estimate.
+func storageMsg(s *storageEstimate) string {
+	if s == nil {
+		return ""
+	}
+	msg := "Estimated Spanner storage: " + formatBytes(s.bytes)
+	if s.fromSchema {
+		msg += fmt.Sprintf(" (from column types and %s rows in source statistics)", formatCount(s.rows))
+	}
+	return msg
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	sp "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestValueBytes(t *testing.T) {
+	str := ddl.String{Len: ddl.MaxLength{}}
+	tests := []struct {
+		name     string
+		cd       ddl.ColumnDef
+		v        interface{}
+		expected int64
+	}{
+		{"bool", ddl.ColumnDef{T: ddl.Bool{}}, true, 1},
+		{"int64", ddl.ColumnDef{T: ddl.Int64{}}, int64(7), 8},
+		{"float64", ddl.ColumnDef{T: ddl.Float64{}}, 1.5, 8},
+		{"string", ddl.ColumnDef{T: str}, "héllo", 6},
+		{"bytes", ddl.ColumnDef{T: ddl.Bytes{Len: ddl.MaxLength{}}}, []byte{1, 2, 3}, 3},
+		{"date", ddl.ColumnDef{T: ddl.Date{}}, civil.Date{Year: 2020, Month: 1, Day: 2}, 4},
+		{"timestamp", ddl.ColumnDef{T: ddl.Timestamp{}}, time.Now(), 12},
+		{"numeric", ddl.ColumnDef{T: ddl.Numeric{}}, "1.5", 22},
+		{"string array", ddl.ColumnDef{T: str, IsArray: true}, []sp.NullString{{StringVal: "ab", Valid: true}, {}}, 2},
+		{"int64 array", ddl.ColumnDef{T: ddl.Int64{}, IsArray: true}, []sp.NullInt64{{Int64: 1, Valid: true}, {}, {Int64: 2, Valid: true}}, 16},
+		{"empty array", ddl.ColumnDef{T: ddl.Int64{}, IsArray: true}, []sp.NullString{}, 0},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, valueBytes(tc.cd, tc.v), tc.name)
+	}
+}
+
+func TestRowBytes(t *testing.T) {
+	ct := ddl.CreateTable{
+		Name:     "t",
+		ColNames: []string{"a", "b", "c"},
+		ColDefs: map[string]ddl.ColumnDef{
+			"a": {Name: "a", T: ddl.Int64{}},
+			"b": {Name: "b", T: ddl.String{Len: ddl.Int64Length{Value: 10}}},
+			"c": {Name: "c", T: ddl.String{Len: ddl.MaxLength{}}},
+		},
+		Pks: []ddl.IndexKey{{Col: "a"}},
+	}
+	sizes := map[string]int64{"a": 8, "b": 3, "c": 100}
+	assert.Equal(t, int64(rowOverheadBytes+111), rowBytes(ct, sizes))
+	assert.Equal(t, int64(rowOverheadBytes+8+5+defaultStringBytes), typicalRowBytes(ct))
+	// Index entries have the index's key columns and the primary key.
+	ct.Indexes = []ddl.CreateIndex{{Name: "i", Table: "t", Keys: []ddl.IndexKey{{Col: "b"}, {Col: "a"}}}}
+	assert.Equal(t, int64(2*rowOverheadBytes+111+3+8), rowBytes(ct, sizes))
+}
+
+func TestStorage_PgDump(t *testing.T) {
+	dump := "CREATE TABLE t (a bigint PRIMARY KEY, b text);\n" +
+		"COPY public.t (a, b) FROM stdin;\n1\tabc\n2\t\\N\n\\.\n" +
+		"CREATE TABLE u (a bigint PRIMARY KEY);\n"
+	conv := MakeConv()
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(dump)), nil))
+	assert.Empty(t, conv.stats.storage, "schema mode shouldn't estimate storage")
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(dump)), nil))
+	assert.Equal(t, &storageEstimate{bytes: 2*rowOverheadBytes + 16 + 3, rows: 2}, conv.storageEstimate("t"))
+	assert.Nil(t, conv.storageEstimate("u"))
+	assert.Equal(t, "Estimated Spanner storage: 51 B", storageMsg(conv.totalStorage()))
+}
+
+func TestStorage_SchemaOnly(t *testing.T) {
+	conv := MakeConv()
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(
+		"CREATE TABLE t (a bigint PRIMARY KEY, b varchar(20));\nCREATE TABLE u (a bigint PRIMARY KEY);\n")), nil))
+	conv.SkipDataConversion()
+	assert.Nil(t, conv.totalStorage(), "no estimates without row counts")
+	conv.SetRowEstimates(map[string]int64{"t": 1000 * 1000 * 1000})
+	s := conv.storageEstimate("t")
+	assert.Equal(t, &storageEstimate{bytes: 1000 * 1000 * 1000 * (rowOverheadBytes + 8 + 10), rows: 1000 * 1000 * 1000, fromSchema: true}, s)
+	assert.Nil(t, conv.storageEstimate("u"))
+	assert.Equal(t, "Estimated Spanner storage: 31.7 GiB (from column types and 1,000,000,000 rows in source statistics)", storageMsg(conv.totalStorage()))
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	sp "cloud.google.com/go/spanner"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// MaxStringLength is the maximum length (in characters) of a Spanner
+// STRING(N) column.
+const MaxStringLength = 2621440
+
+// StringOverflow determines what happens to values that are longer
+// than the length of their STRING(N) column.
+type StringOverflow string
+
+// String overflow policies.
+const (
+	StringOverflowReject   StringOverflow = "reject"   // The row is a bad row (the default).
+	StringOverflowTruncate StringOverflow = "truncate" // The value is truncated to the column's length.
+)
+
+// ParseStringOverflow returns the policy named s (empty for the
+// default).
+func ParseStringOverflow(s string) (StringOverflow, error) {
+	switch x := StringOverflow(s); x {
+	case "":
+		return StringOverflowReject, nil
+	case StringOverflowReject, StringOverflowTruncate:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown string overflow policy %q: expected reject or truncate", s)
+}
+
+// SetStringLength configures the length of STRING columns for source
+// types that would otherwise map to STRING(MAX) (e.g. text), for
+// environments that don't allow STRING(MAX). Zero means STRING(MAX).
+// It must be called before schema conversion.
+func (conv *Conv) SetStringLength(n int64) error {
+	if n < 0 || n > MaxStringLength {
+		return fmt.Errorf("string length %d is out of range: expected 1 to %d (or 0 for STRING(MAX))", n, MaxStringLength)
+	}
+	conv.stringLength = n
+	return nil
+}
+
+// SetStringOverflow configures what happens to values that are longer
+// than their STRING(N) column.
+func (conv *Conv) SetStringOverflow(p StringOverflow) {
+	conv.overflowPolicy = p
+}
+
+func (conv *Conv) stringOverflow() StringOverflow {
+	if conv.overflowPolicy == "" {
+		return StringOverflowReject
+	}
+	return conv.overflowPolicy
+}
+
+// boundString applies the configured string length to Spanner type ty
+// (see SetStringLength), adding stringBounded to issues if it changes
+// ty. STRING(N) types longer than Spanner allows are reduced to
+// MaxStringLength.
+func (conv *Conv) boundString(ty ddl.ScalarType, issues []schemaIssue) (ddl.ScalarType, []schemaIssue) {
+	s, ok := ty.(ddl.String)
+	if !ok {
+		return ty, issues
+	}
+	switch l := s.Len.(type) {
+	case ddl.MaxLength:
+		if conv.stringLength > 0 {
+			return ddl.String{Len: ddl.Int64Length{Value: conv.stringLength}}, append(issues, stringBounded)
+		}
+	case ddl.Int64Length:
+		if l.Value > MaxStringLength {
+			return ddl.String{Len: ddl.Int64Length{Value: MaxStringLength}}, issues
+		}
+	}
+	return ty, issues
+}
+
+// lengthError is the error for STRING values that are longer than
+// their Spanner column allows, when the overflow policy rejects them.
+// Rows with such values are bad rows with reason BadRowRange.
+type lengthError struct {
+	col string
+	typ string // Spanner type of col.
+}
+
+func (e *lengthError) Error() string {
+	return fmt.Sprintf("value of column %s is longer than Spanner type %s allows", e.col, e.typ)
+}
+
+// fitString enforces the length of Spanner column type t (if it is
+// STRING(N)) on converted value x, a string or (for arrays) a slice of
+// sp.NullString. Depending on the overflow policy, longer values are
+// truncated or cause an error. Either way, they are counted.
+func (conv *Conv) fitString(srcTable, srcCol string, t ddl.ScalarType, x interface{}) (interface{}, error) {
+	s, ok := t.(ddl.String)
+	if !ok {
+		return x, nil
+	}
+	l, ok := s.Len.(ddl.Int64Length)
+	if !ok {
+		return x, nil
+	}
+	fit := func(v string) (string, error) {
+		if utf8.RuneCountInString(v) <= int(l.Value) {
+			return v, nil
+		}
+		conv.addOverflow(srcTable, srcCol)
+		if conv.stringOverflow() == StringOverflowReject {
+			return v, &lengthError{srcCol, t.PrintScalarType()}
+		}
+		return truncateRunes(v, int(l.Value)), nil
+	}
+	switch v := x.(type) {
+	case string:
+		return fit(v)
+	case []sp.NullString:
+		var err error
+		for i := range v {
+			if v[i].Valid {
+				if v[i].StringVal, err = fit(v[i].StringVal); err != nil {
+					return x, err
+				}
+			}
+		}
+	}
+	return x, nil
+}
+
+// truncateRunes returns the first n characters of s.
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+func (conv *Conv) addOverflow(srcTable, srcCol string) {
+	if conv.overflows[srcTable] == nil {
+		conv.overflows[srcTable] = make(map[string]int64)
+	}
+	conv.overflows[srcTable][srcCol]++
+}
+
+// describeOverflow describes the values of srcCol of srcTable that
+// were too long for their column, for reports.
+func (conv *Conv) describeOverflow(srcTable, srcCol, spType string) string {
+	n := conv.overflows[srcTable][srcCol]
+	what := "values were"
+	if n == 1 {
+		what = "value was"
+	}
+	action := "rejected (their rows are bad rows)"
+	if conv.stringOverflow() == StringOverflowTruncate {
+		action = "truncated"
+	}
+	return fmt.Sprintf("Column '%s': %d %s longer than %s allows, and %s", srcCol, n, what, spType, action)
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	sp "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+const stringLengthDump = "CREATE TABLE t (id bigint PRIMARY KEY, s text, v varchar(3), a text[], big varchar(5000000));\n" +
+	"COPY public.t (id, s, v, a) FROM stdin;\n" +
+	"1\tabcd\tabc\t{ab}\n" +
+	"2\tabcdef\tabc\t{ab,cdefg}\n" +
+	"3\tdéjà vu\t\\N\t\\N\n" +
+	"\\.\n"
+
+func TestProcessPgDump_StringLength(t *testing.T) {
+	conv := MakeConv()
+	assert.Nil(t, conv.SetStringLength(4))
+	conv, rows := runProcessPgDumpConv(conv, stringLengthDump)
+	ct := stripSchemaComments(conv.spSchema)["t"]
+	assert.Equal(t, map[string]ddl.ColumnDef{
+		"id":  {Name: "id", T: ddl.Int64{}, NotNull: true},
+		"s":   {Name: "s", T: ddl.String{Len: ddl.Int64Length{Value: 4}}},
+		"v":   {Name: "v", T: ddl.String{Len: ddl.Int64Length{Value: 3}}},
+		"a":   {Name: "a", T: ddl.String{Len: ddl.Int64Length{Value: 4}}, IsArray: true},
+		"big": {Name: "big", T: ddl.String{Len: ddl.Int64Length{Value: MaxStringLength}}},
+	}, ct.ColDefs)
+	assert.Equal(t, []schemaIssue{stringBounded}, conv.issues["t"]["s"])
+	assert.Empty(t, conv.issues["t"]["v"])
+	// Rows 2 and 3 are rejected (the default).
+	assert.Equal(t, []spannerData{{table: "t", cols: []string{"id", "s", "v", "a"},
+		vals: []interface{}{int64(1), "abcd", "abc", []sp.NullString{{StringVal: "ab", Valid: true}}}}}, rows)
+	assert.Equal(t, int64(2), conv.BadRows())
+	assert.Equal(t, map[string]map[string]int64{"t": {"s": 2}}, conv.overflows)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	s := normalizeSpace(buf.String())
+	assert.Contains(t, s, "Some columns would be mapped to string(max), but are mapped to array<string(4)> e.g. column 'a' of type text[]")
+	assert.Contains(t, s, "Column 's': 2 values were longer than string(4) allows, and rejected (their rows are bad rows). Spanner STRING(N) columns")
+
+	conv = MakeConv()
+	assert.Nil(t, conv.SetStringLength(4))
+	conv.SetStringOverflow(StringOverflowTruncate)
+	conv, rows = runProcessPgDumpConv(conv, stringLengthDump)
+	noIssues(conv, t, "string length (truncate)")
+	assert.Equal(t, []spannerData{
+		{table: "t", cols: []string{"id", "s", "v", "a"}, vals: []interface{}{int64(1), "abcd", "abc", []sp.NullString{{StringVal: "ab", Valid: true}}}},
+		{table: "t", cols: []string{"id", "s", "v", "a"}, vals: []interface{}{int64(2), "abcd", "abc", []sp.NullString{{StringVal: "ab", Valid: true}, {StringVal: "cdef", Valid: true}}}},
+		{table: "t", cols: []string{"id", "s"}, vals: []interface{}{int64(3), "déjà"}},
+	}, rows)
+	assert.Equal(t, int64(0), conv.BadRows())
+	assert.Equal(t, map[string]map[string]int64{"t": {"s": 2, "a": 1}}, conv.overflows)
+	tr := buildTableReport(conv, "t", nil)
+	assert.Equal(t, int64(2), tr.warnings)
+}
+
+func TestSetStringLength(t *testing.T) {
+	conv := MakeConv()
+	assert.NotNil(t, conv.SetStringLength(-1))
+	assert.NotNil(t, conv.SetStringLength(MaxStringLength+1))
+	assert.Nil(t, conv.SetStringLength(MaxStringLength))
+	assert.Nil(t, conv.SetStringLength(0))
+	// Without a string length, only overlong lengths are changed.
+	ty, issues := conv.boundString(ddl.String{Len: ddl.MaxLength{}}, nil)
+	assert.Equal(t, ddl.String{Len: ddl.MaxLength{}}, ty)
+	assert.Empty(t, issues)
+	ty, _ = conv.boundString(ddl.String{Len: ddl.Int64Length{Value: MaxStringLength + 1}}, nil)
+	assert.Equal(t, ddl.String{Len: ddl.Int64Length{Value: MaxStringLength}}, ty)
+}
+
+func TestParseStringOverflow(t *testing.T) {
+	for s, expected := range map[string]StringOverflow{"": StringOverflowReject, "reject": StringOverflowReject, "truncate": StringOverflowTruncate} {
+		p, err := ParseStringOverflow(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, p, s)
+	}
+	_, err := ParseStringOverflow("clip")
+	assert.NotNil(t, err)
+}
+
+func TestTruncateRunes(t *testing.T) {
+	assert.Equal(t, "", truncateRunes("abc", 0))
+	assert.Equal(t, "ab", truncateRunes("abc", 2))
+	assert.Equal(t, "abc", truncateRunes("abc", 3))
+	assert.Equal(t, "日本", truncateRunes("日本語", 2))
+}
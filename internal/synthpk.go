@@ -0,0 +1,113 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/bits"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// SyntheticPKStrategy determines the type and values of the synthetic
+// primary keys added to tables that don't have a primary key (see
+// AddPrimaryKeys).
+type SyntheticPKStrategy string
+
+// Synthetic primary key strategies.
+const (
+	SyntheticPKBitReversed SyntheticPKStrategy = "bitreversed" // INT64 sequence, bit-reversed to spread writes across splits (the default).
+	SyntheticPKSequential  SyntheticPKStrategy = "sequential"  // INT64 sequence 0, 1, 2, ...
+	SyntheticPKUUID        SyntheticPKStrategy = "uuid"        // STRING(36) random (version 4) UUIDs.
+)
+
+// ParseSyntheticPKStrategy returns the strategy named s (empty for the
+// default).
+func ParseSyntheticPKStrategy(s string) (SyntheticPKStrategy, error) {
+	switch x := SyntheticPKStrategy(s); x {
+	case "":
+		return SyntheticPKBitReversed, nil
+	case SyntheticPKBitReversed, SyntheticPKSequential, SyntheticPKUUID:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown synthetic primary key strategy %q: expected sequential, uuid or bitreversed", s)
+}
+
+// SetSyntheticPKStrategy configures the strategy for synthetic primary
+// keys. It must be called before schema conversion.
+func (conv *Conv) SetSyntheticPKStrategy(s SyntheticPKStrategy) {
+	conv.pkStrategy = s
+}
+
+func (conv *Conv) syntheticPKStrategy() SyntheticPKStrategy {
+	if conv.pkStrategy == "" {
+		return SyntheticPKBitReversed
+	}
+	return conv.pkStrategy
+}
+
+// syntheticPKType returns the Spanner type of synthetic primary keys.
+func (conv *Conv) syntheticPKType() ddl.ScalarType {
+	if conv.syntheticPKStrategy() == SyntheticPKUUID {
+		return ddl.String{Len: ddl.Int64Length{Value: 36}}
+	}
+	return ddl.Int64{}
+}
+
+// syntheticPKDesc describes how synthetic primary keys are filled, for
+// reports.
+func (conv *Conv) syntheticPKDesc() string {
+	switch conv.syntheticPKStrategy() {
+	case SyntheticPKSequential:
+		return "It is filled with a sequence, so writes of new rows all go to the end of the table (a hotspot)"
+	case SyntheticPKUUID:
+		return "It is filled with random UUIDs"
+	}
+	return "It is filled with a bit-reversed sequence, to spread writes across the table"
+}
+
+// nextSyntheticPK returns the synthetic primary key column of spTable
+// and its value for the next row, if spTable has a synthetic primary
+// key.
+func (conv *Conv) nextSyntheticPK(spTable string) (string, interface{}, bool) {
+	aux, ok := conv.syntheticPKeys[spTable]
+	if !ok {
+		return "", nil, false
+	}
+	var v interface{}
+	switch conv.syntheticPKStrategy() {
+	case SyntheticPKSequential:
+		v = aux.sequence
+	case SyntheticPKUUID:
+		v = newUUID()
+	default:
+		v = int64(bits.Reverse64(uint64(aux.sequence)))
+	}
+	aux.sequence++
+	conv.syntheticPKeys[spTable] = aux
+	return aux.col, v, true
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Errorf("can't generate UUID: %w", err))
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4.
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant.
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestParseSyntheticPKStrategy(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected SyntheticPKStrategy
+		err      bool
+	}{
+		{"", SyntheticPKBitReversed, false},
+		{"bitreversed", SyntheticPKBitReversed, false},
+		{"sequential", SyntheticPKSequential, false},
+		{"uuid", SyntheticPKUUID, false},
+		{"UUID", "", true},
+		{"random", "", true},
+	} {
+		s, err := ParseSyntheticPKStrategy(tc.s)
+		assert.Equal(t, tc.err, err != nil, tc.s)
+		assert.Equal(t, tc.expected, s, tc.s)
+	}
+}
+
+func TestSyntheticPKStrategy(t *testing.T) {
+	dump := "CREATE TABLE t (a text);\n" +
+		"COPY public.t (a) FROM stdin;\n" +
+		"x\ny\nz\n\\.\n"
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for _, tc := range []struct {
+		strategy SyntheticPKStrategy
+		colType  ddl.ScalarType
+		vals     []interface{} // Nil for random values.
+		report   string
+	}{
+		{
+			strategy: "",
+			colType:  ddl.Int64{},
+			vals:     []interface{}{bitReverse(0), bitReverse(1), bitReverse(2)},
+			report:   "bit-reversed sequence",
+		},
+		{
+			strategy: SyntheticPKBitReversed,
+			colType:  ddl.Int64{},
+			vals:     []interface{}{bitReverse(0), bitReverse(1), bitReverse(2)},
+			report:   "bit-reversed sequence",
+		},
+		{
+			strategy: SyntheticPKSequential,
+			colType:  ddl.Int64{},
+			vals:     []interface{}{int64(0), int64(1), int64(2)},
+			report:   "hotspot",
+		},
+		{
+			strategy: SyntheticPKUUID,
+			colType:  ddl.String{Len: ddl.Int64Length{Value: 36}},
+			report:   "random UUIDs",
+		},
+	} {
+		name := string(tc.strategy)
+		conv := MakeConv()
+		conv.SetSyntheticPKStrategy(tc.strategy)
+		conv, rows := runProcessPgDumpConv(conv, dump)
+		assert.Equal(t, tc.colType, conv.spSchema["t"].ColDefs["synth_id"].T, name)
+		assert.Equal(t, 3, len(rows), name)
+		seen := make(map[interface{}]bool)
+		for i, r := range rows {
+			assert.Equal(t, []string{"a", "synth_id"}, r.cols, name)
+			v := r.vals[1]
+			if tc.vals != nil {
+				assert.Equal(t, tc.vals[i], v, name)
+			} else {
+				assert.Regexp(t, uuid, v, name)
+			}
+			assert.False(t, seen[v], name)
+			seen[v] = true
+		}
+		var b strings.Builder
+		for _, l := range buildTableReport(conv, "t", nil).body[0].lines {
+			b.WriteString(l.text)
+		}
+		assert.Contains(t, b.String(), tc.report, name)
+	}
+}
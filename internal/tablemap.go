@@ -17,6 +17,9 @@ package internal
 import (
 	"fmt"
 	"strconv"
+	"strings"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
 )
 
 // GetSpannerTable maps a source DB table name into a legal Spanner table
@@ -25,7 +28,8 @@ import (
 // getSpannerTable may have to change a name to make it legal, we must ensure
 // that:
 // a) the new table name is legal
-// b) the new table name doesn't clash with other Spanner table names
+// b) the new table name doesn't clash with other Spanner table names,
+// ignoring case (Spanner names are case-insensitive)
 // c) we consistently return the same name for this table.
 func GetSpannerTable(conv *Conv, srcTable string) (string, error) {
 	if srcTable == "" {
@@ -34,16 +38,16 @@ func GetSpannerTable(conv *Conv, srcTable string) (string, error) {
 	if sp, found := conv.toSpanner[srcTable]; found {
 		return sp.name, nil
 	}
-	spTable, _ := FixName(srcTable)
-	if _, found := conv.toSource[spTable]; found {
-		// s has been used before i.e. FixName caused a collision.
+	spTable, _ := spannerName(srcTable)
+	if tableUsed(conv, spTable) {
+		// s has been used before i.e. spannerName caused a collision.
 		// Add unique postfix: use number of tables so far.
 		// However, there is a chance this has already been used,
 		// so need to iterate.
 		id := len(conv.toSpanner)
 		for {
-			t := spTable + "_" + strconv.Itoa(id)
-			if _, found := conv.toSource[t]; !found {
+			t := suffixName(spTable, "_"+strconv.Itoa(id))
+			if !tableUsed(conv, t) {
 				spTable = t
 				break
 			}
@@ -58,6 +62,22 @@ func GetSpannerTable(conv *Conv, srcTable string) (string, error) {
 	return spTable, nil
 }
 
+// BySourceTable re-keys counts keyed by Spanner table name (such as
+// those from spanner.BatchWriter) by the corresponding source table name.
+func BySourceTable(conv *Conv, m map[string]int64) map[string]int64 {
+	r := make(map[string]int64)
+	for spTable, n := range m {
+		src, ok := conv.toSource[spTable]
+		if !ok {
+			conv.unexpected(fmt.Sprintf("BySourceTable: unknown Spanner table %s", spTable))
+			r[spTable] += n
+			continue
+		}
+		r[src.name] += n
+	}
+	return r
+}
+
 // GetSpannerCol maps a source DB table/column into a legal Spanner column
 // name. If mustExist is true, we return error if the column is new.
 // Note that source DB column names can be essentially any string, but
@@ -65,7 +85,8 @@ func GetSpannerTable(conv *Conv, srcTable string) (string, error) {
 // getSpannerCol may have to change a name to make it legal, we must ensure
 // that:
 // a) the new col name is legal
-// b) the new col name doesn't clash with other col names in the same table
+// b) the new col name doesn't clash with other col names in the same
+// table, ignoring case
 // c) we consistently return the same name for the same col.
 func GetSpannerCol(conv *Conv, srcTable, srcCol string, mustExist bool) (string, error) {
 	if srcTable == "" {
@@ -90,16 +111,16 @@ func GetSpannerCol(conv *Conv, srcTable, srcCol string, mustExist bool) (string,
 	if mustExist {
 		return "", fmt.Errorf("table %s does not have a column %s", srcTable, srcCol)
 	}
-	spCol, _ := FixName(srcCol)
-	if _, found := conv.toSource[sp.name].cols[spCol]; found {
-		// spCol has been used before i.e. FixName caused a collision.
+	spCol, _ := spannerName(srcCol)
+	if colUsed(conv.toSource[sp.name].cols, spCol) {
+		// spCol has been used before i.e. spannerName caused a collision.
 		// Add unique postfix: use number of cols in this table so far.
 		// However, there is a chance this has already been used,
 		// so need to iterate.
 		id := len(sp.cols)
 		for {
-			c := spCol + "_" + strconv.Itoa(id)
-			if _, found := conv.toSource[sp.name].cols[c]; !found {
+			c := suffixName(spCol, "_"+strconv.Itoa(id))
+			if !colUsed(conv.toSource[sp.name].cols, c) {
 				spCol = c
 				break
 			}
@@ -114,6 +135,34 @@ func GetSpannerCol(conv *Conv, srcTable, srcCol string, mustExist bool) (string,
 	return spCol, nil
 }
 
+// tableUsed returns true if Spanner table name spTable is already in
+// use, ignoring case.
+func tableUsed(conv *Conv, spTable string) bool {
+	if _, found := conv.toSource[spTable]; found {
+		return true
+	}
+	for t := range conv.toSource {
+		if strings.EqualFold(t, spTable) {
+			return true
+		}
+	}
+	return false
+}
+
+// colUsed returns true if Spanner column name spCol is already in use
+// in cols (a map from Spanner to source column names), ignoring case.
+func colUsed(cols map[string]string, spCol string) bool {
+	if _, found := cols[spCol]; found {
+		return true
+	}
+	for c := range cols {
+		if strings.EqualFold(c, spCol) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetSpannerCols maps a slice of source columns into their corresponding
 // Spanner columns using GetSpannerCol.
 func GetSpannerCols(conv *Conv, srcTable string, srcCols []string) ([]string, error) {
@@ -127,3 +176,42 @@ func GetSpannerCols(conv *Conv, srcTable string, srcCols []string) ([]string, er
 	}
 	return spCols, nil
 }
+
+// renames returns a note for each name of srcTable (the table name, and
+// its column names) that was changed to make it a legal Spanner name.
+// It must be called after the table and its columns have been mapped.
+func renames(conv *Conv, srcTable schema.Table) []groupIssue {
+	var l []groupIssue
+	sp := conv.toSpanner[srcTable.Name]
+	if sp.name != srcTable.Name {
+		l = append(l, groupIssue{issue: renamed, construct: "table " + sp.name, detail: renameReason(srcTable.Name, sp.name)})
+	}
+	for _, c := range srcTable.ColNames {
+		if spCol, ok := sp.cols[c]; ok && spCol != c {
+			l = append(l, groupIssue{issue: renamed, cols: []string{c}, construct: "column " + spCol, detail: renameReason(c, spCol)})
+		}
+	}
+	return l
+}
+
+// renameSummary returns a summary of the table and column names that
+// were changed to make them legal Spanner names (empty if none were).
+func renameSummary(conv *Conv) string {
+	var tables, cols int
+	for _, l := range conv.groupIssues {
+		for _, g := range l {
+			if g.issue != renamed {
+				continue
+			}
+			if len(g.cols) > 0 {
+				cols++
+			} else {
+				tables++
+			}
+		}
+	}
+	if tables+cols == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Names changed: %d tables, %d columns (renamed to make them legal Spanner names; see the notes for each table)", tables, cols)
+}
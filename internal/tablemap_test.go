@@ -15,6 +15,7 @@
 package internal
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,6 +40,13 @@ func TestGetSpannerTable(t *testing.T) {
 		{"Illegal start character", "2table", false, "Atable"},
 		{"Illegal start character with collision (1)", "_table", false, "Atable_8"},
 		{"Illegal start character with collision (2)", "\ntable", false, "Atable_9"},
+		{"Clash ignoring case", "Table", false, "Table_10"}, // Spanner names are case-insensitive, so this clashes with "table".
+		{"Clash ignoring case (2)", "TAB_LE_12", false, "TAB_LE_12"},
+		{"Clash ignoring case (3)", "Tab-le", false, "Tab_le_13"}, // Must skip TAB_LE_12.
+		{"Reserved word", "group", false, "group_"},
+		{"Reserved word with collision", "group_", false, "group__14"},
+		{"Too long", strings.Repeat("t", 130), false, strings.Repeat("t", 119) + "_53173575"},
+		{"Too long with collision", strings.Repeat("t", 119) + "_53173575", false, strings.Repeat("t", 119) + "_53173_16"},
 	}
 	for _, tc := range basicTests {
 		spTable, err := GetSpannerTable(conv, tc.srcTable)
@@ -0,0 +1,236 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// TableOptions specifies Spanner table-level options for a source
+// table. Table options are read from a JSON config file that maps
+// source table names to options e.g.
+//
+//	{
+//	  "orders": {"row_deletion_policy": {"column": "expires_at", "days": 30}},
+//	  "users": {"commit_timestamp": {"column": "update_time", "fill": "commit"}},
+//	  "events": {"column_options": {"updated_at": {"allow_commit_timestamp": true}}}
+//	}
+//
+// A table's commit_timestamp option overrides the commit timestamp
+// column configured for all tables (see SetCommitTimestamp); an empty
+// column means none.
+type TableOptions struct {
+	RowDeletionPolicy *RowDeletionPolicyOption `json:"row_deletion_policy"`
+	CommitTimestamp   *CommitTimestampOption   `json:"commit_timestamp"`
+	ColumnOptions     map[string]ColumnOptions `json:"column_options"` // Keyed by source column name.
+}
+
+// ColumnOptions specifies Spanner column options for a source column.
+// AllowCommitTimestamp sets allow_commit_timestamp = true, and is only
+// allowed for columns that map to TIMESTAMP.
+type ColumnOptions struct {
+	AllowCommitTimestamp bool `json:"allow_commit_timestamp"`
+}
+
+// RowDeletionPolicyOption specifies a Spanner row deletion policy:
+// rows are deleted once the value of 'Column' (a source column that
+// maps to a Spanner TIMESTAMP) is more than 'Days' days old.
+type RowDeletionPolicyOption struct {
+	Column string `json:"column"`
+	Days   int64  `json:"days"`
+}
+
+// rowDeletionStats records the row deletion policy for a table, along
+// with stats about the values of its timestamp column seen during data
+// conversion.
+type rowDeletionStats struct {
+	srcCol    string
+	spCol     string
+	days      int64
+	cutoff    time.Time // Rows with timestamps before this are already expired.
+	horizon   time.Time // Rows with timestamps after this will effectively never expire.
+	expired   int64     // Count of rows that are already expired.
+	farFuture int64     // Count of rows with timestamps after horizon.
+}
+
+// farFutureYears defines how far in the future a timestamp must be
+// for us to flag it: such values are usually sentinels
+// (e.g. 9999-12-31) meaning "never expires".
+const farFutureYears = 100
+
+// ReadTableOptions reads a table options config file (see TableOptions).
+func ReadTableOptions(r io.Reader) (map[string]TableOptions, error) {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	var opts map[string]TableOptions
+	if err := d.Decode(&opts); err != nil {
+		return nil, fmt.Errorf("can't parse table options: %w", err)
+	}
+	return opts, nil
+}
+
+// ApplyTableOptions validates table options against the converted
+// schema and adds them to the Spanner schema, along with commit
+// timestamp columns. It must be called after
+// schema conversion. 'now' is used to assess whether timestamps seen
+// during data conversion are already older than a row deletion policy.
+func (conv *Conv) ApplyTableOptions(opts map[string]TableOptions, now time.Time) error {
+	// Process tables in sorted order so that errors are deterministic.
+	var tables []string
+	for t := range opts {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for _, srcTable := range tables {
+		o := opts[srcTable]
+		if _, ok := conv.srcSchema[srcTable]; !ok && o.CommitTimestamp != nil {
+			return fmt.Errorf("bad commit timestamp column for table %s: no such table", srcTable)
+		}
+		if o.RowDeletionPolicy != nil {
+			if err := conv.addRowDeletionPolicy(srcTable, *o.RowDeletionPolicy, now); err != nil {
+				return fmt.Errorf("bad row deletion policy for table %s: %w", srcTable, err)
+			}
+		}
+		if len(o.ColumnOptions) > 0 {
+			if err := conv.addColumnOptions(srcTable, o.ColumnOptions); err != nil {
+				return fmt.Errorf("bad column options for table %s: %w", srcTable, err)
+			}
+		}
+	}
+	return conv.addCommitTimestampColumns(opts)
+}
+
+func (conv *Conv) addRowDeletionPolicy(srcTable string, rdp RowDeletionPolicyOption, now time.Time) error {
+	srcSchema, ok := conv.srcSchema[srcTable]
+	if !ok {
+		return fmt.Errorf("no such table")
+	}
+	srcCol, ok := srcSchema.ColDefs[rdp.Column]
+	if !ok {
+		return fmt.Errorf("no such column: %s", rdp.Column)
+	}
+	if rdp.Days < 0 {
+		return fmt.Errorf("days must be non-negative, got %d", rdp.Days)
+	}
+	spTable, err := GetSpannerTable(conv, srcTable)
+	if err != nil {
+		return err
+	}
+	spCol, err := GetSpannerCol(conv, srcTable, rdp.Column, false)
+	if err != nil {
+		return err
+	}
+	spSchema := conv.spSchema[spTable]
+	cd := spSchema.ColDefs[spCol]
+	if _, ok := cd.T.(ddl.Timestamp); !ok || cd.IsArray {
+		return fmt.Errorf("column %s has Spanner type %s, but must be TIMESTAMP", rdp.Column, cd.PrintColumnDefType())
+	}
+	spSchema.RowDeletionPolicy = &ddl.RowDeletionPolicy{Col: spCol, Days: rdp.Days}
+	conv.spSchema[spTable] = spSchema
+	if conv.rowDeletion == nil {
+		conv.rowDeletion = make(map[string]*rowDeletionStats)
+	}
+	conv.rowDeletion[srcTable] = &rowDeletionStats{
+		srcCol:  rdp.Column,
+		spCol:   spCol,
+		days:    rdp.Days,
+		cutoff:  now.AddDate(0, 0, -int(rdp.Days)),
+		horizon: now.AddDate(farFutureYears, 0, 0),
+	}
+	issues := []schemaIssue{rowDeletionPolicy}
+	if !srcCol.NotNull {
+		issues = append(issues, rowDeletionPolicyNullable)
+	}
+	if conv.issues[srcTable] == nil {
+		conv.issues[srcTable] = make(map[string][]schemaIssue)
+	}
+	conv.issues[srcTable][rdp.Column] = append(conv.issues[srcTable][rdp.Column], issues...)
+	return nil
+}
+
+// addColumnOptions adds the column options opts (keyed by source
+// column) to the Spanner columns of srcTable.
+func (conv *Conv) addColumnOptions(srcTable string, opts map[string]ColumnOptions) error {
+	srcSchema, ok := conv.srcSchema[srcTable]
+	if !ok {
+		return fmt.Errorf("no such table")
+	}
+	var cols []string
+	for c := range opts {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	for _, srcCol := range cols {
+		if _, ok := srcSchema.ColDefs[srcCol]; !ok {
+			return fmt.Errorf("no such column: %s", srcCol)
+		}
+		if !opts[srcCol].AllowCommitTimestamp {
+			continue
+		}
+		spTable, err := GetSpannerTable(conv, srcTable)
+		if err != nil {
+			return err
+		}
+		spCol, err := GetSpannerCol(conv, srcTable, srcCol, false)
+		if err != nil {
+			return err
+		}
+		spSchema := conv.spSchema[spTable]
+		cd := spSchema.ColDefs[spCol]
+		if _, ok := cd.T.(ddl.Timestamp); !ok || cd.IsArray {
+			return fmt.Errorf("column %s has Spanner type %s, but %s needs TIMESTAMP", srcCol, cd.PrintColumnDefType(), ddl.AllowCommitTimestamp)
+		}
+		cd.SetOption(ddl.AllowCommitTimestamp, "true")
+		spSchema.ColDefs[spCol] = cd
+		if conv.issues[srcTable] == nil {
+			conv.issues[srcTable] = make(map[string][]schemaIssue)
+		}
+		conv.issues[srcTable][srcCol] = append(conv.issues[srcTable][srcCol], commitTimestamp)
+	}
+	return nil
+}
+
+// checkRowDeletion checks the timestamp used by srcTable's row
+// deletion policy (if any). We flag values that are already expired
+// (Spanner will delete these rows soon after they are written) and
+// values in the far future (which will never expire).
+func (conv *Conv) checkRowDeletion(srcTable string, spCols []string, spVals []interface{}) {
+	rd, ok := conv.rowDeletion[srcTable]
+	if !ok || !conv.dataMode() {
+		return
+	}
+	for i, c := range spCols {
+		if c != rd.spCol {
+			continue
+		}
+		t, ok := spVals[i].(time.Time)
+		if !ok {
+			return
+		}
+		switch {
+		case t.Before(rd.cutoff):
+			rd.expired++
+		case t.After(rd.horizon):
+			rd.farFuture++
+		}
+		return
+	}
+}
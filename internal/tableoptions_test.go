@@ -0,0 +1,140 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestReadTableOptions(t *testing.T) {
+	opts, err := ReadTableOptions(strings.NewReader(`{"orders": {"row_deletion_policy": {"column": "expires_at", "days": 30}}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]TableOptions{
+		"orders": TableOptions{RowDeletionPolicy: &RowDeletionPolicyOption{Column: "expires_at", Days: 30}},
+	}, opts)
+	_, err = ReadTableOptions(strings.NewReader(`{"orders": {"ttl": {"column": "expires_at"}}}`))
+	assert.NotNil(t, err)
+	_, err = ReadTableOptions(strings.NewReader(`{"orders": `))
+	assert.NotNil(t, err)
+}
+
+func TestApplyTableOptions(t *testing.T) {
+	schema := "CREATE TABLE t (id bigint PRIMARY KEY, expires timestamptz, created timestamptz NOT NULL, s text, a timestamptz[]);\n"
+	tc := []struct {
+		name     string
+		rdp      RowDeletionPolicyOption
+		table    string
+		expected *ddl.RowDeletionPolicy
+		issues   []schemaIssue
+		err      bool
+	}{
+		{name: "nullable", rdp: RowDeletionPolicyOption{Column: "expires", Days: 30}, expected: &ddl.RowDeletionPolicy{Col: "expires", Days: 30}, issues: []schemaIssue{rowDeletionPolicy, rowDeletionPolicyNullable}},
+		{name: "not null", rdp: RowDeletionPolicyOption{Column: "created", Days: 0}, expected: &ddl.RowDeletionPolicy{Col: "created", Days: 0}, issues: []schemaIssue{rowDeletionPolicy}},
+		{name: "no such table", table: "x", rdp: RowDeletionPolicyOption{Column: "expires", Days: 30}, err: true},
+		{name: "no such column", rdp: RowDeletionPolicyOption{Column: "x", Days: 30}, err: true},
+		{name: "not a timestamp", rdp: RowDeletionPolicyOption{Column: "s", Days: 30}, err: true},
+		{name: "timestamp array", rdp: RowDeletionPolicyOption{Column: "a", Days: 30}, err: true},
+		{name: "negative days", rdp: RowDeletionPolicyOption{Column: "expires", Days: -1}, err: true},
+	}
+	for _, tc := range tc {
+		conv, _ := runProcessPgDump(schema)
+		table := "t"
+		if tc.table != "" {
+			table = tc.table
+		}
+		err := conv.ApplyTableOptions(map[string]TableOptions{table: TableOptions{RowDeletionPolicy: &tc.rdp}}, time.Now())
+		assert.Equal(t, tc.err, err != nil, tc.name)
+		assert.Equal(t, tc.expected, conv.spSchema["t"].RowDeletionPolicy, tc.name)
+		if !tc.err {
+			assert.Equal(t, tc.issues, conv.issues["t"][tc.rdp.Column], tc.name)
+		}
+	}
+}
+
+func TestApplyTableOptions_ColumnOptions(t *testing.T) {
+	schema := "CREATE TABLE t (id bigint PRIMARY KEY, updated timestamptz, s text, a timestamptz[]);\n"
+	tc := []struct {
+		name string
+		col  string
+		err  bool
+	}{
+		{name: "timestamp", col: "updated"},
+		{name: "no such column", col: "x", err: true},
+		{name: "not a timestamp", col: "s", err: true},
+		{name: "timestamp array", col: "a", err: true},
+	}
+	for _, tc := range tc {
+		conv, _ := runProcessPgDump(schema)
+		opts := TableOptions{ColumnOptions: map[string]ColumnOptions{tc.col: {AllowCommitTimestamp: true}}}
+		err := conv.ApplyTableOptions(map[string]TableOptions{"t": opts}, time.Now())
+		assert.Equal(t, tc.err, err != nil, tc.name)
+		if tc.err {
+			continue
+		}
+		assert.True(t, conv.spSchema["t"].ColDefs[tc.col].AllowsCommitTimestamp(), tc.name)
+		assert.Equal(t, []schemaIssue{commitTimestamp}, conv.issues["t"][tc.col], tc.name)
+		assert.Contains(t, strings.Join(conv.GetDDL(ddl.Config{}), "\n"), "updated TIMESTAMP OPTIONS (allow_commit_timestamp = true)", tc.name)
+		b := new(strings.Builder)
+		w := bufio.NewWriter(b)
+		GenerateReport(PgDumpSource, conv, w, nil)
+		w.Flush()
+		assert.Contains(t, normalizeSpace(b.String()), "Column 'updated' has allow_commit_timestamp = true (see table options), so Spanner rejects values in the future", tc.name)
+	}
+	opts, err := ReadTableOptions(strings.NewReader(`{"t": {"column_options": {"updated": {"allow_commit_timestamp": true}}}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]TableOptions{"t": {ColumnOptions: map[string]ColumnOptions{"updated": {AllowCommitTimestamp: true}}}}, opts)
+	_, err = ReadTableOptions(strings.NewReader(`{"t": {"column_options": {"updated": {"version_retention_period": "1d"}}}}`))
+	assert.NotNil(t, err)
+}
+
+func TestRowDeletionPolicyData(t *testing.T) {
+	s := "CREATE TABLE t (id bigint PRIMARY KEY, expires timestamptz);\n" +
+		"COPY public.t (id, expires) FROM stdin;\n" +
+		"1	2020-06-01 00:00:00+00\n" + // Already expired.
+		"2	2020-06-29 00:00:00+00\n" +
+		"3	\\N\n" +
+		"4	9999-12-31 00:00:00+00\n" + // Far future.
+		"\\.\n"
+	now := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	conv := MakeConv()
+	conv.SetLocation(time.UTC)
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	opts := map[string]TableOptions{"t": TableOptions{RowDeletionPolicy: &RowDeletionPolicyOption{Column: "expires", Days: 7}}}
+	assert.Nil(t, conv.ApplyTableOptions(opts, now))
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(s)), nil))
+	assert.Equal(t, int64(1), conv.rowDeletion["t"].expired)
+	assert.Equal(t, int64(1), conv.rowDeletion["t"].farFuture)
+	tr := buildTableReport(conv, "t", nil)
+	assert.Equal(t, int64(1), tr.warnings)
+	expected := []tableReportBody{
+		{heading: "Warning", lines: []reportLine{{rowDeletionPolicyNullable, []string{"expires"},
+			"Column 'expires' is used by the row deletion policy but is nullable. Rows where this column is NULL will never be deleted"}}},
+		{heading: "Note", lines: []reportLine{{rowDeletionPolicy, []string{"expires"},
+			"Rows will be deleted by Spanner once column 'expires' is more than 7 days old (row deletion policy). " +
+				"1 rows are already older than this, and will be deleted soon after they are written. " +
+				"1 rows have timestamps more than 100 years in the future (possibly sentinel values), and will effectively never be deleted"}}},
+	}
+	assert.Equal(t, expected, tr.body)
+}
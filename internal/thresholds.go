@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Schema and data conversion are rated by the fraction of columns with
+// warnings, or of rows that are bad. Conversions below the GOOD
+// threshold are rated GOOD, those below the OK threshold are rated OK,
+// and the rest are rated POOR. Users can change the thresholds e.g. to
+// hold a migration to a stricter standard. Comparisons are done as in
+// the original integer formulas (e.g. bad < total/20 for 5%), so that
+// ratings are unchanged with the default thresholds.
+
+// RatingThresholds are the percentages of columns with warnings (for
+// schema ratings) or bad rows (for data ratings) below which a
+// conversion is rated GOOD or OK.
+type RatingThresholds struct {
+	Good float64
+	OK   float64
+}
+
+// DefaultRatingThresholds are the rating thresholds used unless
+// overridden (see Conv.SetRatingThresholds).
+var DefaultRatingThresholds = RatingThresholds{Good: 5, OK: 100.0 / 3}
+
+// Validate returns an error unless 0 < Good <= OK <= 100.
+func (th RatingThresholds) Validate() error {
+	if th.Good <= 0 || th.Good > th.OK || th.OK > 100 {
+		return fmt.Errorf("bad rating thresholds good=%g, ok=%g: must have 0 < good <= ok <= 100", th.Good, th.OK)
+	}
+	return nil
+}
+
+// ParseRatingThresholds parses rating thresholds given as percentages
+// e.g. "good=2,ok=10". Thresholds that aren't given keep their default
+// value, and the empty string gives the defaults.
+func ParseRatingThresholds(s string) (RatingThresholds, error) {
+	th := DefaultRatingThresholds
+	for _, p := range ParseIssueNames(s) {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return th, fmt.Errorf("bad rating threshold %q: expected good=<percent> or ok=<percent>", p)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			return th, fmt.Errorf("bad rating threshold %q: %v", p, err)
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "good":
+			th.Good = v
+		case "ok":
+			th.OK = v
+		default:
+			return th, fmt.Errorf("unknown rating threshold %q: must be good or ok", kv[0])
+		}
+	}
+	return th, th.Validate()
+}
+
+// SetRatingThresholds sets the thresholds of schema and data ratings.
+// It returns an error if th isn't valid.
+func (conv *Conv) SetRatingThresholds(th RatingThresholds) error {
+	if err := th.Validate(); err != nil {
+		return err
+	}
+	conv.thresholds = th
+	return nil
+}
+
+// ratingThresholds returns the rating thresholds in effect.
+func (conv *Conv) ratingThresholds() RatingThresholds {
+	if conv.thresholds == (RatingThresholds{}) {
+		return DefaultRatingThresholds
+	}
+	return conv.thresholds
+}
+
+// good returns whether bad of total is below the GOOD threshold.
+func (th RatingThresholds) good(total, bad int64) bool {
+	return below(total, bad, th.Good)
+}
+
+// ok returns whether bad of total is below the OK threshold.
+func (th RatingThresholds) ok(total, bad int64) bool {
+	return below(total, bad, th.OK)
+}
+
+// below returns whether bad is less than pct percent of total, rounded
+// down to a whole number.
+func below(total, bad int64, pct float64) bool {
+	return bad < int64(float64(total)*pct/100)
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRatingThresholds_Default checks that the default thresholds rate
+// exactly as the original integer formulas did.
+func TestRatingThresholds_Default(t *testing.T) {
+	th := DefaultRatingThresholds
+	for total := int64(0); total <= 1000; total++ {
+		for bad := int64(0); bad <= total; bad++ {
+			assert.Equal(t, bad < total/20, th.good(total, bad), "good(%d, %d)", total, bad)
+			assert.Equal(t, bad < total/3, th.ok(total, bad), "ok(%d, %d)", total, bad)
+		}
+	}
+}
+
+func TestParseRatingThresholds(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected RatingThresholds
+		err      bool
+	}{
+		{"", DefaultRatingThresholds, false},
+		{"good=2,ok=10", RatingThresholds{Good: 2, OK: 10}, false},
+		{" OK = 50 ", RatingThresholds{Good: 5, OK: 50}, false},
+		{"good=0.5", RatingThresholds{Good: 0.5, OK: 100.0 / 3}, false},
+		{"good=40", RatingThresholds{}, true},
+		{"good=0", RatingThresholds{}, true},
+		{"ok=101", RatingThresholds{}, true},
+		{"great=1", RatingThresholds{}, true},
+		{"good", RatingThresholds{}, true},
+		{"good=x", RatingThresholds{}, true},
+	} {
+		th, err := ParseRatingThresholds(tc.s)
+		if tc.err {
+			assert.NotNil(t, err, tc.s)
+			continue
+		}
+		assert.Nil(t, err, tc.s)
+		assert.Equal(t, tc.expected, th, tc.s)
+	}
+	conv := MakeConv()
+	assert.Equal(t, DefaultRatingThresholds, conv.ratingThresholds())
+	assert.NotNil(t, conv.SetRatingThresholds(RatingThresholds{Good: 10, OK: 5}))
+	assert.Nil(t, conv.SetRatingThresholds(RatingThresholds{Good: 1, OK: 10}))
+	assert.Equal(t, RatingThresholds{Good: 1, OK: 10}, conv.ratingThresholds())
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spanner timestamps are points in time, but source types such as
+// PostgreSQL's timestamp (without time zone) and MySQL's datetime are
+// just a date and time. We interpret such values in a configurable
+// zone (UTC by default).
+
+var offsetRE = regexp.MustCompile(`^(?:UTC)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// ParseTimeZone parses s, an IANA time zone name (e.g.
+// "America/New_York") or a fixed offset from UTC (e.g. "+05:30" or
+// "-08"). The empty string means UTC.
+func ParseTimeZone(s string) (*time.Location, error) {
+	if s == "" {
+		return time.UTC, nil
+	}
+	if m := offsetRE.FindStringSubmatch(s); m != nil {
+		h, _ := strconv.Atoi(m[2])
+		min, _ := strconv.Atoi(m[3]) // Zero if absent.
+		if h > 14 || min > 59 {
+			return nil, fmt.Errorf("bad time zone offset %q", s)
+		}
+		offset := h*3600 + min*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(s, offset), nil
+	}
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q: expected an IANA name (e.g. America/New_York) or an offset (e.g. +05:30)", s)
+	}
+	return loc, nil
+}
+
+// SetTimestampZone configures the zone that timestamps without time
+// zone are interpreted in during data conversion (nil means UTC).
+func (conv *Conv) SetTimestampZone(loc *time.Location) {
+	conv.timestampZone = loc
+}
+
+func (conv *Conv) naiveZone() *time.Location {
+	if conv.timestampZone == nil {
+		return time.UTC
+	}
+	return conv.timestampZone
+}
+
+// Spanner timestamps must be in years 1 to 9999 (UTC).
+const (
+	minTimestampYear = 1
+	maxTimestampYear = 9999
+)
+
+// timestampRangeError is the error for timestamps that Spanner can't
+// represent. Rows with such values are bad rows with reason
+// BadRowTimestampRange.
+type timestampRangeError struct {
+	val string
+}
+
+func (e *timestampRangeError) Error() string {
+	return fmt.Sprintf("can't convert to timestamp: %s is outside Spanner's range (years %d to %d)", e.val, minTimestampYear, maxTimestampYear)
+}
+
+// outOfRangeTimestamp reports whether val (as written by pg_dump or
+// mysqldump) is a timestamp that Spanner can't represent, but which
+// wouldn't otherwise parse: BC dates, infinities and years with more
+// than four digits.
+func outOfRangeTimestamp(val string) bool {
+	if strings.HasSuffix(val, " BC") || val == "infinity" || val == "-infinity" {
+		return true
+	}
+	i := strings.IndexByte(val, '-')
+	if i <= 4 {
+		return false
+	}
+	_, err := strconv.Atoi(val[:i])
+	return err == nil
+}
+
+// checkTimestampRange returns a timestampRangeError if t is outside
+// Spanner's range.
+func checkTimestampRange(t time.Time, val string) error {
+	if y := t.UTC().Year(); y < minTimestampYear || y > maxTimestampYear {
+		return &timestampRangeError{val}
+	}
+	return nil
+}
+
+func (conv *Conv) addTimestampRange(srcTable, srcCol string) {
+	if conv.tsOutOfRange[srcTable] == nil {
+		conv.tsOutOfRange[srcTable] = make(map[string]int64)
+	}
+	conv.tsOutOfRange[srcTable][srcCol]++
+}
+
+// describeTimestampRange describes the values of srcCol of srcTable
+// that were outside Spanner's range, for reports.
+func (conv *Conv) describeTimestampRange(srcTable, srcCol string) string {
+	n := conv.tsOutOfRange[srcTable][srcCol]
+	what := "values were"
+	if n == 1 {
+		what = "value was"
+	}
+	return fmt.Sprintf("Column '%s': %d %s outside Spanner's timestamp range, and their rows are bad rows", srcCol, n, what)
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimeZone(t *testing.T) {
+	tests := []struct {
+		s      string
+		offset int // Offset from UTC in seconds, on 2020-01-01.
+		err    bool
+	}{
+		{"", 0, false},
+		{"UTC", 0, false},
+		{"America/New_York", -5 * 3600, false},
+		{"+05:30", 5*3600 + 30*60, false},
+		{"-08", -8 * 3600, false},
+		{"UTC+3", 3 * 3600, false},
+		{"+0530", 5*3600 + 30*60, false},
+		{"+15:00", 0, true},
+		{"+05:60", 0, true},
+		{"Mars/Olympus_Mons", 0, true},
+	}
+	for _, tc := range tests {
+		loc, err := ParseTimeZone(tc.s)
+		assert.Equal(t, tc.err, err != nil, tc.s)
+		if err == nil {
+			_, offset := time.Date(2020, 1, 1, 0, 0, 0, 0, loc).Zone()
+			assert.Equal(t, tc.offset, offset, tc.s)
+		}
+	}
+}
+
+func TestProcessPgDump_TimestampZone(t *testing.T) {
+	conv := MakeConv()
+	loc, err := ParseTimeZone("America/New_York")
+	assert.Nil(t, err)
+	conv.SetTimestampZone(loc)
+	var buf bytes.Buffer
+	conv.SetBadRowWriter(NewBadRowWriter(&buf, "bad.jsonl", 1000))
+	conv, rows := runProcessPgDumpConv(conv, "CREATE TABLE t (id bigint PRIMARY KEY, ts timestamp, tz timestamptz);\n"+
+		"COPY public.t (id, ts, tz) FROM stdin;\n"+
+		"1\t2020-01-02 03:04:05\t2020-01-02 03:04:05+00\n"+
+		"2\t0044-03-15 12:00:00 BC\t\\N\n"+
+		"3\tinfinity\t\\N\n"+
+		"4\t\\N\t0001-01-01 00:00:00+01\n"+
+		"5\t12020-01-01 00:00:00\t\\N\n"+
+		"\\.\n")
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, []string{"id", "ts", "tz"}, rows[0].cols)
+	assert.True(t, getTime(t, "2020-01-02T08:04:05Z").Equal(rows[0].vals[1].(time.Time)))
+	assert.True(t, getTime(t, "2020-01-02T03:04:05Z").Equal(rows[0].vals[2].(time.Time)))
+	assert.Equal(t, int64(4), conv.BadRows())
+	assert.Equal(t, map[string]map[string]int64{"t": {"ts": 3, "tz": 1}}, conv.tsOutOfRange)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	for _, l := range lines {
+		var r badRowRecord
+		assert.Nil(t, json.Unmarshal([]byte(l), &r))
+		assert.Equal(t, BadRowTimestampRange, r.Reason)
+	}
+
+	tr := buildTableReport(conv, "t", nil)
+	assert.Equal(t, int64(2), tr.warnings)
+	b := new(bytes.Buffer)
+	w := bufio.NewWriter(b)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	s := normalizeSpace(b.String())
+	assert.Contains(t, s, "e.g. column 'ts' (values interpreted as America/New_York)")
+	assert.Contains(t, s, "Column 'ts': 3 values were outside Spanner's timestamp range, and their rows are bad rows. Spanner timestamps must be in years 1 to 9999")
+	assert.Contains(t, s, "Column 'tz': 1 value was outside Spanner's timestamp range")
+}
+
+func TestConvTimestamp_Zone(t *testing.T) {
+	loc, err := ParseTimeZone("+05:30")
+	assert.Nil(t, err)
+	ts, err := convTimestamp("timestamp", time.UTC, loc, "2020-01-02 03:04:05")
+	assert.Nil(t, err)
+	assert.Equal(t, getTime(t, "2020-01-01T21:34:05Z"), ts.UTC())
+	_, err = convTimestamp("timestamp", time.UTC, loc, "0001-01-01 01:00:00")
+	assert.IsType(t, &timestampRangeError{}, err)
+	_, err = convTimestamp("timestamp", time.UTC, loc, "2020-13-01 00:00:00")
+	_, ok := err.(*timestampRangeError)
+	assert.False(t, ok, "parse errors aren't range errors")
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Per-table timing tracks the wall-clock time spent converting each
+// table's data, and the number of bytes of source data processed, so
+// that the report can show which tables dominated the runtime of a
+// migration. Timing is only collected in data mode.
+
+// tableTiming is the time spent and bytes processed for a table.
+type tableTiming struct {
+	elapsed time.Duration
+	bytes   int64
+}
+
+// statsAddTiming records d of elapsed time and n bytes of source data
+// for srcTable. Tables may be processed in several pieces (e.g. a
+// pg_dump with many INSERT statements for a table), so calls are
+// cumulative.
+func (conv *Conv) statsAddTiming(srcTable string, d time.Duration, n int64) {
+	if !conv.dataMode() {
+		return
+	}
+	t, ok := conv.stats.timing[srcTable]
+	if !ok {
+		t = &tableTiming{}
+		conv.stats.timing[srcTable] = t
+	}
+	t.elapsed += d
+	t.bytes += n
+}
+
+// totalTiming returns the sum of all per-table timings, and the number
+// of rows in tables that have timing information.
+func (conv *Conv) totalTiming() (tableTiming, int64) {
+	var total tableTiming
+	var rows int64
+	for t, x := range conv.stats.timing {
+		total.elapsed += x.elapsed
+		total.bytes += x.bytes
+		rows += conv.stats.rows[t]
+	}
+	return total, rows
+}
+
+// formatThroughput returns a description of elapsed time and throughput
+// e.g. "4m32s, 12.3 MB/s, 9,200 rows/s". It returns the empty string if
+// there is nothing meaningful to report (no rows or no elapsed time).
+// The bytes rate is omitted when the number of bytes is unknown.
+func formatThroughput(t tableTiming, rows int64) string {
+	if rows <= 0 || t.elapsed <= 0 {
+		return ""
+	}
+	secs := t.elapsed.Seconds()
+	l := []string{formatDuration(t.elapsed)}
+	if t.bytes > 0 {
+		l = append(l, fmt.Sprintf("%.1f MB/s", float64(t.bytes)/1e6/secs))
+	}
+	if r := float64(rows) / secs; r < 10 {
+		l = append(l, fmt.Sprintf("%.1f rows/s", r))
+	} else {
+		l = append(l, fmt.Sprintf("%s rows/s", formatCount(int64(r+0.5))))
+	}
+	return strings.Join(l, ", ")
+}
+
+// formatDuration prints d at a precision appropriate to its size
+// e.g. "4m32s", "2.5s" or "250ms".
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+// formatCount prints n with comma thousands separators e.g. "9,200".
+func formatCount(n int64) string {
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	s := fmt.Sprintf("%d", n)
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatThroughput(t *testing.T) {
+	tests := []struct {
+		name     string
+		timing   tableTiming
+		rows     int64
+		expected string
+	}{
+		{"typical", tableTiming{4*time.Minute + 32*time.Second, 3346 * 1000 * 1000}, 2502400, "4m32s, 12.3 MB/s, 9,200 rows/s"},
+		{"subsecond", tableTiming{250 * time.Millisecond, 500 * 1000}, 1000, "250ms, 2.0 MB/s, 4,000 rows/s"},
+		{"unknown bytes", tableTiming{2 * time.Second, 0}, 10, "2s, 5.0 rows/s"},
+		{"slow", tableTiming{2500 * time.Millisecond, 1000}, 3, "2.5s, 0.0 MB/s, 1.2 rows/s"},
+		{"no rows", tableTiming{2 * time.Second, 100}, 0, ""},
+		{"no time", tableTiming{0, 100}, 10, ""},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, formatThroughput(tc.timing, tc.rows), tc.name)
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	for n, s := range map[int64]string{
+		0:        "0",
+		999:      "999",
+		1000:     "1,000",
+		9200:     "9,200",
+		1234567:  "1,234,567",
+		-1234567: "-1,234,567",
+	} {
+		assert.Equal(t, s, formatCount(n))
+	}
+}
+
+func TestTiming_PgDump(t *testing.T) {
+	copyStmt := "COPY public.t (a) FROM stdin;\n1\n2\n3\n\\.\n"
+	insert := "INSERT INTO u (a) VALUES (1);\n"
+	dump := "CREATE TABLE t (a bigint PRIMARY KEY);\n" +
+		"CREATE TABLE u (a bigint PRIMARY KEY);\n" +
+		copyStmt + insert + insert
+	conv := MakeConv()
+	conv.SetLocation(time.UTC)
+	// Each call to the clock advances it by one second.
+	var clock time.Time
+	conv.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	conv.SetSchemaMode()
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(dump)), nil))
+	assert.Empty(t, conv.stats.timing, "schema mode shouldn't record timing")
+	conv.SetDataMode()
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {})
+	ProcessPgDump(conv, NewReader(bufio.NewReader(strings.NewReader(dump)), nil))
+	assert.Equal(t, map[string]*tableTiming{
+		"t": {time.Second, int64(len(copyStmt))},
+		"u": {2 * time.Second, int64(2 * len(insert))},
+	}, conv.stats.timing)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), "Data conversion time: 3s, 0.0 MB/s, 1.7 rows/s.\n")
+	assert.Contains(t, buf.String(), "Time: 1s, 0.0 MB/s, 3.0 rows/s.\n")
+	assert.Contains(t, buf.String(), "Time: 2s, 0.0 MB/s, 1.0 rows/s.\n")
+}
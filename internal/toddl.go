@@ -16,6 +16,8 @@ package internal
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -28,7 +30,23 @@ import (
 // Spanner. It uses the source schema in conv.srcSchema, and writes
 // the Spanner schema to conv.spSchema.
 func schemaToDDL(conv *Conv) error {
-	for _, srcTable := range conv.srcSchema {
+	toType := toSpannerType
+	if conv.mysql {
+		toType = toSpannerTypeMySQL
+	}
+	if conv.dynamo != nil {
+		toType = toSpannerTypeDynamo
+	}
+	if conv.csv != nil {
+		toType = toSpannerTypeCSV
+	}
+	if conv.sqlserver {
+		toType = toSpannerTypeSQLServer
+	}
+	// Map tables in sorted order, so that names that clash are renamed
+	// deterministically.
+	for _, t := range sortedSrcTables(conv) {
+		srcTable := conv.srcSchema[t]
 		spTableName, err := GetSpannerTable(conv, srcTable.Name)
 		if err != nil {
 			conv.unexpected(fmt.Sprintf("Couldn't map source table %s to Spanner: %s", srcTable.Name, err))
@@ -36,6 +54,7 @@ func schemaToDDL(conv *Conv) error {
 		}
 		var spColNames []string
 		spColDef := make(map[string]ddl.ColumnDef)
+		spTypes := make(map[string]ddl.ScalarType) // Spanner types, keyed by source column.
 		conv.issues[srcTable.Name] = make(map[string][]schemaIssue)
 		// Iterate over columns using ColNames order.
 		for _, srcColName := range srcTable.ColNames {
@@ -46,30 +65,63 @@ func schemaToDDL(conv *Conv) error {
 				continue
 			}
 			spColNames = append(spColNames, colName)
-			ty, issues := toSpannerType(conv, srcCol.Type.Name, srcCol.Type.Mods)
+			notNull := srcCol.NotNull
+			ty, issues := toType(conv, srcCol.Type.Name, srcCol.Type.Mods)
+			ty, issues = conv.boundString(ty, issues)
+			if o, ok := conv.typeOverride(srcTable.Name, srcCol.Name, srcCol.Type.Name); ok {
+				// ReadTypeMap has already validated the override's type.
+				ty, _ = o.spannerType()
+				issues = []schemaIssue{typeOverride}
+				if o.NotNull != nil {
+					notNull = *o.NotNull
+				}
+				conv.addTypeOverride(srcTable.Name, srcCol.Name, o)
+			}
 			if len(srcCol.Type.ArrayBounds) > 1 {
-				ty = ddl.String{Len: ddl.MaxLength{}}
-				issues = append(issues, multiDimensionalArray)
+				ty, issues = conv.boundString(ddl.String{Len: ddl.MaxLength{}}, append(issues, multiDimensionalArray))
 			}
 			// TODO: add issues for all elements of srcCol.Ignored.
-			if srcCol.Ignored.ForeignKey {
-				issues = append(issues, foreignKey)
+			if srcCol.Ignored.Identity {
+				issues = append(issues, serial)
 			}
 			if srcCol.Ignored.Default {
 				issues = append(issues, defaultValue)
 			}
+			if srcCol.Domain != "" {
+				issues = append(issues, domain)
+			}
 			if len(issues) > 0 {
 				conv.issues[srcTable.Name][srcCol.Name] = issues
 			}
+			if len(srcCol.Type.ArrayBounds) == 0 {
+				spTypes[srcCol.Name] = ty
+			}
 			spColDef[colName] = ddl.ColumnDef{
 				Name:    colName,
 				T:       ty,
 				IsArray: len(srcCol.Type.ArrayBounds) == 1,
-				NotNull: srcCol.NotNull,
+				NotNull: notNull,
 				Comment: "From: " + quoteIfNeeded(srcCol.Name) + " " + printSourceType(srcCol.Type),
 			}
 		}
+		conv.groupIssues[srcTable.Name] = orderingChanges(srcTable, spTypes)
+		if g, ok := conv.partitionIssue(srcTable.Name); ok {
+			conv.groupIssues[srcTable.Name] = append(conv.groupIssues[srcTable.Name], g)
+		}
+		conv.groupIssues[srcTable.Name] = append(conv.groupIssues[srcTable.Name], conv.inheritanceIssues(srcTable.Name)...)
+		conv.groupIssues[srcTable.Name] = append(conv.groupIssues[srcTable.Name], renames(conv, srcTable)...)
+		for _, c := range srcTable.Checks {
+			desc := "check constraint"
+			if c.Name != "" {
+				desc += " " + c.Name
+			}
+			desc += fmt.Sprintf(" (%s)", strings.Join(c.Columns, ", "))
+			conv.groupIssues[srcTable.Name] = append(conv.groupIssues[srcTable.Name], groupIssue{issue: checkConstraint, cols: c.Columns, construct: desc})
+		}
 		comment := "Spanner schema for source table " + quoteIfNeeded(srcTable.Name)
+		if c := sourceComments(srcTable); c != "" {
+			comment += "\n" + c
+		}
 		conv.spSchema[spTableName] = ddl.CreateTable{
 			Name:     spTableName,
 			ColNames: spColNames,
@@ -77,9 +129,242 @@ func schemaToDDL(conv *Conv) error {
 			Pks:      cvtPrimaryKeys(conv, srcTable.Name, srcTable.PrimaryKeys),
 			Comment:  comment}
 	}
+	conv.checkTypeMap()
+	// Index and constraint names share a namespace with table names
+	// in Spanner, so we track all names used.
+	used := make(map[string]bool) // Spanner names (lower case) used so far.
+	for t := range conv.spSchema {
+		used[strings.ToLower(t)] = true
+	}
+	cvtIndexes(conv, used)
+	cvtForeignKeys(conv, used)
 	return nil
 }
 
+// cvtIndexes converts the secondary indexes of source tables into
+// Spanner indexes. Indexes that Spanner can't represent (e.g. indexes
+// on expressions) are dropped, and reported as warnings.
+func cvtIndexes(conv *Conv, used map[string]bool) {
+	for _, t := range sortedSrcTables(conv) {
+		srcTable := conv.srcSchema[t]
+		spTable, err := GetSpannerTable(conv, t)
+		ct, ok := conv.spSchema[spTable]
+		if err != nil || !ok {
+			continue
+		}
+		ct.Indexes = nil
+		for _, i := range srcTable.Indexes {
+			var cols []string
+			for _, k := range i.Keys {
+				cols = append(cols, k.Column)
+			}
+			desc := "index"
+			if i.Name != "" {
+				desc += " " + i.Name
+			}
+			spIndex, err := cvtIndex(conv, srcTable, ct, i)
+			if err != nil {
+				// Dropping a unique index (or the index of a unique
+				// constraint) also drops the uniqueness of its columns.
+				issue := indexUnsupported
+				if i.Unique {
+					issue = uniqueUnsupported
+					desc = fmt.Sprintf("unique %s (%s)", desc, strings.Join(cols, ", "))
+				}
+				conv.groupIssues[t] = append(conv.groupIssues[t], groupIssue{issue: issue, cols: cols, construct: desc, detail: err.Error()})
+				conv.addDroppedObject("index", i.Name, []string{t}, conv.indexSQL[t][i.Name], err.Error())
+				continue
+			}
+			name := i.Name
+			if name == "" {
+				name = spTable + "_idx"
+			}
+			spIndex.Name = constraintName(name, used)
+			ct.Indexes = append(ct.Indexes, spIndex)
+		}
+		conv.spSchema[spTable] = ct
+	}
+}
+
+// cvtIndex converts index i of srcTable to a Spanner index on table ct.
+// If i can't be represented in Spanner, it returns an error explaining
+// why.
+func cvtIndex(conv *Conv, srcTable schema.Table, ct ddl.CreateTable, i schema.Index) (ddl.CreateIndex, error) {
+	switch {
+	case i.Ignored.Expression:
+		return ddl.CreateIndex{}, fmt.Errorf("it indexes expressions, which Spanner doesn't support")
+	case i.Ignored.Predicate:
+		return ddl.CreateIndex{}, fmt.Errorf("it is a partial index (it has a WHERE clause), which Spanner doesn't support")
+	case i.Ignored.Method != "":
+		return ddl.CreateIndex{}, fmt.Errorf("Spanner doesn't support %s indexes", i.Ignored.Method)
+	case len(i.Keys) == 0:
+		return ddl.CreateIndex{}, fmt.Errorf("it has no key columns")
+	}
+	spIndex := ddl.CreateIndex{Table: ct.Name, Unique: i.Unique}
+	keys := make(map[string]bool) // Spanner key columns of ct and spIndex.
+	for _, k := range ct.Pks {
+		keys[k.Col] = true
+	}
+	for _, k := range i.Keys {
+		col, err := GetSpannerCol(conv, srcTable.Name, k.Column, true)
+		if err != nil {
+			return ddl.CreateIndex{}, fmt.Errorf("column '%s' was not found", k.Column)
+		}
+		if ct.ColDefs[col].IsArray {
+			return ddl.CreateIndex{}, fmt.Errorf("Spanner doesn't support indexes on array columns")
+		}
+		// PostgreSQL unique indexes allow multiple rows with NULL keys,
+		// but Spanner treats NULLs as equal unless NULL_FILTERED is used.
+		if i.Unique && !srcTable.ColDefs[k.Column].NotNull {
+			spIndex.NullFiltered = true
+		}
+		spIndex.Keys = append(spIndex.Keys, ddl.IndexKey{Col: col, Desc: k.Desc})
+		keys[col] = true
+	}
+	for _, c := range i.Storing {
+		col, err := GetSpannerCol(conv, srcTable.Name, c, true)
+		if err != nil {
+			return ddl.CreateIndex{}, fmt.Errorf("column '%s' was not found", c)
+		}
+		// Spanner always stores key columns in the index, and doesn't
+		// allow them to be listed in STORING.
+		if !keys[col] {
+			spIndex.Storing = append(spIndex.Storing, col)
+		}
+	}
+	return spIndex, nil
+}
+
+// sortedSrcTables returns the names of source tables in sorted order.
+// Processing tables in a fixed order ensures that any renaming of
+// clashing index or constraint names is deterministic.
+func sortedSrcTables(conv *Conv) []string {
+	var tables []string
+	for t := range conv.srcSchema {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// cvtForeignKeys converts the foreign keys of source tables into
+// Spanner foreign keys. This must run after all tables have been
+// converted, since foreign keys refer to the columns of other tables.
+// Foreign keys that Spanner can't represent are dropped, and reported
+// as warnings.
+func cvtForeignKeys(conv *Conv, used map[string]bool) {
+	for _, t := range sortedSrcTables(conv) {
+		srcTable := conv.srcSchema[t]
+		spTable, err := GetSpannerTable(conv, t)
+		ct, ok := conv.spSchema[spTable]
+		if err != nil || !ok {
+			continue
+		}
+		ct.ForeignKeys = nil
+		for _, fk := range srcTable.ForeignKeys {
+			spFk, desc, err := cvtForeignKey(conv, t, fk)
+			if err != nil {
+				conv.groupIssues[t] = append(conv.groupIssues[t], groupIssue{issue: foreignKeyUnsupported, cols: fk.Columns, construct: desc, detail: err.Error()})
+				continue
+			}
+			spFk.Name = constraintName(fk.Name, used)
+			ct.ForeignKeys = append(ct.ForeignKeys, spFk)
+			g := groupIssue{issue: foreignKey, cols: fk.Columns, construct: desc}
+			if !noAction(fk.OnUpdate) {
+				g.detail = fmt.Sprintf("Spanner doesn't support ON UPDATE %s, so updates to referenced columns that would break the foreign key will fail instead", fk.OnUpdate)
+			}
+			conv.groupIssues[t] = append(conv.groupIssues[t], g)
+		}
+		conv.spSchema[spTable] = ct
+	}
+}
+
+// cvtForeignKey converts foreign key fk of srcTable to a Spanner foreign
+// key. It also returns a description of fk for use in reports. If fk
+// can't be represented in Spanner, it returns an error explaining why.
+func cvtForeignKey(conv *Conv, srcTable string, fk schema.ForeignKey) (ddl.ForeignKey, string, error) {
+	referCols := fk.ReferColumns
+	refer, referFound := conv.srcSchema[fk.ReferTable]
+	if len(referCols) == 0 && referFound {
+		// A foreign key without referenced columns refers to the
+		// primary key of the referenced table.
+		for _, k := range refer.PrimaryKeys {
+			referCols = append(referCols, k.Column)
+		}
+	}
+	desc := "foreign key"
+	if fk.Name != "" {
+		desc += " " + fk.Name
+	}
+	desc += fmt.Sprintf(" (%s) referencing %s (%s)", strings.Join(fk.Columns, ", "), fk.ReferTable, strings.Join(referCols, ", "))
+	if !referFound {
+		return ddl.ForeignKey{}, desc, fmt.Errorf("the referenced table %s was not found", fk.ReferTable)
+	}
+	if len(fk.Columns) == 0 || len(fk.Columns) != len(referCols) {
+		return ddl.ForeignKey{}, desc, fmt.Errorf("it has %d columns but references %d columns", len(fk.Columns), len(referCols))
+	}
+	spFk := ddl.ForeignKey{}
+	switch {
+	case noAction(fk.OnDelete):
+	case fk.OnDelete == "CASCADE":
+		spFk.OnDelete = "CASCADE"
+	default:
+		return ddl.ForeignKey{}, desc, fmt.Errorf("Spanner doesn't support ON DELETE %s", fk.OnDelete)
+	}
+	var err error
+	if spFk.ReferTable, err = GetSpannerTable(conv, fk.ReferTable); err != nil {
+		return ddl.ForeignKey{}, desc, err
+	}
+	spTable, _ := GetSpannerTable(conv, srcTable)
+	ct, referCt := conv.spSchema[spTable], conv.spSchema[spFk.ReferTable]
+	for i := range fk.Columns {
+		col, err1 := GetSpannerCol(conv, srcTable, fk.Columns[i], true)
+		referCol, err2 := GetSpannerCol(conv, fk.ReferTable, referCols[i], true)
+		if err1 != nil || err2 != nil {
+			return ddl.ForeignKey{}, desc, fmt.Errorf("column '%s' or referenced column '%s' was not found", fk.Columns[i], referCols[i])
+		}
+		cd, referCd := ct.ColDefs[col], referCt.ColDefs[referCol]
+		if cd.IsArray || referCd.IsArray {
+			return ddl.ForeignKey{}, desc, fmt.Errorf("Spanner doesn't support foreign keys on array columns")
+		}
+		// Spanner requires the same types, but allows different
+		// lengths for STRING and BYTES.
+		if reflect.TypeOf(cd.T) != reflect.TypeOf(referCd.T) {
+			return ddl.ForeignKey{}, desc, fmt.Errorf("column '%s' maps to %s but the referenced column '%s' maps to %s, and Spanner requires them to have the same type",
+				fk.Columns[i], strings.ToLower(cd.PrintColumnDefType()), referCols[i], strings.ToLower(referCd.PrintColumnDefType()))
+		}
+		spFk.Columns = append(spFk.Columns, col)
+		spFk.ReferColumns = append(spFk.ReferColumns, referCol)
+	}
+	return spFk, desc, nil
+}
+
+// noAction returns true if the referential action a is equivalent to
+// Spanner's (default) NO ACTION. Note that RESTRICT differs from NO
+// ACTION in PostgreSQL only when constraint checks are deferred, which
+// Spanner doesn't support.
+func noAction(a string) bool {
+	return a == "" || a == "NO ACTION" || a == "RESTRICT"
+}
+
+// constraintName returns a legal Spanner name for source constraint
+// or index name s, making it unique amongst the names in used (and
+// adding it to used). Spanner constraint names share a namespace
+// across the database, whereas source DBs often scope them by table.
+// Returns the empty string for unnamed constraints.
+func constraintName(s string, used map[string]bool) string {
+	if s == "" {
+		return ""
+	}
+	base, _ := spannerName(s)
+	name := base
+	for i := 1; used[strings.ToLower(name)]; i++ {
+		name = suffixName(base, fmt.Sprintf("_%d", i))
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
 // toSpannerType maps a scalar source schema type (defined by id and
 // mods) into a Spanner type. This is the core source-to-Spanner type
 // mapping.  toSpannerType returns the Spanner type and a list of type
@@ -125,15 +410,19 @@ func toSpannerType(conv *Conv, id string, mods []int64) (ddl.ScalarType, []schem
 	case "int2", "smallint":
 		maxExpectedMods(0)
 		return ddl.Int64{}, []schemaIssue{widened}
-	case "numeric": // Map all numeric types to float64.
+	case "numeric", "decimal":
 		maxExpectedMods(2)
-		if len(mods) > 0 && mods[0] <= 15 {
-			// float64 can represent this numeric type faithfully.
-			// Note: int64 has 53 bits for mantissa, which is ~15.96
-			// decimal digits.
-			return ddl.Float64{}, []schemaIssue{numericThatFits}
+		switch {
+		case conv.pg():
+			// PostgreSQL-dialect numeric holds all values.
+			return ddl.Numeric{}, []schemaIssue{numericThatFits}
+		case len(mods) == 0:
+			// Unconstrained numeric: some values may not fit.
+			return ddl.Numeric{}, []schemaIssue{numeric}
+		case numericFits(mods):
+			return ddl.Numeric{}, []schemaIssue{numericThatFits}
 		}
-		return ddl.Float64{}, []schemaIssue{numeric}
+		return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{numericOutOfRange}
 	case "serial":
 		maxExpectedMods(0)
 		return ddl.Int64{}, []schemaIssue{serial}
@@ -154,9 +443,73 @@ func toSpannerType(conv *Conv, id string, mods []int64) (ddl.ScalarType, []schem
 		}
 		return ddl.String{Len: ddl.MaxLength{}}, nil
 	}
+	if labels, ok := conv.enumLabels(id); ok {
+		maxExpectedMods(0)
+		return enumType(labels), []schemaIssue{enum}
+	}
 	return ddl.String{Len: ddl.MaxLength{}}, []schemaIssue{noGoodType}
 }
 
+// numericFits returns true if all values of numeric(precision, scale)
+// (where mods is {precision} or {precision, scale}) fit in a Spanner
+// NUMERIC, which has precision 38 and scale 9 i.e. at most 29 digits
+// before the decimal point and 9 digits after it.
+func numericFits(mods []int64) bool {
+	var scale int64
+	if len(mods) > 1 {
+		scale = mods[1]
+	}
+	return scale <= 9 && mods[0]-scale <= 29
+}
+
+// orderingChanges returns an orderingChanged issue for each primary key
+// or index column whose type mapping changes ordering or comparison
+// semantics. For columns that are not keys, the generic type mapping
+// issues are sufficient, but for keys the impact is much larger
+// (e.g. range scans, pagination and uniqueness).
+func orderingChanges(srcTable schema.Table, spTypes map[string]ddl.ScalarType) []groupIssue {
+	var l []groupIssue
+	check := func(construct string, keys []schema.Key) {
+		for _, k := range keys {
+			ty, ok := spTypes[k.Column]
+			if ok && orderingChangeReason(srcTable.ColDefs[k.Column].Type, ty) != "" {
+				l = append(l, groupIssue{issue: orderingChanged, cols: []string{k.Column}, construct: construct})
+			}
+		}
+	}
+	check("primary key", srcTable.PrimaryKeys)
+	for _, i := range srcTable.Indexes {
+		if i.Ignored == (schema.IndexIgnored{}) { // Ignored indexes are dropped.
+			check("index "+i.Name, i.Keys)
+		}
+	}
+	return l
+}
+
+// orderingChangeReason explains how mapping source type srcType to
+// Spanner type ty changes ordering or comparison semantics. Returns
+// the empty string if the mapping preserves them.
+func orderingChangeReason(srcType schema.Type, ty ddl.ScalarType) string {
+	switch ty.(type) {
+	case ddl.Bytes:
+		if srcType.Name == "bytea" {
+			return "Spanner orders bytes bytewise, which may differ from the source ordering of encoded data (e.g. collation-aware text)"
+		}
+	case ddl.String:
+		switch srcType.Name {
+		case "numeric", "decimal":
+			// See toSpannerType: numerics that don't fit in Spanner's
+			// NUMERIC are mapped to string.
+			return "Spanner compares the values as strings, so they don't sort in numeric order (e.g. '10' sorts before '9')"
+		case "citext":
+			return "Spanner string comparisons are case-sensitive, which changes both ordering and uniqueness"
+		case "uuid":
+			return "Spanner compares the values as strings, so the same uuid written in different case or format is treated as a distinct value"
+		}
+	}
+	return ""
+}
+
 func printSourceType(ty schema.Type) string {
 	s := ty.Name
 	if len(ty.Mods) > 0 {
@@ -53,7 +53,7 @@ func TestToSpannerType(t *testing.T) {
 			"b": ddl.ColumnDef{Name: "b", T: ddl.Float64{}},
 			"c": ddl.ColumnDef{Name: "c", T: ddl.Bool{}},
 			"d": ddl.ColumnDef{Name: "d", T: ddl.String{Len: ddl.Int64Length{Value: 6}}},
-			"e": ddl.ColumnDef{Name: "e", T: ddl.Float64{}},
+			"e": ddl.ColumnDef{Name: "e", T: ddl.Numeric{}},
 			"f": ddl.ColumnDef{Name: "f", T: ddl.Timestamp{}},
 		},
 		Pks: []ddl.IndexKey{ddl.IndexKey{Col: "a"}},
@@ -74,3 +74,125 @@ func dropComments(t *ddl.CreateTable) {
 		t.ColDefs[c] = cd
 	}
 }
+
+func TestToSpannerType_Numeric(t *testing.T) {
+	tc := []struct {
+		name  string
+		mods  []int64
+		ty    ddl.ScalarType
+		issue schemaIssue
+	}{
+		{"numeric", nil, ddl.Numeric{}, numeric},
+		{"numeric", []int64{6, 2}, ddl.Numeric{}, numericThatFits},
+		{"numeric", []int64{29}, ddl.Numeric{}, numericThatFits},
+		{"numeric", []int64{38, 9}, ddl.Numeric{}, numericThatFits},
+		{"decimal", []int64{10, 4}, ddl.Numeric{}, numericThatFits},
+		{"numeric", []int64{30}, ddl.String{Len: ddl.MaxLength{}}, numericOutOfRange},
+		{"numeric", []int64{38, 10}, ddl.String{Len: ddl.MaxLength{}}, numericOutOfRange},
+		{"numeric", []int64{12, 12}, ddl.String{Len: ddl.MaxLength{}}, numericOutOfRange},
+	}
+	for _, c := range tc {
+		conv := MakeConv()
+		ty, issues := toSpannerType(conv, c.name, c.mods)
+		assert.Equal(t, c.ty, ty, "%s %v", c.name, c.mods)
+		assert.Equal(t, []schemaIssue{c.issue}, issues, "%s %v", c.name, c.mods)
+	}
+}
+
+func TestOrderingChanged(t *testing.T) {
+	tc := []struct {
+		srcType string
+		mods    []int64
+		changed bool
+	}{
+		{"numeric", nil, false},
+		{"numeric", []int64{20, 2}, false},
+		{"numeric", []int64{40, 2}, true}, // Doesn't fit in Spanner numeric, so mapped to string.
+		{"uuid", nil, true},
+		{"citext", nil, true},
+		{"bytea", nil, true},
+		{"bigint", nil, false},
+		{"text", nil, false},
+		{"timestamptz", nil, false},
+	}
+	for _, c := range tc {
+		for _, construct := range []string{"primary key", "index idx_k"} {
+			conv := MakeConv()
+			conv.SetSchemaMode()
+			srcSchema := schema.Table{
+				Name:     "t",
+				ColNames: []string{"k", "v"},
+				ColDefs: map[string]schema.Column{
+					"k": schema.Column{Name: "k", Type: schema.Type{Name: c.srcType, Mods: c.mods}},
+					"v": schema.Column{Name: "v", Type: schema.Type{Name: c.srcType, Mods: c.mods}},
+				},
+			}
+			if construct == "primary key" {
+				srcSchema.PrimaryKeys = []schema.Key{{Column: "k"}}
+			} else {
+				srcSchema.PrimaryKeys = []schema.Key{{Column: "v"}}
+				srcSchema.ColDefs["v"] = schema.Column{Name: "v", Type: schema.Type{Name: "bigint"}}
+				srcSchema.Indexes = []schema.Index{{Name: "idx_k", Keys: []schema.Key{{Column: "k"}}}}
+			}
+			conv.srcSchema["t"] = srcSchema
+			assert.Nil(t, schemaToDDL(conv))
+			var expected []groupIssue
+			if c.changed {
+				expected = []groupIssue{{issue: orderingChanged, cols: []string{"k"}, construct: construct}}
+			}
+			assert.Equal(t, expected, conv.groupIssues["t"], "%s %s", c.srcType, construct)
+		}
+	}
+}
+
+func TestForeignKeys_ConstraintNames(t *testing.T) {
+	conv := MakeConv()
+	conv.SetSchemaMode()
+	fk := func(name string) schema.ForeignKey {
+		return schema.ForeignKey{Name: name, Columns: []string{"p"}, ReferTable: "p"}
+	}
+	for _, tbl := range []string{"a", "b", "c", "p"} {
+		conv.srcSchema[tbl] = schema.Table{
+			Name:        tbl,
+			ColNames:    []string{"p"},
+			ColDefs:     map[string]schema.Column{"p": schema.Column{Name: "p", Type: schema.Type{Name: "bigint"}}},
+			PrimaryKeys: []schema.Key{{Column: "p"}},
+		}
+	}
+	// Spanner constraint names must be unique across the database,
+	// whereas PostgreSQL constraint names are scoped to the table.
+	conv.srcSchema["a"] = withForeignKeys(conv.srcSchema["a"], fk("fk"))
+	conv.srcSchema["b"] = withForeignKeys(conv.srcSchema["b"], fk("FK"), fk(""))
+	conv.srcSchema["c"] = withForeignKeys(conv.srcSchema["c"], fk("fk"))
+	assert.Nil(t, schemaToDDL(conv))
+	var names []string
+	for _, tbl := range []string{"a", "b", "c"} {
+		for _, fk := range conv.spSchema[tbl].ForeignKeys {
+			assert.Equal(t, []string{"p"}, fk.ReferColumns) // Resolved from the primary key of p.
+			names = append(names, fk.Name)
+		}
+	}
+	assert.Equal(t, []string{"fk", "FK_1", "", "fk_2"}, names)
+}
+
+func withForeignKeys(t schema.Table, fks ...schema.ForeignKey) schema.Table {
+	t.ForeignKeys = fks
+	return t
+}
+
+func TestOrderingChanged_Array(t *testing.T) {
+	conv := MakeConv()
+	conv.SetSchemaMode()
+	conv.srcSchema["t"] = schema.Table{
+		Name:        "t",
+		ColNames:    []string{"k"},
+		ColDefs:     map[string]schema.Column{"k": schema.Column{Name: "k", Type: schema.Type{Name: "uuid", ArrayBounds: []int64{-1}}}},
+		Indexes:     []schema.Index{{Name: "idx_k", Keys: []schema.Key{{Column: "k"}}}},
+		PrimaryKeys: []schema.Key{{Column: "k"}},
+	}
+	assert.Nil(t, schemaToDDL(conv))
+	// No orderingChanged issues, but the index is dropped since Spanner
+	// can't index arrays.
+	expected := []groupIssue{{issue: indexUnsupported, cols: []string{"k"}, construct: "index idx_k", detail: "Spanner doesn't support indexes on array columns"}}
+	assert.Equal(t, expected, conv.groupIssues["t"])
+}
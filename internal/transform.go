@@ -0,0 +1,347 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// ColumnTransforms maps source columns, given as "table.column", to
+// transforms applied to their values during data conversion (e.g. to
+// anonymize personal data before it reaches Spanner). Column
+// transforms are read from a JSON or YAML config file e.g.
+//
+//	{
+//	  "users.email": "mask-email",
+//	  "users.ssn": "hash-sha256",
+//	  "users.phone": "null",
+//	  "users.name": "constant:anonymous",
+//	  "users.zip": "truncate:3"
+//	}
+//
+// Transforms are applied to converted values, just before rows are
+// written. NULL values are left as they are.
+type ColumnTransforms map[string]string
+
+// Column transforms (see ColumnTransforms).
+const (
+	transformHash     = "hash-sha256" // Hex-encoded SHA-256 of the value (the raw hash for BYTES).
+	transformNull     = "null"        // NULL.
+	transformConstant = "constant"    // The given value e.g. "constant:anonymous".
+	transformMask     = "mask-email"  // All but the first character of the local part replaced by '*' e.g. "j***@example.com".
+	transformTruncate = "truncate"    // The first n characters (bytes for BYTES) e.g. "truncate:3".
+)
+
+// columnTransform is a parsed transform, along with the Spanner column
+// it applies to (once validated against the schema).
+type columnTransform struct {
+	spec  string      // As given in the config e.g. "truncate:3".
+	kind  string      // One of the transform constants above.
+	arg   string      // Argument of constant and truncate.
+	n     int         // For truncate.
+	spCol string      // Spanner column the transform applies to.
+	value interface{} // For constant: the value, converted to the Spanner column's type.
+}
+
+// tableTransforms has the column transforms of a table, keyed by source
+// column.
+type tableTransforms map[string]columnTransform
+
+// ReadColumnTransforms reads a column transforms config file (see
+// ColumnTransforms). The file can be either JSON or YAML.
+func ReadColumnTransforms(r io.Reader) (ColumnTransforms, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("can't read column transforms: %w", err)
+	}
+	var ct ColumnTransforms
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		err = d.Decode(&ct)
+	} else {
+		err = yaml.UnmarshalStrict(b, &ct)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't parse column transforms: %w", err)
+	}
+	for _, col := range ct.sortedColumns() {
+		if t, c := splitTableColumn(col); t == "" || c == "" {
+			return nil, fmt.Errorf("bad column transform for %q: column must have the form table.column", col)
+		}
+		if _, err := parseTransform(ct[col]); err != nil {
+			return nil, fmt.Errorf("bad column transform for %s: %w", col, err)
+		}
+	}
+	return ct, nil
+}
+
+// sortedColumns returns the columns of ct in sorted order, so that
+// errors are deterministic.
+func (ct ColumnTransforms) sortedColumns() []string {
+	var cols []string
+	for c := range ct {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// splitTableColumn splits "table.column" into table and column. As for
+// type maps, we split at the last '.' since table names can contain
+// dots.
+func splitTableColumn(s string) (string, string) {
+	i := strings.LastIndex(s, ".")
+	if i < 0 {
+		return "", ""
+	}
+	return s[:i], s[i+1:]
+}
+
+// parseTransform parses a transform spec e.g. "truncate:3".
+func parseTransform(spec string) (columnTransform, error) {
+	t := columnTransform{spec: spec, kind: spec}
+	if i := strings.Index(spec, ":"); i >= 0 {
+		t.kind, t.arg = spec[:i], spec[i+1:]
+	}
+	switch t.kind {
+	case transformHash, transformNull, transformMask:
+		if t.kind != spec {
+			return t, fmt.Errorf("%s takes no argument, got %q", t.kind, spec)
+		}
+	case transformConstant:
+		if t.kind == spec {
+			return t, fmt.Errorf("constant needs a value e.g. \"constant:anonymous\"")
+		}
+	case transformTruncate:
+		n, err := strconv.Atoi(t.arg)
+		if err != nil || n < 0 {
+			return t, fmt.Errorf("truncate needs a non-negative length e.g. \"truncate:3\", got %q", spec)
+		}
+		t.n = n
+	default:
+		return t, fmt.Errorf("unknown transform %q (must be one of %s, %s, %s:<value>, %s or %s:<n>)", spec, transformHash, transformNull, transformConstant, transformMask, transformTruncate)
+	}
+	return t, nil
+}
+
+// SetColumnTransforms validates column transforms against the
+// converted schema, and configures conv to apply them during data
+// conversion. It must be called after schema conversion (and before
+// any data is written), so that a transform for a column that doesn't
+// exist, or that can't apply to the column's type, stops the
+// conversion before any data is written.
+func (conv *Conv) SetColumnTransforms(ct ColumnTransforms) error {
+	for _, col := range ct.sortedColumns() {
+		srcTable, srcCol := splitTableColumn(col)
+		t, err := parseTransform(ct[col])
+		if err == nil {
+			t, err = conv.resolveTransform(srcTable, srcCol, t)
+		}
+		if err != nil {
+			return fmt.Errorf("bad column transform for %s: %w", col, err)
+		}
+		if conv.transforms == nil {
+			conv.transforms = make(map[string]tableTransforms)
+		}
+		if _, ok := conv.transforms[srcTable]; !ok {
+			conv.transforms[srcTable] = make(tableTransforms)
+		}
+		conv.transforms[srcTable][srcCol] = t
+	}
+	return nil
+}
+
+// resolveTransform checks that transform t can be applied to column
+// srcCol of srcTable, and fills in its Spanner column (and value).
+func (conv *Conv) resolveTransform(srcTable, srcCol string, t columnTransform) (columnTransform, error) {
+	srcSchema, ok := conv.srcSchema[srcTable]
+	if !ok {
+		return t, fmt.Errorf("no such table: %s", srcTable)
+	}
+	srcCd, ok := srcSchema.ColDefs[srcCol]
+	if !ok {
+		return t, fmt.Errorf("no such column: %s", srcCol)
+	}
+	spTable, err1 := GetSpannerTable(conv, srcTable)
+	spCol, err2 := GetSpannerCol(conv, srcTable, srcCol, false)
+	spSchema, ok := conv.spSchema[spTable]
+	if err1 != nil || err2 != nil || !ok {
+		return t, fmt.Errorf("can't find Spanner column for %s.%s", srcTable, srcCol)
+	}
+	cd := spSchema.ColDefs[spCol]
+	t.spCol = spCol
+	_, isString := cd.T.(ddl.String)
+	_, isBytes := cd.T.(ddl.Bytes)
+	switch t.kind {
+	case transformNull:
+		if cd.NotNull {
+			return t, fmt.Errorf("can't set NOT NULL column %s to NULL", spCol)
+		}
+	case transformConstant:
+		if cd.IsArray {
+			return t, fmt.Errorf("constant isn't supported for array column %s", spCol)
+		}
+		srcType := srcCd.Type.Name
+		if conv.mysql {
+			srcType = mysqlDataType(srcType)
+		}
+		if conv.csv != nil {
+			srcType = csvDataType(srcType)
+		}
+		if conv.sqlserver {
+			srcType = sqlServerDataType(srcType)
+		}
+		v, err := convScalar(cd.T, srcType, conv.location, conv.naiveZone(), t.arg)
+		if err != nil {
+			return t, fmt.Errorf("bad constant %q: %w", t.arg, err)
+		}
+		if s, ok := cd.T.(ddl.String); ok {
+			if l, ok := s.Len.(ddl.Int64Length); ok && int64(utf8.RuneCountInString(t.arg)) > l.Value {
+				return t, fmt.Errorf("%q is too long for %s", t.arg, cd.PrintColumnDefType())
+			}
+		}
+		t.value = v
+	case transformHash, transformTruncate, transformMask:
+		if cd.IsArray || !(isString || (isBytes && t.kind != transformMask)) {
+			return t, fmt.Errorf("%s isn't supported for column %s of type %s", t.kind, spCol, cd.PrintColumnDefType())
+		}
+		if s, ok := cd.T.(ddl.String); ok && t.kind == transformHash {
+			if l, ok := s.Len.(ddl.Int64Length); ok && l.Value < 2*sha256.Size {
+				return t, fmt.Errorf("%s is too short for SHA-256 hashes (%d hex digits)", cd.PrintColumnDefType(), 2*sha256.Size)
+			}
+		}
+	}
+	return t, nil
+}
+
+// transformRow applies the column transforms of srcTable (if any) to a
+// row's Spanner columns and values, which are returned. Columns set to
+// NULL are dropped, as NULL values are.
+func (conv *Conv) transformRow(srcTable string, spCols []string, spVals []interface{}) ([]string, []interface{}) {
+	m := conv.transforms[srcTable]
+	if len(m) == 0 {
+		return spCols, spVals
+	}
+	byCol := make(map[string]columnTransform)
+	for _, t := range m {
+		byCol[t.spCol] = t
+	}
+	var cols []string
+	var vals []interface{}
+	for i, c := range spCols {
+		v := spVals[i]
+		if t, ok := byCol[c]; ok {
+			if v = t.apply(v); v == nil {
+				continue
+			}
+		}
+		cols = append(cols, c)
+		vals = append(vals, v)
+	}
+	return cols, vals
+}
+
+// apply returns the result of transform t on Spanner value v, or nil
+// for NULL.
+func (t columnTransform) apply(v interface{}) interface{} {
+	switch t.kind {
+	case transformNull:
+		return nil
+	case transformConstant:
+		return t.value
+	case transformHash:
+		switch x := v.(type) {
+		case string:
+			h := sha256.Sum256([]byte(x))
+			return hex.EncodeToString(h[:])
+		case []byte:
+			h := sha256.Sum256(x)
+			return h[:]
+		}
+	case transformMask:
+		if s, ok := v.(string); ok {
+			return maskEmail(s)
+		}
+	case transformTruncate:
+		switch x := v.(type) {
+		case string:
+			if utf8.RuneCountInString(x) > t.n {
+				return string([]rune(x)[:t.n])
+			}
+		case []byte:
+			if len(x) > t.n {
+				return x[:t.n]
+			}
+		}
+	}
+	return v
+}
+
+// maskEmail masks an email address, keeping only the first character
+// of its local part and its domain e.g. "jane@example.com" becomes
+// "j***@example.com". Values without '@' are masked in the same way.
+func maskEmail(s string) string {
+	local, domain := s, ""
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		local, domain = s[:i], s[i:]
+	}
+	r := []rune(local)
+	if len(r) <= 1 {
+		return s
+	}
+	return string(r[0]) + strings.Repeat("*", len(r)-1) + domain
+}
+
+// transformed returns whether column srcCol of srcTable has a column
+// transform.
+func (conv *Conv) transformed(srcTable, srcCol string) bool {
+	_, ok := conv.transforms[srcTable][srcCol]
+	return ok
+}
+
+// describeTransforms returns the source columns of srcTable that have
+// column transforms (in sorted order), with a description of them for
+// the report, or nil and "" if there are none.
+func (conv *Conv) describeTransforms(srcTable string) ([]string, string) {
+	m := conv.transforms[srcTable]
+	var cols []string
+	for c := range m {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	var l []string
+	for _, c := range cols {
+		l = append(l, fmt.Sprintf("'%s' (%s)", c, m[c].spec))
+	}
+	if len(l) == 0 {
+		return nil, ""
+	}
+	return cols, "Column values are transformed during data conversion: " + strings.Join(l, ", ")
+}
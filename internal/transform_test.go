@@ -0,0 +1,165 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudspannerecosystem/harbourbridge/schema"
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadColumnTransforms(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   string
+		want    ColumnTransforms
+		wantErr string
+	}{
+		{"json", `{"users.email": "mask-email", "users.zip": "truncate:3"}`, ColumnTransforms{"users.email": "mask-email", "users.zip": "truncate:3"}, ""},
+		{"yaml", "users.name: constant:anon\nusers.phone: \"null\"\n", ColumnTransforms{"users.name": "constant:anon", "users.phone": "null"}, ""},
+		{"no table", `{"email": "null"}`, nil, `bad column transform for "email": column must have the form table.column`},
+		{"unknown", `{"users.email": "encrypt"}`, nil, `bad column transform for users.email: unknown transform "encrypt" (must be one of hash-sha256, null, constant:<value>, mask-email or truncate:<n>)`},
+		{"bad length", `{"users.zip": "truncate:x"}`, nil, `bad column transform for users.zip: truncate needs a non-negative length e.g. "truncate:3", got "truncate:x"`},
+		{"argument", `{"users.ssn": "hash-sha256:salt"}`, nil, `bad column transform for users.ssn: hash-sha256 takes no argument, got "hash-sha256:salt"`},
+		{"no value", `{"users.name": "constant"}`, nil, `bad column transform for users.name: constant needs a value e.g. "constant:anonymous"`},
+	} {
+		ct, err := ReadColumnTransforms(strings.NewReader(tc.input))
+		if tc.wantErr != "" {
+			assert.EqualError(t, err, tc.wantErr, tc.name)
+			continue
+		}
+		assert.Nil(t, err, tc.name)
+		assert.Equal(t, tc.want, ct, tc.name)
+	}
+}
+
+func transformsConv() *Conv {
+	cols := []string{"id", "email", "ssn", "zip", "name", "phone", "n"}
+	return buildConv(
+		ddl.CreateTable{
+			Name:     "users",
+			ColNames: cols,
+			ColDefs: map[string]ddl.ColumnDef{
+				"id":    ddl.ColumnDef{Name: "id", T: ddl.Int64{}, NotNull: true},
+				"email": ddl.ColumnDef{Name: "email", T: ddl.String{Len: ddl.MaxLength{}}},
+				"ssn":   ddl.ColumnDef{Name: "ssn", T: ddl.String{Len: ddl.Int64Length{Value: 11}}},
+				"zip":   ddl.ColumnDef{Name: "zip", T: ddl.String{Len: ddl.MaxLength{}}},
+				"name":  ddl.ColumnDef{Name: "name", T: ddl.String{Len: ddl.Int64Length{Value: 8}}},
+				"phone": ddl.ColumnDef{Name: "phone", T: ddl.String{Len: ddl.MaxLength{}}},
+				"n":     ddl.ColumnDef{Name: "n", T: ddl.Int64{}},
+			},
+			Pks: []ddl.IndexKey{ddl.IndexKey{Col: "id"}}},
+		schema.Table{
+			Name:     "users",
+			ColNames: cols,
+			ColDefs: map[string]schema.Column{
+				"id":    schema.Column{Name: "id", Type: schema.Type{Name: "int8"}},
+				"email": schema.Column{Name: "email", Type: schema.Type{Name: "text"}},
+				"ssn":   schema.Column{Name: "ssn", Type: schema.Type{Name: "varchar(11)"}},
+				"zip":   schema.Column{Name: "zip", Type: schema.Type{Name: "text"}},
+				"name":  schema.Column{Name: "name", Type: schema.Type{Name: "varchar(8)"}},
+				"phone": schema.Column{Name: "phone", Type: schema.Type{Name: "text"}},
+				"n":     schema.Column{Name: "n", Type: schema.Type{Name: "int8"}},
+			}})
+}
+
+func TestSetColumnTransforms(t *testing.T) {
+	for _, tc := range []struct {
+		ct      ColumnTransforms
+		wantErr string
+	}{
+		{ColumnTransforms{"users.email": "mask-email", "users.n": "constant:0"}, ""},
+		{ColumnTransforms{"accounts.email": "null"}, "bad column transform for accounts.email: no such table: accounts"},
+		{ColumnTransforms{"users.mail": "null"}, "bad column transform for users.mail: no such column: mail"},
+		{ColumnTransforms{"users.id": "null"}, "bad column transform for users.id: can't set NOT NULL column id to NULL"},
+		{ColumnTransforms{"users.n": "hash-sha256"}, "bad column transform for users.n: hash-sha256 isn't supported for column n of type INT64"},
+		{ColumnTransforms{"users.ssn": "hash-sha256"}, "bad column transform for users.ssn: STRING(11) is too short for SHA-256 hashes (64 hex digits)"},
+		{ColumnTransforms{"users.n": "constant:zero"}, `bad column transform for users.n: bad constant "zero": can't convert to int64: strconv.ParseInt: parsing "zero": invalid syntax`},
+		{ColumnTransforms{"users.name": "constant:anonymous"}, `bad column transform for users.name: "anonymous" is too long for STRING(8)`},
+	} {
+		err := transformsConv().SetColumnTransforms(tc.ct)
+		if tc.wantErr == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.EqualError(t, err, tc.wantErr)
+		}
+	}
+}
+
+func TestColumnTransforms(t *testing.T) {
+	conv := transformsConv()
+	conv.SetDataMode()
+	var rows [][]interface{}
+	var rowCols [][]string
+	conv.SetDataSink(func(table string, cols []string, vals []interface{}) {
+		rowCols = append(rowCols, cols)
+		rows = append(rows, vals)
+	})
+	assert.Nil(t, conv.SetColumnTransforms(ColumnTransforms{
+		"users.email": "mask-email",
+		"users.zip":   "truncate:3",
+		"users.name":  "constant:anon",
+		"users.phone": "null",
+		"users.n":     "constant:0",
+	}))
+	conv.SetBadValueSamples(5)
+	cols := []string{"id", "email", "ssn", "zip", "name", "phone", "n"}
+	ProcessDataRow(conv, "users", cols, []string{"1", "jane@example.com", "123", "94043", "Jane", "555-1234", "7"})
+	ProcessDataRow(conv, "users", cols, []string{"2", nullMarker, nullMarker, "12", nullMarker, nullMarker, "8"})
+	ProcessDataRow(conv, "users", cols, []string{"3", "a@b.c", "123", "94043", "Jane", "555-1234", "not-a-number"})
+	assert.Equal(t, [][]string{
+		{"id", "email", "ssn", "zip", "name", "n"},
+		{"id", "zip", "n"},
+	}, rowCols)
+	assert.Equal(t, [][]interface{}{
+		{int64(1), "j***@example.com", "123", "940", "anon", int64(0)},
+		{int64(2), "12", int64(0)},
+	}, rows)
+	// No example values are given for transformed columns.
+	assert.Nil(t, badValueLines(conv, "users"))
+	tr := buildTableReport(conv, "users", nil)
+	var found bool
+	for _, b := range tr.body {
+		for _, l := range b.lines {
+			if l.issue == transformed {
+				found = true
+				assert.Equal(t, []string{"email", "n", "name", "phone", "zip"}, l.cols)
+				assert.True(t, strings.HasPrefix(l.text, "Column values are transformed during data conversion: 'email' (mask-email), 'n' (constant:0), 'name' (constant:anon), 'phone' (null), 'zip' (truncate:3). "))
+			}
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestTransformApply(t *testing.T) {
+	hash := columnTransform{kind: transformHash}
+	assert.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", hash.apply("foo"))
+	assert.Equal(t, 32, len(hash.apply([]byte("foo")).([]byte)))
+	trunc := columnTransform{kind: transformTruncate, n: 2}
+	assert.Equal(t, "日本", trunc.apply("日本語"))
+	assert.Equal(t, []byte("ab"), trunc.apply([]byte("abc")))
+	assert.Equal(t, "x", trunc.apply("x"))
+	for in, want := range map[string]string{
+		"jane@example.com": "j***@example.com",
+		"a@example.com":    "a@example.com",
+		"no-at-sign":       "n*********",
+		"":                 "",
+	} {
+		assert.Equal(t, want, maskEmail(in), in)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// TypeMap is a list of user overrides of HarbourBridge's default type
+// mappings. Type maps are read from a JSON or YAML config file e.g.
+//
+//	[
+//	  {"source_type": "uuid", "type": "BYTES", "length": 16},
+//	  {"column": "users.avatar", "type": "BYTES"},
+//	  {"column": "audit.*", "source_type": "numeric", "type": "STRING", "not_null": false}
+//	]
+//
+// When several overrides match a column, the most specific wins: an
+// override for table.column beats one for table.*, which beats one
+// that only gives a source type. Among equally specific overrides,
+// the first one listed wins.
+type TypeMap []TypeOverride
+
+// TypeOverride specifies the Spanner type for the source columns it
+// matches. An override must give a source type, a column, or both (in
+// which case a column must match both).
+type TypeOverride struct {
+	SourceType string `json:"source_type" yaml:"source_type"` // Source type name without modifiers e.g. "varchar" (case insensitive).
+	Column     string `json:"column" yaml:"column"`           // "table.column", or "table.*" for all columns of a table.
+	Type       string `json:"type" yaml:"type"`               // Spanner type e.g. "STRING" (case insensitive).
+	Length     int64  `json:"length" yaml:"length"`           // Length for STRING and BYTES. Zero means MAX.
+	NotNull    *bool  `json:"not_null" yaml:"not_null"`       // If set, overrides the source column's nullability.
+}
+
+// ReadTypeMap reads a type map config file (see TypeMap). The file
+// can be either JSON or YAML.
+func ReadTypeMap(r io.Reader) (TypeMap, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("can't read type map: %w", err)
+	}
+	var tm TypeMap
+	if t := bytes.TrimSpace(b); len(t) > 0 && (t[0] == '[' || t[0] == '{') {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		err = d.Decode(&tm)
+	} else {
+		err = yaml.UnmarshalStrict(b, &tm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't parse type map: %w", err)
+	}
+	for i, o := range tm {
+		if _, err := o.spannerType(); err != nil {
+			return nil, fmt.Errorf("bad type map entry %d: %w", i+1, err)
+		}
+		if o.SourceType == "" && o.Column == "" {
+			return nil, fmt.Errorf("bad type map entry %d: must specify source_type or column", i+1)
+		}
+		if o.Column != "" {
+			if t, c := o.splitColumn(); t == "" || c == "" {
+				return nil, fmt.Errorf("bad type map entry %d: column must have the form table.column or table.*, got %q", i+1, o.Column)
+			}
+		}
+	}
+	return tm, nil
+}
+
+// SetTypeMap configures the type overrides used during schema
+// conversion. It must be called before schema conversion.
+func (conv *Conv) SetTypeMap(tm TypeMap) {
+	conv.typeMap = tm
+}
+
+// spannerType returns the Spanner type specified by o.
+func (o TypeOverride) spannerType() (ddl.ScalarType, error) {
+	if o.Length < 0 {
+		return nil, fmt.Errorf("length must be non-negative, got %d", o.Length)
+	}
+	var l ddl.Length = ddl.MaxLength{}
+	if o.Length > 0 {
+		l = ddl.Int64Length{Value: o.Length}
+	}
+	var ty ddl.ScalarType
+	switch strings.ToUpper(o.Type) {
+	case "BOOL":
+		ty = ddl.Bool{}
+	case "BYTES":
+		return ddl.Bytes{Len: l}, nil
+	case "DATE":
+		ty = ddl.Date{}
+	case "FLOAT64":
+		ty = ddl.Float64{}
+	case "INT64":
+		ty = ddl.Int64{}
+	case "NUMERIC":
+		ty = ddl.Numeric{}
+	case "STRING":
+		if o.Length > MaxStringLength {
+			return nil, fmt.Errorf("STRING length can be at most %d, got %d", MaxStringLength, o.Length)
+		}
+		return ddl.String{Len: l}, nil
+	case "TIMESTAMP":
+		ty = ddl.Timestamp{}
+	default:
+		return nil, fmt.Errorf("unknown Spanner type %q", o.Type)
+	}
+	if o.Length > 0 {
+		return nil, fmt.Errorf("length can only be specified for STRING and BYTES")
+	}
+	return ty, nil
+}
+
+// splitColumn splits o.Column into table and column. We split at the
+// last '.' since table names can contain dots.
+func (o TypeOverride) splitColumn() (string, string) {
+	i := strings.LastIndex(o.Column, ".")
+	if i < 0 {
+		return "", ""
+	}
+	return o.Column[:i], o.Column[i+1:]
+}
+
+// describe returns a short description of the columns o matches, for
+// use in reports.
+func (o TypeOverride) describe() string {
+	switch {
+	case o.Column == "":
+		return fmt.Sprintf("source type %s", o.SourceType)
+	case o.SourceType == "":
+		return o.Column
+	default:
+		return fmt.Sprintf("%s with source type %s", o.Column, o.SourceType)
+	}
+}
+
+// match returns how specifically o matches column col (with source
+// type srcType) of table: 0 means no match, and higher values mean
+// more specific matches.
+func (o TypeOverride) match(table, col, srcType string) int {
+	n := 1
+	if o.Column != "" {
+		t, c := o.splitColumn()
+		switch {
+		case t != table:
+			return 0
+		case c == col:
+			n += 4
+		case c == "*":
+			n += 2
+		default:
+			return 0
+		}
+	}
+	if o.SourceType != "" && !strings.EqualFold(o.SourceType, srcType) {
+		return 0
+	}
+	return n
+}
+
+// typeOverride returns the type override (if any) for column col
+// (with source type srcType) of srcTable.
+func (conv *Conv) typeOverride(srcTable, col, srcType string) (TypeOverride, bool) {
+	best, score := TypeOverride{}, 0
+	for _, o := range conv.typeMap {
+		if n := o.match(srcTable, col, srcType); n > score {
+			best, score = o, n
+		}
+	}
+	return best, score > 0
+}
+
+// checkTypeMap reports type overrides that name tables or columns
+// that don't exist in the source schema. Such overrides are almost
+// certainly typos, so we flag them rather than silently ignoring them.
+func (conv *Conv) checkTypeMap() {
+	for _, o := range conv.typeMap {
+		if o.Column == "" {
+			continue
+		}
+		t, c := o.splitColumn()
+		srcTable, ok := conv.srcSchema[t]
+		if !ok {
+			conv.unexpected(fmt.Sprintf("Type override for %s refers to non-existent table %s", o.Column, t))
+			continue
+		}
+		if _, ok := srcTable.ColDefs[c]; !ok && c != "*" {
+			conv.unexpected(fmt.Sprintf("Type override for %s refers to non-existent column %s of table %s", o.Column, c, t))
+		}
+	}
+}
+
+// addTypeOverride records that override o was applied to column col
+// of srcTable.
+func (conv *Conv) addTypeOverride(srcTable, col string, o TypeOverride) {
+	if conv.typeOverrides == nil {
+		conv.typeOverrides = make(map[string]map[string]TypeOverride)
+	}
+	if conv.typeOverrides[srcTable] == nil {
+		conv.typeOverrides[srcTable] = make(map[string]TypeOverride)
+	}
+	conv.typeOverrides[srcTable][col] = o
+}
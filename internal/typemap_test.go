@@ -0,0 +1,107 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+func TestReadTypeMap(t *testing.T) {
+	f := false
+	expected := TypeMap{
+		{SourceType: "uuid", Type: "BYTES", Length: 16},
+		{Column: "users.*", SourceType: "numeric", Type: "STRING", NotNull: &f},
+	}
+	tm, err := ReadTypeMap(strings.NewReader(`[
+	{"source_type": "uuid", "type": "BYTES", "length": 16},
+	{"column": "users.*", "source_type": "numeric", "type": "STRING", "not_null": false}
+]`))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, tm)
+	tm, err = ReadTypeMap(strings.NewReader(`
+- source_type: uuid
+  type: BYTES
+  length: 16
+- column: users.*
+  source_type: numeric
+  type: STRING
+  not_null: false
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, tm)
+
+	for _, bad := range []string{
+		`[{"source_type": "uuid", "type": "BYTES", "len": 16}]`, // Unknown field.
+		"- source_type: uuid\n  type: BYTES\n  len: 16\n",       // Unknown field (YAML).
+		`[{"source_type": "uuid", "type": "UUID"}]`,             // Unknown type.
+		`[{"source_type": "uuid", "type": "INT64", "length": 8}]`,
+		`[{"source_type": "uuid", "type": "STRING", "length": -1}]`,
+		`[{"source_type": "text", "type": "STRING", "length": 2621441}]`, // Longer than Spanner allows.
+		`[{"type": "STRING"}]`,
+		`[{"column": "users", "type": "STRING"}]`,
+		`[{"column": "users.", "type": "STRING"}]`,
+		`[{"source_type": `,
+	} {
+		_, err := ReadTypeMap(strings.NewReader(bad))
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestTypeMap(t *testing.T) {
+	f, tr := false, true
+	conv := MakeConv()
+	conv.SetTypeMap(TypeMap{
+		{SourceType: "varchar", Type: "BYTES"},
+		{Column: "t.n", Type: "string", Length: 50, NotNull: &tr},
+		{Column: "t.*", SourceType: "numeric", Type: "FLOAT64", NotNull: &f},
+		{Column: "t.missing", Type: "STRING"},
+		{Column: "nosuchtable.*", Type: "STRING"},
+	})
+	conv, rows := runProcessPgDumpConv(conv,
+		"CREATE TABLE t (id bigint PRIMARY KEY, v varchar(10), n numeric, m numeric NOT NULL, a varchar[]);\n"+
+			"COPY public.t (id, v, n, m, a) FROM stdin;\n"+
+			"1	abc	1.5	2.5	{x,NULL}\n"+
+			"\\.\n")
+	ct := conv.spSchema["t"]
+	assert.Equal(t, ddl.ColumnDef{Name: "id", T: ddl.Int64{}, NotNull: true, Comment: "From: id int8"}, ct.ColDefs["id"])
+	assert.Equal(t, ddl.ColumnDef{Name: "v", T: ddl.Bytes{Len: ddl.MaxLength{}}, Comment: "From: v varchar(10)"}, ct.ColDefs["v"])
+	assert.Equal(t, ddl.ColumnDef{Name: "n", T: ddl.String{Len: ddl.Int64Length{Value: 50}}, NotNull: true, Comment: "From: n numeric"}, ct.ColDefs["n"])
+	assert.Equal(t, ddl.ColumnDef{Name: "m", T: ddl.Float64{}, NotNull: false, Comment: "From: m numeric"}, ct.ColDefs["m"])
+	assert.Equal(t, ddl.ColumnDef{Name: "a", T: ddl.Bytes{Len: ddl.MaxLength{}}, IsArray: true, Comment: "From: a varchar[]"}, ct.ColDefs["a"])
+	for _, c := range []string{"v", "n", "m", "a"} {
+		assert.Equal(t, []schemaIssue{typeOverride}, conv.issues["t"][c], c)
+	}
+	assert.Equal(t, "t.n", conv.typeOverrides["t"]["n"].Column)
+	assert.Equal(t, map[string]int64{
+		"Type override for t.missing refers to non-existent column missing of table t": 1,
+		"Type override for nosuchtable.* refers to non-existent table nosuchtable":     1,
+	}, unexpectedCounts(conv))
+	assert.Equal(t, []spannerData{
+		spannerData{table: "t", cols: []string{"id", "v", "n", "m", "a"}, vals: []interface{}{int64(1), []byte("abc"), "1.5", float64(2.5), [][]byte{[]byte("x"), nil}}},
+	}, rows)
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, buf.String(), "Column 'n': type numeric is mapped to string(50) via user override (t.n).")
+}
@@ -0,0 +1,234 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unexpected conditions are hard to debug from their descriptions
+// alone, so we also record where in the dump each was first seen: its
+// approximate byte offset, and (unless snippets are disabled e.g. for
+// confidential dumps) a shortened snippet of the statement or COPY-FROM
+// row being processed.
+//
+// Descriptions often include values from the dump (e.g. errors
+// converting data), so a pathological dump can generate millions of
+// distinct conditions. To bound memory use, values are replaced by
+// placeholders (see normalizeCondition), and we only store up to
+// maxConditions conditions and maxConditionBytes bytes of descriptions
+// and snippets. Further conditions are counted as suppressed, with an
+// approximate count of how many distinct conditions they are.
+
+const (
+	maxSnippet        = 100     // Maximum length of the snippet of dump text given with an unexpected condition.
+	maxConditionLen   = 500     // Maximum length of an unexpected condition's description.
+	maxConditions     = 10000   // Maximum number of distinct unexpected conditions stored.
+	maxConditionBytes = 2 << 20 // Maximum bytes of descriptions and snippets stored.
+)
+
+// conditionStat counts occurrences of an unexpected condition, with
+// context from its first occurrence.
+type conditionStat struct {
+	count   int64
+	offset  int64  // Approximate byte offset in the dump of the first occurrence (-1 if unknown).
+	snippet string // Dump text being processed at the first occurrence, shortened (empty if unknown or snippets are disabled).
+}
+
+// suppressedConditions counts unexpected conditions that weren't stored
+// because of the limits.
+type suppressedConditions struct {
+	count    int64       // Occurrences.
+	distinct hyperLogLog // Distinct descriptions.
+}
+
+// dumpContext is the part of a dump currently being processed.
+type dumpContext struct {
+	known  bool
+	offset int64  // Byte offset in the dump, starting at 0.
+	text   string // Statement or COPY-FROM row.
+}
+
+// EnableSnippets enables snippets of dump text for unexpected
+// conditions in the report. They are off by default since the text
+// may include data.
+func (conv *Conv) EnableSnippets() {
+	conv.snippets = true
+}
+
+// setDumpContext records that offset (starting at 0) and text of the
+// dump are being processed, for unexpected conditions.
+func (conv *Conv) setDumpContext(offset int64, text string) {
+	conv.dumpContext = dumpContext{known: true, offset: offset, text: text}
+}
+
+// clearDumpContext records that no dump text is being processed e.g.
+// while building the Spanner schema.
+func (conv *Conv) clearDumpContext() {
+	conv.dumpContext = dumpContext{}
+}
+
+// unexpected records stats about corner-cases and conditions
+// that were not expected. Note that the counts maybe not
+// be completely reliable due to potential double-counting
+// because we process pg_dump data twice. The first occurrence of each
+// condition is also logged as a warning (without its values, which may
+// be confidential).
+func (conv *Conv) unexpected(u string) {
+	VerbosePrintf("Unexpected condition: %s\n", u)
+	u = normalizeCondition(u)
+	if x, ok := conv.stats.unexpected[u]; ok {
+		x.count++
+		return
+	}
+	if len(conv.stats.unexpected) < maxConditions {
+		x := conv.newConditionStat()
+		if n := int64(len(u) + len(x.snippet)); conv.stats.unexpBytes+n <= maxConditionBytes {
+			x.count = 1
+			conv.stats.unexpected[u] = x
+			conv.stats.unexpBytes += n
+			Log().Warnf("Unexpected condition: %s", u)
+			return
+		}
+	}
+	if conv.stats.suppressed == nil {
+		Log().Warnf("Too many unexpected conditions: further conditions are only counted in the report")
+		conv.stats.suppressed = &suppressedConditions{}
+	}
+	conv.stats.suppressed.count++
+	conv.stats.suppressed.distinct.add(u)
+}
+
+// normalizeCondition replaces the values in unexpected condition u
+// with placeholders, so that conditions that differ only in their
+// values are counted together: quoted strings (as in errors from
+// strconv and time) become "...", and numbers become N. Numbers that
+// are part of names e.g. t1 and int64 are kept. u is also truncated to
+// maxConditionLen bytes.
+func normalizeCondition(u string) string {
+	var b strings.Builder
+	for i := 0; i < len(u); {
+		c := u[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for j < len(u) && u[j] != '"' {
+				if u[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(u) {
+				b.WriteString(u[i:]) // Not a quoted string.
+				i = len(u)
+				continue
+			}
+			b.WriteString(`"..."`)
+			i = j + 1
+			continue
+		case isDigit(c) && startsNumber(u, i):
+			j := i
+			for j < len(u) && (isDigit(u[j]) || u[j] == '.' && j+1 < len(u) && isDigit(u[j+1])) {
+				j++
+			}
+			if j == len(u) || !isIdentChar(u[j]) {
+				b.WriteByte('N')
+				i = j
+				continue
+			}
+			b.WriteString(u[i:j]) // e.g. 2nd.
+			i = j
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return shortenCondition(b.String())
+}
+
+// startsNumber returns whether the digit at u[i] starts a number,
+// rather than being part of a name (e.g. t1) or a dash-separated code
+// (e.g. ISO-8859-1).
+func startsNumber(u string, i int) bool {
+	switch {
+	case i == 0:
+		return true
+	case u[i-1] == '-':
+		return i == 1 || !isIdentChar(u[i-2])
+	default:
+		return !isIdentChar(u[i-1]) && u[i-1] != '.'
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// shortenCondition truncates u to maxConditionLen bytes.
+func shortenCondition(u string) string {
+	if len(u) <= maxConditionLen {
+		return u
+	}
+	// Don't split a multi-byte character.
+	n := maxConditionLen
+	for n > 0 && (u[n]&0xC0) == 0x80 {
+		n--
+	}
+	return u[:n] + "..."
+}
+
+// suppressedCondition returns a description of the unexpected
+// conditions that weren't stored because of the limits, and how many
+// times they occurred, or "" and 0 if there were none.
+func suppressedCondition(conv *Conv) (string, int64) {
+	x := conv.stats.suppressed
+	if x == nil {
+		return "", 0
+	}
+	return fmt.Sprintf("About %d additional distinct conditions suppressed (over the limit on conditions stored)", x.distinct.estimate()), x.count
+}
+
+// newConditionStat returns the stats for the first occurrence of an
+// unexpected condition.
+func (conv *Conv) newConditionStat() *conditionStat {
+	u := &conditionStat{offset: -1}
+	if c := conv.dumpContext; c.known {
+		u.offset = c.offset
+		if conv.snippets {
+			u.snippet = shortenText(strings.ToValidUTF8(c.text, "\uFFFD"), maxSnippet)
+		}
+	}
+	return u
+}
+
+// reportUnexpected returns the unexpected conditions for the JSON and
+// HTML reports, in the same order as in the text report.
+func reportUnexpected(conv *Conv) []ReportUnexpected {
+	var l []ReportUnexpected
+	for _, c := range sortedUnexpected(conv) {
+		u := conv.stats.unexpected[c]
+		r := ReportUnexpected{Condition: c, Count: u.count}
+		if conv.snippets && u.offset >= 0 {
+			offset := u.offset
+			r.Offset, r.Snippet = &offset, u.snippet
+		}
+		l = append(l, r)
+	}
+	if s, n := suppressedCondition(conv); s != "" {
+		l = append(l, ReportUnexpected{Condition: s, Count: n})
+	}
+	return l
+}
@@ -0,0 +1,205 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unexpectedCounts returns the counts of conv's unexpected conditions.
+func unexpectedCounts(conv *Conv) map[string]int64 {
+	m := make(map[string]int64)
+	for c, u := range conv.stats.unexpected {
+		m[c] = u.count
+	}
+	return m
+}
+
+func TestUnexpectedContext(t *testing.T) {
+	const missing = "ALTER TABLE alters column missing of table t, but it doesn't exist"
+	tests := []struct {
+		name      string
+		mysql     bool
+		dump      string
+		condition string
+		at        string // Text of the dump at the condition's offset.
+		snippet   string
+	}{
+		{
+			name: "statement",
+			dump: "CREATE TABLE t (id bigint PRIMARY KEY);\n" +
+				"-- A comment.\n" +
+				"ALTER TABLE t ALTER COLUMN missing SET NOT NULL;\n" +
+				"ALTER TABLE t ALTER COLUMN missing SET NOT NULL;\n",
+			condition: missing,
+			at:        "-- A comment.",
+			snippet:   "ALTER TABLE t ALTER COLUMN missing SET NOT NULL",
+		},
+		{
+			name:      "statement in a multi-statement line",
+			dump:      "CREATE TABLE t (id bigint PRIMARY KEY); ALTER TABLE t ALTER COLUMN missing SET NOT NULL;\n",
+			condition: missing,
+			at:        "ALTER",
+			snippet:   "ALTER TABLE t ALTER COLUMN missing SET NOT NULL",
+		},
+		{
+			name: "COPY-FROM block",
+			dump: "CREATE TABLE t (id bigint PRIMARY KEY, s text);\n" +
+				"COPY public.t (id, s) FROM stdin;\n" +
+				"1\tabc\n",
+			condition: "Reached eof while parsing copy-block",
+			at:        "COPY",
+			snippet:   "COPY public.t (id, s) FROM stdin",
+		},
+		{
+			name:  "mysqldump",
+			mysql: true,
+			dump: "CREATE TABLE t (id bigint PRIMARY KEY, s text);\n" +
+				"INSERT INTO t VALUES (1, 'a'); INSERT INTO t VALUES (99999999999999999999, '" + strings.Repeat("b", 100) + "');\n",
+			condition: `Error while converting data: can't convert to int64: strconv.ParseInt: parsing "...": value out of range` + "\n",
+			at:        " INSERT INTO t VALUES (9",
+			snippet:   "INSERT INTO t VALUES (99999999999999999999, '" + strings.Repeat("b", 55) + "...",
+		},
+	}
+	for _, tc := range tests {
+		for _, snippets := range []bool{false, true} {
+			conv := MakeConv()
+			if snippets {
+				conv.EnableSnippets()
+			}
+			if tc.mysql {
+				runProcessMySQLDumpConv(conv, tc.dump)
+			} else {
+				runProcessPgDumpConv(conv, tc.dump)
+			}
+			u, ok := conv.stats.unexpected[tc.condition]
+			if !assert.True(t, ok, "%s: conditions %v", tc.name, unexpectedCounts(conv)) {
+				continue
+			}
+			assert.Equal(t, int64(strings.Index(tc.dump, tc.at)), u.offset, tc.name)
+			if snippets {
+				assert.Equal(t, tc.snippet, u.snippet, tc.name)
+			} else {
+				assert.Equal(t, "", u.snippet, tc.name)
+			}
+		}
+	}
+}
+
+func TestWriteUnexpectedConditions(t *testing.T) {
+	conv := MakeConv()
+	conv.stats.unexpected = map[string]*conditionStat{
+		"Condition a": {count: 2, offset: 1234, snippet: "INSERT INTO t VALUES (1)"},
+		"Condition b": {count: 1, offset: -1},
+	}
+	report := func() string {
+		buf := new(bytes.Buffer)
+		w := bufio.NewWriter(buf)
+		writeUnexpectedConditions(PgDumpSource, BuildReport(PgDumpSource, conv, nil), w)
+		w.Flush()
+		return buf.String()
+	}
+	table := func(s string) string {
+		return s[strings.Index(s, "  ------"):]
+	}
+	assert.Equal(t, "  --------------------------------------\n"+
+		"   count  condition\n"+
+		"  --------------------------------------\n"+
+		"       2  Condition a\n"+
+		"       1  Condition b\n"+
+		"\n", table(report()))
+	conv.EnableSnippets()
+	assert.Equal(t, "  --------------------------------------\n"+
+		"   count  condition\n"+
+		"  --------------------------------------\n"+
+		"       2  Condition a\n"+
+		"          first at byte 1234: INSERT INTO t VALUES (1)\n"+
+		"       1  Condition b\n"+
+		"\n", table(report()))
+	offset := int64(1234)
+	assert.Equal(t, []ReportUnexpected{
+		{Condition: "Condition a", Count: 2, Offset: &offset, Snippet: "INSERT INTO t VALUES (1)"},
+		{Condition: "Condition b", Count: 1},
+	}, reportUnexpected(conv))
+}
+
+func TestNormalizeCondition(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"Reached eof while parsing copy-block", "Reached eof while parsing copy-block"},
+		{`can't convert to int64: strconv.ParseInt: parsing "12x": invalid syntax`, `can't convert to int64: strconv.ParseInt: parsing "...": invalid syntax`},
+		{`parsing time "x \"y\"" as "2006-01-02": cannot parse "x" as "2006"`, `parsing time "..." as "...": cannot parse "..." as "..."`},
+		{"can't convert to numeric: 1234.5 has more than 29 digits before the decimal point", "can't convert to numeric: N has more than N digits before the decimal point"},
+		{"Inconsistent row counts for table t1: 10 9 -1 0", "Inconsistent row counts for table t1: N N -N N"},
+		{"bad escape format: invalid escape at offset 17.", "bad escape format: invalid escape at offset N."},
+		{"Dump has unsupported client_encoding ISO-8859-1", "Dump has unsupported client_encoding ISO-8859-1"},
+		{"Table 2020_sales has column int64", "Table 2020_sales has column int64"},
+		{`Unterminated "quote 12`, `Unterminated "quote 12`},
+		{strings.Repeat("x", 600), strings.Repeat("x", maxConditionLen) + "..."},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.out, normalizeCondition(tc.in), tc.in)
+	}
+}
+
+func TestUnexpectedLimits(t *testing.T) {
+	log, restore := captureLog(LogWarn)
+	defer restore()
+	conv := MakeConv()
+	conv.EnableSnippets()
+	conv.setDumpContext(0, strings.Repeat("INSERT INTO t VALUES (1); ", 100))
+	// Conditions that differ only in their values are counted together.
+	for i := 0; i < 100000; i++ {
+		conv.unexpected(fmt.Sprintf(`Error while converting data: parsing "v%d": value %d out of range`, i, i))
+	}
+	assert.Equal(t, map[string]int64{`Error while converting data: parsing "...": value N out of range`: 100000}, unexpectedCounts(conv))
+	// Millions of distinct conditions use bounded memory.
+	const n = 2000000
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := 0; i < n; i++ {
+		conv.unexpected(fmt.Sprintf("Found unknown node Node_%x while processing table t", i))
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	assert.True(t, len(conv.stats.unexpected) <= maxConditions)
+	assert.True(t, conv.stats.unexpBytes <= maxConditionBytes)
+	assert.True(t, int64(after.HeapAlloc)-int64(before.HeapAlloc) < 4*maxConditionBytes, "heap grew by %d bytes", int64(after.HeapAlloc)-int64(before.HeapAlloc))
+	stored := int64(len(conv.stats.unexpected)) - 1
+	assert.Equal(t, n-stored, conv.stats.suppressed.count)
+	// The estimate of distinct suppressed conditions is within a few
+	// standard errors.
+	est := conv.stats.suppressed.distinct.estimate()
+	assert.InDelta(t, float64(n-stored), float64(est), 4*hllStdError*float64(n))
+	assert.Equal(t, est+stored+1, conv.Unexpecteds())
+	// Each stored condition is logged once, as is the first suppressed one.
+	assert.Equal(t, maxConditions+1, len(logLines(log)))
+
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	writeUnexpectedConditions(PgDumpSource, BuildReport(PgDumpSource, conv, nil), w)
+	w.Flush()
+	assert.Contains(t, buf.String(), fmt.Sprintf("  %6d  About %d additional distinct conditions suppressed (over the limit on conditions stored)\n", n-stored, est))
+}
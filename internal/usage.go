@@ -0,0 +1,110 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ResourceUsage records high-water marks for resources consumed
+// during a run. It is intended for capacity planning of migration
+// hosts, and is printed in the "Resource Usage" section of the report.
+type ResourceUsage struct {
+	PeakRSS        int64 // Peak resident set size of the process (bytes).
+	PeakHeap       int64 // Peak bytes of allocated Go heap objects.
+	PeakGoroutines int64 // Peak number of goroutines.
+	BytesRead      int64 // Bytes read from the input (e.g. pg_dump data).
+	TempFileBytes  int64 // Bytes written to temporary and auxiliary files (tmp copy of stdin, bad-row files, etc).
+}
+
+// UsageMonitor periodically samples process resource usage and keeps
+// track of high-water marks. Sampling is entirely passive: it only
+// reads runtime and process statistics. The interval should be a few
+// seconds, which keeps the overhead negligible.
+type UsageMonitor struct {
+	lock  sync.Mutex // Protects usage.
+	usage ResourceUsage
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// StartUsageMonitor creates a UsageMonitor and starts sampling
+// resource usage every 'interval'.
+func StartUsageMonitor(interval time.Duration) *UsageMonitor {
+	m := &UsageMonitor{done: make(chan struct{})}
+	m.sample()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				m.sample()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// Stop takes a final sample, stops the monitor and returns the
+// high-water marks recorded. Stop must be called at most once.
+func (m *UsageMonitor) Stop() ResourceUsage {
+	close(m.done)
+	m.wg.Wait()
+	m.sample()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.usage
+}
+
+func (m *UsageMonitor) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	goroutines := int64(runtime.NumGoroutine())
+	rss := peakRSS()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.usage.PeakHeap = max64(m.usage.PeakHeap, int64(ms.HeapAlloc))
+	m.usage.PeakGoroutines = max64(m.usage.PeakGoroutines, goroutines)
+	m.usage.PeakRSS = max64(m.usage.PeakRSS, rss)
+}
+
+// peakRSS returns the peak resident set size of the process in bytes,
+// or 0 if it isn't available.
+func peakRSS() int64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	// Maxrss is reported in bytes on macOS, but in kilobytes on Linux.
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss)
+	}
+	return int64(ru.Maxrss) * 1024
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
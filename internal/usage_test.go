@@ -0,0 +1,42 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageMonitor(t *testing.T) {
+	m := StartUsageMonitor(time.Millisecond)
+	// Allocate some memory and start a few goroutines so there is
+	// something to observe.
+	done := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go func() { <-done }()
+	}
+	b := make([]byte, 1<<20)
+	time.Sleep(10 * time.Millisecond)
+	close(done)
+	u := m.Stop()
+	assert.True(t, u.PeakGoroutines >= 10, "peak goroutines: %d", u.PeakGoroutines)
+	assert.True(t, u.PeakHeap >= int64(len(b)), "peak heap: %d", u.PeakHeap)
+	assert.True(t, u.PeakRSS > 0, "peak rss: %d", u.PeakRSS)
+	// Fields filled in by the caller are left untouched.
+	assert.Zero(t, u.BytesRead)
+	assert.Zero(t, u.TempFileBytes)
+}
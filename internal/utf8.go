@@ -0,0 +1,192 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	sp "cloud.google.com/go/spanner"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+
+	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
+)
+
+// Spanner STRING values must be valid UTF-8, but source text columns
+// often contain legacy data in other encodings (e.g. Latin-1). We check
+// values converted to STRING, and handle invalid ones as configured.
+
+// UTF8Action is what happens to STRING values that aren't valid UTF-8.
+type UTF8Action string
+
+// UTF-8 actions.
+const (
+	UTF8Reject    UTF8Action = "reject"    // The row is a bad row (the default).
+	UTF8Replace   UTF8Action = "replace"   // Invalid bytes are replaced by U+FFFD.
+	UTF8Transcode UTF8Action = "transcode" // The value is decoded from a source charset.
+)
+
+// UTF8Strategy determines what happens to STRING values that aren't
+// valid UTF-8.
+type UTF8Strategy struct {
+	Action  UTF8Action
+	Charset string // Source charset (an IANA name e.g. latin1), for UTF8Transcode.
+}
+
+func (s UTF8Strategy) String() string {
+	if s.Action == UTF8Transcode {
+		return "transcode-from=" + s.Charset
+	}
+	return string(s.Action)
+}
+
+// ParseUTF8Strategy parses s: reject, replace or transcode-from=<charset>
+// (empty for the default).
+func ParseUTF8Strategy(s string) (UTF8Strategy, error) {
+	switch a := UTF8Action(s); a {
+	case "":
+		return UTF8Strategy{Action: UTF8Reject}, nil
+	case UTF8Reject, UTF8Replace:
+		return UTF8Strategy{Action: a}, nil
+	}
+	if strings.HasPrefix(s, "transcode-from=") {
+		u := UTF8Strategy{Action: UTF8Transcode, Charset: strings.TrimPrefix(s, "transcode-from=")}
+		if _, err := charsetEncoding(u.Charset); err != nil {
+			return UTF8Strategy{}, err
+		}
+		return u, nil
+	}
+	return UTF8Strategy{}, fmt.Errorf("unknown UTF-8 strategy %q: expected reject, replace or transcode-from=<charset>", s)
+}
+
+// charsetEncoding returns the encoding of the charset with IANA name
+// (or alias) name.
+func charsetEncoding(name string) (encoding.Encoding, error) {
+	e, err := ianaindex.IANA.Encoding(name)
+	if err != nil || e == nil {
+		return nil, fmt.Errorf("unknown or unsupported charset %q: expected an IANA name e.g. latin1 or windows-1252", name)
+	}
+	return e, nil
+}
+
+// SetUTF8Strategy configures what happens to STRING values that aren't
+// valid UTF-8.
+func (conv *Conv) SetUTF8Strategy(s UTF8Strategy) error {
+	conv.utf8Strategy = s
+	conv.utf8Charset = nil
+	if s.Action == UTF8Transcode {
+		e, err := charsetEncoding(s.Charset)
+		if err != nil {
+			return err
+		}
+		conv.utf8Charset = e
+	}
+	return nil
+}
+
+func (conv *Conv) utf8() UTF8Strategy {
+	if conv.utf8Strategy.Action == "" {
+		return UTF8Strategy{Action: UTF8Reject}
+	}
+	return conv.utf8Strategy
+}
+
+// utf8Error is the error for STRING values that aren't valid UTF-8,
+// and that the UTF-8 strategy can't fix. Rows with such values are bad
+// rows with reason BadRowUTF8.
+type utf8Error struct {
+	col string
+	err error // Transcoding error, if any.
+}
+
+func (e *utf8Error) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("value of column %s is invalid UTF-8, and can't be transcoded: %v", e.col, e.err)
+	}
+	return fmt.Sprintf("value of column %s is invalid UTF-8", e.col)
+}
+
+// fitUTF8 applies the UTF-8 strategy to converted value x of a column
+// of Spanner type t (if it is STRING): a string or (for arrays) a slice
+// of sp.NullString. Invalid values are counted, whatever the strategy
+// does with them. Only invalid values are transcoded, so that columns
+// that mix UTF-8 and legacy data are handled.
+func (conv *Conv) fitUTF8(srcTable, srcCol string, t ddl.ScalarType, x interface{}) (interface{}, error) {
+	if _, ok := t.(ddl.String); !ok {
+		return x, nil
+	}
+	fit := func(v string) (string, error) {
+		if utf8.ValidString(v) {
+			return v, nil
+		}
+		conv.addInvalidUTF8(srcTable, srcCol)
+		switch conv.utf8().Action {
+		case UTF8Replace:
+			return strings.ToValidUTF8(v, string(utf8.RuneError)), nil
+		case UTF8Transcode:
+			s, err := conv.utf8Charset.NewDecoder().String(v)
+			if err != nil {
+				return v, &utf8Error{srcCol, err}
+			}
+			return s, nil
+		}
+		return v, &utf8Error{col: srcCol}
+	}
+	switch v := x.(type) {
+	case string:
+		return fit(v)
+	case []sp.NullString:
+		var err error
+		for i := range v {
+			if v[i].Valid {
+				if v[i].StringVal, err = fit(v[i].StringVal); err != nil {
+					return x, err
+				}
+			}
+		}
+	}
+	return x, nil
+}
+
+func (conv *Conv) addInvalidUTF8(srcTable, srcCol string) {
+	if conv.invalidUTF8[srcTable] == nil {
+		conv.invalidUTF8[srcTable] = make(map[string]int64)
+	}
+	conv.invalidUTF8[srcTable][srcCol]++
+}
+
+// describeInvalidUTF8 describes the values of srcCol of srcTable that
+// weren't valid UTF-8, and what the UTF-8 strategy did with them, for
+// reports.
+func (conv *Conv) describeInvalidUTF8(srcTable, srcCol string) string {
+	n := conv.invalidUTF8[srcTable][srcCol]
+	what, verb := "values", "were"
+	if n == 1 {
+		what, verb = "value", "was"
+	}
+	s := conv.utf8()
+	var action string
+	switch s.Action {
+	case UTF8Replace:
+		action = "had invalid bytes replaced by U+FFFD"
+	case UTF8Transcode:
+		action = verb + " transcoded from " + s.Charset
+	default:
+		action = verb + " rejected (their rows are bad rows)"
+	}
+	return fmt.Sprintf("Column '%s': %d %s %s not valid UTF-8, and %s (UTF-8 strategy %s)", srcCol, n, what, verb, action, s)
+}
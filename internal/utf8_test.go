@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUTF8Strategy(t *testing.T) {
+	tests := []struct {
+		s   string
+		u   UTF8Strategy
+		err bool
+	}{
+		{"", UTF8Strategy{Action: UTF8Reject}, false},
+		{"replace", UTF8Strategy{Action: UTF8Replace}, false},
+		{"transcode-from=latin1", UTF8Strategy{Action: UTF8Transcode, Charset: "latin1"}, false},
+		{"transcode-from=windows-1252", UTF8Strategy{Action: UTF8Transcode, Charset: "windows-1252"}, false},
+		{"transcode-from=klingon", UTF8Strategy{}, true},
+		{"transcode", UTF8Strategy{}, true},
+	}
+	for _, tc := range tests {
+		u, err := ParseUTF8Strategy(tc.s)
+		assert.Equal(t, tc.err, err != nil, tc.s)
+		assert.Equal(t, tc.u, u, tc.s)
+	}
+	assert.Equal(t, "transcode-from=latin1", UTF8Strategy{Action: UTF8Transcode, Charset: "latin1"}.String())
+}
+
+func TestProcessPgDump_InvalidUTF8(t *testing.T) {
+	// Row 2 has "café" in Latin-1, and row 3 has Windows-1252 quotes
+	// (0x93 and 0x94) in an array.
+	const dump = "CREATE TABLE t (id bigint PRIMARY KEY, s text, a text[], b bytea);\n" +
+		"COPY public.t (id, s, a, b) FROM stdin;\n" +
+		"1\tcafé\t{x}\t\\\\xff\n" +
+		"2\tcaf\xe9\t\\N\t\\N\n" +
+		"3\tok\t{\x93hi\x94}\t\\N\n" +
+		"\\.\n"
+	tests := []struct {
+		strategy string
+		rows     []spannerData
+		report   string
+	}{
+		{
+			strategy: "reject",
+			rows:     []spannerData{{table: "t", cols: []string{"id", "s", "a", "b"}, vals: []interface{}{int64(1), "café", []spanner.NullString{{StringVal: "x", Valid: true}}, []byte{0xff}}}},
+			report:   "Column 's': 1 value was not valid UTF-8, and was rejected (their rows are bad rows) (UTF-8 strategy reject)",
+		},
+		{
+			strategy: "replace",
+			rows: []spannerData{
+				{table: "t", cols: []string{"id", "s", "a", "b"}, vals: []interface{}{int64(1), "café", []spanner.NullString{{StringVal: "x", Valid: true}}, []byte{0xff}}},
+				{table: "t", cols: []string{"id", "s"}, vals: []interface{}{int64(2), "caf\uFFFD"}},
+				{table: "t", cols: []string{"id", "s", "a"}, vals: []interface{}{int64(3), "ok", []spanner.NullString{{StringVal: "\uFFFDhi\uFFFD", Valid: true}}}},
+			},
+			report: "Column 's': 1 value was not valid UTF-8, and had invalid bytes replaced by U+FFFD (UTF-8 strategy replace)",
+		},
+		{
+			strategy: "transcode-from=windows-1252",
+			rows: []spannerData{
+				{table: "t", cols: []string{"id", "s", "a", "b"}, vals: []interface{}{int64(1), "café", []spanner.NullString{{StringVal: "x", Valid: true}}, []byte{0xff}}},
+				{table: "t", cols: []string{"id", "s"}, vals: []interface{}{int64(2), "café"}},
+				{table: "t", cols: []string{"id", "s", "a"}, vals: []interface{}{int64(3), "ok", []spanner.NullString{{StringVal: "“hi”", Valid: true}}}},
+			},
+			report: "Column 'a': 1 value was not valid UTF-8, and was transcoded from windows-1252 (UTF-8 strategy transcode-from=windows-1252)",
+		},
+	}
+	for _, tc := range tests {
+		conv := MakeConv()
+		u, err := ParseUTF8Strategy(tc.strategy)
+		assert.Nil(t, err)
+		assert.Nil(t, conv.SetUTF8Strategy(u))
+		var buf bytes.Buffer
+		conv.SetBadRowWriter(NewBadRowWriter(&buf, "bad.jsonl", 1000))
+		conv, rows := runProcessPgDumpConv(conv, dump)
+		assert.Equal(t, tc.rows, rows, tc.strategy)
+		assert.Equal(t, map[string]map[string]int64{"t": {"s": 1, "a": 1}}, conv.invalidUTF8, tc.strategy)
+		assert.Equal(t, int64(3-len(tc.rows)), conv.BadRows(), tc.strategy)
+		if s := strings.TrimSpace(buf.String()); s != "" {
+			for _, l := range strings.Split(s, "\n") {
+				var r badRowRecord
+				assert.Nil(t, json.Unmarshal([]byte(l), &r))
+				assert.Equal(t, BadRowUTF8, r.Reason, tc.strategy)
+			}
+		}
+		b := new(bytes.Buffer)
+		w := bufio.NewWriter(b)
+		GenerateReport(PgDumpSource, conv, w, nil)
+		w.Flush()
+		assert.Contains(t, normalizeSpace(b.String()), tc.report, tc.strategy)
+	}
+}
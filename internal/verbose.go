@@ -18,35 +18,32 @@ import (
 	"fmt"
 )
 
-// TODO: Replace verbose with one of the existing go logging
-// libraries that provides support for different log-levels.
-// Our main goal is to support '-v' (verbose mode) where we log
-// lots of low-level details of conversion for debugging.
-// Likely candidate: go.uber.org/zap.
-
-var chatty = false
+// Verbose mode ('-v') logs lots of low-level details of conversion for
+// debugging: it is the same as the debug log level (see log.go).
 
 // Verbose returns true if verbose mode is enabled.
 func Verbose() bool {
-	return chatty
+	return Log().Enabled(LogDebug)
 }
 
-// VerboseInit determines whether verbose mode is enabled.
-// Generally there should be one call to VerboseInit at startup.
+// VerboseInit enables verbose mode if b is true, by setting the log
+// level to debug. Generally there should be one call to VerboseInit at
+// startup, after any call to SetLog.
 func VerboseInit(b bool) {
-	chatty = b
+	if b {
+		l := Log()
+		SetLog(NewLogger(l.w, LogDebug, l.format))
+	}
 }
 
-// VerbosePrintf prints to stdout if verbose is enabled.
+// VerbosePrintf logs at debug level.
 func VerbosePrintf(format string, a ...interface{}) {
-	if Verbose() {
-		fmt.Printf(format, a...)
-	}
+	Log().Debugf(format, a...)
 }
 
-// VerbosePrintln prints to stdout if verbose is enabled.
+// VerbosePrintln logs at debug level.
 func VerbosePrintln(a ...interface{}) {
 	if Verbose() {
-		fmt.Println(a...)
+		Log().Debugf("%s", fmt.Sprintln(a...))
 	}
 }
@@ -0,0 +1,169 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+)
+
+// Verification compares row counts after data conversion: the rows in
+// each source table, the rows the report counts as written to Spanner,
+// and the rows actually in the Spanner table. The counts themselves are
+// taken by the caller (see SetVerifyCounts), since they need access to
+// Spanner and the source database.
+
+// verifyCounts are the row counts taken by the verification pass.
+type verifyCounts struct {
+	source  map[string]int64 // Keyed by source table (nil for dumps, where the source is the dump itself).
+	spanner map[string]int64 // Keyed by Spanner table; -1 if the table couldn't be counted.
+}
+
+// SourceTables returns the names of the source tables, in sorted order.
+func (conv *Conv) SourceTables() []string {
+	return sortedSrcTables(conv)
+}
+
+// SpannerTables returns the names of the Spanner tables, in sorted order.
+func (conv *Conv) SpannerTables() []string {
+	var l []string
+	for t := range conv.spSchema {
+		l = append(l, t)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// SetVerifyCounts records the row counts taken after data conversion
+// for verification: source is keyed by source table (nil if the source
+// is a dump), and spanner is keyed by Spanner table, with -1 for tables
+// that couldn't be counted. Reports then include a verification section.
+func (conv *Conv) SetVerifyCounts(source, spanner map[string]int64) {
+	conv.verify = &verifyCounts{source: source, spanner: spanner}
+}
+
+// verifyRow is the verification result for a table.
+type verifyRow struct {
+	srcTable string
+	spTable  string
+	source   int64 // Rows in the source table (for dumps, rows in the dump); -1 if unknown.
+	report   int64 // Rows counted as written to Spanner.
+	spanner  int64 // Rows in the Spanner table; -1 if unknown.
+	mismatch bool
+}
+
+// verification returns the verification results for all tables, in
+// report order. Returns nil if there was no verification pass.
+func verification(conv *Conv, badWrites map[string]int64) []verifyRow {
+	if conv.verify == nil {
+		return nil
+	}
+	var tables []string
+	for t := range conv.srcSchema {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	var l []verifyRow
+	for _, srcTable := range tables {
+		spTable, err := GetSpannerTable(conv, srcTable)
+		if err != nil {
+			continue
+		}
+		v := verifyRow{srcTable: srcTable, spTable: spTable, source: conv.stats.rows[srcTable], spanner: -1}
+		if conv.verify.source != nil {
+			v.source = -1
+			if n, ok := conv.verify.source[srcTable]; ok {
+				v.source = n
+			}
+		}
+		v.report = conv.stats.goodRows[srcTable] - badWrites[srcTable]
+		if n, ok := conv.verify.spanner[spTable]; ok {
+			v.spanner = n
+		}
+		// The source count can legitimately differ from the report
+		// count (bad rows, row sampling), but not from the rows read.
+		v.mismatch = v.spanner != v.report || v.source != conv.stats.rows[srcTable]
+		l = append(l, v)
+	}
+	return l
+}
+
+// VerifyMismatches returns the number of tables whose row counts don't
+// match in the verification pass (zero if there was no verification).
+func VerifyMismatches(conv *Conv, badWrites map[string]int64) int {
+	return countMismatches(verification(conv, badWrites))
+}
+
+func countMismatches(l []verifyRow) int {
+	n := 0
+	for _, v := range l {
+		if v.mismatch {
+			n++
+		}
+	}
+	return n
+}
+
+// verifySummary returns a note on the verification pass for the report
+// summary, or "" if there was no verification pass.
+func verifySummary(l []verifyRow) string {
+	if len(l) == 0 {
+		return ""
+	}
+	if n := countMismatches(l); n > 0 {
+		return fmt.Sprintf("Verification: %d of %d tables have mismatched row counts (see the Verification section)", n, len(l))
+	}
+	return fmt.Sprintf("Verification: row counts match for all %d tables", len(l))
+}
+
+func writeVerification(l []ReportVerification, w *bufio.Writer) {
+	writeHeading(w, "Verification")
+	w.WriteString("Row counts after data conversion, broken down by table.\n")
+	w.WriteString("   source: rows in the source database (for dumps, rows in the dump).\n")
+	w.WriteString("   report: rows this report counts as written to Spanner.\n")
+	w.WriteString("  spanner: rows in the Spanner table.\n")
+	w.WriteString("  --------------------------------------\n")
+	fmt.Fprintf(w, "  %8s %8s %8s  %s\n", "source", "report", "spanner", "table")
+	w.WriteString("  --------------------------------------\n")
+	mismatches := 0
+	for _, v := range l {
+		fmt.Fprintf(w, "  %8s %8d %8s  %s", formatVerifyCount(v.SourceRows), v.ReportRows, formatVerifyCount(v.SpannerRows), v.SrcTable)
+		if v.Mismatch {
+			w.WriteString("  MISMATCH")
+			mismatches++
+		}
+		w.WriteString("\n")
+	}
+	if mismatches > 0 {
+		w.WriteString("\n")
+		justifyLines(w, verifyMismatchHelp, 80, 0)
+		w.WriteString("\n")
+	}
+	w.WriteString("\n")
+}
+
+// verifyMismatchHelp explains mismatches in the verification section.
+const verifyMismatchHelp = "A table's row counts are mismatched if the Spanner count differs from " +
+	"the report count, or the source count differs from the rows read during conversion " +
+	"(e.g. because the source changed during conversion). A ? is a count that failed."
+
+// formatVerifyCount formats a row count, with "?" for unknown (-1) counts.
+func formatVerifyCount(n int64) string {
+	if n < 0 {
+		return "?"
+	}
+	return fmt.Sprintf("%d", n)
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const verifyDump = "CREATE TABLE t (a bigint PRIMARY KEY, b int4);\n" +
+	"COPY public.t (a, b) FROM stdin;\n" +
+	"1\t1\n2\tx\n3\t3\n\\.\n" +
+	"CREATE TABLE u (a bigint PRIMARY KEY);\n" +
+	"COPY public.u (a) FROM stdin;\n" +
+	"1\n2\n\\.\n"
+
+func TestVerification(t *testing.T) {
+	conv, _ := runProcessPgDumpConv(MakeConv(), verifyDump)
+	assert.Nil(t, verification(conv, nil))
+	assert.Equal(t, []string{"t", "u"}, conv.SpannerTables())
+
+	// Table t has a bad row, and one of its rows failed to write.
+	badWrites := map[string]int64{"t": 1}
+	conv.SetVerifyCounts(nil, map[string]int64{"t": 1, "u": 2})
+	assert.Equal(t, []verifyRow{
+		{srcTable: "t", spTable: "t", source: 3, report: 1, spanner: 1},
+		{srcTable: "u", spTable: "u", source: 2, report: 2, spanner: 2},
+	}, verification(conv, badWrites))
+	assert.Equal(t, 0, VerifyMismatches(conv, badWrites))
+
+	// Spanner counts that differ or failed are mismatches, and so are
+	// source counts that differ from the rows read.
+	conv.SetVerifyCounts(nil, map[string]int64{"t": 2, "u": -1})
+	assert.Equal(t, 2, VerifyMismatches(conv, badWrites))
+	conv.SetVerifyCounts(map[string]int64{"t": 3, "u": 5}, map[string]int64{"t": 1, "u": 2})
+	assert.Equal(t, 1, VerifyMismatches(conv, badWrites))
+	conv.SetVerifyCounts(map[string]int64{"t": 3}, map[string]int64{"t": 1, "u": 2})
+	l := verification(conv, badWrites)
+	assert.Equal(t, int64(-1), l[1].source)
+	assert.True(t, l[1].mismatch)
+}
+
+func TestReport_Verification(t *testing.T) {
+	conv, _ := runProcessPgDumpConv(MakeConv(), verifyDump)
+	conv.SetVerifyCounts(nil, map[string]int64{"t": 2, "u": -1})
+	buf := new(bytes.Buffer)
+	w := bufio.NewWriter(buf)
+	summary := GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.Contains(t, summary, "Verification: 1 of 2 tables have mismatched row counts (see the Verification section).\n")
+	assert.Contains(t, buf.String(), ""+
+		"  --------------------------------------\n"+
+		"    source   report  spanner  table\n"+
+		"  --------------------------------------\n"+
+		"         3        2        2  t\n"+
+		"         2        2        ?  u  MISMATCH\n")
+
+	buf.Reset()
+	assert.Nil(t, GenerateHTMLReport(PgDumpSource, conv, buf, nil, ""))
+	assert.Contains(t, buf.String(), `<tr><td>u</td><td class="num">2</td><td class="num">2</td><td class="num">?</td><td><span class="poor">MISMATCH</span></td></tr>`)
+
+	buf.Reset()
+	assert.Nil(t, GenerateJSONReport(PgDumpSource, conv, buf, nil))
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, []ReportVerification{{"t", 3, 2, 2, false}, {"u", 2, 2, -1, true}}, r.Verification)
+
+	conv.SetVerifyCounts(nil, map[string]int64{"t": 2, "u": 2})
+	assert.Contains(t, generateSummary(conv, analyzeTables(conv, nil), nil), "Verification: row counts match for all 2 tables.\n")
+}
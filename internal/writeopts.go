@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WritePriority is the request priority of writes to Spanner. Lower
+// priorities let a bulk load run on an instance that serves other
+// traffic without starving it.
+type WritePriority string
+
+// Write priorities. The empty priority means Spanner's default (HIGH).
+const (
+	WritePriorityLow    WritePriority = "LOW"
+	WritePriorityMedium WritePriority = "MEDIUM"
+	WritePriorityHigh   WritePriority = "HIGH"
+)
+
+// maxWriteTagLen is the maximum length of Spanner transaction tags.
+const maxWriteTagLen = 50
+
+// ParseWritePriority returns the write priority named s (case
+// insensitive). The empty string means Spanner's default.
+func ParseWritePriority(s string) (WritePriority, error) {
+	switch x := WritePriority(strings.ToUpper(s)); x {
+	case "", WritePriorityLow, WritePriorityMedium, WritePriorityHigh:
+		return x, nil
+	}
+	return "", fmt.Errorf("unknown write priority %q: expected LOW, MEDIUM or HIGH", s)
+}
+
+// CheckWriteTag returns an error if tag can't be used as the
+// transaction tag of writes to Spanner: tags are at most 50 printable
+// ASCII characters.
+func CheckWriteTag(tag string) error {
+	if len(tag) > maxWriteTagLen {
+		return fmt.Errorf("write tag %q is too long: at most %d characters are allowed", tag, maxWriteTagLen)
+	}
+	for _, c := range tag {
+		if c < ' ' || c > '~' {
+			return fmt.Errorf("write tag %q must only have printable ASCII characters", tag)
+		}
+	}
+	return nil
+}
+
+// SetWriteOptions records the priority and transaction tag of the
+// writes to Spanner (empty if not set), so that reports can give them
+// e.g. to correlate the load with Spanner's metrics.
+func (conv *Conv) SetWriteOptions(p WritePriority, tag string) {
+	conv.writePriority = p
+	conv.writeTag = tag
+}
+
+// writeOptionsMsg describes the priority and tag of writes to Spanner,
+// or returns "" if neither was set.
+func writeOptionsMsg(priority, tag string) string {
+	var l []string
+	if priority != "" {
+		l = append(l, "priority "+priority)
+	}
+	if tag != "" {
+		l = append(l, fmt.Sprintf("transaction tag %q", tag))
+	}
+	if len(l) == 0 {
+		return ""
+	}
+	return "Writes to Spanner: " + strings.Join(l, ", ")
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWritePriority(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    WritePriority
+		wantErr bool
+	}{
+		{"", "", false},
+		{"low", WritePriorityLow, false},
+		{"Medium", WritePriorityMedium, false},
+		{"HIGH", WritePriorityHigh, false},
+		{"urgent", "", true},
+	} {
+		p, err := ParseWritePriority(tc.in)
+		assert.Equal(t, tc.wantErr, err != nil, tc.in)
+		assert.Equal(t, tc.want, p, tc.in)
+	}
+}
+
+func TestCheckWriteTag(t *testing.T) {
+	assert.Nil(t, CheckWriteTag(""))
+	assert.Nil(t, CheckWriteTag("harbourbridge-import"))
+	assert.NotNil(t, CheckWriteTag(strings.Repeat("x", 51)))
+	assert.NotNil(t, CheckWriteTag("tag\n"))
+	assert.NotNil(t, CheckWriteTag("étiquette"))
+}
+
+func TestWriteOptionsReport(t *testing.T) {
+	assert.Equal(t, "", writeOptionsMsg("", ""))
+	assert.Equal(t, "Writes to Spanner: priority LOW", writeOptionsMsg(string(WritePriorityLow), ""))
+	assert.Equal(t, `Writes to Spanner: priority LOW, transaction tag "harbourbridge-import"`, writeOptionsMsg(string(WritePriorityLow), "harbourbridge-import"))
+	conv := MakeConv()
+	conv.SetWriteOptions(WritePriorityLow, "harbourbridge-import")
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	GenerateReport(PgDumpSource, conv, w, nil)
+	w.Flush()
+	assert.True(t, strings.HasPrefix(buf.String(), `Writes to Spanner: priority LOW, transaction tag "harbourbridge-import".`+"\n\n"))
+	r := BuildReport(PgDumpSource, conv, nil)
+	assert.Equal(t, "LOW", r.WritePriority)
+	assert.Equal(t, "harbourbridge-import", r.WriteTag)
+}
@@ -20,76 +20,274 @@ package main
 import (
 	"bufio"
 	"context"
-	"crypto/rand"
-	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
-	"sync/atomic"
 	"syscall"
 	"time"
 
-	sp "cloud.google.com/go/spanner"
-	database "cloud.google.com/go/spanner/admin/database/apiv1"
 	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
 	_ "github.com/lib/pq"
 	"golang.org/x/crypto/ssh/terminal"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
-	adminpb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
 	instancepb "google.golang.org/genproto/googleapis/spanner/admin/instance/v1"
-	"google.golang.org/grpc"
 
+	"github.com/cloudspannerecosystem/harbourbridge/conversion"
 	"github.com/cloudspannerecosystem/harbourbridge/internal"
-	"github.com/cloudspannerecosystem/harbourbridge/spanner"
-	"github.com/cloudspannerecosystem/harbourbridge/spanner/ddl"
 )
 
 const (
 	// PGDUMP is the driver name for pg_dump.
-	PGDUMP string = "pgdump"
+	PGDUMP string = conversion.PGDUMP
+	// MYSQLDUMP is the driver name for mysqldump.
+	MYSQLDUMP string = conversion.MYSQLDUMP
 	// POSTGRES is the driver name for PostgreSQL.
-	POSTGRES string = "postgres"
+	POSTGRES string = conversion.POSTGRES
+	// DYNAMODB is the driver name for DynamoDB exports.
+	DYNAMODB string = conversion.DYNAMODB
+	// CSV is the driver name for CSV files.
+	CSV string = conversion.CSV
+	// SQLSERVER is the driver name for SQL Server.
+	SQLSERVER string = conversion.SQLSERVER
 )
 
 var (
-	badDataFile      = "dropped.txt"
-	schemaFile       = "schema.txt"
-	reportFile       = "report.txt"
 	dbNameOverride   string
+	dbNamePrefix     = ""
+	dbNameCollision  = ""
+	projectOverride  = ""
+	credentialsFile  = ""
+	impersonate      = ""
+	assumeYes        bool
 	instanceOverride string
+	createInstance   bool
+	instanceConfig   = ""
+	instanceNodes    int
+	processingUnits  int
 	filePrefix       = ""
+	outDir           = ""
 	driverName       = ""
+	inputFiles       inputList
 	verbose          bool
-	fromPgDump       bool
+	logLevel         = ""
+	logFormat        = ""
+	metricsAddr      = ""
+	columnStats      bool
+	showMappings     bool
+	piiKeyCheck      bool
+	tableOptionsFile = ""
+	typeMapFile      = ""
+	transformsFile   = ""
+	readSessionFile  = ""
+	writeSessionFile = ""
+	schemaOnly       bool
+	dialect          = ""
+	validateDDL      = ""
+	dryRun           bool
+	dataOnly         bool
+	existingDB       bool
+	batchBytes       int64
+	commitAttempts   int64
+	commitBudget     time.Duration
+	writePriority    = ""
+	writeTag         = ""
+	badRowsFile      = ""
+	badRowsLimit     int64
+	badValueSamples  int64
+	noDataSamples    bool
+	noSnippets       bool
+	checkpointFile   = ""
+	resume           bool
+	assessFile       = ""
+	reportFormat     = "text"
+	reportOrder      = ""
+	reportLevel      = ""
+	ratingThresholds = ""
+	minRating        = ""
+	syntheticPK      = ""
+	inheritance      = ""
+	defaultSchema    = ""
+	sourceSchemas    = ""
+	dynamoTable      = ""
+	dynamoKey        = ""
+	dynamoSample     int64
+	csvSchemaFile    = ""
+	stringLength     int64
+	stringOverflow   = ""
+	timeZone         = ""
+	floatPolicy      = ""
+	duplicateCopy    = ""
+	invalidUTF8      = ""
+	commitTSColumn   = ""
+	commitTSFill     = ""
+	suppressIssues   = ""
+	issueSeverities  = ""
+	rowLimit         int64
+	samplePercent    float64
+	verify           bool
+	deferIndexes     bool
+	indexBatch       int64
+	readWorkers      int64
+	fetchSize        int64
+	ddlBatch         int64
+	ddlBatchBytes    int64
+	ddlResumeFrom    int
+	target           = ""
+	endpoint         = ""
 )
 
+// Project and instance used with the Spanner emulator, unless
+// overridden by $GCLOUD_PROJECT and -instance. The emulator accepts any
+// names, and the instance is created if it doesn't exist.
+const (
+	emulatorProject  = "emulator-project"
+	emulatorInstance = "emulator-instance"
+)
+
+// exitBelowMinRating is the exit code used when the conversion
+// completes, but its schema or data rating is below -min-rating (or
+// -verify found mismatched row counts).
+const exitBelowMinRating = 3
+
+// exitSchemaInvalid is the exit code used when Spanner rejects
+// statements of the schema validated with -validate-ddl.
+const exitSchemaInvalid = 4
+
+// exitCancelled is the exit code used when the user doesn't confirm
+// the target of the conversion (see -yes).
+const exitCancelled = 1
+
+// exitInterrupted is the exit code used when the conversion is
+// interrupted by SIGINT or SIGTERM (128 + SIGINT, as for shells).
+const exitInterrupted = 130
+
 func init() {
-	flag.StringVar(&dbNameOverride, "dbname", "", "dbname: name to use for Spanner DB")
+	flag.StringVar(&dbNameOverride, "dbname", "", "dbname: name to use for Spanner DB (if not set, a name is generated from the dump file or source database name and a timestamp)")
+	flag.StringVar(&dbNamePrefix, "dbname-prefix", "", "dbname-prefix: prefix of the generated Spanner DB name, instead of one derived from the dump file or source database name (can't be combined with -dbname)")
+	flag.StringVar(&dbNameCollision, "dbname-collision", string(conversion.DBNameCollisionError), "dbname-collision: what to do if the Spanner DB to be created already exists, which is checked before anything else: error, or suffix (use the first of <name>-1, <name>-2, ... that doesn't exist)")
+	flag.StringVar(&projectOverride, "project", "", "project: cloud project of the Spanner instance (if not set, $GCLOUD_PROJECT or gcloud's default project)")
+	flag.StringVar(&credentialsFile, "credentials-file", "", "credentials-file: credentials JSON file (e.g. a service account key file) to access Spanner with, instead of Application Default Credentials")
+	flag.StringVar(&impersonate, "impersonate-service-account", "", "impersonate-service-account: email address of a service account to impersonate when accessing Spanner (needs the Service Account Token Creator role on it)")
+	flag.BoolVar(&assumeYes, "yes", false, "yes: don't ask for confirmation of the project, instance, database and principal before accessing Cloud Spanner, e.g. for scripts")
 	flag.StringVar(&instanceOverride, "instance", "", "instance: Spanner instance to use")
+	flag.BoolVar(&createInstance, "create-instance", false, "create-instance: if the Spanner instance named by -instance doesn't exist, create it (with -instance-config, and -nodes or -processing-units) before anything else, and delete it if the conversion fails")
+	flag.StringVar(&instanceConfig, "instance-config", "", "instance-config: instance config of the Spanner instance created by -create-instance e.g. regional-us-central1 (see 'gcloud spanner instance-configs list')")
+	flag.IntVar(&instanceNodes, "nodes", 0, "nodes: compute capacity in nodes of the Spanner instance created by -create-instance (default 1 node, unless -processing-units is set)")
+	flag.IntVar(&processingUnits, "processing-units", 0, "processing-units: compute capacity in processing units (1000 per node) of the Spanner instance created by -create-instance, instead of -nodes")
 	flag.StringVar(&filePrefix, "prefix", "", "prefix: file prefix for generated files")
-	flag.StringVar(&driverName, "driver", "", "driver name: experimental flag for accessing source DB via database/sql driver (only accepted value is \"postgres\")")
-	flag.BoolVar(&verbose, "v", false, "verbose: print additional output")
+	flag.StringVar(&outDir, "out-dir", "", "out-dir: directory to write generated files to, with per-table files for large schemas: schema/<table>.ddl for each Spanner table, and report/<table>.txt for each source table's conversion details (which report.txt then leaves out)")
+	flag.StringVar(&driverName, "driver", "", "driver name: experimental flag for accessing source DB via database/sql driver (accepted values are \"postgres\", \"sqlserver\" for reading a SQL Server database, \"mysqldump\" for reading mysqldump data from stdin, \"dynamodb\" for reading the data files of a DynamoDB export from stdin, and \"csv\" for reading the CSV files listed in -csv-schema). Without -driver, dump input is recognized as pg_dump or mysqldump output from its start")
+	flag.Var(&inputFiles, "input", "input: dump file to read instead of stdin: a file name or a Google Cloud Storage URL (gs://bucket/object). Repeat -input, or give a quoted file name pattern such as 'dumps/*.sql', to convert several pg_dump or mysqldump files into one database")
+	flag.BoolVar(&verbose, "v", false, "verbose: print additional output (same as -log-level=debug)")
+	flag.StringVar(&logLevel, "log-level", "warn", "log-level: level of diagnostics logged to stderr: error, warn, info (e.g. each table finished) or debug (e.g. each write to Spanner)")
+	flag.StringVar(&logFormat, "log-format", "text", "log-format: format of diagnostics logged to stderr: text or json (one object per line, which also includes status messages at info level)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "metrics-addr: address (e.g. :9090) to serve metrics about the conversion at /metrics, in the Prometheus text format")
+	flag.BoolVar(&columnStats, "column-stats", false, "column-stats: collect per-column NULL fraction and approximate distinct counts during data conversion")
+	flag.BoolVar(&showMappings, "show-mappings", false, "show-mappings: list each table's source columns and types with the Spanner columns and types they map to in the report")
+	flag.StringVar(&tableOptionsFile, "table-options", "", "table-options: JSON file of Spanner table options (e.g. row deletion policies) keyed by source table name")
+	flag.StringVar(&typeMapFile, "type-map", "", "type-map: JSON or YAML file of overrides of the default type mappings, matched by source type, table.column or table.*")
+	flag.StringVar(&transformsFile, "transforms", "", "transforms: JSON or YAML file mapping table.column to a transform applied to its values during data conversion (hash-sha256, null, constant:<value>, mask-email or truncate:<n>), e.g. to anonymize personal data")
+	flag.StringVar(&writeSessionFile, "write-session", "", "write-session: JSON file to write the session (source and Spanner schemas and the mapping between them) to after schema conversion")
+	flag.StringVar(&readSessionFile, "read-session", "", "read-session: JSON session file (see -write-session), possibly hand-edited, to use for the Spanner schema and mapping instead of those from schema conversion")
+	flag.BoolVar(&schemaOnly, "schema-only", false, "schema-only: convert schema and write the schema file and report, but don't create a Spanner database or convert data")
+	flag.StringVar(&dialect, "dialect", string(conversion.DialectGoogleStandardSQL), "dialect: dialect of the Spanner database to convert the schema for: google_standard_sql, or postgresql (schema-only conversions only: the schema file and report use PostgreSQL-dialect DDL and type names)")
+	flag.StringVar(&validateDDL, "validate-ddl", "", fmt.Sprintf("validate-ddl: before creating the Spanner database, validate the schema by applying it to a throwaway database: instance (a temporary database in the target instance, dropped afterwards) or emulator:<host:port> (the Spanner emulator at host:port); if Spanner rejects any statements, the report lists them, no database is created, and the exit code is %d", exitSchemaInvalid))
+	flag.BoolVar(&dryRun, "dry-run", false, "dry-run: convert schema and data (and size mutations) as usual, and write the schema file and report, but don't create a Spanner database or write any data")
+	flag.BoolVar(&dataOnly, "data-only", false, "data-only: convert data into the existing Spanner database named by -dbname, using its schema (or the -read-session file) instead of creating a database")
+	flag.BoolVar(&existingDB, "existing-db", false, "existing-db: convert data into the existing Spanner database named by -dbname (e.g. one created with Terraform) instead of creating a database; tables that don't match the generated schema (columns, types or primary key) are skipped, and listed in the report")
+	flag.Int64Var(&batchBytes, "batch-bytes", conversion.DefaultBatchBytes, "batch-bytes: limit on the (estimated) size in bytes of each batch of rows written to Spanner")
+	flag.Int64Var(&commitAttempts, "commit-attempts", conversion.DefaultCommitAttempts, "commit-attempts: max attempts for each write to Spanner that fails with a transient error (ABORTED, UNAVAILABLE or DEADLINE_EXCEEDED)")
+	flag.DurationVar(&commitBudget, "commit-retry-budget", conversion.DefaultCommitBudget, "commit-retry-budget: max time spent backing off between retries of each write to Spanner")
+	flag.StringVar(&writePriority, "write-priority", "", "write-priority: request priority of writes to Spanner: LOW, MEDIUM or HIGH (Spanner's default), e.g. LOW so that the load doesn't starve other traffic on the instance")
+	flag.StringVar(&writeTag, "write-tag", "", "write-tag: transaction tag of writes to Spanner (e.g. harbourbridge-import), to pick out the load in Spanner's metrics; at most 50 printable ASCII characters")
+	flag.StringVar(&badRowsFile, "bad-rows-file", "", "bad-rows-file: file to write every bad row (rows that fail conversion, and rows that can't be written to Spanner) to, as JSON lines")
+	flag.Int64Var(&badRowsLimit, "bad-rows-limit", conversion.DefaultBadRowsLimit, "bad-rows-limit: limit on the size in bytes of the -bad-rows-file file")
+	flag.Int64Var(&badValueSamples, "bad-value-samples", conversion.DefaultBadValueSamples, "bad-value-samples: number of example values the report gives for each cause of bad rows of a column")
+	flag.BoolVar(&noDataSamples, "no-data-samples", false, "no-data-samples: don't give example values of bad rows in the report, e.g. for sensitive data")
+	flag.BoolVar(&noSnippets, "no-snippets", false, "no-snippets: don't give snippets of the dump text where unexpected conditions were first seen in the report, e.g. for confidential dumps")
+	flag.StringVar(&checkpointFile, "checkpoint", "", "checkpoint: file to record the progress of data conversion in, so that it can be resumed (see -resume)")
+	flag.BoolVar(&resume, "resume", false, "resume: resume an interrupted data-only conversion from its -checkpoint file, skipping rows already written to Spanner")
+	flag.StringVar(&assessFile, "assess", "", "assess: file listing source databases (connection strings or pg_dump files, which can be gs:// URLs, one per line) for an aggregate schema-only assessment")
+	flag.StringVar(&reportFormat, "report-format", "text", "report-format: format of the report: text, html, or both")
+	flag.StringVar(&reportLevel, "report-level", string(conversion.ReportLevelFull), "report-level: detail of the text report: full (a section for every table), or terse (one line for each table with no issues and no bad rows)")
+	flag.StringVar(&reportOrder, "report-order", string(conversion.ReportOrderAlpha), "report-order: order of tables in the report: alpha (alphabetical), rows (most rows first), or problems (worst converted first, by schema warnings weighted by row count and percentage of bad rows)")
+	flag.StringVar(&ratingThresholds, "rating-thresholds", "", "rating-thresholds: percentages of columns with warnings (for schema ratings) or bad rows (for data ratings) below which a conversion is rated good or ok e.g. good=2,ok=10 (defaults: good=5,ok=33.3)")
+	flag.StringVar(&minRating, "min-rating", "", fmt.Sprintf("min-rating: exit with code %d if the overall schema or data conversion rating is below this rating: excellent, good, ok, or poor", exitBelowMinRating))
+	flag.StringVar(&syntheticPK, "synthetic-pk-strategy", string(conversion.SyntheticPKBitReversed), "synthetic-pk-strategy: how to fill the primary key column added to tables that don't have one: bitreversed (a bit-reversed INT64 sequence), sequential (an INT64 sequence, which makes writes hotspot), or uuid (STRING(36) random UUIDs)")
+	flag.StringVar(&inheritance, "inheritance", string(conversion.InheritanceSeparate), "inheritance: how to convert PostgreSQL tables that inherit from other tables (INHERITS): separate (each is a separate Spanner table, with the inherited columns) or merge (rows are written to the table they inherit from, with a column giving the source table)")
+	flag.StringVar(&defaultSchema, "default-schema", internal.DefaultSchema, "default-schema: PostgreSQL schema whose tables keep their names in Spanner; tables of other schemas are prefixed with their schema name e.g. audit.users becomes audit_users")
+	flag.StringVar(&dynamoTable, "dynamodb-table", "", "dynamodb-table: name of the table exported, which -driver dynamodb needs since DynamoDB exports don't record it")
+	flag.StringVar(&dynamoKey, "dynamodb-key", "", "dynamodb-key: partition key attribute of the table exported, followed by its sort key attribute (if any) e.g. userId,createdAt, for the primary key of the Spanner table (default a synthetic primary key)")
+	flag.Int64Var(&dynamoSample, "dynamodb-sample-size", conversion.DefaultDynamoDBSampleSize, "dynamodb-sample-size: number of values of each attribute used to infer its type with -driver dynamodb")
+	flag.StringVar(&csvSchemaFile, "csv-schema", "", "csv-schema: JSON or YAML file defining the Spanner tables of -driver csv, with the CSV or TSV files holding their rows (local file names, relative to the schema file, or Google Cloud Storage URLs)")
+	flag.StringVar(&sourceSchemas, "schemas", "", "schemas: comma-separated list of the PostgreSQL schemas whose tables are converted with -driver postgres e.g. public,audit (default all schemas other than PostgreSQL's own)")
+	flag.Int64Var(&stringLength, "max-string-length", 0, "max-string-length: if positive, map source types that would be STRING(MAX) (e.g. text) to STRING(N) with this length, for environments that forbid STRING(MAX)")
+	flag.StringVar(&stringOverflow, "string-overflow", string(conversion.StringOverflowReject), "string-overflow: what to do with values longer than their STRING(N) column: reject (the row is a bad row) or truncate")
+	flag.StringVar(&timeZone, "timezone", "UTC", "timezone: time zone that source timestamps without time zone (PostgreSQL timestamp, MySQL datetime, SQL Server datetime2) are interpreted in: an IANA name (e.g. America/New_York) or a fixed offset (e.g. +05:30)")
+	flag.StringVar(&duplicateCopy, "duplicate-copy", string(conversion.DuplicateCopySkip), "duplicate-copy: what to do with a COPY-FROM block for a table whose data an earlier block gave (e.g. in concatenated dump files): skip (don't convert its rows) or append (convert them as well)")
+	flag.StringVar(&floatPolicy, "float-policy", string(conversion.FloatReject), "float-policy: what to do with NaN, infinite and out-of-range values converted to FLOAT64: reject (the row is a bad row), null (write NULL, or reject if the column is NOT NULL) or clamp (infinities become the largest FLOAT64 values; NaN is handled as for null)")
+	flag.StringVar(&invalidUTF8, "invalid-utf8", "reject", "invalid-utf8: what to do with values converted to STRING that aren't valid UTF-8: reject (the row is a bad row), replace (invalid bytes become U+FFFD) or transcode-from=<charset> (decode them from a charset such as latin1 or windows-1252)")
+	flag.StringVar(&commitTSColumn, "commit-timestamp-column", "", "commit-timestamp-column: if non-empty, add a TIMESTAMP column with this name and allow_commit_timestamp = true to every table, for tracking changes after migration (tables can override this in the -table-options file)")
+	flag.StringVar(&commitTSFill, "commit-timestamp-fill", string(conversion.CommitTimestampNull), "commit-timestamp-fill: what migrated rows have in the commit timestamp column: null or commit (the commit timestamp of the write)")
+	flag.StringVar(&suppressIssues, "suppress-issues", "", "suppress-issues: comma-separated schema issues to leave out of the report and schema ratings, e.g. timestamp,widened (as named in report.json)")
+	flag.StringVar(&issueSeverities, "issue-severity-overrides", "", "issue-severity-overrides: comma-separated issue=severity pairs that change the severity (warning or note) schema issues are reported and rated with, e.g. numeric=note")
+	flag.Int64Var(&rowLimit, "row-limit", 0, "row-limit: convert at most this many rows of each table, for trial conversions (0 for no limit)")
+	flag.Float64Var(&samplePercent, "sample-percent", 0, "sample-percent: convert a pseudo-random sample of this percentage of the rows of each table, for trial conversions (0 for all rows)")
+	flag.BoolVar(&verify, "verify", false, fmt.Sprintf("verify: after data conversion, compare the row counts of the source and Spanner tables, and report mismatches (with -min-rating, mismatches exit with code %d)", exitBelowMinRating))
+	flag.BoolVar(&deferIndexes, "defer-indexes", false, "defer-indexes: create the Spanner database without secondary indexes, and create them after data conversion (which makes data conversion much faster); indexes that fail to be created are listed in the report")
+	flag.Int64Var(&readWorkers, "read-workers", conversion.DefaultReadWorkers, "read-workers: number of source tables read concurrently during data conversion with -driver postgres, each in a connection of its own that shares the snapshot of the source database")
+	flag.Int64Var(&fetchSize, "fetch-size", conversion.DefaultFetchSize, "fetch-size: number of rows read by each query of a source table with a primary key during data conversion with -driver postgres, in key order")
+	flag.Int64Var(&indexBatch, "index-batch", conversion.DefaultIndexBatch, "index-batch: max CREATE INDEX statements in each schema update with -defer-indexes")
+	flag.Int64Var(&ddlBatch, "ddl-batch", conversion.DefaultDDLBatch, "ddl-batch: max DDL statements in each schema update when creating the Spanner database")
+	flag.Int64Var(&ddlBatchBytes, "ddl-batch-bytes", conversion.DefaultDDLBatchBytes, "ddl-batch-bytes: max size in bytes of the DDL statements in each schema update when creating the Spanner database")
+	flag.IntVar(&ddlResumeFrom, "ddl-resume-from", 0, "ddl-resume-from: resume creating the schema of the database named by -dbname from this batch, after fixing the problem that made it fail (see the DDL Application section of the report), then convert data")
+	flag.StringVar(&target, "target", "", "target: where to write data: spanner (the default), or avro:<dir> to write one Avro file per table to directory <dir> for bulk import, instead of creating a Spanner database")
+	flag.StringVar(&endpoint, "endpoint", "", "endpoint: address (host:port) of a Spanner emulator to use instead of Cloud Spanner (defaults to $SPANNER_EMULATOR_HOST)")
+	flag.BoolVar(&piiKeyCheck, "pii-key-check", false, "pii-key-check: add report notes for primary key columns that look like they contain personal data (email, national ID, phone)")
 }
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	flag.PrintDefaults()
-	fmt.Fprintf(os.Stderr, `Note: input is always read from stdin.
+	fmt.Fprintf(os.Stderr, `Note: input is read from stdin, unless -input is used.
 Sample usage:
   pg_dump mydb | %s
   %s < my_pg_dump_file
-`, os.Args[0], os.Args[0])
+  %s -input gs://my-bucket/my_pg_dump_file.gz
+  %s -input 'schema_dumps/*.sql'
+  mysqldump mydb | %s -driver mysqldump
+  zcat export/data/*.json.gz | %s -driver dynamodb -dynamodb-table users -dynamodb-key userId
+  %s -driver csv -csv-schema schema.yaml
+  SQLCMDSERVER=myhost SQLCMDUSER=me SQLCMDDBNAME=mydb %s -driver sqlserver
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 }
 
 func main() {
 	flag.Usage = usage
 	flag.Parse()
+	level, err := conversion.ParseLogLevel(logLevel)
+	if err != nil {
+		fmt.Printf("\nBad -log-level: %v\n", err)
+		panic(err)
+	}
+	format, err := conversion.ParseLogFormat(logFormat)
+	if err != nil {
+		fmt.Printf("\nBad -log-format: %v\n", err)
+		panic(err)
+	}
+	conversion.SetLog(conversion.NewLogger(os.Stderr, level, format))
 	internal.VerboseInit(verbose)
 	lf, err := setupLogFile()
 	if err != nil {
@@ -97,356 +295,754 @@ func main() {
 		panic(fmt.Errorf("can't set up log file"))
 	}
 	defer close(lf)
-
-	ioHelper := &ioStreams{in: os.Stdin, out: os.Stdout}
-	project, err := getProject()
+	if _, _, err := reportFormats(reportFormat); err != nil {
+		fmt.Printf("\n%v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseDialect(dialect); err != nil {
+		fmt.Printf("\nBad -dialect: %v\n", err)
+		panic(err)
+	}
+	collision, err := conversion.ParseDBNameCollision(dbNameCollision)
+	if err != nil {
+		fmt.Printf("\nBad -dbname-collision: %v\n", err)
+		panic(err)
+	}
+	if dbNamePrefix != "" && dbNameOverride != "" {
+		fmt.Printf("\nCan't use both -dbname and -dbname-prefix\n")
+		panic(fmt.Errorf("can't use both -dbname and -dbname-prefix"))
+	}
+	if _, err := conversion.ParseReportOrder(reportOrder); err != nil {
+		fmt.Printf("\nBad -report-order: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseReportLevel(reportLevel); err != nil {
+		fmt.Printf("\nBad -report-level: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseRatingThresholds(ratingThresholds); err != nil {
+		fmt.Printf("\nBad -rating-thresholds: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseSyntheticPKStrategy(syntheticPK); err != nil {
+		fmt.Printf("\nBad -synthetic-pk-strategy: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseInheritanceStrategy(inheritance); err != nil {
+		fmt.Printf("\nBad -inheritance: %v\n", err)
+		panic(err)
+	}
+	if stringLength < 0 || stringLength > conversion.MaxStringLength {
+		fmt.Printf("\nBad -max-string-length: must be between 0 and %d\n", conversion.MaxStringLength)
+		panic(fmt.Errorf("bad -max-string-length %d", stringLength))
+	}
+	if _, err := conversion.ParseStringOverflow(stringOverflow); err != nil {
+		fmt.Printf("\nBad -string-overflow: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseTimeZone(timeZone); err != nil {
+		fmt.Printf("\nBad -timezone: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseWritePriority(writePriority); err != nil {
+		fmt.Printf("\nBad -write-priority: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseFloatPolicy(floatPolicy); err != nil {
+		fmt.Printf("\nBad -float-policy: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseDuplicateCopy(duplicateCopy); err != nil {
+		fmt.Printf("\nBad -duplicate-copy: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseUTF8Strategy(invalidUTF8); err != nil {
+		fmt.Printf("\nBad -invalid-utf8: %v\n", err)
+		panic(err)
+	}
+	if _, err := conversion.ParseCommitTimestampFill(commitTSFill); err != nil {
+		fmt.Printf("\nBad -commit-timestamp-fill: %v\n", err)
+		panic(err)
+	}
+	if _, err := issueOverrides(); err != nil {
+		fmt.Printf("\nBad -suppress-issues or -issue-severity-overrides: %v\n", err)
+		panic(err)
+	}
+	sampling := conversion.RowSampling{Limit: rowLimit, Percent: samplePercent}
+	if err := sampling.Validate(); err != nil {
+		fmt.Printf("\nBad -row-limit or -sample-percent: %v\n", err)
+		panic(err)
+	}
+	if sampling.Enabled() && checkpointFile != "" {
+		fmt.Printf("\nCan't use -row-limit or -sample-percent with -checkpoint\n")
+		panic(fmt.Errorf("can't use -row-limit or -sample-percent with -checkpoint"))
+	}
+	avroDir, err := parseTarget(target)
 	if err != nil {
-		fmt.Printf("\nCan't get project: %v\n", err)
-		panic(fmt.Errorf("can't get project"))
+		fmt.Printf("\n%v\n", err)
+		panic(err)
+	}
+	if avroDir != "" && (schemaOnly || dataOnly || checkpointFile != "" || verify || deferIndexes) {
+		fmt.Printf("\nCan't use -target=avro with -schema-only, -data-only, -checkpoint, -verify or -defer-indexes\n")
+		panic(fmt.Errorf("can't use -target=avro with -schema-only, -data-only, -checkpoint, -verify or -defer-indexes"))
+	}
+	if deferIndexes && (schemaOnly || dataOnly) {
+		fmt.Printf("\nCan't use -defer-indexes with -schema-only or -data-only\n")
+		panic(fmt.Errorf("can't use -defer-indexes with -schema-only or -data-only"))
+	}
+	if ddlResumeFrom > 0 && (schemaOnly || dataOnly || avroDir != "") {
+		fmt.Printf("\nCan't use -ddl-resume-from with -schema-only, -data-only or -target=avro\n")
+		panic(fmt.Errorf("can't use -ddl-resume-from with -schema-only, -data-only or -target=avro"))
+	}
+	if createInstance && (instanceOverride == "" || instanceConfig == "") {
+		fmt.Printf("\n-create-instance requires -instance and -instance-config\n")
+		panic(fmt.Errorf("-create-instance requires -instance and -instance-config"))
+	}
+	if createInstance && (schemaOnly || dryRun || dataOnly || existingDB || ddlResumeFrom > 0 || avroDir != "") {
+		fmt.Printf("\nCan't use -create-instance with -schema-only, -dry-run, -data-only, -existing-db, -ddl-resume-from or -target=avro\n")
+		panic(fmt.Errorf("can't use -create-instance with -schema-only, -dry-run, -data-only, -existing-db, -ddl-resume-from or -target=avro"))
+	}
+	if !createInstance && (instanceConfig != "" || instanceNodes != 0 || processingUnits != 0) {
+		fmt.Printf("\n-instance-config, -nodes and -processing-units require -create-instance\n")
+		panic(fmt.Errorf("-instance-config, -nodes and -processing-units require -create-instance"))
+	}
+	if ddlBatch <= 0 || ddlBatchBytes <= 0 {
+		fmt.Printf("\nBad -ddl-batch or -ddl-batch-bytes: must be positive\n")
+		panic(fmt.Errorf("bad -ddl-batch %d or -ddl-batch-bytes %d", ddlBatch, ddlBatchBytes))
+	}
+	if readWorkers <= 0 {
+		fmt.Printf("\nBad -read-workers: must be positive\n")
+		panic(fmt.Errorf("bad -read-workers %d", readWorkers))
+	}
+	if sourceSchemas != "" && driverName != POSTGRES {
+		fmt.Printf("\n-schemas requires -driver postgres\n")
+		panic(fmt.Errorf("-schemas requires -driver postgres"))
+	}
+	if driverName == DYNAMODB && dynamoTable == "" {
+		fmt.Printf("\n-driver dynamodb requires -dynamodb-table\n")
+		panic(fmt.Errorf("-driver dynamodb requires -dynamodb-table"))
+	}
+	if (dynamoTable != "" || dynamoKey != "") && driverName != DYNAMODB {
+		fmt.Printf("\n-dynamodb-table and -dynamodb-key require -driver dynamodb\n")
+		panic(fmt.Errorf("-dynamodb-table and -dynamodb-key require -driver dynamodb"))
+	}
+	if driverName == CSV && csvSchemaFile == "" {
+		fmt.Printf("\n-driver csv requires -csv-schema\n")
+		panic(fmt.Errorf("-driver csv requires -csv-schema"))
+	}
+	if csvSchemaFile != "" && driverName != CSV {
+		fmt.Printf("\n-csv-schema requires -driver csv\n")
+		panic(fmt.Errorf("-csv-schema requires -driver csv"))
+	}
+	if driverName == CSV && len(inputFiles) > 0 {
+		fmt.Printf("\n-input isn't supported with -driver csv: list the files in -csv-schema\n")
+		panic(fmt.Errorf("-input isn't supported with -driver csv"))
+	}
+	if len(inputFiles) > 1 && driverName != "" && driverName != PGDUMP && driverName != MYSQLDUMP {
+		fmt.Printf("\n-input can only be repeated for pg_dump or mysqldump input\n")
+		panic(fmt.Errorf("-input can only be repeated for pg_dump or mysqldump input"))
+	}
+	if dynamoSample <= 0 {
+		fmt.Printf("\nBad -dynamodb-sample-size: must be positive\n")
+		panic(fmt.Errorf("bad -dynamodb-sample-size %d", dynamoSample))
+	}
+	if readWorkers > 1 && driverName != POSTGRES {
+		fmt.Printf("\n-read-workers requires -driver postgres\n")
+		panic(fmt.Errorf("-read-workers requires -driver postgres"))
+	}
+	if fetchSize <= 0 {
+		fmt.Printf("\nBad -fetch-size: must be positive\n")
+		panic(fmt.Errorf("bad -fetch-size %d", fetchSize))
+	}
+	if indexBatch <= 0 {
+		fmt.Printf("\nBad -index-batch: must be positive\n")
+		panic(fmt.Errorf("bad -index-batch %d", indexBatch))
+	}
+	var min conversion.Rating
+	if minRating != "" {
+		min, err = conversion.ParseRating(minRating)
+		if err != nil {
+			fmt.Printf("\nBad -min-rating: %v\n", err)
+			panic(err)
+		}
 	}
-	fmt.Printf("Using project: %s\n", project)
 
-	instance := instanceOverride
-	if instance == "" {
-		instance, err = getInstance(project, ioHelper.out)
+	// Aggregate assessment is schema-only and doesn't need Spanner.
+	if assessFile != "" {
+		prefix := filePrefix
+		if prefix == "" {
+			prefix = "assessment."
+		}
+		if err := assess(assessFile, prefix, time.Now(), os.Stdout); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if schemaOnly && dataOnly {
+		fmt.Printf("\nCan't use both -schema-only and -data-only\n")
+		panic(fmt.Errorf("can't use both -schema-only and -data-only"))
+	}
+	if dryRun && (schemaOnly || avroDir != "" || checkpointFile != "" || verify || deferIndexes || ddlResumeFrom > 0) {
+		fmt.Printf("\nCan't use -dry-run with -schema-only, -target=avro, -checkpoint, -verify, -defer-indexes or -ddl-resume-from\n")
+		panic(fmt.Errorf("can't use -dry-run with -schema-only, -target=avro, -checkpoint, -verify, -defer-indexes or -ddl-resume-from"))
+	}
+	if existingDB && (schemaOnly || dataOnly || dryRun || avroDir != "" || deferIndexes || ddlResumeFrom > 0 || validateDDL != "") {
+		fmt.Printf("\nCan't use -existing-db with -schema-only, -data-only, -dry-run, -target=avro, -defer-indexes, -ddl-resume-from or -validate-ddl\n")
+		panic(fmt.Errorf("can't use -existing-db with -schema-only, -data-only, -dry-run, -target=avro, -defer-indexes, -ddl-resume-from or -validate-ddl"))
+	}
+	// Data-only dry runs can't read the schema from Spanner.
+	if dryRun && dataOnly && readSessionFile == "" {
+		fmt.Printf("\n-dry-run with -data-only requires -read-session\n")
+		panic(fmt.Errorf("-dry-run with -data-only requires -read-session"))
+	}
+	// Data-only conversions (and -existing-db) write to an existing
+	// database, so we can't make up a name for it.
+	if dataOnly && dbNameOverride == "" {
+		fmt.Printf("\n-data-only requires -dbname\n")
+		panic(fmt.Errorf("-data-only requires -dbname"))
+	}
+	if existingDB && dbNameOverride == "" {
+		fmt.Printf("\n-existing-db requires -dbname\n")
+		panic(fmt.Errorf("-existing-db requires -dbname"))
+	}
+	// Likewise for resuming schema creation of the database of a
+	// previous run.
+	if ddlResumeFrom > 0 && dbNameOverride == "" {
+		fmt.Printf("\n-ddl-resume-from requires -dbname\n")
+		panic(fmt.Errorf("-ddl-resume-from requires -dbname"))
+	}
+	// Resumed conversions write to the database of the interrupted run.
+	if resume && (!dataOnly || checkpointFile == "") {
+		fmt.Printf("\n-resume requires -data-only and -checkpoint\n")
+		panic(fmt.Errorf("-resume requires -data-only and -checkpoint"))
+	}
+
+	ioHelper := &ioStreams{in: os.Stdin, out: os.Stdout}
+	// Schema-only conversions, dry runs, and conversions that write Avro
+	// files don't access Spanner, unless the schema is validated in the
+	// target instance.
+	var project, instance string
+	emulator := endpoint
+	if emulator == "" {
+		emulator = os.Getenv("SPANNER_EMULATOR_HOST")
+	}
+	writesDB := !schemaOnly && !dryRun && avroDir == ""
+	useSpanner := writesDB || validateDDL == conversion.ValidateDDLInstance
+	if emulator != "" && (credentialsFile != "" || impersonate != "") {
+		fmt.Printf("\nCan't use -credentials-file or -impersonate-service-account with the Spanner emulator\n")
+		panic(fmt.Errorf("can't use -credentials-file or -impersonate-service-account with the Spanner emulator"))
+	}
+	var clientOpts []option.ClientOption
+	if useSpanner && emulator != "" {
+		// The gcloud lookups and permission checks below are for Cloud
+		// Spanner only.
+		p := projectOverride
+		if p == "" {
+			p = os.Getenv("GCLOUD_PROJECT")
+		}
+		project, instance = emulatorTarget(p, instanceOverride)
+		fmt.Printf("Using Spanner emulator at %s (project %s, instance %s)\n", emulator, project, instance)
+	} else if useSpanner {
+		clientOpts, err = conversion.CredentialOptions(context.Background(), credentialsFile, impersonate)
 		if err != nil {
-			fmt.Printf("\nCan't get instance: %v\n", err)
-			panic(fmt.Errorf("can't get instance"))
+			fmt.Printf("\nBad -credentials-file or -impersonate-service-account: %v\n", err)
+			panic(err)
+		}
+		project = projectOverride
+		if project == "" {
+			project, err = getProject()
+			if err != nil {
+				fmt.Printf("\nCan't get project: %v\n", err)
+				panic(fmt.Errorf("can't get project"))
+			}
+		}
+		fmt.Printf("Using project: %s\n", project)
+
+		instance = instanceOverride
+		if instance == "" {
+			instance, err = getInstance(project, clientOpts, ioHelper.out)
+			if err != nil {
+				fmt.Printf("\nCan't get instance: %v\n", err)
+				panic(fmt.Errorf("can't get instance"))
+			}
+		}
+		fmt.Printf("Using Spanner instance: %s\n", instance)
+		if !dataOnly && !existingDB {
+			printPermissionsWarning(ioHelper.out)
 		}
 	}
-	fmt.Printf("Using Spanner instance: %s\n", instance)
-	printPermissionsWarning(ioHelper.out)
 
 	now := time.Now()
 	dbName := dbNameOverride
 	if dbName == "" {
-		dbName, err = getDatabaseName(now)
+		dbName, err = conversion.GenerateDBName(dbNamePrefix, dbNameSource(), now)
+		if err != nil {
+			fmt.Printf("\nBad -dbname-prefix: %v\n", err)
+			panic(err)
+		}
+	}
+	// Check that the database to be created doesn't already exist (or
+	// pick a free name) before anything else, so that a collision is
+	// found straight away, and generated files are named after the
+	// database that is created.
+	if useSpanner && !schemaOnly && !dataOnly && !existingDB && ddlResumeFrom == 0 {
+		if err := conversion.CheckDBName(dbName); err != nil {
+			fmt.Printf("\nBad -dbname: %v\n", err)
+			panic(err)
+		}
+		o := conversion.Options{Project: project, Instance: instance, DBName: dbName, DBCollision: collision, Endpoint: endpoint, ClientOptions: clientOpts}
+		name, err := conversion.ResolveDBName(context.Background(), o)
 		if err != nil {
-			fmt.Printf("\nCan't get database name: %v\n", err)
-			panic(fmt.Errorf("can't get database name"))
+			fmt.Printf("\n%v\n", err)
+			panic(err)
+		}
+		if name != dbName {
+			fmt.Printf("Database %s already exists: using %s instead\n", dbName, name)
+			dbName = name
+		}
+	}
+
+	// Loading data into the wrong project is easy to do with ambient
+	// credentials, so say where the conversion goes (and as whom), and
+	// check that's what the user wants before touching Cloud Spanner.
+	if useSpanner && emulator == "" {
+		fmt.Print(describeTarget(project, instance, dbName, principal(), databaseRole(writesDB)))
+		if !assumeYes {
+			ok, err := confirmFromTerminal("Continue?")
+			if err != nil {
+				fmt.Printf("\nCan't ask for confirmation: %v (use -yes to go ahead without it)\n", err)
+				panic(err)
+			}
+			if !ok {
+				fmt.Printf("\nConversion cancelled\n")
+				close(lf)
+				os.Exit(exitCancelled)
+			}
 		}
 	}
 
-	// If filePrefix not explicitly set, use dbName.
-	if filePrefix == "" {
+	// If filePrefix not explicitly set, use dbName (unless files go to
+	// their own directory).
+	if filePrefix == "" && outDir == "" {
 		filePrefix = dbName + "."
 	}
 
 	// If driverName specified, access source DB via database/sql
-	// driver. Otherwise read pgdump data from stdin.
-	if driverName == "" {
-		driverName = PGDUMP
+	// driver. Otherwise read dump data, which is recognized as pg_dump
+	// or mysqldump output (see conversion.Options.Driver).
+	res, err := toSpanner(interruptContext(ioHelper.out), driverName, project, instance, dbName, clientOpts, ioHelper, filePrefix, now)
+	if errors.Is(err, conversion.ErrInterrupted) {
+		fmt.Printf("\nConversion interrupted: the report covers the data converted until then\n")
+		close(lf)
+		os.Exit(exitInterrupted)
+	}
+	if errors.Is(err, conversion.ErrSchemaInvalid) {
+		fmt.Printf("\n%v\n", err)
+		close(lf)
+		os.Exit(exitSchemaInvalid)
 	}
-	err = toSpanner(driverName, project, instance, dbName, ioHelper, filePrefix, now)
 	if err != nil {
 		panic(err)
 	}
+	if minRating != "" {
+		if err := checkMinRating(res.Ratings, res.Mismatches, min); err != nil {
+			fmt.Printf("\n%v\n", err)
+			close(lf)
+			os.Exit(exitBelowMinRating)
+		}
+	}
 }
 
-// toSpanner is the main entrance of the entire conversion, which runs the
-// following steps:
-//   1. Run schema conversion
-//   2. Create database
-//   3. Run data conversion
-//   4. Generate report
-func toSpanner(driver, projectID, instanceID, dbName string, ioHelper *ioStreams, outputFilePrefix string, now time.Time) error {
-	conv, err := schemaConv(driver, ioHelper)
-	if err != nil {
-		return err
+// checkMinRating returns an error if the schema or data rating is
+// below min, or if -verify found tables with mismatched row counts. A
+// rating of RatingNone (e.g. there were no data rows) or RatingSkipped
+// (e.g. data conversion for -schema-only) means there was nothing to
+// rate, so it always passes. RatingInconsistent (row counts that don't
+// add up) is below every minimum.
+func checkMinRating(r conversion.Ratings, mismatches int, min conversion.Rating) error {
+	below := func(x conversion.Rating) bool {
+		return x != conversion.RatingNone && x != conversion.RatingSkipped && x < min
 	}
-	// close the seekable file
-	if ioHelper.seekableIn != nil {
-		defer ioHelper.in.Close()
+	var failed []string
+	if below(r.Schema) {
+		failed = append(failed, "schema conversion rating "+r.Schema.String())
 	}
-
-	writeSchemaFile(conv, now, outputFilePrefix+schemaFile, ioHelper.out)
-	db, err := createDatabase(projectID, instanceID, dbName, conv, ioHelper.out)
-	if err != nil {
-		fmt.Printf("\nCan't create database: %v\n", err)
-		return fmt.Errorf("can't create database")
+	if below(r.Data) {
+		failed = append(failed, "data conversion rating "+r.Data.String())
 	}
-
-	client, err := getClient(db)
-	if err != nil {
-		fmt.Printf("\nCan't create client for db %s: %v\n", db, err)
-		return fmt.Errorf("can't create Spanner client")
+	var errs []string
+	if len(failed) > 0 {
+		errs = append(errs, fmt.Sprintf("%s below minimum rating %s", strings.Join(failed, " and "), min))
 	}
-
-	bw, err := dataConv(driver, ioHelper, client, conv)
-	if err != nil {
-		fmt.Printf("\nCan't finish data conversion for db %s: %v\n", db, err)
-		return fmt.Errorf("can't finish data conversion")
+	if mismatches > 0 {
+		errs = append(errs, fmt.Sprintf("%d tables with mismatched row counts (see the Verification section of the report)", mismatches))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
 	}
-	banner := getBanner(now, db)
-	report(bw.DroppedRowsByTable(), 0, banner, conv, outputFilePrefix+reportFile, ioHelper.out)
-	writeBadData(bw, conv, banner, outputFilePrefix+badDataFile, ioHelper.out)
 	return nil
 }
 
-func schemaConv(driver string, ioHelper *ioStreams) (*internal.Conv, error) {
-	switch driver {
-	case POSTGRES:
-		return schemaFromSQL(driver)
-	case PGDUMP:
-		return schemaFromPgDump(ioHelper)
-	default:
-		return nil, fmt.Errorf("schema conversion for driver %s not supported", driver)
-	}
+// ioStreams are the input (pg_dump data) and output (status messages)
+// for a conversion.
+type ioStreams struct {
+	in, out *os.File
 }
 
-func dataConv(driver string, ioHelper *ioStreams, client *sp.Client, conv *internal.Conv) (*spanner.BatchWriter, error) {
-	config := spanner.BatchWriterConfig{
-		BytesLimit: 100 * 1000 * 1000,
-		WriteLimit: 40,
-		RetryLimit: 1000,
-		Verbose:    internal.Verbose(),
+// toSpanner is the main entrance of the entire conversion. It reads
+// flag-derived options and runs the conversion via conversion.Run,
+// which runs the following steps:
+//  1. Run schema conversion
+//  2. Create database (skipped for -schema-only and -data-only)
+//  3. Run data conversion (skipped for -schema-only)
+//  4. Generate report
+func toSpanner(ctx context.Context, driver, projectID, instanceID, dbName string, clientOpts []option.ClientOption, ioHelper *ioStreams, outputFilePrefix string, now time.Time) (*conversion.Result, error) {
+	// Read table options, type map and column transforms before schema
+	// conversion, so that we fail fast if any of these files is bad.
+	var tableOptions map[string]conversion.TableOptions
+	if tableOptionsFile != "" {
+		var err error
+		tableOptions, err = readTableOptions(tableOptionsFile)
+		if err != nil {
+			return nil, err
+		}
 	}
-	switch driver {
-	case POSTGRES:
-		return dataFromSQL(config, client, conv, driver)
-	case PGDUMP:
-		return dataFromPgDump(config, ioHelper, client, conv)
-	default:
-		return nil, fmt.Errorf("data conversion for driver %s not supported", driver)
+	var typeMap conversion.TypeMap
+	if typeMapFile != "" {
+		var err error
+		typeMap, err = readTypeMap(typeMapFile)
+		if err != nil {
+			return nil, err
+		}
 	}
-}
-
-func driverConfig(driver string) (string, error) {
-	switch driver {
-	case POSTGRES:
-		return pgDriverConfig()
-	default:
-		return "", fmt.Errorf("Driver %s not supported", driver)
+	var transforms conversion.ColumnTransforms
+	if transformsFile != "" {
+		var err error
+		transforms, err = readColumnTransforms(transformsFile)
+		if err != nil {
+			return nil, err
+		}
 	}
-}
-
-func pgDriverConfig() (string, error) {
-	server := os.Getenv("PGHOST")
-	port := os.Getenv("PGPORT")
-	user := os.Getenv("PGUSER")
-	dbname := os.Getenv("PGDATABASE")
-	if server == "" || port == "" || user == "" || dbname == "" {
-		fmt.Printf("Please specify host, port, user and database using PGHOST, PGPORT, PGUSER and PGDATABASE environment variables\n")
-		return "", fmt.Errorf("Could not connect to source database")
+	var session *conversion.Session
+	if readSessionFile != "" {
+		var err error
+		session, err = readSession(readSessionFile)
+		if err != nil {
+			return nil, err
+		}
 	}
-	password := getPassword()
-	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", server, port, user, password, dbname), nil
-}
-
-func schemaFromSQL(driver string) (*internal.Conv, error) {
-	driverConfig, err := driverConfig(driver)
+	text, html, err := reportFormats(reportFormat)
 	if err != nil {
 		return nil, err
 	}
-	sourceDB, err := sql.Open(driver, driverConfig)
+	spDialect, err := conversion.ParseDialect(dialect)
 	if err != nil {
 		return nil, err
 	}
-	conv := internal.MakeConv()
-	err = internal.ProcessInfoSchema(conv, sourceDB)
+	collision, err := conversion.ParseDBNameCollision(dbNameCollision)
 	if err != nil {
 		return nil, err
 	}
-	return conv, nil
-}
-
-func dataFromSQL(config spanner.BatchWriterConfig, client *sp.Client, conv *internal.Conv, driver string) (*spanner.BatchWriter, error) {
-	// TODO: Refactor to avoid redundant calls to driverConfig and
-	// Open in schemaFromSQL and dataFromSQL. Also refactor to
-	// share code with dataFromPgDump. Use single transaction for
-	// reading schema and data from source db to get consistent
-	// dump.
-	driverConfig, err := driverConfig(driver)
+	var newInstance *conversion.InstanceSpec
+	if createInstance {
+		newInstance = &conversion.InstanceSpec{Config: instanceConfig, Nodes: int32(instanceNodes), ProcessingUnits: int32(processingUnits)}
+	}
+	order, err := conversion.ParseReportOrder(reportOrder)
 	if err != nil {
 		return nil, err
 	}
-	sourceDB, err := sql.Open(driver, driverConfig)
+	level, err := conversion.ParseReportLevel(reportLevel)
 	if err != nil {
 		return nil, err
 	}
-
-	internal.SetRowStats(conv, sourceDB)
-	totalRows := conv.Rows()
-	p := internal.NewProgress(totalRows, "Writing data to Spanner", internal.Verbose())
-	rows := int64(0)
-	config.Write = func(m []*sp.Mutation) error {
-		_, err := client.Apply(context.Background(), m)
-		if err != nil {
-			return err
-		}
-		atomic.AddInt64(&rows, int64(len(m)))
-		p.MaybeReport(atomic.LoadInt64(&rows))
-		return nil
-	}
-	writer := spanner.NewBatchWriter(config)
-	conv.SetDataMode()
-	conv.SetDataSink(
-		func(table string, cols []string, vals []interface{}) {
-			writer.AddRow(table, cols, vals)
-		})
-	internal.ProcessSqlData(conv, sourceDB)
-	writer.Flush()
-	return writer, nil
-}
-
-type ioStreams struct {
-	in, seekableIn, out *os.File
-	bytesRead           int64
-}
-
-func schemaFromPgDump(ioHelper *ioStreams) (*internal.Conv, error) {
-	f, n, err := getSeekable(ioHelper.in)
+	thresholds, err := conversion.ParseRatingThresholds(ratingThresholds)
 	if err != nil {
-		printSeekError(err, ioHelper.out)
-		return nil, fmt.Errorf("can't get seekable input file")
+		return nil, err
 	}
-	ioHelper.seekableIn = f
-	ioHelper.bytesRead = n
-	conv := internal.MakeConv()
-	p := internal.NewProgress(n, "Generating schema", internal.Verbose())
-	r := internal.NewReader(bufio.NewReader(f), p)
-	conv.SetSchemaMode() // Build schema and ignore data in pg_dump.
-	conv.SetDataSink(nil)
-	err = internal.ProcessPgDump(conv, r)
+	pkStrategy, err := conversion.ParseSyntheticPKStrategy(syntheticPK)
 	if err != nil {
-		fmt.Fprintf(ioHelper.out, "Failed to parse the data file: %v", err)
-		return nil, fmt.Errorf("failed to parse the data file")
+		return nil, err
 	}
-	p.Done()
-	return conv, nil
-}
-
-func dataFromPgDump(config spanner.BatchWriterConfig, ioHelper *ioStreams, client *sp.Client, conv *internal.Conv) (*spanner.BatchWriter, error) {
-	_, err := ioHelper.seekableIn.Seek(0, 0)
+	inheritStrategy, err := conversion.ParseInheritanceStrategy(inheritance)
 	if err != nil {
-		fmt.Printf("\nCan't seek to start of file (preparation for second pass): %v\n", err)
-		return nil, fmt.Errorf("can't seek to start of file")
+		return nil, err
 	}
-	totalRows := conv.Rows()
-
-	p := internal.NewProgress(totalRows, "Writing data to Spanner", internal.Verbose())
-	r := internal.NewReader(bufio.NewReader(ioHelper.seekableIn), nil)
-	rows := int64(0)
-	config.Write = func(m []*sp.Mutation) error {
-		_, err := client.Apply(context.Background(), m)
-		if err != nil {
-			return err
-		}
-		atomic.AddInt64(&rows, int64(len(m)))
-		p.MaybeReport(atomic.LoadInt64(&rows))
-		return nil
+	overflow, err := conversion.ParseStringOverflow(stringOverflow)
+	if err != nil {
+		return nil, err
 	}
-	writer := spanner.NewBatchWriter(config)
-	conv.SetDataMode() // Process data in pg_dump; schema is unchanged.
-	conv.SetDataSink(
-		func(table string, cols []string, vals []interface{}) {
-			writer.AddRow(table, cols, vals)
-		})
-	internal.ProcessPgDump(conv, r)
-	writer.Flush()
-	p.Done()
-
-	return writer, nil
-}
-
-func report(badWrites map[string]int64, bytesRead int64, banner string, conv *internal.Conv, reportFileName string, out *os.File) {
-	f, err := os.Create(reportFileName)
+	tz, err := conversion.ParseTimeZone(timeZone)
 	if err != nil {
-		fmt.Fprintf(out, "Can't write out report file %s: %v\n", reportFileName, err)
-		fmt.Fprintf(out, "Writing report to stdout\n")
-		f = out
-	} else {
-		defer f.Close()
+		return nil, err
 	}
-	w := bufio.NewWriter(f)
-	w.WriteString(banner)
-	summary := internal.GenerateReport(fromPgDump, conv, w, badWrites)
-	w.Flush()
-	if fromPgDump {
-		fmt.Fprintf(out, "Processed %d bytes of pg_dump data (%d statements, %d rows of data, %d errors, %d unexpected conditions).\n",
-			bytesRead, conv.Statements(), conv.Rows(), conv.StatementErrors(), conv.Unexpecteds())
-	} else {
-		fmt.Fprintf(out, "Processed source database via %s driver (%d rows of data, %d unexpected conditions).\n",
-			driverName, conv.Rows(), conv.Unexpecteds())
+	floats, err := conversion.ParseFloatPolicy(floatPolicy)
+	if err != nil {
+		return nil, err
 	}
-	// We've already written summary to f (as part of GenerateReport).
-	// In the case where f is stdout, don't write a duplicate copy.
-	if f != out {
-		fmt.Fprint(out, summary)
-		fmt.Fprintf(out, "See file '%s' for details of the schema and data conversions.\n", reportFileName)
+	duplicates, err := conversion.ParseDuplicateCopy(duplicateCopy)
+	if err != nil {
+		return nil, err
 	}
-}
-
-// getSeekable returns a seekable file (with same content as f) and the size of the content (in bytes).
-func getSeekable(f *os.File) (*os.File, int64, error) {
-	_, err := f.Seek(0, 0)
-	if err == nil { // Stdin is seekable, let's just use that. This happens when you run 'cmd < file'.
-		n, err := getSize(f)
-		return f, n, err
+	utf8Strategy, err := conversion.ParseUTF8Strategy(invalidUTF8)
+	if err != nil {
+		return nil, err
 	}
-	internal.VerbosePrintln("Creating a tmp file with a copy of stdin because stdin is not seekable.")
-
-	// Create file in os.TempDir. Its not clear this is a good idea e.g. if the
-	// pg_dump output is large (tens of GBs) and os.TempDir points to a directory
-	// (such as /tmp) that's configured with a small amount of disk space.
-	// To workaround such limits on Unix, set $TMPDIR to a directory with lots
-	// of disk space.
-	fcopy, err := ioutil.TempFile("", "harbourbridge.data")
+	fill, err := conversion.ParseCommitTimestampFill(commitTSFill)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-	syscall.Unlink(fcopy.Name()) // File will be deleted when this process exits.
-	_, err = io.Copy(fcopy, f)
+	issues, err := issueOverrides()
 	if err != nil {
-		return nil, 0, fmt.Errorf("can't write stdin to tmp file: %w", err)
+		return nil, err
 	}
-	_, err = fcopy.Seek(0, 0)
+	avroDir, err := parseTarget(target)
 	if err != nil {
-		return nil, 0, fmt.Errorf("can't reset file offset: %w", err)
+		return nil, err
+	}
+	opts := conversion.Options{
+		Driver:            driver,
+		Project:           projectID,
+		Instance:          instanceID,
+		DBName:            dbName,
+		ClientOptions:     clientOpts,
+		TableOptions:      tableOptions,
+		TypeMap:           typeMap,
+		Transforms:        transforms,
+		Session:           session,
+		SchemaOnly:        schemaOnly,
+		Dialect:           spDialect,
+		ValidateDDL:       validateDDL,
+		DryRun:            dryRun,
+		DataOnly:          dataOnly,
+		ExistingDB:        existingDB,
+		DBCollision:       collision,
+		CreateInstance:    newInstance,
+		AvroDir:           avroDir,
+		Endpoint:          endpoint,
+		ColumnStats:       columnStats,
+		ShowMappings:      showMappings,
+		BatchBytes:        batchBytes,
+		CommitAttempts:    commitAttempts,
+		CommitRetryBudget: commitBudget,
+		WritePriority:     conversion.WritePriority(writePriority),
+		WriteTag:          writeTag,
+		PIIKeyCheck:       piiKeyCheck,
+		SyntheticPK:       pkStrategy,
+		Inheritance:       inheritStrategy,
+		Namespace:         defaultSchema,
+		Schemas:           parseSchemas(sourceSchemas),
+		StringLength:      stringLength,
+		Overflow:          overflow,
+		TimeZone:          tz,
+		Floats:            floats,
+		Duplicates:        duplicates,
+		InvalidUTF8:       utf8Strategy,
+		CommitTS:          conversion.CommitTimestampOption{Column: commitTSColumn, Fill: fill},
+		Sampling:          conversion.RowSampling{Limit: rowLimit, Percent: samplePercent},
+		Issues:            issues,
+		Verify:            verify,
+		DeferIndexes:      deferIndexes,
+		IndexBatch:        indexBatch,
+		ReadWorkers:       readWorkers,
+		FetchSize:         fetchSize,
+		DDLBatch:          ddlBatch,
+		DDLBatchBytes:     ddlBatchBytes,
+		DDLResumeFrom:     ddlResumeFrom,
+		BadRowsFile:       badRowsFile,
+		BadRowsLimit:      badRowsLimit,
+		BadValueSamples:   badValueSamples,
+		NoDataSamples:     noDataSamples,
+		NoSnippets:        noSnippets,
+		CheckpointFile:    checkpointFile,
+		Resume:            resume,
+		FilePrefix:        outputFilePrefix,
+		OutDir:            outDir,
+		TextReport:        text,
+		HTMLReport:        html,
+		JSONReport:        true,
+		ReportOrder:       order,
+		ReportLevel:       level,
+		Thresholds:        thresholds,
+		Logger:            statusLogger(ioHelper.out),
+		Progress:          ioHelper.out,
+		Now:               now,
 	}
-	n, err := getSize(fcopy)
-	return fcopy, n, nil
+	switch driver {
+	case "", PGDUMP, MYSQLDUMP, DYNAMODB:
+		opts.Input = ioHelper.in
+		files, err := expandInputs(inputFiles)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range files {
+			f, err := conversion.OpenDump(context.Background(), name)
+			if err != nil {
+				return nil, fmt.Errorf("can't open input %s: %w", name, err)
+			}
+			defer f.Close()
+			opts.Input = f
+			if len(files) > 1 {
+				opts.Input = nil
+				opts.Inputs = append(opts.Inputs, conversion.NamedInput{Name: name, Input: f})
+			}
+		}
+	case POSTGRES:
+		opts.DSN, err = pgDriverConfig()
+		if err != nil {
+			return nil, err
+		}
+	case SQLSERVER:
+		opts.DSN, err = sqlServerDriverConfig()
+		if err != nil {
+			return nil, err
+		}
+	case CSV:
+		opts.CSV, err = readCSVSchema(csvSchemaFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if driver == DYNAMODB {
+		opts.DynamoDB = conversion.DynamoDBExport{Table: dynamoTable, Key: parseDynamoKey(dynamoKey), SampleSize: dynamoSample}
+	}
+	if writeSessionFile != "" {
+		f, err := os.Create(writeSessionFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't create session file: %w", err)
+		}
+		defer f.Close()
+		opts.WriteSession = f
+	}
+	if metricsAddr != "" {
+		opts.Metrics, err = serveMetrics(metricsAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, res, err := conversion.Run(ctx, opts)
+	return res, err
 }
 
-// createDatabase returns a newly create Spanner DB.
-// It automatically determines an appropriate project, selects a
-// Spanner instance to use, generates a new Spanner DB name,
-// and call into the Spanner admin interface to create the new DB.
-func createDatabase(project, instance, dbName string, conv *internal.Conv, out *os.File) (string, error) {
-	fmt.Fprintf(out, "Creating new database %s in instance %s with default permissions ... ", dbName, instance)
-	ctx := context.Background()
-	var opts []option.ClientOption
-	// Append emulator options if SPANNER_EMULATOR_HOST has been set.
-	if emulatorAddr := os.Getenv("SPANNER_EMULATOR_HOST"); emulatorAddr != "" {
-		emulatorOpts := []option.ClientOption{
-			option.WithEndpoint(emulatorAddr),
-			option.WithGRPCDialOption(grpc.WithInsecure()),
-			option.WithoutAuthentication(),
+// interruptContext returns a context that is canceled on the first
+// SIGINT or SIGTERM, which stops the conversion gracefully: it stops
+// reading data, waits for writes in progress, and writes the report. A
+// second signal exits immediately.
+func interruptContext(out *os.File) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		fmt.Fprintf(out, "\nInterrupted: finishing writes in progress and writing the report (interrupt again to exit immediately)\n")
+		cancel()
+		<-c
+		os.Exit(exitInterrupted)
+	}()
+	return ctx
+}
+
+func pgDriverConfig() (string, error) {
+	server := os.Getenv("PGHOST")
+	port := os.Getenv("PGPORT")
+	user := os.Getenv("PGUSER")
+	dbname := os.Getenv("PGDATABASE")
+	if server == "" || port == "" || user == "" || dbname == "" {
+		fmt.Printf("Please specify host, port, user and database using PGHOST, PGPORT, PGUSER and PGDATABASE environment variables\n")
+		return "", fmt.Errorf("Could not connect to source database")
+	}
+	password := getPassword("PGPASSWORD")
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", server, port, user, password, dbname), nil
+}
+
+// sqlServerDriverConfig returns the connection string for -driver
+// sqlserver, from the environment variables that sqlcmd uses.
+func sqlServerDriverConfig() (string, error) {
+	server := os.Getenv("SQLCMDSERVER")
+	user := os.Getenv("SQLCMDUSER")
+	dbname := os.Getenv("SQLCMDDBNAME")
+	if server == "" || user == "" || dbname == "" {
+		fmt.Printf("Please specify server, user and database using SQLCMDSERVER, SQLCMDUSER and SQLCMDDBNAME environment variables\n")
+		return "", fmt.Errorf("Could not connect to source database")
+	}
+	return sqlServerDSN(server, user, getPassword("SQLCMDPASSWORD"), dbname), nil
+}
+
+// sqlServerDSN returns the connection string for SQL Server database
+// dbname, given server in sqlcmd's format: host, host,port or
+// host\instance, optionally prefixed by tcp:.
+func sqlServerDSN(server, user, password, dbname string) string {
+	u := url.URL{Scheme: "sqlserver", User: url.UserPassword(user, password), Host: strings.TrimPrefix(server, "tcp:")}
+	if i := strings.Index(u.Host, "\\"); i >= 0 {
+		u.Host, u.Path = u.Host[:i], "/"+u.Host[i+1:]
+	}
+	u.Host = strings.Replace(u.Host, ",", ":", 1)
+	u.RawQuery = url.Values{"database": {dbname}}.Encode()
+	return u.String()
+}
+
+// reportFormats parses the -report-format flag, and returns whether
+// text and HTML reports should be written.
+func reportFormats(s string) (text, html bool, err error) {
+	switch s {
+	case "text":
+		return true, false, nil
+	case "html":
+		return false, true, nil
+	case "both":
+		return true, true, nil
+	}
+	return false, false, fmt.Errorf("bad -report-format %q: must be text, html, or both", s)
+}
+
+// parseTarget parses the -target flag, and returns the directory to
+// write Avro files to (empty if data is written to Spanner).
+func parseTarget(s string) (avroDir string, err error) {
+	switch {
+	case s == "" || s == "spanner":
+		return "", nil
+	case strings.HasPrefix(s, "avro:") && len(s) > len("avro:"):
+		return strings.TrimPrefix(s, "avro:"), nil
+	}
+	return "", fmt.Errorf("bad -target %q: must be spanner or avro:<dir>", s)
+}
+
+// parseSchemas parses the -schemas flag, a comma-separated list of
+// schemas. Spaces around names and empty names are ignored.
+func parseSchemas(s string) []string {
+	var l []string
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			l = append(l, x)
 		}
-		opts = append(opts, emulatorOpts...)
 	}
-	adminClient, err := database.NewDatabaseAdminClient(ctx, opts...)
-	if err != nil {
-		return "", fmt.Errorf("can't create admin client: %w", analyzeError(err, project, instance))
+	return l
+}
+
+// parseDynamoKey parses the -dynamodb-key flag: the partition key
+// attribute, optionally followed by a comma and the sort key attribute.
+func parseDynamoKey(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
 	}
-	defer adminClient.Close()
-	// The schema we send to Spanner excludes comments (since Cloud
-	// Spanner DDL doesn't accept them), and protects table and col names
-	// using backticks (to avoid any issues with Spanner reserved words).
-	schema := conv.GetDDL(ddl.Config{Comments: false, ProtectIds: true})
-	op, err := adminClient.CreateDatabase(ctx, &adminpb.CreateDatabaseRequest{
-		Parent:          fmt.Sprintf("projects/%s/instances/%s", project, instance),
-		CreateStatement: "CREATE DATABASE `" + dbName + "`",
-		ExtraStatements: schema,
-	})
-	if err != nil {
-		return "", fmt.Errorf("can't build CreateDatabaseRequest: %w", analyzeError(err, project, instance))
+	l := strings.Split(s, ",")
+	for i := range l {
+		l[i] = strings.TrimSpace(l[i])
+	}
+	return l
+}
+
+// emulatorTarget returns the project and instance to use with the
+// Spanner emulator: project and instance if set, and otherwise the
+// defaults.
+func emulatorTarget(project, instance string) (string, string) {
+	if project == "" {
+		project = emulatorProject
 	}
-	if _, err := op.Wait(ctx); err != nil {
-		return "", fmt.Errorf("createDatabase call failed: %w", analyzeError(err, project, instance))
+	if instance == "" {
+		instance = emulatorInstance
 	}
-	fmt.Fprintf(out, "done.\n")
-	return fmt.Sprintf("projects/%s/instances/%s/databases/%s", project, instance, dbName), nil
+	return project, instance
 }
 
 // getProject returns the cloud project we should use for accessing Spanner.
@@ -466,11 +1062,83 @@ func getProject() (string, error) {
 	return project, nil
 }
 
+// principal describes who we access Spanner as (see
+// conversion.Principal). For Application Default Credentials from
+// 'gcloud auth application-default login', which don't say whose they
+// are, it adds the account gcloud is logged in as (if any), which is
+// usually the same.
+func principal() string {
+	p := conversion.Principal(credentialsFile, impersonate)
+	if credentialsFile != "" || impersonate != "" || os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "" {
+		return p
+	}
+	out, err := exec.Command("gcloud", "config", "list", "--format", "value(core.account)").Output()
+	if account := strings.TrimSpace(string(out)); err == nil && account != "" {
+		p += fmt.Sprintf(" (gcloud account %s)", account)
+	}
+	return p
+}
+
+// databaseRole says what the conversion does with the target database:
+// writesDB is false if it only validates the schema (see -validate-ddl).
+func databaseRole(writesDB bool) string {
+	switch {
+	case !writesDB:
+		return "not used: the schema is only validated, in a temporary database"
+	case dataOnly || existingDB:
+		return "existing database, data is loaded into it"
+	case ddlResumeFrom > 0:
+		return "existing database, schema creation is resumed"
+	default:
+		return "created by this conversion"
+	}
+}
+
+// describeTarget returns the preflight summary of where a conversion
+// goes, and as whom.
+func describeTarget(project, instance, dbName, principal, role string) string {
+	if createInstance {
+		instance += " (created if it doesn't exist)"
+	}
+	return fmt.Sprintf("\nTarget of the conversion:\n"+
+		"  Project:   %s\n"+
+		"  Instance:  %s\n"+
+		"  Database:  %s (%s)\n"+
+		"  Principal: %s\n\n", project, instance, dbName, role, principal)
+}
+
+// confirmFromTerminal asks question on the terminal. The answer is read
+// from the terminal rather than stdin, since pg_dump data is usually
+// piped to stdin.
+func confirmFromTerminal(question string) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, fmt.Errorf("no terminal: %w", err)
+	}
+	defer tty.Close()
+	return confirm(tty, tty, question)
+}
+
+// confirm writes question to out and reads the answer from in: only y
+// or yes (in any case) is a yes.
+func confirm(in io.Reader, out io.Writer, question string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
 // getInstance returns the Spanner instance we should use for creating DBs.
 // If the user specified instance (via flag 'instance') then use that.
 // Otherwise try to deduce the instance using gcloud.
-func getInstance(project string, out *os.File) (string, error) {
-	l, err := getInstances(project)
+func getInstance(project string, clientOpts []option.ClientOption, out *os.File) (string, error) {
+	l, err := getInstances(project, clientOpts)
 	if err != nil {
 		return "", err
 	}
@@ -494,11 +1162,11 @@ func getInstance(project string, out *os.File) (string, error) {
 		"Please use the flag '--instance' to select an instance", project)
 }
 
-func getInstances(project string) ([]string, error) {
+func getInstances(project string, clientOpts []option.ClientOption) ([]string, error) {
 	ctx := context.Background()
-	instanceClient, err := instance.NewInstanceAdminClient(ctx)
+	instanceClient, err := instance.NewInstanceAdminClient(ctx, clientOpts...)
 	if err != nil {
-		return nil, analyzeError(err, project, "")
+		return nil, conversion.AnalyzeError(err, project, "")
 	}
 	it := instanceClient.ListInstances(ctx, &instancepb.ListInstancesRequest{Parent: fmt.Sprintf("projects/%s", project)})
 	var l []string
@@ -508,96 +1176,77 @@ func getInstances(project string) ([]string, error) {
 			break
 		}
 		if err != nil {
-			return nil, analyzeError(err, project, "")
+			return nil, conversion.AnalyzeError(err, project, "")
 		}
 		l = append(l, strings.TrimPrefix(resp.Name, fmt.Sprintf("projects/%s/instances/", project)))
 	}
 	return l, nil
 }
 
-func writeSchemaFile(conv *internal.Conv, now time.Time, name string, out *os.File) {
-	f, err := os.Create(name)
-	if err != nil {
-		fmt.Fprintf(out, "Can't create schema file %s: %v\n", name, err)
-		return
-	}
-	// The schema file we write out includes comments, and doesn't add backticks
-	// around table and column names. This file is intended for explanatory
-	// and documentation purposes, and is not strictly legal Cloud Spanner DDL
-	// (Cloud Spanner doesn't currently support comments). Change 'Comments'
-	// to false and 'ProtectIds' to true to write out a schema file that is
-	// legal Cloud Spanner DDL.
-	ddl := conv.GetDDL(ddl.Config{Comments: true, ProtectIds: false})
-	if len(ddl) == 0 {
-		ddl = []string{"\n-- Schema is empty -- no tables found\n"}
-	}
-	l := []string{
-		fmt.Sprintf("-- Schema generated %s\n", now.Format("2006-01-02 15:04:05")),
-		strings.Join(ddl, ";\n\n"),
-		"\n",
-	}
-	if _, err := f.WriteString(strings.Join(l, "")); err != nil {
-		fmt.Fprintf(out, "Can't write out schema file: %v\n", err)
-		return
-	}
-	fmt.Fprintf(out, "Wrote schema to file '%s'.\n", name)
+// inputList is the value of the -input flag, which can be repeated.
+type inputList []string
+
+func (l *inputList) String() string {
+	return strings.Join(*l, ",")
 }
 
-// writeBadData prints summary stats about bad rows and writes detailed info
-// to file 'name'.
-func writeBadData(bw *spanner.BatchWriter, conv *internal.Conv, banner, name string, out *os.File) {
-	badConversions := conv.BadRows()
-	badWrites := sum(bw.DroppedRowsByTable())
-	if badConversions == 0 && badWrites == 0 {
-		os.Remove(name) // Cleanup bad-data file from previous run.
-		return
-	}
-	f, err := os.Create(name)
-	if err != nil {
-		fmt.Fprintf(out, "Can't write out bad data file: %v\n", err)
-		return
-	}
-	f.WriteString(banner)
-	maxRows := 100
-	if badConversions > 0 {
-		l := conv.SampleBadRows(maxRows)
-		if int64(len(l)) < badConversions {
-			f.WriteString("A sample of rows that generated conversion errors:\n")
-		} else {
-			f.WriteString("Rows that generated conversion errors:\n")
-		}
-		for _, r := range l {
-			_, err := f.WriteString("  " + r + "\n")
+func (l *inputList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// expandInputs returns the dump files named by -input flags l, with
+// file name patterns (e.g. dumps/*.sql) replaced by the files they
+// match, in sorted order. Files named more than once are only
+// included once.
+func expandInputs(l []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, s := range l {
+		matches := []string{s}
+		if !strings.HasPrefix(s, "gs://") && strings.ContainsAny(s, "*?[") {
+			m, err := filepath.Glob(s)
 			if err != nil {
-				fmt.Fprintf(out, "Can't write out bad data file: %v\n", err)
-				return
+				return nil, fmt.Errorf("bad -input pattern %s: %w", s, err)
 			}
+			if len(m) == 0 {
+				return nil, fmt.Errorf("no files match -input %s", s)
+			}
+			matches = m
 		}
-	}
-	if badWrites > 0 {
-		l := bw.SampleBadRows(maxRows)
-		if int64(len(l)) < badWrites {
-			f.WriteString("A sample of rows that successfully converted but couldn't be written to Spanner:\n")
-		} else {
-			f.WriteString("Rows that successfully converted but couldn't be written to Spanner:\n")
-		}
-		for _, r := range l {
-			_, err := f.WriteString("  " + r + "\n")
-			if err != nil {
-				fmt.Fprintf(out, "Can't write out bad data file: %v\n", err)
-				return
+		for _, f := range matches {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
 			}
 		}
 	}
-	fmt.Fprintf(out, "See file '%s' for details of bad rows\n", name)
+	return files, nil
 }
 
-func getDatabaseName(now time.Time) (string, error) {
-	return generateName(fmt.Sprintf("pg_dump_%s", now.Format("2006-01-02")))
+// dbNameSource returns what generated database names are derived from:
+// the dump file, the source database, or (for dumps read from stdin)
+// the driver.
+func dbNameSource() string {
+	switch {
+	case len(inputFiles) > 0:
+		return inputFiles[0]
+	case csvSchemaFile != "":
+		return csvSchemaFile
+	case driverName == POSTGRES && os.Getenv("PGDATABASE") != "":
+		return os.Getenv("PGDATABASE")
+	case driverName == SQLSERVER && os.Getenv("SQLCMDDBNAME") != "":
+		return os.Getenv("SQLCMDDBNAME")
+	case driverName == "":
+		return PGDUMP
+	}
+	return driverName
 }
 
-func getPassword() string {
-	password := os.Getenv("PGPASSWORD")
+// getPassword returns the password in environment variable env, or
+// else asks for it.
+func getPassword(env string) string {
+	password := os.Getenv(env)
 	if password != "" {
 		return password
 	}
@@ -611,30 +1260,6 @@ func getPassword() string {
 	return strings.TrimSpace(string(bytePassword))
 }
 
-// analyzeError inspects an error returned from Cloud Spanner and adds information
-// about potential root causes e.g. authentication issues.
-func analyzeError(err error, project, instance string) error {
-	e := strings.ToLower(err.Error())
-	if containsAny(e, []string{"unauthenticated", "cannot fetch token", "default credentials"}) {
-		return fmt.Errorf("%w.\n"+`
-Possible cause: credentials are mis-configured. Do you need to run
-
-  gcloud auth application-default login
-
-or configure environment variable GOOGLE_APPLICATION_CREDENTIALS.
-See https://cloud.google.com/docs/authentication/getting-started.
-`, err)
-	}
-	if containsAny(e, []string{"instance not found"}) && instance != "" {
-		return fmt.Errorf("%w.\n"+`
-Possible cause: Spanner instance specified via instance option does not exist.
-Please check that '%s' is correct and that it is a valid Spanner
-instance for project %s.
-`, err, instance, project)
-	}
-	return err
-}
-
 func printPermissionsWarning(out *os.File) {
 	fmt.Fprintf(out,
 		`
@@ -648,47 +1273,101 @@ ACLs are dropped during conversion since they are not supported by Spanner.
 `)
 }
 
-func printSeekError(err error, out *os.File) {
-	fmt.Fprintf(out, "\nCan't get seekable input file: %v\n", err)
-	fmt.Fprintf(out, "Likely cause: not enough space in %s.\n", os.TempDir())
-	fmt.Fprintf(out, "Try writing pg_dump output to a file first i.e.\n")
-	fmt.Fprintf(out, "  pg_dump > tmpfile\n")
-	fmt.Fprintf(out, "  harbourbridge < tmpfile\n")
+func readTableOptions(name string) (map[string]conversion.TableOptions, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't open table options file: %w", err)
+	}
+	defer f.Close()
+	return conversion.ReadTableOptions(f)
 }
 
-func containsAny(s string, l []string) bool {
-	for _, a := range l {
-		if strings.Contains(s, a) {
-			return true
-		}
+func readSession(name string) (*conversion.Session, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't open session file: %w", err)
 	}
-	return false
+	defer f.Close()
+	return conversion.ReadSession(f)
 }
 
-func generateName(prefix string) (string, error) {
-	b := make([]byte, 4)
-	_, err := rand.Read(b)
+func readTypeMap(name string) (conversion.TypeMap, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		return "", fmt.Errorf("error generating name: %w", err)
-
+		return nil, fmt.Errorf("can't open type map file: %w", err)
 	}
-	return fmt.Sprintf("%s_%x-%x", prefix, b[0:2], b[2:4]), nil
+	defer f.Close()
+	return conversion.ReadTypeMap(f)
 }
 
-func getClient(db string) (*sp.Client, error) {
-	ctx := context.Background()
-	return sp.NewClient(ctx, db)
+// readCSVSchema reads the -csv-schema file. Relative file names in it
+// are resolved against the directory of the schema file.
+func readCSVSchema(name string) (*conversion.CSVSchema, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't open CSV schema file: %w", err)
+	}
+	defer f.Close()
+	s, err := conversion.ReadCSVSchema(f)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(name)
+	for i := range s.Tables {
+		for j, file := range s.Tables[i].Files {
+			if !strings.HasPrefix(file, "gs://") && !filepath.IsAbs(file) {
+				s.Tables[i].Files[j] = filepath.Join(dir, file)
+			}
+		}
+	}
+	return s, nil
 }
 
-func getSize(f *os.File) (int64, error) {
-	info, err := f.Stat()
+func readColumnTransforms(name string) (conversion.ColumnTransforms, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		return 0, fmt.Errorf("can't stat file: %w", err)
+		return nil, fmt.Errorf("can't open column transforms file: %w", err)
 	}
-	return info.Size(), nil
+	defer f.Close()
+	return conversion.ReadColumnTransforms(f)
 }
 
 // setupLogfile configures the file used for logs.
+// statusLogger returns the logger for status messages: out, unless
+// diagnostics are logged as JSON, in which case status messages are
+// logged too, so that they're in the same stream of JSON objects.
+func statusLogger(out io.Writer) conversion.Logger {
+	if f, _ := conversion.ParseLogFormat(logFormat); f == conversion.LogJSON {
+		return internal.Log()
+	}
+	return log.New(out, "", 0)
+}
+
+// issueOverrides returns the schema issue overrides given by
+// -suppress-issues and -issue-severity-overrides.
+func issueOverrides() (conversion.IssueOverrides, error) {
+	severities, err := conversion.ParseIssueSeverities(issueSeverities)
+	if err != nil {
+		return conversion.IssueOverrides{}, err
+	}
+	o := conversion.IssueOverrides{Suppress: conversion.ParseIssueNames(suppressIssues), Severity: severities}
+	return o, o.Validate()
+}
+
+// serveMetrics serves metrics about the conversion at /metrics on addr
+// (see -metrics-addr), until the program exits.
+func serveMetrics(addr string) (*conversion.Metrics, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("can't serve metrics: %w", err)
+	}
+	m := conversion.NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(l, mux)
+	return m, nil
+}
+
 // By default we just drop logs on the floor. To enable them (e.g. to debug
 // Cloud Spanner client library issues), set logfile to a non-empty filename.
 // Note: this tool itself doesn't generate logs, but some of the libraries it
@@ -714,15 +1393,3 @@ func close(f *os.File) {
 		f.Close()
 	}
 }
-
-func sum(m map[string]int64) int64 {
-	n := int64(0)
-	for _, c := range m {
-		n += c
-	}
-	return n
-}
-
-func getBanner(now time.Time, db string) string {
-	return fmt.Sprintf("Generated at %s for db %s\n\n", now.Format("2006-01-02 15:04:05"), db)
-}
@@ -32,16 +32,20 @@ type Table struct {
 	ColDefs     map[string]Column // Details of columns.
 	PrimaryKeys []Key
 	Indexes     []Index
+	ForeignKeys []ForeignKey
+	Checks      []Check // Check constraints on several columns.
+	Comment     string  // From COMMENT ON TABLE (empty if none).
 }
 
 // Column represents a database column.
-// TODO: add support for foreign keys.
 type Column struct {
 	Name    string
 	Type    Type
 	NotNull bool
 	Unique  bool
 	Ignored Ignored
+	Domain  string // Domain the column was declared with, if any (Type is the domain's base type).
+	Comment string // From COMMENT ON COLUMN (empty if none).
 }
 
 // Key respresents a primary key or index key.
@@ -52,8 +56,38 @@ type Key struct {
 
 // Index represents a database index.
 type Index struct {
-	Name string
-	Keys []Key
+	Name    string
+	Keys    []Key
+	Unique  bool
+	Storing []string // Non-key columns included in the index (e.g. PostgreSQL's INCLUDE).
+	Ignored IndexIgnored
+}
+
+// IndexIgnored represents index properties that prevent an index from
+// being converted. As with Ignored, we drop the details, but retain
+// presence/absence for reporting purposes.
+type IndexIgnored struct {
+	Expression bool   // Some keys are expressions rather than columns.
+	Predicate  bool   // Partial index i.e. it has a WHERE clause.
+	Method     string // Unsupported access method (e.g. gin). Empty if supported.
+}
+
+// Check represents a check constraint on several columns. Check
+// constraints on a single column are recorded in the column's Ignored.
+type Check struct {
+	Name    string   // Empty if the constraint is unnamed.
+	Columns []string // Columns used by the constraint, in order of first use.
+}
+
+// ForeignKey represents a foreign key constraint. Columns and
+// ReferColumns are in constraint order.
+type ForeignKey struct {
+	Name         string // Empty if the constraint is unnamed.
+	Columns      []string
+	ReferTable   string
+	ReferColumns []string // Empty means the primary key of ReferTable.
+	OnDelete     string   // Referential action: NO ACTION, RESTRICT, CASCADE, SET NULL or SET DEFAULT.
+	OnUpdate     string   // Referential action, as for OnDelete.
 }
 
 // Type represents the type of a column.
@@ -67,9 +101,11 @@ type Type struct {
 // represented. We drop the details, but retain presence/absence for
 // reporting purposes.
 type Ignored struct {
-	Check      bool
-	Identity   bool
-	Default    bool
-	Exclusion  bool
-	ForeignKey bool
+	Check     bool
+	Identity  bool
+	Default   bool
+	Exclusion bool
+	// Defaults whose values increase over time (both imply Default).
+	Sequence bool // The default is the next value of a sequence e.g. nextval('t_id_seq').
+	Now      bool // The default is the current time e.g. now().
 }
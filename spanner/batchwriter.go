@@ -18,13 +18,16 @@
 package spanner
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
-	"unsafe"
 
 	sp "cloud.google.com/go/spanner"
+	"google.golang.org/grpc/codes"
 )
 
 // Parameters used to control building batches to write to Spanner.
@@ -33,14 +36,30 @@ import (
 // not to exceed Spanner's limits. Also, sending huge RPCs is potentially
 // unreliable.
 const (
-	countThreshold = 10 * 1000    // Spanner per-operation limit is 20,000.
-	byteThreshold  = 20 * 1 << 20 // Spanner per-operation limit is 100MB.
+	countThreshold = 10 * 1000     // Spanner per-operation limit is 20,000.
+	byteThreshold  = 20 * 1 << 20  // Spanner per-operation limit is 100MB. Default for BatchWriterConfig.BatchBytes.
+	rowBytesLimit  = 100 * 1 << 20 // Spanner per-operation limit. Default for BatchWriterConfig.RowBytesLimit.
 )
 
+// Parameters used to control retries of writes that fail with transient
+// errors (see retryable). Delays between attempts start at
+// initialBackoff and double on each attempt, up to maxBackoff.
+const (
+	defaultCommitAttempts    = 5
+	defaultCommitRetryBudget = time.Minute
+	initialBackoff           = 100 * time.Millisecond
+	maxBackoff               = 10 * time.Second
+)
+
+// ErrRowTooLarge is the error given (see BatchWriterConfig.OnDrop) for
+// rows that are dropped because they exceed Spanner's commit size limit.
+var ErrRowTooLarge = errors.New("row exceeds Spanner's commit size limit")
+
 // BatchWriter accumulates rows of data (via AddRow) and assembles them
 // into batches that it asynchronously writes to Spanner.  Rows are
 // written to Spanner using insert semantics i.e. if a row already exists
-// in the database, the row will fail with error 'AlreadyExists'.  If
+// in the database, the row will fail with error 'AlreadyExists' (unless
+// BatchWriterConfig.Upsert is set).  If
 // Spanner returns an error for a batch, BatchWriter splits the batch
 // into smaller chunks to retry, as it attempts to isolate which row(s)
 // in a batch is bad.  BatchWriter respects Spanner's limits on byte size
@@ -57,8 +76,17 @@ type BatchWriter struct {
 	wg         sync.WaitGroup             // Tracks in-progress writes.
 	writeLimit int64                      // Limit on number of in-progress writes.
 	bytesLimit int64                      // Limit on bytes buffered. AddRow blocks if rBytes exceeded this value.
+	batchBytes int64                      // Batches are written once they reach this many bytes.
+	rowLimit   int64                      // Rows bigger than this many bytes are dropped, rather than written.
 	retryLimit int64                      // Limit on retries.
-	verbose    bool                       // If true, print out messages about each write batch.
+	attempts   int64                      // Limit on attempts for each write that fails with transient errors.
+	budget     time.Duration              // Limit on time spent backing off for each write that fails with transient errors.
+	sleep      func(time.Duration)        // Typically time.Sleep, but structured this way for testing.
+	log        Logger                     // Receives log messages about writes.
+	onDrop     DropFunc                   // If non-nil, called for each dropped row.
+	onDone     DoneFunc                   // If non-nil, called for each batch of rows written or dropped.
+	onRetry    func()                     // If non-nil, called for each commit retry.
+	upsert     bool                       // If true, use insert-or-update semantics.
 	async      asyncState
 }
 
@@ -66,6 +94,7 @@ type row struct {
 	table string
 	cols  []string
 	vals  []interface{}
+	id    int64 // Caller-supplied ID (see AddRowWithID).
 }
 
 // Fields in this struct are modified asynchronously e.g. by go routines writing
@@ -78,45 +107,130 @@ type row struct {
 type asyncState struct {
 	writes             int64            // Number of in-progress writes; access using atomic.
 	retries            int64            // Number of retries; access using atomic.
+	commitRetries      int64            // Number of retries of writes that failed with transient errors; access using atomic.
 	lock               sync.Mutex       // Protects errors and badRows
 	errors             map[string]int64 // Errors encountered; protected by lock.
 	sampleBadRows      []*row           // A sample of rows that generated errors; protected by lock.
 	sampleBadRowsBytes int64            // Estimate of bytes for sampleBadRows; protected by lock.
 	droppedRows        map[string]int64 // Count of dropped rows, broken down by table.
+	tooLargeRows       map[string]int64 // Count of rows dropped because they exceed rowLimit, broken down by table (also counted in droppedRows).
+	abandoned          int32            // Set (to 1) by Abandon; access using atomic.
+	abandonedRows      map[string]int64 // Count of rows abandoned (see Abandon), broken down by table; protected by lock.
+
+	// Count of rows dropped because Spanner rejected them, broken down
+	// by table and error code; protected by lock.
+	droppedCodes map[string]map[codes.Code]int64
 }
 
 // BatchWriterConfig specifies parameters for configuring BatchWriter.
 type BatchWriterConfig struct {
-	WriteLimit int64                      // Limit on number of in-progress writes.
-	BytesLimit int64                      // Limit on bytes buffered.
-	RetryLimit int64                      // Limit on retries.
-	Write      func([]*sp.Mutation) error // Function to call to write to Spanner (typically a closure that calls client.Apply).
-	Verbose    bool                       // If true, print out messages about each write batch.
+	WriteLimit    int64                      // Limit on number of in-progress writes.
+	BytesLimit    int64                      // Limit on bytes buffered.
+	BatchBytes    int64                      // Limit on (estimated) bytes in each write. If zero, 20MB is used.
+	RowBytesLimit int64                      // Rows whose (estimated) size exceeds this are dropped. If zero, Spanner's 100MB commit limit is used.
+	RetryLimit    int64                      // Limit on retries.
+	Write         func([]*sp.Mutation) error // Function to call to write to Spanner (typically a closure that calls client.Apply).
+	Log           Logger                     // If non-nil, receives log messages about writes.
+
+	// Writes that fail with transient errors (ABORTED, UNAVAILABLE or
+	// DEADLINE_EXCEEDED) are retried with exponential backoff, up to
+	// CommitAttempts attempts (if zero, 5) and CommitRetryBudget of
+	// backoff (if zero, 1 minute) for each write.
+	CommitAttempts    int64
+	CommitRetryBudget time.Duration
+
+	OnDrop  DropFunc // If non-nil, called for each dropped row.
+	OnDone  DoneFunc // If non-nil, called for each batch of rows written or dropped.
+	OnRetry func()   // If non-nil, called (from the writing goroutine) for each retry of a write that failed with a transient error.
+	Upsert  bool     // If true, rows are written with insert-or-update semantics, so that existing rows are overwritten.
 }
 
+// Logger receives log messages about writes: debug messages about each
+// batch, and warnings about dropped rows. internal.Logger satisfies it.
+type Logger interface {
+	Debugf(format string, a ...interface{})
+	Warnf(format string, a ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, a ...interface{}) {}
+func (nopLogger) Warnf(format string, a ...interface{})  {}
+
+// DropFunc is called with each row that BatchWriter drops, and the error
+// that caused it to be dropped (ErrRowTooLarge for rows that exceed
+// Spanner's commit size limit). It must be threadsafe, since it is called
+// from the goroutines writing to Spanner.
+type DropFunc func(table string, cols []string, vals []interface{}, err error)
+
+// DoneFunc is called with the IDs (see AddRowWithID) of rows once they
+// have been written to Spanner (err is nil) or dropped (err is the error
+// that caused them to be dropped). Every row is passed to DoneFunc exactly
+// once. It must be threadsafe, since it is called from the goroutines
+// writing to Spanner.
+type DoneFunc func(ids []int64, err error)
+
 // NewBatchWriter returns a new BatchWriter with parameters defined by config.
 func NewBatchWriter(config BatchWriterConfig) *BatchWriter {
-	return &BatchWriter{
+	bw := &BatchWriter{
 		write:      config.Write,
 		writeLimit: config.WriteLimit,
 		bytesLimit: config.BytesLimit,
+		batchBytes: config.BatchBytes,
+		rowLimit:   config.RowBytesLimit,
 		retryLimit: config.RetryLimit,
-		verbose:    config.Verbose,
+		attempts:   config.CommitAttempts,
+		budget:     config.CommitRetryBudget,
+		sleep:      time.Sleep,
+		onDrop:     config.OnDrop,
+		onDone:     config.OnDone,
+		onRetry:    config.OnRetry,
+		upsert:     config.Upsert,
+		log:        config.Log,
 		async: asyncState{
-			errors:      make(map[string]int64),
-			droppedRows: make(map[string]int64),
+			errors:       make(map[string]int64),
+			droppedRows:  make(map[string]int64),
+			tooLargeRows: make(map[string]int64),
 		},
 	}
+	if bw.log == nil {
+		bw.log = nopLogger{}
+	}
+	if bw.batchBytes == 0 {
+		bw.batchBytes = byteThreshold
+	}
+	if bw.rowLimit == 0 {
+		bw.rowLimit = rowBytesLimit
+	}
+	if bw.attempts == 0 {
+		bw.attempts = defaultCommitAttempts
+	}
+	if bw.budget == 0 {
+		bw.budget = defaultCommitRetryBudget
+	}
+	return bw
 }
 
 // AddRow appends a new row of data to bw's buffer of rows. Depending on the
 // state of BatchWriter, AddRow may immediately return, or it may initiate writes,
 // or it may block (waiting for some of the writes already in progress to
-// complete) and then initiate writes.
+// complete) and then initiate writes. Rows that are too large to write
+// to Spanner are dropped (see TooLargeRowsByTable).
 func (bw *BatchWriter) AddRow(table string, cols []string, vals []interface{}) {
-	r := &row{table, cols, vals}
+	bw.AddRowWithID(table, cols, vals, 0)
+}
+
+// AddRowWithID is like AddRow, but also records id, which is passed to
+// BatchWriterConfig.OnDone once the row has been written or dropped.
+func (bw *BatchWriter) AddRowWithID(table string, cols []string, vals []interface{}, id int64) {
+	r := &row{table, cols, vals, id}
+	n := byteSize(r)
+	if n > bw.rowLimit {
+		bw.tooLarge(r, n)
+		return
+	}
 	bw.rows = append(bw.rows, r)
-	bw.rBytes += byteSize(r)
+	bw.rBytes += n
 	bw.rCount += int64(len(r.cols))
 	bw.writeData()
 }
@@ -127,10 +241,8 @@ func (bw *BatchWriter) Flush() {
 	for len(bw.rows) > 0 {
 		if atomic.LoadInt64(&bw.async.writes) < bw.writeLimit {
 			m, count, bytes := bw.getBatch()
-			if bw.verbose {
-				fmt.Printf("Starting write of %d rows to Spanner (%d bytes, %d mutations) [%d in progress]\n",
-					len(m), bytes, count, atomic.LoadInt64(&bw.async.writes))
-			}
+			bw.log.Debugf("Starting write of %d rows to Spanner (%d bytes, %d mutations) [%d in progress]",
+				len(m), bytes, count, atomic.LoadInt64(&bw.async.writes))
 			bw.startWrite(m)
 		} else {
 			time.Sleep(10 * time.Millisecond)
@@ -139,6 +251,63 @@ func (bw *BatchWriter) Flush() {
 	bw.wg.Wait()
 }
 
+// Abandon stops bw writing rows, e.g. because the conversion was
+// interrupted: buffered rows are discarded rather than written, and
+// writes in progress that fail aren't retried, their rows being
+// discarded too. Discarded rows are abandoned, rather than dropped:
+// they aren't passed to BatchWriterConfig.OnDrop or OnDone, and are
+// counted by AbandonedRowsByTable. Abandon waits up to timeout for
+// writes in progress to complete, and returns whether they did; if
+// not, the caller can cancel them (e.g. via the context passed to
+// client.Apply) and wait for them with Flush.
+func (bw *BatchWriter) Abandon(timeout time.Duration) bool {
+	atomic.StoreInt32(&bw.async.abandoned, 1)
+	bw.abandon(bw.rows)
+	bw.rows = nil
+	bw.rBytes = 0
+	bw.rCount = 0
+	done := make(chan struct{})
+	go func() {
+		bw.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// AbandonedRowsByTable returns a map of tables to counts of rows
+// abandoned because of a call to Abandon.
+func (bw *BatchWriter) AbandonedRowsByTable() map[string]int64 {
+	m := make(map[string]int64)
+	bw.async.lock.Lock()
+	defer bw.async.lock.Unlock()
+	for t, n := range bw.async.abandonedRows {
+		m[t] = n
+	}
+	return m
+}
+
+// abandon records that rows were abandoned.
+func (bw *BatchWriter) abandon(rows []*row) {
+	bw.async.lock.Lock()
+	defer bw.async.lock.Unlock()
+	if bw.async.abandonedRows == nil {
+		bw.async.abandonedRows = make(map[string]int64)
+	}
+	for _, x := range rows {
+		bw.async.abandonedRows[x.table]++
+	}
+}
+
+// abandoned returns whether Abandon has been called.
+func (bw *BatchWriter) abandoned() bool {
+	return atomic.LoadInt32(&bw.async.abandoned) == 1
+}
+
 // DroppedRowsByTable returns a map of tables to counts of dropped rows.
 // Dropped rows are rows that were not written to Spanner.
 func (bw *BatchWriter) DroppedRowsByTable() map[string]int64 {
@@ -153,6 +322,42 @@ func (bw *BatchWriter) DroppedRowsByTable() map[string]int64 {
 	return m
 }
 
+// TooLargeRowsByTable returns a map of tables to counts of rows that
+// were dropped because they exceed Spanner's commit size limit. These
+// rows are also included in DroppedRowsByTable.
+func (bw *BatchWriter) TooLargeRowsByTable() map[string]int64 {
+	m := make(map[string]int64)
+	bw.async.lock.Lock()
+	defer bw.async.lock.Unlock()
+	for t, n := range bw.async.tooLargeRows {
+		m[t] = n
+	}
+	return m
+}
+
+// DroppedRowsByCode returns a map of tables to counts of rows that were
+// dropped because Spanner rejected them, broken down by the gRPC code
+// of the error (e.g. "FailedPrecondition"). These rows are also
+// included in DroppedRowsByTable.
+func (bw *BatchWriter) DroppedRowsByCode() map[string]map[string]int64 {
+	m := make(map[string]map[string]int64)
+	bw.async.lock.Lock()
+	defer bw.async.lock.Unlock()
+	for t, c := range bw.async.droppedCodes {
+		m[t] = make(map[string]int64)
+		for code, n := range c {
+			m[t][code.String()] = n
+		}
+	}
+	return m
+}
+
+// CommitRetries returns the number of times writes were retried because
+// they failed with transient errors.
+func (bw *BatchWriter) CommitRetries() int64 {
+	return atomic.LoadInt64(&bw.async.commitRetries)
+}
+
 // SampleBadRows returns a string-formatted list of sample rows that
 // generated errors. Returns at most n rows.
 // Note that we split up batches to isolate errors. Each row returned
@@ -189,7 +394,7 @@ func (bw *BatchWriter) getBadRowsForTest() []*row {
 }
 
 // getBatch returns a slice of data from the front of bw.rows.  The slice
-// returned is the largest one not exceeding countThreshold and byteThreshold.
+// returned is the largest one not exceeding countThreshold and bw.batchBytes.
 func (bw *BatchWriter) getBatch() (rows []*row, count int64, bytes int64) {
 	for i, _ := range bw.rows {
 		c := count + int64(len(bw.rows[i].cols))
@@ -198,7 +403,7 @@ func (bw *BatchWriter) getBatch() (rows []*row, count int64, bytes int64) {
 		// we have at least one row. If a single row puts us over the
 		// thresholds, there's not much we can do: we just try sending it to Spanner
 		// (it might succeed, since our thresholds are conservative).
-		if (c >= countThreshold || b >= byteThreshold) && len(rows) >= 1 {
+		if (c >= countThreshold || b >= bw.batchBytes) && len(rows) >= 1 {
 			bw.rCount -= count
 			bw.rBytes -= bytes
 			bw.rows = bw.rows[i:]
@@ -215,8 +420,10 @@ func (bw *BatchWriter) getBatch() (rows []*row, count int64, bytes int64) {
 }
 
 func (bw *BatchWriter) errorStats(rows []*row, err error, retry bool) {
-	if bw.verbose {
-		fmt.Printf("Error while writing %d rows to Spanner: %v\n", len(rows), err)
+	if retry {
+		bw.log.Debugf("Error while writing %d rows to Spanner (retrying in smaller batches): %v", len(rows), err)
+	} else {
+		bw.log.Warnf("Error while writing %d rows of table %s to Spanner (dropping them): %v", len(rows), rows[0].table, err)
 	}
 
 	bw.async.lock.Lock()
@@ -237,26 +444,78 @@ func (bw *BatchWriter) errorStats(rows []*row, err error, retry bool) {
 			bw.async.sampleBadRowsBytes += n
 		}
 	}
+	if bw.async.droppedCodes == nil {
+		bw.async.droppedCodes = make(map[string]map[codes.Code]int64)
+	}
+	code := sp.ErrCode(err)
 	for _, x := range rows {
 		bw.async.droppedRows[x.table]++
+		if bw.async.droppedCodes[x.table] == nil {
+			bw.async.droppedCodes[x.table] = make(map[codes.Code]int64)
+		}
+		bw.async.droppedCodes[x.table][code]++
 	}
 	return
 }
 
+// tooLarge records that row r (of estimated size n bytes) was dropped
+// because it exceeds bw.rowLimit. We don't keep r as a sample bad row,
+// since it would use a lot of memory and make the bad-data file huge.
+func (bw *BatchWriter) tooLarge(r *row, n int64) {
+	bw.log.Warnf("Dropping row of table %s: size (%d bytes) exceeds Spanner's commit size limit", r.table, n)
+	bw.async.lock.Lock()
+	bw.async.errors[ErrRowTooLarge.Error()]++
+	bw.async.droppedRows[r.table]++
+	bw.async.tooLargeRows[r.table]++
+	bw.async.lock.Unlock()
+	if bw.onDrop != nil {
+		bw.onDrop(r.table, r.cols, r.vals, ErrRowTooLarge)
+	}
+	bw.done([]*row{r}, ErrRowTooLarge)
+}
+
+// done calls bw.onDone (if set) for rows.
+func (bw *BatchWriter) done(rows []*row, err error) {
+	if bw.onDone == nil {
+		return
+	}
+	ids := make([]int64, len(rows))
+	for i, x := range rows {
+		ids[i] = x.id
+	}
+	bw.onDone(ids, err)
+}
+
 // Note: doWriteAndHandleErrors must be thread-safe because it is run
 // inside a go routine.
 func (bw *BatchWriter) doWriteAndHandleErrors(rows []*row) {
 	var m []*sp.Mutation
 	for _, x := range rows {
-		m = append(m, sp.Insert(x.table, x.cols, x.vals))
+		if bw.upsert {
+			m = append(m, sp.InsertOrUpdate(x.table, x.cols, x.vals))
+		} else {
+			m = append(m, sp.Insert(x.table, x.cols, x.vals))
+		}
 	}
-	if err := bw.write(m); err != nil {
+	err := bw.commit(m)
+	if err == nil {
+		bw.log.Debugf("Wrote %d rows to Spanner", len(rows))
+		bw.done(rows, nil)
+	} else if bw.abandoned() {
+		bw.abandon(rows)
+	} else {
 		hitRetryLimit := atomic.LoadInt64(&bw.async.retries) >= bw.retryLimit
 		retry := len(rows) > 1 && !hitRetryLimit
 		bw.errorStats(rows, err, retry)
 		if !retry {
-			if hitRetryLimit && bw.verbose {
-				fmt.Printf("Have hit %d retries: will not do any more\n", atomic.LoadInt64(&bw.async.retries))
+			if bw.onDrop != nil {
+				for _, x := range rows {
+					bw.onDrop(x.table, x.cols, x.vals, err)
+				}
+			}
+			bw.done(rows, err)
+			if hitRetryLimit {
+				bw.log.Debugf("Have hit %d retries: will not do any more", atomic.LoadInt64(&bw.async.retries))
 			}
 			return
 		}
@@ -279,6 +538,46 @@ func (bw *BatchWriter) doWriteAndHandleErrors(rows []*row) {
 	}
 }
 
+// commit writes m to Spanner, retrying with exponential backoff and
+// jitter if the write fails with a transient error. It gives up (and
+// returns the error) after bw.attempts attempts, or once the next delay
+// would take the total time spent backing off over bw.budget.
+// Note: commit must be thread-safe because it is run inside a go routine.
+func (bw *BatchWriter) commit(m []*sp.Mutation) error {
+	delay := initialBackoff
+	var waited time.Duration
+	for attempt := int64(1); ; attempt++ {
+		err := bw.write(m)
+		if err == nil || !retryable(err) || attempt >= bw.attempts || waited+delay > bw.budget || bw.abandoned() {
+			return err
+		}
+		// Jitter: wait between half and all of delay, so that concurrent
+		// writes that failed together don't retry together.
+		d := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		bw.log.Debugf("Retrying write of %d rows to Spanner in %v (attempt %d failed: %v)", len(m), d, attempt, err)
+		bw.sleep(d)
+		waited += d
+		atomic.AddInt64(&bw.async.commitRetries, 1)
+		if bw.onRetry != nil {
+			bw.onRetry()
+		}
+		delay *= 2
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+}
+
+// retryable returns true if err is a transient Spanner error, for which
+// retrying the write may succeed.
+func retryable(err error) bool {
+	switch sp.ErrCode(err) {
+	case codes.Aborted, codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
 // Note: backgroundWrite must be thread-safe because it is run as
 // a go routine.
 func (bw *BatchWriter) backgroundWrite(rows []*row) {
@@ -299,13 +598,11 @@ func (bw *BatchWriter) startWrite(rows []*row) {
 // b) we've hit writeLimit and we're under bytesLimit.
 // It will block and re-try till either (a) or (b) holds.
 func (bw *BatchWriter) writeData() {
-	for bw.rCount > countThreshold || bw.rBytes > byteThreshold {
+	for bw.rCount > countThreshold || bw.rBytes > bw.batchBytes {
 		if atomic.LoadInt64(&bw.async.writes) < bw.writeLimit {
 			m, count, bytes := bw.getBatch()
-			if bw.verbose {
-				fmt.Printf("Starting write of %d rows to Spanner (%d bytes, %d mutations) [%d in progress]\n",
-					len(m), bytes, count, atomic.LoadInt64(&bw.async.writes))
-			}
+			bw.log.Debugf("Starting write of %d rows to Spanner (%d bytes, %d mutations) [%d in progress]",
+				len(m), bytes, count, atomic.LoadInt64(&bw.async.writes))
 			bw.startWrite(m)
 		} else {
 			if bw.rBytes < bw.bytesLimit {
@@ -316,18 +613,55 @@ func (bw *BatchWriter) writeData() {
 	}
 }
 
+// byteSize returns an estimate of the size of r when encoded as a
+// Spanner mutation.
 func byteSize(r *row) int64 {
 	n := int64(len(r.table))
 	for _, c := range r.cols {
 		n += int64(len(c))
 	}
 	for _, v := range r.vals {
-		switch x := v.(type) {
-		case string:
-			n += int64(len(x))
-		default:
-			n += int64(unsafe.Sizeof(v))
-		}
+		n += valueSize(v)
 	}
 	return n
 }
+
+// valueSize returns an estimate of the encoded size of v. Strings and
+// bytes (including array elements) usually dominate the size of a row,
+// so they are counted accurately; other values are counted as 8 bytes.
+func valueSize(v interface{}) int64 {
+	switch x := v.(type) {
+	case string:
+		return int64(len(x))
+	case []byte:
+		return base64Size(len(x)) // Bytes are base64 encoded in mutations.
+	case sp.NullString:
+		return int64(len(x.StringVal))
+	case []sp.NullString:
+		n := int64(0)
+		for _, s := range x {
+			n += int64(len(s.StringVal))
+		}
+		return n
+	case []string:
+		n := int64(0)
+		for _, s := range x {
+			n += int64(len(s))
+		}
+		return n
+	case [][]byte:
+		n := int64(0)
+		for _, b := range x {
+			n += base64Size(len(b))
+		}
+		return n
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+		return 8 * int64(rv.Len())
+	}
+	return 8
+}
+
+func base64Size(n int) int64 {
+	return int64((n + 2) / 3 * 4)
+}
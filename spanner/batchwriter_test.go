@@ -26,6 +26,8 @@ import (
 
 	sp "cloud.google.com/go/spanner"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // TestFlush tests NewBatchWriter, AddRow and Flush.
@@ -45,7 +47,6 @@ func TestFlush(t *testing.T) {
 	}
 	config := BatchWriterConfig{
 		BytesLimit: 100 << 20,
-		Verbose:    false,
 		RetryLimit: 1000,
 	}
 	for _, tc := range tests {
@@ -93,6 +94,205 @@ func TestFlush(t *testing.T) {
 	}
 }
 
+func TestBatchBytes(t *testing.T) {
+	var mutex sync.Mutex
+	var writes [][]*sp.Mutation
+	var dropped []error
+	bw := NewBatchWriter(BatchWriterConfig{
+		WriteLimit:    40,
+		BytesLimit:    100 << 20,
+		BatchBytes:    1000,
+		RowBytesLimit: 2000,
+		RetryLimit:    1000,
+		OnDrop: func(table string, cols []string, vals []interface{}, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			dropped = append(dropped, err)
+		},
+		Write: func(m []*sp.Mutation) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			writes = append(writes, m)
+			return nil
+		},
+	})
+	// Each row is about 300 bytes (mostly the bytes value, which is
+	// base64 encoded), so batches hold at most 3 rows.
+	for i := 0; i < 10; i++ {
+		bw.AddRow("t", []string{"id", "b"}, []interface{}{int64(i), make([]byte, 225)})
+	}
+	bw.AddRow("big", []string{"id", "s"}, []interface{}{int64(0), strings.Repeat("x", 2000)})
+	bw.AddRow("big", []string{"id", "a"}, []interface{}{int64(1), []sp.NullString{{StringVal: strings.Repeat("x", 2000), Valid: true}}})
+	bw.Flush()
+	n := 0
+	for _, m := range writes {
+		assert.LessOrEqual(t, len(m), 3)
+		n += len(m)
+	}
+	assert.Equal(t, 10, n)
+	assert.Equal(t, map[string]int64{"big": 2}, bw.TooLargeRowsByTable())
+	assert.Equal(t, map[string]int64{"big": 2}, bw.DroppedRowsByTable())
+	assert.Equal(t, map[string]int64{"row exceeds Spanner's commit size limit": 2}, bw.Errors())
+	assert.Empty(t, bw.SampleBadRows(10))
+	assert.Equal(t, []error{ErrRowTooLarge, ErrRowTooLarge}, dropped)
+}
+
+func TestCommitRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		errs     []error // Errors returned by successive writes; nil once exhausted.
+		attempts int64
+		budget   time.Duration
+		retries  int64
+		dropped  int64
+		code     codes.Code // Code of the dropped row's error.
+	}{
+		{name: "no errors", retries: 0},
+		{name: "transient", errs: []error{status.Error(codes.Aborted, "aborted"), status.Error(codes.Unavailable, "unavailable")}, retries: 2},
+		{name: "deadline", errs: []error{status.Error(codes.DeadlineExceeded, "deadline")}, retries: 1},
+		{name: "not retryable", errs: []error{status.Error(codes.AlreadyExists, "exists")}, retries: 0, dropped: 1, code: codes.AlreadyExists},
+		{name: "attempts exhausted", errs: []error{status.Error(codes.Aborted, "1"), status.Error(codes.Aborted, "2"), status.Error(codes.Aborted, "3")}, attempts: 3, retries: 2, dropped: 1, code: codes.Aborted},
+		// Delays are at least 50ms, then 100ms, so the second retry would
+		// exceed the budget.
+		{name: "budget exhausted", errs: []error{status.Error(codes.Aborted, "1"), status.Error(codes.Aborted, "2"), status.Error(codes.Aborted, "3")}, budget: 120 * time.Millisecond, retries: 1, dropped: 1, code: codes.Aborted},
+	}
+	for _, tc := range tests {
+		calls := 0
+		var onRetry int64
+		bw := NewBatchWriter(BatchWriterConfig{
+			WriteLimit:        1,
+			BytesLimit:        100 << 20,
+			RetryLimit:        1000,
+			CommitAttempts:    tc.attempts,
+			CommitRetryBudget: tc.budget,
+			Write: func(m []*sp.Mutation) error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			},
+			OnRetry: func() { onRetry++ },
+		})
+		var slept []time.Duration
+		bw.sleep = func(d time.Duration) { slept = append(slept, d) }
+		bw.AddRow("t", []string{"id"}, []interface{}{int64(1)})
+		bw.Flush()
+		assert.Equal(t, tc.retries, bw.CommitRetries(), tc.name)
+		assert.Equal(t, tc.retries, onRetry, tc.name)
+		assert.Equal(t, tc.dropped, bw.DroppedRowsByTable()["t"], tc.name)
+		if tc.dropped > 0 {
+			assert.Equal(t, map[string]map[string]int64{"t": {tc.code.String(): tc.dropped}}, bw.DroppedRowsByCode(), tc.name)
+		}
+		assert.Equal(t, int(tc.retries), len(slept), tc.name)
+		for i, d := range slept {
+			// Exponential backoff with jitter.
+			max := initialBackoff << uint(i)
+			assert.True(t, d >= max/2 && d <= max, fmt.Sprintf("%s: delay %d is %v", tc.name, i, d))
+		}
+	}
+}
+
+func TestOnDone(t *testing.T) {
+	var mutex sync.Mutex
+	written := make(map[int64]int)
+	dropped := make(map[int64]error)
+	bw := NewBatchWriter(BatchWriterConfig{
+		WriteLimit:    40,
+		BytesLimit:    100 << 20,
+		BatchBytes:    100,
+		RowBytesLimit: 1000,
+		RetryLimit:    1000,
+		Write: func(m []*sp.Mutation) error {
+			// Rows with negative id are bad.
+			for _, x := range m {
+				if strings.Contains(fmt.Sprint(x), "-") {
+					return fmt.Errorf("bad row")
+				}
+			}
+			return nil
+		},
+		OnDone: func(ids []int64, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, id := range ids {
+				if err != nil {
+					dropped[id] = err
+				} else {
+					written[id]++
+				}
+			}
+		},
+	})
+	for i := int64(1); i <= 20; i++ {
+		v := i
+		if i == 7 {
+			v = -i
+		}
+		bw.AddRowWithID("t", []string{"id"}, []interface{}{v}, i)
+	}
+	bw.AddRowWithID("t", []string{"id", "s"}, []interface{}{int64(21), strings.Repeat("x", 1000)}, 21)
+	bw.Flush()
+	assert.Equal(t, 19, len(written))
+	for _, n := range written {
+		assert.Equal(t, 1, n)
+	}
+	assert.Equal(t, map[int64]error{7: fmt.Errorf("bad row"), 21: ErrRowTooLarge}, dropped)
+}
+
+func TestAbandon(t *testing.T) {
+	release := make(chan error)
+	var done []int64
+	bw := NewBatchWriter(BatchWriterConfig{
+		WriteLimit: 1,
+		BytesLimit: 100 << 20,
+		BatchBytes: 30, // Each row is 11 bytes.
+		RetryLimit: 1000,
+		Write: func(m []*sp.Mutation) error {
+			return <-release
+		},
+		OnDone: func(ids []int64, err error) { done = append(done, ids...) },
+	})
+	// The first two rows are written (and the write blocks); the rest
+	// are buffered.
+	for i := int64(1); i <= 4; i++ {
+		bw.AddRowWithID("t", []string{"id"}, []interface{}{i}, i)
+	}
+	assert.False(t, bw.Abandon(10*time.Millisecond))
+	assert.Equal(t, map[string]int64{"t": 2}, bw.AbandonedRowsByTable())
+	// The write in progress fails (e.g. it is canceled), and isn't
+	// retried: its rows are abandoned too.
+	release <- status.Error(codes.Canceled, "canceled")
+	bw.Flush()
+	assert.Equal(t, map[string]int64{"t": 4}, bw.AbandonedRowsByTable())
+	assert.Equal(t, map[string]int64{}, bw.DroppedRowsByTable())
+	assert.Nil(t, done)
+
+	// Writes that complete in time are written as usual.
+	bw = NewBatchWriter(BatchWriterConfig{
+		WriteLimit: 1,
+		BytesLimit: 100 << 20,
+		BatchBytes: 30,
+		RetryLimit: 1000,
+		Write:      func(m []*sp.Mutation) error { return nil },
+		OnDone:     func(ids []int64, err error) { done = append(done, ids...) },
+	})
+	for i := int64(1); i <= 3; i++ {
+		bw.AddRowWithID("t", []string{"id"}, []interface{}{i}, i)
+	}
+	assert.True(t, bw.Abandon(time.Second))
+	assert.Equal(t, []int64{1, 2}, done)
+	assert.Equal(t, map[string]int64{"t": 1}, bw.AbandonedRowsByTable())
+}
+
+func TestValueSize(t *testing.T) {
+	assert.Equal(t, int64(5), valueSize("hello"))
+	assert.Equal(t, int64(8), valueSize([]byte("hello")))
+	assert.Equal(t, int64(8), valueSize(int64(42)))
+	assert.Equal(t, int64(7), valueSize([]sp.NullString{{StringVal: "abc", Valid: true}, {StringVal: "defg", Valid: true}}))
+	assert.Equal(t, int64(24), valueSize([]sp.NullInt64{{}, {}, {}}))
+}
+
 func TestDroppedRowsByTable(t *testing.T) {
 	bw := NewBatchWriter(BatchWriterConfig{})
 	bw.async.lock.Lock()
@@ -109,8 +309,8 @@ func TestSampleBadRows(t *testing.T) {
 	bw := NewBatchWriter(BatchWriterConfig{})
 	bw.async.lock.Lock()
 	bw.async.sampleBadRows = []*row{
-		&row{"test", []string{"col1", "col2"}, []interface{}{"a", int64(42)}},
-		&row{"test", []string{"col1", "col2"}, []interface{}{"b", int64(6)}},
+		&row{"test", []string{"col1", "col2"}, []interface{}{"a", int64(42)}, 0},
+		&row{"test", []string{"col1", "col2"}, []interface{}{"b", int64(6)}, 0},
 	}
 	bw.async.lock.Unlock()
 	l := bw.SampleBadRows(1)
@@ -129,6 +329,59 @@ func TestErrors(t *testing.T) {
 	assert.Equal(t, int64(42), m["error string 2"])
 }
 
+// testLogger records the messages logged to it.
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) Debugf(format string, a ...interface{}) {
+	l.add("DEBUG " + fmt.Sprintf(format, a...))
+}
+
+func (l *testLogger) Warnf(format string, a ...interface{}) {
+	l.add("WARN " + fmt.Sprintf(format, a...))
+}
+
+func (l *testLogger) add(s string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, s)
+}
+
+func TestLog(t *testing.T) {
+	data, _ := generateRows(3, 5)
+	bad := toMutations(data[1:2])
+	l := &testLogger{}
+	bw := NewBatchWriter(BatchWriterConfig{
+		WriteLimit:    1,
+		BytesLimit:    100 << 20,
+		RowBytesLimit: 100,
+		RetryLimit:    1000,
+		Log:           l,
+		Write: func(m []*sp.Mutation) error {
+			if intersect(m, bad) {
+				return errors.New("bad data")
+			}
+			return nil
+		},
+	})
+	for _, x := range data {
+		bw.AddRow(x.table, x.cols, x.vals)
+	}
+	bw.AddRow("big", []string{"a"}, []interface{}{strings.Repeat("x", 200)})
+	bw.Flush()
+	sort.Strings(l.messages)
+	assert.Equal(t, []string{
+		"DEBUG Error while writing 3 rows to Spanner (retrying in smaller batches): bad data",
+		"DEBUG Starting write of 3 rows to Spanner (60 bytes, 6 mutations) [0 in progress]",
+		"DEBUG Wrote 1 rows to Spanner",
+		"DEBUG Wrote 1 rows to Spanner",
+		"WARN Dropping row of table big: size (204 bytes) exceeds Spanner's commit size limit",
+		"WARN Error while writing 1 rows of table table to Spanner (dropping them): bad data",
+	}, l.messages)
+}
+
 func ExampleBatchWriter() {
 	write := func(m []*sp.Mutation) error {
 		var err error
@@ -142,7 +395,6 @@ func ExampleBatchWriter() {
 		BytesLimit: 100 * 1 << 20, // Limit on bytes buffered; 100MB is a good default.
 		RetryLimit: 1000,          // Limit on retries (if a large set of mutations fails, we split it into smaller pieces and re-try).
 		Write:      write,
-		Verbose:    false, // Whether to print messages about each write.
 	}
 	writer := NewBatchWriter(config)
 
@@ -164,7 +416,7 @@ func generateRows(count int, size int) ([]*row, int) {
 	for i := 0; i < count; i++ {
 		// vals[0] serves as a unique id for each row.
 		vals := []interface{}{i, val}
-		r = append(r, &row{"table", cols, vals})
+		r = append(r, &row{"table", cols, vals, 0})
 	}
 	// Find the max number of rows in a write for the (fixed sized)
 	// rows generated in this test data.
@@ -41,6 +41,7 @@ package ddl
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -68,7 +69,7 @@ func (m MaxLength) PrintLength() string { return fmt.Sprintf("MAX") }
 
 // ScalarType encodes the following DDL definition:
 //     scalar_type:
-//        { BOOL | INT64 | FLOAT64 | STRING( length ) | BYTES( length ) | DATE | TIMESTAMP }
+//        { BOOL | INT64 | FLOAT64 | NUMERIC | STRING( length ) | BYTES( length ) | DATE | TIMESTAMP }
 type ScalarType interface {
 	PrintScalarType() string
 }
@@ -88,6 +89,9 @@ type Float64 struct{}
 // Int64 encodes DDL INT64.
 type Int64 struct{}
 
+// Numeric encodes DDL NUMERIC.
+type Numeric struct{}
+
 // String encodes DDL STRING.
 type String struct{ Len Length }
 
@@ -95,7 +99,7 @@ type String struct{ Len Length }
 type Timestamp struct{}
 
 // Interface validation for ScalarTypes
-var _ = []ScalarType{Bool{}, Bytes{}, Date{}, Float64{}, Int64{}, String{}}
+var _ = []ScalarType{Bool{}, Bytes{}, Date{}, Float64{}, Int64{}, Numeric{}, String{}}
 
 // PrintScalarType unparses Bool.
 func (b Bool) PrintScalarType() string { return "BOOL" }
@@ -112,6 +116,9 @@ func (g Float64) PrintScalarType() string { return "FLOAT64" }
 // PrintScalarType unparses Int64
 func (i Int64) PrintScalarType() string { return "INT64" }
 
+// PrintScalarType unparses Numeric
+func (n Numeric) PrintScalarType() string { return "NUMERIC" }
+
 // PrintScalarType unparses String
 func (s String) PrintScalarType() string { return fmt.Sprintf("STRING(%s)", s.Len.PrintLength()) }
 
@@ -127,33 +134,141 @@ type ColumnDef struct {
 	IsArray bool // When false, this column has type T; when true, it is an array of type T.
 	NotNull bool
 	Comment string
+	// Options is the column's options_def: option values (as they
+	// appear in DDL e.g. "true") keyed by option name (see
+	// AllowCommitTimestamp). Nil if the column has no options.
+	Options map[string]string
+}
+
+// AllowCommitTimestamp is the name of the column option that allows
+// writes of the commit timestamp to a TIMESTAMP column.
+//     options_def:
+//       OPTIONS ( allow_commit_timestamp = { true | null } )
+const AllowCommitTimestamp = "allow_commit_timestamp"
+
+// SetOption sets the column option name to value.
+func (cd *ColumnDef) SetOption(name, value string) {
+	if cd.Options == nil {
+		cd.Options = make(map[string]string)
+	}
+	cd.Options[name] = value
+}
+
+// AllowsCommitTimestamp returns whether the column has the option
+// allow_commit_timestamp = true.
+func (cd ColumnDef) AllowsCommitTimestamp() bool {
+	return cd.Options[AllowCommitTimestamp] == "true"
 }
 
+// Dialect is the SQL dialect of a Spanner database, which determines
+// the syntax of its DDL.
+type Dialect string
+
+// Spanner database dialects.
+const (
+	GoogleStandardSQL Dialect = "google_standard_sql"
+	PostgreSQL        Dialect = "postgresql"
+)
+
 // Config controls how AST nodes are printed (aka unparsed).
 type Config struct {
-	Comments   bool // If true, print comments.
-	ProtectIds bool // If true, table and col names are quoted using backticks (avoids reserved-word issue).
+	Comments   bool    // If true, print comments.
+	ProtectIds bool    // If true, table and col names are quoted using backticks (double quotes for PostgreSQL) to avoid reserved-word issues.
+	Dialect    Dialect // Dialect of the DDL (empty for GoogleStandardSQL).
 }
 
 func (c Config) quote(s string) string {
-	if c.ProtectIds {
-		return "`" + s + "`"
+	switch {
+	case !c.ProtectIds:
+		return s
+	case c.Dialect == PostgreSQL:
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
 	}
-	return s
+	return "`" + s + "`"
 }
 
 // PrintColumnDef unparses ColumnDef and returns it as well as any ColumnDef
 // comment. These are returned as separate strings to support formatting
-// needs of PrintCreateTable.
+// needs of PrintCreateTable. Unlike PrintColumnType and
+// PrintColumnDefType, which give just the type, the result includes
+// NOT NULL and the column's options.
 func (cd ColumnDef) PrintColumnDef(c Config) (string, string) {
-	s := fmt.Sprintf("%s %s", c.quote(cd.Name), cd.PrintColumnDefType())
+	t := cd.PrintColumnType(c)
+	if cd.AllowsCommitTimestamp() && c.Dialect == PostgreSQL {
+		// PostgreSQL has a type for commit timestamps, rather than an
+		// option.
+		t = "spanner.commit_timestamp"
+	}
+	s := fmt.Sprintf("%s %s", c.quote(cd.Name), t)
 	if cd.NotNull {
 		s += " NOT NULL"
 	}
+	if c.Dialect != PostgreSQL {
+		s += cd.printOptions()
+	}
 	return s, cd.Comment
 }
 
-// PrintColumnDefType unparses the type encoded in a ColumnDef.
+// printOptions unparses the column's options_def (empty if it has no
+// options), with options in name order so that DDL is deterministic.
+func (cd ColumnDef) printOptions() string {
+	if len(cd.Options) == 0 {
+		return ""
+	}
+	var names []string
+	for k := range cd.Options {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var l []string
+	for _, k := range names {
+		l = append(l, fmt.Sprintf("%s = %s", k, cd.Options[k]))
+	}
+	return fmt.Sprintf(" OPTIONS (%s)", strings.Join(l, ", "))
+}
+
+// PrintColumnType unparses the type encoded in a ColumnDef in the
+// dialect of c e.g. ARRAY<INT64> or bigint[] (for PostgreSQL).
+func (cd ColumnDef) PrintColumnType(c Config) string {
+	if c.Dialect != PostgreSQL {
+		return cd.PrintColumnDefType()
+	}
+	t := pgScalarType(cd.T)
+	if cd.IsArray {
+		return t + "[]"
+	}
+	return t
+}
+
+// pgScalarType unparses a scalar type in the PostgreSQL dialect.
+func pgScalarType(t ScalarType) string {
+	switch t := t.(type) {
+	case Bool:
+		return "bool"
+	case Bytes:
+		return "bytea"
+	case Date:
+		return "date"
+	case Float64:
+		return "float8"
+	case Int64:
+		return "bigint"
+	case Numeric:
+		return "numeric"
+	case String:
+		if n, ok := t.Len.(Int64Length); ok {
+			return fmt.Sprintf("varchar(%d)", n.Value)
+		}
+		return "text"
+	case Timestamp:
+		return "timestamptz"
+	}
+	return t.PrintScalarType()
+}
+
+// PrintColumnDefType unparses the type encoded in a ColumnDef e.g.
+// ARRAY<INT64>. It doesn't include NOT NULL or options (see
+// PrintColumnDef).
 func (cd ColumnDef) PrintColumnDefType() string {
 	t := cd.T.PrintScalarType()
 	if cd.IsArray {
@@ -182,14 +297,64 @@ func (pk IndexKey) PrintIndexKey(c Config) string {
 	return col
 }
 
+// RowDeletionPolicy encodes the following DDL definition:
+//     row_deletion_policy:
+//       ROW DELETION POLICY ( OLDER_THAN ( timestamp_column, INTERVAL num_days DAY ) )
+type RowDeletionPolicy struct {
+	Col  string // Must be a TIMESTAMP column.
+	Days int64
+}
+
+// PrintRowDeletionPolicy unparses a row deletion policy.
+func (rdp RowDeletionPolicy) PrintRowDeletionPolicy(c Config) string {
+	if c.Dialect == PostgreSQL {
+		return fmt.Sprintf("TTL INTERVAL '%d days' ON %s", rdp.Days, c.quote(rdp.Col))
+	}
+	return fmt.Sprintf("ROW DELETION POLICY (OLDER_THAN(%s, INTERVAL %d DAY))", c.quote(rdp.Col), rdp.Days)
+}
+
+// ForeignKey encodes the following DDL definition:
+//     foreign_key:
+//       [ CONSTRAINT constraint_name ] FOREIGN KEY ( column_name [, ... ] ) REFERENCES ref_table ( ref_column [, ... ] ) [ ON DELETE { CASCADE | NO ACTION } ]
+type ForeignKey struct {
+	Name         string // Empty means Spanner chooses the constraint name.
+	Columns      []string
+	ReferTable   string
+	ReferColumns []string
+	OnDelete     string // "CASCADE" or "NO ACTION". Empty means NO ACTION (the default).
+}
+
+// PrintForeignKey unparses a foreign key.
+func (k ForeignKey) PrintForeignKey(c Config) string {
+	var cols, referCols []string
+	for _, col := range k.Columns {
+		cols = append(cols, c.quote(col))
+	}
+	for _, col := range k.ReferColumns {
+		referCols = append(referCols, c.quote(col))
+	}
+	var s string
+	if k.Name != "" {
+		s = fmt.Sprintf("CONSTRAINT %s ", c.quote(k.Name))
+	}
+	s += fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)", strings.Join(cols, ", "), c.quote(k.ReferTable), strings.Join(referCols, ", "))
+	if k.OnDelete == "CASCADE" {
+		s += " ON DELETE CASCADE"
+	}
+	return s
+}
+
 // CreateTable encodes the following DDL definition:
-//     create_table: CREATE TABLE table_name ([column_def, ...] ) primary_key [, cluster]
+//     create_table: CREATE TABLE table_name ([column_def, ...] ) primary_key [, cluster] [, row_deletion_policy]
 type CreateTable struct {
-	Name     string
-	ColNames []string             // Provides names and order of columns
-	ColDefs  map[string]ColumnDef // Provides definition of columns (a map for simpler/faster lookup during type processing)
-	Pks      []IndexKey
-	Comment  string
+	Name              string
+	ColNames          []string             // Provides names and order of columns
+	ColDefs           map[string]ColumnDef // Provides definition of columns (a map for simpler/faster lookup during type processing)
+	Pks               []IndexKey
+	ForeignKeys       []ForeignKey  // Printed as separate ALTER TABLE statements (see PrintForeignKeys).
+	Indexes           []CreateIndex // Secondary indexes on the table, printed as separate statements.
+	Comment           string
+	RowDeletionPolicy *RowDeletionPolicy // Nil if the table has no row deletion policy.
 }
 
 // PrintCreateTable unparses a CREATE TABLE statement.
@@ -200,7 +365,8 @@ func (ct CreateTable) PrintCreateTable(config Config) string {
 	for i, cn := range ct.ColNames {
 		s, c := ct.ColDefs[cn].PrintColumnDef(config)
 		s = "\n    " + s
-		if i < len(ct.ColNames)-1 {
+		// For PostgreSQL, the primary key follows the columns.
+		if i < len(ct.ColNames)-1 || config.Dialect == PostgreSQL {
 			s += ","
 		} else {
 			s += " "
@@ -221,19 +387,104 @@ func (ct CreateTable) PrintCreateTable(config Config) string {
 	}
 	var tableComment string
 	if config.Comments && len(ct.Comment) > 0 {
-		tableComment = "--\n-- " + ct.Comment + "\n--\n"
+		// Comments can have several lines (e.g. source comments).
+		tableComment = "--\n"
+		for _, l := range strings.Split(ct.Comment, "\n") {
+			tableComment += strings.TrimRight("-- "+l, " ") + "\n"
+		}
+		tableComment += "--\n"
+	}
+	if config.Dialect == PostgreSQL {
+		var rdp string
+		if ct.RowDeletionPolicy != nil {
+			rdp = " " + ct.RowDeletionPolicy.PrintRowDeletionPolicy(config)
+		}
+		return fmt.Sprintf("%sCREATE TABLE %s (%s\n    PRIMARY KEY (%s)\n)%s", tableComment, config.quote(ct.Name), cols, strings.Join(keys, ", "), rdp)
 	}
-	return fmt.Sprintf("%sCREATE TABLE %s (%s\n) PRIMARY KEY (%s)", tableComment, config.quote(ct.Name), cols, strings.Join(keys, ", "))
+	var rdp string
+	if ct.RowDeletionPolicy != nil {
+		rdp = ",\n" + ct.RowDeletionPolicy.PrintRowDeletionPolicy(config)
+	}
+	return fmt.Sprintf("%sCREATE TABLE %s (%s\n) PRIMARY KEY (%s)%s", tableComment, config.quote(ct.Name), cols, strings.Join(keys, ", "), rdp)
+}
+
+// PrintForeignKeys unparses the foreign keys of a table as a list of
+// ALTER TABLE statements. Foreign keys are added after all tables have
+// been created, since they can refer to tables (including the table
+// itself) that would otherwise not exist yet:
+//     ALTER TABLE table_name ADD foreign_key
+func (ct CreateTable) PrintForeignKeys(c Config) []string {
+	var l []string
+	for _, fk := range ct.ForeignKeys {
+		l = append(l, fmt.Sprintf("ALTER TABLE %s ADD %s", c.quote(ct.Name), fk.PrintForeignKey(c)))
+	}
+	return l
 }
 
 // CreateIndex encodes the following DDL definition:
 //     create index: CREATE [UNIQUE] [NULL_FILTERED] INDEX index_name ON table_name ( key_part [, ...] ) [ storing_clause ] [ , interleave_clause ]
+//     storing_clause: STORING ( column_name [, ...] )
+//     interleave_clause: INTERLEAVE IN table_name
 type CreateIndex struct {
-	Name  string
-	Table string
-	Keys  []IndexKey
-	// We have no requirements for unique and null-filtered options and
-	// storing/interleaving clauses yet, so we omit them for now.
+	Name         string
+	Table        string
+	Keys         []IndexKey
+	Unique       bool
+	NullFiltered bool     // Rows with a NULL value in any key column are not indexed.
+	Storing      []string // Non-key columns whose values are stored in the index.
+	Interleave   string   // Ancestor table of Table that the index is interleaved in (empty if none).
+}
+
+// MaxNameLength is the maximum length of Spanner table, column, index
+// and constraint names.
+const MaxNameLength = 128
+
+// CheckName checks that name is a legal length for the name of a
+// Spanner object of the given kind (e.g. "index").
+func CheckName(kind, name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%s has no name", kind)
+	case len(name) > MaxNameLength:
+		return fmt.Errorf("%s name %s is longer than %d characters", kind, name, MaxNameLength)
+	}
+	return nil
+}
+
+// Validate checks that ci is a legal index on table ct: its name has a
+// legal length, it has key columns, and its key and stored columns are
+// columns of ct. Spanner always stores the key columns of the index and
+// of ct, so these can't also be stored columns. Interleave isn't
+// checked, since CreateTable doesn't describe table interleaving.
+func (ci CreateIndex) Validate(ct CreateTable) error {
+	if err := CheckName("index", ci.Name); err != nil {
+		return err
+	}
+	if ci.Table != ct.Name {
+		return fmt.Errorf("index %s is on table %s, not %s", ci.Name, ci.Table, ct.Name)
+	}
+	if len(ci.Keys) == 0 {
+		return fmt.Errorf("index %s has no key columns", ci.Name)
+	}
+	keys := make(map[string]bool)
+	for _, k := range ct.Pks {
+		keys[k.Col] = true
+	}
+	for _, k := range ci.Keys {
+		if _, ok := ct.ColDefs[k.Col]; !ok {
+			return fmt.Errorf("index %s uses unknown column %s", ci.Name, k.Col)
+		}
+		keys[k.Col] = true
+	}
+	for _, col := range ci.Storing {
+		if _, ok := ct.ColDefs[col]; !ok {
+			return fmt.Errorf("index %s stores unknown column %s", ci.Name, col)
+		}
+		if keys[col] {
+			return fmt.Errorf("index %s stores key column %s", ci.Name, col)
+		}
+	}
+	return nil
 }
 
 // PrintCreateIndex unparses a CREATE INDEX statement.
@@ -242,7 +493,41 @@ func (ci CreateIndex) PrintCreateIndex(c Config) string {
 	for _, p := range ci.Keys {
 		keys = append(keys, p.PrintIndexKey(c))
 	}
-	return fmt.Sprintf("CREATE INDEX %s ON %s (%s)", c.quote(ci.Name), c.quote(ci.Table), strings.Join(keys, ", "))
+	s := "CREATE "
+	if ci.Unique {
+		s += "UNIQUE "
+	}
+	pg := c.Dialect == PostgreSQL
+	if ci.NullFiltered && !pg {
+		s += "NULL_FILTERED "
+	}
+	s += fmt.Sprintf("INDEX %s ON %s (%s)", c.quote(ci.Name), c.quote(ci.Table), strings.Join(keys, ", "))
+	if len(ci.Storing) > 0 {
+		var cols []string
+		for _, col := range ci.Storing {
+			cols = append(cols, c.quote(col))
+		}
+		clause := "STORING"
+		if pg {
+			clause = "INCLUDE"
+		}
+		s += fmt.Sprintf(" %s (%s)", clause, strings.Join(cols, ", "))
+	}
+	if ci.Interleave != "" {
+		if !pg {
+			s += ","
+		}
+		s += " INTERLEAVE IN " + c.quote(ci.Interleave)
+	}
+	if ci.NullFiltered && pg {
+		// PostgreSQL filters NULLs with a predicate on the key columns.
+		var l []string
+		for _, k := range ci.Keys {
+			l = append(l, c.quote(k.Col)+" IS NOT NULL")
+		}
+		s += " WHERE " + strings.Join(l, " AND ")
+	}
+	return s
 }
 
 func maxStringLength(s []string) int {
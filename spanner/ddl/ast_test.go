@@ -52,6 +52,9 @@ func TestPrintColumnDef(t *testing.T) {
 		{in: ColumnDef{Name: "col1", T: Int64{}, NotNull: true}, expected: "col1 INT64 NOT NULL"},
 		{in: ColumnDef{Name: "col1", T: Int64{}, IsArray: true, NotNull: true}, expected: "col1 ARRAY<INT64> NOT NULL"},
 		{in: ColumnDef{Name: "col1", T: Int64{}}, protectIds: true, expected: "`col1` INT64"},
+		{in: ColumnDef{Name: "col1", T: Timestamp{}, Options: map[string]string{AllowCommitTimestamp: "true"}}, expected: "col1 TIMESTAMP OPTIONS (allow_commit_timestamp = true)"},
+		{in: ColumnDef{Name: "col1", T: Timestamp{}, NotNull: true, Options: map[string]string{AllowCommitTimestamp: "true"}}, expected: "col1 TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp = true)"},
+		{in: ColumnDef{Name: "col1", T: Timestamp{}, Options: map[string]string{"z": "1", AllowCommitTimestamp: "null"}}, expected: "col1 TIMESTAMP OPTIONS (allow_commit_timestamp = null, z = 1)"},
 	}
 	for _, tc := range tests {
 		s, _ := tc.in.PrintColumnDef(Config{ProtectIds: tc.protectIds})
@@ -59,6 +62,17 @@ func TestPrintColumnDef(t *testing.T) {
 	}
 }
 
+func TestColumnOptions(t *testing.T) {
+	cd := ColumnDef{Name: "ts", T: Timestamp{}, NotNull: true}
+	assert.False(t, cd.AllowsCommitTimestamp())
+	cd.SetOption(AllowCommitTimestamp, "true")
+	assert.True(t, cd.AllowsCommitTimestamp())
+	// The type alone doesn't include NOT NULL or options.
+	assert.Equal(t, "TIMESTAMP", cd.PrintColumnDefType())
+	s, _ := cd.PrintColumnDef(Config{})
+	assert.Equal(t, "ts TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp = true)", s)
+}
+
 func TestPrintIndexKey(t *testing.T) {
 	tests := []struct {
 		in         IndexKey
@@ -84,7 +98,10 @@ func TestPrintCreateTable(t *testing.T) {
 		[]string{"col1", "col2", "col3"},
 		cds,
 		[]IndexKey{IndexKey{Col: "col1", Desc: true}},
+		nil,
+		nil,
 		"",
+		nil,
 	}
 	tests := []struct {
 		name       string
@@ -100,22 +117,181 @@ func TestPrintCreateTable(t *testing.T) {
 }
 
 func TestPrintCreateIndex(t *testing.T) {
-	ci := CreateIndex{
-		"myindex",
-		"mytable",
-		[]IndexKey{IndexKey{Col: "col1", Desc: true}, IndexKey{Col: "col2"}},
+	ci := []CreateIndex{
+		CreateIndex{
+			Name:  "myindex",
+			Table: "mytable",
+			Keys:  []IndexKey{IndexKey{Col: "col1", Desc: true}, IndexKey{Col: "col2"}},
+		},
+		CreateIndex{
+			Name:    "myindex2",
+			Table:   "mytable",
+			Keys:    []IndexKey{IndexKey{Col: "col2", Desc: true}},
+			Unique:  true,
+			Storing: []string{"col3", "col4"},
+		},
+		CreateIndex{
+			Name:         "myindex3",
+			Table:        "mytable",
+			Keys:         []IndexKey{IndexKey{Col: "col3"}},
+			Unique:       true,
+			NullFiltered: true,
+		},
+		CreateIndex{
+			Name:       "myindex4",
+			Table:      "mytable",
+			Keys:       []IndexKey{IndexKey{Col: "col1"}, IndexKey{Col: "col2", Desc: true}},
+			Unique:     true,
+			Storing:    []string{"col3"},
+			Interleave: "parent",
+		},
 	}
 	tests := []struct {
 		name       string
 		protectIds bool
+		index      CreateIndex
 		expected   string
 	}{
-		{"no quote", false, "CREATE INDEX myindex ON mytable (col1 DESC, col2)"},
-		{"quote", true, "CREATE INDEX `myindex` ON `mytable` (`col1` DESC, `col2`)"},
+		{"no quote", false, ci[0], "CREATE INDEX myindex ON mytable (col1 DESC, col2)"},
+		{"quote", true, ci[0], "CREATE INDEX `myindex` ON `mytable` (`col1` DESC, `col2`)"},
+		{"unique storing", false, ci[1], "CREATE UNIQUE INDEX myindex2 ON mytable (col2 DESC) STORING (col3, col4)"},
+		{"unique storing quote", true, ci[1], "CREATE UNIQUE INDEX `myindex2` ON `mytable` (`col2` DESC) STORING (`col3`, `col4`)"},
+		{"unique null filtered", false, ci[2], "CREATE UNIQUE NULL_FILTERED INDEX myindex3 ON mytable (col3)"},
+		{"unique storing desc interleaved", false, ci[3], "CREATE UNIQUE INDEX myindex4 ON mytable (col1, col2 DESC) STORING (col3), INTERLEAVE IN parent"},
+		{"unique storing desc interleaved quote", true, ci[3], "CREATE UNIQUE INDEX `myindex4` ON `mytable` (`col1`, `col2` DESC) STORING (`col3`), INTERLEAVE IN `parent`"},
 	}
 	for _, tc := range tests {
-		assert.Equal(t, normalizeSpace(tc.expected), normalizeSpace(ci.PrintCreateIndex(Config{ProtectIds: tc.protectIds})))
+		assert.Equal(t, normalizeSpace(tc.expected), normalizeSpace(tc.index.PrintCreateIndex(Config{ProtectIds: tc.protectIds})), tc.name)
+	}
+}
+
+func TestPrintCreateTableRowDeletionPolicy(t *testing.T) {
+	cds := make(map[string]ColumnDef)
+	cds["col1"] = ColumnDef{Name: "col1", T: Int64{}, NotNull: true}
+	cds["expires"] = ColumnDef{Name: "expires", T: Timestamp{}}
+	ct := CreateTable{
+		Name:              "mytable",
+		ColNames:          []string{"col1", "expires"},
+		ColDefs:           cds,
+		Pks:               []IndexKey{IndexKey{Col: "col1"}},
+		RowDeletionPolicy: &RowDeletionPolicy{Col: "expires", Days: 30},
+	}
+	tests := []struct {
+		name       string
+		protectIds bool
+		expected   string
+	}{
+		{"no quote", false, "CREATE TABLE mytable (col1 INT64 NOT NULL, expires TIMESTAMP) PRIMARY KEY (col1), ROW DELETION POLICY (OLDER_THAN(expires, INTERVAL 30 DAY))"},
+		{"quote", true, "CREATE TABLE `mytable` (`col1` INT64 NOT NULL, `expires` TIMESTAMP) PRIMARY KEY (`col1`), ROW DELETION POLICY (OLDER_THAN(`expires`, INTERVAL 30 DAY))"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, normalizeSpace(tc.expected), normalizeSpace(ct.PrintCreateTable(Config{ProtectIds: tc.protectIds})), tc.name)
+	}
+}
+
+func TestPrintForeignKeys(t *testing.T) {
+	ct := CreateTable{
+		Name: "mytable",
+		ForeignKeys: []ForeignKey{
+			{Name: "fk1", Columns: []string{"col1", "col2"}, ReferTable: "other", ReferColumns: []string{"a", "b"}},
+			{Columns: []string{"col3"}, ReferTable: "mytable", ReferColumns: []string{"col1"}, OnDelete: "CASCADE"},
+			{Columns: []string{"col4"}, ReferTable: "other", ReferColumns: []string{"c"}, OnDelete: "NO ACTION"},
+		},
+	}
+	tests := []struct {
+		name       string
+		protectIds bool
+		expected   []string
+	}{
+		{"no quote", false, []string{
+			"ALTER TABLE mytable ADD CONSTRAINT fk1 FOREIGN KEY (col1, col2) REFERENCES other (a, b)",
+			"ALTER TABLE mytable ADD FOREIGN KEY (col3) REFERENCES mytable (col1) ON DELETE CASCADE",
+			"ALTER TABLE mytable ADD FOREIGN KEY (col4) REFERENCES other (c)",
+		}},
+		{"quote", true, []string{
+			"ALTER TABLE `mytable` ADD CONSTRAINT `fk1` FOREIGN KEY (`col1`, `col2`) REFERENCES `other` (`a`, `b`)",
+			"ALTER TABLE `mytable` ADD FOREIGN KEY (`col3`) REFERENCES `mytable` (`col1`) ON DELETE CASCADE",
+			"ALTER TABLE `mytable` ADD FOREIGN KEY (`col4`) REFERENCES `other` (`c`)",
+		}},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, ct.PrintForeignKeys(Config{ProtectIds: tc.protectIds}), tc.name)
+	}
+	assert.Nil(t, CreateTable{Name: "t"}.PrintForeignKeys(Config{}))
+}
+
+func TestPrintPostgreSQL(t *testing.T) {
+	pg := Config{ProtectIds: true, Dialect: PostgreSQL}
+	for _, tc := range []struct {
+		cd       ColumnDef
+		expected string
+	}{
+		{ColumnDef{Name: "b", T: Bool{}}, `"b" bool`},
+		{ColumnDef{Name: "by", T: Bytes{MaxLength{}}}, `"by" bytea`},
+		{ColumnDef{Name: "d", T: Date{}}, `"d" date`},
+		{ColumnDef{Name: "f", T: Float64{}}, `"f" float8`},
+		{ColumnDef{Name: "i", T: Int64{}, NotNull: true}, `"i" bigint NOT NULL`},
+		{ColumnDef{Name: "n", T: Numeric{}}, `"n" numeric`},
+		{ColumnDef{Name: "s", T: String{MaxLength{}}}, `"s" text`},
+		{ColumnDef{Name: "s6", T: String{Int64Length{6}}}, `"s6" varchar(6)`},
+		{ColumnDef{Name: "ts", T: Timestamp{}}, `"ts" timestamptz`},
+		{ColumnDef{Name: "a", T: Int64{}, IsArray: true}, `"a" bigint[]`},
+		{ColumnDef{Name: "ct", T: Timestamp{}, NotNull: true, Options: map[string]string{AllowCommitTimestamp: "true"}}, `"ct" spanner.commit_timestamp NOT NULL`},
+		{ColumnDef{Name: `x"y`, T: Int64{}}, `"x""y" bigint`},
+	} {
+		s, _ := tc.cd.PrintColumnDef(pg)
+		assert.Equal(t, tc.expected, s)
+	}
+	ct := CreateTable{
+		Name:              "mytable",
+		ColNames:          []string{"col1", "expires"},
+		ColDefs:           map[string]ColumnDef{"col1": {Name: "col1", T: Int64{}, NotNull: true, Comment: "From: col1 int8"}, "expires": {Name: "expires", T: Timestamp{}}},
+		Pks:               []IndexKey{{Col: "col1"}},
+		RowDeletionPolicy: &RowDeletionPolicy{Col: "expires", Days: 30},
+	}
+	assert.Equal(t, "CREATE TABLE mytable (\n"+
+		"    col1 bigint NOT NULL, -- From: col1 int8\n"+
+		"    expires timestamptz,\n"+
+		"    PRIMARY KEY (col1)\n"+
+		") TTL INTERVAL '30 days' ON expires", ct.PrintCreateTable(Config{Comments: true, Dialect: PostgreSQL}))
+	ci := CreateIndex{Name: "myindex", Table: "mytable", Keys: []IndexKey{{Col: "a", Desc: true}, {Col: "b"}}, NullFiltered: true, Storing: []string{"c"}}
+	assert.Equal(t, `CREATE INDEX "myindex" ON "mytable" ("a" DESC, "b") INCLUDE ("c") WHERE "a" IS NOT NULL AND "b" IS NOT NULL`, ci.PrintCreateIndex(pg))
+	// GoogleSQL is the default.
+	assert.Equal(t, "CREATE NULL_FILTERED INDEX myindex ON mytable (a DESC, b) STORING (c)", ci.PrintCreateIndex(Config{Dialect: GoogleStandardSQL}))
+	ci.Interleave = "parent"
+	assert.Equal(t, `CREATE INDEX "myindex" ON "mytable" ("a" DESC, "b") INCLUDE ("c") INTERLEAVE IN "parent" WHERE "a" IS NOT NULL AND "b" IS NOT NULL`, ci.PrintCreateIndex(pg))
+}
+
+func TestCreateIndexValidate(t *testing.T) {
+	ct := CreateTable{
+		Name:     "t",
+		ColNames: []string{"id", "a", "b"},
+		ColDefs: map[string]ColumnDef{
+			"id": {Name: "id", T: Int64{}},
+			"a":  {Name: "a", T: Int64{}},
+			"b":  {Name: "b", T: String{MaxLength{}}},
+		},
+		Pks: []IndexKey{{Col: "id"}},
+	}
+	for _, tc := range []struct {
+		name string
+		ci   CreateIndex
+		err  bool
+	}{
+		{"ok", CreateIndex{Name: "i", Table: "t", Keys: []IndexKey{{Col: "a", Desc: true}}, Unique: true, Storing: []string{"b"}}, false},
+		{"no name", CreateIndex{Table: "t", Keys: []IndexKey{{Col: "a"}}}, true},
+		{"long name", CreateIndex{Name: strings.Repeat("i", MaxNameLength+1), Table: "t", Keys: []IndexKey{{Col: "a"}}}, true},
+		{"other table", CreateIndex{Name: "i", Table: "u", Keys: []IndexKey{{Col: "a"}}}, true},
+		{"no keys", CreateIndex{Name: "i", Table: "t"}, true},
+		{"unknown key", CreateIndex{Name: "i", Table: "t", Keys: []IndexKey{{Col: "x"}}}, true},
+		{"unknown stored", CreateIndex{Name: "i", Table: "t", Keys: []IndexKey{{Col: "a"}}, Storing: []string{"x"}}, true},
+		{"stored index key", CreateIndex{Name: "i", Table: "t", Keys: []IndexKey{{Col: "a"}}, Storing: []string{"a"}}, true},
+		{"stored primary key", CreateIndex{Name: "i", Table: "t", Keys: []IndexKey{{Col: "a"}}, Storing: []string{"id"}}, true},
+	} {
+		err := tc.ci.Validate(ct)
+		assert.Equal(t, tc.err, err != nil, tc.name)
 	}
+	assert.Nil(t, CheckName("index", strings.Repeat("i", MaxNameLength)))
 }
 
 func normalizeSpace(s string) string {